@@ -0,0 +1,55 @@
+// This file holds the options a torrent is added to a Client with. They set what would
+// otherwise come from the session for that torrent alone: the directory it is saved under,
+// the labels it starts with, the storage backend it is kept in and the dialer its peers are
+// connected to with
+package bittorrentclient
+
+// AddOption configures one torrent as it is added, see Client.AddTorrent
+type AddOption func(*addConfig)
+
+type addConfig struct {
+	// dir is empty for the client's directory
+	dir    string
+	labels []string
+	// storage is nil for FilesystemStorage
+	storage Storage
+	// dialer is nil for the client's
+	dialer *PeerDialer
+}
+
+func newAddConfig(opts []AddOption) addConfig {
+	var cfg addConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithSaveDir saves the torrent under dir instead of the client's directory
+func WithSaveDir(dir string) AddOption {
+	return func(cfg *addConfig) {
+		cfg.dir = dir
+	}
+}
+
+// WithLabels gives the torrent labels, see Download.SetLabels
+func WithLabels(labels ...string) AddOption {
+	return func(cfg *addConfig) {
+		cfg.labels = append(cfg.labels, labels...)
+	}
+}
+
+// WithStorage keeps the torrent in s instead of files on disk, see Download.SetStorage
+func WithStorage(s Storage) AddOption {
+	return func(cfg *addConfig) {
+		cfg.storage = s
+	}
+}
+
+// WithDialer connects to the torrent's peers with dialer instead of the client's, e.g. to
+// put it alone behind a proxy. See Download.SetDialer
+func WithDialer(dialer *PeerDialer) AddOption {
+	return func(cfg *addConfig) {
+		cfg.dialer = dialer
+	}
+}
@@ -0,0 +1,74 @@
+// This file implements the ways a file's space can be reserved before data is written to
+// it. Sparse files cost nothing up front but pieces arriving in random order can leave them
+// badly fragmented on some filesystems, full allocation reserves every block once so the
+// file stays contiguous and a full disk or quota shows up right away instead of halfway
+// through the download
+package bittorrentclient
+
+import "os"
+
+type AllocationMode int
+
+const (
+	// AllocateSparse sets each file to its full size without reserving any blocks
+	AllocateSparse AllocationMode = iota
+	// AllocateFull reserves every block of each file, with fallocate where the system has it
+	AllocateFull
+	// AllocateNone leaves files to grow as data is written to them
+	AllocateNone
+)
+
+func (m AllocationMode) String() string {
+	switch m {
+	case AllocateSparse:
+		return "sparse"
+	case AllocateFull:
+		return "full"
+	case AllocateNone:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+// zeros are written in chunks of this size where blocks can't be reserved any other way
+const allocateChunk = 1 << 20
+
+// this function prepares f to hold size bytes according to mode. files are only ever
+// grown, data already in them is left alone
+func allocateFile(f *os.File, size int64, mode AllocationMode) error {
+	if mode == AllocateNone || size == 0 {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if mode == AllocateSparse {
+		if info.Size() >= size {
+			return nil
+		}
+		return f.Truncate(size)
+	}
+	ok, err := fallocate(f, size)
+	if ok || err != nil {
+		return err
+	}
+	return writeZeros(f, info.Size(), size)
+}
+
+// this function fills f with zeros from off up to size
+func writeZeros(f *os.File, off, size int64) error {
+	if off >= size {
+		return nil
+	}
+	zeros := make([]byte, min(allocateChunk, size-off))
+	for off < size {
+		n, err := f.WriteAt(zeros[:min(int64(len(zeros)), size-off)], off)
+		off += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
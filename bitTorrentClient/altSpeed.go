@@ -0,0 +1,254 @@
+// This file implements the alternative speed limits, a second pair of session limits that
+// can be swapped in with one call, say to keep the client from crowding out everything else
+// on the line in the evening. They are turned on and off by hand with Client.SetAltSpeed, or
+// by a schedule of weekly windows, see WithAltSpeedSchedule. The schedule only acts when a
+// window starts or ends, so turning them on or off by hand holds until its next change
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the schedule is looked at this often
+const altSpeedCheckInterval = 15 * time.Second
+
+// SetAltLimits sets the alternative upload and download limits in bytes per second, zero is
+// unlimited. They take effect right away if they are on
+func (s *SessionLimiter) SetAltLimits(upload, download int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.altUpload, s.altDownload = upload, download
+	s.apply()
+}
+
+// AltLimits returns the alternative upload and download limits in bytes per second
+func (s *SessionLimiter) AltLimits() (upload, download int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.altUpload, s.altDownload
+}
+
+// AltSpeed reports whether the alternative limits are the ones in effect
+func (s *SessionLimiter) AltSpeed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.alt
+}
+
+// this function swaps the alternative limits in or out and reports whether that changed
+// anything
+func (s *SessionLimiter) setAlt(on bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.alt == on {
+		return false
+	}
+	s.alt = on
+	s.apply()
+	return true
+}
+
+// SetAltSpeed turns the alternative speed limits on or off. A change is logged and
+// published on the bus as SessionAltSpeedOn or SessionAltSpeedOff
+func (c *Client) SetAltSpeed(on bool) {
+	c.setAltSpeed(on, "hand")
+}
+
+// AltSpeed reports whether the alternative speed limits are on
+func (c *Client) AltSpeed() bool {
+	return c.limiter.AltSpeed()
+}
+
+func (c *Client) setAltSpeed(on bool, by string) {
+	if !c.limiter.setAlt(on) {
+		return
+	}
+	ev, msg := SessionEvent{Type: SessionAltSpeedOff}, "alternative speed limits off"
+	if on {
+		ev.Type, msg = SessionAltSpeedOn, "alternative speed limits on"
+	}
+	c.logger.Info(msg, "by", by, "upload", c.limiter.UploadLimit(), "download", c.limiter.DownloadLimit())
+	c.bus.Publish(ev)
+}
+
+// this function turns the alternative limits on and off as the windows of schedules start
+// and end, until the client is closed. the first look counts as a change, so the client
+// starts the way the schedule says
+func (c *Client) altSpeedLoop(schedules []SpeedSchedule) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(altSpeedCheckInterval)
+	defer ticker.Stop()
+	scheduled := inSpeedSchedule(schedules, time.Now())
+	c.setAltSpeed(scheduled, "schedule")
+	for {
+		select {
+		case <-c.quit:
+			return
+		case now := <-ticker.C:
+			want := inSpeedSchedule(schedules, now)
+			if want != scheduled {
+				scheduled = want
+				c.setAltSpeed(want, "schedule")
+			}
+		}
+	}
+}
+
+// this function reports whether t is in any of the windows
+func inSpeedSchedule(schedules []SpeedSchedule, t time.Time) bool {
+	for _, s := range schedules {
+		if s.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpeedSchedule is a window of the week in which the alternative speed limits are on
+type SpeedSchedule struct {
+	// Days are the days the window starts on, every day when empty
+	Days []time.Weekday
+	// Start and End are times of day, counted from midnight. An End before the Start runs
+	// past midnight into the next day, an End of 24h runs to midnight
+	Start, End time.Duration
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseSpeedSchedule parses a window written as "[days] HH:MM-HH:MM", e.g. "22:00-07:00"
+// for every night or "mon-fri 09:00-17:30". Days are a comma separated list of three
+// letter names and ranges of them, like "mon,wed,fri" or "sat-sun"
+func ParseSpeedSchedule(s string) (SpeedSchedule, error) {
+	var sched SpeedSchedule
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sched, fmt.Errorf("schedule %q is not [days] HH:MM-HH:MM", s)
+	}
+	if len(fields) == 2 {
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return sched, fmt.Errorf("schedule %q: %w", s, err)
+		}
+		sched.Days = days
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return sched, fmt.Errorf("schedule %q is not [days] HH:MM-HH:MM", s)
+	}
+	var err error
+	sched.Start, err = parseTimeOfDay(start)
+	if err == nil {
+		sched.End, err = parseTimeOfDay(end)
+	}
+	if err == nil {
+		err = sched.validate()
+	}
+	if err != nil {
+		return sched, fmt.Errorf("schedule %q: %w", s, err)
+	}
+	return sched, nil
+}
+
+// this function parses "mon,wed-fri" into the days it names, in the order they are named
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, item := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := parseWeekday(first)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			to, err = parseWeekday(last)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// a range may wrap around the week, like fri-mon
+		for day := from; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for i, name := range weekdayNames {
+		if strings.EqualFold(s, name) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q, days are mon, tue, wed, thu, fri, sat and sun", s)
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	h, err := strconv.Atoi(hours)
+	if ok && err == nil {
+		var m int
+		m, err = strconv.Atoi(minutes)
+		if err == nil && h >= 0 && h <= 24 && m >= 0 && m < 60 && len(minutes) == 2 {
+			return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a time of day HH:MM", s)
+}
+
+// this function checks that the window is one Active can work with
+func (s SpeedSchedule) validate() error {
+	day := 24 * time.Hour
+	if s.Start < 0 || s.Start >= day || s.End <= 0 || s.End > day {
+		return errors.New("times of day must be from 00:00 to 24:00")
+	}
+	if s.Start == s.End {
+		return errors.New("the window starts when it ends")
+	}
+	for _, d := range s.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("%d is not a day of the week", d)
+		}
+	}
+	return nil
+}
+
+// Active reports whether t, in its own location, falls in the window
+func (s SpeedSchedule) Active(t time.Time) bool {
+	h, m, sec := t.Clock()
+	since := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	if s.Start < s.End {
+		return s.startsOn(t.Weekday()) && since >= s.Start && since < s.End
+	}
+	// the window runs past midnight, t is in its first part or in what ran over from the
+	// day before
+	yesterday := (t.Weekday() + 6) % 7
+	return s.startsOn(t.Weekday()) && since >= s.Start || s.startsOn(yesterday) && since < s.End
+}
+
+func (s SpeedSchedule) startsOn(day time.Weekday) bool {
+	return len(s.Days) == 0 || slices.Contains(s.Days, day)
+}
+
+// String returns the window the way ParseSpeedSchedule reads it
+func (s SpeedSchedule) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	window := clock(s.Start) + "-" + clock(s.End)
+	if len(s.Days) == 0 {
+		return window
+	}
+	days := make([]string, len(s.Days))
+	for i, d := range s.Days {
+		days[i] = weekdayNames[d]
+	}
+	return strings.Join(days, ",") + " " + window
+}
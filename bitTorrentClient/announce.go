@@ -6,11 +6,12 @@ import (
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/jackpal/bencode-go"
 )
@@ -19,7 +20,9 @@ type Announcer struct {
 	announce_url string
 	piece_size   int64
 	TotalSize    int64
-	urlParams    urlParams
+	// mu guards urlParams, the download updates them while an announce is out
+	mu        sync.Mutex
+	urlParams urlParams
 }
 
 type urlParams struct {
@@ -31,41 +34,49 @@ type urlParams struct {
 	left       int64
 	compact    string
 	event      string
+	trackerid  string
+	ip         string
 }
 
-func NewAnnouncer(filepath string) *Announcer {
+func NewAnnouncer(filepath string) (*Announcer, error) {
 	file, err := os.Open(filepath)
 	if err != nil {
-		log.Fatalf("Error opening file: %v", err)
+		return nil, fmt.Errorf("opening %s: %w", filepath, err)
 	}
 	defer file.Close()
 	var torrent map[string]interface{}
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&torrent)
 	if err != nil {
-		log.Fatalf("Error decoding JSON: %v", err)
+		return nil, fmt.Errorf("decoding %s: %w", filepath, err)
 	}
 
 	// Extract the "info" dictionary
 	info, ok := torrent["info"].(map[string]interface{})
 	if !ok {
-		log.Fatalf("Error: 'info' field is missing or not a dictionary")
+		return nil, errors.New("'info' field is missing or not a dictionary")
 	}
 
 	//  Bencode the info-dict
 	var bencodedInfo bytes.Buffer
 	err = bencode.Marshal(&bencodedInfo, info)
 	if err != nil {
-		log.Fatalf("Error bencoding info: %v", err)
+		return nil, fmt.Errorf("bencoding info: %w", err)
 	}
 
 	sha1_info_dict := computeInfoHash(bencodedInfo.Bytes())
 	uniquePeerId := generatePeerId()
-	url := torrent["announce"].(string)
-	piece_len := torrent["piece length"].(int64)
+	url, ok := torrent["announce"].(string)
+	if !ok {
+		return nil, errors.New("'announce' field is missing")
+	}
+	piece_len, ok := info["piece length"].(int64)
+	if !ok {
+		return nil, errors.New("'piece length' field is missing")
+	}
 	length, err := GetTotalLength(torrent)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	return &Announcer{
 		announce_url: url,
@@ -73,20 +84,21 @@ func NewAnnouncer(filepath string) *Announcer {
 		urlParams: urlParams{
 			info_dict:  sha1_info_dict,
 			peer_id:    uniquePeerId,
-			port:       "6881",
+			port:       strconv.Itoa(DefaultPort),
 			uploaded:   0,
 			downloaded: 0,
 			left:       length,
 			compact:    "1",
 			event:      "started",
 		},
-	}
+	}, nil
 }
 
-// this function returns the hased sha-1 string of the info-dict
+// this function returns the hased sha-1 string of the info-dict. it is left unescaped,
+// generateEncodedURL escapes every parameter
 func computeInfoHash(bencodedInfo []byte) string {
 	hash := sha1.Sum(bencodedInfo)
-	return url.QueryEscape(string(hash[:]))
+	return string(hash[:])
 }
 
 func generatePeerId() string {
@@ -95,7 +107,7 @@ func generatePeerId() string {
 	if err != nil {
 		panic(err)
 	}
-	return url.QueryEscape(string(buf))
+	return string(buf)
 }
 
 // this function calculates the total size of all files in the torrent
@@ -134,8 +146,8 @@ func GetTotalLength(decoded map[string]interface{}) (int64, error) {
 	return total, nil
 }
 
-// this function generates a request url
-func (a Announcer) generateEncodedURL() string {
+// this function generates a request url. the caller must hold a.mu
+func (a *Announcer) generateEncodedURL() string {
 	params := url.Values{}
 	params.Set("info_hash", a.urlParams.info_dict)
 	params.Set("peer_id", a.urlParams.peer_id)
@@ -147,22 +159,32 @@ func (a Announcer) generateEncodedURL() string {
 	if a.urlParams.event != "" {
 		params.Set("event", a.urlParams.event)
 	}
+	if a.urlParams.trackerid != "" {
+		params.Set("trackerid", a.urlParams.trackerid)
+	}
+	if a.urlParams.ip != "" {
+		params.Set("ip", a.urlParams.ip)
+	}
 	encoded_params := params.Encode()
 	return a.announce_url + "?" + encoded_params
 }
 
 // this function is to be called whenever a new piece is recieve, it will update the downloaded, and left url params
 func (a *Announcer) handleNewPieceLeeched(bytesDownloaded int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.urlParams.downloaded += bytesDownloaded
 	a.urlParams.left = a.TotalSize - a.urlParams.downloaded
 }
 
 // function to update the uploaded url param whenever a new piece is seeded
 func (a *Announcer) handleNewPieceSeeded() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.urlParams.uploaded += a.piece_size
 }
 
-// function used to update the event in the Announcer
+// function used to update the event in the Announcer. the caller must hold a.mu
 func (a *Announcer) setEvent(newEvent string) {
 	switch newEvent {
 	case "started":
@@ -0,0 +1,144 @@
+// This file keeps the API to the networks it is meant for. Listening on every address is
+// often the only way to reach it from the LAN, but on a seedbox that puts the control plane
+// on the internet, so connections from outside the allowed networks are closed as soon as
+// they are accepted, before a TLS handshake or a password guess. The default lets in the
+// machine itself and the private networks only
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// DefaultACL is what the API lets in when nothing else is configured
+var DefaultACL = []string{"loopback", "private"}
+
+// the networks the names in an ACL stand for
+var aclNames = map[string][]netip.Prefix{
+	"loopback": {
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	},
+	"private": {
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("169.254.0.0/16"),
+		netip.MustParsePrefix("fc00::/7"),
+		netip.MustParsePrefix("fe80::/10"),
+	},
+	"any": {
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("::/0"),
+	},
+}
+
+// ACL is the networks allowed to connect to the API
+type ACL []netip.Prefix
+
+// ParseACL parses networks like "192.168.1.0/24", single addresses and the names
+// "loopback", "private" for the RFC 1918, carrier-grade NAT, link-local and unique local
+// ranges, and "any"
+func ParseACL(entries []string) (ACL, error) {
+	var acl ACL
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if prefixes, ok := aclNames[strings.ToLower(entry)]; ok {
+			acl = append(acl, prefixes...)
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("api allow %q: %w", entry, err)
+			}
+			acl = append(acl, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("api allow %q must be a network, an address, loopback, private or any", entry)
+		}
+		addr = addr.Unmap()
+		acl = append(acl, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return acl, nil
+}
+
+// Allows reports whether addr is in one of the networks
+func (a ACL) Allows(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range a {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictListener returns a listener that closes the connections from outside acl as soon
+// as ln accepts them and hands on the others
+func RestrictListener(ln net.Listener, acl ACL) net.Listener {
+	return &aclListener{Listener: ln, acl: acl}
+}
+
+type aclListener struct {
+	net.Listener
+	acl ACL
+}
+
+func (l *aclListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && l.acl.Allows(addr.AddrPort().Addr()) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// InterfaceAddr returns the address of the network interface name to listen on, its first
+// IPv4 one when it has one and its first IPv6 one otherwise, leaving out link-local ones
+func InterfaceAddr(name string) (netip.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return netip.Addr{}, fmt.Errorf("interface %s is down", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	var v6 netip.Addr
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		switch {
+		case ip.IsLinkLocalUnicast():
+		case ip.Is4():
+			return ip, nil
+		case !v6.IsValid():
+			v6 = ip
+		}
+	}
+	if !v6.IsValid() {
+		return netip.Addr{}, fmt.Errorf("interface %s has no usable address", name)
+	}
+	return v6, nil
+}
@@ -0,0 +1,152 @@
+// Package api serves a Client over HTTP, so the client can run headless and be driven from
+// a web UI, a script or another machine. Everything is JSON under /api/v1:
+//
+//	GET    /api/v1/session                          rates, limits, port and torrent count
+//	PUT    /api/v1/session/alt-speed                turn the alternative speed limits on or off
+//	GET    /api/v1/torrents[?label=]                every torrent in queue order, or those with a label
+//	POST   /api/v1/torrents                         add a .torrent or a magnet link
+//	GET    /api/v1/torrents/{infohash}              one torrent with its files and trackers
+//	DELETE /api/v1/torrents/{infohash}[?data=true]  remove it, with its data
+//	POST   /api/v1/torrents/{infohash}/pause
+//	POST   /api/v1/torrents/{infohash}/resume
+//	GET    /api/v1/torrents/{infohash}/files
+//	PUT    /api/v1/torrents/{infohash}/files/{index} set a file's priority
+//	GET    /api/v1/torrents/{infohash}/peers
+//	PUT    /api/v1/torrents/{infohash}/labels       replace its labels
+//	PUT    /api/v1/torrents/{infohash}/queue        move it in the queue, set its queue priority
+//	GET    /api/v1/torrents/{infohash}/bundle       export it to another session, see bundles.go
+//	POST   /api/v1/bundles[?dir=]                   import a bundle exported by another session
+//	GET    /api/v1/labels                           the labels in use
+//	GET    /api/v1/events                           session events as server-sent events
+//	GET    /stream/{infohash}/{index}[/name]        a file's content as it downloads, see stream.go
+//	GET    /metrics                                 the session in the Prometheus text format
+//
+// A .torrent is added by posting it as application/x-bittorrent or as the "torrent" field
+// of a multipart form, with ?label= and ?dir= to label it and pick its directory, a magnet
+// link by posting {"magnet": "...", "labels": [...], "dir": "..."}. Magnet links are added
+// in the background since their metadata can take minutes to arrive, the answer is 202 and
+// the events tell when the torrent shows up or failed. A torrent is moved in the queue by
+// putting {"move": "up"}, or down, top and bottom, and {"priority": "high"}, or low and
+// normal, sets its queue priority. The alternative speed limits are turned on by putting
+// {"alt_speed": true} and off with false. Errors come back as {"error": "..."}
+//
+// Whoever reaches the API can delete torrents and their data, so it is best kept behind
+// RequireAuth, behind RestrictListener for the networks that may reach it, and served over
+// TLS with a certificate from LoadOrCreateCertificate when it is reached over a network,
+// see auth.go, acl.go and tls.go
+//
+// Remote controls written for Transmission are served by TransmissionRPC, see
+// transmission.go, and the same API is served over gRPC by GRPCServer, with the contract in
+// gonet.proto and the generated code in gonetpb, see grpc.go
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	bt "mybittorrent"
+)
+
+// Server answers the API for one client. It is an http.Handler, mount it on any server
+type Server struct {
+	client *bt.Client
+	mux    *http.ServeMux
+
+	// ctx bounds the magnet links being added in the background, Close cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewServer returns the API of client
+func NewServer(client *bt.Client) *Server {
+	s := &Server{client: client, mux: http.NewServeMux()}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.mux.HandleFunc("GET /api/v1/session", s.getSession)
+	s.mux.HandleFunc("PUT /api/v1/session/alt-speed", s.setAltSpeed)
+	s.mux.HandleFunc("GET /api/v1/torrents", s.listTorrents)
+	s.mux.HandleFunc("POST /api/v1/torrents", s.addTorrent)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infohash}", s.getTorrent)
+	s.mux.HandleFunc("DELETE /api/v1/torrents/{infohash}", s.removeTorrent)
+	s.mux.HandleFunc("POST /api/v1/torrents/{infohash}/pause", s.pauseTorrent)
+	s.mux.HandleFunc("POST /api/v1/torrents/{infohash}/resume", s.resumeTorrent)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infohash}/files", s.listFiles)
+	s.mux.HandleFunc("PUT /api/v1/torrents/{infohash}/files/{index}", s.setFilePriority)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infohash}/peers", s.listPeers)
+	s.mux.HandleFunc("PUT /api/v1/torrents/{infohash}/labels", s.setLabels)
+	s.mux.HandleFunc("PUT /api/v1/torrents/{infohash}/queue", s.setQueue)
+	s.mux.HandleFunc("GET /api/v1/torrents/{infohash}/bundle", s.exportBundle)
+	s.mux.HandleFunc("POST /api/v1/bundles", s.importBundle)
+	s.mux.HandleFunc("GET /api/v1/labels", s.listLabels)
+	s.mux.HandleFunc("GET /api/v1/events", s.streamEvents)
+	s.mux.HandleFunc("GET /stream/{infohash}/{index}", s.streamFile)
+	s.mux.HandleFunc("GET /stream/{infohash}/{index}/{name...}", s.streamFile)
+	s.mux.Handle("GET /metrics", MetricsHandler(client))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close gives up on the magnet links still being added and waits for them. It doesn't
+// close the client
+func (s *Server) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// errBadRequest marks errors that are the request's fault
+var errBadRequest = errors.New("bad request")
+
+// writeJSON answers with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with err and the status that fits it
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, bt.ErrTorrentUnknown):
+		status = http.StatusNotFound
+	case errors.Is(err, bt.ErrTorrentExists):
+		status = http.StatusConflict
+	case errors.Is(err, bt.ErrClientClosed):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// download returns the torrent the request's {infohash} names
+func (s *Server) download(r *http.Request) (*bt.Download, error) {
+	infoHash, err := parseInfoHash(r.PathValue("infohash"))
+	if err != nil {
+		return nil, err
+	}
+	d, ok := s.client.Torrent(infoHash)
+	if !ok {
+		return nil, bt.ErrTorrentUnknown
+	}
+	return d, nil
+}
+
+func parseInfoHash(s string) ([20]byte, error) {
+	var infoHash [20]byte
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != len(infoHash) {
+		return infoHash, fmt.Errorf("%w: infohash %q is not 40 hex digits", errBadRequest, s)
+	}
+	copy(infoHash[:], raw)
+	return infoHash, nil
+}
@@ -0,0 +1,52 @@
+// This file moves torrents between sessions as bundles, see bt.Client.ExportBundle.
+// A GET of /api/v1/torrents/{infohash}/bundle answers with the bundle as a download. A POST
+// of it to /api/v1/bundles, with ?dir= when the data was copied somewhere else, adds the
+// torrent with its settings and progress
+
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	bt "mybittorrent"
+)
+
+// the bundle holds the .torrent in base64 and the resume data
+const maxBundleSize = 2 * maxTorrentSize
+
+func (s *Server) exportBundle(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// made before answering, so a failure still gets its status
+	var buf bytes.Buffer
+	err = s.client.ExportBundle(d.InfoHash, &buf)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	name := fmt.Sprintf("%x.bundle", d.InfoHash)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) importBundle(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBundleSize)
+	d, err := s.client.ImportBundle(r.Body, r.URL.Query().Get("dir"))
+	if d == nil {
+		if errors.Is(err, bt.ErrBadBundle) {
+			err = fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		writeError(w, err)
+		return
+	}
+	// a torrent that failed to start is in the session all the same, its state says why
+	writeJSON(w, http.StatusCreated, NewTorrent(d))
+}
@@ -0,0 +1,122 @@
+// This file streams the session's events as server-sent events, which a browser reads with
+// EventSource and anything else with a line reader. Every event is named after its type,
+// e.g. torrent_added, with the Event below as its data. With ?stats=2s the stream carries
+// a "torrents" event with the list of torrents every two seconds as well, so a dashboard
+// needs no polling at all. A comment goes out every keepAliveInterval to keep proxies from
+// timing out a quiet stream
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	bt "mybittorrent"
+)
+
+const (
+	keepAliveInterval = 30 * time.Second
+	// the shortest ?stats interval, shorter ones are raised to it
+	minStatsInterval = 500 * time.Millisecond
+)
+
+type Event struct {
+	Type         string    `json:"type"`
+	Time         time.Time `json:"time"`
+	InfoHash     string    `json:"info_hash,omitempty"`
+	Port         int       `json:"port,omitempty"`
+	ExternalAddr string    `json:"external_addr,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	// Missed is the number of events dropped before this one because the stream fell behind
+	Missed int `json:"missed,omitempty"`
+}
+
+// NewEvent returns the JSON shape of ev
+func NewEvent(ev bt.SessionEvent) Event {
+	e := Event{
+		Type:         strings.ReplaceAll(ev.Type.String(), " ", "_"),
+		Time:         ev.Time,
+		Port:         ev.Port,
+		ExternalAddr: ev.ExternalAddr,
+		Missed:       ev.Missed,
+	}
+	if ev.InfoHash != [20]byte{} {
+		e.InfoHash = fmt.Sprintf("%x", ev.InfoHash)
+	}
+	if ev.Err != nil {
+		e.Error = ev.Err.Error()
+	}
+	return e
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	var statsTick <-chan time.Time
+	if param := r.URL.Query().Get("stats"); param != "" {
+		interval, err := time.ParseDuration(param)
+		if err != nil {
+			writeError(w, fmt.Errorf("%w: stats interval: %v", errBadRequest, err))
+			return
+		}
+		ticker := time.NewTicker(max(interval, minStatsInterval))
+		defer ticker.Stop()
+		statsTick = ticker.C
+	}
+
+	events := make(chan bt.SessionEvent, bt.DefaultEventBuffer)
+	unsubscribe := s.client.Events().Subscribe(0, func(ev bt.SessionEvent) {
+		select {
+		case events <- ev:
+		case <-r.Context().Done():
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case ev := <-events:
+			e := NewEvent(ev)
+			err = writeEvent(w, e.Type, e)
+		case <-statsTick:
+			list := []Torrent{}
+			for _, d := range s.client.Torrents() {
+				list = append(list, NewTorrent(d))
+			}
+			err = writeEvent(w, "torrents", list)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one server-sent event, JSON has no newlines so data is a single line
+func writeEvent(w http.ResponseWriter, name string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, raw)
+	return err
+}
@@ -0,0 +1,5 @@
+// Package gonetpb is the Go code generated from api/gonet.proto, the messages of the gRPC
+// control API with the Session client and server stubs. api.GRPCServer implements the
+// server on a Client, clients dial it with NewSessionClient. Don't edit it by hand, run
+// go generate in api after changing the proto
+package gonetpb
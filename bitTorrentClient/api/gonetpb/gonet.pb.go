@@ -0,0 +1,1973 @@
+// The gRPC contract of the control API, the typed twin of the REST API in api.go for
+// services that would rather have generated stubs than parse JSON. Messages mirror the
+// JSON shapes in torrents.go and events.go field for field, infohashes are 40 hex digits,
+// rates are bytes per second and durations seconds.
+//
+// GRPCServer in grpc.go answers it, the Go code in gonetpb is generated from this file, see
+// the go:generate line there. The server takes the REST API's credentials, as
+// "authorization" metadata in the same form as the header
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: gonet.proto
+
+package gonetpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type FilePriority int32
+
+const (
+	FilePriority_FILE_PRIORITY_SKIP   FilePriority = 0
+	FilePriority_FILE_PRIORITY_NORMAL FilePriority = 1
+	FilePriority_FILE_PRIORITY_HIGH   FilePriority = 2
+)
+
+// Enum value maps for FilePriority.
+var (
+	FilePriority_name = map[int32]string{
+		0: "FILE_PRIORITY_SKIP",
+		1: "FILE_PRIORITY_NORMAL",
+		2: "FILE_PRIORITY_HIGH",
+	}
+	FilePriority_value = map[string]int32{
+		"FILE_PRIORITY_SKIP":   0,
+		"FILE_PRIORITY_NORMAL": 1,
+		"FILE_PRIORITY_HIGH":   2,
+	}
+)
+
+func (x FilePriority) Enum() *FilePriority {
+	p := new(FilePriority)
+	*p = x
+	return p
+}
+
+func (x FilePriority) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FilePriority) Descriptor() protoreflect.EnumDescriptor {
+	return file_gonet_proto_enumTypes[0].Descriptor()
+}
+
+func (FilePriority) Type() protoreflect.EnumType {
+	return &file_gonet_proto_enumTypes[0]
+}
+
+func (x FilePriority) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FilePriority.Descriptor instead.
+func (FilePriority) EnumDescriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{0}
+}
+
+type GetSessionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSessionRequest) Reset() {
+	*x = GetSessionRequest{}
+	mi := &file_gonet_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSessionRequest) ProtoMessage() {}
+
+func (x *GetSessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSessionRequest.ProtoReflect.Descriptor instead.
+func (*GetSessionRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{0}
+}
+
+type SessionInfo struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Port         int32                  `protobuf:"varint,1,opt,name=port,proto3" json:"port,omitempty"`
+	DownloadRate float64                `protobuf:"fixed64,2,opt,name=download_rate,json=downloadRate,proto3" json:"download_rate,omitempty"`
+	UploadRate   float64                `protobuf:"fixed64,3,opt,name=upload_rate,json=uploadRate,proto3" json:"upload_rate,omitempty"`
+	Torrents     int32                  `protobuf:"varint,4,opt,name=torrents,proto3" json:"torrents,omitempty"`
+	// the limits in effect, 0 is unlimited. alt_speed tells whether they are the
+	// alternative ones
+	DownloadLimit    int64 `protobuf:"varint,5,opt,name=download_limit,json=downloadLimit,proto3" json:"download_limit,omitempty"`
+	UploadLimit      int64 `protobuf:"varint,6,opt,name=upload_limit,json=uploadLimit,proto3" json:"upload_limit,omitempty"`
+	AltSpeed         bool  `protobuf:"varint,7,opt,name=alt_speed,json=altSpeed,proto3" json:"alt_speed,omitempty"`
+	AltDownloadLimit int64 `protobuf:"varint,8,opt,name=alt_download_limit,json=altDownloadLimit,proto3" json:"alt_download_limit,omitempty"`
+	AltUploadLimit   int64 `protobuf:"varint,9,opt,name=alt_upload_limit,json=altUploadLimit,proto3" json:"alt_upload_limit,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *SessionInfo) Reset() {
+	*x = SessionInfo{}
+	mi := &file_gonet_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionInfo) ProtoMessage() {}
+
+func (x *SessionInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionInfo.ProtoReflect.Descriptor instead.
+func (*SessionInfo) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SessionInfo) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetDownloadRate() float64 {
+	if x != nil {
+		return x.DownloadRate
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetUploadRate() float64 {
+	if x != nil {
+		return x.UploadRate
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetTorrents() int32 {
+	if x != nil {
+		return x.Torrents
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetDownloadLimit() int64 {
+	if x != nil {
+		return x.DownloadLimit
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetUploadLimit() int64 {
+	if x != nil {
+		return x.UploadLimit
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetAltSpeed() bool {
+	if x != nil {
+		return x.AltSpeed
+	}
+	return false
+}
+
+func (x *SessionInfo) GetAltDownloadLimit() int64 {
+	if x != nil {
+		return x.AltDownloadLimit
+	}
+	return 0
+}
+
+func (x *SessionInfo) GetAltUploadLimit() int64 {
+	if x != nil {
+		return x.AltUploadLimit
+	}
+	return 0
+}
+
+type SetAltSpeedRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AltSpeed      bool                   `protobuf:"varint,1,opt,name=alt_speed,json=altSpeed,proto3" json:"alt_speed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetAltSpeedRequest) Reset() {
+	*x = SetAltSpeedRequest{}
+	mi := &file_gonet_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetAltSpeedRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetAltSpeedRequest) ProtoMessage() {}
+
+func (x *SetAltSpeedRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetAltSpeedRequest.ProtoReflect.Descriptor instead.
+func (*SetAltSpeedRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SetAltSpeedRequest) GetAltSpeed() bool {
+	if x != nil {
+		return x.AltSpeed
+	}
+	return false
+}
+
+type ListTorrentsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// label lists only the torrents that have it, all of them when empty
+	Label         string `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTorrentsRequest) Reset() {
+	*x = ListTorrentsRequest{}
+	mi := &file_gonet_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTorrentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTorrentsRequest) ProtoMessage() {}
+
+func (x *ListTorrentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTorrentsRequest.ProtoReflect.Descriptor instead.
+func (*ListTorrentsRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListTorrentsRequest) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+type ListTorrentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Torrents      []*Torrent             `protobuf:"bytes,1,rep,name=torrents,proto3" json:"torrents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTorrentsResponse) Reset() {
+	*x = ListTorrentsResponse{}
+	mi := &file_gonet_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTorrentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTorrentsResponse) ProtoMessage() {}
+
+func (x *ListTorrentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTorrentsResponse.ProtoReflect.Descriptor instead.
+func (*ListTorrentsResponse) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListTorrentsResponse) GetTorrents() []*Torrent {
+	if x != nil {
+		return x.Torrents
+	}
+	return nil
+}
+
+type TorrentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash      string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TorrentRequest) Reset() {
+	*x = TorrentRequest{}
+	mi := &file_gonet_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TorrentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TorrentRequest) ProtoMessage() {}
+
+func (x *TorrentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TorrentRequest.ProtoReflect.Descriptor instead.
+func (*TorrentRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TorrentRequest) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+type Torrent struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash     string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	Name         string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	State        string                 `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	Error        string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Size         int64                  `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	Left         int64                  `protobuf:"varint,6,opt,name=left,proto3" json:"left,omitempty"`
+	Progress     float64                `protobuf:"fixed64,7,opt,name=progress,proto3" json:"progress,omitempty"`
+	Downloaded   int64                  `protobuf:"varint,8,opt,name=downloaded,proto3" json:"downloaded,omitempty"`
+	Uploaded     int64                  `protobuf:"varint,9,opt,name=uploaded,proto3" json:"uploaded,omitempty"`
+	DownloadRate float64                `protobuf:"fixed64,10,opt,name=download_rate,json=downloadRate,proto3" json:"download_rate,omitempty"`
+	UploadRate   float64                `protobuf:"fixed64,11,opt,name=upload_rate,json=uploadRate,proto3" json:"upload_rate,omitempty"`
+	Ratio        float64                `protobuf:"fixed64,12,opt,name=ratio,proto3" json:"ratio,omitempty"`
+	// eta is -1 when nothing is coming in
+	Eta         int64    `protobuf:"varint,13,opt,name=eta,proto3" json:"eta,omitempty"`
+	Peers       int32    `protobuf:"varint,14,opt,name=peers,proto3" json:"peers,omitempty"`
+	Seeds       int32    `protobuf:"varint,15,opt,name=seeds,proto3" json:"seeds,omitempty"`
+	Leechers    int32    `protobuf:"varint,16,opt,name=leechers,proto3" json:"leechers,omitempty"`
+	PiecesHave  int32    `protobuf:"varint,17,opt,name=pieces_have,json=piecesHave,proto3" json:"pieces_have,omitempty"`
+	PiecesTotal int32    `protobuf:"varint,18,opt,name=pieces_total,json=piecesTotal,proto3" json:"pieces_total,omitempty"`
+	Tracker     string   `protobuf:"bytes,19,opt,name=tracker,proto3" json:"tracker,omitempty"`
+	Labels      []string `protobuf:"bytes,20,rep,name=labels,proto3" json:"labels,omitempty"`
+	// queue_priority is low, normal or high. Lists come in queue order
+	QueuePriority string `protobuf:"bytes,21,opt,name=queue_priority,json=queuePriority,proto3" json:"queue_priority,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Torrent) Reset() {
+	*x = Torrent{}
+	mi := &file_gonet_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Torrent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Torrent) ProtoMessage() {}
+
+func (x *Torrent) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Torrent.ProtoReflect.Descriptor instead.
+func (*Torrent) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Torrent) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *Torrent) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Torrent) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Torrent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Torrent) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Torrent) GetLeft() int64 {
+	if x != nil {
+		return x.Left
+	}
+	return 0
+}
+
+func (x *Torrent) GetProgress() float64 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *Torrent) GetDownloaded() int64 {
+	if x != nil {
+		return x.Downloaded
+	}
+	return 0
+}
+
+func (x *Torrent) GetUploaded() int64 {
+	if x != nil {
+		return x.Uploaded
+	}
+	return 0
+}
+
+func (x *Torrent) GetDownloadRate() float64 {
+	if x != nil {
+		return x.DownloadRate
+	}
+	return 0
+}
+
+func (x *Torrent) GetUploadRate() float64 {
+	if x != nil {
+		return x.UploadRate
+	}
+	return 0
+}
+
+func (x *Torrent) GetRatio() float64 {
+	if x != nil {
+		return x.Ratio
+	}
+	return 0
+}
+
+func (x *Torrent) GetEta() int64 {
+	if x != nil {
+		return x.Eta
+	}
+	return 0
+}
+
+func (x *Torrent) GetPeers() int32 {
+	if x != nil {
+		return x.Peers
+	}
+	return 0
+}
+
+func (x *Torrent) GetSeeds() int32 {
+	if x != nil {
+		return x.Seeds
+	}
+	return 0
+}
+
+func (x *Torrent) GetLeechers() int32 {
+	if x != nil {
+		return x.Leechers
+	}
+	return 0
+}
+
+func (x *Torrent) GetPiecesHave() int32 {
+	if x != nil {
+		return x.PiecesHave
+	}
+	return 0
+}
+
+func (x *Torrent) GetPiecesTotal() int32 {
+	if x != nil {
+		return x.PiecesTotal
+	}
+	return 0
+}
+
+func (x *Torrent) GetTracker() string {
+	if x != nil {
+		return x.Tracker
+	}
+	return ""
+}
+
+func (x *Torrent) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Torrent) GetQueuePriority() string {
+	if x != nil {
+		return x.QueuePriority
+	}
+	return ""
+}
+
+type TorrentDetail struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Torrent       *Torrent               `protobuf:"bytes,1,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	Files         []*File                `protobuf:"bytes,2,rep,name=files,proto3" json:"files,omitempty"`
+	Trackers      []string               `protobuf:"bytes,3,rep,name=trackers,proto3" json:"trackers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TorrentDetail) Reset() {
+	*x = TorrentDetail{}
+	mi := &file_gonet_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TorrentDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TorrentDetail) ProtoMessage() {}
+
+func (x *TorrentDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TorrentDetail.ProtoReflect.Descriptor instead.
+func (*TorrentDetail) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TorrentDetail) GetTorrent() *Torrent {
+	if x != nil {
+		return x.Torrent
+	}
+	return nil
+}
+
+func (x *TorrentDetail) GetFiles() []*File {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *TorrentDetail) GetTrackers() []string {
+	if x != nil {
+		return x.Trackers
+	}
+	return nil
+}
+
+type File struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Path          string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Length        int64                  `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	Completed     int64                  `protobuf:"varint,4,opt,name=completed,proto3" json:"completed,omitempty"`
+	Progress      float64                `protobuf:"fixed64,5,opt,name=progress,proto3" json:"progress,omitempty"`
+	Priority      FilePriority           `protobuf:"varint,6,opt,name=priority,proto3,enum=gonet.v1.FilePriority" json:"priority,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *File) Reset() {
+	*x = File{}
+	mi := &file_gonet_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *File) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*File) ProtoMessage() {}
+
+func (x *File) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use File.ProtoReflect.Descriptor instead.
+func (*File) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *File) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *File) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *File) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *File) GetCompleted() int64 {
+	if x != nil {
+		return x.Completed
+	}
+	return 0
+}
+
+func (x *File) GetProgress() float64 {
+	if x != nil {
+		return x.Progress
+	}
+	return 0
+}
+
+func (x *File) GetPriority() FilePriority {
+	if x != nil {
+		return x.Priority
+	}
+	return FilePriority_FILE_PRIORITY_SKIP
+}
+
+type Peer struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Addr           string                 `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	Client         string                 `protobuf:"bytes,2,opt,name=client,proto3" json:"client,omitempty"`
+	Transport      string                 `protobuf:"bytes,3,opt,name=transport,proto3" json:"transport,omitempty"`
+	Source         string                 `protobuf:"bytes,4,opt,name=source,proto3" json:"source,omitempty"`
+	Downloaded     int64                  `protobuf:"varint,5,opt,name=downloaded,proto3" json:"downloaded,omitempty"`
+	Uploaded       int64                  `protobuf:"varint,6,opt,name=uploaded,proto3" json:"uploaded,omitempty"`
+	DownloadRate   float64                `protobuf:"fixed64,7,opt,name=download_rate,json=downloadRate,proto3" json:"download_rate,omitempty"`
+	UploadRate     float64                `protobuf:"fixed64,8,opt,name=upload_rate,json=uploadRate,proto3" json:"upload_rate,omitempty"`
+	AmChoking      bool                   `protobuf:"varint,9,opt,name=am_choking,json=amChoking,proto3" json:"am_choking,omitempty"`
+	AmInterested   bool                   `protobuf:"varint,10,opt,name=am_interested,json=amInterested,proto3" json:"am_interested,omitempty"`
+	PeerChoking    bool                   `protobuf:"varint,11,opt,name=peer_choking,json=peerChoking,proto3" json:"peer_choking,omitempty"`
+	PeerInterested bool                   `protobuf:"varint,12,opt,name=peer_interested,json=peerInterested,proto3" json:"peer_interested,omitempty"`
+	Snubbed        bool                   `protobuf:"varint,13,opt,name=snubbed,proto3" json:"snubbed,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Peer) Reset() {
+	*x = Peer{}
+	mi := &file_gonet_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Peer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Peer) ProtoMessage() {}
+
+func (x *Peer) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Peer.ProtoReflect.Descriptor instead.
+func (*Peer) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Peer) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *Peer) GetClient() string {
+	if x != nil {
+		return x.Client
+	}
+	return ""
+}
+
+func (x *Peer) GetTransport() string {
+	if x != nil {
+		return x.Transport
+	}
+	return ""
+}
+
+func (x *Peer) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Peer) GetDownloaded() int64 {
+	if x != nil {
+		return x.Downloaded
+	}
+	return 0
+}
+
+func (x *Peer) GetUploaded() int64 {
+	if x != nil {
+		return x.Uploaded
+	}
+	return 0
+}
+
+func (x *Peer) GetDownloadRate() float64 {
+	if x != nil {
+		return x.DownloadRate
+	}
+	return 0
+}
+
+func (x *Peer) GetUploadRate() float64 {
+	if x != nil {
+		return x.UploadRate
+	}
+	return 0
+}
+
+func (x *Peer) GetAmChoking() bool {
+	if x != nil {
+		return x.AmChoking
+	}
+	return false
+}
+
+func (x *Peer) GetAmInterested() bool {
+	if x != nil {
+		return x.AmInterested
+	}
+	return false
+}
+
+func (x *Peer) GetPeerChoking() bool {
+	if x != nil {
+		return x.PeerChoking
+	}
+	return false
+}
+
+func (x *Peer) GetPeerInterested() bool {
+	if x != nil {
+		return x.PeerInterested
+	}
+	return false
+}
+
+func (x *Peer) GetSnubbed() bool {
+	if x != nil {
+		return x.Snubbed
+	}
+	return false
+}
+
+type AddTorrentRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Source:
+	//
+	//	*AddTorrentRequest_Metainfo
+	//	*AddTorrentRequest_Magnet
+	Source isAddTorrentRequest_Source `protobuf_oneof:"source"`
+	Labels []string                   `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty"`
+	// dir is where the torrent is saved, the client's directory or its labels' when empty
+	Dir           string `protobuf:"bytes,4,opt,name=dir,proto3" json:"dir,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddTorrentRequest) Reset() {
+	*x = AddTorrentRequest{}
+	mi := &file_gonet_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddTorrentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddTorrentRequest) ProtoMessage() {}
+
+func (x *AddTorrentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddTorrentRequest.ProtoReflect.Descriptor instead.
+func (*AddTorrentRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *AddTorrentRequest) GetSource() isAddTorrentRequest_Source {
+	if x != nil {
+		return x.Source
+	}
+	return nil
+}
+
+func (x *AddTorrentRequest) GetMetainfo() []byte {
+	if x != nil {
+		if x, ok := x.Source.(*AddTorrentRequest_Metainfo); ok {
+			return x.Metainfo
+		}
+	}
+	return nil
+}
+
+func (x *AddTorrentRequest) GetMagnet() string {
+	if x != nil {
+		if x, ok := x.Source.(*AddTorrentRequest_Magnet); ok {
+			return x.Magnet
+		}
+	}
+	return ""
+}
+
+func (x *AddTorrentRequest) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *AddTorrentRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
+	}
+	return ""
+}
+
+type isAddTorrentRequest_Source interface {
+	isAddTorrentRequest_Source()
+}
+
+type AddTorrentRequest_Metainfo struct {
+	// metainfo is the content of a .torrent file
+	Metainfo []byte `protobuf:"bytes,1,opt,name=metainfo,proto3,oneof"`
+}
+
+type AddTorrentRequest_Magnet struct {
+	Magnet string `protobuf:"bytes,2,opt,name=magnet,proto3,oneof"`
+}
+
+func (*AddTorrentRequest_Metainfo) isAddTorrentRequest_Source() {}
+
+func (*AddTorrentRequest_Magnet) isAddTorrentRequest_Source() {}
+
+type AddTorrentResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	// torrent is unset for a magnet link, its metadata is still to come
+	Torrent       *Torrent `protobuf:"bytes,2,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddTorrentResponse) Reset() {
+	*x = AddTorrentResponse{}
+	mi := &file_gonet_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddTorrentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddTorrentResponse) ProtoMessage() {}
+
+func (x *AddTorrentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddTorrentResponse.ProtoReflect.Descriptor instead.
+func (*AddTorrentResponse) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AddTorrentResponse) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *AddTorrentResponse) GetTorrent() *Torrent {
+	if x != nil {
+		return x.Torrent
+	}
+	return nil
+}
+
+type SetLabelsRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	// labels replace the torrent's
+	Labels        []string `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLabelsRequest) Reset() {
+	*x = SetLabelsRequest{}
+	mi := &file_gonet_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLabelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLabelsRequest) ProtoMessage() {}
+
+func (x *SetLabelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLabelsRequest.ProtoReflect.Descriptor instead.
+func (*SetLabelsRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SetLabelsRequest) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *SetLabelsRequest) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type SetQueueRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	// move is up, down, top or bottom, priority low, normal or high. Either can be empty
+	Move          string `protobuf:"bytes,2,opt,name=move,proto3" json:"move,omitempty"`
+	Priority      string `protobuf:"bytes,3,opt,name=priority,proto3" json:"priority,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetQueueRequest) Reset() {
+	*x = SetQueueRequest{}
+	mi := &file_gonet_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetQueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetQueueRequest) ProtoMessage() {}
+
+func (x *SetQueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetQueueRequest.ProtoReflect.Descriptor instead.
+func (*SetQueueRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SetQueueRequest) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *SetQueueRequest) GetMove() string {
+	if x != nil {
+		return x.Move
+	}
+	return ""
+}
+
+func (x *SetQueueRequest) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+type ListLabelsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLabelsRequest) Reset() {
+	*x = ListLabelsRequest{}
+	mi := &file_gonet_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLabelsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLabelsRequest) ProtoMessage() {}
+
+func (x *ListLabelsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLabelsRequest.ProtoReflect.Descriptor instead.
+func (*ListLabelsRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{14}
+}
+
+type ListLabelsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Labels        []*Label               `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLabelsResponse) Reset() {
+	*x = ListLabelsResponse{}
+	mi := &file_gonet_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLabelsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLabelsResponse) ProtoMessage() {}
+
+func (x *ListLabelsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLabelsResponse.ProtoReflect.Descriptor instead.
+func (*ListLabelsResponse) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListLabelsResponse) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type Label struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Label         string                 `protobuf:"bytes,1,opt,name=label,proto3" json:"label,omitempty"`
+	Torrents      int32                  `protobuf:"varint,2,opt,name=torrents,proto3" json:"torrents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Label) Reset() {
+	*x = Label{}
+	mi := &file_gonet_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Label) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Label) ProtoMessage() {}
+
+func (x *Label) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Label.ProtoReflect.Descriptor instead.
+func (*Label) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *Label) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Label) GetTorrents() int32 {
+	if x != nil {
+		return x.Torrents
+	}
+	return 0
+}
+
+type RemoveTorrentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash      string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	WithData      bool                   `protobuf:"varint,2,opt,name=with_data,json=withData,proto3" json:"with_data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveTorrentRequest) Reset() {
+	*x = RemoveTorrentRequest{}
+	mi := &file_gonet_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveTorrentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveTorrentRequest) ProtoMessage() {}
+
+func (x *RemoveTorrentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveTorrentRequest.ProtoReflect.Descriptor instead.
+func (*RemoveTorrentRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *RemoveTorrentRequest) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *RemoveTorrentRequest) GetWithData() bool {
+	if x != nil {
+		return x.WithData
+	}
+	return false
+}
+
+type RemoveTorrentResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveTorrentResponse) Reset() {
+	*x = RemoveTorrentResponse{}
+	mi := &file_gonet_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveTorrentResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveTorrentResponse) ProtoMessage() {}
+
+func (x *RemoveTorrentResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveTorrentResponse.ProtoReflect.Descriptor instead.
+func (*RemoveTorrentResponse) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{18}
+}
+
+type SetFilePriorityRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	InfoHash      string                 `protobuf:"bytes,1,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	Index         int32                  `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	Priority      FilePriority           `protobuf:"varint,3,opt,name=priority,proto3,enum=gonet.v1.FilePriority" json:"priority,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetFilePriorityRequest) Reset() {
+	*x = SetFilePriorityRequest{}
+	mi := &file_gonet_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetFilePriorityRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetFilePriorityRequest) ProtoMessage() {}
+
+func (x *SetFilePriorityRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetFilePriorityRequest.ProtoReflect.Descriptor instead.
+func (*SetFilePriorityRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *SetFilePriorityRequest) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *SetFilePriorityRequest) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *SetFilePriorityRequest) GetPriority() FilePriority {
+	if x != nil {
+		return x.Priority
+	}
+	return FilePriority_FILE_PRIORITY_SKIP
+}
+
+type ListFilesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Files         []*File                `protobuf:"bytes,1,rep,name=files,proto3" json:"files,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListFilesResponse) Reset() {
+	*x = ListFilesResponse{}
+	mi := &file_gonet_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListFilesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListFilesResponse) ProtoMessage() {}
+
+func (x *ListFilesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListFilesResponse.ProtoReflect.Descriptor instead.
+func (*ListFilesResponse) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ListFilesResponse) GetFiles() []*File {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+type ListPeersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Peers         []*Peer                `protobuf:"bytes,1,rep,name=peers,proto3" json:"peers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPeersResponse) Reset() {
+	*x = ListPeersResponse{}
+	mi := &file_gonet_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPeersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPeersResponse) ProtoMessage() {}
+
+func (x *ListPeersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPeersResponse.ProtoReflect.Descriptor instead.
+func (*ListPeersResponse) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *ListPeersResponse) GetPeers() []*Peer {
+	if x != nil {
+		return x.Peers
+	}
+	return nil
+}
+
+type WatchTorrentsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// interval_ms is raised to 500 when shorter
+	IntervalMs    int64 `protobuf:"varint,1,opt,name=interval_ms,json=intervalMs,proto3" json:"interval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchTorrentsRequest) Reset() {
+	*x = WatchTorrentsRequest{}
+	mi := &file_gonet_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchTorrentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchTorrentsRequest) ProtoMessage() {}
+
+func (x *WatchTorrentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchTorrentsRequest.ProtoReflect.Descriptor instead.
+func (*WatchTorrentsRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *WatchTorrentsRequest) GetIntervalMs() int64 {
+	if x != nil {
+		return x.IntervalMs
+	}
+	return 0
+}
+
+type WatchEventsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEventsRequest) Reset() {
+	*x = WatchEventsRequest{}
+	mi := &file_gonet_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEventsRequest) ProtoMessage() {}
+
+func (x *WatchEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEventsRequest.ProtoReflect.Descriptor instead.
+func (*WatchEventsRequest) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{23}
+}
+
+type Event struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// type is named like the REST stream's events, e.g. torrent_added
+	Type          string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	TimeUnixNano  int64  `protobuf:"varint,2,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	InfoHash      string `protobuf:"bytes,3,opt,name=info_hash,json=infoHash,proto3" json:"info_hash,omitempty"`
+	Port          int32  `protobuf:"varint,4,opt,name=port,proto3" json:"port,omitempty"`
+	ExternalAddr  string `protobuf:"bytes,5,opt,name=external_addr,json=externalAddr,proto3" json:"external_addr,omitempty"`
+	Error         string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	Missed        int32  `protobuf:"varint,7,opt,name=missed,proto3" json:"missed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_gonet_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_gonet_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_gonet_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetTimeUnixNano() int64 {
+	if x != nil {
+		return x.TimeUnixNano
+	}
+	return 0
+}
+
+func (x *Event) GetInfoHash() string {
+	if x != nil {
+		return x.InfoHash
+	}
+	return ""
+}
+
+func (x *Event) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Event) GetExternalAddr() string {
+	if x != nil {
+		return x.ExternalAddr
+	}
+	return ""
+}
+
+func (x *Event) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Event) GetMissed() int32 {
+	if x != nil {
+		return x.Missed
+	}
+	return 0
+}
+
+var File_gonet_proto protoreflect.FileDescriptor
+
+const file_gonet_proto_rawDesc = "" +
+	"\n" +
+	"\vgonet.proto\x12\bgonet.v1\"\x13\n" +
+	"\x11GetSessionRequest\"\xc2\x02\n" +
+	"\vSessionInfo\x12\x12\n" +
+	"\x04port\x18\x01 \x01(\x05R\x04port\x12#\n" +
+	"\rdownload_rate\x18\x02 \x01(\x01R\fdownloadRate\x12\x1f\n" +
+	"\vupload_rate\x18\x03 \x01(\x01R\n" +
+	"uploadRate\x12\x1a\n" +
+	"\btorrents\x18\x04 \x01(\x05R\btorrents\x12%\n" +
+	"\x0edownload_limit\x18\x05 \x01(\x03R\rdownloadLimit\x12!\n" +
+	"\fupload_limit\x18\x06 \x01(\x03R\vuploadLimit\x12\x1b\n" +
+	"\talt_speed\x18\a \x01(\bR\baltSpeed\x12,\n" +
+	"\x12alt_download_limit\x18\b \x01(\x03R\x10altDownloadLimit\x12(\n" +
+	"\x10alt_upload_limit\x18\t \x01(\x03R\x0ealtUploadLimit\"1\n" +
+	"\x12SetAltSpeedRequest\x12\x1b\n" +
+	"\talt_speed\x18\x01 \x01(\bR\baltSpeed\"+\n" +
+	"\x13ListTorrentsRequest\x12\x14\n" +
+	"\x05label\x18\x01 \x01(\tR\x05label\"E\n" +
+	"\x14ListTorrentsResponse\x12-\n" +
+	"\btorrents\x18\x01 \x03(\v2\x11.gonet.v1.TorrentR\btorrents\"-\n" +
+	"\x0eTorrentRequest\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\"\xb9\x04\n" +
+	"\aTorrent\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x14\n" +
+	"\x05state\x18\x03 \x01(\tR\x05state\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\x03R\x04size\x12\x12\n" +
+	"\x04left\x18\x06 \x01(\x03R\x04left\x12\x1a\n" +
+	"\bprogress\x18\a \x01(\x01R\bprogress\x12\x1e\n" +
+	"\n" +
+	"downloaded\x18\b \x01(\x03R\n" +
+	"downloaded\x12\x1a\n" +
+	"\buploaded\x18\t \x01(\x03R\buploaded\x12#\n" +
+	"\rdownload_rate\x18\n" +
+	" \x01(\x01R\fdownloadRate\x12\x1f\n" +
+	"\vupload_rate\x18\v \x01(\x01R\n" +
+	"uploadRate\x12\x14\n" +
+	"\x05ratio\x18\f \x01(\x01R\x05ratio\x12\x10\n" +
+	"\x03eta\x18\r \x01(\x03R\x03eta\x12\x14\n" +
+	"\x05peers\x18\x0e \x01(\x05R\x05peers\x12\x14\n" +
+	"\x05seeds\x18\x0f \x01(\x05R\x05seeds\x12\x1a\n" +
+	"\bleechers\x18\x10 \x01(\x05R\bleechers\x12\x1f\n" +
+	"\vpieces_have\x18\x11 \x01(\x05R\n" +
+	"piecesHave\x12!\n" +
+	"\fpieces_total\x18\x12 \x01(\x05R\vpiecesTotal\x12\x18\n" +
+	"\atracker\x18\x13 \x01(\tR\atracker\x12\x16\n" +
+	"\x06labels\x18\x14 \x03(\tR\x06labels\x12%\n" +
+	"\x0equeue_priority\x18\x15 \x01(\tR\rqueuePriority\"~\n" +
+	"\rTorrentDetail\x12+\n" +
+	"\atorrent\x18\x01 \x01(\v2\x11.gonet.v1.TorrentR\atorrent\x12$\n" +
+	"\x05files\x18\x02 \x03(\v2\x0e.gonet.v1.FileR\x05files\x12\x1a\n" +
+	"\btrackers\x18\x03 \x03(\tR\btrackers\"\xb6\x01\n" +
+	"\x04File\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x16\n" +
+	"\x06length\x18\x03 \x01(\x03R\x06length\x12\x1c\n" +
+	"\tcompleted\x18\x04 \x01(\x03R\tcompleted\x12\x1a\n" +
+	"\bprogress\x18\x05 \x01(\x01R\bprogress\x122\n" +
+	"\bpriority\x18\x06 \x01(\x0e2\x16.gonet.v1.FilePriorityR\bpriority\"\x94\x03\n" +
+	"\x04Peer\x12\x12\n" +
+	"\x04addr\x18\x01 \x01(\tR\x04addr\x12\x16\n" +
+	"\x06client\x18\x02 \x01(\tR\x06client\x12\x1c\n" +
+	"\ttransport\x18\x03 \x01(\tR\ttransport\x12\x16\n" +
+	"\x06source\x18\x04 \x01(\tR\x06source\x12\x1e\n" +
+	"\n" +
+	"downloaded\x18\x05 \x01(\x03R\n" +
+	"downloaded\x12\x1a\n" +
+	"\buploaded\x18\x06 \x01(\x03R\buploaded\x12#\n" +
+	"\rdownload_rate\x18\a \x01(\x01R\fdownloadRate\x12\x1f\n" +
+	"\vupload_rate\x18\b \x01(\x01R\n" +
+	"uploadRate\x12\x1d\n" +
+	"\n" +
+	"am_choking\x18\t \x01(\bR\tamChoking\x12#\n" +
+	"\ram_interested\x18\n" +
+	" \x01(\bR\famInterested\x12!\n" +
+	"\fpeer_choking\x18\v \x01(\bR\vpeerChoking\x12'\n" +
+	"\x0fpeer_interested\x18\f \x01(\bR\x0epeerInterested\x12\x18\n" +
+	"\asnubbed\x18\r \x01(\bR\asnubbed\"\x7f\n" +
+	"\x11AddTorrentRequest\x12\x1c\n" +
+	"\bmetainfo\x18\x01 \x01(\fH\x00R\bmetainfo\x12\x18\n" +
+	"\x06magnet\x18\x02 \x01(\tH\x00R\x06magnet\x12\x16\n" +
+	"\x06labels\x18\x03 \x03(\tR\x06labels\x12\x10\n" +
+	"\x03dir\x18\x04 \x01(\tR\x03dirB\b\n" +
+	"\x06source\"^\n" +
+	"\x12AddTorrentResponse\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\x12+\n" +
+	"\atorrent\x18\x02 \x01(\v2\x11.gonet.v1.TorrentR\atorrent\"G\n" +
+	"\x10SetLabelsRequest\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\x12\x16\n" +
+	"\x06labels\x18\x02 \x03(\tR\x06labels\"^\n" +
+	"\x0fSetQueueRequest\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\x12\x12\n" +
+	"\x04move\x18\x02 \x01(\tR\x04move\x12\x1a\n" +
+	"\bpriority\x18\x03 \x01(\tR\bpriority\"\x13\n" +
+	"\x11ListLabelsRequest\"=\n" +
+	"\x12ListLabelsResponse\x12'\n" +
+	"\x06labels\x18\x01 \x03(\v2\x0f.gonet.v1.LabelR\x06labels\"9\n" +
+	"\x05Label\x12\x14\n" +
+	"\x05label\x18\x01 \x01(\tR\x05label\x12\x1a\n" +
+	"\btorrents\x18\x02 \x01(\x05R\btorrents\"P\n" +
+	"\x14RemoveTorrentRequest\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\x12\x1b\n" +
+	"\twith_data\x18\x02 \x01(\bR\bwithData\"\x17\n" +
+	"\x15RemoveTorrentResponse\"\x7f\n" +
+	"\x16SetFilePriorityRequest\x12\x1b\n" +
+	"\tinfo_hash\x18\x01 \x01(\tR\binfoHash\x12\x14\n" +
+	"\x05index\x18\x02 \x01(\x05R\x05index\x122\n" +
+	"\bpriority\x18\x03 \x01(\x0e2\x16.gonet.v1.FilePriorityR\bpriority\"9\n" +
+	"\x11ListFilesResponse\x12$\n" +
+	"\x05files\x18\x01 \x03(\v2\x0e.gonet.v1.FileR\x05files\"9\n" +
+	"\x11ListPeersResponse\x12$\n" +
+	"\x05peers\x18\x01 \x03(\v2\x0e.gonet.v1.PeerR\x05peers\"7\n" +
+	"\x14WatchTorrentsRequest\x12\x1f\n" +
+	"\vinterval_ms\x18\x01 \x01(\x03R\n" +
+	"intervalMs\"\x14\n" +
+	"\x12WatchEventsRequest\"\xc5\x01\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12$\n" +
+	"\x0etime_unix_nano\x18\x02 \x01(\x03R\ftimeUnixNano\x12\x1b\n" +
+	"\tinfo_hash\x18\x03 \x01(\tR\binfoHash\x12\x12\n" +
+	"\x04port\x18\x04 \x01(\x05R\x04port\x12#\n" +
+	"\rexternal_addr\x18\x05 \x01(\tR\fexternalAddr\x12\x14\n" +
+	"\x05error\x18\x06 \x01(\tR\x05error\x12\x16\n" +
+	"\x06missed\x18\a \x01(\x05R\x06missed*X\n" +
+	"\fFilePriority\x12\x16\n" +
+	"\x12FILE_PRIORITY_SKIP\x10\x00\x12\x18\n" +
+	"\x14FILE_PRIORITY_NORMAL\x10\x01\x12\x16\n" +
+	"\x12FILE_PRIORITY_HIGH\x10\x022\x9d\b\n" +
+	"\aSession\x12@\n" +
+	"\n" +
+	"GetSession\x12\x1b.gonet.v1.GetSessionRequest\x1a\x15.gonet.v1.SessionInfo\x12B\n" +
+	"\vSetAltSpeed\x12\x1c.gonet.v1.SetAltSpeedRequest\x1a\x15.gonet.v1.SessionInfo\x12M\n" +
+	"\fListTorrents\x12\x1d.gonet.v1.ListTorrentsRequest\x1a\x1e.gonet.v1.ListTorrentsResponse\x12?\n" +
+	"\n" +
+	"GetTorrent\x12\x18.gonet.v1.TorrentRequest\x1a\x17.gonet.v1.TorrentDetail\x12G\n" +
+	"\n" +
+	"AddTorrent\x12\x1b.gonet.v1.AddTorrentRequest\x1a\x1c.gonet.v1.AddTorrentResponse\x12P\n" +
+	"\rRemoveTorrent\x12\x1e.gonet.v1.RemoveTorrentRequest\x1a\x1f.gonet.v1.RemoveTorrentResponse\x12;\n" +
+	"\fPauseTorrent\x12\x18.gonet.v1.TorrentRequest\x1a\x11.gonet.v1.Torrent\x12<\n" +
+	"\rResumeTorrent\x12\x18.gonet.v1.TorrentRequest\x1a\x11.gonet.v1.Torrent\x12P\n" +
+	"\x0fSetFilePriority\x12 .gonet.v1.SetFilePriorityRequest\x1a\x1b.gonet.v1.ListFilesResponse\x12B\n" +
+	"\tListPeers\x12\x18.gonet.v1.TorrentRequest\x1a\x1b.gonet.v1.ListPeersResponse\x12:\n" +
+	"\tSetLabels\x12\x1a.gonet.v1.SetLabelsRequest\x1a\x11.gonet.v1.Torrent\x128\n" +
+	"\bSetQueue\x12\x19.gonet.v1.SetQueueRequest\x1a\x11.gonet.v1.Torrent\x12G\n" +
+	"\n" +
+	"ListLabels\x12\x1b.gonet.v1.ListLabelsRequest\x1a\x1c.gonet.v1.ListLabelsResponse\x12Q\n" +
+	"\rWatchTorrents\x12\x1e.gonet.v1.WatchTorrentsRequest\x1a\x1e.gonet.v1.ListTorrentsResponse0\x01\x12>\n" +
+	"\vWatchEvents\x12\x1c.gonet.v1.WatchEventsRequest\x1a\x0f.gonet.v1.Event0\x01B\x1aZ\x18mybittorrent/api/gonetpbb\x06proto3"
+
+var (
+	file_gonet_proto_rawDescOnce sync.Once
+	file_gonet_proto_rawDescData []byte
+)
+
+func file_gonet_proto_rawDescGZIP() []byte {
+	file_gonet_proto_rawDescOnce.Do(func() {
+		file_gonet_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gonet_proto_rawDesc), len(file_gonet_proto_rawDesc)))
+	})
+	return file_gonet_proto_rawDescData
+}
+
+var file_gonet_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_gonet_proto_msgTypes = make([]protoimpl.MessageInfo, 25)
+var file_gonet_proto_goTypes = []any{
+	(FilePriority)(0),              // 0: gonet.v1.FilePriority
+	(*GetSessionRequest)(nil),      // 1: gonet.v1.GetSessionRequest
+	(*SessionInfo)(nil),            // 2: gonet.v1.SessionInfo
+	(*SetAltSpeedRequest)(nil),     // 3: gonet.v1.SetAltSpeedRequest
+	(*ListTorrentsRequest)(nil),    // 4: gonet.v1.ListTorrentsRequest
+	(*ListTorrentsResponse)(nil),   // 5: gonet.v1.ListTorrentsResponse
+	(*TorrentRequest)(nil),         // 6: gonet.v1.TorrentRequest
+	(*Torrent)(nil),                // 7: gonet.v1.Torrent
+	(*TorrentDetail)(nil),          // 8: gonet.v1.TorrentDetail
+	(*File)(nil),                   // 9: gonet.v1.File
+	(*Peer)(nil),                   // 10: gonet.v1.Peer
+	(*AddTorrentRequest)(nil),      // 11: gonet.v1.AddTorrentRequest
+	(*AddTorrentResponse)(nil),     // 12: gonet.v1.AddTorrentResponse
+	(*SetLabelsRequest)(nil),       // 13: gonet.v1.SetLabelsRequest
+	(*SetQueueRequest)(nil),        // 14: gonet.v1.SetQueueRequest
+	(*ListLabelsRequest)(nil),      // 15: gonet.v1.ListLabelsRequest
+	(*ListLabelsResponse)(nil),     // 16: gonet.v1.ListLabelsResponse
+	(*Label)(nil),                  // 17: gonet.v1.Label
+	(*RemoveTorrentRequest)(nil),   // 18: gonet.v1.RemoveTorrentRequest
+	(*RemoveTorrentResponse)(nil),  // 19: gonet.v1.RemoveTorrentResponse
+	(*SetFilePriorityRequest)(nil), // 20: gonet.v1.SetFilePriorityRequest
+	(*ListFilesResponse)(nil),      // 21: gonet.v1.ListFilesResponse
+	(*ListPeersResponse)(nil),      // 22: gonet.v1.ListPeersResponse
+	(*WatchTorrentsRequest)(nil),   // 23: gonet.v1.WatchTorrentsRequest
+	(*WatchEventsRequest)(nil),     // 24: gonet.v1.WatchEventsRequest
+	(*Event)(nil),                  // 25: gonet.v1.Event
+}
+var file_gonet_proto_depIdxs = []int32{
+	7,  // 0: gonet.v1.ListTorrentsResponse.torrents:type_name -> gonet.v1.Torrent
+	7,  // 1: gonet.v1.TorrentDetail.torrent:type_name -> gonet.v1.Torrent
+	9,  // 2: gonet.v1.TorrentDetail.files:type_name -> gonet.v1.File
+	0,  // 3: gonet.v1.File.priority:type_name -> gonet.v1.FilePriority
+	7,  // 4: gonet.v1.AddTorrentResponse.torrent:type_name -> gonet.v1.Torrent
+	17, // 5: gonet.v1.ListLabelsResponse.labels:type_name -> gonet.v1.Label
+	0,  // 6: gonet.v1.SetFilePriorityRequest.priority:type_name -> gonet.v1.FilePriority
+	9,  // 7: gonet.v1.ListFilesResponse.files:type_name -> gonet.v1.File
+	10, // 8: gonet.v1.ListPeersResponse.peers:type_name -> gonet.v1.Peer
+	1,  // 9: gonet.v1.Session.GetSession:input_type -> gonet.v1.GetSessionRequest
+	3,  // 10: gonet.v1.Session.SetAltSpeed:input_type -> gonet.v1.SetAltSpeedRequest
+	4,  // 11: gonet.v1.Session.ListTorrents:input_type -> gonet.v1.ListTorrentsRequest
+	6,  // 12: gonet.v1.Session.GetTorrent:input_type -> gonet.v1.TorrentRequest
+	11, // 13: gonet.v1.Session.AddTorrent:input_type -> gonet.v1.AddTorrentRequest
+	18, // 14: gonet.v1.Session.RemoveTorrent:input_type -> gonet.v1.RemoveTorrentRequest
+	6,  // 15: gonet.v1.Session.PauseTorrent:input_type -> gonet.v1.TorrentRequest
+	6,  // 16: gonet.v1.Session.ResumeTorrent:input_type -> gonet.v1.TorrentRequest
+	20, // 17: gonet.v1.Session.SetFilePriority:input_type -> gonet.v1.SetFilePriorityRequest
+	6,  // 18: gonet.v1.Session.ListPeers:input_type -> gonet.v1.TorrentRequest
+	13, // 19: gonet.v1.Session.SetLabels:input_type -> gonet.v1.SetLabelsRequest
+	14, // 20: gonet.v1.Session.SetQueue:input_type -> gonet.v1.SetQueueRequest
+	15, // 21: gonet.v1.Session.ListLabels:input_type -> gonet.v1.ListLabelsRequest
+	23, // 22: gonet.v1.Session.WatchTorrents:input_type -> gonet.v1.WatchTorrentsRequest
+	24, // 23: gonet.v1.Session.WatchEvents:input_type -> gonet.v1.WatchEventsRequest
+	2,  // 24: gonet.v1.Session.GetSession:output_type -> gonet.v1.SessionInfo
+	2,  // 25: gonet.v1.Session.SetAltSpeed:output_type -> gonet.v1.SessionInfo
+	5,  // 26: gonet.v1.Session.ListTorrents:output_type -> gonet.v1.ListTorrentsResponse
+	8,  // 27: gonet.v1.Session.GetTorrent:output_type -> gonet.v1.TorrentDetail
+	12, // 28: gonet.v1.Session.AddTorrent:output_type -> gonet.v1.AddTorrentResponse
+	19, // 29: gonet.v1.Session.RemoveTorrent:output_type -> gonet.v1.RemoveTorrentResponse
+	7,  // 30: gonet.v1.Session.PauseTorrent:output_type -> gonet.v1.Torrent
+	7,  // 31: gonet.v1.Session.ResumeTorrent:output_type -> gonet.v1.Torrent
+	21, // 32: gonet.v1.Session.SetFilePriority:output_type -> gonet.v1.ListFilesResponse
+	22, // 33: gonet.v1.Session.ListPeers:output_type -> gonet.v1.ListPeersResponse
+	7,  // 34: gonet.v1.Session.SetLabels:output_type -> gonet.v1.Torrent
+	7,  // 35: gonet.v1.Session.SetQueue:output_type -> gonet.v1.Torrent
+	16, // 36: gonet.v1.Session.ListLabels:output_type -> gonet.v1.ListLabelsResponse
+	5,  // 37: gonet.v1.Session.WatchTorrents:output_type -> gonet.v1.ListTorrentsResponse
+	25, // 38: gonet.v1.Session.WatchEvents:output_type -> gonet.v1.Event
+	24, // [24:39] is the sub-list for method output_type
+	9,  // [9:24] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_gonet_proto_init() }
+func file_gonet_proto_init() {
+	if File_gonet_proto != nil {
+		return
+	}
+	file_gonet_proto_msgTypes[10].OneofWrappers = []any{
+		(*AddTorrentRequest_Metainfo)(nil),
+		(*AddTorrentRequest_Magnet)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gonet_proto_rawDesc), len(file_gonet_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   25,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_gonet_proto_goTypes,
+		DependencyIndexes: file_gonet_proto_depIdxs,
+		EnumInfos:         file_gonet_proto_enumTypes,
+		MessageInfos:      file_gonet_proto_msgTypes,
+	}.Build()
+	File_gonet_proto = out.File
+	file_gonet_proto_goTypes = nil
+	file_gonet_proto_depIdxs = nil
+}
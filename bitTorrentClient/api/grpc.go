@@ -0,0 +1,444 @@
+// This file answers the gRPC contract in gonet.proto, the typed twin of the REST API. Every
+// call does what its REST twin does and answers with the same shapes converted to the
+// generated messages, errors carry the status code that fits them like writeError picks the
+// HTTP status. WatchTorrents and WatchEvents are the event stream split in two. The REST
+// credentials are checked by the interceptors of RequireAuthGRPC, taken from the
+// "authorization" metadata in the same form as the header
+
+//go:generate protoc --go_out=gonetpb --go_opt=paths=source_relative --go-grpc_out=gonetpb --go-grpc_opt=paths=source_relative gonet.proto
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	bt "mybittorrent"
+	"mybittorrent/api/gonetpb"
+)
+
+// GRPCServer answers the gRPC API for one client. Register it on a grpc.Server with
+// gonetpb.RegisterSessionServer
+type GRPCServer struct {
+	gonetpb.UnimplementedSessionServer
+	client *bt.Client
+
+	// ctx bounds the magnet links being added in the background and the streams, Close
+	// cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewGRPCServer returns the gRPC API of client
+func NewGRPCServer(client *bt.Client) *GRPCServer {
+	s := &GRPCServer{client: client}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Close ends the streams, gives up on the magnet links still being added and waits for
+// them. It doesn't close the client
+func (s *GRPCServer) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// RequireAuthGRPC returns the server options that let only the calls carrying creds through,
+// the others fail with Unauthenticated
+func RequireAuthGRPC(creds Credentials) ([]grpc.ServerOption, error) {
+	if err := creds.validate(); err != nil {
+		return nil, err
+	}
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) != 1 || !creds.matchAuthorization(values[0]) {
+			return status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return nil
+	}
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := check(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	return []grpc.ServerOption{grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream)}, nil
+}
+
+// grpcError returns err with the status code that fits it
+func grpcError(err error) error {
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, errBadRequest):
+		code = codes.InvalidArgument
+	case errors.Is(err, bt.ErrTorrentUnknown):
+		code = codes.NotFound
+	case errors.Is(err, bt.ErrTorrentExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, bt.ErrClientClosed):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
+
+// download returns the torrent infoHash names
+func (s *GRPCServer) download(infoHash string) (*bt.Download, error) {
+	hash, err := parseInfoHash(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := s.client.Torrent(hash)
+	if !ok {
+		return nil, bt.ErrTorrentUnknown
+	}
+	return d, nil
+}
+
+func (s *GRPCServer) GetSession(ctx context.Context, req *gonetpb.GetSessionRequest) (*gonetpb.SessionInfo, error) {
+	return pbSession(NewSession(s.client)), nil
+}
+
+func (s *GRPCServer) SetAltSpeed(ctx context.Context, req *gonetpb.SetAltSpeedRequest) (*gonetpb.SessionInfo, error) {
+	s.client.SetAltSpeed(req.AltSpeed)
+	return pbSession(NewSession(s.client)), nil
+}
+
+func (s *GRPCServer) ListTorrents(ctx context.Context, req *gonetpb.ListTorrentsRequest) (*gonetpb.ListTorrentsResponse, error) {
+	downloads := s.client.Torrents()
+	if req.Label != "" {
+		downloads = s.client.TorrentsLabelled(req.Label)
+	}
+	return pbTorrents(downloads), nil
+}
+
+func (s *GRPCServer) GetTorrent(ctx context.Context, req *gonetpb.TorrentRequest) (*gonetpb.TorrentDetail, error) {
+	d, err := s.download(req.InfoHash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &gonetpb.TorrentDetail{
+		Torrent:  pbTorrent(NewTorrent(d)),
+		Files:    pbFiles(d),
+		Trackers: d.Torrent.Magnet().Trackers,
+	}, nil
+}
+
+func (s *GRPCServer) AddTorrent(ctx context.Context, req *gonetpb.AddTorrentRequest) (*gonetpb.AddTorrentResponse, error) {
+	opts := []bt.AddOption{bt.WithSaveDir(req.Dir), bt.WithLabels(req.Labels...)}
+	switch source := req.Source.(type) {
+	case *gonetpb.AddTorrentRequest_Metainfo:
+		if len(source.Metainfo) > maxTorrentSize {
+			return nil, status.Error(codes.InvalidArgument, "torrent is too large")
+		}
+		t, err := bt.DecodeTorrent(bytes.NewReader(source.Metainfo))
+		if err != nil {
+			return nil, grpcError(fmt.Errorf("%w: %v", errBadRequest, err))
+		}
+		d, err := s.client.AddTorrent(t, opts...)
+		if d == nil {
+			return nil, grpcError(err)
+		}
+		// a torrent that failed to start is in the session all the same, its state says why
+		return &gonetpb.AddTorrentResponse{InfoHash: fmt.Sprintf("%x", d.InfoHash), Torrent: pbTorrent(NewTorrent(d))}, nil
+	case *gonetpb.AddTorrentRequest_Magnet:
+		m, err := bt.ParseMagnet(source.Magnet)
+		if err != nil {
+			return nil, grpcError(fmt.Errorf("%w: %v", errBadRequest, err))
+		}
+		if _, ok := s.client.Torrent(m.InfoHash); ok {
+			return nil, grpcError(bt.ErrTorrentExists)
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			// a failure is published on the client's event bus
+			s.client.AddMagnet(s.ctx, source.Magnet, opts...)
+		}()
+		return &gonetpb.AddTorrentResponse{InfoHash: fmt.Sprintf("%x", m.InfoHash)}, nil
+	}
+	return nil, status.Error(codes.InvalidArgument, "metainfo or magnet is missing")
+}
+
+func (s *GRPCServer) RemoveTorrent(ctx context.Context, req *gonetpb.RemoveTorrentRequest) (*gonetpb.RemoveTorrentResponse, error) {
+	infoHash, err := parseInfoHash(req.InfoHash)
+	if err == nil {
+		// a magnet link still fetching its metadata is removed too, like over REST
+		err = s.client.Remove(infoHash, req.WithData)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &gonetpb.RemoveTorrentResponse{}, nil
+}
+
+func (s *GRPCServer) PauseTorrent(ctx context.Context, req *gonetpb.TorrentRequest) (*gonetpb.Torrent, error) {
+	d, err := s.download(req.InfoHash)
+	if err == nil {
+		err = d.Pause()
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbTorrent(NewTorrent(d)), nil
+}
+
+func (s *GRPCServer) ResumeTorrent(ctx context.Context, req *gonetpb.TorrentRequest) (*gonetpb.Torrent, error) {
+	d, err := s.download(req.InfoHash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if d.State() != bt.DownloadPaused {
+		return nil, status.Error(codes.FailedPrecondition, "torrent is not paused")
+	}
+	err = d.Resume()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbTorrent(NewTorrent(d)), nil
+}
+
+func (s *GRPCServer) SetFilePriority(ctx context.Context, req *gonetpb.SetFilePriorityRequest) (*gonetpb.ListFilesResponse, error) {
+	d, err := s.download(req.InfoHash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var prio bt.FilePriority
+	switch req.Priority {
+	case gonetpb.FilePriority_FILE_PRIORITY_SKIP:
+		prio = bt.FileSkip
+	case gonetpb.FilePriority_FILE_PRIORITY_NORMAL:
+		prio = bt.FileNormal
+	case gonetpb.FilePriority_FILE_PRIORITY_HIGH:
+		prio = bt.FileHigh
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown priority %v", req.Priority)
+	}
+	err = d.SetFilePriority(int(req.Index), prio)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &gonetpb.ListFilesResponse{Files: pbFiles(d)}, nil
+}
+
+func (s *GRPCServer) ListPeers(ctx context.Context, req *gonetpb.TorrentRequest) (*gonetpb.ListPeersResponse, error) {
+	d, err := s.download(req.InfoHash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &gonetpb.ListPeersResponse{}
+	for _, p := range d.Peers() {
+		resp.Peers = append(resp.Peers, pbPeer(newPeer(p.Stats())))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) SetLabels(ctx context.Context, req *gonetpb.SetLabelsRequest) (*gonetpb.Torrent, error) {
+	d, err := s.download(req.InfoHash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	d.SetLabels(req.Labels)
+	return pbTorrent(NewTorrent(d)), nil
+}
+
+func (s *GRPCServer) SetQueue(ctx context.Context, req *gonetpb.SetQueueRequest) (*gonetpb.Torrent, error) {
+	d, err := s.download(req.InfoHash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	var move bt.QueueMove
+	if req.Move != "" {
+		move, err = bt.ParseQueueMove(req.Move)
+	}
+	var prio bt.QueuePriority
+	if err == nil && req.Priority != "" {
+		prio, err = bt.ParseQueuePriority(req.Priority)
+	}
+	if err == nil && req.Move == "" && req.Priority == "" {
+		err = errors.New("move or priority is missing")
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the priority first, the move is among the torrents of the new one
+	if req.Priority != "" {
+		err = s.client.SetQueuePriority(d.InfoHash, prio)
+	}
+	if err == nil && req.Move != "" {
+		err = s.client.MoveInQueue(d.InfoHash, move)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbTorrent(NewTorrent(d)), nil
+}
+
+func (s *GRPCServer) ListLabels(ctx context.Context, req *gonetpb.ListLabelsRequest) (*gonetpb.ListLabelsResponse, error) {
+	resp := &gonetpb.ListLabelsResponse{}
+	for _, label := range s.client.Labels() {
+		resp.Labels = append(resp.Labels, &gonetpb.Label{Label: label, Torrents: int32(len(s.client.TorrentsLabelled(label)))})
+	}
+	return resp, nil
+}
+
+// WatchTorrents sends the list right away and then every interval, no shorter than
+// minStatsInterval
+func (s *GRPCServer) WatchTorrents(req *gonetpb.WatchTorrentsRequest, stream grpc.ServerStreamingServer[gonetpb.ListTorrentsResponse]) error {
+	ticker := time.NewTicker(max(time.Duration(req.IntervalMs)*time.Millisecond, minStatsInterval))
+	defer ticker.Stop()
+	for {
+		err := stream.Send(pbTorrents(s.client.Torrents()))
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+func (s *GRPCServer) WatchEvents(req *gonetpb.WatchEventsRequest, stream grpc.ServerStreamingServer[gonetpb.Event]) error {
+	ctx := stream.Context()
+	events := make(chan bt.SessionEvent, bt.DefaultEventBuffer)
+	unsubscribe := s.client.Events().Subscribe(0, func(ev bt.SessionEvent) {
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	})
+	defer unsubscribe()
+	// the headers go out now, so the caller knows it is subscribed before the first event
+	err := stream.SendHeader(nil)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case ev := <-events:
+			err := stream.Send(pbEvent(NewEvent(ev)))
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+func pbSession(s Session) *gonetpb.SessionInfo {
+	return &gonetpb.SessionInfo{
+		Port:             int32(s.Port),
+		DownloadRate:     s.DownloadRate,
+		UploadRate:       s.UploadRate,
+		Torrents:         int32(s.Torrents),
+		DownloadLimit:    s.DownloadLimit,
+		UploadLimit:      s.UploadLimit,
+		AltSpeed:         s.AltSpeed,
+		AltDownloadLimit: s.AltDownloadLimit,
+		AltUploadLimit:   s.AltUploadLimit,
+	}
+}
+
+func pbTorrents(downloads []*bt.Download) *gonetpb.ListTorrentsResponse {
+	resp := &gonetpb.ListTorrentsResponse{}
+	for _, d := range downloads {
+		resp.Torrents = append(resp.Torrents, pbTorrent(NewTorrent(d)))
+	}
+	return resp
+}
+
+func pbTorrent(t Torrent) *gonetpb.Torrent {
+	return &gonetpb.Torrent{
+		InfoHash:      t.InfoHash,
+		Name:          t.Name,
+		State:         t.State,
+		Error:         t.Error,
+		Size:          t.Size,
+		Left:          t.Left,
+		Progress:      t.Progress,
+		Downloaded:    t.Downloaded,
+		Uploaded:      t.Uploaded,
+		DownloadRate:  t.DownloadRate,
+		UploadRate:    t.UploadRate,
+		Ratio:         t.Ratio,
+		Eta:           t.ETA,
+		Peers:         int32(t.Peers),
+		Seeds:         int32(t.Seeds),
+		Leechers:      int32(t.Leechers),
+		PiecesHave:    int32(t.PiecesHave),
+		PiecesTotal:   int32(t.PiecesTotal),
+		Tracker:       t.Tracker,
+		Labels:        t.Labels,
+		QueuePriority: t.QueuePriority,
+	}
+}
+
+func pbFiles(d *bt.Download) []*gonetpb.File {
+	var files []*gonetpb.File
+	for _, f := range d.FileProgress() {
+		files = append(files, &gonetpb.File{
+			Index:     int32(f.Index),
+			Path:      f.Path,
+			Length:    f.Length,
+			Completed: f.Completed,
+			Progress:  f.Progress(),
+			// the enum's values are the client's priorities
+			Priority: gonetpb.FilePriority(f.Priority),
+		})
+	}
+	return files
+}
+
+func pbPeer(p Peer) *gonetpb.Peer {
+	return &gonetpb.Peer{
+		Addr:           p.Addr,
+		Client:         p.Client,
+		Transport:      p.Transport,
+		Source:         p.Source,
+		Downloaded:     p.Downloaded,
+		Uploaded:       p.Uploaded,
+		DownloadRate:   p.DownloadRate,
+		UploadRate:     p.UploadRate,
+		AmChoking:      p.AmChoking,
+		AmInterested:   p.AmInterested,
+		PeerChoking:    p.PeerChoking,
+		PeerInterested: p.PeerInterested,
+		Snubbed:        p.Snubbed,
+	}
+}
+
+func pbEvent(e Event) *gonetpb.Event {
+	return &gonetpb.Event{
+		Type:         e.Type,
+		TimeUnixNano: e.Time.UnixNano(),
+		InfoHash:     e.InfoHash,
+		Port:         int32(e.Port),
+		ExternalAddr: e.ExternalAddr,
+		Error:        e.Error,
+		Missed:       int32(e.Missed),
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	bt "mybittorrent"
+	"mybittorrent/api/gonetpb"
+	"mybittorrent/internal/bencode"
+	"mybittorrent/peertest"
+)
+
+// this function serves the gRPC API of a fresh client in memory and returns a client of it
+func newGRPCTest(t *testing.T, creds *Credentials) gonetpb.SessionClient {
+	t.Helper()
+	client, err := bt.NewClient(
+		bt.WithListenHost("127.0.0.1"),
+		bt.WithListenPort(0),
+		bt.WithDHT(false),
+		bt.WithPortMapping(false),
+		bt.WithDownloadDir(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	var opts []grpc.ServerOption
+	if creds != nil {
+		opts, err = RequireAuthGRPC(*creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := grpc.NewServer(opts...)
+	s := NewGRPCServer(client)
+	gonetpb.RegisterSessionServer(server, s)
+	ln := bufconn.Listen(1 << 20)
+	go server.Serve(ln)
+	t.Cleanup(func() {
+		s.Close()
+		server.Stop()
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return gonetpb.NewSessionClient(conn)
+}
+
+// this function returns a .torrent of a few random pieces and its infohash in hex
+func testMetainfo(t *testing.T) ([]byte, string) {
+	t.Helper()
+	const pieceLength = 16 << 10
+	_, hashes := peertest.RandomPieces(4, pieceLength, 0)
+	info := map[string]interface{}{
+		"name":         "grpc",
+		"piece length": int64(pieceLength),
+		"pieces":       hashes,
+		"length":       int64(4 * pieceLength),
+	}
+	metainfo, err := bencode.Encode(map[string]interface{}{
+		"announce": "http://127.0.0.1:1/announce",
+		"info":     info,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := bt.DecodeTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metainfo, fmt.Sprintf("%x", torrent.InfoHash)
+}
+
+func TestGRPCSession(t *testing.T) {
+	c := newGRPCTest(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, err := c.WatchEvents(ctx, &gonetpb.WatchEventsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server sends its headers once it is subscribed
+	if _, err := events.Header(); err != nil {
+		t.Fatal(err)
+	}
+
+	metainfo, infoHash := testMetainfo(t)
+	added, err := c.AddTorrent(ctx, &gonetpb.AddTorrentRequest{
+		Source: &gonetpb.AddTorrentRequest_Metainfo{Metainfo: metainfo},
+		Labels: []string{"test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.InfoHash != infoHash || added.Torrent.GetName() != "grpc" {
+		t.Fatalf("added %s %q, want %s grpc", added.InfoHash, added.Torrent.GetName(), infoHash)
+	}
+	ev, err := events.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != "torrent_added" || ev.InfoHash != infoHash {
+		t.Errorf("first event is %s about %s, want torrent_added about %s", ev.Type, ev.InfoHash, infoHash)
+	}
+
+	_, err = c.AddTorrent(ctx, &gonetpb.AddTorrentRequest{Source: &gonetpb.AddTorrentRequest_Metainfo{Metainfo: metainfo}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("adding it again: %v, want AlreadyExists", err)
+	}
+	detail, err := c.GetTorrent(ctx, &gonetpb.TorrentRequest{InfoHash: infoHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Files) != 1 || detail.Files[0].Priority != gonetpb.FilePriority_FILE_PRIORITY_NORMAL {
+		t.Errorf("files are %v, want one of normal priority", detail.Files)
+	}
+	files, err := c.SetFilePriority(ctx, &gonetpb.SetFilePriorityRequest{InfoHash: infoHash, Priority: gonetpb.FilePriority_FILE_PRIORITY_HIGH})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.Files[0].Priority != gonetpb.FilePriority_FILE_PRIORITY_HIGH {
+		t.Errorf("file priority is %v after setting it high", files.Files[0].Priority)
+	}
+	labels, err := c.ListLabels(ctx, &gonetpb.ListLabelsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels.Labels) != 1 || labels.Labels[0].Label != "test" || labels.Labels[0].Torrents != 1 {
+		t.Errorf("labels are %v, want test on one torrent", labels.Labels)
+	}
+	_, err = c.GetTorrent(ctx, &gonetpb.TorrentRequest{InfoHash: "nope"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad infohash: %v, want InvalidArgument", err)
+	}
+
+	watch, err := c.WatchTorrents(ctx, &gonetpb.WatchTorrentsRequest{IntervalMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		list, err := watch.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Torrents) != 1 || list.Torrents[0].InfoHash != infoHash {
+			t.Fatalf("watched %v, want the torrent added", list.Torrents)
+		}
+	}
+
+	_, err = c.RemoveTorrent(ctx, &gonetpb.RemoveTorrentRequest{InfoHash: infoHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetTorrent(ctx, &gonetpb.TorrentRequest{InfoHash: infoHash})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("removed torrent: %v, want NotFound", err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	c := newGRPCTest(t, &Credentials{Username: "user", Password: "pass", Token: "token"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		authorization string
+		ok            bool
+	}{
+		{"", false},
+		{"Bearer wrong", false},
+		{"Bearer token", true},
+		{"Basic dXNlcjpwYXNz", true},  // user:pass
+		{"Basic dXNlcjpub3Bl", false}, // user:nope
+	}
+	for _, tt := range tests {
+		callCtx := ctx
+		if tt.authorization != "" {
+			callCtx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.authorization)
+		}
+		_, err := c.GetSession(callCtx, &gonetpb.GetSessionRequest{})
+		if (err == nil) != tt.ok || (err != nil && status.Code(err) != codes.Unauthenticated) {
+			t.Errorf("GetSession with %q: %v, want ok %v", tt.authorization, err, tt.ok)
+		}
+		// streams are checked too, a refused one ends before its first message
+		if tt.ok {
+			continue
+		}
+		stream, err := c.WatchEvents(callCtx, &gonetpb.WatchEventsRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("WatchEvents with %q: %v, want Unauthenticated", tt.authorization, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// This file exposes the session in the Prometheus text format on /metrics, so a seedbox is
+// monitored with the stack its operator already runs. Session wide numbers have no labels,
+// per torrent ones are labeled with the infohash and the name. Counters end in _total and
+// reset when the torrent is added again, Prometheus copes with that. Everything is read
+// when scraped, nothing is kept between scrapes
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	bt "mybittorrent"
+)
+
+// MetricsHandler serves the metrics of client on its own, for a listener apart from the API
+func MetricsHandler(client *bt.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, client)
+	})
+}
+
+// a metric family and how to read it from a torrent
+type torrentMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(d *bt.Download, stats bt.DownloadStats, disk bt.DiskStats) float64
+}
+
+var torrentMetrics = []torrentMetric{
+	{"gonet_torrent_size_bytes", "gauge", "Size of the torrent.",
+		func(d *bt.Download, _ bt.DownloadStats, _ bt.DiskStats) float64 {
+			return float64(d.Torrent.TotalLength())
+		}},
+	{"gonet_torrent_left_bytes", "gauge", "Bytes of wanted pieces still missing.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.Left) }},
+	{"gonet_torrent_downloaded_bytes_total", "counter", "Bytes downloaded from peers.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.Downloaded) }},
+	{"gonet_torrent_uploaded_bytes_total", "counter", "Bytes uploaded to peers.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.Uploaded) }},
+	{"gonet_torrent_wasted_bytes_total", "counter", "Downloaded bytes thrown away, duplicates and failed pieces.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.Wasted) }},
+	{"gonet_torrent_download_rate_bytes", "gauge", "Smoothed download rate in bytes per second.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return s.DownloadRate }},
+	{"gonet_torrent_upload_rate_bytes", "gauge", "Smoothed upload rate in bytes per second.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return s.UploadRate }},
+	{"gonet_torrent_peers", "gauge", "Connected peers.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.Peers) }},
+	{"gonet_torrent_seeds", "gauge", "Connected peers that have the whole torrent.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.Seeds) }},
+	{"gonet_torrent_known_peers", "gauge", "Peer addresses known from every source.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.KnownPeers) }},
+	{"gonet_torrent_pieces", "gauge", "Verified pieces.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.PiecesHave) }},
+	{"gonet_torrent_hash_failures_total", "counter", "Pieces that failed their hash check.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.HashFailures) }},
+	{"gonet_tracker_announce_errors_total", "counter", "Tracker announces that failed.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.AnnounceErrors) }},
+	{"gonet_tracker_seeders", "gauge", "Seeders the tracker last reported.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.TrackerSeeds) }},
+	{"gonet_tracker_leechers", "gauge", "Leechers the tracker last reported.",
+		func(_ *bt.Download, s bt.DownloadStats, _ bt.DiskStats) float64 { return float64(s.TrackerLeechers) }},
+	{"gonet_disk_queued_writes", "gauge", "Pieces waiting to be written.",
+		func(_ *bt.Download, _ bt.DownloadStats, disk bt.DiskStats) float64 { return float64(disk.QueuedWrites) }},
+	{"gonet_disk_queued_bytes", "gauge", "Bytes waiting to be written.",
+		func(_ *bt.Download, _ bt.DownloadStats, disk bt.DiskStats) float64 { return float64(disk.QueuedBytes) }},
+	{"gonet_disk_written_bytes_total", "counter", "Bytes written to storage.",
+		func(_ *bt.Download, _ bt.DownloadStats, disk bt.DiskStats) float64 { return float64(disk.Written) }},
+}
+
+// the states a torrent can be in, every one gets a gonet_torrents sample even at zero
+var torrentStates = []bt.DownloadState{
+	bt.DownloadStopped, bt.DownloadChecking, bt.DownloadDownloading,
+	bt.DownloadSeeding, bt.DownloadPaused, bt.DownloadError, bt.DownloadQueued,
+}
+
+func writeMetrics(w io.Writer, client *bt.Client) error {
+	type snapshot struct {
+		d     *bt.Download
+		stats bt.DownloadStats
+		disk  bt.DiskStats
+	}
+	var torrents []snapshot
+	byState := make(map[bt.DownloadState]int)
+	for _, d := range client.Torrents() {
+		s := snapshot{d: d, stats: d.Stats(), disk: d.DiskStats()}
+		torrents = append(torrents, s)
+		byState[s.stats.State]++
+	}
+
+	var b strings.Builder
+	family(&b, "gonet_session_download_rate_bytes", "gauge", "Smoothed download rate of the session in bytes per second.")
+	sample(&b, "gonet_session_download_rate_bytes", "", client.DownloadRate())
+	family(&b, "gonet_session_upload_rate_bytes", "gauge", "Smoothed upload rate of the session in bytes per second.")
+	sample(&b, "gonet_session_upload_rate_bytes", "", client.UploadRate())
+	family(&b, "gonet_session_download_limit_bytes", "gauge", "Session download limit in bytes per second, 0 is unlimited.")
+	sample(&b, "gonet_session_download_limit_bytes", "", float64(client.Limiter().DownloadLimit()))
+	family(&b, "gonet_session_upload_limit_bytes", "gauge", "Session upload limit in bytes per second, 0 is unlimited.")
+	sample(&b, "gonet_session_upload_limit_bytes", "", float64(client.Limiter().UploadLimit()))
+	altSpeed := 0.0
+	if client.AltSpeed() {
+		altSpeed = 1
+	}
+	family(&b, "gonet_session_alt_speed", "gauge", "1 while the alternative speed limits are on.")
+	sample(&b, "gonet_session_alt_speed", "", altSpeed)
+	family(&b, "gonet_session_connections", "gauge", "Peer connections of every torrent together.")
+	sample(&b, "gonet_session_connections", "", float64(client.Connections().Count()))
+	family(&b, "gonet_torrents", "gauge", "Torrents in the session by state.")
+	for _, state := range torrentStates {
+		sample(&b, "gonet_torrents", labels("state", state.String()), float64(byState[state]))
+	}
+	if node := client.DHT(); node != nil {
+		family(&b, "gonet_dht_nodes", "gauge", "Nodes in the DHT routing table.")
+		sample(&b, "gonet_dht_nodes", "", float64(node.Nodes()))
+	}
+
+	for _, m := range torrentMetrics {
+		if len(torrents) == 0 {
+			break
+		}
+		family(&b, m.name, m.kind, m.help)
+		for _, t := range torrents {
+			l := labels("infohash", fmt.Sprintf("%x", t.d.InfoHash), "name", t.d.Torrent.Info.Name)
+			sample(&b, m.name, l, m.value(t.d, t.stats, t.disk))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func family(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(b *strings.Builder, name, labels string, value float64) {
+	fmt.Fprintf(b, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// labels formats name and value pairs as a label set, escaping the values
+func labels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,79 @@
+// This file serves a torrent's files over HTTP while they download, so VLC, mpv or a browser
+// can play a video from the first pieces on:
+//
+//	GET /stream/{infohash}/{index}[/name]
+//
+// index is the file's index as in /api/v1/torrents/{infohash}/files, a trailing name is
+// ignored but lets players that go by the URL see the file's extension. Range requests are
+// answered with the bytes asked for, the pieces under them are fetched ahead of the rest
+// through a bt.Reader, and a read of a piece that isn't there yet waits for it. A skipped
+// file is downloaded as far as it is played. The stream ends when the player hangs up
+// or the API is closed
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+func (s *Server) streamFile(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: file index %q is not a number", errBadRequest, r.PathValue("index")))
+		return
+	}
+	name := ""
+	for _, f := range d.FileProgress() {
+		if f.Index == index {
+			name = filepath.Base(f.Path)
+		}
+	}
+	if name == "" {
+		writeError(w, fmt.Errorf("%w: no file %d", errBadRequest, index))
+		return
+	}
+	reader, err := d.NewReader(index)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	defer reader.Close()
+	// a read waiting for a piece gives up when the player hangs up or the API is closed
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(s.ctx, cancel)()
+	reader.SetContext(ctx)
+
+	// ServeContent would sniff the type from the first bytes otherwise, waiting for the
+	// first piece before answering a HEAD
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	http.ServeContent(flushHeader{w}, r, name, time.Time{}, reader)
+}
+
+// flushHeader sends the status and headers as soon as they are written, so a player knows
+// the length and that it can seek before the first piece arrives
+type flushHeader struct {
+	http.ResponseWriter
+}
+
+func (w flushHeader) WriteHeader(status int) {
+	w.ResponseWriter.WriteHeader(status)
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
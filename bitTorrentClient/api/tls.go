@@ -0,0 +1,118 @@
+// This file provides the certificates the API is served over TLS with: the operator's own,
+// or a self-signed one made on first use and kept, so clients that pinned it keep working
+// across restarts. The SHA-256 fingerprint is what such a client checks
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how long a self-signed certificate is valid for
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// LoadOrCreateCertificate returns the certificate in certFile and keyFile. When neither
+// file exists a self-signed certificate for hosts is made and written to them first, the
+// key readable by the owner only. hosts are names or IP addresses, localhost is always in
+func LoadOrCreateCertificate(certFile, keyFile string, hosts []string) (tls.Certificate, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if errors.Is(certErr, fs.ErrNotExist) && errors.Is(keyErr, fs.ErrNotExist) {
+		certPEM, keyPEM, err := SelfSignedCertificate(hosts)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		err = writeKeyPair(certFile, certPEM, keyFile, keyPEM)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("api certificate: %w", err)
+	}
+	return cert, nil
+}
+
+func writeKeyPair(certFile string, certPEM []byte, keyFile string, keyPEM []byte) error {
+	for _, f := range []struct {
+		path string
+		data []byte
+		perm fs.FileMode
+	}{{keyFile, keyPEM, 0o600}, {certFile, certPEM, 0o644}} {
+		err := os.MkdirAll(filepath.Dir(f.path), 0o700)
+		if err == nil {
+			err = os.WriteFile(f.path, f.data, f.perm)
+		}
+		if err != nil {
+			return fmt.Errorf("writing the api certificate: %w", err)
+		}
+	}
+	return nil
+}
+
+// SelfSignedCertificate makes an ECDSA P-256 certificate for hosts and localhost, signed by
+// its own key, and returns it and the key PEM encoded
+func SelfSignedCertificate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "gonet-bt"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range append([]string{"localhost", "127.0.0.1", "::1"}, hosts...) {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert's leaf in hex
+func Fingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
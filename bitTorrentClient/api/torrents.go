@@ -0,0 +1,485 @@
+// This file holds the handlers for the session and its torrents, and the JSON shapes they
+// answer with. Rates are in bytes per second, durations in seconds
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	bt "mybittorrent"
+)
+
+// the largest .torrent accepted, big enough for torrents of a few hundred thousand pieces
+const maxTorrentSize = 32 << 20
+
+type Session struct {
+	Port         int     `json:"port"`
+	DownloadRate float64 `json:"download_rate"`
+	UploadRate   float64 `json:"upload_rate"`
+	Torrents     int     `json:"torrents"`
+	// the limits in effect, zero is unlimited. AltSpeed tells whether they are the
+	// alternative ones
+	DownloadLimit    int64 `json:"download_limit"`
+	UploadLimit      int64 `json:"upload_limit"`
+	AltSpeed         bool  `json:"alt_speed"`
+	AltDownloadLimit int64 `json:"alt_download_limit"`
+	AltUploadLimit   int64 `json:"alt_upload_limit"`
+}
+
+type Torrent struct {
+	InfoHash     string  `json:"info_hash"`
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Error        string  `json:"error,omitempty"`
+	Size         int64   `json:"size"`
+	Left         int64   `json:"left"`
+	Progress     float64 `json:"progress"`
+	Downloaded   int64   `json:"downloaded"`
+	Uploaded     int64   `json:"uploaded"`
+	DownloadRate float64 `json:"download_rate"`
+	UploadRate   float64 `json:"upload_rate"`
+	Ratio        float64 `json:"ratio"`
+	// ETA is -1 when nothing is coming in
+	ETA         int64    `json:"eta"`
+	Peers       int      `json:"peers"`
+	Seeds       int      `json:"seeds"`
+	Leechers    int      `json:"leechers"`
+	PiecesHave  int      `json:"pieces_have"`
+	PiecesTotal int      `json:"pieces_total"`
+	Tracker     string   `json:"tracker,omitempty"`
+	Labels      []string `json:"labels"`
+	// QueuePriority is low, normal or high. Lists come in queue order
+	QueuePriority string `json:"queue_priority"`
+}
+
+// Label is a label in use with the number of torrents that have it
+type Label struct {
+	Label    string `json:"label"`
+	Torrents int    `json:"torrents"`
+}
+
+// TorrentDetail is a torrent with its files and trackers
+type TorrentDetail struct {
+	Torrent
+	Files    []File   `json:"files"`
+	Trackers []string `json:"trackers"`
+}
+
+type File struct {
+	Index     int     `json:"index"`
+	Path      string  `json:"path"`
+	Length    int64   `json:"length"`
+	Completed int64   `json:"completed"`
+	Progress  float64 `json:"progress"`
+	Priority  string  `json:"priority"`
+}
+
+type Peer struct {
+	Addr           string  `json:"addr"`
+	Client         string  `json:"client"`
+	Transport      string  `json:"transport"`
+	Source         string  `json:"source"`
+	Downloaded     int64   `json:"downloaded"`
+	Uploaded       int64   `json:"uploaded"`
+	DownloadRate   float64 `json:"download_rate"`
+	UploadRate     float64 `json:"upload_rate"`
+	AmChoking      bool    `json:"am_choking"`
+	AmInterested   bool    `json:"am_interested"`
+	PeerChoking    bool    `json:"peer_choking"`
+	PeerInterested bool    `json:"peer_interested"`
+	Snubbed        bool    `json:"snubbed"`
+}
+
+// NewTorrent returns the JSON shape of d
+func NewTorrent(d *bt.Download) Torrent {
+	stats := d.Stats()
+	size := d.Torrent.TotalLength()
+	t := Torrent{
+		InfoHash:     fmt.Sprintf("%x", d.InfoHash),
+		Name:         d.Torrent.Info.Name,
+		State:        stats.State.String(),
+		Size:         size,
+		Left:         stats.Left,
+		Progress:     1,
+		Downloaded:   stats.Downloaded,
+		Uploaded:     stats.Uploaded,
+		DownloadRate: stats.DownloadRate,
+		UploadRate:   stats.UploadRate,
+		Ratio:        d.Ratio(),
+		ETA:          -1,
+		Peers:        stats.Peers,
+		Seeds:        stats.Seeds,
+		Leechers:     stats.Leechers,
+		PiecesHave:   stats.PiecesHave,
+		PiecesTotal:  stats.PiecesTotal,
+		Tracker:      stats.Tracker,
+		Labels:       d.Labels(),
+
+		QueuePriority: d.QueuePriority().String(),
+	}
+	if t.Labels == nil {
+		t.Labels = []string{}
+	}
+	if stats.Err != nil {
+		t.Error = stats.Err.Error()
+	}
+	if size > 0 {
+		t.Progress = float64(size-stats.Left) / float64(size)
+	}
+	if stats.ETA >= 0 {
+		t.ETA = int64(stats.ETA.Seconds())
+	}
+	return t
+}
+
+func newFiles(d *bt.Download) []File {
+	files := []File{}
+	for _, f := range d.FileProgress() {
+		files = append(files, File{
+			Index:     f.Index,
+			Path:      f.Path,
+			Length:    f.Length,
+			Completed: f.Completed,
+			Progress:  f.Progress(),
+			Priority:  f.Priority.String(),
+		})
+	}
+	return files
+}
+
+func newPeer(p bt.PeerStats) Peer {
+	return Peer{
+		Addr:           p.Addr,
+		Client:         p.Client.String(),
+		Transport:      p.Transport.String(),
+		Source:         p.Source.String(),
+		Downloaded:     p.Downloaded,
+		Uploaded:       p.Uploaded,
+		DownloadRate:   p.DownloadRate,
+		UploadRate:     p.UploadRate,
+		AmChoking:      p.AmChoking,
+		AmInterested:   p.AmInterested,
+		PeerChoking:    p.PeerChoking,
+		PeerInterested: p.PeerInterested,
+		Snubbed:        p.Snubbed,
+	}
+}
+
+// NewSession returns the JSON shape of client's session
+func NewSession(client *bt.Client) Session {
+	limiter := client.Limiter()
+	altUpload, altDownload := limiter.AltLimits()
+	return Session{
+		Port:             client.Port(),
+		DownloadRate:     client.DownloadRate(),
+		UploadRate:       client.UploadRate(),
+		Torrents:         len(client.Torrents()),
+		DownloadLimit:    limiter.DownloadLimit(),
+		UploadLimit:      limiter.UploadLimit(),
+		AltSpeed:         limiter.AltSpeed(),
+		AltDownloadLimit: altDownload,
+		AltUploadLimit:   altUpload,
+	}
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, NewSession(s.client))
+}
+
+// setAltSpeed takes {"alt_speed": true} to turn the alternative limits on, false to turn
+// them off
+func (s *Server) setAltSpeed(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AltSpeed *bool `json:"alt_speed"`
+	}
+	err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req)
+	if err == nil && req.AltSpeed == nil {
+		err = fmt.Errorf("alt_speed is missing")
+	}
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	s.client.SetAltSpeed(*req.AltSpeed)
+	writeJSON(w, http.StatusOK, NewSession(s.client))
+}
+
+// listTorrents lists every torrent, or with ?label= those that have the label
+func (s *Server) listTorrents(w http.ResponseWriter, r *http.Request) {
+	downloads := s.client.Torrents()
+	if r.URL.Query().Has("label") {
+		downloads = s.client.TorrentsLabelled(r.URL.Query().Get("label"))
+	}
+	list := []Torrent{}
+	for _, d := range downloads {
+		list = append(list, NewTorrent(d))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) listLabels(w http.ResponseWriter, r *http.Request) {
+	list := []Label{}
+	for _, label := range s.client.Labels() {
+		list = append(list, Label{Label: label, Torrents: len(s.client.TorrentsLabelled(label))})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// setLabels takes {"labels": ["a", "b"]}, replacing the torrent's labels
+func (s *Server) setLabels(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req struct {
+		Labels []string `json:"labels"`
+	}
+	err = json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	d.SetLabels(req.Labels)
+	writeJSON(w, http.StatusOK, NewTorrent(d))
+}
+
+// setQueue takes {"move": "up"} to move the torrent up, down, to the top or to the bottom of
+// the queue and {"priority": "high"} to give it a low, normal or high queue priority
+func (s *Server) setQueue(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req struct {
+		Move     string `json:"move"`
+		Priority string `json:"priority"`
+	}
+	err = json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req)
+	var move bt.QueueMove
+	if err == nil && req.Move != "" {
+		move, err = bt.ParseQueueMove(req.Move)
+	}
+	var prio bt.QueuePriority
+	if err == nil && req.Priority != "" {
+		prio, err = bt.ParseQueuePriority(req.Priority)
+	}
+	if err == nil && req.Move == "" && req.Priority == "" {
+		err = fmt.Errorf("move or priority is missing")
+	}
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	// the priority first, the move is among the torrents of the new one
+	if req.Priority != "" {
+		err = s.client.SetQueuePriority(d.InfoHash, prio)
+	}
+	if err == nil && req.Move != "" {
+		err = s.client.MoveInQueue(d.InfoHash, move)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewTorrent(d))
+}
+
+func (s *Server) getTorrent(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	trackers := d.Torrent.Magnet().Trackers
+	if trackers == nil {
+		trackers = []string{}
+	}
+	writeJSON(w, http.StatusOK, TorrentDetail{Torrent: NewTorrent(d), Files: newFiles(d), Trackers: trackers})
+}
+
+// addTorrent adds the .torrent in the body right away, a magnet link in the background. A
+// .torrent takes its labels and directory from ?label=a&label=b&dir=
+func (s *Server) addTorrent(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTorrentSize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var t *bt.Torrent
+	var err error
+	switch mediaType {
+	case "application/json":
+		s.addMagnet(w, r)
+		return
+	case "multipart/form-data":
+		file, _, ferr := r.FormFile("torrent")
+		if ferr != nil {
+			writeError(w, fmt.Errorf("%w: %v", errBadRequest, ferr))
+			return
+		}
+		defer file.Close()
+		t, err = bt.DecodeTorrent(file)
+	case "application/x-bittorrent":
+		t, err = bt.DecodeTorrent(r.Body)
+	default:
+		writeError(w, fmt.Errorf("%w: post a .torrent as application/x-bittorrent or multipart/form-data, a magnet link as application/json", errBadRequest))
+		return
+	}
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	query := r.URL.Query()
+	d, err := s.client.AddTorrent(t, bt.WithSaveDir(query.Get("dir")), bt.WithLabels(query["label"]...))
+	if d == nil {
+		writeError(w, err)
+		return
+	}
+	// a torrent that failed to start is in the session all the same, its state says why
+	writeJSON(w, http.StatusCreated, NewTorrent(d))
+}
+
+func (s *Server) addMagnet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Magnet string   `json:"magnet"`
+		Labels []string `json:"labels"`
+		Dir    string   `json:"dir"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	m, err := bt.ParseMagnet(req.Magnet)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	if _, ok := s.client.Torrent(m.InfoHash); ok {
+		writeError(w, bt.ErrTorrentExists)
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		// a failure is published on the client's event bus
+		s.client.AddMagnet(s.ctx, req.Magnet, bt.WithSaveDir(req.Dir), bt.WithLabels(req.Labels...))
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"info_hash": fmt.Sprintf("%x", m.InfoHash), "name": m.Name})
+}
+
+func (s *Server) removeTorrent(w http.ResponseWriter, r *http.Request) {
+	infoHash, err := parseInfoHash(r.PathValue("infohash"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	withData, _ := strconv.ParseBool(r.URL.Query().Get("data"))
+	// a magnet link still fetching its metadata is removed too, so the client is asked
+	// even for infohashes it doesn't list
+	err = s.client.Remove(infoHash, withData)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) pauseTorrent(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err == nil {
+		err = d.Pause()
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewTorrent(d))
+}
+
+func (s *Server) resumeTorrent(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if d.State() != bt.DownloadPaused {
+		writeError(w, fmt.Errorf("%w: torrent is not paused", errBadRequest))
+		return
+	}
+	err = d.Resume()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewTorrent(d))
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newFiles(d))
+}
+
+// setFilePriority takes {"priority": "skip"|"normal"|"high"}
+func (s *Server) setFilePriority(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: file index %q is not a number", errBadRequest, r.PathValue("index")))
+		return
+	}
+	var req struct {
+		Priority string `json:"priority"`
+	}
+	err = json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&req)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	prio, err := parseFilePriority(req.Priority)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = d.SetFilePriority(index, prio)
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", errBadRequest, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, newFiles(d))
+}
+
+func parseFilePriority(s string) (bt.FilePriority, error) {
+	for _, prio := range []bt.FilePriority{bt.FileSkip, bt.FileNormal, bt.FileHigh} {
+		if strings.EqualFold(s, prio.String()) {
+			return prio, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: priority %q is not skip, normal or high", errBadRequest, s)
+}
+
+func (s *Server) listPeers(w http.ResponseWriter, r *http.Request) {
+	d, err := s.download(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	peers := []Peer{}
+	for _, p := range d.Peers() {
+		peers = append(peers, newPeer(p.Stats()))
+	}
+	writeJSON(w, http.StatusOK, peers)
+}
@@ -0,0 +1,992 @@
+// This file speaks the Transmission RPC protocol, so the remote controls and tools written
+// for Transmission, phone apps, Sonarr and Radarr among them, drive this client as they are.
+// It is served at /transmission/rpc like Transmission's own, see NewTransmissionRPC, and
+// implements
+//
+//	session-get, session-set, session-stats
+//	torrent-add, torrent-get, torrent-set, torrent-remove
+//	torrent-start, torrent-start-now, torrent-stop, torrent-verify
+//	queue-move-top, queue-move-up, queue-move-down, queue-move-bottom
+//
+// with the fields and arguments that mean something here, the others are left out of the
+// answers and ignored in requests. Transmission numbers its torrents, the numbers are handed
+// out the first time a torrent is seen and kept until the handler is closed. Requests have
+// to carry the X-Transmission-Session-Id a 409 answer gave them, which keeps web pages from
+// posting to it behind the user's back. Credentials are RequireAuth's, Transmission clients
+// only send a username and password
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bt "mybittorrent"
+)
+
+const (
+	// the Transmission release and RPC versions we answer like, clients check them for the
+	// fields and methods they use
+	transmissionVersion    = "4.0.6 (gonet-bt)"
+	transmissionRPCVersion = 17
+	transmissionRPCMinimum = 14
+	transmissionRPCSemver  = "5.3.0"
+
+	sessionIDHeader = "X-Transmission-Session-Id"
+	// torrents removed this long ago are still listed as removed for recently-active
+	recentlyRemoved = time.Minute
+	// how long fetching a .torrent from a url may take
+	torrentFetchTimeout = 30 * time.Second
+)
+
+// Transmission's torrent status codes
+const (
+	trStopped      = 0
+	trCheck        = 2
+	trDownloadWait = 3
+	trDownload     = 4
+	trSeedWait     = 5
+	trSeed         = 6
+)
+
+// errUnknownMethod is the result Transmission answers unknown methods with
+var errUnknownMethod = errors.New("method name not recognized")
+
+// TransmissionRPC answers the Transmission RPC protocol for one client. It is an
+// http.Handler, mount it at /transmission/rpc
+type TransmissionRPC struct {
+	client    *bt.Client
+	sessionID string
+	started   time.Time
+
+	mu     sync.Mutex
+	ids    map[[20]byte]int
+	lastID int
+	// removed are the numbers of the torrents gone from the session lately
+	removed []removedTorrent
+	// fetching are the magnet links added through the handler still fetching their
+	// metadata, they are numbered already
+	fetching map[[20]byte]bool
+
+	// ctx bounds the magnet links being added and the checks started, Close cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type removedTorrent struct {
+	id int
+	at time.Time
+}
+
+// NewTransmissionRPC returns the Transmission RPC handler of client
+func NewTransmissionRPC(client *bt.Client) *TransmissionRPC {
+	var id [24]byte
+	rand.Read(id[:])
+	t := &TransmissionRPC{
+		client:    client,
+		sessionID: base64.RawURLEncoding.EncodeToString(id[:]),
+		started:   time.Now(),
+		ids:       make(map[[20]byte]int),
+		fetching:  make(map[[20]byte]bool),
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t
+}
+
+// Close gives up on the magnet links still being added and the checks running, and waits
+// for them. It doesn't close the client
+func (t *TransmissionRPC) Close() error {
+	t.cancel()
+	t.wg.Wait()
+	return nil
+}
+
+type rpcRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+type rpcResponse struct {
+	Result    string          `json:"result"`
+	Arguments any             `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+func (t *TransmissionRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "post the request"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(sessionIDHeader)), []byte(t.sessionID)) != 1 {
+		// clients retry with the id they are given
+		w.Header().Set(sessionIDHeader, t.sessionID)
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "missing or stale " + sessionIDHeader})
+		return
+	}
+	var req rpcRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleSize)).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{Result: err.Error(), Arguments: struct{}{}})
+		return
+	}
+	args, err := t.call(req.Method, req.Arguments)
+	resp := rpcResponse{Result: "success", Arguments: args, Tag: req.Tag}
+	if err != nil {
+		resp.Result = err.Error()
+	}
+	if resp.Arguments == nil {
+		resp.Arguments = struct{}{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// this function runs the method named by a request on its arguments
+func (t *TransmissionRPC) call(method string, raw json.RawMessage) (any, error) {
+	switch method {
+	case "session-get":
+		return t.sessionGet(raw)
+	case "session-set":
+		return nil, t.sessionSet(raw)
+	case "session-stats":
+		return t.sessionStats(), nil
+	case "torrent-add":
+		return t.torrentAdd(raw)
+	case "torrent-get":
+		return t.torrentGet(raw)
+	case "torrent-set":
+		return nil, t.torrentSet(raw)
+	case "torrent-remove":
+		return nil, t.torrentRemove(raw)
+	case "torrent-start", "torrent-start-now", "torrent-stop", "torrent-verify":
+		return nil, t.torrentAction(method, raw)
+	case "queue-move-top", "queue-move-up", "queue-move-down", "queue-move-bottom":
+		return nil, t.queueMove(method, raw)
+	default:
+		return nil, errUnknownMethod
+	}
+}
+
+// this function decodes a request's arguments into v, requests may leave them out
+func decodeArgs(raw json.RawMessage, v any) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+// pick returns the entries of all that fields names, all of them when fields is empty
+func pick(all map[string]any, fields []string) map[string]any {
+	if len(fields) == 0 {
+		return all
+	}
+	picked := make(map[string]any, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			picked[f] = v
+		}
+	}
+	return picked
+}
+
+func (t *TransmissionRPC) sessionGet(raw json.RawMessage) (any, error) {
+	var args struct {
+		Fields []string `json:"fields"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	limiter := t.client.Limiter()
+	up, down := limiter.Limits()
+	altUp, altDown := limiter.AltLimits()
+	// speeds are in units of speed-bytes per second, KiB/s like everywhere in gonet-bt
+	units := map[string]any{
+		"speed-units":  []string{"KiB/s", "MiB/s", "GiB/s", "TiB/s"},
+		"speed-bytes":  1024,
+		"size-units":   []string{"KiB", "MiB", "GiB", "TiB"},
+		"size-bytes":   1024,
+		"memory-units": []string{"KiB", "MiB", "GiB", "TiB"},
+		"memory-bytes": 1024,
+	}
+	return pick(map[string]any{
+		"version":                  transmissionVersion,
+		"rpc-version":              transmissionRPCVersion,
+		"rpc-version-minimum":      transmissionRPCMinimum,
+		"rpc-version-semver":       transmissionRPCSemver,
+		"session-id":               t.sessionID,
+		"download-dir":             t.client.Dir(),
+		"peer-port":                t.client.Port(),
+		"dht-enabled":              t.client.DHT() != nil,
+		"speed-limit-down":         down >> 10,
+		"speed-limit-down-enabled": down > 0,
+		"speed-limit-up":           up >> 10,
+		"speed-limit-up-enabled":   up > 0,
+		"alt-speed-enabled":        limiter.AltSpeed(),
+		"alt-speed-down":           altDown >> 10,
+		"alt-speed-up":             altUp >> 10,
+		"units":                    units,
+	}, args.Fields), nil
+}
+
+func (t *TransmissionRPC) sessionSet(raw json.RawMessage) error {
+	var args struct {
+		AltSpeedEnabled       *bool  `json:"alt-speed-enabled"`
+		AltSpeedDown          *int64 `json:"alt-speed-down"`
+		AltSpeedUp            *int64 `json:"alt-speed-up"`
+		SpeedLimitDown        *int64 `json:"speed-limit-down"`
+		SpeedLimitDownEnabled *bool  `json:"speed-limit-down-enabled"`
+		SpeedLimitUp          *int64 `json:"speed-limit-up"`
+		SpeedLimitUpEnabled   *bool  `json:"speed-limit-up-enabled"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return err
+	}
+	limiter := t.client.Limiter()
+	up, down := limiter.Limits()
+	limiter.SetDownloadLimit(trLimit(down, args.SpeedLimitDown, args.SpeedLimitDownEnabled))
+	limiter.SetUploadLimit(trLimit(up, args.SpeedLimitUp, args.SpeedLimitUpEnabled))
+	if args.AltSpeedDown != nil || args.AltSpeedUp != nil {
+		up, down := limiter.AltLimits()
+		if args.AltSpeedUp != nil {
+			up = max(*args.AltSpeedUp, 0) << 10
+		}
+		if args.AltSpeedDown != nil {
+			down = max(*args.AltSpeedDown, 0) << 10
+		}
+		limiter.SetAltLimits(up, down)
+	}
+	if args.AltSpeedEnabled != nil {
+		t.client.SetAltSpeed(*args.AltSpeedEnabled)
+	}
+	return nil
+}
+
+// trLimit returns the limit in bytes per second after Transmission's limit in KiB/s and
+// its switch were set on current. Transmission keeps a limit that is switched off, here
+// no limit is zero, so a limit given while switched off is dropped
+func trLimit(current int64, kib *int64, enabled *bool) int64 {
+	limit := current
+	if kib != nil && (current > 0 || enabled != nil && *enabled) {
+		limit = max(*kib, 0) << 10
+	}
+	if enabled != nil && !*enabled {
+		limit = 0
+	}
+	return limit
+}
+
+func (t *TransmissionRPC) sessionStats() any {
+	var active, paused int
+	var uploaded, downloaded int64
+	torrents := t.client.Torrents()
+	for _, d := range torrents {
+		stats := d.Stats()
+		uploaded += stats.Uploaded
+		downloaded += stats.Downloaded
+		switch stats.State {
+		case bt.DownloadDownloading, bt.DownloadSeeding, bt.DownloadChecking:
+			active++
+		case bt.DownloadPaused, bt.DownloadStopped, bt.DownloadError:
+			paused++
+		}
+	}
+	totals := map[string]any{
+		"uploadedBytes":   uploaded,
+		"downloadedBytes": downloaded,
+		"sessionCount":    1,
+		"secondsActive":   int64(time.Since(t.started).Seconds()),
+	}
+	return map[string]any{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(torrents),
+		"downloadSpeed":      int64(t.client.DownloadRate()),
+		"uploadSpeed":        int64(t.client.UploadRate()),
+		"cumulative-stats":   totals,
+		"current-stats":      totals,
+	}
+}
+
+// trTorrent is a torrent with its Transmission number and queue position
+type trTorrent struct {
+	d   *bt.Download
+	id  int
+	pos int
+}
+
+// this function returns the torrents of the session in queue order with their numbers,
+// numbering the new ones and forgetting the numbers of the ones gone
+func (t *TransmissionRPC) torrents() []trTorrent {
+	all := t.client.Torrents()
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	present := make(map[[20]byte]bool, len(all))
+	list := make([]trTorrent, len(all))
+	for i, d := range all {
+		present[d.InfoHash] = true
+		list[i] = trTorrent{d: d, id: t.number(d.InfoHash), pos: i}
+	}
+	for infoHash, id := range t.ids {
+		if !present[infoHash] && !t.fetching[infoHash] {
+			delete(t.ids, infoHash)
+			t.removed = append(t.removed, removedTorrent{id: id, at: now})
+		}
+	}
+	for len(t.removed) > 0 && now.Sub(t.removed[0].at) > recentlyRemoved {
+		t.removed = t.removed[1:]
+	}
+	return list
+}
+
+// this function returns the number of the torrent of infoHash, a new one for a torrent not
+// seen before. the caller must hold t.mu
+func (t *TransmissionRPC) number(infoHash [20]byte) int {
+	id, ok := t.ids[infoHash]
+	if !ok {
+		t.lastID++
+		id = t.lastID
+		t.ids[infoHash] = id
+	}
+	return id
+}
+
+// this function returns the torrents ids selects: every torrent when it is absent, the one
+// of a number or a hash string, those of a list of them, or for "recently-active" the ones
+// transferring or being checked, recent is then set
+func (t *TransmissionRPC) selectTorrents(ids json.RawMessage) (selected []trTorrent, recent bool, err error) {
+	all := t.torrents()
+	if len(ids) == 0 || string(ids) == "null" {
+		return all, false, nil
+	}
+	var keyword string
+	if json.Unmarshal(ids, &keyword) == nil && keyword == "recently-active" {
+		for _, tt := range all {
+			stats := tt.d.Stats()
+			if stats.DownloadRate > 0 || stats.UploadRate > 0 || stats.State == bt.DownloadChecking {
+				selected = append(selected, tt)
+			}
+		}
+		return selected, true, nil
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(ids, &list) != nil {
+		list = []json.RawMessage{ids}
+	}
+	byID := make(map[int]trTorrent, len(all))
+	byHash := make(map[string]trTorrent, len(all))
+	for _, tt := range all {
+		byID[tt.id] = tt
+		byHash[hex.EncodeToString(tt.d.InfoHash[:])] = tt
+	}
+	// torrents that aren't there are skipped, like Transmission does
+	for _, raw := range list {
+		var id int
+		var hash string
+		switch {
+		case json.Unmarshal(raw, &id) == nil:
+			if tt, ok := byID[id]; ok {
+				selected = append(selected, tt)
+			}
+		case json.Unmarshal(raw, &hash) == nil:
+			if tt, ok := byHash[strings.ToLower(hash)]; ok {
+				selected = append(selected, tt)
+			}
+		default:
+			return nil, false, fmt.Errorf("invalid ids: %s", raw)
+		}
+	}
+	return selected, false, nil
+}
+
+func (t *TransmissionRPC) torrentGet(raw json.RawMessage) (any, error) {
+	var args struct {
+		IDs    json.RawMessage `json:"ids"`
+		Fields []string        `json:"fields"`
+		Format string          `json:"format"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	if len(args.Fields) == 0 {
+		return nil, errors.New("no fields specified")
+	}
+	selected, recent, err := t.selectTorrents(args.IDs)
+	if err != nil {
+		return nil, err
+	}
+	var torrents []any
+	if args.Format == "table" {
+		// the field names first, then a row of values for each torrent
+		torrents = append(torrents, args.Fields)
+	}
+	for _, tt := range selected {
+		fields := tt.fields(args.Fields)
+		if args.Format != "table" {
+			torrents = append(torrents, fields)
+			continue
+		}
+		row := make([]any, len(args.Fields))
+		for i, f := range args.Fields {
+			row[i] = fields[f]
+		}
+		torrents = append(torrents, row)
+	}
+	result := map[string]any{"torrents": torrents}
+	if torrents == nil {
+		result["torrents"] = []any{}
+	}
+	if recent {
+		removed := []int{}
+		t.mu.Lock()
+		for _, r := range t.removed {
+			removed = append(removed, r.id)
+		}
+		t.mu.Unlock()
+		result["removed"] = removed
+	}
+	return result, nil
+}
+
+// this function returns the torrent's fields named by names, leaving out those it doesn't
+// know
+func (tt trTorrent) fields(names []string) map[string]any {
+	d := tt.d
+	stats := d.Stats()
+	files := d.FileProgress()
+	var wanted, have int64
+	for _, f := range files {
+		have += f.Completed
+		if f.Priority != bt.FileSkip {
+			wanted += f.Length
+		}
+	}
+	fields := make(map[string]any, len(names))
+	for _, name := range names {
+		var v any
+		switch name {
+		case "id":
+			v = tt.id
+		case "hashString":
+			v = hex.EncodeToString(d.InfoHash[:])
+		case "name":
+			v = d.Torrent.Info.Name
+		case "status":
+			v = trStatus(stats.State, stats.Left == 0)
+		case "error":
+			v = 0
+			if stats.Err != nil {
+				// a local error, Transmission's 1 and 2 are tracker warnings and errors
+				v = 3
+			}
+		case "errorString":
+			v = ""
+			if stats.Err != nil {
+				v = stats.Err.Error()
+			}
+		case "totalSize":
+			v = d.Torrent.TotalLength()
+		case "sizeWhenDone":
+			v = wanted
+		case "leftUntilDone":
+			v = stats.Left
+		case "haveValid":
+			v = have
+		case "haveUnchecked":
+			v = 0
+		case "percentDone":
+			v = 1.0
+			if wanted > 0 {
+				v = float64(wanted-stats.Left) / float64(wanted)
+			}
+		case "percentComplete":
+			v = 1.0
+			if size := d.Torrent.TotalLength(); size > 0 {
+				v = float64(have) / float64(size)
+			}
+		case "metadataPercentComplete":
+			v = 1.0
+		case "rateDownload":
+			v = int64(stats.DownloadRate)
+		case "rateUpload":
+			v = int64(stats.UploadRate)
+		case "uploadRatio":
+			v = d.Ratio()
+		case "eta":
+			v = -1
+			if stats.ETA >= 0 {
+				v = int64(stats.ETA.Seconds())
+			}
+		case "downloadDir":
+			v = d.SaveDir()
+		case "isFinished":
+			// stopped by its seeding limits
+			v = stats.State == bt.DownloadStopped && stats.Left == 0 && stats.Err == nil
+		case "isStalled":
+			v = false
+		case "isPrivate":
+			v = d.Torrent.Info.Private == 1
+		case "labels":
+			labels := d.Labels()
+			if labels == nil {
+				labels = []string{}
+			}
+			v = labels
+		case "queuePosition":
+			v = tt.pos
+		case "uploadedEver":
+			v = stats.Uploaded
+		case "downloadedEver":
+			v = stats.Downloaded
+		case "corruptEver":
+			v = stats.Wasted
+		case "peersConnected":
+			v = stats.Peers
+		case "seedRatioLimit":
+			v = d.RatioLimit()
+		case "seedRatioMode":
+			v = trLimitMode(d.RatioLimit() > 0)
+		case "seedIdleLimit":
+			v = int64(d.IdleLimit().Minutes())
+		case "seedIdleMode":
+			v = trLimitMode(d.IdleLimit() > 0)
+		case "secondsSeeding":
+			v = int64(d.SeedingTime().Seconds())
+		case "downloadLimit":
+			v = d.DownloadLimit() >> 10
+		case "downloadLimited":
+			v = d.DownloadLimit() > 0
+		case "uploadLimit":
+			v = d.UploadLimit() >> 10
+		case "uploadLimited":
+			v = d.UploadLimit() > 0
+		case "pieceCount":
+			v = d.Torrent.NumPieces()
+		case "pieceSize":
+			v = d.Torrent.Info.PieceLength
+		case "comment":
+			v = d.Torrent.Comment
+		case "creator":
+			v = d.Torrent.CreatedBy
+		case "dateCreated":
+			v = d.Torrent.CreationDate
+		case "magnetLink":
+			v = d.Torrent.Magnet().String()
+		case "fileCount":
+			v = len(files)
+		case "files":
+			list := make([]map[string]any, len(files))
+			for i, f := range files {
+				list[i] = map[string]any{"name": filepath.ToSlash(f.Path), "length": f.Length, "bytesCompleted": f.Completed}
+			}
+			v = list
+		case "fileStats":
+			list := make([]map[string]any, len(files))
+			for i, f := range files {
+				list[i] = map[string]any{"bytesCompleted": f.Completed, "wanted": f.Priority != bt.FileSkip, "priority": trPriority(f.Priority)}
+			}
+			v = list
+		case "wanted":
+			list := make([]bool, len(files))
+			for i, f := range files {
+				list[i] = f.Priority != bt.FileSkip
+			}
+			v = list
+		case "priorities":
+			list := make([]int, len(files))
+			for i, f := range files {
+				list[i] = trPriority(f.Priority)
+			}
+			v = list
+		case "trackers":
+			v = trTrackers(d.Torrent)
+		default:
+			continue
+		}
+		fields[name] = v
+	}
+	return fields
+}
+
+// trStatus returns Transmission's status code for a download in state
+func trStatus(state bt.DownloadState, complete bool) int {
+	switch state {
+	case bt.DownloadDownloading:
+		return trDownload
+	case bt.DownloadSeeding:
+		return trSeed
+	case bt.DownloadChecking:
+		return trCheck
+	case bt.DownloadQueued:
+		if complete {
+			return trSeedWait
+		}
+		return trDownloadWait
+	default:
+		return trStopped
+	}
+}
+
+// trLimitMode returns Transmission's mode for a seeding limit, 1 for the torrent's own and
+// 2 for none. There are no session wide seeding limits, which would be 0
+func trLimitMode(limited bool) int {
+	if limited {
+		return 1
+	}
+	return 2
+}
+
+// trPriority returns Transmission's file priority, skipped files are unwanted rather than
+// of low priority
+func trPriority(prio bt.FilePriority) int {
+	if prio == bt.FileHigh {
+		return 1
+	}
+	return 0
+}
+
+// trTrackers lists the torrent's trackers by tier
+func trTrackers(t *bt.Torrent) []map[string]any {
+	tiers := t.AnnounceList
+	if len(tiers) == 0 && t.Announce != "" {
+		tiers = [][]string{{t.Announce}}
+	}
+	trackers := []map[string]any{}
+	for tier, urls := range tiers {
+		for _, u := range urls {
+			trackers = append(trackers, map[string]any{"id": len(trackers), "announce": u, "tier": tier})
+		}
+	}
+	return trackers
+}
+
+func (t *TransmissionRPC) torrentAdd(raw json.RawMessage) (any, error) {
+	var args struct {
+		Filename    string   `json:"filename"`
+		Metainfo    string   `json:"metainfo"`
+		DownloadDir string   `json:"download-dir"`
+		Paused      bool     `json:"paused"`
+		Labels      []string `json:"labels"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	opts := []bt.AddOption{bt.WithSaveDir(args.DownloadDir), bt.WithLabels(args.Labels...)}
+	var torrent *bt.Torrent
+	var err error
+	switch {
+	case args.Metainfo != "":
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(args.Metainfo)
+		if err == nil {
+			torrent, err = bt.DecodeTorrent(bytes.NewReader(data))
+		}
+	case strings.HasPrefix(args.Filename, "magnet:"):
+		return t.addMagnet(args.Filename, args.Paused, opts)
+	case strings.HasPrefix(args.Filename, "http://"), strings.HasPrefix(args.Filename, "https://"):
+		torrent, err = t.fetchTorrent(args.Filename)
+	case args.Filename != "":
+		// reading files on the server for whoever asks is more than the API should do
+		return nil, errors.New("filename has to be a magnet link or an http(s) url")
+	default:
+		return nil, errors.New("no filename or metainfo specified")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid or corrupt torrent file: %v", err)
+	}
+	if d, ok := t.client.Torrent(torrent.InfoHash); ok {
+		return map[string]any{"torrent-duplicate": t.added(d.InfoHash, d.Torrent.Info.Name)}, nil
+	}
+	d, err := t.client.AddTorrent(torrent, opts...)
+	if d == nil {
+		return nil, err
+	}
+	// a torrent that failed to start is in the session all the same, its error says why
+	if args.Paused {
+		d.Pause()
+	}
+	return map[string]any{"torrent-added": t.added(d.InfoHash, d.Torrent.Info.Name)}, nil
+}
+
+// this function returns what torrent-add answers about the torrent of infoHash
+func (t *TransmissionRPC) added(infoHash [20]byte, name string) map[string]any {
+	t.mu.Lock()
+	id := t.number(infoHash)
+	t.mu.Unlock()
+	return map[string]any{"id": id, "name": name, "hashString": hex.EncodeToString(infoHash[:])}
+}
+
+// this function adds a magnet link in the background, its number is handed out right away
+func (t *TransmissionRPC) addMagnet(uri string, paused bool, opts []bt.AddOption) (any, error) {
+	m, err := bt.ParseMagnet(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid magnet link: %v", err)
+	}
+	name := m.Name
+	if name == "" {
+		name = hex.EncodeToString(m.InfoHash[:])
+	}
+	if d, ok := t.client.Torrent(m.InfoHash); ok {
+		return map[string]any{"torrent-duplicate": t.added(d.InfoHash, d.Torrent.Info.Name)}, nil
+	}
+	t.mu.Lock()
+	t.fetching[m.InfoHash] = true
+	t.mu.Unlock()
+	added := t.added(m.InfoHash, name)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		// a failure is published on the client's event bus
+		d, err := t.client.AddMagnet(t.ctx, uri, opts...)
+		if err == nil && paused {
+			d.Pause()
+		}
+		t.mu.Lock()
+		delete(t.fetching, m.InfoHash)
+		t.mu.Unlock()
+	}()
+	return map[string]any{"torrent-added": added}, nil
+}
+
+// this function downloads the .torrent at u
+func (t *TransmissionRPC) fetchTorrent(u string) (*bt.Torrent, error) {
+	ctx, cancel := context.WithTimeout(t.ctx, torrentFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return bt.DecodeTorrent(io.LimitReader(resp.Body, maxTorrentSize))
+}
+
+func (t *TransmissionRPC) torrentSet(raw json.RawMessage) error {
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		Labels          *[]string       `json:"labels"`
+		DownloadLimit   *int64          `json:"downloadLimit"`
+		DownloadLimited *bool           `json:"downloadLimited"`
+		UploadLimit     *int64          `json:"uploadLimit"`
+		UploadLimited   *bool           `json:"uploadLimited"`
+		SeedRatioLimit  *float64        `json:"seedRatioLimit"`
+		SeedRatioMode   *int            `json:"seedRatioMode"`
+		SeedIdleLimit   *int64          `json:"seedIdleLimit"`
+		SeedIdleMode    *int            `json:"seedIdleMode"`
+		FilesWanted     []int           `json:"files-wanted"`
+		FilesUnwanted   []int           `json:"files-unwanted"`
+		PriorityHigh    []int           `json:"priority-high"`
+		PriorityNormal  []int           `json:"priority-normal"`
+		PriorityLow     []int           `json:"priority-low"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return err
+	}
+	selected, _, err := t.selectTorrents(args.IDs)
+	if err != nil {
+		return err
+	}
+	for _, tt := range selected {
+		d := tt.d
+		if args.Labels != nil {
+			d.SetLabels(*args.Labels)
+		}
+		d.SetDownloadLimit(trLimit(d.DownloadLimit(), args.DownloadLimit, args.DownloadLimited))
+		d.SetUploadLimit(trLimit(d.UploadLimit(), args.UploadLimit, args.UploadLimited))
+
+		// a limit given with mode 1 or while limited already takes effect, the other
+		// modes drop it
+		ratio := d.RatioLimit()
+		if args.SeedRatioLimit != nil && (ratio > 0 || args.SeedRatioMode != nil && *args.SeedRatioMode == 1) {
+			ratio = *args.SeedRatioLimit
+		}
+		if args.SeedRatioMode != nil && *args.SeedRatioMode != 1 {
+			ratio = 0
+		}
+		d.SetRatioLimit(ratio)
+		idle := d.IdleLimit()
+		if args.SeedIdleLimit != nil && (idle > 0 || args.SeedIdleMode != nil && *args.SeedIdleMode == 1) {
+			idle = time.Duration(*args.SeedIdleLimit) * time.Minute
+		}
+		if args.SeedIdleMode != nil && *args.SeedIdleMode != 1 {
+			idle = 0
+		}
+		d.SetIdleLimit(idle)
+
+		if err := setFiles(d, args.FilesWanted, args.FilesUnwanted, args.PriorityHigh, append(args.PriorityNormal, args.PriorityLow...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFiles applies torrent-set's file arguments, indices into the files torrent-get lists.
+// An empty list given for wanted or unwanted means every file, as in Transmission. Here a
+// file is either skipped or downloaded at a priority, so the priorities apply to the wanted
+// files only and low is normal
+func setFiles(d *bt.Download, wanted, unwanted, high, normal []int) error {
+	files := d.FileProgress()
+	prios := d.FilePriorities()
+	every := func(list []int) []int {
+		if list != nil && len(list) == 0 {
+			list = make([]int, len(files))
+			for i := range list {
+				list[i] = i
+			}
+		}
+		return list
+	}
+	set := func(list []int, fn func(bt.FilePriority) bt.FilePriority) error {
+		for _, i := range list {
+			if i < 0 || i >= len(files) {
+				return fmt.Errorf("file index %d out of range", i)
+			}
+			index := files[i].Index
+			prio := fn(prios[index])
+			if prio == prios[index] {
+				continue
+			}
+			if err := d.SetFilePriority(index, prio); err != nil {
+				return err
+			}
+			prios[index] = prio
+		}
+		return nil
+	}
+	steps := []struct {
+		list []int
+		fn   func(bt.FilePriority) bt.FilePriority
+	}{
+		{every(wanted), func(p bt.FilePriority) bt.FilePriority { return max(p, bt.FileNormal) }},
+		{every(unwanted), func(bt.FilePriority) bt.FilePriority { return bt.FileSkip }},
+		{high, func(p bt.FilePriority) bt.FilePriority { return keepSkipped(p, bt.FileHigh) }},
+		{normal, func(p bt.FilePriority) bt.FilePriority { return keepSkipped(p, bt.FileNormal) }},
+	}
+	for _, step := range steps {
+		if err := set(step.list, step.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepSkipped returns prio for a wanted file, a skipped one stays skipped
+func keepSkipped(current, prio bt.FilePriority) bt.FilePriority {
+	if current == bt.FileSkip {
+		return current
+	}
+	return prio
+}
+
+func (t *TransmissionRPC) torrentRemove(raw json.RawMessage) error {
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		DeleteLocalData bool            `json:"delete-local-data"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return err
+	}
+	selected, _, err := t.selectTorrents(args.IDs)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, tt := range selected {
+		if err := t.client.Remove(tt.d.InfoHash, args.DeleteLocalData); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// torrentAction starts, stops or checks torrents. torrent-start resumes paused and stopped
+// torrents and leaves queued ones waiting, torrent-start-now moves those to the top of the
+// queue. torrent-stop pauses them, the way they are restored paused after a restart
+func (t *TransmissionRPC) torrentAction(method string, raw json.RawMessage) error {
+	var args struct {
+		IDs json.RawMessage `json:"ids"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return err
+	}
+	selected, _, err := t.selectTorrents(args.IDs)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, tt := range selected {
+		d := tt.d
+		state := d.State()
+		var err error
+		switch {
+		case method == "torrent-verify":
+			t.wg.Add(1)
+			go func() {
+				defer t.wg.Done()
+				// a failure is published on the client's event bus
+				d.Verify(t.ctx)
+			}()
+		case method == "torrent-stop":
+			if state == bt.DownloadDownloading || state == bt.DownloadSeeding || state == bt.DownloadQueued {
+				err = d.Pause()
+			}
+		case state == bt.DownloadPaused:
+			err = d.Resume()
+		case state == bt.DownloadStopped || state == bt.DownloadError:
+			err = d.Start()
+		case state == bt.DownloadQueued && method == "torrent-start-now":
+			err = t.client.MoveInQueue(d.InfoHash, bt.QueueTop)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *TransmissionRPC) queueMove(method string, raw json.RawMessage) error {
+	var args struct {
+		IDs json.RawMessage `json:"ids"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return err
+	}
+	move, err := bt.ParseQueueMove(strings.TrimPrefix(method, "queue-move-"))
+	if err != nil {
+		return err
+	}
+	selected, _, err := t.selectTorrents(args.IDs)
+	if err != nil {
+		return err
+	}
+	for _, tt := range selected {
+		if err := t.client.MoveInQueue(tt.d.InfoHash, move); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// This file attributes hash failures to the peers that sent us the blocks of a corrupt piece.
+// Each contributor gets a strike and peers collecting too many are banned, otherwise a
+// single peer sending garbage could keep a piece from ever completing
+package bittorrentclient
+
+import (
+	"maps"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultBanThreshold = 3
+
+// BanList counts hash failure strikes and bans by IP, so a banned peer can't come back
+// from another port
+type BanList struct {
+	mu sync.Mutex
+	// Threshold is the number of strikes after which a peer is banned
+	Threshold int
+	strikes   map[string]int
+	banned    map[string]time.Time
+}
+
+func NewBanList(threshold int) *BanList {
+	if threshold < 1 {
+		threshold = defaultBanThreshold
+	}
+	return &BanList{
+		Threshold: threshold,
+		strikes:   make(map[string]int),
+		banned:    make(map[string]time.Time),
+	}
+}
+
+// this function strips the port so bans apply to the whole host
+func banKey(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// Strike records a hash failure against addr and reports whether it is now banned
+func (b *BanList) Strike(addr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := banKey(addr)
+	b.strikes[key]++
+	if b.strikes[key] >= b.Threshold {
+		if _, ok := b.banned[key]; !ok {
+			b.banned[key] = time.Now()
+		}
+		return true
+	}
+	return false
+}
+
+func (b *BanList) Ban(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.banned[banKey(addr)] = time.Now()
+}
+
+func (b *BanList) Unban(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := banKey(addr)
+	delete(b.banned, key)
+	delete(b.strikes, key)
+}
+
+func (b *BanList) IsBanned(addr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.banned[banKey(addr)]
+	return ok
+}
+
+func (b *BanList) Strikes(addr string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.strikes[banKey(addr)]
+}
+
+// Banned returns the banned hosts
+func (b *BanList) Banned() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hosts := make([]string, 0, len(b.banned))
+	for host := range b.banned {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// pieceAttribution remembers which peer sent each block of the pieces being downloaded
+type pieceAttribution struct {
+	mu      sync.Mutex
+	sources map[int]map[int]*Peer
+}
+
+func newPieceAttribution() *pieceAttribution {
+	return &pieceAttribution{sources: make(map[int]map[int]*Peer)}
+}
+
+func (a *pieceAttribution) addBlock(index, begin int, p *Peer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	blocks, ok := a.sources[index]
+	if !ok {
+		blocks = make(map[int]*Peer)
+		a.sources[index] = blocks
+	}
+	blocks[begin] = p
+}
+
+// this function returns a copy of which peer sent each block of a piece, by offset
+func (a *pieceAttribution) blockSources(index int) map[int]*Peer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.sources[index])
+}
+
+// this function returns every distinct peer that contributed to a piece
+func (a *pieceAttribution) contributors(index int) []*Peer {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seen := make(map[*Peer]bool)
+	var peers []*Peer
+	for _, p := range a.sources[index] {
+		if !seen[p] {
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// this function is called with the verification result of a completed piece. every
+// contributor is credited or gets a strike, and the peers that crossed the ban threshold
+// are returned so the caller can disconnect them
+func (a *pieceAttribution) resolve(index int, verified bool, bans *BanList) []*Peer {
+	peers := a.contributors(index)
+	a.mu.Lock()
+	delete(a.sources, index)
+	a.mu.Unlock()
+
+	var banned []*Peer
+	for _, p := range peers {
+		p.recordPieceResult(verified)
+		if !verified && bans.Strike(p.Addr) {
+			banned = append(banned, p)
+		}
+	}
+	return banned
+}
@@ -18,9 +18,14 @@ package bittorrentclient
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"sort"
 	"strconv"
 )
 
@@ -31,6 +36,21 @@ type Torrent struct {
 	Comment      string
 	CreatedBy    string
 	Info         TorrentInfo
+	// HTTPSeeds are BEP 17 seeds that serve whole pieces over HTTP
+	HTTPSeeds []string
+	// Nodes are DHT nodes, host:port, that trackerless torrents list to join the DHT through
+	Nodes []string
+	// InfoHash is the SHA-1 of the info dictionary exactly as it was encoded in the file. For
+	// v2 only torrents it is InfoHashV2 truncated to 20 bytes, which is what BEP 52 puts on
+	// the wire and in tracker announces
+	InfoHash [20]byte
+	// InfoHashV2 is the SHA-256 of the info dictionary, set for v2 and hybrid torrents
+	InfoHashV2 [32]byte
+	// PieceLayers maps the pieces root of every v2 file larger than a piece to the
+	// concatenated SHA-256 hashes of its pieces
+	PieceLayers map[string][]byte
+	// infoBytes is the raw info dictionary the infohash was computed from
+	infoBytes []byte
 }
 
 type TorrentInfo struct {
@@ -40,13 +60,30 @@ type TorrentInfo struct {
 	Name        string
 	Length      int64
 	Files       []TorrentFile
+	// Mtime is the BEP 47 modification time of a single file torrent's file, zero when
+	// the creator didn't record it
+	Mtime int64
+	// MetaVersion is 2 for v2 and hybrid torrents, see merkle.go
+	MetaVersion int64
+	// FileTree holds the files of the v2 file tree in order, without padding
+	FileTree []TorrentFile
 }
 
 type TorrentFile struct {
 	Length int64
 	Path   []string
+	// Attr holds the BEP 47 attribute flags, "p" marks a padding file
+	Attr string
+	// Mtime is the BEP 47 modification time of the file in unix seconds, zero when the
+	// creator didn't record it
+	Mtime int64
+	// PiecesRoot is the root of the file's v2 merkle tree, nil for v1 and empty files
+	PiecesRoot []byte
 }
 
+// a string length has at most this many digits, enough for any length an int64 holds
+const maxLengthDigits = 19
+
 type BencodeDecoder struct {
 	reader *bufio.Reader
 }
@@ -120,6 +157,9 @@ func (d *BencodeDecoder) decodeString() (string, error) {
 		if ch == ':' {
 			break
 		}
+		if ch < '0' || ch > '9' || len(lengthStr) == maxLengthDigits {
+			return "", fmt.Errorf("invalid string length %q", append(lengthStr, ch))
+		}
 		lengthStr = append(lengthStr, ch)
 	}
 
@@ -127,10 +167,21 @@ func (d *BencodeDecoder) decodeString() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if length < 0 {
+		return "", fmt.Errorf("invalid string length %d", length)
+	}
 
-	strBytes := make([]byte, length)
-	_, err = io.ReadFull(d.reader, strBytes)
-	return string(strBytes), err
+	// the length is whatever the input claims, so the string only grows as its bytes
+	// actually arrive instead of being allocated up front
+	var str bytes.Buffer
+	n, err := io.CopyN(&str, d.reader, length)
+	if n < length {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", fmt.Errorf("string of length %d: %w", length, err)
+	}
+	return str.String(), nil
 }
 
 func (d *BencodeDecoder) decodeList() ([]interface{}, error) {
@@ -194,12 +245,34 @@ func (d *BencodeDecoder) decodeDict() (map[string]interface{}, error) {
 }
 
 func DecodeTorrent(r io.Reader) (*Torrent, error) {
-	decoder := NewDecoder(r)
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decoder := NewDecoder(bytes.NewReader(raw))
 	data, err := decoder.decode()
 	if err != nil {
 		return nil, err
 	}
-	return parseTorrent(data)
+	torrent, err := parseTorrent(data)
+	if err != nil {
+		return nil, err
+	}
+	// the infohash has to come from the original bytes, re-encoding the decoded dictionary
+	// only gives the same result for torrents that were encoded canonically
+	info, err := rawDictValue(raw, "info")
+	if err != nil {
+		return nil, fmt.Errorf("error locating info: %v", err)
+	}
+	torrent.infoBytes = info
+	torrent.InfoHash = sha1.Sum(info)
+	if torrent.IsV2() {
+		torrent.InfoHashV2 = sha256.Sum256(info)
+		if !torrent.IsHybrid() {
+			copy(torrent.InfoHash[:], torrent.InfoHashV2[:])
+		}
+	}
+	return torrent, nil
 }
 
 func parseTorrent(data interface{}) (*Torrent, error) {
@@ -254,6 +327,36 @@ func parseTorrent(data interface{}) (*Torrent, error) {
 		torrent.CreatedBy = createdBy
 	}
 
+	// some creators write a single seed as a plain string
+	switch seeds := topLevel["httpseeds"].(type) {
+	case string:
+		torrent.HTTPSeeds = []string{seeds}
+	case []interface{}:
+		for _, seedInterface := range seeds {
+			seed, ok := seedInterface.(string)
+			if !ok {
+				return nil, errors.New("httpseeds contains non-string URL")
+			}
+			torrent.HTTPSeeds = append(torrent.HTTPSeeds, seed)
+		}
+	}
+
+	// each node is a list of host and port, nodes that aren't are skipped
+	if nodes, ok := topLevel["nodes"].([]interface{}); ok {
+		for _, nodeInterface := range nodes {
+			node, ok := nodeInterface.([]interface{})
+			if !ok || len(node) != 2 {
+				continue
+			}
+			host, ok := node[0].(string)
+			port, ok2 := node[1].(int64)
+			if !ok || !ok2 || port <= 0 || port > 65535 {
+				continue
+			}
+			torrent.Nodes = append(torrent.Nodes, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+		}
+	}
+
 	infoInterface, ok := topLevel["info"]
 	if !ok {
 		return nil, errors.New("missing required field 'info'")
@@ -268,6 +371,21 @@ func parseTorrent(data interface{}) (*Torrent, error) {
 	}
 	torrent.Info = *info
 
+	if layersInterface, ok := topLevel["piece layers"]; ok {
+		layers, ok := layersInterface.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("piece layers is not a dictionary")
+		}
+		torrent.PieceLayers = make(map[string][]byte, len(layers))
+		for root, layerInterface := range layers {
+			layer, ok := layerInterface.(string)
+			if !ok {
+				return nil, errors.New("piece layer is not a string")
+			}
+			torrent.PieceLayers[root] = []byte(layer)
+		}
+	}
+
 	return torrent, nil
 }
 
@@ -284,13 +402,22 @@ func parseTorrentInfo(infoMap map[string]interface{}) (*TorrentInfo, error) {
 		return nil, errors.New("missing required field 'piece length'")
 	}
 
+	if metaVersionInterface, ok := infoMap["meta version"]; ok {
+		metaVersion, ok := metaVersionInterface.(int64)
+		if !ok {
+			return nil, errors.New("meta version is not an integer")
+		}
+		info.MetaVersion = metaVersion
+	}
+
+	// v2 only torrents have no v1 piece hashes, their pieces are hashed per file
 	if piecesInterface, ok := infoMap["pieces"]; ok {
 		pieces, ok := piecesInterface.(string)
 		if !ok {
 			return nil, errors.New("pieces is not a string")
 		}
 		info.Pieces = []byte(pieces)
-	} else {
+	} else if info.MetaVersion != 2 {
 		return nil, errors.New("missing required field 'pieces'")
 	}
 
@@ -321,6 +448,9 @@ func parseTorrentInfo(infoMap map[string]interface{}) (*TorrentInfo, error) {
 			return nil, errors.New("length is not an integer")
 		}
 		info.Length = length
+		if mtime, ok := infoMap["mtime"].(int64); ok {
+			info.Mtime = mtime
+		}
 	} else if hasFiles {
 		filesInterface, _ := infoMap["files"]
 		filesList, ok := filesInterface.([]interface{})
@@ -359,11 +489,79 @@ func parseTorrentInfo(infoMap map[string]interface{}) (*TorrentInfo, error) {
 				path = append(path, pathPart)
 			}
 			file.Path = path
+			if attr, ok := fileMap["attr"].(string); ok {
+				file.Attr = attr
+			}
+			if mtime, ok := fileMap["mtime"].(int64); ok {
+				file.Mtime = mtime
+			}
 			info.Files = append(info.Files, file)
 		}
-	} else {
+	}
+
+	if info.MetaVersion == 2 {
+		treeInterface, ok := infoMap["file tree"]
+		if !ok {
+			return nil, errors.New("missing required field 'file tree'")
+		}
+		tree, ok := treeInterface.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("file tree is not a dictionary")
+		}
+		if err := parseFileTree(tree, nil, info); err != nil {
+			return nil, err
+		}
+		// a hybrid torrent already has its v1 layout, a v2 only one gets the same layout
+		// with padding files
+		if !hasLength && !hasFiles {
+			info.layoutV2()
+		}
+	} else if !hasLength && !hasFiles {
 		return nil, errors.New("info missing both length and files")
 	}
 
 	return info, nil
 }
+
+// this function walks a v2 file tree and appends its files to info.FileTree. keys are
+// visited in sorted order, which is the order BEP 52 lays files out in. a file is a
+// dictionary with an empty key holding its length and pieces root
+func parseFileTree(tree map[string]interface{}, path []string, info *TorrentInfo) error {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node, ok := tree[name].(map[string]interface{})
+		if !ok {
+			return errors.New("file tree entry is not a dictionary")
+		}
+		if name == "" {
+			if len(path) == 0 {
+				return errors.New("file tree has a file without a name")
+			}
+			file := TorrentFile{Path: append([]string(nil), path...)}
+			length, ok := node["length"].(int64)
+			if !ok || length < 0 {
+				return errors.New("file tree file has no valid length")
+			}
+			file.Length = length
+			if root, ok := node["pieces root"].(string); ok {
+				file.PiecesRoot = []byte(root)
+			}
+			if attr, ok := node["attr"].(string); ok {
+				file.Attr = attr
+			}
+			if mtime, ok := node["mtime"].(int64); ok {
+				file.Mtime = mtime
+			}
+			info.FileTree = append(info.FileTree, file)
+			continue
+		}
+		if err := parseFileTree(node, append(path, name), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package bittorrentclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeStringLength(t *testing.T) {
+	for _, input := range []string{
+		// a length far past the input must fail without allocating it
+		"d8:intervali5e5:peers999999999999:xe",
+		"d8:intervali5e5:peers9223372036854775807:xe",
+		"d8:intervali5e5:peers99999999999999999999:xe",
+		"d8:intervali5e5:peers-1:xe",
+		"d8:intervali5e5:peers+1:xe",
+		"d8:intervali5e5:peers6:abce",
+	} {
+		if _, err := parseAnnounceResponse(strings.NewReader(input)); err == nil {
+			t.Errorf("announce response %q parsed", input)
+		}
+		if _, err := DecodeTorrent(strings.NewReader(input)); err == nil {
+			t.Errorf("torrent %q decoded", input)
+		}
+	}
+
+	res, err := parseAnnounceResponse(strings.NewReader("d8:intervali1800e5:peers0:e"))
+	if err != nil || res.Interval != 30*time.Minute || len(res.Peers) != 0 {
+		t.Errorf("empty peer list: %+v, %v", res, err)
+	}
+}
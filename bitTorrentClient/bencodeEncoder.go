@@ -0,0 +1,30 @@
+// This file encodes bencode, the counterpart of bencodeDecoder.go for the messages we build
+// ourselves, like DHT queries and extension messages, and decodes the ones we read from
+// packets. The work is done by internal/bencode, which the DHT package shares
+package bittorrentclient
+
+import (
+	"mybittorrent/internal/bencode"
+)
+
+// rawBencode is a value that is bencoded already and written as is
+type rawBencode = bencode.Raw
+
+func encodeBencode(v interface{}) ([]byte, error) {
+	return bencode.Encode(v)
+}
+
+func appendBencode(b []byte, v interface{}) ([]byte, error) {
+	return bencode.Append(b, v)
+}
+
+// this function decodes a single bencoded value from data, which must hold nothing else
+func decodeBencode(data []byte) (interface{}, error) {
+	return bencode.Decode(data)
+}
+
+// this function decodes the bencoded value data starts with and returns how many bytes it
+// took up
+func decodeBencodePrefix(data []byte) (interface{}, int, error) {
+	return bencode.DecodePrefix(data)
+}
@@ -0,0 +1,21 @@
+// This file binds sockets to a network device on Linux, so traffic can't be routed out of
+// another interface even when the address alone would allow it
+package bittorrentclient
+
+import "syscall"
+
+func bindDeviceControl(device string) func(network, address string, c syscall.RawConn) error {
+	if device == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), device)
+		})
+		if err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+// Outside Linux there is no portable way to bind a socket to a device, binding to the
+// interface's address has to do
+package bittorrentclient
+
+import "syscall"
+
+func bindDeviceControl(device string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,72 @@
+// This file pools the buffers used for message payloads. Piece messages are the hot path,
+// allocating a fresh 16 KiB buffer for every block received or sent adds up fast, so
+// buffers are recycled through size classed sync.Pools instead
+package bittorrentclient
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// size classes are powers of two from 512 bytes up to the largest message we accept
+	minPoolClass = 9
+	maxPoolClass = 21
+)
+
+var bufferPools [maxPoolClass + 1]sync.Pool
+
+func init() {
+	for class := minPoolClass; class <= maxPoolClass; class++ {
+		size := 1 << class
+		bufferPools[class].New = func() any {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+}
+
+// this function returns the smallest size class that fits n bytes
+func poolClass(n int) int {
+	if n <= 1<<minPoolClass {
+		return minPoolClass
+	}
+	return bits.Len(uint(n - 1))
+}
+
+// getBuffer returns a buffer of length n, backed by a pooled array when n fits a size class
+func getBuffer(n int) []byte {
+	class := poolClass(n)
+	if class > maxPoolClass {
+		return make([]byte, n)
+	}
+	buf := bufferPools[class].Get().(*[]byte)
+	return (*buf)[:n]
+}
+
+// putBuffer hands a buffer from getBuffer back to its pool, the caller must not touch it afterwards
+func putBuffer(buf []byte) {
+	c := cap(buf)
+	if c < 1<<minPoolClass || c&(c-1) != 0 {
+		// not one of ours
+		return
+	}
+	class := bits.Len(uint(c)) - 1
+	if class > maxPoolClass {
+		return
+	}
+	buf = buf[:c]
+	bufferPools[class].Put(&buf)
+}
+
+// Release returns the message's payload buffer to the pool. Messages from ReadMessage
+// should be released once their payload has been consumed, the payload must not be used
+// afterwards. Releasing a message that isn't pooled does nothing
+func (m *Message) Release() {
+	if m == nil || m.buf == nil {
+		return
+	}
+	putBuffer(m.buf)
+	m.buf = nil
+	m.Payload = nil
+}
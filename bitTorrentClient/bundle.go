@@ -0,0 +1,127 @@
+// This file moves a torrent from one session to another, e.g. from a seedbox to a machine
+// at home. A bundle is a single JSON file with the .torrent, the torrent's settings as
+// session.json keeps them and its resume data, so the session importing it trusts the data
+// copied along instead of hashing it all again. As with any resume data that only holds for
+// files whose size and modification time survived the copy, rsync -a and cp -p keep them,
+// the pieces of the other files are checked again
+package bittorrentclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// the bundle format written by ExportBundle, ImportBundle reads no other
+const bundleVersion = 1
+
+// ErrBadBundle is returned by ImportBundle for data that isn't a bundle it can import
+var ErrBadBundle = errors.New("not a torrent bundle")
+
+type bundle struct {
+	Version int `json:"version"`
+	// Torrent is the .torrent file
+	Torrent  []byte       `json:"torrent"`
+	Settings torrentState `json:"settings"`
+	Resume   *ResumeData  `json:"resume"`
+}
+
+// ExportBundle writes the bundle of the download of infoHash to w. The resume data is taken
+// as it is at the time, a running download keeps running
+func (c *Client) ExportBundle(infoHash [20]byte, w io.Writer) error {
+	d, ok := c.Torrent(infoHash)
+	if !ok {
+		return ErrTorrentUnknown
+	}
+	data, err := d.Torrent.Encode()
+	if err != nil {
+		return err
+	}
+	rd, err := d.ResumeData()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(bundle{
+		Version:  bundleVersion,
+		Torrent:  data,
+		Settings: d.torrentState(),
+		Resume:   rd,
+	})
+}
+
+// ImportBundle adds the torrent of a bundle written by ExportBundle with its settings and
+// its progress. dir is where the data was copied to, empty when it is where it was exported
+// from. With a dir the torrent has no complete directory, its files are looked for in dir
+// whether they had been moved or not. A bundle can come from anywhere, so without a dir its
+// directories are only kept when they are inside the client's download directory, which is
+// used as dir otherwise
+func (c *Client) ImportBundle(r io.Reader, dir string) (*Download, error) {
+	var b bundle
+	err := json.NewDecoder(r).Decode(&b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("%w: version %d, only %d is supported", ErrBadBundle, b.Version, bundleVersion)
+	}
+	t, err := DecodeTorrent(bytes.NewReader(b.Torrent))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+	}
+	ts := b.Settings
+	if ts.InfoHash != hex.EncodeToString(t.InfoHash[:]) {
+		return nil, fmt.Errorf("%w: the settings are of torrent %s", ErrBadBundle, ts.InfoHash)
+	}
+	c.mu.Lock()
+	err = c.addable(t.InfoHash)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" && !(c.inDownloadDir(ts.Dir) && c.inDownloadDir(ts.CompleteDir)) {
+		dir = c.Dir()
+	}
+	if dir != "" {
+		ts.Dir, ts.CompleteDir = dir, ""
+		if b.Resume != nil {
+			b.Resume.Moved = false
+		}
+	}
+
+	d, err := c.newDownload(t, newAddConfig([]AddOption{WithSaveDir(ts.Dir), WithLabels(ts.Labels...)}))
+	if err != nil {
+		return nil, err
+	}
+	d.applyTorrentState(ts)
+	if b.Resume != nil {
+		err = d.applyResume(b.Resume)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadBundle, err)
+		}
+	}
+	// the resume data in the state directory, if any, is older than the bundle's
+	d.mu.Lock()
+	d.resumed = true
+	d.mu.Unlock()
+	if ts.Paused {
+		d.park(DownloadPaused)
+	}
+	err = c.add(d, !ts.Paused)
+	// kept right away, a restart before the first save would forget the progress
+	if kept, ok := c.Torrent(d.InfoHash); ok && kept == d {
+		if err := d.autosaveResume(); err != nil {
+			c.logger.Warn("saving imported resume data failed", "infohash", fmt.Sprintf("%x", d.InfoHash), "err", err)
+		}
+	}
+	// like AddTorrent, a torrent that failed to start is in the session all the same
+	return d, err
+}
+
+// this function reports whether dir is empty or inside the download directory once links
+// are resolved
+func (c *Client) inDownloadDir(dir string) bool {
+	return dir == "" || confinePath(c.Dir(), dir) == nil
+}
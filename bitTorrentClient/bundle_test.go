@@ -0,0 +1,65 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func newBundleClient(t *testing.T) *Client {
+	t.Helper()
+	client, err := NewClient(WithListenHost("127.0.0.1"), WithListenPort(0), WithDHT(false), WithDownloadDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// this function returns the bundle of the test torrent saved to dir, completing to complete
+func exportBundle(t *testing.T, dir, complete string) []byte {
+	t.Helper()
+	client := newBundleClient(t)
+	d, err := client.AddTorrent(testTorrent(t, "http://127.0.0.1:1/announce"), WithSaveDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetCompleteDir(complete)
+	var buf bytes.Buffer
+	err = client.ExportBundle(d.InfoHash, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportBundleDirs(t *testing.T) {
+	client := newBundleClient(t)
+	inside := filepath.Join(client.Dir(), "incoming")
+	complete := filepath.Join(client.Dir(), "complete")
+	outside := t.TempDir()
+
+	for _, tc := range []struct {
+		name                  string
+		dir, complete         string
+		wantDir, wantComplete string
+	}{
+		{"inside", inside, complete, inside, complete},
+		{"saved outside", outside, complete, client.Dir(), ""},
+		{"completing outside", inside, outside, client.Dir(), ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := client.ImportBundle(bytes.NewReader(exportBundle(t, tc.dir, tc.complete)), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Remove(d.InfoHash, false)
+			if got := d.SaveDir(); got != tc.wantDir {
+				t.Errorf("saving to %s, want %s", got, tc.wantDir)
+			}
+			if got := d.CompleteDir(); got != tc.wantComplete {
+				t.Errorf("completing to %q, want %q", got, tc.wantComplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// This file implements the tit-for-tat choking algorithm. Every 10 seconds the peers that
+// gave us the best rates are unchoked, and every 30 seconds the optimistic unchoke slot is
+// handed to a random choked peer so we keep discovering better partners. Once seeding
+// there is nothing to reciprocate, so a separate seed strategy decides who gets served
+package bittorrentclient
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RechokeInterval    = 10 * time.Second
+	OptimisticInterval = 30 * time.Second
+	DefaultUploadSlots = 4
+	// newly connected peers get this many tickets in the optimistic unchoke lottery since
+	// they have nothing to offer us yet and would otherwise never get started
+	newPeerLotteryWeight = 3
+	newPeerAge           = time.Minute
+	// a round robin seed turn ends after this long or after this many bytes, whichever is first
+	seedTurnDuration = time.Minute
+	seedTurnBytes    = 4 << 20
+)
+
+type SeedStrategy int
+
+const (
+	// SeedRoundRobin gives every interested peer a turn, spreading our upload across the swarm
+	SeedRoundRobin SeedStrategy = iota
+	// SeedFastestUpload keeps serving the peers that take data from us the fastest
+	SeedFastestUpload
+)
+
+func (s SeedStrategy) String() string {
+	switch s {
+	case SeedRoundRobin:
+		return "round-robin"
+	case SeedFastestUpload:
+		return "fastest-upload"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSeedStrategy parses "round-robin" or "fastest-upload"
+func ParseSeedStrategy(s string) (SeedStrategy, error) {
+	for _, strategy := range []SeedStrategy{SeedRoundRobin, SeedFastestUpload} {
+		if strings.EqualFold(s, strategy.String()) {
+			return strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("seed strategy %q must be round-robin or fastest-upload", s)
+}
+
+type Choker struct {
+	// UploadSlots is the total number of unchoked peers, including the optimistic one
+	UploadSlots int
+	// SeedUploadSlots replaces UploadSlots while seeding, zero keeps UploadSlots
+	SeedUploadSlots int
+	SeedStrategy    SeedStrategy
+	// SlotCap limits the slots above from the outside, e.g. to the torrent's share of the
+	// global unchoke limit. zero means no cap
+	SlotCap int
+
+	optimistic   *Peer
+	optimisticAt time.Time
+	rotation     []*Peer
+	turns        map[*Peer]seedTurn
+}
+
+type seedTurn struct {
+	start    time.Time
+	uploaded int64
+}
+
+func NewChoker(uploadSlots int) *Choker {
+	if uploadSlots < 1 {
+		uploadSlots = DefaultUploadSlots
+	}
+	return &Choker{
+		UploadSlots:  uploadSlots,
+		SeedStrategy: SeedRoundRobin,
+		turns:        make(map[*Peer]seedTurn),
+	}
+}
+
+// Rechoke runs one round of the algorithm over the currently connected peers
+func (c *Choker) Rechoke(peers []*Peer, seeding bool) {
+	now := time.Now()
+	var unchoke map[*Peer]bool
+	if seeding && c.SeedStrategy == SeedRoundRobin {
+		unchoke = c.roundRobin(peers, now)
+	} else {
+		unchoke = c.titForTat(peers, seeding, now)
+	}
+
+	for _, p := range peers {
+		switch {
+		case unchoke[p] && p.AmChoking():
+			_ = p.SendUnchoke()
+		case !unchoke[p] && !p.AmChoking():
+			_ = p.SendChoke()
+		}
+	}
+}
+
+// this function returns the number of unchoked peers allowed in the current mode, at least
+// the optimistic one
+func (c *Choker) slots(seeding bool) int {
+	slots := c.UploadSlots
+	if seeding && c.SeedUploadSlots > 0 {
+		slots = c.SeedUploadSlots
+	}
+	if c.SlotCap > 0 && slots > c.SlotCap {
+		slots = c.SlotCap
+	}
+	return max(slots, 1)
+}
+
+// this function picks who to unchoke by rate. while leeching peers are ranked by how fast
+// they upload to us, when seeding with SeedFastestUpload by how fast we upload to them
+func (c *Choker) titForTat(peers []*Peer, seeding bool, now time.Time) map[*Peer]bool {
+	// regular slots go to the fastest interested peers, snubbing peers earn nothing
+	rates := make(map[*Peer]float64, len(peers))
+	var ranked []*Peer
+	for _, p := range peers {
+		stats := p.Stats()
+		rates[p] = stats.DownloadRate
+		if seeding {
+			rates[p] = stats.UploadRate
+		}
+		if !p.PeerInterested() {
+			continue
+		}
+		if !seeding && p.Snubbed() {
+			continue
+		}
+		ranked = append(ranked, p)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rates[ranked[i]] > rates[ranked[j]]
+	})
+
+	slots := c.slots(seeding)
+	regular := slots - 1
+	if regular > len(ranked) {
+		regular = len(ranked)
+	}
+	unchoke := make(map[*Peer]bool, slots)
+	for _, p := range ranked[:regular] {
+		unchoke[p] = true
+	}
+
+	if opt := c.pickOptimistic(peers, unchoke, now); opt != nil {
+		unchoke[opt] = true
+	}
+	return unchoke
+}
+
+// this function hands the seed slots out in turns. a peer keeps its slot until it used up
+// its turn and then moves to the back of the queue, so every interested peer gets served
+func (c *Choker) roundRobin(peers []*Peer, now time.Time) map[*Peer]bool {
+	connected := make(map[*Peer]bool, len(peers))
+	for _, p := range peers {
+		connected[p] = true
+	}
+
+	var rotation, expired []*Peer
+	queued := make(map[*Peer]bool, len(peers))
+	for _, p := range c.rotation {
+		if !connected[p] {
+			delete(c.turns, p)
+			continue
+		}
+		queued[p] = true
+		turn, ok := c.turns[p]
+		if ok && (now.Sub(turn.start) >= seedTurnDuration || p.Uploaded()-turn.uploaded >= seedTurnBytes) {
+			delete(c.turns, p)
+			expired = append(expired, p)
+			continue
+		}
+		rotation = append(rotation, p)
+	}
+	for _, p := range peers {
+		if !queued[p] {
+			rotation = append(rotation, p)
+		}
+	}
+	c.rotation = append(rotation, expired...)
+
+	slots := c.slots(true)
+	unchoke := make(map[*Peer]bool, slots)
+	for _, p := range c.rotation {
+		if len(unchoke) == slots {
+			break
+		}
+		if !p.PeerInterested() {
+			continue
+		}
+		unchoke[p] = true
+		if _, ok := c.turns[p]; !ok {
+			c.turns[p] = seedTurn{start: now, uploaded: p.Uploaded()}
+		}
+	}
+	for p := range c.turns {
+		if !unchoke[p] {
+			delete(c.turns, p)
+		}
+	}
+	return unchoke
+}
+
+// this function keeps the current optimistic unchoke for OptimisticInterval and then rotates
+// it to a random choked peer, weighting newly connected peers more heavily
+func (c *Choker) pickOptimistic(peers []*Peer, unchoke map[*Peer]bool, now time.Time) *Peer {
+	if c.optimistic != nil && now.Sub(c.optimisticAt) < OptimisticInterval && !unchoke[c.optimistic] {
+		for _, p := range peers {
+			if p == c.optimistic && p.PeerInterested() {
+				return p
+			}
+		}
+	}
+
+	var lottery []*Peer
+	for _, p := range optimisticCandidates(peers) {
+		if unchoke[p] {
+			continue
+		}
+		tickets := 1
+		if now.Sub(p.connectedAt) < newPeerAge {
+			tickets = newPeerLotteryWeight
+		}
+		for i := 0; i < tickets; i++ {
+			lottery = append(lottery, p)
+		}
+	}
+	if len(lottery) == 0 {
+		c.optimistic = nil
+		return nil
+	}
+	c.optimistic = lottery[rand.Intn(len(lottery))]
+	c.optimisticAt = now
+	return c.optimistic
+}
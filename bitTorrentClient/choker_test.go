@@ -0,0 +1,29 @@
+package bittorrentclient
+
+import "testing"
+
+func TestChokerSlotsAtLeastOne(t *testing.T) {
+	c := NewChoker(DefaultUploadSlots)
+	c.UploadSlots = 0
+	for _, seeding := range []bool{false, true} {
+		if got := c.slots(seeding); got != 1 {
+			t.Errorf("slots(%v) with no upload slots = %d, want 1", seeding, got)
+		}
+	}
+	c.SeedStrategy = SeedFastestUpload
+	// used to slice the ranked peers to -1
+	c.Rechoke(nil, false)
+	c.Rechoke(nil, true)
+}
+
+func TestParseSeedStrategy(t *testing.T) {
+	for _, s := range []SeedStrategy{SeedRoundRobin, SeedFastestUpload} {
+		got, err := ParseSeedStrategy(s.String())
+		if err != nil || got != s {
+			t.Errorf("ParseSeedStrategy(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseSeedStrategy("fastest"); err == nil {
+		t.Error("ParseSeedStrategy accepted fastest")
+	}
+}
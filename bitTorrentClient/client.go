@@ -0,0 +1,760 @@
+// This file implements the Client, which runs any number of downloads as one session. The
+// client owns what the downloads share: the listening socket, whose incoming connections it
+// hands to the download their handshake names, the DHT node, the session wide rate limits
+// and rates, the event bus and the peer dialer. Torrents are added from metainfo or from
+// magnet links and removed again, with or without their data, and Close shuts the whole
+// session down
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mybittorrent/dht"
+)
+
+var (
+	ErrClientClosed   = errors.New("client is closed")
+	ErrTorrentExists  = errors.New("torrent is already added")
+	ErrTorrentUnknown = errors.New("torrent is not added")
+)
+
+// Client runs downloads as one session, it is safe for concurrent use
+type Client struct {
+	dir          string
+	peerIDPrefix string
+	// logger logs as the client component, baseLogger is what the downloads' loggers
+	// start from
+	logger     *slog.Logger
+	baseLogger *slog.Logger
+	logLevels  *LogLevels
+	listener   net.Listener
+	port       int
+	dht        *dht.Node
+	limiter    *SessionLimiter
+	conns      *ConnectionManager
+	rates      *TransferRates
+	bus        *EventBus
+	dialer     *PeerDialer
+	// utp accepts uTP peers on the listening port, nil without uTP
+	utp *UTPSocket
+	// mapper forwards the listening port on the gateway, nil without port mapping
+	mapper *PortMapper
+	// shutdownTimeout bounds Close, zero doesn't
+	shutdownTimeout time.Duration
+	// labelSettings are the defaults of torrents added with a label, see labels.go
+	labelSettings map[string]LabelDefaults
+	// maxDownloads and maxSeeds are the slots of the queue, zero is unlimited. requeue
+	// has the queue looked at right away, see queue.go
+	maxDownloads, maxSeeds int
+	requeue                chan struct{}
+	// unchoke is what every torrent's choker starts out with, see uploadSlots.go
+	unchoke chokerSettings
+	// requestQueueTime sizes the request pipelines of every torrent, see requestDepth.go
+	requestQueueTime time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	torrents map[[20]byte]*Download
+	// order holds the infohashes of the torrents in queue order, see queue.go
+	order [][20]byte
+	// fetching holds the magnet links whose metadata is being fetched, Remove cancels them
+	fetching map[[20]byte]*pendingMagnet
+	wg       sync.WaitGroup
+
+	// stateDir keeps the session across restarts, empty keeps nothing. see sessionState.go
+	stateDir  string
+	stateMu   sync.Mutex
+	lastState []byte
+	quit      chan struct{}
+	// restoring is set while the saved session is added back, under mu
+	restoring bool
+
+	// hooks run commands on the events of the bus, see hooks.go. hookCtx is cancelled
+	// to kill the commands still running when Shutdown runs out of time
+	hooks       []Hook
+	hookLogger  *slog.Logger
+	hookCtx     context.Context
+	cancelHooks context.CancelFunc
+	stopHooks   func()
+	hookWG      sync.WaitGroup
+}
+
+// pendingMagnet is a magnet link whose metadata is being fetched
+type pendingMagnet struct {
+	uri    string
+	cfg    addConfig
+	cancel context.CancelFunc
+}
+
+// NewClient starts listening for peers and joins the DHT, torrents are added afterwards.
+// See clientOptions.go for the options and their defaults
+func NewClient(opts ...Option) (*Client, error) {
+	cfg := defaultClientConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	levels := NewLogLevels(cfg.logLevels)
+	base := slog.New(newComponentHandler(cfg.logger.Handler(), levels))
+	c := &Client{
+		dir:          cfg.dir,
+		peerIDPrefix: cfg.peerIDPrefix,
+		logger:       base.With("component", LogClient),
+		baseLogger:   base,
+		logLevels:    levels,
+		limiter:      NewSessionLimiter(cfg.uploadLimit, cfg.downloadLimit),
+		conns:        NewConnectionManager(cfg.connLimits),
+		rates:        NewTransferRates(),
+		bus:          NewEventBus(),
+		dialer:       NewPeerDialer(nil),
+		torrents:     make(map[[20]byte]*Download),
+		fetching:     make(map[[20]byte]*pendingMagnet),
+		stateDir:     cfg.stateDir,
+		quit:         make(chan struct{}),
+
+		shutdownTimeout:  cfg.shutdownTimeout,
+		labelSettings:    cfg.labelDefaults,
+		maxDownloads:     cfg.maxDownloads,
+		maxSeeds:         cfg.maxSeeds,
+		requeue:          make(chan struct{}, 1),
+		unchoke:          cfg.unchoke,
+		requestQueueTime: cfg.requestQueueTime,
+	}
+	c.limiter.SetAltLimits(cfg.altUploadLimit, cfg.altDownloadLimit)
+	c.dialer.Proxy = cfg.proxy
+	c.dialer.Encryption = cfg.encryption
+	c.dialer.SetMaxHalfOpen(cfg.maxHalfOpen)
+	err = c.dialer.SetOutgoingBind(cfg.bind)
+	if err != nil {
+		return nil, fmt.Errorf("outgoing bind: %w", err)
+	}
+	err = c.listen(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.dialer.UTP = c.utp
+	c.dialer.PreferUTP = c.utp != nil
+	c.logger.Info("listening for peers", "addr", c.listener.Addr(), "utp", c.utp != nil, "dht", c.dht != nil)
+	err = c.restoreState()
+	if err != nil {
+		c.listener.Close()
+		if c.utp != nil {
+			c.utp.Close()
+		}
+		if c.dht != nil {
+			c.dht.Close()
+		}
+		return nil, err
+	}
+	c.startHooks(cfg.hooks)
+	if cfg.portMapping {
+		c.mapper = NewPortMapper(PortMappingConfig{Port: c.port, Bus: c.bus})
+	}
+	c.wg.Add(1)
+	go c.acceptLoop(c.listener)
+	if c.utp != nil {
+		c.wg.Add(1)
+		go c.acceptLoop(c.utp)
+	}
+	if c.stateDir != "" {
+		c.wg.Add(1)
+		go c.stateLoop()
+	}
+	if len(cfg.altSchedule) > 0 {
+		c.wg.Add(1)
+		go c.altSpeedLoop(cfg.altSchedule)
+	}
+	if c.queueing() {
+		c.wg.Add(1)
+		go c.queueLoop()
+	}
+	return c, nil
+}
+
+// this function opens the listener, and uTP and the DHT node on the same port, on the first
+// port of the configured range where all of them are free
+func (c *Client) listen(cfg *clientConfig) error {
+	var err error
+	for port := cfg.firstPort; port <= cfg.lastPort; port++ {
+		addr := net.JoinHostPort(cfg.host, strconv.Itoa(port))
+		c.listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			continue
+		}
+		c.port = c.listener.Addr().(*net.TCPAddr).Port
+		err = c.listenUDP(cfg)
+		if err == nil {
+			return nil
+		}
+		c.listener.Close()
+	}
+	return err
+}
+
+// this function starts uTP and the DHT on the listening port. When the DHT runs there too
+// they share one IPv4 socket, see udpMux.go, and the DHT opens its IPv6 one itself
+func (c *Client) listenUDP(cfg *clientConfig) error {
+	node := cfg.dht
+	addr := net.JoinHostPort(cfg.host, strconv.Itoa(c.port))
+	shared := cfg.dhtEnabled && node.Addr == "" && node.Conn == nil
+	var utpConn net.PacketConn
+	if cfg.utp {
+		network := "udp"
+		if shared {
+			network = "udp4"
+		}
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return fmt.Errorf("listening for uTP: %w", err)
+		}
+		utpConn = pc
+		if shared {
+			node.Conn, utpConn = newUDPMux(pc)
+			ip := net.ParseIP(cfg.host)
+			if node.Addr6 == "" && (cfg.host == "" || ip != nil && ip.IsUnspecified()) {
+				node.Addr6 = net.JoinHostPort("::", strconv.Itoa(c.port))
+			}
+		}
+	}
+	if cfg.dhtEnabled {
+		if node.Addr == "" && node.Conn == nil {
+			node.Addr = addr
+		}
+		if node.Logger == nil {
+			node.Logger = c.baseLogger.With("component", LogDHT)
+		}
+		var err error
+		c.dht, err = dht.New(node)
+		if err != nil {
+			if utpConn != nil {
+				utpConn.Close()
+			}
+			return fmt.Errorf("starting the DHT: %w", err)
+		}
+	}
+	if utpConn != nil {
+		c.utp = NewUTPSocket(utpConn)
+	}
+	return nil
+}
+
+// Addr returns the address the client accepts peer connections on
+func (c *Client) Addr() net.Addr {
+	return c.listener.Addr()
+}
+
+// Port returns the port the client listens on and announces
+func (c *Client) Port() int {
+	return c.port
+}
+
+// Dir returns the directory torrents are saved under unless they are given their own
+func (c *Client) Dir() string {
+	return c.dir
+}
+
+// DHT returns the client's DHT node, nil when the DHT is disabled
+func (c *Client) DHT() *dht.Node {
+	return c.dht
+}
+
+// LogLevels returns the levels the client's components log at, changing them takes effect
+// right away
+func (c *Client) LogLevels() *LogLevels {
+	return c.logLevels
+}
+
+// PortMapper returns what forwards the listening port on the gateway, nil unless the
+// client was made WithPortMapping
+func (c *Client) PortMapper() *PortMapper {
+	return c.mapper
+}
+
+// Events returns the bus the client and its downloads publish session events on
+func (c *Client) Events() *EventBus {
+	return c.bus
+}
+
+// Limiter returns the session wide rate limits
+func (c *Client) Limiter() *SessionLimiter {
+	return c.limiter
+}
+
+// Connections returns the manager of the session's connection limits
+func (c *Client) Connections() *ConnectionManager {
+	return c.conns
+}
+
+// DownloadRate returns the smoothed download rate of all torrents together in bytes per second
+func (c *Client) DownloadRate() float64 {
+	return c.rates.DownloadRate()
+}
+
+// UploadRate returns the smoothed upload rate of all torrents together in bytes per second
+func (c *Client) UploadRate() float64 {
+	return c.rates.UploadRate()
+}
+
+// AddTorrent starts downloading t into the client's directory, opts change that and more
+// for this torrent, see addOptions.go
+func (c *Client) AddTorrent(t *Torrent, opts ...AddOption) (*Download, error) {
+	c.mu.Lock()
+	err := c.addable(t.InfoHash)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	d, err := c.newDownload(t, newAddConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	return d, c.add(d, true)
+}
+
+// this function makes the download of t with the client's peer id and cfg's settings
+func (c *Client) newDownload(t *Torrent, cfg addConfig) (*Download, error) {
+	d, err := NewDownload(t, c.saveDir(cfg))
+	if err != nil {
+		return nil, err
+	}
+	d.PeerID, err = newPeerID(c.peerIDPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.storage != nil {
+		d.SetStorage(cfg.storage)
+	}
+	if cfg.dialer != nil {
+		d.SetDialer(cfg.dialer)
+	}
+	d.SetLabels(cfg.labels)
+	c.applyLabelDefaults(d, cfg.labels)
+	return d, nil
+}
+
+// AddTorrentFile starts downloading the torrent in the .torrent file at path
+func (c *Client) AddTorrentFile(path string, opts ...AddOption) (*Download, error) {
+	t, err := LoadTorrent(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.AddTorrent(t, opts...)
+}
+
+// this function returns the directory a torrent added with cfg is saved under: its own,
+// its labels' or the client's
+func (c *Client) saveDir(cfg addConfig) string {
+	if cfg.dir != "" {
+		return cfg.dir
+	}
+	if defaults, ok := c.labelDefaults(cfg.labels); ok && defaults.Dir != "" {
+		return defaults.Dir
+	}
+	return c.dir
+}
+
+// AddMagnet fetches the metadata of the magnet link uri and starts downloading the torrent,
+// see AddMagnet. It returns once the metadata arrived, ctx bounds the wait and Remove with
+// the link's infohash gives up on it
+func (c *Client) AddMagnet(ctx context.Context, uri string, opts ...AddOption) (*Download, error) {
+	m, err := ParseMagnet(uri)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newAddConfig(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c.mu.Lock()
+	err = c.addable(m.InfoHash)
+	if err == nil {
+		c.fetching[m.InfoHash] = &pendingMagnet{uri: uri, cfg: cfg, cancel: cancel}
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	c.saveStateNow()
+	fetch := MagnetOptions{DHT: c.dht, Dialer: c.dialer, Port: c.port, PeerIDPrefix: c.peerIDPrefix}
+	if cfg.dialer != nil {
+		fetch.Dialer = cfg.dialer
+	}
+	d, err := m.download(ctx, c.saveDir(cfg), fetch)
+	c.mu.Lock()
+	_, wanted := c.fetching[m.InfoHash]
+	delete(c.fetching, m.InfoHash)
+	c.mu.Unlock()
+	if err == nil && !wanted {
+		err = errors.New("magnet link was removed")
+	}
+	if err != nil {
+		if wanted {
+			c.logger.Warn("magnet link failed", "infohash", fmt.Sprintf("%x", m.InfoHash), "err", err)
+			// whoever watches the bus learns of links added in the background failing
+			c.bus.Publish(SessionEvent{Type: SessionTorrentErrored, InfoHash: m.InfoHash, Err: err})
+		}
+		return nil, err
+	}
+	d.SetLabels(cfg.labels)
+	c.applyLabelDefaults(d, cfg.labels)
+	c.bus.Publish(SessionEvent{Type: SessionMetadataReceived, InfoHash: d.InfoHash})
+	return d, c.add(d, true)
+}
+
+// this function checks that a torrent with infoHash can be added. the caller must hold c.mu
+func (c *Client) addable(infoHash [20]byte) error {
+	if c.closed {
+		return ErrClientClosed
+	}
+	if _, ok := c.torrents[infoHash]; ok {
+		return ErrTorrentExists
+	}
+	if _, ok := c.fetching[infoHash]; ok {
+		return ErrTorrentExists
+	}
+	return nil
+}
+
+// this function puts d under the session's limits, dialer unless it has its own, and DHT,
+// adds it to the bottom of the queue and starts it unless told not to. with queue slots it
+// waits for one instead
+func (c *Client) add(d *Download, start bool) error {
+	d.Port = c.port
+	if limit := c.conns.Limits().MaxPerTorrent; limit > 0 {
+		d.MaxPeers = limit
+	}
+	d.setDefaultDialer(c.dialer)
+	d.SetDHT(c.dht)
+	d.SetSessionLimiter(c.limiter)
+	d.SetConnectionManager(c.conns)
+	d.SetSessionRates(c.rates)
+	d.SetEventBus(c.bus)
+	d.SetUploadSlots(c.unchoke.uploadSlots)
+	d.SetSeedUploadSlots(c.unchoke.seedUploadSlots)
+	d.SetSeedStrategy(c.unchoke.seedStrategy)
+	d.SetRequestQueueTime(c.requestQueueTime)
+	d.SetLogger(c.baseLogger.With("infohash", fmt.Sprintf("%x", d.InfoHash), "name", d.Torrent.Info.Name))
+
+	c.mu.Lock()
+	if err := c.addable(d.InfoHash); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.torrents[d.InfoHash] = d
+	c.order = append(c.order, d.InfoHash)
+	c.mu.Unlock()
+	c.keepTorrent(d)
+	c.bus.Publish(SessionEvent{Type: SessionTorrentAdded, InfoHash: d.InfoHash})
+	c.logger.Info("torrent added", "infohash", fmt.Sprintf("%x", d.InfoHash), "name", d.Torrent.Info.Name)
+
+	// a download that fails to start stays in the session in its error state, like one
+	// that fails later on, so it can be looked at and removed
+	var err error
+	if start && c.queueing() {
+		d.park(DownloadQueued)
+		c.queueChanged()
+	} else if start {
+		err = d.Start()
+	}
+	if err != nil {
+		c.logger.Warn("torrent failed to start", "infohash", fmt.Sprintf("%x", d.InfoHash), "err", err)
+	}
+	c.mu.Lock()
+	gone := ErrTorrentUnknown
+	if c.closed {
+		gone = ErrClientClosed
+	} else if c.torrents[d.InfoHash] == d {
+		gone = nil
+	}
+	c.mu.Unlock()
+	if gone != nil {
+		// Remove or Close got to it while it was starting
+		d.Stop()
+		return errors.Join(err, gone)
+	}
+	return err
+}
+
+// Torrent returns the download of infoHash
+func (c *Client) Torrent(infoHash [20]byte) (*Download, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.torrents[infoHash]
+	return d, ok
+}
+
+// Torrents returns every download in the session in queue order, which is the order they
+// were added in unless they were moved or given queue priorities
+func (c *Client) Torrents() []*Download {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]*Download, 0, len(c.order))
+	for _, infoHash := range c.order {
+		list = append(list, c.torrents[infoHash])
+	}
+	return list
+}
+
+// Remove stops the download of infoHash and takes it out of the session. withData deletes
+// its files as well, complete or not, along with its resume data. A magnet link whose
+// metadata is still being fetched is given up on
+func (c *Client) Remove(infoHash [20]byte, withData bool) error {
+	c.mu.Lock()
+	if pending, ok := c.fetching[infoHash]; ok {
+		delete(c.fetching, infoHash)
+		c.mu.Unlock()
+		pending.cancel()
+		c.saveStateNow()
+		c.bus.Publish(SessionEvent{Type: SessionTorrentRemoved, InfoHash: infoHash})
+		return nil
+	}
+	d, ok := c.torrents[infoHash]
+	if !ok {
+		c.mu.Unlock()
+		return ErrTorrentUnknown
+	}
+	delete(c.torrents, infoHash)
+	for i, h := range c.order {
+		if h == infoHash {
+			c.order = append(c.order[:i:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	err := d.Stop()
+	d.SetEventBus(nil)
+	c.conns.RemoveTorrent(infoHash)
+	if withData {
+		err = errors.Join(err, d.removeFiles())
+	}
+	c.forgetTorrent(d)
+	c.bus.Publish(SessionEvent{Type: SessionTorrentRemoved, InfoHash: infoHash})
+	c.logger.Info("torrent removed", "infohash", fmt.Sprintf("%x", infoHash), "withData", withData, "err", err)
+	return err
+}
+
+// DefaultShutdownTimeout is how long Close waits for the session to shut down
+const DefaultShutdownTimeout = 15 * time.Second
+
+// Close shuts the session down with Shutdown, bounded by the shutdown timeout, see
+// WithShutdownTimeout
+func (c *Client) Close() error {
+	ctx := context.Background()
+	if c.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.shutdownTimeout)
+		defer cancel()
+	}
+	return c.Shutdown(ctx)
+}
+
+// Shutdown shuts the session down in order, so nothing is lost and the swarms hear we left.
+// It saves the session while the downloads still run, stops accepting peers and gives up
+// on magnet links being fetched. Then every download is stopped at once: its peers are
+// disconnected, which cancels the requests in flight, the disk queue is written out, the
+// resume data saved and the trackers told we stopped. Last the port mappings are removed
+// and the DHT is left. When ctx is done before the downloads stopped, the announces still
+// going are given up and Shutdown goes on with the last steps, returning ctx's error along
+// with those of the steps. Hooks still running are waited for until ctx is done, then
+// killed. Files stay, and the session does with a state directory
+func (c *Client) Shutdown(ctx context.Context) error {
+	// saved while the downloads still run, so the ones running are restarted
+	stateErr := c.saveStateNow()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.quit)
+	if c.stopHooks != nil {
+		c.stopHooks()
+	}
+	for _, pending := range c.fetching {
+		pending.cancel()
+	}
+	var downloads []*Download
+	for _, infoHash := range c.order {
+		downloads = append(downloads, c.torrents[infoHash])
+	}
+	c.mu.Unlock()
+	c.logger.Info("shutting down", "torrents", len(downloads))
+
+	// closing the uTP socket disconnects the uTP peers too, a moment before the downloads
+	// stop and disconnect the rest
+	errs := []error{stateErr, c.listener.Close()}
+	if c.utp != nil {
+		errs = append(errs, c.utp.Close())
+	}
+	c.wg.Wait()
+	var wg sync.WaitGroup
+	var running atomic.Int32
+	running.Store(int32(len(downloads)))
+	stopErrs := make([]error, len(downloads))
+	for i, d := range downloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer running.Add(-1)
+			stopErrs[i] = d.stop(ctx)
+		}()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		errs = append(errs, stopErrs...)
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("shutdown: %d torrents still stopping: %w", running.Load(), ctx.Err()))
+	}
+	if c.cancelHooks != nil {
+		errs = append(errs, c.waitHooks(ctx))
+	}
+	if c.mapper != nil {
+		errs = append(errs, c.mapper.Close())
+	}
+	if c.dht != nil {
+		errs = append(errs, c.dht.Close())
+	}
+	err := errors.Join(errs...)
+	c.logger.Info("shut down", "err", err)
+	return err
+}
+
+// this function accepts peer connections until ln is closed, ln is the TCP listener or the
+// uTP socket
+func (c *Client) acceptLoop(ln net.Listener) {
+	defer c.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// running out of file descriptors goes away once connections close
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.handleIncoming(conn)
+		}()
+	}
+}
+
+// this function reads the handshake of an incoming connection and hands it to the download
+// of the infohash it names, or hangs up when there is none. Behind a proxy nobody is
+// supposed to reach us directly, whoever does is hung up on before we say anything
+func (c *Client) handleIncoming(conn net.Conn) {
+	if !c.dialer.AcceptsIncoming() {
+		c.logger.Debug("incoming peer refused behind a proxy", "peer", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Now().Add(defaultHandshakeTimeout))
+	var encryptedFor [20]byte
+	if c.dialer.Encryption != EncryptionDisabled {
+		encrypted, infoHash, err := acceptEncryption(conn, c.dialer.Encryption, c.skey)
+		if err != nil {
+			c.logger.Debug("bad incoming encryption handshake", "peer", conn.RemoteAddr(), "err", err)
+			conn.Close()
+			return
+		}
+		conn, encryptedFor = encrypted, infoHash
+	}
+	h, err := ReadHandshake(conn)
+	if err != nil {
+		c.logger.Debug("bad incoming handshake", "peer", conn.RemoteAddr(), "err", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	if encryptedFor != ([20]byte{}) && h.InfoHash != encryptedFor {
+		c.logger.Debug("incoming peer encrypted for another torrent", "peer", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	d, ok := c.Torrent(h.InfoHash)
+	if !ok {
+		c.logger.Debug("incoming peer for an unknown torrent", "peer", conn.RemoteAddr(), "infohash", fmt.Sprintf("%x", h.InfoHash))
+		conn.Close()
+		return
+	}
+	err = d.AcceptPeer(conn, h)
+	if err != nil {
+		c.logger.Debug("incoming peer refused", "peer", conn.RemoteAddr(), "err", err)
+	}
+}
+
+// this function returns the infohash of the torrent an encrypting peer asks for by its
+// hash, see encryptIncoming
+func (c *Client) skey(req2 []byte) ([20]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for infoHash := range c.torrents {
+		if bytes.Equal(mseHash([]byte("req2"), infoHash[:]), req2) {
+			return infoHash, true
+		}
+	}
+	return [20]byte{}, false
+}
+
+// this function deletes the download's files, their part files and the directories they
+// leave empty, and its resume data. the download must be stopped. backends that don't keep
+// files on disk have nothing to delete
+func (d *Download) removeFiles() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	if d.ResumePath != "" {
+		if err := os.Remove(d.ResumePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	switch d.backend.(type) {
+	case FilesystemStorage, MmapStorage:
+	default:
+		return errors.Join(errs...)
+	}
+	layout := d.fileLayout()
+	paths, err := layout.filePaths(d.Dir)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	suffix := d.partSuffix()
+	for _, path := range paths {
+		for _, name := range []string{path, path + suffix} {
+			// a directory of the torrent replaced by a link mustn't lead us elsewhere
+			if err := confinePath(d.Dir, name); err != nil {
+				errs = append(errs, err)
+			} else if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			if suffix == "" {
+				break
+			}
+		}
+	}
+	if len(layout.Info.Files) > 0 {
+		removeEmptyDirs(filepath.Join(d.Dir, layout.Info.Name), paths)
+	}
+	return errors.Join(errs...)
+}
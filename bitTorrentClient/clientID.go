@@ -0,0 +1,263 @@
+// This file decodes the client name and version out of a peer_id. Most clients follow the
+// Azureus convention (-qB4600-), older ones the Shadow convention (S58B-----) or the
+// Mainline one (M4-3-6--). Knowing who we talk to helps debugging interop problems
+package bittorrentclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ClientInfo struct {
+	Name    string
+	Version string
+}
+
+func (c ClientInfo) String() string {
+	if c.Version == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Version
+}
+
+var azureusClients = map[string]string{
+	"7T": "aTorrent",
+	"A2": "aria2",
+	"AG": "Ares",
+	"AR": "Arctic",
+	"AT": "Artemis",
+	"AV": "Avicora",
+	"AX": "BitPump",
+	"AZ": "Vuze",
+	"BB": "BitBuddy",
+	"BC": "BitComet",
+	"BF": "Bitflu",
+	"BI": "BiglyBT",
+	"BL": "BitCometLite",
+	"BR": "BitRocket",
+	"BT": "BitTorrent",
+	"BW": "BitWombat",
+	"BX": "BittorrentX",
+	"CD": "Enhanced CTorrent",
+	"CT": "CTorrent",
+	"DE": "Deluge",
+	"DP": "Propagate Data Client",
+	"EB": "EBit",
+	"ES": "Electric Sheep",
+	"FC": "FileCroc",
+	"FD": "Free Download Manager",
+	"FG": "FlashGet",
+	"FT": "FoxTorrent",
+	"FW": "FrostWire",
+	"FX": "Freebox BitTorrent",
+	"GN": "goNet",
+	"GR": "GetRight",
+	"GS": "GSTorrent",
+	"HL": "Halite",
+	"HN": "Hydranode",
+	"KG": "KGet",
+	"KT": "KTorrent",
+	"LC": "LeechCraft",
+	"LH": "LH-ABC",
+	"LP": "Lphant",
+	"LT": "libtorrent",
+	"lt": "libTorrent",
+	"LW": "LimeWire",
+	"MO": "MonoTorrent",
+	"MP": "MooPolice",
+	"MR": "Miro",
+	"MT": "MoonlightTorrent",
+	"NX": "Net Transport",
+	"OS": "OneSwarm",
+	"OT": "OmegaTorrent",
+	"PD": "Pando",
+	"PI": "PicoTorrent",
+	"qB": "qBittorrent",
+	"QD": "QQDownload",
+	"QT": "Qt 4 Torrent example",
+	"RT": "Retriever",
+	"RZ": "RezTorrent",
+	"SB": "Swiftbit",
+	"SD": "Thunder",
+	"SM": "SoMud",
+	"SP": "BitSpirit",
+	"SS": "SwarmScope",
+	"ST": "SymTorrent",
+	"st": "sharktorrent",
+	"SZ": "Shareaza",
+	"TN": "TorrentDotNET",
+	"TR": "Transmission",
+	"TS": "Torrentstorm",
+	"TT": "TuoTu",
+	"UL": "uLeecher!",
+	"UM": "µTorrent Mac",
+	"UT": "µTorrent",
+	"UW": "µTorrent Web",
+	"VG": "Vagaa",
+	"WD": "WebTorrent Desktop",
+	"WT": "BitLet",
+	"WW": "WebTorrent",
+	"WY": "FireTorrent",
+	"XF": "Xfplay",
+	"XL": "Xunlei",
+	"XS": "XSwifter",
+	"XT": "XanTorrent",
+	"XX": "Xtorrent",
+	"ZT": "ZipTorrent",
+}
+
+var shadowClients = map[byte]string{
+	'A': "ABC",
+	'O': "Osprey Permaseed",
+	'Q': "BTQueue",
+	'R': "Tribler",
+	'S': "Shadow's client",
+	'T': "BitTornado",
+	'U': "UPnP NAT Bit Torrent",
+}
+
+const shadowAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz.-"
+
+// IdentifyClient returns the client that generated peerID, or "unknown" when it doesn't
+// follow any convention we recognize
+func IdentifyClient(peerID [20]byte) ClientInfo {
+	id := string(peerID[:])
+	if c, ok := identifyAzureus(id); ok {
+		return c
+	}
+	if c, ok := identifyMainline(id); ok {
+		return c
+	}
+	if c, ok := identifyShadow(id); ok {
+		return c
+	}
+	return ClientInfo{Name: "unknown"}
+}
+
+// -XXvvvv- where XX names the client and vvvv is its version
+func identifyAzureus(id string) (ClientInfo, bool) {
+	if id[0] != '-' || id[7] != '-' {
+		return ClientInfo{}, false
+	}
+	code := id[1:3]
+	name, ok := azureusClients[code]
+	if !ok {
+		return ClientInfo{}, false
+	}
+	v := id[3:7]
+	switch code {
+	case "TR":
+		return ClientInfo{Name: name, Version: transmissionVersion(v)}, true
+	case "UT", "UM", "UW":
+		// the last character is a build tag, B for beta
+		version := azureusVersion(v[:3])
+		if v[3] == 'B' {
+			version += " beta"
+		}
+		return ClientInfo{Name: name, Version: version}, true
+	}
+	return ClientInfo{Name: name, Version: azureusVersion(v)}, true
+}
+
+// this function decodes version characters one component each, letters count from 10 so
+// Deluge's -DE13F0- reads as 1.3.15. trailing zero components past the minor are dropped
+func azureusVersion(v string) string {
+	var parts []string
+	for i := 0; i < len(v); i++ {
+		n, ok := versionDigit(v[i])
+		if !ok {
+			break
+		}
+		parts = append(parts, strconv.Itoa(n))
+	}
+	for len(parts) > 2 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// transmission 1.x and 2.x use a major digit plus a two digit minor (-TR2940- is 2.94),
+// 3.x onwards use the usual one digit per component (-TR4000- is 4.0.0)
+func transmissionVersion(v string) string {
+	major, ok := versionDigit(v[0])
+	if !ok {
+		return ""
+	}
+	if major >= 3 {
+		return azureusVersion(v)
+	}
+	minor, err := strconv.Atoi(v[1:3])
+	if err != nil {
+		return strconv.Itoa(major)
+	}
+	version := fmt.Sprintf("%d.%02d", major, minor)
+	switch v[3] {
+	case 'Z', 'X':
+		version += "+"
+	}
+	return version
+}
+
+func versionDigit(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10, true
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 36, true
+	}
+	return 0, false
+}
+
+// M4-3-6-- is mainline 4.3.6, Q for Queen Bee uses the same layout
+func identifyMainline(id string) (ClientInfo, bool) {
+	var name string
+	switch id[0] {
+	case 'M':
+		name = "BitTorrent"
+	case 'Q':
+		name = "Queen Bee"
+	default:
+		return ClientInfo{}, false
+	}
+	end := strings.Index(id[1:], "--")
+	if end < 1 {
+		return ClientInfo{}, false
+	}
+	parts := strings.Split(id[1:1+end], "-")
+	for _, p := range parts {
+		if _, err := strconv.Atoi(p); err != nil {
+			return ClientInfo{}, false
+		}
+	}
+	return ClientInfo{Name: name, Version: strings.Join(parts, ".")}, true
+}
+
+// S58B----- is Shadow's client 5.8.11, one base64ish character per version component
+// followed by dash padding
+func identifyShadow(id string) (ClientInfo, bool) {
+	name, ok := shadowClients[id[0]]
+	if !ok {
+		return ClientInfo{}, false
+	}
+	var parts []string
+	i := 1
+	for ; i < 6 && id[i] != '-'; i++ {
+		n := strings.IndexByte(shadowAlphabet, id[i])
+		if n < 0 {
+			return ClientInfo{}, false
+		}
+		parts = append(parts, strconv.Itoa(n))
+	}
+	if len(parts) == 0 || !strings.HasPrefix(id[i:], "--") {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{Name: name, Version: strings.Join(parts, ".")}, true
+}
+
+// Client returns the client the peer identified itself as in its peer_id
+func (p *Peer) Client() ClientInfo {
+	return IdentifyClient(p.ID)
+}
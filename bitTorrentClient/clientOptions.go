@@ -0,0 +1,337 @@
+// This file holds the options a Client is configured with. NewClient takes any number of
+// them and everything left out has a sensible default: the current directory, the first
+// free port from 6881 to 6889, no rate limits, DefaultConnectionLimits, DefaultUploadSlots
+// per torrent seeding round robin, DefaultRequestQueueTime of each peer's rate requested
+// ahead, peers over TCP only with 32 connecting at once, the DHT on, plaintext connections,
+// our own peer id prefix, no proxy, no logging, nothing kept across restarts, no port
+// mapping, no hooks, no limit on active torrents and DefaultShutdownTimeout for Close
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"mybittorrent/dht"
+)
+
+// the ports tried when none are configured, the range BitTorrent clients traditionally use
+const (
+	defaultFirstPort = DefaultPort
+	defaultLastPort  = DefaultPort + 8
+)
+
+// Option configures a Client, see NewClient
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	dir string
+	// host is the address the listener binds to, empty for every address
+	host                string
+	firstPort, lastPort int
+	uploadLimit         int64
+	downloadLimit       int64
+	connLimits          ConnectionLimits
+	dhtEnabled          bool
+	dht                 dht.Config
+	encryption          EncryptionPolicy
+	peerIDPrefix        string
+	proxy               *SOCKS5Proxy
+	logger              *slog.Logger
+	logLevels           map[string]slog.Level
+	stateDir            string
+	portMapping         bool
+	shutdownTimeout     time.Duration
+	labelDefaults       map[string]LabelDefaults
+	altUploadLimit      int64
+	altDownloadLimit    int64
+	altSchedule         []SpeedSchedule
+	hooks               []Hook
+	maxDownloads        int
+	maxSeeds            int
+	unchoke             chokerSettings
+	utp                 bool
+	bind                OutgoingBind
+	maxHalfOpen         int
+	requestQueueTime    time.Duration
+}
+
+func defaultClientConfig() clientConfig {
+	return clientConfig{
+		dir:              ".",
+		firstPort:        defaultFirstPort,
+		lastPort:         defaultLastPort,
+		connLimits:       DefaultConnectionLimits(),
+		dhtEnabled:       true,
+		peerIDPrefix:     peerIDPrefix,
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		shutdownTimeout:  DefaultShutdownTimeout,
+		unchoke:          chokerSettings{uploadSlots: DefaultUploadSlots},
+		maxHalfOpen:      defaultMaxHalfOpen,
+		requestQueueTime: DefaultRequestQueueTime,
+	}
+}
+
+// this function checks the options for combinations NewClient can't work with
+func (cfg *clientConfig) validate() error {
+	if cfg.firstPort < 0 || cfg.lastPort > 65535 || cfg.firstPort > cfg.lastPort {
+		return fmt.Errorf("invalid listen port range %d-%d", cfg.firstPort, cfg.lastPort)
+	}
+	if cfg.uploadLimit < 0 || cfg.downloadLimit < 0 || cfg.altUploadLimit < 0 || cfg.altDownloadLimit < 0 {
+		return errors.New("rate limits can't be negative")
+	}
+	for _, s := range cfg.altSchedule {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("alternative speed schedule %s: %w", s, err)
+		}
+	}
+	if len(cfg.peerIDPrefix) > 20 {
+		return fmt.Errorf("peer id prefix %q is longer than a peer id", cfg.peerIDPrefix)
+	}
+	if cfg.maxDownloads < 0 || cfg.maxSeeds < 0 {
+		return errors.New("active torrent limits can't be negative")
+	}
+	if cfg.unchoke.uploadSlots < 1 || cfg.unchoke.seedUploadSlots < 0 {
+		return errors.New("a torrent needs at least one upload slot")
+	}
+	if cfg.maxHalfOpen < 0 {
+		return errors.New("half open connection limit can't be negative")
+	}
+	if cfg.requestQueueTime < 0 {
+		return errors.New("request queue time can't be negative")
+	}
+	if cfg.shutdownTimeout < 0 {
+		return errors.New("shutdown timeout can't be negative")
+	}
+	for _, h := range cfg.hooks {
+		if err := h.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithDownloadDir saves torrents under dir
+func WithDownloadDir(dir string) Option {
+	return func(cfg *clientConfig) {
+		cfg.dir = dir
+	}
+}
+
+// WithListenHost makes the client accept peers on host only, e.g. "127.0.0.1", instead of
+// on every address
+func WithListenHost(host string) Option {
+	return func(cfg *clientConfig) {
+		cfg.host = host
+	}
+}
+
+// WithListenPort listens on port, zero picks any free port
+func WithListenPort(port int) Option {
+	return WithListenPortRange(port, port)
+}
+
+// WithListenPortRange listens on the first free port from first to last
+func WithListenPortRange(first, last int) Option {
+	return func(cfg *clientConfig) {
+		cfg.firstPort, cfg.lastPort = first, last
+	}
+}
+
+// WithRateLimits caps the whole session's upload and download in bytes per second, zero
+// is unlimited. They can be changed later through Client.Limiter
+func WithRateLimits(upload, download int64) Option {
+	return func(cfg *clientConfig) {
+		cfg.uploadLimit, cfg.downloadLimit = upload, download
+	}
+}
+
+// WithAltRateLimits sets the alternative session limits in bytes per second, zero is
+// unlimited. They take over from the usual ones while they are on, see Client.SetAltSpeed
+func WithAltRateLimits(upload, download int64) Option {
+	return func(cfg *clientConfig) {
+		cfg.altUploadLimit, cfg.altDownloadLimit = upload, download
+	}
+}
+
+// WithAltSpeedSchedule turns the alternative limits on when one of the windows starts and
+// off when it ends. The client starts with them on inside a window
+func WithAltSpeedSchedule(schedules ...SpeedSchedule) Option {
+	return func(cfg *clientConfig) {
+		cfg.altSchedule = schedules
+	}
+}
+
+// WithMaxActive runs at most downloads torrents that are downloading and seeds that are
+// seeding at once, the others wait in the queue, see queue.go. Zero is unlimited
+func WithMaxActive(downloads, seeds int) Option {
+	return func(cfg *clientConfig) {
+		cfg.maxDownloads, cfg.maxSeeds = downloads, seeds
+	}
+}
+
+// WithHooks runs the hooks' commands on their events, see hooks.go. Torrents restored from
+// the state directory don't count as added
+func WithHooks(hooks ...Hook) Option {
+	return func(cfg *clientConfig) {
+		cfg.hooks = append(cfg.hooks, hooks...)
+	}
+}
+
+// WithConnectionLimits sets how many peers the session and each torrent connect to and
+// how many of them are unchoked, see ConnectionLimits
+func WithConnectionLimits(limits ConnectionLimits) Option {
+	return func(cfg *clientConfig) {
+		cfg.connLimits = limits
+	}
+}
+
+// WithUploadSlots sets how many peers each torrent unchokes at once while downloading and
+// while seeding, zero seedSlots keeps slots. See Download.SetUploadSlots
+func WithUploadSlots(slots, seedSlots int) Option {
+	return func(cfg *clientConfig) {
+		cfg.unchoke.uploadSlots, cfg.unchoke.seedUploadSlots = slots, seedSlots
+	}
+}
+
+// WithSeedStrategy decides who each torrent unchokes while seeding, see SeedStrategy
+func WithSeedStrategy(strategy SeedStrategy) Option {
+	return func(cfg *clientConfig) {
+		cfg.unchoke.seedStrategy = strategy
+	}
+}
+
+// WithUTP turns uTP on or off. With it on, peers can connect to the listening port over uTP
+// as well as TCP, on the same UDP socket as the DHT, and we try uTP first when connecting
+// to them. It is off by default
+func WithUTP(enabled bool) Option {
+	return func(cfg *clientConfig) {
+		cfg.utp = enabled
+	}
+}
+
+// WithOutgoingBind restricts where outgoing peer connections come from, see OutgoingBind.
+// uTP isn't used to connect to peers then, its packets leave from the listening socket
+func WithOutgoingBind(b OutgoingBind) Option {
+	return func(cfg *clientConfig) {
+		cfg.bind = b
+	}
+}
+
+// WithMaxHalfOpen sets how many peer connections may be connecting or handshaking at once,
+// 32 by default and zero is unlimited
+func WithMaxHalfOpen(n int) Option {
+	return func(cfg *clientConfig) {
+		cfg.maxHalfOpen = n
+	}
+}
+
+// WithRequestQueueTime sets how much of each peer's transfer rate every torrent keeps
+// requested ahead, zero keeps DefaultRequestQueueTime. See Download.SetRequestQueueTime
+func WithRequestQueueTime(queueTime time.Duration) Option {
+	return func(cfg *clientConfig) {
+		cfg.requestQueueTime = queueTime
+	}
+}
+
+// WithDHT turns the DHT on or off, without it torrents find their peers from trackers alone
+func WithDHT(enabled bool) Option {
+	return func(cfg *clientConfig) {
+		cfg.dhtEnabled = enabled
+	}
+}
+
+// WithDHTConfig turns the DHT on and configures its node. Addr defaults to the listening
+// port over UDP
+func WithDHTConfig(node dht.Config) Option {
+	return func(cfg *clientConfig) {
+		cfg.dhtEnabled = true
+		cfg.dht = node
+	}
+}
+
+// WithEncryption sets the encryption policy of peer connections, incoming and outgoing.
+// Encrypted connections use MSE, see encryption.go
+func WithEncryption(policy EncryptionPolicy) Option {
+	return func(cfg *clientConfig) {
+		cfg.encryption = policy
+	}
+}
+
+// WithPeerIDPrefix starts our peer ids with prefix instead of our own client code, e.g. to
+// look like another client to trackers that only allow some. It is at most 20 bytes
+func WithPeerIDPrefix(prefix string) Option {
+	return func(cfg *clientConfig) {
+		cfg.peerIDPrefix = prefix
+	}
+}
+
+// WithProxy makes every peer connection through proxy. Our listening port isn't announced
+// to trackers and the DHT, port zero is, and peers connecting to it are refused. Trackers
+// and the DHT aren't proxied, turn the DHT off too to keep our address out of the swarm
+func WithProxy(proxy *SOCKS5Proxy) Option {
+	return func(cfg *clientConfig) {
+		cfg.proxy = proxy
+	}
+}
+
+// WithLogger has the client log to logger, nothing is logged by default. See logging.go
+// for the attributes it logs with
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *clientConfig) {
+		if logger != nil {
+			cfg.logger = logger
+		}
+	}
+}
+
+// WithLogLevels sets the level of components by name, see the Log constants. The empty
+// name sets the level of every component not named. Client.LogLevels changes them later
+func WithLogLevels(levels map[string]slog.Level) Option {
+	return func(cfg *clientConfig) {
+		if cfg.logLevels == nil {
+			cfg.logLevels = make(map[string]slog.Level)
+		}
+		for component, level := range levels {
+			cfg.logLevels[component] = level
+		}
+	}
+}
+
+// WithStateDir keeps the session in dir, the torrents, their settings and resume data, and
+// restores it when a client is made with the same dir again. See sessionState.go
+func WithStateDir(dir string) Option {
+	return func(cfg *clientConfig) {
+		cfg.stateDir = dir
+	}
+}
+
+// WithPortMapping has the client forward its listening port on the gateway while it runs
+// and remove the mappings when it shuts down, see portMapping.go
+func WithPortMapping(enabled bool) Option {
+	return func(cfg *clientConfig) {
+		cfg.portMapping = enabled
+	}
+}
+
+// WithShutdownTimeout bounds how long Close waits for the session to shut down, zero waits
+// until it did. See Client.Shutdown
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(cfg *clientConfig) {
+		cfg.shutdownTimeout = timeout
+	}
+}
+
+// WithLabelDefaults gives the torrents added with label defaults, see LabelDefaults. A
+// torrent with several labels gets the defaults of the first in sorted order that has any
+func WithLabelDefaults(label string, defaults LabelDefaults) Option {
+	return func(cfg *clientConfig) {
+		if cfg.labelDefaults == nil {
+			cfg.labelDefaults = make(map[string]LabelDefaults)
+		}
+		cfg.labelDefaults[strings.TrimSpace(label)] = defaults
+	}
+}
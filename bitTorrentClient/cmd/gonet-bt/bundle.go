@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"mybittorrent/api"
+)
+
+// runExport saves a torrent of a running serve as a bundle, to be imported by another
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	remote := addAPIFlags(flags)
+	out := flags.String("o", "", "file to write the bundle to, - for stdout, <infohash>.bundle when empty")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt export [flags] infohash")
+		fmt.Fprintln(os.Stderr, "the infohash can be shortened to the start list prints")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	client, u, err := remote.dial()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	infoHash, err := resolveInfoHash(client, u, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	u.Path = "/api/v1/torrents/" + infoHash + "/bundle"
+	resp, err := client.Get(u.String())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", apiError(u.String(), resp))
+		return 1
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	switch *out {
+	case "-":
+		_, err = os.Stdout.Write(data)
+	case "":
+		*out = infoHash + ".bundle"
+		fallthrough
+	default:
+		err = os.WriteFile(*out, data, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	if *out != "-" {
+		fmt.Println("exported to", *out)
+	}
+	return 0
+}
+
+// runImport adds a torrent exported by another session to a running serve
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	remote := addAPIFlags(flags)
+	dir := flags.String("dir", "", "directory the data was copied to, where it was exported from when empty")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt import [flags] file.bundle")
+		fmt.Fprintln(os.Stderr, "copy the data keeping modification times, e.g. with rsync -a, so it isn't checked again")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	client, u, err := remote.dial()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	u.Path = "/api/v1/bundles"
+	if *dir != "" {
+		u.RawQuery = url.Values{"dir": {*dir}}.Encode()
+	}
+	resp, err := client.Post(u.String(), "application/json", bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", apiError(u.String(), resp))
+		return 1
+	}
+	var t api.Torrent
+	err = json.NewDecoder(resp.Body).Decode(&t)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	fmt.Printf("imported %s, %.1f%% done, %s\n", t.Name, 100*t.Progress, t.State)
+	return 0
+}
+
+// resolveInfoHash returns the full infohash of the one torrent of the session whose
+// infohash starts with prefix
+func resolveInfoHash(client *http.Client, u url.URL, prefix string) (string, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) == 40 {
+		return prefix, nil
+	}
+	u.Path = "/api/v1/torrents"
+	torrents, err := fetchTorrents(client, u.String())
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, t := range torrents {
+		if strings.HasPrefix(t.InfoHash, prefix) {
+			found = append(found, t.InfoHash)
+		}
+	}
+	switch {
+	case prefix == "" || len(found) == 0:
+		return "", fmt.Errorf("no torrent %q", prefix)
+	case len(found) > 1:
+		return "", fmt.Errorf("%q is the start of %d torrents", prefix, len(found))
+	}
+	return found[0], nil
+}
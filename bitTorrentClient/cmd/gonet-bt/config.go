@@ -0,0 +1,630 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bt "mybittorrent"
+	"mybittorrent/api"
+	"mybittorrent/dht"
+	"mybittorrent/internal/toml"
+)
+
+// envPrefix starts the environment variables that override the config file, the rest is
+// the setting's key in upper case with underscores, e.g. GONET_BT_LIMITS_UPLOAD
+const envPrefix = "GONET_BT_"
+
+// config holds every setting of a session. It starts from the defaults, then the config
+// file, the environment and the flags given on the command line override it in that order
+type config struct {
+	Dir          string
+	Encryption   string
+	PeerIDPrefix string
+	// StateDir keeps the session across runs, empty keeps nothing
+	StateDir string
+
+	ListenHost    string
+	ListenPort    int
+	ListenPortMax int
+	PortMapping   bool
+
+	// limits are in KiB/s
+	UploadLimit    int
+	DownloadLimit  int
+	MaxConnections int
+	MaxPerTorrent  int
+	MaxUnchoked    int
+	// MaxDownloads and MaxSeeds are how many torrents download and seed at once, the
+	// others wait in the queue. Zero is unlimited
+	MaxDownloads int
+	MaxSeeds     int
+	// UploadSlots and SeedUploadSlots are how many peers each torrent unchokes while
+	// downloading and while seeding, zero SeedUploadSlots keeps UploadSlots. SeedStrategy
+	// picks them while seeding, round-robin or fastest-upload
+	UploadSlots     int
+	SeedUploadSlots int
+	SeedStrategy    string
+	// the alternative limits, in KiB/s, and the windows they are on in as
+	// bt.ParseSpeedSchedule reads them
+	AltUploadLimit   int
+	AltDownloadLimit int
+	AltSchedule      []string
+
+	DHT          bool
+	DHTBootstrap []string
+	DHTReadOnly  bool
+	DHTIPv6      bool
+
+	Proxy         string
+	ProxyUsername string
+	ProxyPassword string
+
+	Log      bool
+	LogLevel string
+
+	APIListen string
+	// APIInterface puts the API on the address of a network interface, at APIListen's port
+	APIInterface string
+	// APIAllow are the networks let in, see api.ParseACL
+	APIAllow []string
+	// the API asks for a username and password or a token when they are set
+	APIUsername string
+	APIPassword string
+	APIToken    string
+	// the API is served over TLS with the certificate in APICert and APIKey, or with a
+	// self-signed one made on first use and kept in them, or in the state or config
+	// directory when they are empty
+	APICert       string
+	APIKey        string
+	APISelfSigned bool
+	// APITransmission serves the Transmission RPC at /transmission/rpc as well
+	APITransmission bool
+	// APIGRPCListen serves the gRPC API on this address as well, with the REST API's
+	// interface, networks, TLS and credentials. Empty leaves it off
+	APIGRPCListen string
+
+	// ShutdownTimeout is in seconds, zero waits as long as shutting down takes
+	ShutdownTimeout int
+
+	// the commands run when torrents are added, complete or fail, as a program and its
+	// arguments. HookTimeout is in seconds, zero lets them run as long as they take
+	HookAdded     []string
+	HookCompleted []string
+	HookErrored   []string
+	HookTimeout   int
+
+	// Watch are the watch folders, from [watch.<name>] tables in the order of the file
+	Watch []bt.WatchFolder
+	// Labels are the defaults of torrents with a label, from [label.<name>] tables
+	Labels map[string]*labelConfig
+
+	// dir is the directory of the config file, whether it was there or not
+	dir string
+}
+
+// labelConfig is a [label.<name>] table
+type labelConfig struct {
+	Dir        string
+	RatioLimit float64
+	// SeedTimeLimit is in minutes
+	SeedTimeLimit int
+}
+
+func defaultConfig() *config {
+	limits := bt.DefaultConnectionLimits()
+	return &config{
+		Dir:             ".",
+		Encryption:      bt.EncryptionDisabled.String(),
+		ListenPort:      6881,
+		ListenPortMax:   6889,
+		MaxConnections:  limits.MaxConnections,
+		MaxPerTorrent:   limits.MaxPerTorrent,
+		MaxUnchoked:     limits.MaxUnchoked,
+		UploadSlots:     bt.DefaultUploadSlots,
+		SeedStrategy:    bt.SeedRoundRobin.String(),
+		DHT:             true,
+		DHTIPv6:         true,
+		LogLevel:        "info",
+		APIListen:       "127.0.0.1:8080",
+		APIAllow:        api.DefaultACL,
+		ShutdownTimeout: int(bt.DefaultShutdownTimeout / time.Second),
+	}
+}
+
+// setting ties a key of the config file to the field it sets
+type setting struct {
+	key   string
+	field any
+}
+
+func (c *config) settings() []setting {
+	return []setting{
+		{"dir", &c.Dir},
+		{"state_dir", &c.StateDir},
+		{"encryption", &c.Encryption},
+		{"peer_id_prefix", &c.PeerIDPrefix},
+		{"listen.host", &c.ListenHost},
+		{"listen.port", &c.ListenPort},
+		{"listen.port_max", &c.ListenPortMax},
+		{"listen.port_mapping", &c.PortMapping},
+		{"limits.upload", &c.UploadLimit},
+		{"limits.download", &c.DownloadLimit},
+		{"limits.max_connections", &c.MaxConnections},
+		{"limits.max_per_torrent", &c.MaxPerTorrent},
+		{"limits.max_unchoked", &c.MaxUnchoked},
+		{"limits.max_active_downloads", &c.MaxDownloads},
+		{"limits.max_active_seeds", &c.MaxSeeds},
+		{"limits.upload_slots", &c.UploadSlots},
+		{"limits.seed_upload_slots", &c.SeedUploadSlots},
+		{"limits.seed_strategy", &c.SeedStrategy},
+		{"alt_speed.upload", &c.AltUploadLimit},
+		{"alt_speed.download", &c.AltDownloadLimit},
+		{"alt_speed.schedule", &c.AltSchedule},
+		{"dht.enabled", &c.DHT},
+		{"dht.bootstrap", &c.DHTBootstrap},
+		{"dht.read_only", &c.DHTReadOnly},
+		{"dht.ipv6", &c.DHTIPv6},
+		{"proxy.socks5", &c.Proxy},
+		{"proxy.username", &c.ProxyUsername},
+		{"proxy.password", &c.ProxyPassword},
+		{"log.enabled", &c.Log},
+		{"log.level", &c.LogLevel},
+		{"api.listen", &c.APIListen},
+		{"api.interface", &c.APIInterface},
+		{"api.allow", &c.APIAllow},
+		{"api.username", &c.APIUsername},
+		{"api.password", &c.APIPassword},
+		{"api.token", &c.APIToken},
+		{"api.tls_cert", &c.APICert},
+		{"api.tls_key", &c.APIKey},
+		{"api.tls_self_signed", &c.APISelfSigned},
+		{"api.transmission", &c.APITransmission},
+		{"api.grpc_listen", &c.APIGRPCListen},
+		{"shutdown_timeout", &c.ShutdownTimeout},
+		{"hooks.torrent_added", &c.HookAdded},
+		{"hooks.torrent_completed", &c.HookCompleted},
+		{"hooks.torrent_errored", &c.HookErrored},
+		{"hooks.timeout", &c.HookTimeout},
+	}
+}
+
+func (c *config) setting(key string) (setting, bool) {
+	for _, s := range c.settings() {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// defaultConfigPath is where the config is read from when -config doesn't say
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gonet-bt", "config.toml")
+}
+
+// loadFile applies the config file at path. A missing file is an error only when required
+func (c *config) loadFile(path string, required bool) error {
+	c.dir = filepath.Dir(path)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	values, err := toml.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// the first error by line, so fixing a file goes top to bottom
+	sort.Slice(keys, func(i, j int) bool { return values[keys[i]].Line < values[keys[j]].Line })
+	watches := make(map[string]int)
+	for _, key := range keys {
+		v := values[key]
+		if rest, ok := strings.CutPrefix(key, "watch."); ok {
+			name, field, _ := strings.Cut(rest, ".")
+			err := c.setWatch(watches, name, field, v.V)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s %w", path, v.Line, key, err)
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(key, "label."); ok {
+			name, field, _ := strings.Cut(rest, ".")
+			err := c.setLabel(name, field, v.V)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s %w", path, v.Line, key, err)
+			}
+			continue
+		}
+		s, ok := c.setting(key)
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown setting %s", path, v.Line, key)
+		}
+		err := s.setValue(v.V)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s %w", path, v.Line, key, err)
+		}
+	}
+	for name, i := range watches {
+		if c.Watch[i].Dir == "" {
+			return fmt.Errorf("%s: [watch.%s] needs a dir", path, name)
+		}
+	}
+	return nil
+}
+
+// watchFields maps the keys of a [watch.<name>] table to the field of the folder they set
+var watchFields = map[string]func(*bt.WatchFolder) any{
+	"dir":      func(f *bt.WatchFolder) any { return &f.Dir },
+	"save_dir": func(f *bt.WatchFolder) any { return &f.SaveDir },
+	"labels":   func(f *bt.WatchFolder) any { return &f.Labels },
+	"done_dir": func(f *bt.WatchFolder) any { return &f.DoneDir },
+}
+
+// this function sets field of the watch folder name, adding the folder the first time.
+// watches holds the index in c.Watch of the folders added so far
+func (c *config) setWatch(watches map[string]int, name, field string, v any) error {
+	fieldOf, ok := watchFields[field]
+	if !ok {
+		return errors.New("is not a watch folder setting, those are dir, save_dir, labels and done_dir")
+	}
+	i, ok := watches[name]
+	if !ok {
+		i = len(c.Watch)
+		watches[name] = i
+		c.Watch = append(c.Watch, bt.WatchFolder{})
+	}
+	return setting{field: fieldOf(&c.Watch[i])}.setValue(v)
+}
+
+// labelFields maps the keys of a [label.<name>] table to the field they set
+var labelFields = map[string]func(*labelConfig) any{
+	"dir":             func(l *labelConfig) any { return &l.Dir },
+	"ratio_limit":     func(l *labelConfig) any { return &l.RatioLimit },
+	"seed_time_limit": func(l *labelConfig) any { return &l.SeedTimeLimit },
+}
+
+// this function sets field of the defaults of label name
+func (c *config) setLabel(name, field string, v any) error {
+	fieldOf, ok := labelFields[field]
+	if !ok {
+		return errors.New("is not a label setting, those are dir, ratio_limit and seed_time_limit")
+	}
+	if c.Labels == nil {
+		c.Labels = make(map[string]*labelConfig)
+	}
+	l, ok := c.Labels[name]
+	if !ok {
+		l = &labelConfig{}
+		c.Labels[name] = l
+	}
+	return setting{field: fieldOf(l)}.setValue(v)
+}
+
+// loadEnv applies the environment variables that name a setting
+func (c *config) loadEnv() error {
+	for _, s := range c.settings() {
+		name := envPrefix + strings.ToUpper(strings.NewReplacer(".", "_").Replace(s.key))
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		err := s.setString(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s %w", name, s.key, err)
+		}
+	}
+	return nil
+}
+
+// this function sets the field from a value of the config file
+func (s setting) setValue(v any) error {
+	switch field := s.field.(type) {
+	case *string:
+		str, ok := v.(string)
+		if !ok {
+			return errors.New("must be a string in quotes")
+		}
+		*field = str
+	case *int:
+		n, ok := v.(int64)
+		if !ok {
+			return errors.New("must be a whole number")
+		}
+		*field = int(n)
+	case *float64:
+		switch n := v.(type) {
+		case float64:
+			*field = n
+		case int64:
+			*field = float64(n)
+		default:
+			return errors.New("must be a number")
+		}
+	case *bool:
+		b, ok := v.(bool)
+		if !ok {
+			return errors.New("must be true or false")
+		}
+		*field = b
+	case *[]string:
+		list, ok := v.([]any)
+		if !ok {
+			return errors.New(`must be a list of strings, e.g. ["a", "b"]`)
+		}
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			str, ok := item.(string)
+			if !ok {
+				return errors.New(`must be a list of strings, e.g. ["a", "b"]`)
+			}
+			strs = append(strs, str)
+		}
+		*field = strs
+	}
+	return nil
+}
+
+// this function sets the field from an environment variable or a flag, lists are comma
+// separated
+func (s setting) setString(value string) error {
+	switch field := s.field.(type) {
+	case *string:
+		*field = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be a whole number, not %q", value)
+		}
+		*field = n
+	case *float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number, not %q", value)
+		}
+		*field = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false, not %q", value)
+		}
+		*field = b
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the settings for values the client would refuse, naming the setting
+func (c *config) validate() error {
+	if c.ListenPort < 0 || c.ListenPort > 65535 {
+		return fmt.Errorf("listen.port %d is not a port", c.ListenPort)
+	}
+	if c.ListenPortMax < c.ListenPort || c.ListenPortMax > 65535 {
+		return fmt.Errorf("listen.port_max %d must be a port from listen.port %d up", c.ListenPortMax, c.ListenPort)
+	}
+	for _, limit := range []struct {
+		key   string
+		value int
+	}{
+		{"limits.upload", c.UploadLimit},
+		{"limits.download", c.DownloadLimit},
+		{"limits.max_connections", c.MaxConnections},
+		{"limits.max_per_torrent", c.MaxPerTorrent},
+		{"limits.max_unchoked", c.MaxUnchoked},
+		{"limits.max_active_downloads", c.MaxDownloads},
+		{"limits.max_active_seeds", c.MaxSeeds},
+		{"limits.seed_upload_slots", c.SeedUploadSlots},
+		{"alt_speed.upload", c.AltUploadLimit},
+		{"alt_speed.download", c.AltDownloadLimit},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"hooks.timeout", c.HookTimeout},
+	} {
+		if limit.value < 0 {
+			return fmt.Errorf("%s can't be negative", limit.key)
+		}
+	}
+	for name, l := range c.Labels {
+		if l.RatioLimit < 0 || l.SeedTimeLimit < 0 {
+			return fmt.Errorf("label.%s limits can't be negative", name)
+		}
+	}
+	if _, err := api.ParseACL(c.APIAllow); err != nil {
+		return err
+	}
+	if (c.APICert == "") != (c.APIKey == "") {
+		return errors.New("api.tls_cert and api.tls_key go together")
+	}
+	if c.APIPassword != "" && c.APIUsername == "" {
+		return errors.New("api.password needs an api.username")
+	}
+	if _, err := c.altSchedule(); err != nil {
+		return fmt.Errorf("alt_speed.schedule: %w", err)
+	}
+	if _, err := parseEncryption(c.Encryption); err != nil {
+		return err
+	}
+	if c.UploadSlots < 1 {
+		return errors.New("limits.upload_slots must be at least 1")
+	}
+	if _, err := bt.ParseSeedStrategy(c.SeedStrategy); err != nil {
+		return fmt.Errorf("limits.seed_strategy: %w", err)
+	}
+	if _, err := bt.ParseLogLevels(c.LogLevel); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	return nil
+}
+
+func parseEncryption(s string) (bt.EncryptionPolicy, error) {
+	for _, p := range []bt.EncryptionPolicy{bt.EncryptionDisabled, bt.EncryptionPreferred, bt.EncryptionRequired} {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("encryption %q must be disabled, preferred or required", s)
+}
+
+// apiAddr returns the address the API listens on
+func (c *config) apiAddr() (string, error) {
+	return c.interfaceAddr("api.listen", c.APIListen)
+}
+
+// grpcAddr returns the address the gRPC API listens on, empty when it is off
+func (c *config) grpcAddr() (string, error) {
+	if c.APIGRPCListen == "" {
+		return "", nil
+	}
+	return c.interfaceAddr("api.grpc_listen", c.APIGRPCListen)
+}
+
+// interfaceAddr returns addr, the value of the setting key, moved to the address of
+// api.interface when that is set
+func (c *config) interfaceAddr(key, addr string) (string, error) {
+	if c.APIInterface == "" {
+		return addr, nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	ip, err := api.InterfaceAddr(c.APIInterface)
+	if err != nil {
+		return "", fmt.Errorf("api.interface: %w", err)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// apiTLS reports whether the API is served over TLS, and with which certificate files
+func (c *config) apiTLS() (tls bool, certFile, keyFile string) {
+	if c.APICert != "" || !c.APISelfSigned {
+		return c.APICert != "", c.APICert, c.APIKey
+	}
+	dir := c.StateDir
+	if dir == "" {
+		dir = c.dir
+	}
+	if dir == "" {
+		dir = filepath.Dir(defaultConfigPath())
+	}
+	return true, filepath.Join(dir, "api.crt"), filepath.Join(dir, "api.key")
+}
+
+// apiCredentials returns what a request to the API has to carry, ok is false when the API
+// is open
+func (c *config) apiCredentials() (creds api.Credentials, ok bool) {
+	creds = api.Credentials{Username: c.APIUsername, Password: c.APIPassword, Token: c.APIToken}
+	return creds, c.APIPassword != "" || c.APIToken != ""
+}
+
+func (c *config) altSchedule() ([]bt.SpeedSchedule, error) {
+	var schedules []bt.SpeedSchedule
+	for _, s := range c.AltSchedule {
+		sched, err := bt.ParseSpeedSchedule(s)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched)
+	}
+	return schedules, nil
+}
+
+// options returns the client options of the settings, which validate accepted
+func (c *config) options() []bt.Option {
+	encryption, _ := parseEncryption(c.Encryption)
+	seedStrategy, _ := bt.ParseSeedStrategy(c.SeedStrategy)
+	opts := []bt.Option{
+		bt.WithDownloadDir(c.Dir),
+		bt.WithListenHost(c.ListenHost),
+		bt.WithListenPortRange(c.ListenPort, c.ListenPortMax),
+		bt.WithRateLimits(int64(c.UploadLimit)*1024, int64(c.DownloadLimit)*1024),
+		bt.WithAltRateLimits(int64(c.AltUploadLimit)*1024, int64(c.AltDownloadLimit)*1024),
+		bt.WithConnectionLimits(bt.ConnectionLimits{
+			MaxConnections: c.MaxConnections,
+			MaxPerTorrent:  c.MaxPerTorrent,
+			MaxUnchoked:    c.MaxUnchoked,
+		}),
+		bt.WithMaxActive(c.MaxDownloads, c.MaxSeeds),
+		bt.WithUploadSlots(c.UploadSlots, c.SeedUploadSlots),
+		bt.WithSeedStrategy(seedStrategy),
+		bt.WithDHT(c.DHT),
+		bt.WithEncryption(encryption),
+		bt.WithPortMapping(c.PortMapping),
+		bt.WithShutdownTimeout(time.Duration(c.ShutdownTimeout) * time.Second),
+	}
+	if c.DHT && (len(c.DHTBootstrap) > 0 || c.DHTReadOnly || !c.DHTIPv6) {
+		opts = append(opts, bt.WithDHTConfig(dht.Config{
+			BootstrapNodes: c.DHTBootstrap,
+			ReadOnly:       c.DHTReadOnly,
+			DisableIPv6:    !c.DHTIPv6,
+		}))
+	}
+	for _, h := range []struct {
+		on      bt.SessionEventType
+		command []string
+	}{
+		{bt.SessionTorrentAdded, c.HookAdded},
+		{bt.SessionTorrentCompleted, c.HookCompleted},
+		{bt.SessionTorrentErrored, c.HookErrored},
+	} {
+		if len(h.command) > 0 {
+			opts = append(opts, bt.WithHooks(bt.Hook{
+				On:      h.on,
+				Command: h.command,
+				Timeout: time.Duration(c.HookTimeout) * time.Second,
+			}))
+		}
+	}
+	if schedules, _ := c.altSchedule(); len(schedules) > 0 {
+		opts = append(opts, bt.WithAltSpeedSchedule(schedules...))
+	}
+	if c.StateDir != "" {
+		opts = append(opts, bt.WithStateDir(c.StateDir))
+	}
+	for name, l := range c.Labels {
+		opts = append(opts, bt.WithLabelDefaults(name, bt.LabelDefaults{
+			Dir:           l.Dir,
+			RatioLimit:    l.RatioLimit,
+			SeedTimeLimit: time.Duration(l.SeedTimeLimit) * time.Minute,
+		}))
+	}
+	if c.PeerIDPrefix != "" {
+		opts = append(opts, bt.WithPeerIDPrefix(c.PeerIDPrefix))
+	}
+	if c.Proxy != "" {
+		opts = append(opts, bt.WithProxy(&bt.SOCKS5Proxy{Addr: c.Proxy, Username: c.ProxyUsername, Password: c.ProxyPassword}))
+	}
+	if c.Log {
+		levels, _ := bt.ParseLogLevels(c.LogLevel)
+		if _, ok := levels[""]; !ok {
+			levels[""] = slog.LevelInfo
+		}
+		// the components' levels decide, the handler passes everything
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		opts = append(opts, bt.WithLogger(slog.New(handler)), bt.WithLogLevels(levels))
+	}
+	return opts
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	bt "mybittorrent"
+)
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runCreate hashes a file or directory into a .torrent and prints its magnet link
+func runCreate(args []string) int {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	out := flags.String("o", "", "file to write, the name with .torrent added when empty")
+	var trackers, seeds, nodes stringList
+	flags.Var(&trackers, "tracker", "announce url, may be given several times")
+	flags.Var(&seeds, "httpseed", "HTTP seed url, may be given several times")
+	flags.Var(&nodes, "node", "DHT node host:port for trackerless torrents, may be given several times")
+	name := flags.String("name", "", "torrent name, the base name of the path when empty")
+	pieceKiB := flags.Int64("piece-length", 0, "piece length in KiB, 0 picks one from the size")
+	comment := flags.String("comment", "", "comment to put in the torrent")
+	private := flags.Bool("private", false, "keep peers to the trackers, no DHT or peer exchange")
+	noDate := flags.Bool("no-date", false, "leave out the creation date")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt create [flags] path")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	path := flags.Arg(0)
+	t, err := bt.CreateTorrent(ctx, path, bt.CreateOptions{
+		Name:        *name,
+		PieceLength: *pieceKiB * 1024,
+		Trackers:    trackers,
+		HTTPSeeds:   seeds,
+		Nodes:       nodes,
+		Comment:     *comment,
+		Private:     *private,
+		NoDate:      *noDate,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	data, err := t.Encode()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	if *out == "" {
+		*out = filepath.Base(filepath.Clean(path)) + ".torrent"
+	}
+	err = os.WriteFile(*out, data, 0o644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	fmt.Printf("wrote %s: %d pieces of %s, infohash %x\n", *out, t.NumPieces(), formatBytes(t.Info.PieceLength), t.InfoHash)
+	fmt.Println(t.Magnet())
+	return 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	bt "mybittorrent"
+)
+
+// runDownload downloads one torrent into a directory and exits once it is complete, or
+// keeps seeding until interrupted with -seed
+func runDownload(args []string) int {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	session := addSessionFlags(flags)
+	seed := flags.Bool("seed", false, "keep seeding once the download is complete")
+	verbose := flags.Bool("v", false, "show the progress of every file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt download [flags] file.torrent|magnet-link")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := session.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	client, err := bt.NewClient(cfg.options()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if strings.HasPrefix(flags.Arg(0), "magnet:") {
+		fmt.Println("fetching metadata...")
+	}
+	d, err := addDownload(ctx, client, flags.Arg(0))
+	if err != nil {
+		if ctx.Err() != nil {
+			return 130
+		}
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+
+	display := newProgressDisplay(os.Stdout, *verbose)
+	display.update(d)
+	ticker := time.NewTicker(display.interval())
+	defer ticker.Stop()
+	done := d.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			display.finish(d)
+			fmt.Println("stopping, interrupt again to quit now")
+			return 130
+		case <-done:
+			done = nil
+			display.finish(d)
+			fmt.Printf("%s: complete\n", d.Torrent.Info.Name)
+			if !*seed {
+				return 0
+			}
+		case <-ticker.C:
+			if d.State() == bt.DownloadError {
+				display.finish(d)
+				fmt.Fprintln(os.Stderr, "gonet-bt:", d.Err())
+				return 1
+			}
+			display.update(d)
+		}
+	}
+}
+
+// addDownload adds the torrent file or magnet link arg to the client
+func addDownload(ctx context.Context, client *bt.Client, arg string) (*bt.Download, error) {
+	if strings.HasPrefix(arg, "magnet:") {
+		return client.AddMagnet(ctx, arg)
+	}
+	return client.AddTorrentFile(arg)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	bt "mybittorrent"
+)
+
+// inspection is what inspect prints about a torrent
+type inspection struct {
+	Name        string          `json:"name"`
+	InfoHash    string          `json:"info_hash"`
+	InfoHashV2  string          `json:"info_hash_v2,omitempty"`
+	Size        int64           `json:"size"`
+	PieceLength int64           `json:"piece_length"`
+	Pieces      int             `json:"pieces"`
+	Private     bool            `json:"private"`
+	Created     *time.Time      `json:"created,omitempty"`
+	CreatedBy   string          `json:"created_by,omitempty"`
+	Comment     string          `json:"comment,omitempty"`
+	Trackers    [][]string      `json:"trackers,omitempty"`
+	HTTPSeeds   []string        `json:"http_seeds,omitempty"`
+	Nodes       []string        `json:"nodes,omitempty"`
+	Files       []inspectedFile `json:"files"`
+	Magnet      string          `json:"magnet"`
+}
+
+type inspectedFile struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// runInspect prints the metadata of a .torrent
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt inspect [-json] file.torrent")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	t, err := bt.LoadTorrent(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	in := inspect(t)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(in)
+	} else {
+		err = in.writeText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	return 0
+}
+
+func inspect(t *bt.Torrent) inspection {
+	in := inspection{
+		Name:        t.Info.Name,
+		InfoHash:    fmt.Sprintf("%x", t.InfoHash),
+		Size:        t.TotalLength(),
+		PieceLength: t.Info.PieceLength,
+		Pieces:      t.NumPieces(),
+		Private:     t.Info.Private == 1,
+		CreatedBy:   t.CreatedBy,
+		Comment:     t.Comment,
+		Trackers:    t.AnnounceList,
+		HTTPSeeds:   t.HTTPSeeds,
+		Nodes:       t.Nodes,
+		Magnet:      t.Magnet().String(),
+	}
+	if t.IsV2() {
+		in.InfoHashV2 = fmt.Sprintf("%x", t.InfoHashV2)
+	}
+	if len(in.Trackers) == 0 && t.Announce != "" {
+		in.Trackers = [][]string{{t.Announce}}
+	}
+	if created, ok := t.CreatedAt(); ok {
+		in.Created = &created
+	}
+	if len(t.Info.Files) == 0 {
+		in.Files = []inspectedFile{{Path: t.Info.Name, Length: t.Info.Length}}
+	}
+	for _, f := range t.Info.Files {
+		if f.IsPadding() {
+			continue
+		}
+		in.Files = append(in.Files, inspectedFile{Path: strings.Join(append([]string{t.Info.Name}, f.Path...), "/"), Length: f.Length})
+	}
+	return in
+}
+
+func (in inspection) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "name:       %s\n", in.Name)
+	fmt.Fprintf(&b, "infohash:   %s\n", in.InfoHash)
+	if in.InfoHashV2 != "" {
+		fmt.Fprintf(&b, "infohash2:  %s\n", in.InfoHashV2)
+	}
+	fmt.Fprintf(&b, "size:       %s (%d bytes)\n", formatBytes(in.Size), in.Size)
+	fmt.Fprintf(&b, "pieces:     %d of %s\n", in.Pieces, formatBytes(in.PieceLength))
+	fmt.Fprintf(&b, "private:    %t\n", in.Private)
+	if in.Created != nil {
+		fmt.Fprintf(&b, "created:    %s\n", in.Created.Format(time.RFC3339))
+	}
+	if in.CreatedBy != "" {
+		fmt.Fprintf(&b, "created by: %s\n", in.CreatedBy)
+	}
+	if in.Comment != "" {
+		fmt.Fprintf(&b, "comment:    %s\n", in.Comment)
+	}
+	for i, tier := range in.Trackers {
+		fmt.Fprintf(&b, "tier %d:     %s\n", i, strings.Join(tier, " "))
+	}
+	for _, seed := range in.HTTPSeeds {
+		fmt.Fprintf(&b, "http seed:  %s\n", seed)
+	}
+	for _, node := range in.Nodes {
+		fmt.Fprintf(&b, "node:       %s\n", node)
+	}
+	fmt.Fprintf(&b, "files:\n")
+	for _, f := range in.Files {
+		fmt.Fprintf(&b, "  %10s  %s\n", formatBytes(f.Length), f.Path)
+	}
+	fmt.Fprintf(&b, "magnet:     %s\n", in.Magnet)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"mybittorrent/api"
+)
+
+// runList prints the torrents of a session running with serve, asking its API
+func runList(args []string) int {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	remote := addAPIFlags(flags)
+	label := flags.String("label", "", "list only the torrents with this label")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt list [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	client, u, err := remote.dial()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	u.Path = "/api/v1/torrents"
+	if *label != "" {
+		u.RawQuery = url.Values{"label": {*label}}.Encode()
+	}
+	torrents, err := fetchTorrents(client, u.String())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	for _, t := range torrents {
+		fmt.Printf("%s  %-11s %5.1f%%  ↓ %10s  ↑ %10s  %5.2f  %s",
+			t.InfoHash[:8], t.State, 100*t.Progress, formatRate(t.DownloadRate), formatRate(t.UploadRate), t.Ratio, t.Name)
+		if len(t.Labels) > 0 {
+			fmt.Printf("  [%s]", strings.Join(t.Labels, ", "))
+		}
+		fmt.Println()
+	}
+	return 0
+}
+
+func fetchTorrents(client *http.Client, u string) ([]api.Torrent, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(u, resp)
+	}
+	var torrents []api.Torrent
+	err = json.NewDecoder(resp.Body).Decode(&torrents)
+	return torrents, err
+}
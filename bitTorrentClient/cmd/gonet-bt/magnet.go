@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	bt "mybittorrent"
+)
+
+// runMagnet prints the magnet link of each .torrent given
+func runMagnet(args []string) int {
+	flags := flag.NewFlagSet("magnet", flag.ExitOnError)
+	noTrackers := flags.Bool("no-trackers", false, "leave the trackers out of the link")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt magnet [-no-trackers] file.torrent...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	status := 0
+	for _, path := range flags.Args() {
+		t, err := bt.LoadTorrent(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+			status = 1
+			continue
+		}
+		m := t.Magnet()
+		if *noTrackers {
+			m.Trackers = nil
+		}
+		fmt.Println(m)
+	}
+	return status
+}
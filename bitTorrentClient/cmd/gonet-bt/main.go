@@ -0,0 +1,50 @@
+// Command gonet-bt is the command line front end of the client. Each task is a subcommand,
+// run gonet-bt without arguments for the list
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"download", "download a .torrent or magnet link", runDownload},
+	{"create", "make a .torrent from a file or directory", runCreate},
+	{"inspect", "show what a .torrent holds", runInspect},
+	{"verify", "check data on disk against a .torrent", runVerify},
+	{"magnet", "print the magnet link of a .torrent", runMagnet},
+	{"scrape", "ask trackers and the DHT for a swarm's size", runScrape},
+	{"tui", "watch and control torrents full screen", runTUI},
+	{"serve", "run headless, controlled over an HTTP API", runServe},
+	{"list", "list the torrents of a running serve", runList},
+	{"export", "save a torrent of a running serve as a bundle", runExport},
+	{"import", "add a bundle exported by another session to a running serve", runImport},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "gonet-bt: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gonet-bt <command> [arguments]\n\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+}
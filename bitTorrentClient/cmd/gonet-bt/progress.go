@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	bt "mybittorrent"
+)
+
+const (
+	// a terminal is redrawn this often, a log gets a line every plainInterval
+	ttyInterval   = 500 * time.Millisecond
+	plainInterval = 10 * time.Second
+	// the width assumed when COLUMNS doesn't say
+	defaultColumns = 80
+	// the narrowest a progress bar gets, the rest of its line is labels
+	minBarWidth = 10
+)
+
+// progressDisplay shows how a download is going. On a terminal it redraws a few lines in
+// place: a bar with the percentage, the rates, peers and ETA, and with verbose a bar for
+// every file. Anywhere else, a pipe or a log file, it prints a plain line at a time
+type progressDisplay struct {
+	w       io.Writer
+	tty     bool
+	verbose bool
+	columns int
+	// drawn is the number of lines the last redraw left on the terminal
+	drawn int
+}
+
+// newProgressDisplay returns a display writing to f, redrawing in place when f is a
+// terminal that understands escape codes
+func newProgressDisplay(f *os.File, verbose bool) *progressDisplay {
+	p := &progressDisplay{w: f, verbose: verbose, columns: defaultColumns}
+	if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.tty = os.Getenv("TERM") != "dumb"
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		p.columns = columns
+	}
+	return p
+}
+
+// interval is how often update should be called
+func (p *progressDisplay) interval() time.Duration {
+	if p.tty {
+		return ttyInterval
+	}
+	return plainInterval
+}
+
+// update shows the download's current progress
+func (p *progressDisplay) update(d *bt.Download) {
+	stats := d.Stats()
+	if !p.tty {
+		fmt.Fprintf(p.w, "%s %s\n", time.Now().Format(time.TimeOnly), p.summary(d, stats))
+		return
+	}
+	var lines []string
+	name := d.Torrent.Info.Name
+	percent := progressPercent(d.Torrent.TotalLength(), stats.Left)
+	label := fmt.Sprintf(" %5.1f%% %s", percent, stats.State)
+	lines = append(lines, truncate(name, p.columns))
+	lines = append(lines, p.bar(percent/100, p.columns-len(label))+label)
+	lines = append(lines, truncate(p.rates(stats), p.columns))
+	if p.verbose {
+		for _, f := range d.FileProgress() {
+			label := fmt.Sprintf(" %5.1f%% ", 100*f.Progress())
+			barWidth := max(minBarWidth, p.columns/3)
+			path := truncate(f.Path, p.columns-barWidth-len(label))
+			lines = append(lines, p.bar(f.Progress(), barWidth)+label+path)
+		}
+	}
+	p.redraw(lines)
+}
+
+// finish leaves the last progress on screen and moves past it
+func (p *progressDisplay) finish(d *bt.Download) {
+	p.update(d)
+	p.drawn = 0
+}
+
+// this function replaces the lines drawn last time with lines
+func (p *progressDisplay) redraw(lines []string) {
+	var b strings.Builder
+	if p.drawn > 0 {
+		// back to the start of the first line drawn last time
+		fmt.Fprintf(&b, "\x1b[%dF", p.drawn)
+	}
+	for _, line := range lines {
+		b.WriteString("\x1b[2K")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	// lines left over from a taller redraw, e.g. before the metadata arrived
+	for i := len(lines); i < p.drawn; i++ {
+		b.WriteString("\x1b[2K\n")
+	}
+	if extra := p.drawn - len(lines); extra > 0 {
+		fmt.Fprintf(&b, "\x1b[%dF", extra)
+	}
+	p.drawn = len(lines)
+	io.WriteString(p.w, b.String())
+}
+
+// summary is the one line the plain display prints
+func (p *progressDisplay) summary(d *bt.Download, stats bt.DownloadStats) string {
+	total := d.Torrent.TotalLength()
+	return fmt.Sprintf("%s: %s %.1f%% of %s, %s",
+		d.Torrent.Info.Name, stats.State, progressPercent(total, stats.Left), formatBytes(total), p.rates(stats))
+}
+
+func (p *progressDisplay) rates(stats bt.DownloadStats) string {
+	return fmt.Sprintf("↓ %s  ↑ %s  peers %d (%d seeds)  eta %s",
+		formatRate(stats.DownloadRate), formatRate(stats.UploadRate), stats.Peers, stats.Seeds, formatETA(stats.ETA))
+}
+
+// this function draws a bar width characters wide filled to fraction
+func (p *progressDisplay) bar(fraction float64, width int) string {
+	width = max(width, minBarWidth)
+	inner := width - 2
+	filled := int(fraction * float64(inner))
+	filled = min(max(filled, 0), inner)
+	bar := strings.Repeat("=", filled)
+	if filled < inner {
+		bar += ">" + strings.Repeat(" ", inner-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+// progressPercent returns how much of total is there with left still missing
+func progressPercent(total, left int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(total-left) / float64(total)
+}
+
+// truncate cuts s down to width characters, marking the cut
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width < 1 || len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"mybittorrent/api"
+)
+
+// apiFlags are the flags of the subcommands that talk to a session running with serve. The
+// address, credentials and certificate come from the config file, -api overrides the address
+type apiFlags struct {
+	configPath *string
+	addr       *string
+	insecure   *bool
+}
+
+// addAPIFlags defines the API flags on flags
+func addAPIFlags(flags *flag.FlagSet) *apiFlags {
+	return &apiFlags{
+		configPath: flags.String("config", defaultConfigPath(), "config file to take the API address from"),
+		addr:       flags.String("api", "", "address of the API, the config's api.listen or api.interface when empty"),
+		insecure:   flags.Bool("insecure", false, "don't verify the API's TLS certificate"),
+	}
+}
+
+// dial returns a client for the API and its URL without a path
+func (f *apiFlags) dial() (*http.Client, url.URL, error) {
+	cfg := defaultConfig()
+	err := cfg.loadFile(*f.configPath, false)
+	if err == nil {
+		err = cfg.loadEnv()
+	}
+	if err != nil {
+		return nil, url.URL{}, err
+	}
+	addr := *f.addr
+	if addr == "" {
+		addr, err = cfg.apiAddr()
+		if err != nil {
+			return nil, url.URL{}, err
+		}
+	}
+	client, scheme, err := apiClient(cfg, *f.insecure)
+	if err != nil {
+		return nil, url.URL{}, err
+	}
+	return client, url.URL{Scheme: scheme, Host: addr}, nil
+}
+
+// apiClient returns a client for the API the config describes, sending its credentials and
+// trusting its certificate, and the scheme to reach it with
+func apiClient(cfg *config, insecure bool) (*http.Client, string, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client := &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	if creds, ok := cfg.apiCredentials(); ok {
+		client.Transport = authTransport{creds: creds, next: transport}
+	}
+	ok, certFile, _ := cfg.apiTLS()
+	if !ok {
+		return client, "http", nil
+	}
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	if !insecure {
+		// a self-signed certificate is its own root
+		pem, err := os.ReadFile(certFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("api certificate: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("api certificate: no certificate in %s", certFile)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+	return client, "https", nil
+}
+
+// authTransport adds the API credentials to the requests going through it, the token when
+// there is one
+type authTransport struct {
+	creds api.Credentials
+	next  http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if t.creds.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+t.creds.Token)
+	} else {
+		r.SetBasicAuth(t.creds.Username, t.creds.Password)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// apiError returns the error the API answered a request to u with
+func apiError(u string, resp *http.Response) error {
+	var e struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&e)
+	return fmt.Errorf("%s: %s %s", u, resp.Status, e.Error)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	bt "mybittorrent"
+	"mybittorrent/dht"
+)
+
+// runScrape prints the swarm size of a torrent as its trackers and the DHT see it
+func runScrape(args []string) int {
+	flags := flag.NewFlagSet("scrape", flag.ExitOnError)
+	var trackers stringList
+	flags.Var(&trackers, "tracker", "tracker announce url to ask as well, may be given several times")
+	useDHT := flags.Bool("dht", false, "estimate the swarm size from the DHT too (BEP 33)")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for answers")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt scrape [flags] file.torrent|magnet-link|infohash")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	infoHash, known, err := scrapeTarget(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	trackers = append(known, trackers...)
+	if len(trackers) == 0 && !*useDHT {
+		fmt.Fprintln(os.Stderr, "gonet-bt: no trackers to ask, give some with -tracker or use -dht")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	status := 1
+	for _, tracker := range trackers {
+		res, err := bt.ScrapeTracker(ctx, tracker, infoHash)
+		if err != nil {
+			fmt.Printf("%s: %v\n", tracker, err)
+			continue
+		}
+		s, ok := res[infoHash]
+		if !ok {
+			fmt.Printf("%s: torrent not known\n", tracker)
+			continue
+		}
+		status = 0
+		fmt.Printf("%s: %d seeders, %d leechers, %d completed\n", tracker, s.Seeders, s.Leechers, s.Completed)
+	}
+	if *useDHT {
+		s, err := scrapeDHT(ctx, infoHash)
+		if err != nil {
+			fmt.Printf("dht: %v\n", err)
+		} else {
+			status = 0
+			fmt.Printf("dht: about %d seeders, %d leechers\n", s.Seeds, s.Leechers)
+		}
+	}
+	return status
+}
+
+// scrapeTarget returns the infohash and trackers of a .torrent file, a magnet link or a
+// bare infohash in hex
+func scrapeTarget(arg string) ([20]byte, []string, error) {
+	var infoHash [20]byte
+	if strings.HasPrefix(arg, "magnet:") {
+		m, err := bt.ParseMagnet(arg)
+		if err != nil {
+			return infoHash, nil, err
+		}
+		return m.InfoHash, m.Trackers, nil
+	}
+	if raw, err := hex.DecodeString(arg); err == nil && len(raw) == len(infoHash) {
+		copy(infoHash[:], raw)
+		return infoHash, nil, nil
+	}
+	t, err := bt.LoadTorrent(arg)
+	if err != nil {
+		return infoHash, nil, err
+	}
+	return t.InfoHash, t.Magnet().Trackers, nil
+}
+
+// scrapeDHT joins the DHT for the time of one scrape, read only so no node keeps us in its
+// table after we're gone
+func scrapeDHT(ctx context.Context, infoHash [20]byte) (dht.ScrapeResult, error) {
+	node, err := dht.New(dht.Config{Addr: ":0", ReadOnly: true})
+	if err != nil {
+		return dht.ScrapeResult{}, err
+	}
+	defer node.Close()
+	err = node.Bootstrap(ctx, dht.DefaultBootstrapNodes...)
+	if err != nil {
+		return dht.ScrapeResult{}, fmt.Errorf("joining the DHT: %w", err)
+	}
+	return node.Scrape(ctx, infoHash)
+}
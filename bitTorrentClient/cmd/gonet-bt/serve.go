@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	bt "mybittorrent"
+	"mybittorrent/api"
+	"mybittorrent/api/gonetpb"
+)
+
+// how long a shutdown waits for API requests in flight
+const apiShutdownTimeout = 5 * time.Second
+
+// runServe runs a session without a terminal, driven through the HTTP API
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	session := addSessionFlags(flags)
+	flags.String("api", defaultConfig().APIListen, "address to serve the HTTP API on")
+	addWatchFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt serve [flags] [file.torrent|magnet-link ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg, err := session.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	client, err := bt.NewClient(cfg.options()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	defer client.Close()
+	watcher, err := newWatcher(client, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	addr, err := cfg.apiAddr()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	acl, err := api.ParseACL(cfg.APIAllow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	ln = api.RestrictListener(ln, acl)
+	tlsConfig, err := apiTLSConfig(addr, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		scheme = "https"
+	}
+	handler := api.NewServer(client)
+	defer handler.Close()
+	var h http.Handler = handler
+	if cfg.APITransmission {
+		rpc := api.NewTransmissionRPC(client)
+		defer rpc.Close()
+		mux := http.NewServeMux()
+		mux.Handle("/transmission/rpc", rpc)
+		mux.Handle("/", handler)
+		h = mux
+	}
+	if creds, ok := cfg.apiCredentials(); ok {
+		h, err = api.RequireAuth(h, creds)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+			return 2
+		}
+	}
+	server := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	grpcServer, grpcLn, err := listenGRPC(client, cfg, acl, tlsConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if watcher != nil {
+		go watcher.Run(ctx)
+	}
+	for _, arg := range flags.Args() {
+		go func() {
+			_, err := addDownload(ctx, client, arg)
+			if err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "gonet-bt: %s: %v\n", arg, err)
+			}
+		}()
+	}
+
+	fmt.Printf("serving the API on %s://%s, peers on port %d\n", scheme, ln.Addr(), client.Port())
+	served := make(chan error, 2)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+	if grpcServer != nil {
+		defer grpcServer.Close()
+		fmt.Printf("serving the gRPC API on %s\n", grpcLn.Addr())
+		go func() {
+			served <- grpcServer.Serve(grpcLn)
+		}()
+	}
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		// a second signal kills us right away
+		stop()
+		fmt.Println("shutting down, interrupt again to quit now")
+		// event streams only end once the API is closed
+		handler.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+		defer cancel()
+		if grpcServer != nil {
+			grpcServer.Shutdown(shutdownCtx)
+		}
+		err = server.Shutdown(shutdownCtx)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	err = client.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 1
+	}
+	return 0
+}
+
+// apiTLSConfig returns the TLS config the API is served with, nil when the config asks for
+// none. A self-signed certificate's fingerprint is printed for clients to check
+func apiTLSConfig(addr string, cfg *config) (*tls.Config, error) {
+	ok, certFile, keyFile := cfg.apiTLS()
+	if !ok {
+		return nil, nil
+	}
+	var hosts []string
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		hosts = append(hosts, host)
+	}
+	var cert tls.Certificate
+	var err error
+	if cfg.APISelfSigned {
+		cert, err = api.LoadOrCreateCertificate(certFile, keyFile, hosts)
+		if err == nil {
+			fmt.Printf("API certificate %s, SHA-256 fingerprint %s\n", certFile, api.Fingerprint(cert))
+		}
+	} else {
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			err = fmt.Errorf("api certificate: %w", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// grpcAPI is the gRPC API being served, see listenGRPC
+type grpcAPI struct {
+	*grpc.Server
+	api *api.GRPCServer
+}
+
+// listenGRPC starts listening for the gRPC API when the config asks for it, with the same
+// networks, TLS and credentials as the REST API. It returns a nil server when it is off
+func listenGRPC(client *bt.Client, cfg *config, acl api.ACL, tlsConfig *tls.Config) (*grpcAPI, net.Listener, error) {
+	addr, err := cfg.grpcAddr()
+	if err != nil || addr == "" {
+		return nil, nil, err
+	}
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if creds, ok := cfg.apiCredentials(); ok {
+		auth, err := api.RequireAuthGRPC(creds)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, auth...)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &grpcAPI{Server: grpc.NewServer(opts...), api: api.NewGRPCServer(client)}
+	gonetpb.RegisterSessionServer(s.Server, s.api)
+	return s, api.RestrictListener(ln, acl), nil
+}
+
+// Shutdown ends the streams and waits for the calls in flight until ctx is done, then
+// drops them
+func (s *grpcAPI) Shutdown(ctx context.Context) {
+	s.api.Close()
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// Close stops the server and its API, it is safe after Shutdown
+func (s *grpcAPI) Close() {
+	s.Stop()
+	s.api.Close()
+}
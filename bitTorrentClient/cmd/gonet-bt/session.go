@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	bt "mybittorrent"
+)
+
+// sessionFlags are the flags of the subcommands that run a client. Their settings come from
+// the config file and the environment first, see config.go, a flag given overrides them
+type sessionFlags struct {
+	flags      *flag.FlagSet
+	configPath *string
+}
+
+// flagSettings maps the flags that override a setting to its key
+var flagSettings = map[string]string{
+	"dir":       "dir",
+	"state":     "state_dir",
+	"up":        "limits.upload",
+	"down":      "limits.download",
+	"proxy":     "proxy.socks5",
+	"log":       "log.enabled",
+	"log-level": "log.level",
+	"api":       "api.listen",
+}
+
+// addSessionFlags defines the session flags on flags
+func addSessionFlags(flags *flag.FlagSet) *sessionFlags {
+	defaults := defaultConfig()
+	f := &sessionFlags{
+		flags:      flags,
+		configPath: flags.String("config", defaultConfigPath(), "config file, settings missing from it keep their defaults"),
+	}
+	flags.String("dir", defaults.Dir, "directory to save the data to")
+	flags.String("state", "", "directory to keep the session in, restored on the next run")
+	flags.Int("port", 0, "port to accept peers on, 0 tries 6881 to 6889")
+	flags.Bool("no-dht", false, "find peers from trackers only")
+	flags.Int("up", 0, "upload limit in KiB/s, 0 is unlimited")
+	flags.Int("down", 0, "download limit in KiB/s, 0 is unlimited")
+	flags.String("proxy", "", "SOCKS5 proxy for peer connections, host:port")
+	flags.Bool("log", false, "log what the client does to stderr")
+	flags.String("log-level", defaults.LogLevel, "levels to log at with -log, e.g. warn,dht=debug,tracker=info")
+	return f
+}
+
+// load returns the settings of the config file, the environment and the flags given, in
+// that order
+func (f *sessionFlags) load() (*config, error) {
+	cfg := defaultConfig()
+	given := make(map[string]*flag.Flag)
+	f.flags.Visit(func(fl *flag.Flag) {
+		given[fl.Name] = fl
+	})
+	// a config file named on the command line has to be there, the default one doesn't
+	_, explicit := given["config"]
+	if *f.configPath != "" {
+		err := cfg.loadFile(*f.configPath, explicit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := cfg.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+	for name, fl := range given {
+		switch name {
+		case "port":
+			port, _ := strconv.Atoi(fl.Value.String())
+			if port != 0 {
+				cfg.ListenPort, cfg.ListenPortMax = port, port
+			}
+		case "watch":
+			cfg.Watch = append(cfg.Watch, bt.WatchFolder{Dir: fl.Value.String()})
+		case "no-dht":
+			noDHT, _ := strconv.ParseBool(fl.Value.String())
+			cfg.DHT = !noDHT
+		default:
+			key, ok := flagSettings[name]
+			if !ok {
+				continue
+			}
+			s, _ := cfg.setting(key)
+			err := s.setString(fl.Value.String())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// addWatchFlag defines -watch on flags, for the subcommands that keep running
+func addWatchFlag(flags *flag.FlagSet) {
+	flags.String("watch", "", "folder to add the .torrent and .magnet files saved into, besides the config's")
+}
+
+// newWatcher returns the watcher of the config's watch folders, nil when there are none
+func newWatcher(client *bt.Client, cfg *config) (*bt.Watcher, error) {
+	if len(cfg.Watch) == 0 {
+		return nil, nil
+	}
+	return bt.NewWatcher(client, cfg.Watch)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// the keys the TUI tells apart, anything else printable arrives as itself
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyEnter     = "enter"
+	keyEscape    = "esc"
+	keyTab       = "tab"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl-c"
+)
+
+// terminal puts the terminal on stdin into raw mode through stty, which every Unix has,
+// so the TUI gets keys as they are pressed and they aren't echoed
+type terminal struct {
+	in *os.File
+	// saved is the stty state to restore on close
+	saved string
+}
+
+func openTerminal() (*terminal, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, errors.New("stdin is not a terminal")
+	}
+	t := &terminal{in: os.Stdin}
+	saved, err := t.stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("can't control the terminal: %w", err)
+	}
+	t.saved = strings.TrimSpace(saved)
+	_, err = t.stty("raw", "-echo")
+	if err != nil {
+		return nil, fmt.Errorf("can't control the terminal: %w", err)
+	}
+	return t, nil
+}
+
+func (t *terminal) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.in
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// size returns the terminal's rows and columns, 24 by 80 when stty doesn't tell
+func (t *terminal) size() (rows, columns int) {
+	out, err := t.stty("size")
+	if err == nil {
+		if _, err := fmt.Sscan(out, &rows, &columns); err == nil && rows > 0 && columns > 0 {
+			return rows, columns
+		}
+	}
+	return 24, 80
+}
+
+// close puts the terminal back the way it was
+func (t *terminal) close() error {
+	_, err := t.stty(t.saved)
+	return err
+}
+
+// readKeys sends every key read from r on keys until r fails
+func readKeys(r io.Reader, keys chan<- string) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			close(keys)
+			return
+		}
+		switch b {
+		case 0x1b:
+			keys <- readEscape(br)
+		case '\r', '\n':
+			keys <- keyEnter
+		case '\t':
+			keys <- keyTab
+		case 0x7f, 0x08:
+			keys <- keyBackspace
+		case 0x03:
+			keys <- keyCtrlC
+		default:
+			if b < 0x20 {
+				continue
+			}
+			br.UnreadByte()
+			r, _, err := br.ReadRune()
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(r)
+		}
+	}
+}
+
+// readEscape decodes the arrow keys from the escape sequence that follows an escape byte.
+// a lone escape is the escape key
+func readEscape(br *bufio.Reader) string {
+	if br.Buffered() == 0 {
+		return keyEscape
+	}
+	b, _ := br.ReadByte()
+	if b != '[' && b != 'O' {
+		return keyEscape
+	}
+	// the sequence ends with a letter or a tilde, parameters come before it
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return keyEscape
+		}
+		switch c {
+		case 'A':
+			return keyUp
+		case 'B':
+			return keyDown
+		case 'C':
+			return keyRight
+		case 'D':
+			return keyLeft
+		}
+		if c >= 0x40 && c <= 0x7e {
+			return ""
+		}
+	}
+}
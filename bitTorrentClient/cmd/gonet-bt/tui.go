@@ -0,0 +1,589 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	bt "mybittorrent"
+)
+
+// the TUI redraws this often when no key is pressed
+const tuiInterval = time.Second
+
+// the columns the torrent list can be sorted by, in the order s cycles through them
+const (
+	sortName = iota
+	sortState
+	sortProgress
+	sortDown
+	sortUp
+	sortRatio
+	sortPeers
+	sortQueue
+	numSortColumns
+)
+
+// the tabs of the detail pane
+const (
+	tabFiles = iota
+	tabPeers
+	tabTrackers
+	numTabs
+)
+
+var tabNames = [numTabs]string{"files", "peers", "trackers"}
+
+// runTUI runs a full screen dashboard of every torrent the session holds, with keys to
+// pause, resume, remove and add them and to switch to the alternative speed limits
+func runTUI(args []string) int {
+	flags := flag.NewFlagSet("tui", flag.ExitOnError)
+	session := addSessionFlags(flags)
+	addWatchFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gonet-bt tui [flags] [file.torrent|magnet-link ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg, err := session.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	client, err := bt.NewClient(cfg.options()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	defer client.Close()
+	watcher, err := newWatcher(client, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+
+	term, err := openTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gonet-bt:", err)
+		return 2
+	}
+	defer term.close()
+	// the alternate screen leaves the shell's scrollback alone, the cursor stays hidden
+	io.WriteString(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(os.Stdout, "\x1b[?25h\x1b[?1049l")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ui := &tui{client: client, ctx: ctx, notes: make(chan string, 8)}
+	if watcher != nil {
+		go watcher.Run(ctx)
+	}
+	for _, arg := range flags.Args() {
+		ui.add(arg)
+	}
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(tuiInterval)
+	defer ticker.Stop()
+	for {
+		ui.rows, ui.columns = term.size()
+		io.WriteString(os.Stdout, ui.render())
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		case note := <-ui.notes:
+			ui.message = note
+		case key, ok := <-keys:
+			if !ok || ui.handleKey(key) {
+				return 0
+			}
+		}
+	}
+}
+
+// tui holds what the dashboard shows and where the user is in it
+type tui struct {
+	client *bt.Client
+	ctx    context.Context
+
+	rows, columns int
+	// selected is the infohash of the highlighted torrent, kept across resorting
+	selected [20]byte
+	sortBy   int
+	reverse  bool
+	// detail shows the pane of tab under the list
+	detail bool
+	tab    int
+	// label shows only the torrents that have it, all of them when empty
+	label string
+	// queue holds the place of each torrent in the client's queue, from 1
+	queue map[*bt.Download]int
+
+	// prompt is the text typed so far after promptTitle, entered is given it on enter.
+	// confirm is the question waiting for y
+	prompting   bool
+	promptTitle string
+	prompt      string
+	entered     func(string)
+	confirm     func()
+	question    string
+	// message is shown in the status line until the next key
+	message string
+	// notes carries messages from torrents being added in the background
+	notes chan string
+}
+
+// handleKey acts on a key and reports whether to quit
+func (ui *tui) handleKey(key string) bool {
+	if key == keyCtrlC {
+		return true
+	}
+	ui.message = ""
+	if ui.prompting {
+		ui.promptKey(key)
+		return false
+	}
+	if ui.confirm != nil {
+		if key == "y" {
+			ui.confirm()
+		}
+		ui.confirm = nil
+		return false
+	}
+	list := ui.sorted()
+	current := ui.current(list)
+	switch key {
+	case "q":
+		return true
+	case keyUp, "k":
+		ui.move(list, -1)
+	case keyDown, "j":
+		ui.move(list, 1)
+	case "s", keyRight:
+		ui.sortBy = (ui.sortBy + 1) % numSortColumns
+	case keyLeft:
+		ui.sortBy = (ui.sortBy + numSortColumns - 1) % numSortColumns
+	case "r":
+		ui.reverse = !ui.reverse
+	case keyEnter:
+		ui.detail = !ui.detail
+	case keyTab:
+		ui.detail = true
+		ui.tab = (ui.tab + 1) % numTabs
+	case "a":
+		ui.ask("add torrent file or magnet link: ", "", func(arg string) {
+			if arg = strings.TrimSpace(arg); arg != "" {
+				ui.add(arg)
+			}
+		})
+	case "l":
+		ui.nextLabel()
+	case "L":
+		if current != nil {
+			ui.ask("labels, comma separated: ", strings.Join(current.Labels(), ", "), func(text string) {
+				current.SetLabels(strings.Split(text, ","))
+			})
+		}
+	case "t":
+		ui.client.SetAltSpeed(!ui.client.AltSpeed())
+		ui.message = "alternative speed limits off"
+		if ui.client.AltSpeed() {
+			ui.message = "alternative speed limits on"
+		}
+	case "u", "d", "U", "D":
+		if current != nil {
+			move := map[string]bt.QueueMove{"u": bt.QueueUp, "d": bt.QueueDown, "U": bt.QueueTop, "D": bt.QueueBottom}[key]
+			if err := ui.client.MoveInQueue(current.InfoHash, move); err != nil {
+				ui.message = err.Error()
+			}
+		}
+	case "P":
+		if current != nil {
+			// normal, high, low and round again
+			prio := (current.QueuePriority()+2)%3 - 1
+			ui.message = "queue priority " + prio.String()
+			if err := ui.client.SetQueuePriority(current.InfoHash, prio); err != nil {
+				ui.message = err.Error()
+			}
+		}
+	case "p":
+		if current != nil {
+			ui.togglePause(current)
+		}
+	case "x", "X":
+		if current != nil {
+			ui.askRemove(current, key == "X")
+		}
+	}
+	return false
+}
+
+// this function opens the prompt with title, text is typed already
+func (ui *tui) ask(title, text string, entered func(string)) {
+	ui.prompting = true
+	ui.promptTitle = title
+	ui.prompt = text
+	ui.entered = entered
+}
+
+// this function edits the prompt
+func (ui *tui) promptKey(key string) {
+	switch key {
+	case keyEscape:
+		ui.prompting = false
+	case keyEnter:
+		ui.prompting = false
+		ui.entered(ui.prompt)
+	case keyBackspace:
+		if r := []rune(ui.prompt); len(r) > 0 {
+			ui.prompt = string(r[:len(r)-1])
+		}
+	default:
+		if len([]rune(key)) == 1 {
+			ui.prompt += key
+		}
+	}
+}
+
+// add adds a torrent file or magnet link in the background, a magnet can take a while to
+// fetch its metadata and the dashboard keeps running meanwhile
+func (ui *tui) add(arg string) {
+	go func() {
+		d, err := addDownload(ui.ctx, ui.client, arg)
+		note := ""
+		if err != nil {
+			note = fmt.Sprintf("%s: %v", arg, err)
+		} else {
+			note = "added " + d.Torrent.Info.Name
+		}
+		select {
+		case ui.notes <- note:
+		case <-ui.ctx.Done():
+		}
+	}()
+	if strings.HasPrefix(arg, "magnet:") {
+		ui.message = "fetching metadata..."
+	}
+}
+
+func (ui *tui) togglePause(d *bt.Download) {
+	var err error
+	if d.State() == bt.DownloadPaused {
+		err = d.Resume()
+	} else {
+		err = d.Pause()
+	}
+	if err != nil {
+		ui.message = err.Error()
+	}
+}
+
+func (ui *tui) askRemove(d *bt.Download, withData bool) {
+	ui.question = fmt.Sprintf("remove %s? (y/n)", d.Torrent.Info.Name)
+	if withData {
+		ui.question = fmt.Sprintf("remove %s and delete its data? (y/n)", d.Torrent.Info.Name)
+	}
+	ui.confirm = func() {
+		err := ui.client.Remove(d.InfoHash, withData)
+		if err != nil {
+			ui.message = err.Error()
+		}
+	}
+}
+
+// nextLabel filters the list by the next label in use, after the last one it shows every
+// torrent again
+func (ui *tui) nextLabel() {
+	labels := ui.client.Labels()
+	i := slices.Index(labels, ui.label)
+	switch {
+	case len(labels) == 0:
+		ui.label = ""
+		ui.message = "no torrent has a label, press L to give one some"
+	case ui.label == "":
+		ui.label = labels[0]
+	case i < 0 || i == len(labels)-1:
+		ui.label = ""
+	default:
+		ui.label = labels[i+1]
+	}
+}
+
+// sorted returns the torrents the list shows, in its order
+func (ui *tui) sorted() []*bt.Download {
+	list := ui.client.Torrents()
+	ui.queue = make(map[*bt.Download]int, len(list))
+	for i, d := range list {
+		ui.queue[d] = i + 1
+	}
+	if ui.label != "" {
+		list = ui.client.TorrentsLabelled(ui.label)
+	}
+	stats := make(map[*bt.Download]bt.DownloadStats, len(list))
+	for _, d := range list {
+		stats[d] = d.Stats()
+	}
+	slices.SortStableFunc(list, func(a, b *bt.Download) int {
+		c := cmp.Compare(ui.queue[a], ui.queue[b])
+		if ui.sortBy != sortQueue {
+			c = compareTorrents(ui.sortBy, a, b, stats[a], stats[b])
+		}
+		if c == 0 {
+			c = strings.Compare(a.Torrent.Info.Name, b.Torrent.Info.Name)
+		}
+		if ui.reverse {
+			return -c
+		}
+		return c
+	})
+	return list
+}
+
+// compareTorrents orders two torrents by column. Rates, ratio and peers sort the largest
+// first, that's what one looks for
+func compareTorrents(column int, a, b *bt.Download, sa, sb bt.DownloadStats) int {
+	switch column {
+	case sortState:
+		return cmp.Compare(sa.State.String(), sb.State.String())
+	case sortProgress:
+		return cmp.Compare(progressPercent(a.Torrent.TotalLength(), sa.Left), progressPercent(b.Torrent.TotalLength(), sb.Left))
+	case sortDown:
+		return cmp.Compare(sb.DownloadRate, sa.DownloadRate)
+	case sortUp:
+		return cmp.Compare(sb.UploadRate, sa.UploadRate)
+	case sortRatio:
+		return cmp.Compare(b.Ratio(), a.Ratio())
+	case sortPeers:
+		return cmp.Compare(sb.Peers, sa.Peers)
+	default:
+		return strings.Compare(a.Torrent.Info.Name, b.Torrent.Info.Name)
+	}
+}
+
+// current returns the highlighted torrent, the first one when the highlighted torrent is gone
+func (ui *tui) current(list []*bt.Download) *bt.Download {
+	for _, d := range list {
+		if d.InfoHash == ui.selected {
+			return d
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	ui.selected = list[0].InfoHash
+	return list[0]
+}
+
+func (ui *tui) move(list []*bt.Download, by int) {
+	if len(list) == 0 {
+		return
+	}
+	i := slices.IndexFunc(list, func(d *bt.Download) bool { return d.InfoHash == ui.selected })
+	i = min(max(i+by, 0), len(list)-1)
+	ui.selected = list[i].InfoHash
+}
+
+// render draws the whole screen: a header with the session totals, the torrent list, the
+// detail pane and a status line
+func (ui *tui) render() string {
+	width := max(ui.columns, 40)
+	list := ui.sorted()
+	current := ui.current(list)
+
+	var lines []string
+	header := fmt.Sprintf("gonet-bt  %d torrents  ↓ %s  ↑ %s  port %d",
+		len(list), formatRate(ui.client.DownloadRate()), formatRate(ui.client.UploadRate()), ui.client.Port())
+	if ui.client.AltSpeed() {
+		header += "  turtle"
+	}
+	if ui.label != "" {
+		header += "  label " + ui.label
+	}
+	lines = append(lines, "\x1b[1m"+truncate(header, width)+"\x1b[0m")
+
+	nameWidth := max(width-tableFixedWidth, 10)
+	lines = append(lines, "\x1b[4m"+ui.tableHeader(nameWidth)+"\x1b[0m")
+	// the list gets the space the detail pane and the status line leave
+	listRows := max(ui.rows-3, 1)
+	if ui.detail {
+		listRows = max(listRows/2, 1)
+	}
+	first := 0
+	if i := slices.Index(list, current); i >= listRows {
+		first = i - listRows + 1
+	}
+	for _, d := range list[first:min(len(list), first+listRows)] {
+		row := tableRow(d, d.Stats(), ui.queue[d], nameWidth)
+		if d == current {
+			row = "\x1b[7m" + row + "\x1b[0m"
+		}
+		lines = append(lines, row)
+	}
+	if len(list) == 0 && ui.label != "" {
+		lines = append(lines, "no torrents labelled "+ui.label+", press l for the next label")
+	} else if len(list) == 0 {
+		lines = append(lines, "no torrents, press a to add one")
+	}
+	for len(lines) < listRows+2 {
+		lines = append(lines, "")
+	}
+	if ui.detail && current != nil {
+		lines = append(lines, ui.detailPane(current, width, max(ui.rows-len(lines)-1, 1))...)
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for _, line := range lines[:min(len(lines), max(ui.rows-1, 1))] {
+		b.WriteString(line)
+		// raw mode doesn't turn a newline into a carriage return as well
+		b.WriteString("\x1b[K\r\n")
+	}
+	b.WriteString("\x1b[J")
+	fmt.Fprintf(&b, "\x1b[%d;1H", max(ui.rows, 1))
+	b.WriteString(truncate(ui.statusLine(), width))
+	b.WriteString("\x1b[K")
+	return b.String()
+}
+
+// the width of every column of the list but the name, with the spaces between them
+const tableFixedWidth = 5 + 12 + 7 + 11 + 11 + 7 + 7
+
+func (ui *tui) tableHeader(nameWidth int) string {
+	titles := [numSortColumns]string{"NAME", "STATE", "DONE", "DOWN", "UP", "RATIO", "PEERS", "#"}
+	arrow := "▲"
+	if ui.reverse {
+		arrow = "▼"
+	}
+	titles[ui.sortBy] += arrow
+	return fmt.Sprintf("%4s %-*s %-11s %6s %10s %10s %6s %6s",
+		titles[sortQueue], nameWidth, titles[sortName], titles[sortState], titles[sortProgress], titles[sortDown], titles[sortUp], titles[sortRatio], titles[sortPeers])
+}
+
+// tableRow is a torrent's line in the list, its place in the queue is marked + for a high
+// queue priority and - for a low one
+func tableRow(d *bt.Download, stats bt.DownloadStats, place, nameWidth int) string {
+	name := truncate(d.Torrent.Info.Name, nameWidth)
+	mark := ""
+	switch d.QueuePriority() {
+	case bt.QueueHigh:
+		mark = "+"
+	case bt.QueueLow:
+		mark = "-"
+	}
+	return fmt.Sprintf("%4s %-*s %-11s %5.1f%% %10s %10s %6.2f %6d",
+		fmt.Sprint(place, mark), nameWidth, name, stats.State, progressPercent(d.Torrent.TotalLength(), stats.Left),
+		formatRate(stats.DownloadRate), formatRate(stats.UploadRate), d.Ratio(), stats.Peers)
+}
+
+// detailPane returns at most height lines about d: the tab bar and the selected tab
+func (ui *tui) detailPane(d *bt.Download, width, height int) []string {
+	var tabs []string
+	for i, name := range tabNames {
+		if i == ui.tab {
+			name = "\x1b[7m " + name + " \x1b[0m"
+		} else {
+			name = " " + name + " "
+		}
+		tabs = append(tabs, name)
+	}
+	title := d.Torrent.Info.Name
+	if labels := d.Labels(); len(labels) > 0 {
+		title += "  [" + strings.Join(labels, ", ") + "]"
+	}
+	lines := []string{strings.Join(tabs, " ") + "  " + truncate(title, width/2)}
+	var body []string
+	switch ui.tab {
+	case tabFiles:
+		body = filesTab(d, width)
+	case tabPeers:
+		body = peersTab(d, width)
+	case tabTrackers:
+		body = ui.trackersTab(d, width)
+	}
+	lines = append(lines, body...)
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+func filesTab(d *bt.Download, width int) []string {
+	var lines []string
+	for _, f := range d.FileProgress() {
+		line := fmt.Sprintf("%5.1f%% %10s %-7s %s", 100*f.Progress(), formatBytes(f.Length), f.Priority, f.Path)
+		lines = append(lines, truncate(line, width))
+	}
+	return lines
+}
+
+func peersTab(d *bt.Download, width int) []string {
+	var peers []bt.PeerStats
+	for _, p := range d.Peers() {
+		peers = append(peers, p.Stats())
+	}
+	if len(peers) == 0 {
+		return []string{"no peers connected"}
+	}
+	slices.SortFunc(peers, func(a, b bt.PeerStats) int {
+		return cmp.Compare(b.DownloadRate+b.UploadRate, a.DownloadRate+a.UploadRate)
+	})
+	var lines []string
+	for _, p := range peers {
+		flags := ""
+		if !p.PeerChoking {
+			flags += "D"
+		}
+		if !p.AmChoking {
+			flags += "U"
+		}
+		if p.Snubbed {
+			flags += "S"
+		}
+		line := fmt.Sprintf("%-22s %-20s %-4s %-8s %-3s ↓ %10s  ↑ %10s",
+			p.Addr, truncate(p.Client.String(), 20), p.Transport, p.Source, flags, formatRate(p.DownloadRate), formatRate(p.UploadRate))
+		lines = append(lines, truncate(line, width))
+	}
+	return lines
+}
+
+func (ui *tui) trackersTab(d *bt.Download, width int) []string {
+	stats := d.Stats()
+	var lines []string
+	for _, tracker := range d.Torrent.Magnet().Trackers {
+		line := "  " + tracker
+		if tracker == stats.Tracker {
+			line = fmt.Sprintf("* %s  %d seeds, %d leechers", tracker, stats.TrackerSeeds, stats.TrackerLeechers)
+		}
+		lines = append(lines, truncate(line, width))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "no trackers")
+	}
+	if d.Torrent.Info.Private != 1 && ui.client.DHT() != nil {
+		lines = append(lines, fmt.Sprintf("  dht  about %d seeds, %d leechers", stats.DHTSeeds, stats.DHTLeechers))
+	}
+	return lines
+}
+
+// statusLine is the bottom line: the add prompt, a question, a message or the keys
+func (ui *tui) statusLine() string {
+	switch {
+	case ui.prompting:
+		return ui.promptTitle + ui.prompt + "█"
+	case ui.confirm != nil:
+		return ui.question
+	case ui.message != "":
+		return ui.message
+	}
+	return "j/k move  s sort  r reverse  enter details  tab pane  p pause/resume  x remove  X remove+data  a add  l filter label  L set labels  u/d/U/D queue  P queue priority  t turtle  q quit"
+}
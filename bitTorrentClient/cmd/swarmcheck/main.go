@@ -0,0 +1,51 @@
+// Command swarmcheck runs a swarm of clients in one process, see swarmtest, and fails
+// unless every leecher downloads the seeder's data intact in time. CI runs it as the end to
+// end check of the client
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	bt "mybittorrent"
+	"mybittorrent/swarmtest"
+)
+
+func main() {
+	leechers := flag.Int("leechers", 3, "number of clients downloading from the seeder")
+	size := flag.Int("size", 4<<10, "size of the torrent in KiB")
+	pieceLength := flag.Int("piece", 64, "piece length in KiB")
+	files := flag.Int("files", 3, "number of files the data is split into")
+	timeout := flag.Duration("timeout", time.Minute, "how long the leechers get to finish")
+	verbose := flag.Bool("v", false, "log what the clients do to stderr")
+	flag.Parse()
+	if *leechers <= 0 || *size <= 0 || *pieceLength <= 0 || *files < 0 || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := swarmtest.Config{
+		Leechers:    *leechers,
+		Size:        *size << 10,
+		PieceLength: *pieceLength << 10,
+		Files:       *files,
+	}
+	if *verbose {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		cfg.Options = append(cfg.Options, bt.WithLogger(logger))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	start := time.Now()
+	err := swarmtest.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swarmcheck:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("ok: %d leechers got %d KiB in %d files from the seeder in %v\n",
+		*leechers, *size, max(*files, 1), time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,285 @@
+package bittorrentclient_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	bt "mybittorrent"
+	"mybittorrent/internal/bencode"
+	"mybittorrent/peertest"
+)
+
+const (
+	testPieceLength = 16 << 10
+	testPieces      = 4
+)
+
+// this function returns a torrent of random pieces and the pieces. Its tracker isn't there,
+// the fake peer is the only one
+func randomTorrent(t *testing.T) (*bt.Torrent, [][]byte) {
+	t.Helper()
+	pieces, hashes := peertest.RandomPieces(testPieces, testPieceLength, 0)
+	metainfo, err := bencode.Encode(map[string]interface{}{
+		"announce": "http://127.0.0.1:1/announce",
+		"info": map[string]interface{}{
+			"name":         "conformance",
+			"piece length": int64(testPieceLength),
+			"pieces":       hashes,
+			"length":       int64(testPieces * testPieceLength),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := bt.DecodeTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrent, pieces
+}
+
+// this function starts a download of torrent connected to fp, which is served on a loopback
+// listener the download dials like any other peer. The events the download emits from the
+// start come on the channel returned
+func downloadFrom(t *testing.T, torrent *bt.Torrent, fp *peertest.FakePeer) (*bt.Download, <-chan bt.Event) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		fp.Serve(conn)
+	}()
+
+	d, err := bt.NewDownload(torrent, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan bt.Event, 1024)
+	unsubscribe := d.Subscribe(func(ev bt.Event) {
+		select {
+		case events <- ev:
+		default:
+		}
+	})
+	t.Cleanup(unsubscribe)
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Stop() })
+	d.AddPeers(ln.Addr().String())
+	return d, events
+}
+
+// this function returns the next event of type typ, skipping the others
+func waitEvent(t *testing.T, events <-chan bt.Event, typ bt.EventType, timeout time.Duration) bt.Event {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("no %v event", typ)
+			return bt.Event{}
+		}
+	}
+}
+
+// this function connects a Peer to fp over net.Pipe
+func pipePeer(t *testing.T, torrent *bt.Torrent, fp *peertest.FakePeer) *bt.Peer {
+	t.Helper()
+	var peerID [20]byte
+	copy(peerID[:], "-GN0001-conformance0")
+	p, err := bt.NewPeer(fp.Pipe(), torrent.InfoHash, peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetNumPieces(torrent.NumPieces())
+	t.Cleanup(func() {
+		p.Close()
+		fp.Close()
+	})
+	return p
+}
+
+// this function reads messages from p until one of type id arrives
+func readUntil(t *testing.T, p *bt.Peer, id bt.MessageID) *bt.Message {
+	t.Helper()
+	for {
+		msg, err := p.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %v: %v", id, err)
+		}
+		if msg != nil && msg.ID == id {
+			return msg
+		}
+	}
+}
+
+func TestConformanceDownload(t *testing.T) {
+	torrent, pieces := randomTorrent(t)
+	fp := peertest.NewFakePeer(torrent.InfoHash, testPieceLength, pieces)
+	fp.Behavior.UnchokeOnInterest = true
+	d, _ := downloadFrom(t, torrent, fp)
+	select {
+	case <-d.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the download didn't complete")
+	}
+	if _, err := fp.WaitFor(bt.MsgInterested, time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConformanceStallSnubs(t *testing.T) {
+	torrent, pieces := randomTorrent(t)
+	fp := peertest.NewFakePeer(torrent.InfoHash, testPieceLength, pieces)
+	fp.Behavior.UnchokeOnInterest = true
+	fp.Behavior.Stall = true
+	p := pipePeer(t, torrent, fp)
+
+	go p.SendInterested()
+	readUntil(t, p, bt.MsgUnchoke)
+	err := p.SendRequest(0, 0, 1<<14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fp.WaitFor(bt.MsgRequest, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if p.CheckSnubbed(time.Minute) {
+		t.Fatal("snubbed as soon as the request went out")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !p.CheckSnubbed(10 * time.Millisecond) {
+		t.Fatal("a peer sitting on our request isn't snubbed")
+	}
+	if p.CanRequest(false) {
+		t.Error("a snubbed peer gets requests outside endgame")
+	}
+	if !p.CanRequest(true) {
+		t.Error("a snubbed peer gets no requests in endgame")
+	}
+
+	// the first block it delivers lifts the snub
+	fp.SetStall(false)
+	err = p.SendRequest(1, 0, 1<<14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, p, bt.MsgPiece).Release()
+	if p.Snubbed() {
+		t.Error("still snubbed after a block arrived")
+	}
+}
+
+func TestConformanceCorruptPieceBans(t *testing.T) {
+	torrent, pieces := randomTorrent(t)
+	fp := peertest.NewFakePeer(torrent.InfoHash, testPieceLength, pieces)
+	fp.Behavior.UnchokeOnInterest = true
+	corrupt := map[int]bool{0: true, 1: true, 2: true}
+	fp.Behavior.CorruptPieces = corrupt
+	d, events := downloadFrom(t, torrent, fp)
+
+	// every corrupt piece it sends is a strike, the third gets it banned. The ban is
+	// announced before the hash failure that caused it
+	failures, banned := 0, ""
+	timeout := time.After(10 * time.Second)
+	for failures < len(corrupt) || banned == "" {
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case bt.EventHashFailed:
+				failures++
+				if !corrupt[ev.Piece] {
+					t.Errorf("hash failed for piece %d, which was served intact", ev.Piece)
+				}
+			case bt.EventPeerBanned:
+				banned = ev.Peer
+				if failures != len(corrupt)-1 {
+					t.Errorf("banned after %d hash failures, want %d", failures+1, len(corrupt))
+				}
+			}
+		case <-timeout:
+			t.Fatalf("%d hash failures and banned %q, want %d and the fake peer", failures, banned, len(corrupt))
+		}
+	}
+	if host, _, _ := net.SplitHostPort(banned); host != "127.0.0.1" {
+		t.Errorf("banned %s, want the fake peer", banned)
+	}
+	have := d.Have()
+	for index := range corrupt {
+		if have.HasPiece(index) {
+			t.Errorf("corrupt piece %d was kept", index)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.Peers()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the banned peer is still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConformanceReject(t *testing.T) {
+	torrent, pieces := randomTorrent(t)
+	fp := peertest.NewFakePeer(torrent.InfoHash, testPieceLength, pieces)
+	fp.Behavior.Fast = true
+	p := pipePeer(t, torrent, fp)
+	if !p.SupportsFast() {
+		t.Fatal("the fast extension wasn't negotiated")
+	}
+
+	// a fast peer that is choking us rejects the request instead of dropping it
+	readUntil(t, p, bt.MsgBitfield)
+	err := p.SendRequest(2, 0, 1<<14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := readUntil(t, p, bt.MsgReject)
+	index, begin, length, err := bt.ParseRequest(&bt.Message{ID: bt.MsgRequest, Payload: msg.Payload})
+	if err != nil || index != 2 || begin != 0 || length != 1<<14 {
+		t.Errorf("rejected %d %d %d (%v), want the request for 2 0 16384", index, begin, length, err)
+	}
+	if n := p.PendingRequests(); n != 0 {
+		t.Errorf("%d requests pending after the reject", n)
+	}
+}
+
+func TestConformanceAllowedFast(t *testing.T) {
+	torrent, pieces := randomTorrent(t)
+	fp := peertest.NewFakePeer(torrent.InfoHash, testPieceLength, pieces)
+	fp.Behavior.Fast = true
+	fp.Behavior.AllowedFast = []int{0, 2}
+	d, events := downloadFrom(t, torrent, fp)
+
+	// the peer never unchokes us, the pieces it allowed come anyway and nothing else does
+	for range 2 {
+		ev := waitEvent(t, events, bt.EventPieceCompleted, 10*time.Second)
+		if ev.Piece != 0 && ev.Piece != 2 {
+			t.Fatalf("piece %d completed from a choking peer that only allowed 0 and 2", ev.Piece)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	have := d.Have()
+	for i := range testPieces {
+		if want := i == 0 || i == 2; have.HasPiece(i) != want {
+			t.Errorf("have piece %d: %v, want %v", i, have.HasPiece(i), want)
+		}
+	}
+	if _, err := fp.WaitFor(bt.MsgInterested, time.Second); err != nil {
+		t.Error(err)
+	}
+}
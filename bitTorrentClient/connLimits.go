@@ -0,0 +1,285 @@
+// This file enforces connection limits: the total number of peer connections, the number
+// per torrent, and how many peers may be unchoked at once. When a limit is hit the least
+// useful connection is evicted to make room, idle and snubbing peers go first, and seeds
+// go first of all when we are seeding ourselves. Ties are broken by canonical peer priority
+// so both ends of a connection agree on whether to keep it
+package bittorrentclient
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxConnections = 200
+	defaultMaxPerTorrent  = 50
+	defaultMaxUnchoked    = 20
+	// connections younger than this are never evicted, they haven't had a chance to prove themselves
+	evictionGracePeriod = 30 * time.Second
+)
+
+var ErrTooManyConnections = errors.New("connection limit reached")
+
+type ConnectionLimits struct {
+	MaxConnections int
+	MaxPerTorrent  int
+	MaxUnchoked    int
+}
+
+func DefaultConnectionLimits() ConnectionLimits {
+	return ConnectionLimits{
+		MaxConnections: defaultMaxConnections,
+		MaxPerTorrent:  defaultMaxPerTorrent,
+		MaxUnchoked:    defaultMaxUnchoked,
+	}
+}
+
+type torrentConns struct {
+	peers     map[*Peer]bool
+	numPieces int
+	seeding   bool
+}
+
+// ConnectionManager keeps track of every peer connection across all torrents
+type ConnectionManager struct {
+	mu       sync.Mutex
+	limits   ConnectionLimits
+	torrents map[[20]byte]*torrentConns
+	total    int
+	// external is our address as seen by other peers, used for peer priority
+	external netip.AddrPort
+}
+
+func NewConnectionManager(limits ConnectionLimits) *ConnectionManager {
+	return &ConnectionManager{
+		limits:   limits,
+		torrents: make(map[[20]byte]*torrentConns),
+	}
+}
+
+// SetLimits changes the limits, connections above the new limits are evicted right away
+func (m *ConnectionManager) SetLimits(limits ConnectionLimits) {
+	m.mu.Lock()
+	m.limits = limits
+	var evict []*Peer
+	for infoHash, t := range m.torrents {
+		for limits.MaxPerTorrent > 0 && len(t.peers) > limits.MaxPerTorrent {
+			p := m.leastUseful(&infoHash, nil, true)
+			if p == nil {
+				break
+			}
+			m.removeLocked(p)
+			evict = append(evict, p)
+		}
+	}
+	for limits.MaxConnections > 0 && m.total > limits.MaxConnections {
+		p := m.leastUseful(nil, nil, true)
+		if p == nil {
+			break
+		}
+		m.removeLocked(p)
+		evict = append(evict, p)
+	}
+	m.mu.Unlock()
+	for _, p := range evict {
+		p.Close()
+	}
+}
+
+// SetExternalAddr tells the manager our public address, e.g. as reported by a tracker.
+// Until it is known peer priority is computed from the local end of each connection
+func (m *ConnectionManager) SetExternalAddr(addr netip.AddrPort) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.external = addr
+}
+
+func (m *ConnectionManager) Limits() ConnectionLimits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.limits
+}
+
+// SetTorrentState tells the manager how many pieces a torrent has and whether we are
+// seeding it, which decides whether its seed connections are worth keeping
+func (m *ConnectionManager) SetTorrentState(infoHash [20]byte, numPieces int, seeding bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.torrent(infoHash)
+	t.numPieces = numPieces
+	t.seeding = seeding
+}
+
+func (m *ConnectionManager) torrent(infoHash [20]byte) *torrentConns {
+	t, ok := m.torrents[infoHash]
+	if !ok {
+		t = &torrentConns{peers: make(map[*Peer]bool)}
+		m.torrents[infoHash] = t
+	}
+	return t
+}
+
+// Admit registers a new connection. When a limit is reached the least useful existing
+// connection is closed to make room, if every other connection is more useful than a
+// brand new one ErrTooManyConnections is returned and the caller should drop p
+func (m *ConnectionManager) Admit(infoHash [20]byte, p *Peer) error {
+	m.mu.Lock()
+	t := m.torrent(infoHash)
+	var evict []*Peer
+	if m.limits.MaxPerTorrent > 0 && len(t.peers) >= m.limits.MaxPerTorrent {
+		victim := m.leastUseful(&infoHash, p, false)
+		if victim == nil {
+			m.mu.Unlock()
+			return ErrTooManyConnections
+		}
+		m.removeLocked(victim)
+		evict = append(evict, victim)
+	}
+	if m.limits.MaxConnections > 0 && m.total >= m.limits.MaxConnections {
+		victim := m.leastUseful(nil, p, false)
+		if victim == nil {
+			m.mu.Unlock()
+			for _, v := range evict {
+				v.Close()
+			}
+			return ErrTooManyConnections
+		}
+		m.removeLocked(victim)
+		evict = append(evict, victim)
+	}
+	t.peers[p] = true
+	m.total++
+	m.mu.Unlock()
+
+	for _, v := range evict {
+		v.Close()
+	}
+	return nil
+}
+
+// Remove forgets a connection, call it once the peer disconnected
+func (m *ConnectionManager) Remove(p *Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeLocked(p)
+}
+
+func (m *ConnectionManager) removeLocked(p *Peer) {
+	for _, t := range m.torrents {
+		if t.peers[p] {
+			delete(t.peers, p)
+			m.total--
+			return
+		}
+	}
+}
+
+// RemoveTorrent forgets a torrent, its connections should already have been closed
+func (m *ConnectionManager) RemoveTorrent(infoHash [20]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.torrents[infoHash]; ok {
+		m.total -= len(t.peers)
+		delete(m.torrents, infoHash)
+	}
+}
+
+func (m *ConnectionManager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total
+}
+
+func (m *ConnectionManager) TorrentCount(infoHash [20]byte) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.torrents[infoHash]; ok {
+		return len(t.peers)
+	}
+	return 0
+}
+
+// UnchokeSlots returns how many peers a torrent may unchoke, the global unchoke limit is
+// shared evenly between the torrents that currently have connections
+func (m *ConnectionManager) UnchokeSlots(infoHash [20]byte) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.limits.MaxUnchoked <= 0 {
+		return 0
+	}
+	active := 0
+	for _, t := range m.torrents {
+		if len(t.peers) > 0 {
+			active++
+		}
+	}
+	if active == 0 {
+		active = 1
+	}
+	share := m.limits.MaxUnchoked / active
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
+
+// this function picks the connection to evict, within one torrent when infoHash is set
+// or across all of them otherwise. connections in their grace period are skipped unless
+// force is set. nil means nobody scores lower than a fresh connection would
+func (m *ConnectionManager) leastUseful(infoHash *[20]byte, newcomer *Peer, force bool) *Peer {
+	var victim *Peer
+	var victimScore float64
+	var victimPriority uint32
+	now := time.Now()
+	for h, t := range m.torrents {
+		if infoHash != nil && h != *infoHash {
+			continue
+		}
+		for p := range t.peers {
+			if !force && now.Sub(p.connectedAt) < evictionGracePeriod {
+				continue
+			}
+			score := peerUsefulness(p, t.numPieces, t.seeding)
+			priority := p.priority(m.external)
+			if victim == nil || score < victimScore || score == victimScore && priority < victimPriority {
+				victim = p
+				victimScore = score
+				victimPriority = priority
+			}
+		}
+	}
+	if victim != nil && newcomer != nil && !force && victimScore >= 0 {
+		// the worst peer is still moving data, keep it over an unknown newcomer
+		return nil
+	}
+	return victim
+}
+
+// this function scores how much a connection is worth keeping, negative scores mark
+// connections that are useless right now
+func peerUsefulness(p *Peer, numPieces int, seeding bool) float64 {
+	stats := p.Stats()
+	if seeding && numPieces > 0 && p.Bitfield().Count() >= numPieces {
+		// two seeds have nothing to say to each other
+		return -3
+	}
+	if stats.Snubbed {
+		return -2
+	}
+	rate := stats.DownloadRate + stats.UploadRate
+	if rate == 0 && !stats.PeerInterested && !stats.AmInterested {
+		return -1
+	}
+	return rate
+}
+
+// SetConnectionManager counts the download's peers against m's limits from the next peer
+// that connects, and caps its unchoked peers at its share of m's unchoke limit. nil leaves
+// the download to MaxPeers alone
+func (d *Download) SetConnectionManager(m *ConnectionManager) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conns = m
+}
@@ -0,0 +1,313 @@
+// This file creates .torrent files. CreateTorrent hashes a file, or every file under a
+// directory in path order, into a v1 torrent, picking a piece length that keeps the piece
+// list a reasonable size unless one is given. Encode writes a torrent back out as a
+// .torrent file, the info dictionary exactly as it was so the infohash doesn't change
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// automatically picked piece lengths aim for about this many pieces, within the bounds
+	targetPieceCount  = 1500
+	minPieceLength    = 16 * 1024
+	maxPieceLength    = 16 * 1024 * 1024
+	defaultCreatedBy  = "goNet"
+	createReadBufSize = 256 * 1024
+)
+
+// CreateOptions configures CreateTorrent
+type CreateOptions struct {
+	// Name is the torrent's name, the base name of the path when empty
+	Name string
+	// PieceLength is the size of a piece, a power of two of at least 16 KiB. Zero picks one
+	// from the total size
+	PieceLength int64
+	// Trackers are the announce urls, the first goes in announce and each gets a tier of
+	// its own in announce-list when there are several. None makes a trackerless torrent
+	Trackers []string
+	// HTTPSeeds are BEP 17 seeds serving pieces over HTTP
+	HTTPSeeds []string
+	// Nodes are DHT nodes, host:port, a trackerless torrent's peers join the DHT through
+	Nodes   []string
+	Comment string
+	// CreatedBy names the program that made the torrent, "goNet" when empty
+	CreatedBy string
+	// Private keeps the torrent to its trackers (BEP 27)
+	Private bool
+	// NoDate leaves the creation date out, so the same files always give the same file
+	NoDate bool
+	// Progress is called with the number of bytes hashed so far and the total
+	Progress func(hashed, total int64)
+}
+
+// CreateTorrent hashes the file or directory at path into a torrent. Hidden files and
+// anything but regular files are left out of directories
+func CreateTorrent(ctx context.Context, path string, opts CreateOptions) (*Torrent, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	name := opts.Name
+	if name == "" {
+		name = filepath.Base(filepath.Clean(path))
+	}
+	var files []TorrentFile
+	var paths []string
+	if info.IsDir() {
+		files, paths, err = collectFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s holds no files", path)
+		}
+	} else {
+		files = []TorrentFile{{Length: info.Size()}}
+		paths = []string{path}
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Length
+	}
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(total)
+	}
+	if pieceLength < minPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length %d is not a power of two of at least 16 KiB", pieceLength)
+	}
+	pieces, err := hashFiles(ctx, paths, total, pieceLength, opts.Progress)
+	if err != nil {
+		return nil, err
+	}
+
+	infoDict := map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	if info.IsDir() {
+		list := make([]interface{}, len(files))
+		for i, f := range files {
+			list[i] = map[string]interface{}{"length": f.Length, "path": f.Path}
+		}
+		infoDict["files"] = list
+	} else {
+		infoDict["length"] = total
+	}
+	if opts.Private {
+		infoDict["private"] = 1
+	}
+	rawInfo, err := encodeBencode(infoDict)
+	if err != nil {
+		return nil, err
+	}
+	t := &Torrent{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		HTTPSeeds: opts.HTTPSeeds,
+		Nodes:     opts.Nodes,
+		infoBytes: rawInfo,
+	}
+	if t.CreatedBy == "" {
+		t.CreatedBy = defaultCreatedBy
+	}
+	if !opts.NoDate {
+		t.CreationDate = time.Now().Unix()
+	}
+	if len(opts.Trackers) > 0 {
+		t.Announce = opts.Trackers[0]
+	}
+	if len(opts.Trackers) > 1 {
+		for _, tracker := range opts.Trackers {
+			t.AnnounceList = append(t.AnnounceList, []string{tracker})
+		}
+	}
+	// decoding what we encode gives exactly the torrent anyone loading the file gets
+	data, err := t.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTorrent(bytes.NewReader(data))
+}
+
+// this function lists the regular files under dir in path order, with their paths
+// relative to dir as the torrent has them
+func collectFiles(dir string) ([]TorrentFile, []string, error) {
+	var files []TorrentFile
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && entry.Name()[0] == '.' {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, TorrentFile{Length: info.Size(), Path: strings.Split(filepath.ToSlash(rel), "/")})
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// WalkDir goes in lexical order already, sorting by elements keeps a/b before a.b
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := files[order[i]].Path, files[order[j]].Path
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	sortedFiles := make([]TorrentFile, len(files))
+	sortedPaths := make([]string, len(paths))
+	for i, k := range order {
+		sortedFiles[i], sortedPaths[i] = files[k], paths[k]
+	}
+	return sortedFiles, sortedPaths, nil
+}
+
+// this function picks the smallest power of two piece length that keeps total under
+// targetPieceCount pieces, within minPieceLength and maxPieceLength
+func choosePieceLength(total int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && total/length > targetPieceCount {
+		length *= 2
+	}
+	return length
+}
+
+// this function hashes the files one after another as one stream cut into pieces
+func hashFiles(ctx context.Context, paths []string, total, pieceLength int64, progress func(hashed, total int64)) ([]byte, error) {
+	var pieces []byte
+	piece := sha1.New()
+	var inPiece, hashed int64
+	buf := make([]byte, createReadBufSize)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				f.Close()
+				return nil, err
+			}
+			n, err := f.Read(buf[:min(int64(len(buf)), pieceLength-inPiece)])
+			piece.Write(buf[:n])
+			inPiece += int64(n)
+			hashed += int64(n)
+			if inPiece == pieceLength {
+				pieces = piece.Sum(pieces)
+				piece.Reset()
+				inPiece = 0
+			}
+			if n > 0 && progress != nil {
+				progress(hashed, total)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		f.Close()
+	}
+	if hashed != total {
+		return nil, errors.New("files changed while they were hashed")
+	}
+	if inPiece > 0 {
+		pieces = piece.Sum(pieces)
+	}
+	return pieces, nil
+}
+
+// Encode returns the torrent as a .torrent file. The info dictionary is written exactly as
+// it was read or created, so the file has the same infohash
+func (t *Torrent) Encode() ([]byte, error) {
+	if t.infoBytes == nil {
+		return nil, errors.New("torrent has no info dictionary")
+	}
+	dict := map[string]interface{}{
+		"announce": t.Announce,
+		"info":     rawBencode(t.infoBytes),
+	}
+	if len(t.AnnounceList) > 0 {
+		tiers := make([]interface{}, len(t.AnnounceList))
+		for i, tier := range t.AnnounceList {
+			tiers[i] = tier
+		}
+		dict["announce-list"] = tiers
+	}
+	if t.CreationDate != 0 {
+		dict["creation date"] = t.CreationDate
+	}
+	if t.Comment != "" {
+		dict["comment"] = t.Comment
+	}
+	if t.CreatedBy != "" {
+		dict["created by"] = t.CreatedBy
+	}
+	if len(t.HTTPSeeds) > 0 {
+		dict["httpseeds"] = t.HTTPSeeds
+	}
+	if len(t.Nodes) > 0 {
+		var nodes []interface{}
+		for _, node := range t.Nodes {
+			host, port, err := net.SplitHostPort(node)
+			if err != nil {
+				return nil, fmt.Errorf("node %q: %w", node, err)
+			}
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("node %q: %w", node, err)
+			}
+			nodes = append(nodes, []interface{}{host, p})
+		}
+		dict["nodes"] = nodes
+	}
+	if len(t.PieceLayers) > 0 {
+		layers := make(map[string]interface{}, len(t.PieceLayers))
+		for root, hashes := range t.PieceLayers {
+			layers[root] = hashes
+		}
+		dict["piece layers"] = layers
+	}
+	return encodeBencode(dict)
+}
@@ -0,0 +1,182 @@
+// This file gets the DHT node into the network. A node knows nobody when it starts, so it
+// asks well known nodes for the nodes closest to its own id: first the ones configured in
+// Config.BootstrapNodes, then DefaultBootstrapNodes when none of those answered. Their
+// names are resolved with a few retries, DNS tends to fail right after a machine wakes up.
+// The table is checked every dhtHealthInterval and the node bootstraps again whenever it
+// knows fewer than dhtK nodes, e.g. after the network was down long enough for every node
+// to time out. Trackerless torrents can list nodes of their own, which are added as well.
+// Bootstrap nodes are asked on IPv4 and IPv6 alike, and for the nodes of both families, so
+// an IPv6 table gets started by nodes only reachable over IPv4 too
+
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultBootstrapNodes are the public routers a node joins through when the configured
+// nodes don't answer
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+	"dht.libtorrent.org:25401",
+}
+
+const (
+	// dhtHealthInterval is how often the node checks that it knows enough nodes
+	dhtHealthInterval = time.Minute
+	// a bootstrap node's name is resolved this many times before it is given up on, the
+	// wait between tries doubles from dhtResolveRetryDelay
+	dhtResolveAttempts   = 3
+	dhtResolveRetryDelay = time.Second
+)
+
+// Bootstrap joins the DHT through the nodes at addrs, host:port pairs, and fills the routing
+// tables with a lookup of our own id. It fails when none of them answered
+func (dht *Node) Bootstrap(ctx context.Context, addrs ...string) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	answered := 0
+	// others are the nodes of another family than the one a bootstrap node answered on
+	var others []dhtNode
+	for _, addr := range addrs {
+		for _, stack := range dht.stacks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, err := dht.ping(ctx, addr, stack)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					name := addr
+					if stack.ipv6 {
+						name += " over IPv6"
+					}
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+					return
+				}
+				answered++
+				for _, other := range dht.stacks {
+					if compact, ok := r[other.nodesKey()].(string); ok && other != stack {
+						others = append(others, parseCompactNodes([]byte(compact), other.ipLen())...)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if answered == 0 {
+		if len(errs) == 0 {
+			return errors.New("no bootstrap nodes")
+		}
+		return fmt.Errorf("no bootstrap node answered: %w", errors.Join(errs...))
+	}
+	// the nodes of the other family go in its table once they answer
+	if len(others) > dhtK {
+		others = others[:dhtK]
+	}
+	for _, n := range others {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = dht.query(ctx, n.addr, "find_node", map[string]interface{}{"target": string(dht.id[:])})
+		}()
+	}
+	wg.Wait()
+	_, _ = dht.lookupAll(ctx, dht.id, "find_node", nil, nil)
+	return nil
+}
+
+// this function resolves addr for the family of stack and asks the node there for the nodes
+// closest to us, of every family we run, which adds it to the table when it answers
+func (dht *Node) ping(ctx context.Context, addr string, stack *dhtStack) (map[string]interface{}, error) {
+	network := "udp4"
+	if stack.ipv6 {
+		network = "udp6"
+	}
+	udpAddr, err := resolveDHTNode(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	args := map[string]interface{}{"target": string(dht.id[:])}
+	if len(dht.stacks) > 1 {
+		var want []interface{}
+		for _, s := range dht.stacks {
+			want = append(want, s.want())
+		}
+		args["want"] = want
+	}
+	return dht.query(ctx, udpAddr, "find_node", args)
+}
+
+// this function resolves a bootstrap node's address on network, udp4 or udp6, retrying
+// lookups that failed for a reason that may go away
+func resolveDHTNode(ctx context.Context, network, addr string) (*net.UDPAddr, error) {
+	delay := dhtResolveRetryDelay
+	for attempt := 1; ; attempt++ {
+		udpAddr, err := net.ResolveUDPAddr(network, addr)
+		if err == nil {
+			return udpAddr, nil
+		}
+		var dnsErr *net.DNSError
+		if attempt == dhtResolveAttempts || !errors.As(err, &dnsErr) || dnsErr.IsNotFound {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// this function bootstraps the node in the background when it knows too few nodes and
+// isn't bootstrapping already
+func (dht *Node) checkHealth() {
+	if dht.Nodes() >= dhtK {
+		return
+	}
+	dht.mu.Lock()
+	defer dht.mu.Unlock()
+	if dht.closed || dht.bootstrapping {
+		return
+	}
+	dht.bootstrapping = true
+	dht.wg.Add(1)
+	go func() {
+		defer dht.wg.Done()
+		err := dht.bootstrapConfigured(dht.ctx)
+		if err != nil && dht.ctx.Err() == nil {
+			dht.logger.Warn("bootstrap failed", "err", err)
+		} else if err == nil {
+			dht.logger.Info("bootstrapped", "nodes", dht.Nodes())
+		}
+		dht.mu.Lock()
+		dht.bootstrapping = false
+		dht.mu.Unlock()
+	}()
+}
+
+// this function bootstraps through the configured nodes, and the default ones when none of
+// those answered
+func (dht *Node) bootstrapConfigured(ctx context.Context) error {
+	var err error
+	if len(dht.bootstrapNodes) > 0 {
+		err = dht.Bootstrap(ctx, dht.bootstrapNodes...)
+		if err == nil {
+			return nil
+		}
+	}
+	if dht.noDefaultBootstrap {
+		return err
+	}
+	return errors.Join(err, dht.Bootstrap(ctx, DefaultBootstrapNodes...))
+}
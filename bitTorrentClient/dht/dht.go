@@ -0,0 +1,719 @@
+// Package dht implements a node of the mainline DHT (BEP 5), the distributed tracker that
+// finds peers without a tracker. Every node has a random 160 bit id and knows more nodes
+// the closer their ids are to its own by XOR distance, see table.go, so a lookup for an
+// infohash gets closer to it with every round of queries until it reaches the nodes that
+// store its peers, see lookup.go. The node answers the queries of other nodes as well,
+// stores the peers announced to it and hands out the tokens announcing needs. It takes part
+// in the IPv6 DHT too where it can, see ipv6.go, and stores arbitrary items, see items.go.
+//
+// The package stands on its own: the BitTorrent client shares one node between its
+// downloads, but a node is just as usable for anything else built on Kademlia. It listens
+// on a socket of its own or on one handed to it, so tests can run private DHTs of nodes on
+// loopback or on an in-memory network
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+const (
+	// dhtQueryTimeout is how long a node gets to answer a query
+	dhtQueryTimeout = 3 * time.Second
+	// tokens are made from a secret that changes this often, tokens of the previous secret
+	// are accepted too
+	dhtSecretInterval = 5 * time.Minute
+	// announced peers are forgotten after this long unless they announce again
+	dhtPeerTTL = 30 * time.Minute
+	// dhtMaxInfoHashes and dhtMaxPeersPerHash bound what other nodes can make us store
+	dhtMaxInfoHashes   = 5000
+	dhtMaxPeersPerHash = 200
+	// dhtMaxValues is the most peers a get_peers answer holds, so it fits a packet
+	dhtMaxValues = 50
+	// the table is refreshed with a lookup of our own id this often
+	dhtRefreshInterval = 15 * time.Minute
+)
+
+// KRPC error codes
+const (
+	dhtErrorGeneric  = 201
+	dhtErrorProtocol = 203
+	dhtErrorMethod   = 204
+)
+
+var errDHTClosed = errors.New("dht is closed")
+
+// Error is an error a node answered a query with
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+// Config configures a DHT node
+type Config struct {
+	// Addr is the UDP address to listen on, ":6881" when empty
+	Addr string
+	// Addr6 is the UDP address to listen on for IPv6. When empty the node listens on the
+	// port of Addr on every IPv6 address, if Addr doesn't name an IPv4 address of its own
+	Addr6 string
+	// Conn and Conn6 are sockets to run on instead of listening on Addr and Addr6, e.g. a
+	// socket shared with other protocols or one of an in-memory network. The addresses they
+	// read from and write to are *net.UDPAddr, and reads fail with net.ErrClosed once they
+	// are closed. The node closes them when it is closed
+	Conn  net.PacketConn
+	Conn6 net.PacketConn
+	// DisableIPv6 keeps the node off the IPv6 DHT
+	DisableIPv6 bool
+	// ID is the node's id, a random one is used when it is zero
+	ID [20]byte
+	// BootstrapNodes are host:port pairs of nodes to join the DHT through. They are tried
+	// before DefaultBootstrapNodes
+	BootstrapNodes []string
+	// NoDefaultBootstrap leaves DefaultBootstrapNodes out, for private networks
+	NoDefaultBootstrap bool
+	// ReadOnly makes the node query the DHT without taking part in it (BEP 43): incoming
+	// queries go unanswered and other nodes are told to keep us out of their tables. For
+	// short lived processes and nodes behind a NAT or firewall that drops incoming packets
+	ReadOnly bool
+	// Logger is where the node logs joining the DHT and the queries it answers, nil logs
+	// nothing
+	Logger *slog.Logger
+}
+
+// Node is a node of the mainline DHT
+type Node struct {
+	// stacks holds the IPv4 stack and the IPv6 one when there is one, see ipv6.go
+	stacks []*dhtStack
+	id     [20]byte
+
+	mu sync.Mutex
+	// calls holds the queries waiting for an answer by transaction id
+	calls  map[string]*dhtCall
+	nextTx uint16
+	// peers holds the peers announced to us by infohash, by their compact form
+	peers map[[20]byte]map[string]dhtStoredPeer
+	// items holds the BEP 44 items put to us by target, see items.go
+	items      map[[20]byte]*dhtStoredItem
+	secret     [16]byte
+	prevSecret [16]byte
+	closed     bool
+	// ctx is cancelled when the node is closed, for the work it does on its own
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// bootstrapNodes are tried before the defaults, see bootstrap.go
+	bootstrapNodes     []string
+	noDefaultBootstrap bool
+	bootstrapping      bool
+	readOnly           bool
+	logger             *slog.Logger
+}
+
+// dhtStoredPeer is a peer announced to us
+type dhtStoredPeer struct {
+	expires time.Time
+	// seed is set when the peer announced itself as a seed (BEP 33)
+	seed bool
+}
+
+// dhtCall is a query waiting for its answer
+type dhtCall struct {
+	addr  string
+	reply chan dhtMessage
+}
+
+// dhtMessage is a decoded KRPC message, a query, a response or an error
+type dhtMessage struct {
+	T string
+	Y string
+	Q string
+	A map[string]interface{}
+	R map[string]interface{}
+	E []interface{}
+	// RO is set on queries of read-only nodes
+	RO bool
+}
+
+// New starts a DHT node listening on cfg.Addr, or running on cfg.Conn. It joins the DHT on
+// its own through the bootstrap nodes, see bootstrap.go
+func New(cfg Config) (*Node, error) {
+	conn, addr6 := cfg.Conn, cfg.Addr6
+	if conn == nil {
+		addr := cfg.Addr
+		if addr == "" {
+			addr = ":6881"
+		}
+		var err error
+		conn, err = net.ListenPacket("udp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		if host, _, err := net.SplitHostPort(addr); addr6 == "" && err == nil {
+			if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+				addr6 = net.JoinHostPort("::", fmt.Sprint(conn.LocalAddr().(*net.UDPAddr).Port))
+			}
+		}
+	}
+	stacks := []*dhtStack{{conn: conn}}
+	conn6 := cfg.Conn6
+	if conn6 == nil && !cfg.DisableIPv6 && addr6 != "" {
+		// without IPv6 on the machine the node keeps to IPv4
+		conn6, _ = net.ListenPacket("udp6", addr6)
+	}
+	if conn6 != nil {
+		stacks = append(stacks, &dhtStack{conn: conn6, ipv6: true})
+	}
+	var err error
+	closeAll := func() {
+		for _, s := range stacks {
+			s.conn.Close()
+		}
+	}
+	dht := &Node{
+		stacks: stacks,
+		id:     cfg.ID,
+		calls:  make(map[string]*dhtCall),
+		peers:  make(map[[20]byte]map[string]dhtStoredPeer),
+		items:  make(map[[20]byte]*dhtStoredItem),
+
+		bootstrapNodes:     cfg.BootstrapNodes,
+		noDefaultBootstrap: cfg.NoDefaultBootstrap,
+		readOnly:           cfg.ReadOnly,
+		logger:             cfg.Logger,
+	}
+	if dht.logger == nil {
+		dht.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	dht.ctx, dht.cancel = context.WithCancel(context.Background())
+	if dht.id == [20]byte{} {
+		_, err = rand.Read(dht.id[:])
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	_, err = rand.Read(dht.secret[:])
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	dht.prevSecret = dht.secret
+	for _, s := range stacks {
+		s.table = newDHTTable(dht.id, s.ipv6)
+	}
+	for _, s := range stacks {
+		dht.wg.Add(1)
+		go dht.serve(s)
+	}
+	dht.wg.Add(1)
+	go dht.maintain()
+	return dht, nil
+}
+
+// ID returns the node's id
+func (dht *Node) ID() [20]byte {
+	return dht.id
+}
+
+// Addr returns the address the node listens on for IPv4
+func (dht *Node) Addr() net.Addr {
+	return dht.stacks[0].conn.LocalAddr()
+}
+
+// Nodes returns the number of nodes in the routing tables
+func (dht *Node) Nodes() int {
+	n := 0
+	for _, s := range dht.stacks {
+		n += s.table.len()
+	}
+	return n
+}
+
+// Close stops the node, lookups still running fail
+func (dht *Node) Close() error {
+	dht.mu.Lock()
+	if dht.closed {
+		dht.mu.Unlock()
+		return nil
+	}
+	dht.closed = true
+	dht.cancel()
+	dht.mu.Unlock()
+	var errs []error
+	for _, s := range dht.stacks {
+		errs = append(errs, s.conn.Close())
+	}
+	dht.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// GetPeers looks up the peers of infoHash and returns their addresses
+func (dht *Node) GetPeers(ctx context.Context, infoHash [20]byte) ([]string, error) {
+	peers, _, err := dht.getPeers(ctx, infoHash, false)
+	return peers, err
+}
+
+// AnnounceOptions are the optional parts of an announce
+type AnnounceOptions struct {
+	// Seed tells the nodes we have the whole torrent (BEP 33)
+	Seed bool
+	// ImpliedPort has the nodes store the port our packets come from rather than the port
+	// announced, for peers behind a NAT that doesn't keep ports
+	ImpliedPort bool
+}
+
+// Announce looks up the peers of infoHash and tells the nodes closest to it that we have it
+// too, on port, on IPv4 and IPv6. It returns the peers found on the way and the size of the
+// swarm estimated from the scrape the lookup does, see scrape.go. A zero port announces
+// nothing, for peers nobody can connect to
+func (dht *Node) Announce(ctx context.Context, infoHash [20]byte, port int, opts AnnounceOptions) ([]string, ScrapeResult, error) {
+	peers, closest, err := dht.getPeers(ctx, infoHash, true)
+	if err != nil {
+		return nil, ScrapeResult{}, err
+	}
+	if port == 0 {
+		return peers, mergeScrapes(closest), nil
+	}
+	args := map[string]interface{}{
+		"info_hash": string(infoHash[:]),
+		"port":      port,
+	}
+	if opts.Seed {
+		args["seed"] = 1
+	}
+	if opts.ImpliedPort {
+		args["implied_port"] = 1
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	announced := 0
+	for _, n := range closest {
+		if n.token == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeArgs := map[string]interface{}{"token": n.token}
+			for k, v := range args {
+				nodeArgs[k] = v
+			}
+			_, err := dht.query(ctx, n.addr, "announce_peer", nodeArgs)
+			if err == nil {
+				mu.Lock()
+				announced++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	scrape := mergeScrapes(closest)
+	if announced == 0 && len(closest) > 0 {
+		return peers, scrape, errors.New("no node accepted the announce")
+	}
+	return peers, scrape, nil
+}
+
+// this function runs a get_peers lookup and returns the peers found and the closest nodes
+// that answered, with their tokens and, when scrape is set, their bloom filters
+func (dht *Node) getPeers(ctx context.Context, infoHash [20]byte, scrape bool) ([]string, []*dhtLookupNode, error) {
+	seen := make(map[string]bool)
+	var peers []string
+	var args map[string]interface{}
+	if scrape {
+		args = map[string]interface{}{"scrape": 1}
+	}
+	closest, err := dht.lookupAll(ctx, infoHash, "get_peers", args, func(n *dhtLookupNode, r map[string]interface{}) {
+		n.seeds, n.peers = parseDHTBloom(r["BFsd"]), parseDHTBloom(r["BFpe"])
+		values, _ := r["values"].([]interface{})
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok || len(s) != net.IPv4len+2 && len(s) != net.IPv6len+2 {
+				continue
+			}
+			for _, addr := range parseCompactPeers([]byte(s), len(s)-2) {
+				if !seen[addr] {
+					seen[addr] = true
+					peers = append(peers, addr)
+				}
+			}
+		}
+	})
+	return peers, closest, err
+}
+
+// this function decodes compact peer info with addresses of ipLen bytes, skipping peers
+// without a port
+func parseCompactPeers(data []byte, ipLen int) []string {
+	entry := ipLen + 2
+	var peers []string
+	for i := 0; i+entry <= len(data); i += entry {
+		ip, _ := netip.AddrFromSlice(data[i : i+ipLen])
+		port := binary.BigEndian.Uint16(data[i+ipLen : i+entry])
+		if port == 0 {
+			continue
+		}
+		peers = append(peers, netip.AddrPortFrom(ip, port).String())
+	}
+	return peers
+}
+
+// this function sends a query to addr and waits for the answer. the node is added to the
+// routing table when it answers, and counted as failing when it doesn't
+func (dht *Node) query(ctx context.Context, addr *net.UDPAddr, method string, args map[string]interface{}) (map[string]interface{}, error) {
+	stack := dht.stackFor(addr.IP)
+	if stack == nil {
+		return nil, fmt.Errorf("%s: no dht socket for the address family", addr)
+	}
+	args["id"] = string(dht.id[:])
+	call := &dhtCall{addr: addr.String(), reply: make(chan dhtMessage, 1)}
+	dht.mu.Lock()
+	if dht.closed {
+		dht.mu.Unlock()
+		return nil, errDHTClosed
+	}
+	var tx string
+	for {
+		dht.nextTx++
+		tx = string(binary.BigEndian.AppendUint16(nil, dht.nextTx))
+		if dht.calls[tx] == nil {
+			break
+		}
+	}
+	dht.calls[tx] = call
+	dht.mu.Unlock()
+	defer func() {
+		dht.mu.Lock()
+		delete(dht.calls, tx)
+		dht.mu.Unlock()
+	}()
+
+	query := map[string]interface{}{"t": tx, "y": "q", "q": method, "a": args}
+	if dht.readOnly {
+		query["ro"] = 1
+	}
+	data, err := bencode.Encode(query)
+	if err != nil {
+		return nil, err
+	}
+	_, err = stack.conn.WriteTo(data, addr)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(dhtQueryTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-call.reply:
+		if msg.Y == "e" {
+			return nil, parseDHTError(msg.E)
+		}
+		id, ok := msg.R["id"].(string)
+		if !ok || len(id) != 20 {
+			return nil, errors.New("dht response without a node id")
+		}
+		stack.table.add([20]byte([]byte(id)), addr)
+		return msg.R, nil
+	case <-timer.C:
+		stack.table.failed(addr)
+		return nil, fmt.Errorf("%s: dht query timed out", addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-dht.ctx.Done():
+		return nil, errDHTClosed
+	}
+}
+
+func parseDHTError(e []interface{}) error {
+	err := &Error{Code: dhtErrorGeneric}
+	if len(e) > 0 {
+		if code, ok := e[0].(int64); ok {
+			err.Code = int(code)
+		}
+	}
+	if len(e) > 1 {
+		err.Message, _ = e[1].(string)
+	}
+	return err
+}
+
+// this function reads the packets of stack until the node is closed
+func (dht *Node) serve(stack *dhtStack) {
+	defer dht.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := stack.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// e.g. an ICMP error for an earlier packet, the socket is still fine
+			continue
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		msg, err := parseDHTMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch msg.Y {
+		case "q":
+			// a read-only node stays silent, so nobody takes it for a node to ask
+			if !dht.readOnly {
+				dht.handleQuery(msg, udpAddr, stack)
+			}
+		case "r", "e":
+			dht.handleReply(msg, udpAddr)
+		}
+	}
+}
+
+func parseDHTMessage(data []byte) (dhtMessage, error) {
+	v, err := bencode.Decode(data)
+	if err != nil {
+		return dhtMessage{}, err
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return dhtMessage{}, errors.New("dht message is not a dictionary")
+	}
+	var msg dhtMessage
+	msg.T, _ = dict["t"].(string)
+	msg.Y, _ = dict["y"].(string)
+	msg.Q, _ = dict["q"].(string)
+	msg.A, _ = dict["a"].(map[string]interface{})
+	msg.R, _ = dict["r"].(map[string]interface{})
+	msg.E, _ = dict["e"].([]interface{})
+	ro, _ := dict["ro"].(int64)
+	msg.RO = ro != 0
+	switch {
+	case msg.Y == "q" && msg.A == nil, msg.Y == "r" && msg.R == nil:
+		return dhtMessage{}, errors.New("dht message without arguments")
+	}
+	return msg, nil
+}
+
+// this function hands an answer to the query waiting for it, when it came from the node
+// the query went to
+func (dht *Node) handleReply(msg dhtMessage, addr *net.UDPAddr) {
+	dht.mu.Lock()
+	call := dht.calls[msg.T]
+	dht.mu.Unlock()
+	if call == nil || call.addr != addr.String() {
+		return
+	}
+	select {
+	case call.reply <- msg:
+	default:
+	}
+}
+
+// this function answers a query from another node, which came in on stack
+func (dht *Node) handleQuery(msg dhtMessage, addr *net.UDPAddr, stack *dhtStack) {
+	id, ok := msg.A["id"].(string)
+	if !ok || len(id) != 20 {
+		dht.sendError(msg.T, addr, dhtErrorProtocol, "invalid id")
+		return
+	}
+	dht.logger.Debug("query", "method", msg.Q, "from", addr)
+	r := map[string]interface{}{"id": string(dht.id[:])}
+	switch msg.Q {
+	case "ping":
+	case "find_node":
+		target, ok := msg.A["target"].(string)
+		if !ok || len(target) != 20 {
+			dht.sendError(msg.T, addr, dhtErrorProtocol, "invalid target")
+			return
+		}
+		closestNodesAnswer(r, [20]byte([]byte(target)), dht.wanted(msg.A, stack))
+	case "get_peers":
+		infoHash, ok := msg.A["info_hash"].(string)
+		if !ok || len(infoHash) != 20 {
+			dht.sendError(msg.T, addr, dhtErrorProtocol, "invalid info_hash")
+			return
+		}
+		r["token"] = dht.token(addr.IP, false)
+		if scrape, _ := msg.A["scrape"].(int64); scrape != 0 {
+			seeds, peers := dht.scrapeFilters([20]byte([]byte(infoHash)))
+			r["BFsd"], r["BFpe"] = string(seeds[:]), string(peers[:])
+		}
+		// peers announced over the family the query came in on, which the asking node can reach
+		values := dht.storedPeers([20]byte([]byte(infoHash)), stack.ipLen())
+		if len(values) > 0 {
+			r["values"] = values
+		} else {
+			closestNodesAnswer(r, [20]byte([]byte(infoHash)), dht.wanted(msg.A, stack))
+		}
+	case "announce_peer":
+		infoHash, ok := msg.A["info_hash"].(string)
+		if !ok || len(infoHash) != 20 {
+			dht.sendError(msg.T, addr, dhtErrorProtocol, "invalid info_hash")
+			return
+		}
+		token, _ := msg.A["token"].(string)
+		if !dht.validToken(token, addr.IP) {
+			dht.sendError(msg.T, addr, dhtErrorProtocol, "bad token")
+			return
+		}
+		port, _ := msg.A["port"].(int64)
+		if implied, _ := msg.A["implied_port"].(int64); implied != 0 {
+			port = int64(addr.Port)
+		}
+		if port <= 0 || port > 65535 {
+			dht.sendError(msg.T, addr, dhtErrorProtocol, "invalid port")
+			return
+		}
+		ip := addr.IP.To4()
+		if stack.ipv6 {
+			ip = addr.IP.To16()
+		}
+		compact := binary.BigEndian.AppendUint16(append([]byte(nil), ip...), uint16(port))
+		seed, _ := msg.A["seed"].(int64)
+		dht.storePeer([20]byte([]byte(infoHash)), string(compact), seed != 0)
+	case "get", "put":
+		if err := dht.handleItemQuery(msg, addr, stack, r); err != nil {
+			dht.sendError(msg.T, addr, err.Code, err.Message)
+			return
+		}
+	default:
+		dht.sendError(msg.T, addr, dhtErrorMethod, "method unknown")
+		return
+	}
+	// a node that queries us is alive, which is as good as an answer, unless it is
+	// read-only and wouldn't answer queries of its own
+	if !msg.RO {
+		stack.table.add([20]byte([]byte(id)), addr)
+	}
+	dht.send(addr, map[string]interface{}{"t": msg.T, "y": "r", "r": r})
+}
+
+func (dht *Node) sendError(tx string, addr *net.UDPAddr, code int, message string) {
+	dht.send(addr, map[string]interface{}{"t": tx, "y": "e", "e": []interface{}{code, message}})
+}
+
+func (dht *Node) send(addr *net.UDPAddr, msg map[string]interface{}) {
+	stack := dht.stackFor(addr.IP)
+	if stack == nil {
+		return
+	}
+	data, err := bencode.Encode(msg)
+	if err != nil {
+		return
+	}
+	_, _ = stack.conn.WriteTo(data, addr)
+}
+
+// this function returns the token for ip, made with the previous secret when prev is set
+func (dht *Node) token(ip net.IP, prev bool) string {
+	dht.mu.Lock()
+	secret := dht.secret
+	if prev {
+		secret = dht.prevSecret
+	}
+	dht.mu.Unlock()
+	h := sha1.New()
+	h.Write(secret[:])
+	h.Write(ip.To16())
+	return string(h.Sum(nil)[:8])
+}
+
+func (dht *Node) validToken(token string, ip net.IP) bool {
+	return token != "" && (token == dht.token(ip, false) || token == dht.token(ip, true))
+}
+
+// this function remembers a peer announced for infoHash, within the limits
+func (dht *Node) storePeer(infoHash [20]byte, compact string, seed bool) {
+	dht.mu.Lock()
+	defer dht.mu.Unlock()
+	peers := dht.peers[infoHash]
+	if peers == nil {
+		if len(dht.peers) >= dhtMaxInfoHashes {
+			return
+		}
+		peers = make(map[string]dhtStoredPeer)
+		dht.peers[infoHash] = peers
+	}
+	if _, ok := peers[compact]; !ok && len(peers) >= dhtMaxPeersPerHash {
+		return
+	}
+	peers[compact] = dhtStoredPeer{expires: time.Now().Add(dhtPeerTTL), seed: seed}
+}
+
+// this function returns the peers announced for infoHash with addresses of ipLen bytes as
+// compact values
+func (dht *Node) storedPeers(infoHash [20]byte, ipLen int) []interface{} {
+	dht.mu.Lock()
+	defer dht.mu.Unlock()
+	var values []interface{}
+	for compact := range dht.peers[infoHash] {
+		if len(values) == dhtMaxValues {
+			break
+		}
+		if len(compact) != ipLen+2 {
+			continue
+		}
+		values = append(values, compact)
+	}
+	return values
+}
+
+// this function rotates the token secret, forgets expired peers and items and keeps the
+// routing table healthy until the node is closed
+func (dht *Node) maintain() {
+	defer dht.wg.Done()
+	secretTicker := time.NewTicker(dhtSecretInterval)
+	defer secretTicker.Stop()
+	refreshTicker := time.NewTicker(dhtRefreshInterval)
+	defer refreshTicker.Stop()
+	healthTicker := time.NewTicker(dhtHealthInterval)
+	defer healthTicker.Stop()
+	dht.checkHealth()
+	for {
+		select {
+		case <-dht.ctx.Done():
+			return
+		case now := <-secretTicker.C:
+			var secret [16]byte
+			_, _ = rand.Read(secret[:])
+			dht.mu.Lock()
+			dht.prevSecret, dht.secret = dht.secret, secret
+			for infoHash, peers := range dht.peers {
+				for addr, sp := range peers {
+					if now.After(sp.expires) {
+						delete(peers, addr)
+					}
+				}
+				if len(peers) == 0 {
+					delete(dht.peers, infoHash)
+				}
+			}
+			for target, item := range dht.items {
+				if now.After(item.expires) {
+					delete(dht.items, target)
+				}
+			}
+			dht.mu.Unlock()
+		case <-refreshTicker.C:
+			_, _ = dht.lookupAll(dht.ctx, dht.id, "find_node", nil, nil)
+		case <-healthTicker.C:
+			dht.checkHealth()
+		}
+	}
+}
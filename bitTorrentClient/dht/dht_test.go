@@ -0,0 +1,393 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+// this function starts n nodes on loopback, every one after the first joined through it
+func testNodes(t *testing.T, n int, cfg Config) []*Node {
+	t.Helper()
+	var nodes []*Node
+	for i := 0; i < n; i++ {
+		c := Config{Addr: "127.0.0.1:0", DisableIPv6: true, NoDefaultBootstrap: true}
+		if i > 0 {
+			c.ReadOnly = cfg.ReadOnly
+		}
+		node, err := New(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.Close() })
+		if i > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = node.Bootstrap(ctx, nodes[0].Addr().String())
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// this function sends msg to the node at addr from conn and returns the answer
+func krpc(t *testing.T, conn net.PacketConn, addr net.Addr, msg map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := bencode.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteTo(data, addr); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil
+	}
+	v, err := bencode.Decode(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.(map[string]interface{})
+}
+
+func TestParseDHTMessage(t *testing.T) {
+	msg, err := parseDHTMessage([]byte("d1:ad2:id20:abcdefghij01234567896:target20:mnopqrstuvwxyz123456e1:q9:find_node2:roi1e1:t2:aa1:y1:qe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.T != "aa" || msg.Y != "q" || msg.Q != "find_node" || !msg.RO || msg.A["target"] != "mnopqrstuvwxyz123456" {
+		t.Errorf("query parsed as %+v", msg)
+	}
+	msg, err = parseDHTMessage([]byte("d1:eli201e23:A Generic Error Ocurrede1:t2:aa1:y1:ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parseDHTError(msg.E); !reflect.DeepEqual(err, &Error{Code: 201, Message: "A Generic Error Ocurred"}) {
+		t.Errorf("error parsed as %v", err)
+	}
+	if err := parseDHTError(nil); err.(*Error).Code != dhtErrorGeneric {
+		t.Errorf("empty error parsed as %v", err)
+	}
+
+	for _, data := range []string{
+		"",
+		"li1ee",
+		"d1:t2:aa1:y1:q1:q4:pinge",
+		"d1:t2:aa1:y1:re",
+		"d1:ad2:id20:abcdefghij0123456789e1:q4:ping",
+	} {
+		if msg, err := parseDHTMessage([]byte(data)); err == nil {
+			t.Errorf("%q parsed as %+v", data, msg)
+		}
+	}
+}
+
+func TestCompactInfo(t *testing.T) {
+	nodes := []dhtNode{
+		{id: [20]byte{1}, addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}},
+		{id: [20]byte{2}, addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6882}},
+		{id: [20]byte{3}, addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 6883}},
+	}
+	compact := compactNodes(nodes, net.IPv4len)
+	if len(compact) != 2*26 {
+		t.Fatalf("%d bytes for two IPv4 nodes", len(compact))
+	}
+	// a zero port is skipped and so is a truncated entry
+	compact = append(compact, make([]byte, 26)...)
+	compact = append(compact, 1, 2, 3)
+	got := parseCompactNodes(compact, net.IPv4len)
+	if len(got) != 2 || got[0].id != nodes[0].id || got[0].addr.String() != "10.0.0.1:6881" || got[1].addr.String() != "10.0.0.3:6883" {
+		t.Errorf("IPv4 nodes parsed as %v", got)
+	}
+	got = parseCompactNodes(compactNodes(nodes, net.IPv6len), net.IPv6len)
+	if len(got) != 1 || got[0].id != nodes[1].id || got[0].addr.String() != "[2001:db8::1]:6882" {
+		t.Errorf("IPv6 nodes parsed as %v", got)
+	}
+
+	peers := parseCompactPeers([]byte("\x7f\x00\x00\x01\x1a\xe1"+"\x0a\x00\x00\x02\x00\x00"+"\x0a\x00"), net.IPv4len)
+	if !reflect.DeepEqual(peers, []string{"127.0.0.1:6881"}) {
+		t.Errorf("peers parsed as %v", peers)
+	}
+	peers = parseCompactPeers(net.ParseIP("::1").To16(), net.IPv6len)
+	if peers != nil {
+		t.Errorf("a peer without a port parsed as %v", peers)
+	}
+}
+
+func TestTable(t *testing.T) {
+	var self [20]byte
+	table := newDHTTable(self, false)
+	addr := func(port int) *net.UDPAddr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port} }
+	// every id with the first bit set lands in bucket 0
+	for i := 0; i < dhtK+2; i++ {
+		table.add([20]byte{0x80, byte(i)}, addr(1000+i))
+	}
+	table.add(self, addr(1))
+	table.add([20]byte{1}, &net.UDPAddr{IP: net.ParseIP("::1"), Port: 1})
+	table.add([20]byte{2}, addr(0))
+	if table.len() != dhtK {
+		t.Fatalf("table has %d nodes, want a full bucket of %d", table.len(), dhtK)
+	}
+	// a full bucket takes a new node only in place of a failing one
+	table.failed(addr(1000))
+	table.add([20]byte{0x80, 0xff}, addr(2000))
+	closest := table.closest([20]byte{0x80, 0xff}, 1)
+	if table.len() != dhtK || len(closest) != 1 || closest[0].addr.Port != 2000 {
+		t.Errorf("closest after replacing a failing node is %v", closest)
+	}
+	for i := 0; i < dhtMaxFailures; i++ {
+		table.failed(addr(1001))
+	}
+	if table.len() != dhtK-1 {
+		t.Errorf("a node that failed %d times is kept", dhtMaxFailures)
+	}
+
+	if b := table.bucket([20]byte{0, 0x10}); b != 11 {
+		t.Errorf("bucket %d for 11 shared bits", b)
+	}
+	if !closerTo([20]byte{0xf0}, [20]byte{0xe0}, [20]byte{0x70}) || closerTo([20]byte{}, [20]byte{2}, [20]byte{1}) {
+		t.Error("closerTo doesn't order by XOR distance")
+	}
+}
+
+// the test vectors of BEP 33
+func TestBloom(t *testing.T) {
+	var b dhtBloom
+	if b.estimate() != 0 {
+		t.Errorf("empty filter estimated at %d", b.estimate())
+	}
+	for i := 0; i < 256; i++ {
+		b.add(net.IPv4(192, 0, 2, byte(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		ip := net.ParseIP("2001:db8::")
+		ip[14], ip[15] = byte(i>>8), byte(i)
+		b.add(ip)
+	}
+	if got := b.estimate(); got != 1225 {
+		t.Errorf("estimated %d, want 1225", got)
+	}
+	if parseDHTBloom(string(b[:])) == nil || parseDHTBloom("short") != nil || parseDHTBloom(int64(1)) != nil {
+		t.Error("parseDHTBloom doesn't check the filter")
+	}
+	var full dhtBloom
+	for i := range full {
+		full[i] = 0xff
+	}
+	if full.estimate() <= 0 {
+		t.Errorf("full filter estimated at %d", full.estimate())
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// the test vectors of BEP 44
+func TestItemVectors(t *testing.T) {
+	target, err := ImmutableTarget("Hello World!")
+	if err != nil || hex.EncodeToString(target[:]) != "e5f96f6f38320f0f33959cb4d3d656452117aadb" {
+		t.Errorf("immutable target %x, %v", target, err)
+	}
+
+	key := ed25519.PublicKey(mustHex(t, "77ff84905a91936367c01360803104f92432fcd904a43511876df5cdf3e7e548"))
+	for _, tc := range []struct {
+		salt, target, sig string
+	}{
+		{"", "4a533d47ec9c7d95b1ad75f576cffc641853b750",
+			"305ac8aeb6c9c151fa120f120ea2cfb923564e11552d06a5d856091e5e853cff1260d3f39e4999684aa92eb73ffd136e6f4f3ecbfda0ce53a1608ecd7ae21f01"},
+		{"foobar", "411eba73b6f087ca51a3795d9c8c938d365e32c1",
+			"6834284b6b24c3204eb2fea824d82f88883a3d95e8b4a21b8c0ded553d17d17ddf9a8a7104b1258f30bed3787e6cb896fca78c58f8e03b5f18f14951a87d9a08"},
+	} {
+		target := MutableTarget(key, []byte(tc.salt))
+		if hex.EncodeToString(target[:]) != tc.target {
+			t.Errorf("salt %q: target %x, want %s", tc.salt, target, tc.target)
+		}
+		if !ed25519.Verify(key, dhtSignedPart([]byte(tc.salt), 1, []byte("12:Hello World!")), mustHex(t, tc.sig)) {
+			t.Errorf("salt %q: the signature doesn't verify", tc.salt)
+		}
+	}
+
+	_, priv, _ := ed25519.GenerateKey(nil)
+	item, err := SignItem(priv, []byte("salt"), 3, "value")
+	if err != nil || !ed25519.Verify(item.Key, dhtSignedPart(item.Salt, 3, []byte("5:value")), item.Sig) {
+		t.Errorf("SignItem gave %+v, %v", item, err)
+	}
+	if _, err := SignItem(priv, make([]byte, dhtMaxSaltSize+1), 1, "v"); err == nil {
+		t.Error("an item with a long salt was signed")
+	}
+	if _, err := SignItem(priv, nil, 1, string(make([]byte, dhtMaxItemSize))); err == nil {
+		t.Error("an item too big was signed")
+	}
+}
+
+func TestQueries(t *testing.T) {
+	node := testNodes(t, 1, Config{})[0]
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	id := "abcdefghij0123456789"
+	query := func(method string, args map[string]interface{}) map[string]interface{} {
+		args["id"] = id
+		return krpc(t, conn, node.Addr(), map[string]interface{}{"t": "tx", "y": "q", "q": method, "a": args})
+	}
+	errorCode := func(resp map[string]interface{}) int64 {
+		e, _ := resp["e"].([]interface{})
+		if len(e) == 0 {
+			return 0
+		}
+		code, _ := e[0].(int64)
+		return code
+	}
+
+	resp := query("ping", map[string]interface{}{})
+	if r, _ := resp["r"].(map[string]interface{}); resp["t"] != "tx" || resp["y"] != "r" || r["id"] != string(node.id[:]) {
+		t.Errorf("ping answered with %v", resp)
+	}
+	if node.Nodes() != 1 {
+		t.Errorf("a node that queried us isn't in the table")
+	}
+
+	infoHash := "mnopqrstuvwxyz123456"
+	r, _ := query("get_peers", map[string]interface{}{"info_hash": infoHash})["r"].(map[string]interface{})
+	token, _ := r["token"].(string)
+	if token == "" {
+		t.Fatalf("get_peers answered without a token: %v", r)
+	}
+	for _, tc := range []struct {
+		method string
+		args   map[string]interface{}
+		code   int64
+	}{
+		{"announce_peer", map[string]interface{}{"info_hash": infoHash, "port": 6881, "token": "forged"}, dhtErrorProtocol},
+		{"announce_peer", map[string]interface{}{"info_hash": infoHash, "port": 0, "token": token}, dhtErrorProtocol},
+		{"announce_peer", map[string]interface{}{"info_hash": "short", "port": 6881, "token": token}, dhtErrorProtocol},
+		{"find_node", map[string]interface{}{"target": "short"}, dhtErrorProtocol},
+		{"vote", map[string]interface{}{}, dhtErrorMethod},
+		{"announce_peer", map[string]interface{}{"info_hash": infoHash, "port": 6881, "token": token}, 0},
+		{"announce_peer", map[string]interface{}{"info_hash": infoHash, "port": 1, "implied_port": 1, "token": token, "seed": 1}, 0},
+	} {
+		if code := errorCode(query(tc.method, tc.args)); code != tc.code {
+			t.Errorf("%s %v answered with error %d, want %d", tc.method, tc.args, code, tc.code)
+		}
+	}
+	if code := errorCode(krpc(t, conn, node.Addr(), map[string]interface{}{"t": "tx", "y": "q", "q": "ping", "a": map[string]interface{}{"id": "short"}})); code != dhtErrorProtocol {
+		t.Errorf("a query with a short id answered with error %d", code)
+	}
+
+	r, _ = query("get_peers", map[string]interface{}{"info_hash": infoHash, "scrape": 1})["r"].(map[string]interface{})
+	values, _ := r["values"].([]interface{})
+	var peers []string
+	for _, v := range values {
+		peers = append(peers, parseCompactPeers([]byte(v.(string)), net.IPv4len)...)
+	}
+	sort.Strings(peers)
+	// the implied port is the one the query came from
+	want := []string{fmt.Sprint("127.0.0.1:", conn.LocalAddr().(*net.UDPAddr).Port), "127.0.0.1:6881"}
+	sort.Strings(want)
+	if !reflect.DeepEqual(peers, want) {
+		t.Errorf("stored peers %v, want %v", peers, want)
+	}
+	seeds, leechers := parseDHTBloom(r["BFsd"]), parseDHTBloom(r["BFpe"])
+	if seeds == nil || leechers == nil || seeds.estimate() != 1 || leechers.estimate() != 1 {
+		t.Errorf("scrape answered with %v", r)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	node, err := New(Config{Addr: "127.0.0.1:0", DisableIPv6: true, NoDefaultBootstrap: true, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	query := map[string]interface{}{"t": "tx", "y": "q", "q": "ping", "a": map[string]interface{}{"id": "abcdefghij0123456789"}}
+	if resp := krpc(t, conn, node.Addr(), query); resp != nil {
+		t.Errorf("a read-only node answered with %v", resp)
+	}
+
+	// read-only nodes query others but stay out of their tables
+	nodes := testNodes(t, 2, Config{ReadOnly: true})
+	if nodes[0].Nodes() != 0 {
+		t.Errorf("a read-only node was added to the table")
+	}
+	if nodes[1].Nodes() != 1 {
+		t.Errorf("the bootstrap node isn't in the read-only node's table")
+	}
+}
+
+func TestLookups(t *testing.T) {
+	nodes := testNodes(t, 6, Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	var infoHash [20]byte
+	copy(infoHash[:], "lookup test infohash")
+	if _, _, err := nodes[1].Announce(ctx, infoHash, 51413, AnnounceOptions{Seed: true}); err != nil {
+		t.Fatal(err)
+	}
+	peers, err := nodes[5].GetPeers(ctx, infoHash)
+	if err != nil || !reflect.DeepEqual(peers, []string{"127.0.0.1:51413"}) {
+		t.Errorf("found peers %v, %v", peers, err)
+	}
+	scrape, err := nodes[4].Scrape(ctx, infoHash)
+	if err != nil || scrape != (ScrapeResult{Seeds: 1}) {
+		t.Errorf("scraped %+v, %v", scrape, err)
+	}
+
+	target, err := nodes[2].PutImmutable(ctx, map[string]interface{}{"k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := nodes[3].GetImmutable(ctx, target)
+	if err != nil || !reflect.DeepEqual(v, map[string]interface{}{"k": "v"}) {
+		t.Errorf("got immutable %v, %v", v, err)
+	}
+	if _, err := nodes[3].GetImmutable(ctx, [20]byte{1}); err != ErrItemNotFound {
+		t.Errorf("got a missing item: %v", err)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(nil)
+	for seq := int64(1); seq <= 2; seq++ {
+		item, err := SignItem(priv, []byte("salt"), seq, fmt.Sprint("version ", seq))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := nodes[2].PutMutable(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, _ := SignItem(priv, []byte("salt"), 1, "version 1")
+	if err := nodes[2].PutMutable(ctx, old); err == nil {
+		t.Error("an older version was stored over a newer one")
+	}
+	item, err := nodes[4].GetMutable(ctx, priv.Public().(ed25519.PublicKey), []byte("salt"))
+	if err != nil || item.Seq != 2 || item.V != "version 2" {
+		t.Errorf("got mutable %+v, %v", item, err)
+	}
+}
@@ -0,0 +1,94 @@
+// This file runs the DHT over IPv6 alongside IPv4 (BEP 32). The two are separate networks
+// that share our node id: each has a socket and a routing table of its own, nodes in the
+// IPv6 one are sent as 38 byte compact infos under "nodes6" and peers as 18 byte values.
+// A query can ask for the nodes of either family or both with "want", by default it gets
+// the nodes of the family it came in on. Lookups and announces run on both at once, so
+// peers reachable over IPv6 only are found and find us. The IPv6 socket is optional, a
+// machine without IPv6 runs the DHT over IPv4 alone
+
+package dht
+
+import (
+	"net"
+)
+
+// dhtStack is the socket and routing table of one address family
+type dhtStack struct {
+	conn  net.PacketConn
+	table *dhtTable
+	ipv6  bool
+}
+
+// this function returns the length of the stack's addresses in compact form
+func (s *dhtStack) ipLen() int {
+	if s.ipv6 {
+		return net.IPv6len
+	}
+	return net.IPv4len
+}
+
+// this function returns the key compact nodes of the stack's family are sent under
+func (s *dhtStack) nodesKey() string {
+	if s.ipv6 {
+		return "nodes6"
+	}
+	return "nodes"
+}
+
+// this function returns how a query asks for nodes of the stack's family in "want"
+func (s *dhtStack) want() string {
+	if s.ipv6 {
+		return "n6"
+	}
+	return "n4"
+}
+
+func isIPv6(ip net.IP) bool {
+	return ip.To4() == nil && ip.To16() != nil
+}
+
+// Addr6 returns the address the node listens on for IPv6, nil when it runs on IPv4 alone
+func (dht *Node) Addr6() net.Addr {
+	for _, s := range dht.stacks {
+		if s.ipv6 {
+			return s.conn.LocalAddr()
+		}
+	}
+	return nil
+}
+
+// this function returns the stack of ip's family, nil when the node doesn't run one
+func (dht *Node) stackFor(ip net.IP) *dhtStack {
+	for _, s := range dht.stacks {
+		if isIPv6(ip) == s.ipv6 {
+			return s
+		}
+	}
+	return nil
+}
+
+// this function returns the stacks whose nodes a query from a node of stack from asked for
+// with "want", the asking node's own family when it didn't say
+func (dht *Node) wanted(a map[string]interface{}, from *dhtStack) []*dhtStack {
+	want, _ := a["want"].([]interface{})
+	var stacks []*dhtStack
+	for _, s := range dht.stacks {
+		for _, w := range want {
+			if w, _ := w.(string); w == s.want() {
+				stacks = append(stacks, s)
+				break
+			}
+		}
+	}
+	if len(want) == 0 {
+		stacks = append(stacks, from)
+	}
+	return stacks
+}
+
+// this function adds the nodes closest to target of every stack in stacks to the answer r
+func closestNodesAnswer(r map[string]interface{}, target [20]byte, stacks []*dhtStack) {
+	for _, s := range stacks {
+		r[s.nodesKey()] = string(compactNodes(s.table.closest(target, dhtK), s.ipLen()))
+	}
+}
@@ -0,0 +1,351 @@
+// This file stores arbitrary data on the DHT (BEP 44). An item is a bencoded value of up to
+// 1000 bytes kept by the nodes closest to its target. Immutable items are addressed by the
+// SHA-1 of the value, so they can't change once published. Mutable items are addressed by
+// an ed25519 public key and an optional salt, and carry a sequence number and a signature
+// over the value with it: whoever holds the private key can publish new versions, and nodes
+// keep the one with the highest sequence number. Items are forgotten after two hours, the
+// publisher puts them again to keep them around. get and put follow get_peers and
+// announce_peer: a lookup gathers the closest nodes with their tokens and the put goes to
+// them
+
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+const (
+	// dhtMaxItemSize is the largest bencoded value an item can hold
+	dhtMaxItemSize = 1000
+	// dhtMaxSaltSize is the longest salt of a mutable item
+	dhtMaxSaltSize = 64
+	// stored items are forgotten after this long unless they are put again
+	dhtItemTTL = 2 * time.Hour
+	// dhtMaxItems bounds the items other nodes can make us store
+	dhtMaxItems = 2000
+)
+
+// KRPC error codes of BEP 44
+const (
+	dhtErrorTooBig           = 205
+	dhtErrorInvalidSignature = 206
+	dhtErrorSaltTooBig       = 207
+	dhtErrorCASMismatch      = 301
+	dhtErrorSeqTooLow        = 302
+)
+
+// ErrItemNotFound is returned by the gets when no node had the item
+var ErrItemNotFound = errors.New("dht item not found")
+
+// MutableItem is a signed value stored under a public key and salt
+type MutableItem struct {
+	Key  ed25519.PublicKey
+	Salt []byte
+	// Seq orders the versions of the item, nodes keep the highest they were given
+	Seq int64
+	// V is the value: a string, an int64, or a []interface{} or map[string]interface{}
+	// of those, as the bencode decoder returns them
+	V   interface{}
+	Sig []byte
+}
+
+// dhtStoredItem is an item stored with us, mutable when k is set
+type dhtStoredItem struct {
+	v       bencode.Raw
+	k       []byte
+	salt    []byte
+	seq     int64
+	sig     []byte
+	expires time.Time
+}
+
+// SignItem returns version seq of the mutable item under key's public key and salt,
+// holding v
+func SignItem(key ed25519.PrivateKey, salt []byte, seq int64, v interface{}) (MutableItem, error) {
+	encoded, err := bencode.Encode(v)
+	if err != nil {
+		return MutableItem{}, err
+	}
+	if len(encoded) > dhtMaxItemSize {
+		return MutableItem{}, fmt.Errorf("dht item of %d bytes, at most %d fit", len(encoded), dhtMaxItemSize)
+	}
+	if len(salt) > dhtMaxSaltSize {
+		return MutableItem{}, fmt.Errorf("dht item salt of %d bytes, at most %d fit", len(salt), dhtMaxSaltSize)
+	}
+	return MutableItem{
+		Key:  key.Public().(ed25519.PublicKey),
+		Salt: salt,
+		Seq:  seq,
+		V:    v,
+		Sig:  ed25519.Sign(key, dhtSignedPart(salt, seq, encoded)),
+	}, nil
+}
+
+// Target returns the target the item is stored under
+func (item MutableItem) Target() [20]byte {
+	return MutableTarget(item.Key, item.Salt)
+}
+
+// MutableTarget returns the target of the mutable items of key and salt
+func MutableTarget(key ed25519.PublicKey, salt []byte) [20]byte {
+	return sha1.Sum(append(append([]byte(nil), key...), salt...))
+}
+
+// ImmutableTarget returns the target of the immutable item holding v
+func ImmutableTarget(v interface{}) ([20]byte, error) {
+	encoded, err := bencode.Encode(v)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	return sha1.Sum(encoded), nil
+}
+
+// this function returns what a mutable item's signature covers: the salt when there is
+// one, the sequence number and the bencoded value, as they would appear in a dictionary
+func dhtSignedPart(salt []byte, seq int64, encoded []byte) []byte {
+	var b []byte
+	if len(salt) > 0 {
+		b = append(b, "4:salt"...)
+		b, _ = bencode.Append(b, salt)
+	}
+	b = append(b, "3:seqi"...)
+	b = strconv.AppendInt(b, seq, 10)
+	b = append(b, "e1:v"...)
+	return append(b, encoded...)
+}
+
+// PutImmutable stores v on the nodes closest to its hash and returns the target it can be
+// got with
+func (dht *Node) PutImmutable(ctx context.Context, v interface{}) ([20]byte, error) {
+	encoded, err := bencode.Encode(v)
+	if err != nil {
+		return [20]byte{}, err
+	}
+	if len(encoded) > dhtMaxItemSize {
+		return [20]byte{}, fmt.Errorf("dht item of %d bytes, at most %d fit", len(encoded), dhtMaxItemSize)
+	}
+	target := sha1.Sum(encoded)
+	return target, dht.put(ctx, target, map[string]interface{}{"v": bencode.Raw(encoded)})
+}
+
+// GetImmutable returns the value of the immutable item stored under target
+func (dht *Node) GetImmutable(ctx context.Context, target [20]byte) (interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var found interface{}
+	_, err := dht.lookupAll(ctx, target, "get", nil, func(n *dhtLookupNode, r map[string]interface{}) {
+		v, ok := r["v"]
+		if !ok || found != nil {
+			return
+		}
+		encoded, err := bencode.Encode(v)
+		if err == nil && sha1.Sum(encoded) == target {
+			found = v
+			// the value is the same wherever it is stored, the lookup can stop
+			cancel()
+		}
+	})
+	if found != nil {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrItemNotFound
+}
+
+// PutMutable stores the signed item on the nodes closest to its target. Nodes holding a
+// version with a higher sequence number refuse it
+func (dht *Node) PutMutable(ctx context.Context, item MutableItem) error {
+	encoded, err := bencode.Encode(item.V)
+	if err != nil {
+		return err
+	}
+	args := map[string]interface{}{
+		"v":   bencode.Raw(encoded),
+		"k":   string(item.Key),
+		"seq": item.Seq,
+		"sig": string(item.Sig),
+	}
+	if len(item.Salt) > 0 {
+		args["salt"] = string(item.Salt)
+	}
+	return dht.put(ctx, item.Target(), args)
+}
+
+// GetMutable returns the latest version of the mutable item of key and salt the nodes
+// closest to it have
+func (dht *Node) GetMutable(ctx context.Context, key ed25519.PublicKey, salt []byte) (MutableItem, error) {
+	var best MutableItem
+	found := false
+	_, err := dht.lookupAll(ctx, MutableTarget(key, salt), "get", nil, func(n *dhtLookupNode, r map[string]interface{}) {
+		v, hasV := r["v"]
+		k, _ := r["k"].(string)
+		sig, _ := r["sig"].(string)
+		seq, hasSeq := r["seq"].(int64)
+		if !hasV || !hasSeq || k != string(key) || len(sig) != ed25519.SignatureSize {
+			return
+		}
+		if found && seq <= best.Seq {
+			return
+		}
+		encoded, err := bencode.Encode(v)
+		if err != nil || !ed25519.Verify(key, dhtSignedPart(salt, seq, encoded), []byte(sig)) {
+			return
+		}
+		best = MutableItem{Key: key, Salt: salt, Seq: seq, V: v, Sig: []byte(sig)}
+		found = true
+	})
+	if found {
+		return best, nil
+	}
+	if err != nil {
+		return MutableItem{}, err
+	}
+	return MutableItem{}, ErrItemNotFound
+}
+
+// this function looks up the nodes closest to target and sends them a put with args
+func (dht *Node) put(ctx context.Context, target [20]byte, args map[string]interface{}) error {
+	closest, err := dht.lookupAll(ctx, target, "get", nil, nil)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	stored := 0
+	for _, n := range closest {
+		if n.token == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeArgs := map[string]interface{}{"token": n.token}
+			for k, v := range args {
+				nodeArgs[k] = v
+			}
+			_, err := dht.query(ctx, n.addr, "put", nodeArgs)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			stored++
+		}()
+	}
+	wg.Wait()
+	if stored == 0 {
+		if len(errs) == 0 {
+			return errors.New("no node handed out a token")
+		}
+		return fmt.Errorf("no node stored the item: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// this function answers get and put queries into r, or returns the error to answer with
+func (dht *Node) handleItemQuery(msg dhtMessage, addr *net.UDPAddr, stack *dhtStack, r map[string]interface{}) *Error {
+	switch msg.Q {
+	case "get":
+		target, ok := msg.A["target"].(string)
+		if !ok || len(target) != 20 {
+			return &Error{Code: dhtErrorProtocol, Message: "invalid target"}
+		}
+		r["token"] = dht.token(addr.IP, false)
+		closestNodesAnswer(r, [20]byte([]byte(target)), dht.wanted(msg.A, stack))
+		dht.mu.Lock()
+		item := dht.items[[20]byte([]byte(target))]
+		dht.mu.Unlock()
+		if item == nil {
+			return nil
+		}
+		if item.k != nil {
+			r["seq"] = item.seq
+			// a node that has this version or a later one only needs to know it's current
+			if seq, ok := msg.A["seq"].(int64); ok && seq >= item.seq {
+				return nil
+			}
+			r["k"], r["sig"] = string(item.k), string(item.sig)
+		}
+		r["v"] = item.v
+		return nil
+	case "put":
+		token, _ := msg.A["token"].(string)
+		if !dht.validToken(token, addr.IP) {
+			return &Error{Code: dhtErrorProtocol, Message: "bad token"}
+		}
+		v, ok := msg.A["v"]
+		if !ok {
+			return &Error{Code: dhtErrorProtocol, Message: "no value"}
+		}
+		encoded, err := bencode.Encode(v)
+		if err != nil {
+			return &Error{Code: dhtErrorProtocol, Message: "invalid value"}
+		}
+		if len(encoded) > dhtMaxItemSize {
+			return &Error{Code: dhtErrorTooBig, Message: "message (v field) too big"}
+		}
+		k, mutable := msg.A["k"].(string)
+		if !mutable {
+			return dht.storeItem(sha1.Sum(encoded), &dhtStoredItem{v: encoded}, nil)
+		}
+		salt, _ := msg.A["salt"].(string)
+		sig, _ := msg.A["sig"].(string)
+		seq, hasSeq := msg.A["seq"].(int64)
+		switch {
+		case len(salt) > dhtMaxSaltSize:
+			return &Error{Code: dhtErrorSaltTooBig, Message: "salt (salt field) too big"}
+		case len(k) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize || !hasSeq:
+			return &Error{Code: dhtErrorProtocol, Message: "invalid mutable item"}
+		case !ed25519.Verify(ed25519.PublicKey(k), dhtSignedPart([]byte(salt), seq, encoded), []byte(sig)):
+			return &Error{Code: dhtErrorInvalidSignature, Message: "invalid signature"}
+		}
+		item := &dhtStoredItem{v: encoded, k: []byte(k), salt: []byte(salt), seq: seq, sig: []byte(sig)}
+		var cas *int64
+		if c, ok := msg.A["cas"].(int64); ok {
+			cas = &c
+		}
+		return dht.storeItem(MutableTarget(item.k, item.salt), item, cas)
+	}
+	return nil
+}
+
+// this function stores item under target, within the limits. a mutable item only replaces
+// an older version, and with cas only the version it names
+func (dht *Node) storeItem(target [20]byte, item *dhtStoredItem, cas *int64) *Error {
+	dht.mu.Lock()
+	defer dht.mu.Unlock()
+	item.expires = time.Now().Add(dhtItemTTL)
+	old := dht.items[target]
+	if old == nil {
+		if len(dht.items) >= dhtMaxItems {
+			return &Error{Code: dhtErrorGeneric, Message: "storage full"}
+		}
+		dht.items[target] = item
+		return nil
+	}
+	if item.k != nil {
+		if cas != nil && *cas != old.seq {
+			return &Error{Code: dhtErrorCASMismatch, Message: "CAS mismatch, re-read value and try again"}
+		}
+		if item.seq < old.seq || item.seq == old.seq && !bytes.Equal(item.v, old.v) {
+			return &Error{Code: dhtErrorSeqTooLow, Message: "sequence number less than current"}
+		}
+	}
+	dht.items[target] = item
+	return nil
+}
@@ -0,0 +1,162 @@
+// This file runs iterative lookups on the DHT. A lookup starts from the nodes of our table
+// closest to the target and asks them for nodes closer still, dhtAlpha queries at a time,
+// until the dhtK closest nodes it heard of have all answered or failed. Those are the nodes
+// storing the target's peers, the ones get_peers and announce_peer go to. A lookup stays in
+// one address family, lookupAll runs one in each
+
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// dhtAlpha is the number of queries a lookup has in flight
+const dhtAlpha = 3
+
+// dhtLookupNode is a node met during a lookup
+type dhtLookupNode struct {
+	id       [20]byte
+	addr     *net.UDPAddr
+	queried  bool
+	answered bool
+	failed   bool
+	// token is what the node answered get_peers with, announcing to it needs it
+	token string
+	// seeds and peers are the bloom filters of a get_peers scrape, see scrape.go
+	seeds, peers *dhtBloom
+}
+
+// this function runs lookup on every stack at once and returns the closest nodes of each.
+// onReply sees one answer at a time. it fails when every lookup failed
+func (dht *Node) lookupAll(ctx context.Context, target [20]byte, method string, args map[string]interface{}, onReply func(n *dhtLookupNode, r map[string]interface{})) ([]*dhtLookupNode, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var closest []*dhtLookupNode
+	errs := make([]error, len(dht.stacks))
+	for i, s := range dht.stacks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodes, err := dht.lookup(ctx, s, target, method, args, func(n *dhtLookupNode, r map[string]interface{}) {
+				if onReply != nil {
+					mu.Lock()
+					onReply(n, r)
+					mu.Unlock()
+				}
+			})
+			mu.Lock()
+			closest = append(closest, nodes...)
+			mu.Unlock()
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	if len(closest) == 0 {
+		// the IPv4 error tells more, an IPv6 table is empty on networks without IPv6
+		return nil, errs[0]
+	}
+	return closest, nil
+}
+
+// this function looks for the nodes closest to target by sending them queries of method,
+// find_node or get_peers, with the extra arguments args, to the nodes of stack. onReply,
+// when set, sees every answer on the lookup's goroutine. it returns the closest nodes that
+// answered, closest first
+func (dht *Node) lookup(ctx context.Context, stack *dhtStack, target [20]byte, method string, args map[string]interface{}, onReply func(n *dhtLookupNode, r map[string]interface{})) ([]*dhtLookupNode, error) {
+	start := stack.table.closest(target, dhtK)
+	if len(start) == 0 {
+		return nil, errors.New("no dht nodes known")
+	}
+	var nodes []*dhtLookupNode
+	seen := make(map[string]bool)
+	add := func(id [20]byte, addr *net.UDPAddr) {
+		key := addr.String()
+		if seen[key] || id == dht.id {
+			return
+		}
+		seen[key] = true
+		nodes = append(nodes, &dhtLookupNode{id: id, addr: addr})
+	}
+	for _, n := range start {
+		add(n.id, n.addr)
+	}
+	argName := "target"
+	if method == "get_peers" {
+		argName = "info_hash"
+	}
+
+	type result struct {
+		n   *dhtLookupNode
+		r   map[string]interface{}
+		err error
+	}
+	results := make(chan result)
+	inflight := 0
+	for {
+		sortByDistance(nodes, target, func(n *dhtLookupNode) [20]byte { return n.id })
+		// the closest dhtK nodes that didn't fail get queried, closest first
+		live := 0
+		for _, n := range nodes {
+			if inflight >= dhtAlpha || live >= dhtK || ctx.Err() != nil {
+				break
+			}
+			if n.failed {
+				continue
+			}
+			live++
+			if n.queried {
+				continue
+			}
+			n.queried = true
+			inflight++
+			// every query gets its own arguments, query adds our id to them
+			queryArgs := map[string]interface{}{argName: string(target[:])}
+			for k, v := range args {
+				queryArgs[k] = v
+			}
+			go func() {
+				r, err := dht.query(ctx, n.addr, method, queryArgs)
+				results <- result{n, r, err}
+			}()
+		}
+		if inflight == 0 {
+			break
+		}
+		res := <-results
+		inflight--
+		if res.err != nil {
+			res.n.failed = true
+			continue
+		}
+		res.n.answered = true
+		res.n.token, _ = res.r["token"].(string)
+		if compact, ok := res.r[stack.nodesKey()].(string); ok {
+			for _, n := range parseCompactNodes([]byte(compact), stack.ipLen()) {
+				add(n.id, n.addr)
+			}
+		}
+		if onReply != nil {
+			onReply(res.n, res.r)
+		}
+	}
+
+	var closest []*dhtLookupNode
+	for _, n := range nodes {
+		if len(closest) == dhtK {
+			break
+		}
+		if n.answered {
+			closest = append(closest, n)
+		}
+	}
+	if len(closest) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("no dht node answered")
+	}
+	return closest, nil
+}
@@ -0,0 +1,116 @@
+// This file implements the DHT scrape extension (BEP 33), which estimates the size of a
+// trackerless swarm. A get_peers query asking for a scrape is answered with two bloom
+// filters of the addresses announced for the infohash, one of seeds and one of everyone
+// else. The filters of the nodes closest to the infohash are merged, as every peer
+// announces to several of them, and the number of peers is estimated from the bits left
+// unset. Every announce scrapes along the way, the client's downloads report the result in
+// their stats next to the tracker's numbers
+
+package dht
+
+import (
+	"context"
+	"crypto/sha1"
+	"math"
+	"math/bits"
+	"net"
+)
+
+// the filters are 2048 bits with two hash functions, as fixed by BEP 33
+const (
+	dhtBloomBytes  = 256
+	dhtBloomBits   = dhtBloomBytes * 8
+	dhtBloomHashes = 2
+)
+
+type dhtBloom [dhtBloomBytes]byte
+
+// ScrapeResult is the swarm size of an infohash estimated from the DHT
+type ScrapeResult struct {
+	Seeds    int
+	Leechers int
+}
+
+// this function adds ip to the filter
+func (b *dhtBloom) add(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	h := sha1.Sum(ip)
+	for i := 0; i < dhtBloomHashes; i++ {
+		index := (int(h[2*i]) | int(h[2*i+1])<<8) % dhtBloomBits
+		b[index/8] |= 1 << (index % 8)
+	}
+}
+
+func (b *dhtBloom) union(other *dhtBloom) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+// this function estimates how many addresses were added to the filter
+func (b *dhtBloom) estimate() int {
+	zeros := 0
+	for _, c := range b {
+		zeros += 8 - bits.OnesCount8(c)
+	}
+	if zeros == dhtBloomBits {
+		return 0
+	}
+	// a full filter says nothing beyond its capacity, count it as having one bit unset
+	zeros = max(zeros, 1)
+	m := float64(dhtBloomBits)
+	return int(math.Round(math.Log(float64(zeros)/m) / (dhtBloomHashes * math.Log(1-1/m))))
+}
+
+func parseDHTBloom(v interface{}) *dhtBloom {
+	s, ok := v.(string)
+	if !ok || len(s) != dhtBloomBytes {
+		return nil
+	}
+	var b dhtBloom
+	copy(b[:], s)
+	return &b
+}
+
+// Scrape estimates the number of seeds and leechers of infoHash from the filters of the
+// nodes closest to it
+func (dht *Node) Scrape(ctx context.Context, infoHash [20]byte) (ScrapeResult, error) {
+	_, closest, err := dht.getPeers(ctx, infoHash, true)
+	if err != nil {
+		return ScrapeResult{}, err
+	}
+	return mergeScrapes(closest), nil
+}
+
+// this function merges the filters the closest nodes answered a scrape with
+func mergeScrapes(closest []*dhtLookupNode) ScrapeResult {
+	var seeds, peers dhtBloom
+	for _, n := range closest {
+		if n.seeds != nil {
+			seeds.union(n.seeds)
+		}
+		if n.peers != nil {
+			peers.union(n.peers)
+		}
+	}
+	return ScrapeResult{Seeds: seeds.estimate(), Leechers: peers.estimate()}
+}
+
+// this function returns the filters of the seeds and the other peers announced to us for
+// infoHash
+func (dht *Node) scrapeFilters(infoHash [20]byte) (seeds, peers *dhtBloom) {
+	dht.mu.Lock()
+	defer dht.mu.Unlock()
+	seeds, peers = new(dhtBloom), new(dhtBloom)
+	for compact, sp := range dht.peers[infoHash] {
+		ip := net.IP([]byte(compact[:len(compact)-2]))
+		if sp.seed {
+			seeds.add(ip)
+		} else {
+			peers.add(ip)
+		}
+	}
+	return seeds, peers
+}
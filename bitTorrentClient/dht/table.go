@@ -0,0 +1,195 @@
+// This file keeps the DHT routing table. Nodes are sorted into 160 buckets by how many
+// leading bits their id shares with ours, and each bucket holds at most dhtK nodes, so we
+// know many nodes close to us and a few far away, which is all a lookup needs to get
+// anywhere in a few hops. Nodes are added when they answer or query us. A node that stops
+// answering is dropped after dhtMaxFailures timeouts, and a full bucket only takes a new
+// node in place of one that failed. Every address family has a table of its own, see
+// ipv6.go
+
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// dhtK is the size of a bucket, and the number of closest nodes a lookup ends with
+	dhtK = 8
+	// a node is dropped once this many queries to it in a row timed out
+	dhtMaxFailures = 3
+)
+
+type dhtNode struct {
+	id       [20]byte
+	addr     *net.UDPAddr
+	lastSeen time.Time
+	failures int
+}
+
+type dhtTable struct {
+	self [20]byte
+	// ipv6 is set for the table of IPv6 nodes, which takes no IPv4 nodes and the other way round
+	ipv6    bool
+	mu      sync.Mutex
+	buckets [160][]*dhtNode
+}
+
+func newDHTTable(self [20]byte, ipv6 bool) *dhtTable {
+	return &dhtTable{self: self, ipv6: ipv6}
+}
+
+// this function returns the bucket of id, the number of leading bits it shares with our
+// own id, and -1 for our own id
+func (t *dhtTable) bucket(id [20]byte) int {
+	for i := range id {
+		if x := id[i] ^ t.self[i]; x != 0 {
+			bit := 0
+			for x&0x80 == 0 {
+				x <<= 1
+				bit++
+			}
+			return i*8 + bit
+		}
+	}
+	return -1
+}
+
+// this function records that the node id at addr is alive
+func (t *dhtTable) add(id [20]byte, addr *net.UDPAddr) {
+	b := t.bucket(id)
+	if b < 0 || isIPv6(addr.IP) != t.ipv6 || addr.Port == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for _, n := range t.buckets[b] {
+		if n.id == id {
+			n.addr, n.lastSeen, n.failures = addr, now, 0
+			return
+		}
+	}
+	node := &dhtNode{id: id, addr: addr, lastSeen: now}
+	if len(t.buckets[b]) < dhtK {
+		t.buckets[b] = append(t.buckets[b], node)
+		return
+	}
+	// a full bucket keeps its nodes unless one of them is failing
+	worst := -1
+	for i, n := range t.buckets[b] {
+		if n.failures > 0 && (worst < 0 || n.failures > t.buckets[b][worst].failures) {
+			worst = i
+		}
+	}
+	if worst >= 0 {
+		t.buckets[b][worst] = node
+	}
+}
+
+// this function counts a query to addr that wasn't answered
+func (t *dhtTable) failed(addr *net.UDPAddr) {
+	key := addr.String()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for b, nodes := range t.buckets {
+		for i, n := range nodes {
+			if n.addr.String() != key {
+				continue
+			}
+			n.failures++
+			if n.failures >= dhtMaxFailures {
+				t.buckets[b] = append(nodes[:i:i], nodes[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// this function returns up to count nodes closest to target, closest first
+func (t *dhtTable) closest(target [20]byte, count int) []dhtNode {
+	t.mu.Lock()
+	var nodes []dhtNode
+	for _, bucket := range t.buckets {
+		for _, n := range bucket {
+			nodes = append(nodes, *n)
+		}
+	}
+	t.mu.Unlock()
+	sortByDistance(nodes, target, func(n dhtNode) [20]byte { return n.id })
+	if len(nodes) > count {
+		nodes = nodes[:count]
+	}
+	return nodes
+}
+
+func (t *dhtTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+// this function sorts items by the XOR distance of their ids to target, closest first
+func sortByDistance[T any](items []T, target [20]byte, id func(T) [20]byte) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return closerTo(target, id(items[i]), id(items[j]))
+	})
+}
+
+// this function reports whether a is closer to target than b
+func closerTo(target, a, b [20]byte) bool {
+	var da, db [20]byte
+	for i := range target {
+		da[i] = a[i] ^ target[i]
+		db[i] = b[i] ^ target[i]
+	}
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// this function encodes nodes in the compact node info format, 26 bytes per node for IPv4
+// and 38 for IPv6 as ipLen says. nodes of the other family are left out
+func compactNodes(nodes []dhtNode, ipLen int) []byte {
+	var b []byte
+	for _, n := range nodes {
+		ip := n.addr.IP.To4()
+		if ipLen == net.IPv6len {
+			ip = n.addr.IP.To16()
+			if !isIPv6(n.addr.IP) {
+				ip = nil
+			}
+		}
+		if ip == nil {
+			continue
+		}
+		b = append(b, n.id[:]...)
+		b = append(b, ip...)
+		b = binary.BigEndian.AppendUint16(b, uint16(n.addr.Port))
+	}
+	return b
+}
+
+// this function decodes compact node info with addresses of ipLen bytes, skipping nodes
+// without a port
+func parseCompactNodes(data []byte, ipLen int) []dhtNode {
+	var nodes []dhtNode
+	size := 20 + ipLen + 2
+	for i := 0; i+size <= len(data); i += size {
+		var n dhtNode
+		copy(n.id[:], data[i:i+20])
+		port := binary.BigEndian.Uint16(data[i+size-2 : i+size])
+		if port == 0 {
+			continue
+		}
+		n.addr = &net.UDPAddr{IP: net.IP(append([]byte(nil), data[i+20:i+20+ipLen]...)), Port: int(port)}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
@@ -0,0 +1,78 @@
+// This file has downloads find peers on the DHT. A running download of a public torrent
+// looks its infohash up when it starts and every dhtAnnounceInterval after, announcing our
+// port to the nodes closest to it so other peers find us too, and dials what the lookups
+// found. Nodes listed by a trackerless torrent are bootstrapped from before the first
+// lookup. The lookups scrape the swarm as well, see dht/scrape.go. Private torrents (BEP 27)
+// keep to their trackers and never touch the DHT
+package bittorrentclient
+
+import (
+	"context"
+	"time"
+
+	"mybittorrent/dht"
+)
+
+const (
+	// dhtAnnounceInterval is how often a download looks for peers on the DHT
+	dhtAnnounceInterval = 15 * time.Minute
+	// dhtRetryInterval is the wait after a lookup that failed, e.g. before the DHT was
+	// bootstrapped
+	dhtRetryInterval = time.Minute
+	// dhtLookupTimeout bounds a lookup with its announce
+	dhtLookupTimeout = time.Minute
+)
+
+// SetDHT makes the download look for peers on node from its next Start, nil stops that
+func (d *Download) SetDHT(node *dht.Node) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dht = node
+}
+
+// this function reports whether the download uses the DHT. the caller must hold d.mu
+func (d *Download) usesDHT() bool {
+	return d.dht != nil && d.Torrent.Info.Private != 1
+}
+
+// this function looks up and announces the download on the DHT until ctx is done
+func (d *Download) dhtLoop(ctx context.Context, node *dht.Node) {
+	defer d.wg.Done()
+	if len(d.Torrent.Nodes) > 0 {
+		_ = node.Bootstrap(ctx, d.Torrent.Nodes...)
+	}
+	for {
+		d.mu.Lock()
+		// behind a proxy the port is zero and nothing is announced, the lookup still finds
+		// peers
+		port := d.dialer.announcePort(d.Port)
+		opts := dht.AnnounceOptions{
+			Seed: d.complete(),
+			// behind a NAT that changes ports our listening port isn't what others reach, the
+			// nodes are told to take the port our packets come from instead
+			ImpliedPort: d.nat.External.IsValid() && !d.nat.PortPreserved,
+		}
+		d.mu.Unlock()
+		lookupCtx, cancel := context.WithTimeout(ctx, dhtLookupTimeout)
+		peers, scrape, err := node.Announce(lookupCtx, d.InfoHash, port, opts)
+		cancel()
+		if err == nil || len(peers) > 0 {
+			d.mu.Lock()
+			d.dhtSeeds, d.dhtLeechers = scrape.Seeds, scrape.Leechers
+			d.mu.Unlock()
+		}
+		if len(peers) > 0 {
+			d.addPeers(PeerSourceDHT, peers...)
+			d.connectPeers(ctx)
+		}
+		wait := dhtAnnounceInterval
+		if err != nil && len(peers) == 0 {
+			wait = dhtRetryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// This file keeps a download from running the disk full. Before a download starts, and
+// every diskSpaceCheckInterval while it downloads, the free space on the filesystem of its
+// directory is compared with what its wanted files still need. When that doesn't fit the
+// download is paused with an EventDiskFull event and an ErrDiskFull error, instead of
+// failing later with whatever error a write happens to return. A write failing because the
+// disk filled up anyway, e.g. by something else writing to it, is handled the same way.
+// Only storages keeping the files on disk are checked
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrDiskFull is the download's error when it was paused for lack of disk space. Free some
+// space, or lower what is wanted, and resume it
+var ErrDiskFull = errors.New("not enough disk space")
+
+// free space is checked this often while downloading
+const diskSpaceCheckInterval = 30 * time.Second
+
+// SetMinFreeSpace makes the download keep at least bytes free on the disk on top of what it
+// still needs, so other programs aren't starved of space. A negative value turns the disk
+// space checks off
+func (d *Download) SetMinFreeSpace(bytes int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.minFreeSpace = bytes
+}
+
+func (d *Download) MinFreeSpace() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.minFreeSpace
+}
+
+// pendingFile is a wanted file that isn't complete yet
+type pendingFile struct {
+	path string
+	// partPath is where the file is while incomplete, when that isn't path
+	partPath  string
+	remaining int64
+	completed int64
+}
+
+// this function lists the wanted files still missing data, an empty list means there is
+// nothing to check. the caller must hold d.mu
+func (d *Download) pendingFiles() []pendingFile {
+	if d.minFreeSpace < 0 {
+		return nil
+	}
+	switch d.backend.(type) {
+	case FilesystemStorage, MmapStorage:
+	default:
+		return nil
+	}
+	layout := d.fileLayout()
+	paths, err := layout.filePaths(d.Dir)
+	if err != nil {
+		// opening the storage reports that
+		return nil
+	}
+	suffix := d.partSuffix()
+	var pending []pendingFile
+	for i, f := range layout.files() {
+		if f.Padding || d.filePriority[i] == FileSkip {
+			continue
+		}
+		completed := d.Torrent.fileCompleted(f, d.have)
+		if completed < f.Length {
+			pf := pendingFile{path: paths[i], remaining: f.Length - completed, completed: completed}
+			if suffix != "" {
+				pf.partPath = paths[i] + suffix
+			}
+			pending = append(pending, pf)
+		}
+	}
+	return pending
+}
+
+// this function returns an ErrDiskFull error when the pending files don't fit on the disk
+// holding dir with reserve bytes to spare. space a file already took up beyond its
+// verified data, by preallocation or by blocks of pieces in progress, is counted as used
+// for it. it doesn't need d.mu, so the lookups don't hold up the download
+func checkDiskSpace(dir string, pending []pendingFile, reserve int64) error {
+	var needed int64
+	for _, f := range pending {
+		remaining := f.remaining
+		used, ok := allocatedSpace(f.path)
+		if !ok && f.partPath != "" {
+			used, ok = allocatedSpace(f.partPath)
+		}
+		if ok {
+			remaining -= max(used-f.completed, 0)
+		}
+		needed += max(remaining, 0)
+	}
+	if needed == 0 {
+		return nil
+	}
+	free, err := freeSpace(existingParent(dir))
+	if err != nil {
+		// nothing to go on, writes will tell
+		return nil
+	}
+	if free-reserve >= needed {
+		return nil
+	}
+	return fmt.Errorf("%w: %d bytes free in %s, but %d more are needed", ErrDiskFull, free, dir, needed+reserve-free)
+}
+
+// this function returns dir, or its closest parent that exists when it wasn't created yet
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// this function reports whether err is a write failing because the disk is full
+func isDiskFull(err error) bool {
+	return errors.Is(err, ErrDiskFull) || errors.Is(err, syscall.ENOSPC)
+}
+
+// this function checks the free space while downloading and pauses when it ran low
+func (d *Download) watchDiskSpace() {
+	d.mu.Lock()
+	if d.state != DownloadDownloading {
+		d.mu.Unlock()
+		return
+	}
+	dir, reserve := d.Dir, d.minFreeSpace
+	pending := d.pendingFiles()
+	d.mu.Unlock()
+
+	err := checkDiskSpace(dir, pending, reserve)
+	if err != nil {
+		d.pauseForSpace(err)
+	}
+}
+
+// this function pauses the running download because the disk is full, or about to be
+func (d *Download) pauseForSpace(err error) {
+	if !errors.Is(err, ErrDiskFull) {
+		err = fmt.Errorf("%w: %w", ErrDiskFull, err)
+	}
+	d.pauseForStorage(err, EventDiskFull)
+}
+
+// this function pauses the running download for a storage problem, with an event of type
+// kind and err as the download's error. Pause waits for the goroutine calling us, so it
+// runs on its own
+func (d *Download) pauseForStorage(err error, kind EventType) {
+	d.mu.Lock()
+	ctx := d.runCtx
+	if d.cancel == nil || d.storagePause {
+		d.mu.Unlock()
+		return
+	}
+	d.storagePause = true
+	d.err = err
+	d.emit(Event{Type: kind, Err: err})
+	d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+	d.mu.Unlock()
+	go func() {
+		// unless the download was stopped or paused in the meantime
+		if ctx.Err() == nil {
+			_ = d.Pause()
+		}
+	}()
+}
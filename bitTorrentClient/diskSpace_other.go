@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+// Free space is only looked up on Linux and macOS, elsewhere the disk space checks are
+// skipped and a full disk shows up as a failed write
+package bittorrentclient
+
+import "errors"
+
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space can't be determined on this platform")
+}
+
+func allocatedSpace(path string) (int64, bool) {
+	return 0, false
+}
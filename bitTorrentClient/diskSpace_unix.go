@@ -0,0 +1,35 @@
+//go:build linux || darwin
+
+// This file asks the filesystem how much space is free and how much a file takes up on
+// disk, for the disk space checks in diskSpace.go
+package bittorrentclient
+
+import (
+	"os"
+	"syscall"
+)
+
+// this function returns the bytes an unprivileged process can still write to the
+// filesystem holding path
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// this function returns the bytes the file at path has allocated on disk, which for a
+// sparse file is less than its size
+func allocatedSpace(path string) (int64, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size(), true
+	}
+	return st.Blocks * 512, true
+}
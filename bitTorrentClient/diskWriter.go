@@ -0,0 +1,195 @@
+// This file decouples writing pieces from receiving them. Verified pieces are queued for a
+// writer goroutine instead of being written by whoever verified them, so a slow or spun down
+// disk only backs up the queue. The queue is bounded, once it is full the pipeline in front
+// of it waits, which keeps memory in check when the disk can't keep up. When data is synced
+// to the disk is up to the download's SyncPolicy
+package bittorrentclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// this many verified pieces can wait to be written
+	diskQueueLength = 64
+	// SyncPeriodic syncs at most this often
+	periodicSyncInterval = 30 * time.Second
+)
+
+var errWriterClosed = errors.New("disk writer is closed")
+
+type SyncPolicy int
+
+const (
+	// SyncOnFlush syncs when the download is paused, stopped or completes
+	SyncOnFlush SyncPolicy = iota
+	// SyncPeriodic also syncs every 30 seconds while pieces are being written
+	SyncPeriodic
+	// SyncEveryPiece syncs after every piece, safest and slowest
+	SyncEveryPiece
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncOnFlush:
+		return "on flush"
+	case SyncPeriodic:
+		return "periodic"
+	case SyncEveryPiece:
+		return "every piece"
+	default:
+		return "unknown"
+	}
+}
+
+// DiskStats describes the download's disk activity
+type DiskStats struct {
+	QueuedWrites int
+	QueuedBytes  int64
+	Written      int64
+	LastSync     time.Time
+}
+
+// a write job with nil data only syncs
+type writeJob struct {
+	index int
+	data  []byte
+	done  func(err error)
+}
+
+type diskWriter struct {
+	storage TorrentStorage
+	jobs    chan writeJob
+	stopped chan struct{}
+
+	// closeMu is read locked by writers while they send, so close can't close jobs under them
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu           sync.Mutex
+	policy       SyncPolicy
+	queuedWrites int
+	queuedBytes  int64
+	written      int64
+	lastSync     time.Time
+	// dirty is set while there are writes that weren't synced yet
+	dirty bool
+}
+
+func newDiskWriter(storage TorrentStorage, policy SyncPolicy) *diskWriter {
+	w := &diskWriter{
+		storage:  storage,
+		jobs:     make(chan writeJob, diskQueueLength),
+		stopped:  make(chan struct{}),
+		policy:   policy,
+		lastSync: time.Now(),
+	}
+	go w.run()
+	return w
+}
+
+func (w *diskWriter) run() {
+	defer close(w.stopped)
+	for job := range w.jobs {
+		var err error
+		if job.data != nil {
+			_, err = w.storage.WritePieceAt(job.index, job.data, 0)
+		}
+
+		w.mu.Lock()
+		if job.data != nil {
+			w.queuedWrites--
+			w.queuedBytes -= int64(len(job.data))
+			if err == nil {
+				w.written += int64(len(job.data))
+				w.dirty = true
+			}
+		}
+		sync := w.dirty && (job.data == nil || w.policy == SyncEveryPiece ||
+			(w.policy == SyncPeriodic && time.Since(w.lastSync) >= periodicSyncInterval))
+		w.mu.Unlock()
+
+		if sync && err == nil {
+			err = w.sync()
+		}
+		if job.done != nil {
+			job.done(err)
+		}
+	}
+}
+
+func (w *diskWriter) sync() error {
+	err := w.storage.Flush()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirty = false
+	w.lastSync = time.Now()
+	w.mu.Unlock()
+	return nil
+}
+
+// this function queues piece index to be written, done is called from the writer goroutine
+// once it was. it blocks while the queue is full
+func (w *diskWriter) write(index int, data []byte, done func(err error)) error {
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if w.closed {
+		return errWriterClosed
+	}
+	w.mu.Lock()
+	w.queuedWrites++
+	w.queuedBytes += int64(len(data))
+	w.mu.Unlock()
+	w.jobs <- writeJob{index: index, data: data, done: done}
+	return nil
+}
+
+// this function waits until everything queued so far is written and synced
+func (w *diskWriter) flush() error {
+	w.closeMu.RLock()
+	if w.closed {
+		w.closeMu.RUnlock()
+		return errWriterClosed
+	}
+	result := make(chan error, 1)
+	w.jobs <- writeJob{done: func(err error) { result <- err }}
+	w.closeMu.RUnlock()
+	return <-result
+}
+
+// this function flushes the queue and stops the writer goroutine, the storage stays open
+func (w *diskWriter) close() error {
+	err := w.flush()
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.jobs)
+	}
+	w.closeMu.Unlock()
+	<-w.stopped
+	if errors.Is(err, errWriterClosed) {
+		return nil
+	}
+	return err
+}
+
+func (w *diskWriter) setPolicy(policy SyncPolicy) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.policy = policy
+}
+
+func (w *diskWriter) stats() DiskStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return DiskStats{
+		QueuedWrites: w.queuedWrites,
+		QueuedBytes:  w.queuedBytes,
+		Written:      w.written,
+		LastSync:     w.lastSync,
+	}
+}
@@ -0,0 +1,1442 @@
+// This file ties the pieces together into a running download. A Download announces to the
+// tracker, connects to the peers it hands out, requests blocks picked by the piece picker,
+// verifies completed pieces against their hashes and writes them to disk, and serves
+// pieces back to peers that ask for them. Start, Pause and Stop move it between states
+package bittorrentclient
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mybittorrent/dht"
+)
+
+const (
+	// BlockSize is the length of the blocks pieces are requested in
+	BlockSize = 16 * 1024
+	// requests kept outstanding with each peer, enough to fill the pipe on most links
+	requestBacklog = 16
+	// peers may ask for blocks up to this size, anything bigger is refused
+	maxServedBlock = 128 * 1024
+	// DefaultPort is the port announced to trackers when none is set
+	DefaultPort = 6881
+	// how often the maintenance loop runs: flushing haves, dialing peers, checking snubs
+	downloadTick = time.Second
+	// the stopped announce is best effort, it mustn't hold up shutdown
+	stoppedAnnounceTimeout = 5 * time.Second
+	// our peer ID starts with an Azureus style client code
+	peerIDPrefix = "-GN0001-"
+)
+
+type DownloadState int
+
+const (
+	DownloadStopped DownloadState = iota
+	DownloadDownloading
+	DownloadSeeding
+	DownloadPaused
+	DownloadError
+	DownloadChecking
+	// DownloadQueued waits for the client's queue to start it, see queue.go
+	DownloadQueued
+)
+
+func (s DownloadState) String() string {
+	switch s {
+	case DownloadStopped:
+		return "stopped"
+	case DownloadDownloading:
+		return "downloading"
+	case DownloadSeeding:
+		return "seeding"
+	case DownloadPaused:
+		return "paused"
+	case DownloadError:
+		return "error"
+	case DownloadChecking:
+		return "checking"
+	case DownloadQueued:
+		return "queued"
+	default:
+		return "unknown"
+	}
+}
+
+// activePiece is a piece whose blocks are being downloaded
+type activePiece struct {
+	index    int
+	data     []byte
+	received []bool
+	// requested holds the peers each block is currently requested from
+	requested []map[*Peer]bool
+	remaining int
+	// owner is the peer the piece's blocks go to first, nil once it choked us, hung up or
+	// timed out and anyone may finish the piece
+	owner *Peer
+	// writeFailures counts the failed attempts to write the verified piece
+	writeFailures int
+}
+
+func newActivePiece(index, length int) *activePiece {
+	blocks := (length + BlockSize - 1) / BlockSize
+	ap := &activePiece{
+		index:     index,
+		data:      make([]byte, length),
+		received:  make([]bool, blocks),
+		requested: make([]map[*Peer]bool, blocks),
+		remaining: blocks,
+	}
+	for i := range ap.requested {
+		ap.requested[i] = make(map[*Peer]bool)
+	}
+	return ap
+}
+
+func (ap *activePiece) block(i int) blockRequest {
+	begin := i * BlockSize
+	length := BlockSize
+	if begin+length > len(ap.data) {
+		length = len(ap.data) - begin
+	}
+	return blockRequest{Index: ap.index, Begin: begin, Length: length}
+}
+
+type Download struct {
+	Torrent  *Torrent
+	InfoHash [20]byte
+	PeerID   [20]byte
+	// Dir is the directory the torrent's files are saved under. It changes to the complete
+	// directory once they are moved there, see SetCompleteDir
+	Dir string
+	// Port is announced to trackers as the port we accept connections on, unless the dialer
+	// goes through a proxy and zero is announced instead
+	Port int
+	// MaxPeers is the number of peer connections the download aims for
+	MaxPeers int
+	// ResumePath is where resume data is loaded from on the first Start and saved to
+	// periodically, on Pause and on Stop. Empty disables fast resume
+	ResumePath string
+
+	dialer *PeerDialer
+	// ownDialer is set once SetDialer gave the download a dialer, a Client keeps its own
+	// dialer out of such a download
+	ownDialer   bool
+	picker      PiecePicker
+	choker      *Choker
+	bans        *BanList
+	reconnect   *ReconnectPolicy
+	attribution *pieceAttribution
+	announcer   *Announcer
+	// announceWake has announceLoop announce right away, so the completed event doesn't
+	// wait for the next interval
+	announceWake chan struct{}
+	// stopping is closed once the last stopped announce is done, announceLoop waits for it
+	// so the tracker doesn't hear started before stopped. nil before the first one
+	stopping chan struct{}
+
+	mu         sync.Mutex
+	state      DownloadState
+	err        error
+	storage    TorrentStorage
+	have       Bitfield
+	peers      map[*Peer]bool
+	peerStore  *PeerStore
+	active     map[int]*activePiece
+	downloaded int64
+	uploaded   int64
+	runCtx     context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	done       chan struct{}
+	events     eventFeed
+	rates      *TransferRates
+	// session is fed along with rates when the download is part of a session
+	session *TransferRates
+	// limits throttles the download's peers together with every other download's
+	limits *SessionLimiter
+	// conns counts the download's peers against the session's connection limits, see
+	// connLimits.go
+	conns *ConnectionManager
+	// uploadLimit and downloadLimit cap the download on its own, see downloadLimit.go
+	uploadLimit   *RateLimiter
+	downloadLimit *RateLimiter
+	// hasher checks completed pieces and rechecks, see hasher.go
+	hasher *Hasher
+	// ratioLimit, seedTimeLimit and idleLimit stop seeding once reached, zero seeds
+	// forever. see seedLimits.go
+	ratioLimit    float64
+	seedTimeLimit time.Duration
+	idleLimit     time.Duration
+	seedLimitHit  bool
+	// seededFor is the seeding time before seedingSince, the start of the current stretch
+	seededFor    time.Duration
+	seedingSince time.Time
+	lastUpload   time.Time
+	// unchoke holds the choker's settings, maintain copies them over before every rechoke
+	// since only it touches the choker. see uploadSlots.go
+	unchoke chokerSettings
+	// labels are kept sorted, see labels.go
+	labels []string
+	// queuePriority orders the download in the client's queue, see queue.go
+	queuePriority QueuePriority
+	// completeDir is where finished files are moved to, see moveCompleted.go
+	completeDir string
+	movePending bool
+	// moving is set while storage is closed for the files to be moved or renamed, which
+	// relocateMu serializes with each other and with Stop
+	moving     bool
+	relocateMu sync.Mutex
+	// layout is the torrent with renamed files, nil until something is renamed. see rename.go
+	layout *Torrent
+	// backend opens storage when the download starts, see storage.go
+	backend Storage
+	// writer writes verified pieces to storage, see diskWriter.go
+	writer     *diskWriter
+	syncPolicy SyncPolicy
+	// cache holds pieces read for uploads, see readCache.go
+	cache *ReadCache
+	// partial holds pieces in progress from the resume data until Start reads them back
+	partial []ResumePiece
+	// requestQueueTime sizes each peer's request pipeline, see requestDepth.go
+	requestQueueTime time.Duration
+	// quarantine holds pieces that failed their hash check, see quarantine.go
+	quarantine map[int]*quarantinedPiece
+	// pieceReady is closed and replaced whenever a piece is written, see reader.go
+	pieceReady chan struct{}
+	// wasted counts downloaded bytes that were thrown away: duplicate and unrequested
+	// blocks, and pieces that failed their hash check
+	wasted int64
+	// hashFailures and announceErrors count failed pieces and failed announces
+	hashFailures   int
+	announceErrors int
+	// bus is the session's event bus, see eventBus.go. it is read while d.mu is held
+	// by whoever emits, so it doesn't live under d.mu
+	bus atomic.Pointer[EventBus]
+	// loggers are where the download logs, by component, see logging.go. like bus they
+	// are read with d.mu held
+	loggers atomic.Pointer[componentLoggers]
+	// trackerSeeds and trackerLeechers are the swarm size from the last announce
+	trackerSeeds    int
+	trackerLeechers int
+	// dhtSeeds and dhtLeechers are the swarm size estimated by the last DHT scrape
+	dhtSeeds    int
+	dhtLeechers int
+	// nat is what a STUN check found out about our address, see stun.go
+	nat NATStatus
+	// trackerID is restored from resume data and handed to the announcer
+	trackerID string
+	resumed   bool
+	// minFreeSpace is kept free on the disk, negative turns the checks off, see diskSpace.go
+	minFreeSpace int64
+	// storagePause is set once the download is being paused for a storage problem
+	storagePause bool
+	// storagePolicy decides what write errors do, see storageErrors.go
+	storagePolicy StorageErrorPolicy
+
+	// filePriority holds the priority of each file, see SetFilePriority
+	filePriority []FilePriority
+	// pieceOverrides holds piece priorities set directly, they win over file priorities
+	pieceOverrides map[int]PiecePriority
+	// previewPieces fetches the first and last piece of each file early, see previewPieces.go
+	previewPieces bool
+	// dht finds peers for public torrents, see dhtPeers.go
+	dht *dht.Node
+	// preserveModTimes sets finished files to the times in the torrent, see modTimes.go
+	preserveModTimes bool
+}
+
+// NewDownload prepares a download of t into dir, call Start to begin
+func NewDownload(t *Torrent, dir string) (*Download, error) {
+	err := t.validate()
+	if err != nil {
+		return nil, err
+	}
+	peerID, err := newPeerID(peerIDPrefix)
+	if err != nil {
+		return nil, err
+	}
+	d := &Download{
+		Torrent:     t,
+		InfoHash:    t.InfoHash,
+		PeerID:      peerID,
+		Dir:         dir,
+		Port:        DefaultPort,
+		MaxPeers:    defaultMaxPerTorrent,
+		dialer:      NewPeerDialer(nil),
+		picker:      NewPicker(t.NumPieces()),
+		choker:      NewChoker(DefaultUploadSlots),
+		bans:        NewBanList(0),
+		reconnect:   NewReconnectPolicy(),
+		attribution: newPieceAttribution(),
+		have:        NewBitfield(t.NumPieces()),
+		peers:       make(map[*Peer]bool),
+		active:      make(map[int]*activePiece),
+		done:        make(chan struct{}),
+		rates:       NewTransferRates(),
+		hasher:      defaultHasher(),
+		cache:       defaultReadCache(),
+
+		announceWake: make(chan struct{}, 1),
+	}
+	d.uploadLimit, d.downloadLimit = newDownloadLimiters()
+	d.SetLogger(nil)
+	d.peerStore = newPeerStore(d.bans)
+	d.backend = FilesystemStorage{}
+	d.requestQueueTime = DefaultRequestQueueTime
+	d.unchoke = chokerSettings{uploadSlots: DefaultUploadSlots}
+	d.quarantine = make(map[int]*quarantinedPiece)
+	d.storagePolicy = DefaultStorageErrorPolicy()
+	d.pieceReady = make(chan struct{})
+	d.pieceOverrides = make(map[int]PiecePriority)
+	d.filePriority = make([]FilePriority, len(t.files()))
+	for i := range d.filePriority {
+		d.filePriority[i] = FileNormal
+	}
+	return d, nil
+}
+
+// this function returns a peer id starting with prefix, the rest is random
+func newPeerID(prefix string) ([20]byte, error) {
+	var peerID [20]byte
+	n := copy(peerID[:], prefix)
+	_, err := rand.Read(peerID[n:])
+	return peerID, err
+}
+
+// SetSyncPolicy decides when written data is synced to the disk, see SyncPolicy
+func (d *Download) SetSyncPolicy(policy SyncPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.syncPolicy = policy
+	if d.writer != nil {
+		d.writer.setPolicy(policy)
+	}
+}
+
+// DiskStats returns the state of the download's disk writes, including how many verified
+// pieces wait to be written
+func (d *Download) DiskStats() DiskStats {
+	d.mu.Lock()
+	writer := d.writer
+	d.mu.Unlock()
+	if writer == nil {
+		return DiskStats{}
+	}
+	return writer.stats()
+}
+
+// SetReadCache makes the download serve uploads through c instead of the shared default
+// cache, a cache with a zero budget turns caching off
+func (d *Download) SetReadCache(c *ReadCache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cache.invalidate(d.InfoHash)
+	d.cache = c
+}
+
+func (d *Download) readCache() *ReadCache {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cache
+}
+
+// this function returns a block for serving. it comes from the read cache, or else the
+// whole piece is read and cached so the requests for the rest of it don't hit the disk
+func (d *Download) readBlock(storage TorrentStorage, index, begin, length int) ([]byte, error) {
+	cache := d.readCache()
+	piece, ok := cache.get(d.InfoHash, index)
+	if ok {
+		return piece[begin : begin+length], nil
+	}
+	size := d.Torrent.PieceSize(index)
+	if !cache.fits(size) {
+		block := make([]byte, length)
+		_, err := storage.ReadPieceAt(index, block, int64(begin))
+		return block, err
+	}
+	piece = make([]byte, size)
+	_, err := storage.ReadPieceAt(index, piece, 0)
+	if err != nil {
+		return nil, err
+	}
+	cache.put(d.InfoHash, index, piece)
+	return piece[begin : begin+length], nil
+}
+
+// SetHasher makes the download check pieces on h instead of the shared default hasher
+func (d *Download) SetHasher(h *Hasher) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hasher = h
+}
+
+// SetDialer replaces the dialer used for outgoing peer connections, e.g. to enable uTP or a proxy
+func (d *Download) SetDialer(dialer *PeerDialer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialer = dialer
+	d.ownDialer = true
+}
+
+// this function gives the download dialer unless SetDialer gave it one already
+func (d *Download) setDefaultDialer(dialer *PeerDialer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.ownDialer {
+		d.dialer = dialer
+	}
+}
+
+func (d *Download) State() DownloadState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// Err returns the error that put the download into the error state
+func (d *Download) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Done is closed once every wanted piece has been downloaded and verified
+func (d *Download) Done() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.done
+}
+
+// Have returns a copy of the pieces we have
+func (d *Download) Have() Bitfield {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append(Bitfield(nil), d.have...)
+}
+
+// Peers returns the currently connected peers
+func (d *Download) Peers() []*Peer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.peerList()
+}
+
+// this function returns the connected peers as a slice. the caller must hold d.mu
+func (d *Download) peerList() []*Peer {
+	peers := make([]*Peer, 0, len(d.peers))
+	for p := range d.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// AddPeers hands the download peer addresses found outside its own announces
+func (d *Download) AddPeers(addrs ...string) {
+	d.addPeers(PeerSourceManual, addrs...)
+}
+
+// Start opens the files and begins announcing and connecting to peers. A paused download
+// picks up where it left off
+func (d *Download) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state == DownloadDownloading || d.state == DownloadSeeding {
+		return nil
+	}
+	if d.state == DownloadChecking {
+		return errors.New("download is being checked")
+	}
+	if !d.resumed && d.ResumePath != "" {
+		d.resumed = true
+		d.mu.Unlock()
+		err := d.LoadResume(d.ResumePath)
+		d.mu.Lock()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			// bad resume data only costs us a redownload, it shouldn't keep us from starting
+			d.emit(Event{Type: EventResumeFailed, Err: err})
+		}
+	}
+	if !d.complete() {
+		// better to not start than to fill the disk and fail halfway
+		err := checkDiskSpace(d.Dir, d.pendingFiles(), d.minFreeSpace)
+		if err != nil {
+			d.err = err
+			d.emit(Event{Type: EventDiskFull, Err: err})
+			d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+			d.setState(DownloadPaused)
+			return err
+		}
+	}
+	err := d.openStorage()
+	if err != nil {
+		d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+		d.err = err
+		d.setState(DownloadError)
+		return err
+	}
+	d.restorePartialPieces()
+	// files that completed while their rename couldn't happen, or before there was a suffix,
+	// and files written to since their modification time was set
+	d.finishFiles(0, d.Torrent.NumPieces()-1)
+	if d.announcer == nil {
+		d.announcer = NewTorrentAnnouncer(d.Torrent, d.PeerID, d.dialer.announcePort(d.Port))
+		d.announcer.SetTrackerID(d.trackerID)
+	}
+	d.err = nil
+	d.seedLimitHit = false
+	d.storagePause = false
+	if d.complete() {
+		d.setState(DownloadSeeding)
+		d.closeDone()
+	} else {
+		d.setState(DownloadDownloading)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.runCtx = ctx
+	d.cancel = cancel
+	d.wg.Add(1)
+	go d.maintain(ctx)
+	// trackerless torrents find their peers on the DHT alone
+	if d.Torrent.Announce != "" {
+		d.wg.Add(1)
+		go d.announceLoop(ctx)
+	}
+	// finished before the complete directory was set, or before a move got done
+	d.moveWhenComplete()
+	if d.usesDHT() {
+		d.wg.Add(1)
+		go d.dhtLoop(ctx, d.dht)
+	}
+	for _, seedURL := range d.Torrent.HTTPSeeds {
+		d.wg.Add(1)
+		go d.runHTTPSeed(ctx, newHTTPSeed(seedURL))
+	}
+	return nil
+}
+
+// Pause disconnects every peer and stops transferring, and tells the tracker we left.
+// Verified pieces and the blocks of pieces still in progress are kept, Resume picks up
+// where the download left off. The files stay open
+func (d *Download) Pause() error {
+	return d.pause(DownloadPaused)
+}
+
+// this function pauses the download, leaving it in state
+func (d *Download) pause(state DownloadState) error {
+	d.halt(state)
+	d.mu.Lock()
+	writer := d.writer
+	d.mu.Unlock()
+	var err error
+	if writer != nil {
+		err = writer.flush()
+	}
+	resumeErr := d.autosaveResume()
+	d.mu.Lock()
+	announceStopped := d.takeAnnouncer()
+	d.mu.Unlock()
+	announceStopped(context.Background())
+	if err != nil {
+		return err
+	}
+	return resumeErr
+}
+
+// Resume restarts a paused download, announcing to the tracker again
+func (d *Download) Resume() error {
+	if d.State() != DownloadPaused {
+		return errors.New("download is not paused")
+	}
+	return d.Start()
+}
+
+// Stop disconnects every peer, tells the tracker we left and closes the files. Blocks of
+// pieces still in progress are dropped
+func (d *Download) Stop() error {
+	return d.stop(context.Background())
+}
+
+// this function stops the download, the stopped announce gives up when ctx is done
+func (d *Download) stop(ctx context.Context) error {
+	d.halt(DownloadStopped)
+	// a rename can still be going on, its storage is closed here once it reopened it
+	d.relocateMu.Lock()
+	defer d.relocateMu.Unlock()
+	resumeErr := d.autosaveResume()
+	d.mu.Lock()
+	announceStopped := d.takeAnnouncer()
+	d.mu.Unlock()
+	announceStopped(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for index := range d.active {
+		d.picker.Abort(index)
+	}
+	clear(d.active)
+	d.cache.invalidate(d.InfoHash)
+	if d.storage == nil {
+		return resumeErr
+	}
+	err := d.writer.close()
+	d.writer = nil
+	closeErr := d.storage.Close()
+	d.storage = nil
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return resumeErr
+}
+
+// this function opens the download's storage and starts its writer, unless that was done
+// already. the caller must hold d.mu
+func (d *Download) openStorage() error {
+	if d.storage != nil {
+		return nil
+	}
+	if d.moving {
+		return errors.New("files are being moved")
+	}
+	storage, err := d.backend.OpenTorrent(d.fileLayout(), d.Dir)
+	if err != nil {
+		return err
+	}
+	d.storage = storage
+	d.writer = newDiskWriter(storage, d.syncPolicy)
+	return nil
+}
+
+// this function drops the announcer and returns what sends its stopped announce, for the
+// caller to run once it let go of d.mu. The next Start makes a new announcer that announces
+// started again, after the stopped announce is done. the caller must hold d.mu
+func (d *Download) takeAnnouncer() func(ctx context.Context) {
+	a := d.announcer
+	if a == nil {
+		return func(context.Context) {}
+	}
+	d.announcer = nil
+	d.trackerID = a.TrackerID()
+	// nothing to tell the tracker if it never heard from us
+	if a.pendingEvent() == "started" {
+		return func(context.Context) {}
+	}
+	a.SetEvent("stopped")
+	a.SetProgress(d.uploaded, d.downloaded, d.left())
+	stopping := make(chan struct{})
+	d.stopping = stopping
+	return func(ctx context.Context) {
+		defer close(stopping)
+		ctx, cancel := context.WithTimeout(ctx, stoppedAnnounceTimeout)
+		defer cancel()
+		_, _ = a.Announce(ctx)
+	}
+}
+
+// this function stops every goroutine of the download and leaves it in state
+func (d *Download) halt(state DownloadState) {
+	d.mu.Lock()
+	cancel := d.cancel
+	d.cancel = nil
+	peers := d.peerList()
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	for _, p := range peers {
+		p.Close()
+	}
+	d.wg.Wait()
+
+	// dropping the peers handed their requested blocks back, the blocks already received
+	// stay with their pieces
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state != DownloadError {
+		d.setState(state)
+	}
+}
+
+// this function puts the download into the error state and stops it
+func (d *Download) fail(err error) {
+	d.mu.Lock()
+	d.err = err
+	d.setState(DownloadError)
+	cancel := d.cancel
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// this function reports whether every wanted piece is verified. the caller must hold d.mu
+func (d *Download) complete() bool {
+	return d.picker.Done()
+}
+
+// this function closes the done channel unless it is closed already. the caller must hold d.mu
+func (d *Download) closeDone() {
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+}
+
+// this function returns the number of bytes of wanted pieces we still need. the caller
+// must hold d.mu
+func (d *Download) left() int64 {
+	var left int64
+	for i := 0; i < d.Torrent.NumPieces(); i++ {
+		if d.picker.Wants(i) {
+			left += int64(d.Torrent.PieceSize(i))
+		}
+	}
+	return left
+}
+
+func (d *Download) announceLoop(ctx context.Context) {
+	defer d.wg.Done()
+	d.mu.Lock()
+	stopping := d.stopping
+	d.mu.Unlock()
+	if stopping != nil {
+		select {
+		case <-stopping:
+		case <-ctx.Done():
+			return
+		}
+	}
+	for {
+		d.mu.Lock()
+		d.announcer.SetProgress(d.uploaded, d.downloaded, d.left())
+		if d.nat.External.IsValid() {
+			d.announcer.SetExternalIP(d.nat.External.Addr().String())
+		}
+		announcer := d.announcer
+		d.mu.Unlock()
+
+		wait := minAnnounceInterval
+		res, err := announcer.Announce(ctx)
+		switch {
+		case err == nil:
+			wait = res.Interval
+			d.mu.Lock()
+			d.trackerSeeds, d.trackerLeechers = res.Seeders, res.Leechers
+			d.mu.Unlock()
+			d.log(LogTracker).Debug("announced", "tracker", announcer.URL(), "peers", len(res.Peers), "interval", res.Interval)
+			d.addPeers(PeerSourceTracker, res.Peers...)
+			d.connectPeers(ctx)
+		case ctx.Err() == nil:
+			d.mu.Lock()
+			d.announceErrors++
+			d.mu.Unlock()
+			d.emit(Event{Type: EventTrackerError, Err: err})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-d.announceWake:
+		}
+	}
+}
+
+// this function runs the periodic work: flushing haves, checking for snubbing peers,
+// dialing new peers and rechoking
+func (d *Download) maintain(ctx context.Context) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(downloadTick)
+	defer ticker.Stop()
+	lastRechoke := time.Time{}
+	lastSave := time.Now()
+	lastSpaceCheck := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			peers := d.Peers()
+			for _, p := range peers {
+				_ = p.FlushHaves()
+				p.CheckSnubbed(defaultSnubTimeout)
+				d.expireRequests(p, now)
+			}
+			if now.Sub(lastRechoke) >= RechokeInterval {
+				lastRechoke = now
+				d.mu.Lock()
+				// only this loop touches the choker
+				d.unchoke.apply(d.choker)
+				d.choker.SlotCap = 0
+				if d.conns != nil {
+					d.choker.SlotCap = d.conns.UnchokeSlots(d.InfoHash)
+				}
+				d.mu.Unlock()
+				d.choker.Rechoke(peers, d.State() == DownloadSeeding)
+			}
+			d.connectPeers(ctx)
+			d.checkSeedLimits()
+			if now.Sub(lastSave) >= resumeSaveInterval {
+				lastSave = now
+				_ = d.autosaveResume()
+			}
+			if now.Sub(lastSpaceCheck) >= diskSpaceCheckInterval {
+				lastSpaceCheck = now
+				d.watchDiskSpace()
+			}
+		}
+	}
+}
+
+// this function dials known peers until MaxPeers connections are open or being opened
+func (d *Download) connectPeers(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state == DownloadSeeding {
+		// seeds don't go looking for peers, leechers find us
+		return
+	}
+	slots := d.MaxPeers - len(d.peers) - d.peerStore.connecting()
+	if slots <= 0 {
+		return
+	}
+	for _, e := range d.peerStore.candidates(slots, d.reconnect.CanDial) {
+		d.wg.Add(1)
+		go d.connect(ctx, d.dialer, e.addr, e.source)
+	}
+}
+
+func (d *Download) connect(ctx context.Context, dialer *PeerDialer, addr string, source PeerSource) {
+	defer d.wg.Done()
+	p, err := DialPeer(ctx, dialer, addr, d.InfoHash, d.PeerID)
+	d.reconnect.RecordDialResult(addr, err)
+	d.peerStore.dialed(addr, err)
+	if err != nil {
+		return
+	}
+	p.Source = source
+	d.runPeer(ctx, p)
+	d.peerStore.disconnected(addr, p.Stats().Downloaded)
+	if ctx.Err() == nil {
+		// hanging up ourselves on pause or stop shouldn't hold off the next Start
+		d.reconnect.Disconnected(addr)
+	}
+}
+
+// this function serves one peer connection until it closes or the download stops
+func (d *Download) runPeer(ctx context.Context, p *Peer) {
+	d.mu.Lock()
+	if ctx.Err() != nil {
+		d.mu.Unlock()
+		p.Close()
+		return
+	}
+	conns := d.conns
+	if conns != nil {
+		conns.SetTorrentState(d.InfoHash, d.Torrent.NumPieces(), d.state == DownloadSeeding)
+		if conns.Admit(d.InfoHash, p) != nil {
+			d.mu.Unlock()
+			p.Close()
+			return
+		}
+		defer conns.Remove(p)
+	}
+	d.peers[p] = true
+	have := append(Bitfield(nil), d.have...)
+	attachLimiters(p, d.downloadLimit, d.uploadLimit)
+	if d.limits != nil {
+		d.limits.attach(p)
+	}
+	d.mu.Unlock()
+	d.emit(Event{Type: EventPeerConnected, Peer: p.Addr})
+	stop := context.AfterFunc(ctx, func() { p.Close() })
+	defer stop()
+	defer d.dropPeer(p)
+
+	numPieces := d.Torrent.NumPieces()
+	p.SetNumPieces(numPieces)
+	if p.SupportsExtensions() {
+		_ = p.SendExtendedHandshake(len(d.Torrent.infoBytes))
+	}
+	switch {
+	case p.SupportsFast() && have.Count() == numPieces:
+		_ = p.SendHaveAll()
+	case p.SupportsFast() && have.Count() == 0:
+		_ = p.SendHaveNone()
+	default:
+		_ = p.SendBitfield(have)
+	}
+	_ = p.GrantAllowedFast(d.InfoHash, numPieces, defaultAllowedFastSetSize)
+
+	counted := false
+	for {
+		msg, err := p.ReadMessage()
+		if err != nil {
+			d.log(LogPeer).Debug("peer disconnected", "peer", p.Addr, "err", err)
+			return
+		}
+		if msg == nil {
+			continue
+		}
+		switch msg.ID {
+		case MsgBitfield, MsgHaveAll:
+			if !counted {
+				counted = true
+				d.picker.PeerAdded(p.Bitfield())
+			}
+			d.updateInterest(p)
+		case MsgHave:
+			index, _ := ParseHave(msg)
+			if counted {
+				d.picker.PeerHave(index)
+			} else {
+				counted = true
+				d.picker.PeerAdded(p.Bitfield())
+			}
+			d.updateInterest(p)
+		case MsgHaveNone:
+			counted = true
+		case MsgChoke:
+			if !p.SupportsFast() {
+				d.releasePeer(p)
+			}
+		case MsgReject:
+			index, begin, length, err := ParseRequest(&Message{ID: MsgRequest, Payload: msg.Payload})
+			if err == nil {
+				d.releaseBlock(p, blockRequest{Index: index, Begin: begin, Length: length})
+			}
+		case MsgPiece:
+			err = d.receiveBlock(p, msg)
+		case MsgRequest:
+			err = d.serveRequest(p, msg)
+		case MsgExtended:
+			err = d.handleExtended(p, msg)
+		}
+		msg.Release()
+		if err != nil {
+			return
+		}
+		d.fillRequests(p)
+	}
+}
+
+// this function forgets a disconnected peer and hands its requested blocks back
+func (d *Download) dropPeer(p *Peer) {
+	p.Close()
+	d.picker.PeerRemoved(p.Bitfield())
+	d.releasePeer(p)
+	d.mu.Lock()
+	delete(d.peers, p)
+	d.mu.Unlock()
+}
+
+// this function tells the peer whether it has anything we still want
+func (d *Download) updateInterest(p *Peer) {
+	bf := p.Bitfield()
+	wanted := false
+	for i := 0; i < d.Torrent.NumPieces(); i++ {
+		if bf.HasPiece(i) && d.picker.Wants(i) {
+			wanted = true
+			break
+		}
+	}
+	switch {
+	case wanted && !p.AmInterested():
+		_ = p.SendInterested()
+	case !wanted && p.AmInterested():
+		_ = p.SendNotInterested()
+	}
+}
+
+// this function keeps the peer's request pipeline full
+func (d *Download) fillRequests(p *Peer) {
+	d.mu.Lock()
+	queueTime := d.requestQueueTime
+	d.mu.Unlock()
+	limit := p.requestLimit(p.requestDepth(queueTime))
+	for p.PendingRequests() < limit {
+		req, ok := d.nextRequest(p)
+		if !ok {
+			return
+		}
+		if p.SendRequest(req.Index, req.Begin, req.Length) != nil {
+			return
+		}
+	}
+}
+
+// this function picks the next block to request from p. pieces are kept with one peer where
+// possible, so each piece arrives in order from a single source instead of a few blocks from
+// everyone. in order p gets: the next block of a piece it owns, of a piece nobody owns
+// anymore, of a new piece from the picker, of a piece another peer owns, and in endgame
+// blocks that are already requested from someone else
+func (d *Download) nextRequest(p *Peer) (blockRequest, bool) {
+	bf := p.Bitfield()
+	choked := p.PeerChoking()
+	if !p.CanRequest(false) && !choked {
+		// snubbed, only worth asking in endgame
+		return d.endgameRequest(p, bf)
+	}
+	bf = d.withoutSuspectPieces(p, bf)
+	usable := func(index int) bool {
+		return bf.HasPiece(index) && (!choked || p.CanRequestPiece(index))
+	}
+
+	if req, ok := d.activeRequest(p, usable, func(owner *Peer) bool { return owner == p }); ok {
+		return req, true
+	}
+	if req, ok := d.activeRequest(p, usable, func(owner *Peer) bool { return owner == nil }); ok {
+		return req, true
+	}
+
+	pickable := bf
+	if choked {
+		pickable = NewBitfield(d.Torrent.NumPieces())
+		for _, index := range p.AllowedFast() {
+			if bf.HasPiece(index) {
+				pickable.SetPiece(index)
+			}
+		}
+	}
+	copied := choked
+	for {
+		index, ok := d.picker.Pick(pickable)
+		if !ok {
+			break
+		}
+		if req, ok := d.pickedRequest(p, index); ok {
+			return req, true
+		}
+		// an overdue deadline piece p already asked for every block of, pick again without it
+		if !copied {
+			pickable = append(Bitfield(nil), pickable...)
+			copied = true
+		}
+		pickable.ClearPiece(index)
+	}
+	if req, ok := d.activeRequest(p, usable, func(*Peer) bool { return true }); ok {
+		return req, true
+	}
+	if choked {
+		return blockRequest{}, false
+	}
+	return d.endgameRequest(p, bf)
+}
+
+// this function returns a block of the piece the picker handed out, starting the piece if
+// it isn't active yet. an overdue deadline piece is handed out again while it's in progress
+// and may have no block left that p hasn't asked for
+func (d *Download) pickedRequest(p *Peer, index int) (blockRequest, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ap, ok := d.active[index]
+	if !ok {
+		ap = newActivePiece(index, d.Torrent.PieceSize(index))
+		ap.owner = p
+		d.active[index] = ap
+	}
+	for i := range ap.received {
+		if !ap.received[i] && !ap.requested[i][p] {
+			ap.requested[i][p] = true
+			return ap.block(i), true
+		}
+	}
+	return blockRequest{}, false
+}
+
+// this function returns the first unrequested block of an active piece p can request and
+// whose owner matches. blocks are handed out in order so a piece arrives front to back
+func (d *Download) activeRequest(p *Peer, usable func(int) bool, owner func(*Peer) bool) (blockRequest, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for index, ap := range d.active {
+		if !usable(index) || !owner(ap.owner) {
+			continue
+		}
+		for i := range ap.received {
+			if !ap.received[i] && len(ap.requested[i]) == 0 {
+				ap.requested[i][p] = true
+				return ap.block(i), true
+			}
+		}
+	}
+	return blockRequest{}, false
+}
+
+// this function returns a block that is requested from another peer but not from p, once
+// the picker has nothing left to hand out the last blocks are raced between peers
+func (d *Download) endgameRequest(p *Peer, bf Bitfield) (blockRequest, bool) {
+	if d.picker.Remaining() > 0 || p.PeerChoking() {
+		return blockRequest{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for index, ap := range d.active {
+		if !bf.HasPiece(index) {
+			continue
+		}
+		for i := range ap.received {
+			if !ap.received[i] && !ap.requested[i][p] {
+				ap.requested[i][p] = true
+				return ap.block(i), true
+			}
+		}
+	}
+	return blockRequest{}, false
+}
+
+// this function cancels the peer's timed out requests and hands their blocks to other
+// peers, which get their pipelines topped up right away
+func (d *Download) expireRequests(p *Peer, now time.Time) {
+	expired := p.timedOutRequests(now)
+	if len(expired) == 0 {
+		return
+	}
+	for _, req := range expired {
+		d.releaseBlock(p, req)
+		_ = p.Send(FormatCancel(req.Index, req.Begin, req.Length))
+	}
+	for _, other := range d.Peers() {
+		if other != p {
+			d.fillRequests(other)
+		}
+	}
+}
+
+// this function hands every block requested from p back to the pool
+func (d *Download) releasePeer(p *Peer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ap := range d.active {
+		for i := range ap.requested {
+			delete(ap.requested[i], p)
+		}
+		if ap.owner == p {
+			ap.owner = nil
+		}
+	}
+}
+
+func (d *Download) releaseBlock(p *Peer, req blockRequest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ap, ok := d.active[req.Index]
+	if !ok || req.Begin%BlockSize != 0 || req.Begin/BlockSize >= len(ap.requested) {
+		return
+	}
+	delete(ap.requested[req.Begin/BlockSize], p)
+	// a peer that timed out on or rejected a block of its piece gives the piece up
+	if ap.owner == p {
+		ap.owner = nil
+	}
+}
+
+// this function stores a received block, and verifies and writes the piece once it is complete
+func (d *Download) receiveBlock(p *Peer, msg *Message) error {
+	index, begin, block, err := ParsePiece(msg)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.downloaded += int64(len(block))
+	d.countTransfer(int64(len(block)), 0)
+	ap, ok := d.active[index]
+	if !ok || begin%BlockSize != 0 || begin/BlockSize >= len(ap.received) {
+		// a block we didn't ask for or that raced in after the piece was done
+		d.wasted += int64(len(block))
+		d.mu.Unlock()
+		return nil
+	}
+	i := begin / BlockSize
+	if ap.received[i] || len(block) != ap.block(i).Length {
+		d.wasted += int64(len(block))
+		d.mu.Unlock()
+		return nil
+	}
+	copy(ap.data[begin:], block)
+	ap.received[i] = true
+	ap.remaining--
+	// cancel the duplicates still requested from other peers in endgame
+	var cancels []*Peer
+	for other := range ap.requested[i] {
+		if other != p {
+			cancels = append(cancels, other)
+		}
+	}
+	clear(ap.requested[i])
+	finished := ap.remaining == 0
+	if finished {
+		delete(d.active, index)
+	}
+	d.mu.Unlock()
+
+	d.attribution.addBlock(index, begin, p)
+	for _, other := range cancels {
+		_ = other.SendCancel(index, begin, len(block))
+	}
+	if finished {
+		d.verifyPiece(ap)
+	}
+	return nil
+}
+
+// this function hands a completed piece to the hasher, finishPiece runs with the result.
+// the peer's read loop only waits here when the hasher's queue is full
+func (d *Download) verifyPiece(ap *activePiece) {
+	d.mu.Lock()
+	ctx := d.runCtx
+	hasher := d.hasher
+	d.mu.Unlock()
+	// counted like a peer goroutine so halt waits for the piece to be written out
+	d.wg.Add(1)
+	err := hasher.SubmitPiece(ctx, d.Torrent, ap.index, ap.data, func(verified bool) {
+		defer d.wg.Done()
+		d.finishPiece(ap, verified)
+	})
+	if err != nil {
+		// the download is stopping, the piece is downloaded again next time
+		d.wg.Done()
+		d.picker.Abort(ap.index)
+	}
+}
+
+// this function writes out a hashed piece, or puts it back in the picker when the hash
+// didn't match
+func (d *Download) finishPiece(ap *activePiece, verified bool) {
+	sources := d.attribution.blockSources(ap.index)
+	for _, banned := range d.attribution.resolve(ap.index, verified, d.bans) {
+		banned.Close()
+		d.emit(Event{Type: EventPeerBanned, Peer: banned.Addr})
+	}
+	if !verified {
+		d.mu.Lock()
+		d.wasted += int64(len(ap.data))
+		d.hashFailures++
+		d.quarantinePiece(ap, sources)
+		d.mu.Unlock()
+		d.picker.Abort(ap.index)
+		d.emit(Event{Type: EventHashFailed, Piece: ap.index})
+		return
+	}
+
+	d.writePiece(ap)
+}
+
+// this function queues a verified piece to be written out
+func (d *Download) writePiece(ap *activePiece) {
+	d.mu.Lock()
+	writer := d.writer
+	d.mu.Unlock()
+	if writer == nil {
+		// storage is closed while the files are moved, the piece is downloaded again
+		d.picker.Abort(ap.index)
+		return
+	}
+	// counted so halt waits for the write, and the piece isn't lost between queue and disk
+	d.wg.Add(1)
+	err := writer.write(ap.index, ap.data, func(err error) {
+		defer d.wg.Done()
+		d.pieceWritten(ap, err)
+	})
+	if err != nil {
+		d.wg.Done()
+		d.picker.Abort(ap.index)
+	}
+}
+
+// this function marks a piece as ours once the writer wrote it out, and tells the peers.
+// it runs on the writer goroutine
+func (d *Download) pieceWritten(ap *activePiece, err error) {
+	if err != nil {
+		ap.writeFailures++
+		err = fmt.Errorf("writing piece %d: %w", ap.index, err)
+		// the piece stays picked while it is written again
+		if !d.storageFailed(err, ap.index, ap.writeFailures, func() { d.writePiece(ap) }) {
+			d.picker.Abort(ap.index)
+		}
+		return
+	}
+	d.picker.Complete(ap.index)
+	// a piece we just got is one the other leechers are about to ask for
+	d.readCache().put(d.InfoHash, ap.index, ap.data)
+
+	d.mu.Lock()
+	d.have.SetPiece(ap.index)
+	d.releaseQuarantine(ap.index)
+	d.emit(Event{Type: EventPieceCompleted, Piece: ap.index})
+	d.emitFilesCompleted(ap.index)
+	d.finishFiles(ap.index, ap.index)
+	d.wakeReaders()
+	peers := d.peerList()
+	completed := d.complete() && d.state == DownloadDownloading
+	if completed {
+		d.announcer.SetEvent("completed")
+		select {
+		case d.announceWake <- struct{}{}:
+		default:
+		}
+		d.closeDone()
+		d.emit(Event{Type: EventTorrentCompleted})
+		d.setState(DownloadSeeding)
+	}
+	writer := d.writer
+	d.mu.Unlock()
+
+	if completed {
+		// we're on the writer goroutine, so everything before this piece is written already
+		err = writer.sync()
+		if err != nil {
+			d.storageFailed(fmt.Errorf("syncing data: %w", err), -1, 1, nil)
+		} else {
+			d.mu.Lock()
+			d.moveWhenComplete()
+			d.mu.Unlock()
+		}
+	}
+	for _, p := range peers {
+		_ = p.QueueHave(ap.index)
+		d.updateInterest(p)
+	}
+}
+
+// this function answers a block request from the peer
+func (d *Download) serveRequest(p *Peer, msg *Message) error {
+	index, begin, length, err := ParseRequest(msg)
+	if err != nil {
+		return err
+	}
+	if p.AmChoking() && !p.IsGrantedFast(index) {
+		if p.SupportsFast() {
+			return p.SendReject(index, begin, length)
+		}
+		return nil
+	}
+	d.mu.Lock()
+	storage := d.storage
+	ok := d.have.HasPiece(index) && storage != nil
+	d.mu.Unlock()
+	if !ok || length <= 0 || length > maxServedBlock || begin < 0 || begin+length > d.Torrent.PieceSize(index) {
+		if p.SupportsFast() {
+			return p.SendReject(index, begin, length)
+		}
+		return nil
+	}
+
+	block, err := d.readBlock(storage, index, begin, length)
+	if err != nil {
+		return err
+	}
+	err = p.SendPiece(index, begin, block)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.uploaded += int64(length)
+	d.lastUpload = time.Now()
+	d.countTransfer(0, int64(length))
+	d.mu.Unlock()
+	return nil
+}
+
+// this function feeds transferred bytes into the download's and the session's rates. the
+// caller must hold d.mu
+func (d *Download) countTransfer(down, up int64) {
+	now := time.Now()
+	for _, r := range []*TransferRates{d.rates, d.session} {
+		if r == nil {
+			continue
+		}
+		if down > 0 {
+			r.addDownloaded(down, now)
+		}
+		if up > 0 {
+			r.addUploaded(up, now)
+		}
+	}
+}
+
+// DownloadRate returns the download's smoothed download rate in bytes per second
+func (d *Download) DownloadRate() float64 {
+	return d.rates.DownloadRate()
+}
+
+// UploadRate returns the download's smoothed upload rate in bytes per second
+func (d *Download) UploadRate() float64 {
+	return d.rates.UploadRate()
+}
+
+// SetSessionLimiter puts the download's peers under limits shared with other downloads,
+// it applies to peers that connect from now on
+func (d *Download) SetSessionLimiter(l *SessionLimiter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.limits = l
+}
+
+// SetSessionRates makes the download count its transfers towards session wide rates too
+func (d *Download) SetSessionRates(r *TransferRates) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.session = r
+}
+
+// AcceptPeer hands the download an incoming connection whose handshake has been read
+// already, our half of the handshake is sent here
+func (d *Download) AcceptPeer(conn net.Conn, h *Handshake) error {
+	if d.bans.IsBanned(conn.RemoteAddr().String()) {
+		conn.Close()
+		return errors.New("peer is banned")
+	}
+	d.mu.Lock()
+	running := d.cancel != nil
+	proxied := !d.dialer.AcceptsIncoming()
+	d.mu.Unlock()
+	if !running || h.InfoHash != d.InfoHash {
+		conn.Close()
+		return errors.New("download is not running")
+	}
+	if proxied {
+		conn.Close()
+		return errors.New("download connects to peers through a proxy")
+	}
+	res := Handshake{InfoHash: d.InfoHash, PeerID: d.PeerID}
+	res.Reserved[reservedFastByte] |= reservedFastBit
+	res.Reserved[reservedExtensionByte] |= reservedExtensionBit
+	conn.SetWriteDeadline(time.Now().Add(defaultHandshakeTimeout))
+	_, err := conn.Write(res.Serialize())
+	conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p := newPeer(conn, h)
+	p.Source = PeerSourceIncoming
+	if _, ok := unwrapConn(conn).(*utpConn); ok {
+		p.Transport = TransportUTP
+	}
+	d.peerStore.accepted(p.Addr)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		p.Close()
+		return errors.New("download is not running")
+	}
+	// added under d.mu so halt, which clears d.cancel first, never waits on a goroutine it missed
+	ctx := d.runCtx
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.runPeer(ctx, p)
+		d.peerStore.disconnected(p.Addr, p.Stats().Downloaded)
+	}()
+	return nil
+}
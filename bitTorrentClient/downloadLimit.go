@@ -0,0 +1,65 @@
+// This file implements per download rate limits. They sit between the per peer limits and
+// the session limits: every byte a peer moves has to pass all three, so a download never goes
+// faster than its own cap, nor faster than what the session limiter grants it
+package bittorrentclient
+
+func newDownloadLimiters() (upload, download *RateLimiter) {
+	upload = NewRateLimiter(0)
+	download = NewRateLimiter(0)
+	// charged like the session limiters so both limits measure the same thing
+	upload.overhead = true
+	download.overhead = true
+	return upload, download
+}
+
+// SetUploadLimit caps how fast the download sends to all of its peers together in bytes per
+// second, zero removes the cap. It can be changed while the download is running
+func (d *Download) SetUploadLimit(bytesPerSec int64) {
+	d.uploadLimit.SetRate(bytesPerSec)
+}
+
+// SetDownloadLimit caps how fast the download receives from all of its peers together in
+// bytes per second, zero removes the cap. It can be changed while the download is running
+func (d *Download) SetDownloadLimit(bytesPerSec int64) {
+	d.downloadLimit.SetRate(bytesPerSec)
+}
+
+func (d *Download) UploadLimit() int64 {
+	return d.uploadLimit.Rate()
+}
+
+func (d *Download) DownloadLimit() int64 {
+	return d.downloadLimit.Rate()
+}
+
+// EffectiveUploadLimit returns the upload cap that actually applies, the lower of the
+// download's own and the session's, zero if neither is set
+func (d *Download) EffectiveUploadLimit() int64 {
+	d.mu.Lock()
+	limits := d.limits
+	d.mu.Unlock()
+	if limits == nil {
+		return d.UploadLimit()
+	}
+	return minLimit(d.UploadLimit(), limits.UploadLimit())
+}
+
+// EffectiveDownloadLimit returns the download cap that actually applies, the lower of the
+// download's own and the session's, zero if neither is set
+func (d *Download) EffectiveDownloadLimit() int64 {
+	d.mu.Lock()
+	limits := d.limits
+	d.mu.Unlock()
+	if limits == nil {
+		return d.DownloadLimit()
+	}
+	return minLimit(d.DownloadLimit(), limits.DownloadLimit())
+}
+
+// this function returns the stricter of two limits where zero means unlimited
+func minLimit(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
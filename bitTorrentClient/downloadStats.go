@@ -0,0 +1,101 @@
+// This file puts together a snapshot of a download's statistics, everything a frontend
+// shows for a torrent in one call instead of a dozen getters that may each see a
+// different moment
+package bittorrentclient
+
+import "time"
+
+type DownloadStats struct {
+	State DownloadState
+	Err   error
+
+	Downloaded int64
+	Uploaded   int64
+	// Wasted is the part of Downloaded that was thrown away, duplicate blocks and pieces
+	// that failed their hash check
+	Wasted int64
+	// HashFailures counts the pieces that failed their hash check
+	HashFailures int
+	DownloadRate float64
+	UploadRate   float64
+	// Left is the number of bytes of wanted pieces still missing
+	Left int64
+	// ETA estimates the time until Left is downloaded at the current rate, it is -1 when
+	// nothing is coming in
+	ETA time.Duration
+
+	// Peers is the number of connected peers, Seeds and Leechers split them by whether
+	// they have the whole torrent. KnownPeers counts every address we know of
+	Peers      int
+	Seeds      int
+	Leechers   int
+	KnownPeers int
+	// TrackerSeeds and TrackerLeechers are the swarm size the tracker last reported
+	TrackerSeeds    int
+	TrackerLeechers int
+	// DHTSeeds and DHTLeechers are the swarm size the last DHT scrape estimated, for
+	// trackerless torrents the only numbers there are
+	DHTSeeds    int
+	DHTLeechers int
+	// Tracker is the announce url of the tracker in use, empty while stopped
+	Tracker string
+	// AnnounceErrors counts the tracker announces that failed
+	AnnounceErrors int
+
+	PiecesHave  int
+	PiecesTotal int
+	// Availability is the number of full copies among the connected peers, see
+	// Picker.DistributedCopies
+	Availability float64
+}
+
+// Stats returns a snapshot of the download's statistics
+func (d *Download) Stats() DownloadStats {
+	numPieces := d.Torrent.NumPieces()
+	rate := d.DownloadRate()
+
+	d.mu.Lock()
+	s := DownloadStats{
+		State:           d.state,
+		Err:             d.err,
+		Downloaded:      d.downloaded,
+		Uploaded:        d.uploaded,
+		Wasted:          d.wasted,
+		HashFailures:    d.hashFailures,
+		AnnounceErrors:  d.announceErrors,
+		DownloadRate:    rate,
+		UploadRate:      d.UploadRate(),
+		Left:            d.left(),
+		Peers:           len(d.peers),
+		KnownPeers:      d.peerStore.Len(),
+		TrackerSeeds:    d.trackerSeeds,
+		TrackerLeechers: d.trackerLeechers,
+		DHTSeeds:        d.dhtSeeds,
+		DHTLeechers:     d.dhtLeechers,
+		PiecesHave:      d.have.Count(),
+		PiecesTotal:     numPieces,
+		Availability:    d.picker.DistributedCopies(),
+	}
+	if d.announcer != nil {
+		s.Tracker = d.announcer.URL()
+	}
+	peers := d.peerList()
+	d.mu.Unlock()
+
+	for _, p := range peers {
+		if p.Bitfield().Count() == numPieces {
+			s.Seeds++
+		} else {
+			s.Leechers++
+		}
+	}
+	switch {
+	case s.Left == 0:
+		s.ETA = 0
+	case rate > 0:
+		s.ETA = time.Duration(float64(s.Left) / rate * float64(time.Second))
+	default:
+		s.ETA = -1
+	}
+	return s
+}
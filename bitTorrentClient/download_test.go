@@ -0,0 +1,97 @@
+package bittorrentclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+func TestStoppedAnnounceOutsideLock(t *testing.T) {
+	events := make(chan string, 10)
+	release := make(chan struct{})
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := r.URL.Query().Get("event")
+		events <- event
+		if event == "stopped" {
+			<-release
+		}
+		data, _ := bencode.Encode(map[string]interface{}{"interval": int64(60), "peers": ""})
+		w.Write(data)
+	}))
+	defer tracker.Close()
+	defer close(release)
+
+	d, err := NewDownload(testTorrent(t, tracker.URL+"/announce"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetDHT(nil)
+	next := func() string {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no announce")
+			return ""
+		}
+	}
+
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := next(); event != "started" {
+		t.Fatalf("first announce is %q, want started", event)
+	}
+	// a pause while it is out cancels it, and the tracker never heard from us
+	for {
+		d.mu.Lock()
+		sent := d.announcer.pendingEvent() == ""
+		d.mu.Unlock()
+		if sent {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	paused := make(chan error, 1)
+	go func() { paused <- d.Pause() }()
+	if event := next(); event != "stopped" {
+		t.Fatalf("announce on pause is %q, want stopped", event)
+	}
+
+	// the tracker holds on to the stopped announce, the download isn't locked meanwhile
+	resumed := make(chan error, 1)
+	go func() {
+		d.State()
+		resumed <- d.Resume()
+	}()
+	select {
+	case err := <-resumed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Resume blocked on the stopped announce")
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("announced %q before the stopped announce was done", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	release <- struct{}{}
+	if err := <-paused; err != nil {
+		t.Fatal(err)
+	}
+	if event := next(); event != "started" {
+		t.Fatalf("announce on resume is %q, want started", event)
+	}
+	go func() {
+		for range release {
+		}
+	}()
+	d.Stop()
+}
@@ -0,0 +1,430 @@
+// This file implements Message Stream Encryption (MSE, also known as PE), the obfuscation
+// most clients speak to get past ISPs throttling BitTorrent. Both ends agree on a secret
+// with a Diffie-Hellman exchange, prove they know the torrent's infohash without sending
+// it, and settle on RC4 or plaintext for the rest of the connection. The BitTorrent
+// handshake then runs over the result as over any other connection. The policy decides
+// whether we speak it: never, when the peer does too, or always
+package bittorrentclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+)
+
+// EncryptionPolicy decides whether peer connections are encrypted
+type EncryptionPolicy int
+
+const (
+	// EncryptionDisabled makes and accepts plaintext connections only
+	EncryptionDisabled EncryptionPolicy = iota
+	// EncryptionPreferred encrypts where the peer supports it and falls back to plaintext
+	EncryptionPreferred
+	// EncryptionRequired refuses peers that don't encrypt
+	EncryptionRequired
+)
+
+func (p EncryptionPolicy) String() string {
+	switch p {
+	case EncryptionDisabled:
+		return "disabled"
+	case EncryptionPreferred:
+		return "preferred"
+	case EncryptionRequired:
+		return "required"
+	default:
+		return "unknown"
+	}
+}
+
+// this function returns the crypto methods the policy allows on an encrypted handshake
+func (p EncryptionPolicy) cryptoMethods() uint32 {
+	if p == EncryptionRequired {
+		return mseCryptoRC4
+	}
+	return mseCryptoRC4 | mseCryptoPlaintext
+}
+
+// ErrEncryptionHandshake is returned when a peer fails the encryption handshake
+var ErrEncryptionHandshake = errors.New("encryption handshake failed")
+
+const (
+	// the public keys are 768 bits, sent as 96 bytes
+	mseKeyLength = 96
+	// random padding follows the keys and the headers, at most this much
+	mseMaxPad = 512
+	// the RC4 keystreams start after this many discarded bytes
+	mseDiscard = 1024
+
+	mseCryptoPlaintext = 0x01
+	mseCryptoRC4       = 0x02
+)
+
+var (
+	mseP, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+		"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576"+
+		"625E7EC6F44C42E9A63A36210000000000090563", 16)
+	mseG = big.NewInt(2)
+	// the verification constant, eight zero bytes
+	mseVC = make([]byte, 8)
+	// a plaintext connection starts with the length and name of the protocol
+	plaintextPrefix = append([]byte{byte(len(protocolString))}, protocolString...)
+)
+
+// this function returns the hash of the concatenated parts
+func mseHash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// this function returns a new private key and the public key to send for it
+func mseKeys() (*big.Int, []byte, error) {
+	private := make([]byte, 20)
+	_, err := rand.Read(private)
+	if err != nil {
+		return nil, nil, err
+	}
+	x := new(big.Int).SetBytes(private)
+	public := make([]byte, mseKeyLength)
+	new(big.Int).Exp(mseG, x, mseP).FillBytes(public)
+	return x, public, nil
+}
+
+// this function returns the shared secret of our private key and the peer's public key
+func mseSecret(x *big.Int, public []byte) ([]byte, error) {
+	y := new(big.Int).SetBytes(public)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(mseP) >= 0 {
+		return nil, fmt.Errorf("%w: bad public key", ErrEncryptionHandshake)
+	}
+	s := make([]byte, mseKeyLength)
+	new(big.Int).Exp(y, x, mseP).FillBytes(s)
+	return s, nil
+}
+
+// this function returns the RC4 keystream of a side, past the discarded bytes
+func mseCipher(name string, s, skey []byte) *rc4.Cipher {
+	c, _ := rc4.NewCipher(mseHash([]byte(name), s, skey))
+	discard := make([]byte, mseDiscard)
+	c.XORKeyStream(discard, discard)
+	return c
+}
+
+// this function returns up to mseMaxPad random bytes
+func msePad() ([]byte, error) {
+	var n [2]byte
+	_, err := rand.Read(n[:])
+	if err != nil {
+		return nil, err
+	}
+	pad := make([]byte, int(binary.BigEndian.Uint16(n[:]))%(mseMaxPad+1))
+	_, err = rand.Read(pad)
+	return pad, err
+}
+
+// this function reads until pattern went by, giving up after max bytes
+func mseSync(r *bufio.Reader, pattern []byte, max int) error {
+	window := make([]byte, 0, max)
+	for len(window) < max {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no sync within %d bytes", ErrEncryptionHandshake, max)
+}
+
+// this function reads n bytes and decrypts them with c
+func mseRead(r io.Reader, c *rc4.Cipher, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	c.XORKeyStream(b, b)
+	return b, nil
+}
+
+// this function returns the crypto method chosen out of the offered ones, RC4 first
+func mseSelect(offered, allowed uint32) (uint32, error) {
+	switch both := offered & allowed; {
+	case both&mseCryptoRC4 != 0:
+		return mseCryptoRC4, nil
+	case both&mseCryptoPlaintext != 0:
+		return mseCryptoPlaintext, nil
+	}
+	return 0, fmt.Errorf("%w: no common crypto method in %#x", ErrEncryptionHandshake, offered)
+}
+
+// encryptedConn is a connection after the encryption handshake. With plaintext chosen the
+// ciphers are nil and only the bytes read ahead during the handshake are handed out first
+type encryptedConn struct {
+	net.Conn
+	r *bufio.Reader
+	// initial holds decrypted payload the peer sent along with the handshake
+	initial  []byte
+	enc, dec *rc4.Cipher
+}
+
+func (c *encryptedConn) Read(b []byte) (int, error) {
+	if len(c.initial) > 0 {
+		n := copy(b, c.initial)
+		c.initial = c.initial[n:]
+		return n, nil
+	}
+	n, err := c.r.Read(b)
+	if c.dec != nil {
+		c.dec.XORKeyStream(b[:n], b[:n])
+	}
+	return n, err
+}
+
+func (c *encryptedConn) Write(b []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(b)
+	}
+	// the caller's buffer stays as it was, it may be written again elsewhere
+	out := make([]byte, len(b))
+	c.enc.XORKeyStream(out, b)
+	return c.Conn.Write(out)
+}
+
+// Encrypted reports whether the connection is RC4 encrypted, not just obfuscated during
+// the handshake
+func (c *encryptedConn) Encrypted() bool {
+	return c.enc != nil
+}
+
+// this function returns the connection underneath the encryption, if any
+func unwrapConn(conn net.Conn) net.Conn {
+	if ec, ok := conn.(*encryptedConn); ok {
+		return ec.Conn
+	}
+	return conn
+}
+
+// this function reports whether the traffic of conn is RC4 encrypted
+func connEncrypted(conn net.Conn) bool {
+	ec, ok := conn.(*encryptedConn)
+	return ok && ec.Encrypted()
+}
+
+// this function encrypts a connection we made to a peer of the torrent infoHash as the
+// policy asks, within the deadline of ctx. conn is closed if that fails
+func encryptConn(ctx context.Context, conn net.Conn, infoHash [20]byte, policy EncryptionPolicy) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	encrypted, err := encryptOutgoing(conn, infoHash, policy.cryptoMethods(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return encrypted, nil
+}
+
+// this function runs the handshake as the side that connected, for the torrent skey,
+// offering the methods in provide. initial is sent along encrypted, it may be empty
+func encryptOutgoing(conn net.Conn, skey [20]byte, provide uint32, initial []byte) (net.Conn, error) {
+	x, public, err := mseKeys()
+	if err != nil {
+		return nil, err
+	}
+	pad, err := msePad()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(append(public, pad...))
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	theirs := make([]byte, mseKeyLength)
+	_, err = io.ReadFull(r, theirs)
+	if err != nil {
+		return nil, err
+	}
+	s, err := mseSecret(x, theirs)
+	if err != nil {
+		return nil, err
+	}
+	enc := mseCipher("keyA", s, skey[:])
+	dec := mseCipher("keyB", s, skey[:])
+
+	msg := mseHash([]byte("req1"), s)
+	req2, req3 := mseHash([]byte("req2"), skey[:]), mseHash([]byte("req3"), s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	msg = append(msg, req2...)
+	header := binary.BigEndian.AppendUint32(append([]byte(nil), mseVC...), provide)
+	header = binary.BigEndian.AppendUint16(header, 0)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(initial)))
+	header = append(header, initial...)
+	enc.XORKeyStream(header, header)
+	_, err = conn.Write(append(msg, header...))
+	if err != nil {
+		return nil, err
+	}
+
+	// their reply starts with the verification constant once their padding is over, we
+	// find it by what it looks like encrypted
+	vc := append([]byte(nil), mseVC...)
+	mseCipher("keyB", s, skey[:]).XORKeyStream(vc, vc)
+	err = mseSync(r, vc, mseMaxPad+len(vc))
+	if err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(make([]byte, len(vc)), vc)
+	reply, err := mseRead(r, dec, 6)
+	if err != nil {
+		return nil, err
+	}
+	selected := binary.BigEndian.Uint32(reply)
+	if selected != mseCryptoRC4 && selected != mseCryptoPlaintext || selected&provide == 0 {
+		return nil, fmt.Errorf("%w: peer selected crypto method %#x", ErrEncryptionHandshake, selected)
+	}
+	padLength := int(binary.BigEndian.Uint16(reply[4:]))
+	if padLength > mseMaxPad {
+		return nil, fmt.Errorf("%w: %d bytes of padding", ErrEncryptionHandshake, padLength)
+	}
+	_, err = mseRead(r, dec, padLength)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &encryptedConn{Conn: conn, r: r}
+	if selected == mseCryptoRC4 {
+		c.enc, c.dec = enc, dec
+	}
+	return c, nil
+}
+
+// this function runs the handshake as the side that was connected to, r has the bytes read
+// from conn so far. skey looks up the infohash of one of our torrents by the hash the peer
+// sends of it, allowed are the crypto methods we accept
+func encryptIncoming(conn net.Conn, r *bufio.Reader, skey func(req2 []byte) ([20]byte, bool), allowed uint32) (net.Conn, [20]byte, error) {
+	var infoHash [20]byte
+	theirs := make([]byte, mseKeyLength)
+	_, err := io.ReadFull(r, theirs)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	x, public, err := mseKeys()
+	if err != nil {
+		return nil, infoHash, err
+	}
+	pad, err := msePad()
+	if err != nil {
+		return nil, infoHash, err
+	}
+	_, err = conn.Write(append(public, pad...))
+	if err != nil {
+		return nil, infoHash, err
+	}
+	s, err := mseSecret(x, theirs)
+	if err != nil {
+		return nil, infoHash, err
+	}
+
+	// their padding ends where the hash of the secret begins
+	req1 := mseHash([]byte("req1"), s)
+	err = mseSync(r, req1, mseMaxPad+len(req1))
+	if err != nil {
+		return nil, infoHash, err
+	}
+	req2 := make([]byte, sha1.Size)
+	_, err = io.ReadFull(r, req2)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	req3 := mseHash([]byte("req3"), s)
+	for i := range req2 {
+		req2[i] ^= req3[i]
+	}
+	infoHash, ok := skey(req2)
+	if !ok {
+		return nil, infoHash, fmt.Errorf("%w: peer asked for a torrent we don't have", ErrEncryptionHandshake)
+	}
+	dec := mseCipher("keyA", s, infoHash[:])
+	enc := mseCipher("keyB", s, infoHash[:])
+
+	header, err := mseRead(r, dec, len(mseVC)+6)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	if !bytes.Equal(header[:len(mseVC)], mseVC) {
+		return nil, infoHash, fmt.Errorf("%w: bad verification constant", ErrEncryptionHandshake)
+	}
+	header = header[len(mseVC):]
+	selected, err := mseSelect(binary.BigEndian.Uint32(header), allowed)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	padLength := int(binary.BigEndian.Uint16(header[4:]))
+	if padLength > mseMaxPad {
+		return nil, infoHash, fmt.Errorf("%w: %d bytes of padding", ErrEncryptionHandshake, padLength)
+	}
+	_, err = mseRead(r, dec, padLength)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	length, err := mseRead(r, dec, 2)
+	if err != nil {
+		return nil, infoHash, err
+	}
+	initial, err := mseRead(r, dec, int(binary.BigEndian.Uint16(length)))
+	if err != nil {
+		return nil, infoHash, err
+	}
+
+	reply := binary.BigEndian.AppendUint32(append([]byte(nil), mseVC...), selected)
+	reply = binary.BigEndian.AppendUint16(reply, 0)
+	enc.XORKeyStream(reply, reply)
+	_, err = conn.Write(reply)
+	if err != nil {
+		return nil, infoHash, err
+	}
+
+	c := &encryptedConn{Conn: conn, r: r, initial: initial}
+	if selected == mseCryptoRC4 {
+		c.enc, c.dec = enc, dec
+	}
+	return c, infoHash, nil
+}
+
+// this function looks at the first bytes of an incoming connection and runs the encryption
+// handshake unless they start a plaintext one, which the policy may refuse. infoHash is
+// the torrent an encrypted peer asked for, zero for a plaintext one
+func acceptEncryption(conn net.Conn, policy EncryptionPolicy, skey func(req2 []byte) ([20]byte, bool)) (net.Conn, [20]byte, error) {
+	r := bufio.NewReader(conn)
+	start, err := r.Peek(len(plaintextPrefix))
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	if bytes.Equal(start, plaintextPrefix) {
+		if policy == EncryptionRequired {
+			return nil, [20]byte{}, errors.New("plaintext connection refused, encryption is required")
+		}
+		return &encryptedConn{Conn: conn, r: r}, [20]byte{}, nil
+	}
+	return encryptIncoming(conn, r, skey, policy.cryptoMethods())
+}
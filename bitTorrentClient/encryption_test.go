@@ -0,0 +1,178 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// this function runs both sides of the encryption handshake over a pipe and returns the
+// connections, the receiver knows the torrent infoHash only
+func encryptPipe(t *testing.T, infoHash [20]byte, skey [20]byte, provide uint32, initial []byte) (net.Conn, net.Conn, [20]byte, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	outgoing := make(chan result, 1)
+	go func() {
+		conn, err := encryptOutgoing(a, skey, provide, initial)
+		if err != nil {
+			a.Close()
+		}
+		outgoing <- result{conn, err}
+	}()
+	lookup := func(req2 []byte) ([20]byte, bool) {
+		return infoHash, bytes.Equal(req2, mseHash([]byte("req2"), infoHash[:]))
+	}
+	in, got, err := acceptEncryption(b, EncryptionPreferred, lookup)
+	if err != nil {
+		b.Close()
+	}
+	out := <-outgoing
+	if err == nil {
+		err = out.err
+	}
+	return out.conn, in, got, err
+}
+
+func TestEncryptionHandshake(t *testing.T) {
+	infoHash := [20]byte{1, 2, 3}
+	for _, tc := range []struct {
+		name      string
+		provide   uint32
+		encrypted bool
+	}{
+		{"rc4", mseCryptoRC4 | mseCryptoPlaintext, true},
+		{"plaintext", mseCryptoPlaintext, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, in, got, err := encryptPipe(t, infoHash, infoHash, tc.provide, []byte("initial"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != infoHash {
+				t.Errorf("receiver found torrent %x, want %x", got, infoHash)
+			}
+			if connEncrypted(out) != tc.encrypted || connEncrypted(in) != tc.encrypted {
+				t.Errorf("encrypted %v and %v, want %v", connEncrypted(out), connEncrypted(in), tc.encrypted)
+			}
+
+			// the payload sent with the handshake comes first, then the stream both ways
+			go out.Write([]byte(" outgoing"))
+			buf := make([]byte, len("initial outgoing"))
+			_, err = io.ReadFull(in, buf)
+			if err != nil || string(buf) != "initial outgoing" {
+				t.Errorf("receiver read %q (%v), want %q", buf, err, "initial outgoing")
+			}
+			go in.Write([]byte("incoming"))
+			buf = make([]byte, len("incoming"))
+			_, err = io.ReadFull(out, buf)
+			if err != nil || string(buf) != "incoming" {
+				t.Errorf("initiator read %q (%v), want %q", buf, err, "incoming")
+			}
+		})
+	}
+}
+
+func TestEncryptionUnknownTorrent(t *testing.T) {
+	_, _, _, err := encryptPipe(t, [20]byte{1}, [20]byte{2}, mseCryptoRC4, nil)
+	if !errors.Is(err, ErrEncryptionHandshake) {
+		t.Errorf("handshake for a torrent the receiver doesn't have: %v, want %v", err, ErrEncryptionHandshake)
+	}
+}
+
+func TestEncryptionRequiredRefusesPlaintext(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go a.Write(plaintextPrefix)
+	_, _, err := acceptEncryption(b, EncryptionRequired, func([]byte) ([20]byte, bool) { return [20]byte{}, false })
+	if err == nil {
+		t.Error("plaintext connection accepted with encryption required")
+	}
+}
+
+// this function starts a client with the encryption policy and the test torrent, seeding it
+// when seed is set
+func newEncryptionClient(t *testing.T, policy EncryptionPolicy, seed bool) (*Client, *Download) {
+	t.Helper()
+	client, err := NewClient(
+		WithListenHost("127.0.0.1"),
+		WithListenPort(0),
+		WithDHT(false),
+		WithEncryption(policy),
+		WithDownloadDir(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if seed {
+		err = os.WriteFile(filepath.Join(client.Dir(), "test"), make([]byte, 16<<10), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := client.AddTorrent(testTorrent(t, "http://127.0.0.1:1/announce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seed {
+		err = d.Verify(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client, d
+}
+
+func TestClientEncryption(t *testing.T) {
+	for _, tc := range []struct {
+		seeder, leecher EncryptionPolicy
+		// connects is whether the transfer happens, encrypted whether it happens encrypted
+		connects, encrypted bool
+	}{
+		{EncryptionRequired, EncryptionRequired, true, true},
+		{EncryptionPreferred, EncryptionRequired, true, true},
+		{EncryptionDisabled, EncryptionPreferred, true, false},
+		{EncryptionDisabled, EncryptionRequired, false, false},
+		{EncryptionRequired, EncryptionDisabled, false, false},
+	} {
+		t.Run(tc.seeder.String()+"-"+tc.leecher.String(), func(t *testing.T) {
+			seeder, _ := newEncryptionClient(t, tc.seeder, true)
+			_, leech := newEncryptionClient(t, tc.leecher, false)
+			leech.AddPeers(seeder.Addr().String())
+			timeout := 10 * time.Second
+			if !tc.connects {
+				timeout = time.Second
+			}
+			select {
+			case <-leech.Done():
+				if !tc.connects {
+					t.Fatal("a seeder of another encryption policy sent the torrent")
+				}
+			case <-time.After(timeout):
+				if tc.connects {
+					t.Fatal("the download didn't complete")
+				}
+				return
+			}
+			peers := leech.Peers()
+			if len(peers) != 1 || peers[0].Encrypted != tc.encrypted {
+				t.Errorf("%d peers, want one with encryption %v", len(peers), tc.encrypted)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// This file implements the session wide event bus. Where a download's events are about its
+// pieces and peers, the bus carries what matters to everyone watching a session: torrents
+// coming and going, finishing or failing, storage trouble, the listening port changing,
+// whether it could be forwarded on the gateway and the alternative speed limits going on
+// and off.
+// A CLI, a web UI and a script can all subscribe to the same bus. Delivery never blocks the
+// publisher, each subscriber has a buffer of its own and when it falls that far behind the
+// events that don't fit are dropped and counted, the next one it gets says how many it missed
+package bittorrentclient
+
+import (
+	"sync"
+	"time"
+)
+
+type SessionEventType int
+
+const (
+	SessionTorrentAdded SessionEventType = iota
+	SessionTorrentRemoved
+	SessionTorrentCompleted
+	SessionTorrentErrored
+	SessionMetadataReceived
+	SessionStorageError
+	SessionListenPortChanged
+	SessionPortMapping
+	SessionExternalAddr
+	SessionAltSpeedOn
+	SessionAltSpeedOff
+)
+
+func (t SessionEventType) String() string {
+	switch t {
+	case SessionTorrentAdded:
+		return "torrent added"
+	case SessionTorrentRemoved:
+		return "torrent removed"
+	case SessionTorrentCompleted:
+		return "torrent completed"
+	case SessionTorrentErrored:
+		return "torrent errored"
+	case SessionMetadataReceived:
+		return "metadata received"
+	case SessionStorageError:
+		return "storage error"
+	case SessionListenPortChanged:
+		return "listen port changed"
+	case SessionPortMapping:
+		return "port mapping"
+	case SessionExternalAddr:
+		return "external address"
+	case SessionAltSpeedOn:
+		return "alt speed on"
+	case SessionAltSpeedOff:
+		return "alt speed off"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultEventBuffer is the buffer a subscriber gets when it doesn't ask for a size
+const DefaultEventBuffer = 256
+
+// SessionEvent describes something that happened in a session, only the fields that apply
+// to the event's type are set
+type SessionEvent struct {
+	Type SessionEventType
+	Time time.Time
+	// InfoHash is the torrent the event is about, zero for events about the whole session
+	InfoHash [20]byte
+	// Port is the new listening port for listen port changes, and the external port for
+	// port mappings and external addresses
+	Port int
+	// ExternalAddr is the address peers reach us at for port mappings, empty when the
+	// gateway didn't tell. A port mapping event with Err set means we aren't connectable.
+	// For external addresses it is the address a STUN server saw, see stun.go
+	ExternalAddr string
+	Err          error
+	// Missed is the number of events dropped before this one because the subscriber's
+	// buffer was full
+	Missed int
+}
+
+// EventBus fans session events out to subscribers, it is safe for concurrent use
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*busSubscriber]bool
+}
+
+type busSubscriber struct {
+	ch chan SessionEvent
+	// dropped counts events that didn't fit since the last one that did, under the bus's mu
+	dropped int
+	done    chan struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*busSubscriber]bool)}
+}
+
+// Subscribe calls fn for every event published on the bus until the returned function is
+// called. Events arrive in order on a goroutine owned by the subscription, up to buffer of
+// them wait there while fn is busy. A buffer of zero or less uses DefaultEventBuffer
+func (b *EventBus) Subscribe(buffer int, fn func(SessionEvent)) (unsubscribe func()) {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	s := &busSubscriber{
+		ch:   make(chan SessionEvent, buffer),
+		done: make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs[s] = true
+	b.mu.Unlock()
+	go s.run(fn)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+// Publish hands ev to every subscriber without waiting for any of them
+func (b *EventBus) Publish(ev SessionEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		ev.Missed = s.dropped
+		select {
+		case s.ch <- ev:
+			s.dropped = 0
+		default:
+			s.dropped++
+		}
+	}
+}
+
+func (s *busSubscriber) run(fn func(SessionEvent)) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case ev := <-s.ch:
+			fn(ev)
+		}
+	}
+}
+
+// SetEventBus makes the download publish its completion, failures and storage errors on
+// bus, nil stops it
+func (d *Download) SetEventBus(bus *EventBus) {
+	d.bus.Store(bus)
+}
+
+// this function publishes ev on the download's event bus, if it has one. it doesn't take
+// d.mu, so it can be called with it held
+func (d *Download) publish(ev SessionEvent) {
+	bus := d.bus.Load()
+	if bus == nil {
+		return
+	}
+	ev.InfoHash = d.InfoHash
+	bus.Publish(ev)
+}
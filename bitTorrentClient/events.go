@@ -0,0 +1,202 @@
+// This file lets UIs and automation react to what a download does instead of polling it.
+// Subscribers get every event in order on a goroutine of their own, so a slow callback
+// never holds up the peer connections that emit the events
+package bittorrentclient
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventStateChanged EventType = iota
+	EventPieceCompleted
+	EventFileCompleted
+	EventTorrentCompleted
+	EventHashFailed
+	EventTrackerError
+	EventPeerConnected
+	EventPeerBanned
+	EventResumeFailed
+	EventVerifyProgress
+	EventSeedLimitReached
+	EventFilesMoved
+	EventDiskFull
+	EventStorageError
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStateChanged:
+		return "state changed"
+	case EventPieceCompleted:
+		return "piece completed"
+	case EventFileCompleted:
+		return "file completed"
+	case EventTorrentCompleted:
+		return "torrent completed"
+	case EventHashFailed:
+		return "hash failed"
+	case EventTrackerError:
+		return "tracker error"
+	case EventPeerConnected:
+		return "peer connected"
+	case EventPeerBanned:
+		return "peer banned"
+	case EventResumeFailed:
+		return "resume failed"
+	case EventVerifyProgress:
+		return "verify progress"
+	case EventSeedLimitReached:
+		return "seed limit reached"
+	case EventFilesMoved:
+		return "files moved"
+	case EventDiskFull:
+		return "disk full"
+	case EventStorageError:
+		return "storage error"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes something that happened to a download, only the fields that apply to
+// the event's type are set
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	InfoHash [20]byte
+	// Piece is the piece index for piece events and hash failures
+	Piece int
+	// File is the index into the torrent's file list for file events
+	File int
+	// Peer is the peer address for peer events
+	Peer string
+	// State is the new state for state changes
+	State DownloadState
+	// Progress is the fraction of pieces checked so far for verify progress
+	Progress float64
+	Err      error
+}
+
+// eventFeed fans events out to subscribers. Every subscriber has its own queue and
+// goroutine, emit only appends to the queues and never blocks
+type eventFeed struct {
+	mu   sync.Mutex
+	subs map[*subscriber]bool
+}
+
+type subscriber struct {
+	fn     func(Event)
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []Event
+	closed bool
+}
+
+func (f *eventFeed) subscribe(fn func(Event)) func() {
+	s := &subscriber{fn: fn}
+	s.cond = sync.NewCond(&s.mu)
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[*subscriber]bool)
+	}
+	f.subs[s] = true
+	f.mu.Unlock()
+	go s.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.subs, s)
+			f.mu.Unlock()
+			s.mu.Lock()
+			s.closed = true
+			s.cond.Signal()
+			s.mu.Unlock()
+		})
+	}
+}
+
+func (f *eventFeed) emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		s.mu.Lock()
+		s.queue = append(s.queue, ev)
+		s.cond.Signal()
+		s.mu.Unlock()
+	}
+}
+
+func (s *subscriber) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		batch := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+		for _, ev := range batch {
+			s.fn(ev)
+		}
+	}
+}
+
+// Subscribe calls fn for every event of the download until the returned function is
+// called. Events arrive in order, on a goroutine owned by the subscription
+func (d *Download) Subscribe(fn func(Event)) (unsubscribe func()) {
+	return d.events.subscribe(fn)
+}
+
+func (d *Download) emit(ev Event) {
+	ev.InfoHash = d.InfoHash
+	d.events.emit(ev)
+	d.logEvent(ev)
+	switch {
+	case ev.Type == EventTorrentCompleted:
+		d.publish(SessionEvent{Type: SessionTorrentCompleted})
+	case ev.Type == EventStateChanged && ev.State == DownloadError:
+		d.publish(SessionEvent{Type: SessionTorrentErrored, Err: ev.Err})
+	}
+}
+
+// this function changes the state and tells subscribers about it. the caller must hold d.mu
+func (d *Download) setState(state DownloadState) {
+	if d.state == state {
+		return
+	}
+	d.trackSeeding(state)
+	d.state = state
+	d.wakeReaders()
+	d.emit(Event{Type: EventStateChanged, State: state, Err: d.err})
+}
+
+// this function emits a file completed event for every file piece index finished. the
+// caller must hold d.mu and have marked the piece in d.have
+func (d *Download) emitFilesCompleted(index int) {
+	for i, f := range d.Torrent.files() {
+		first, last, ok := d.Torrent.filePieces(f)
+		if !ok || index < first || index > last {
+			continue
+		}
+		done := true
+		for piece := first; piece <= last && done; piece++ {
+			done = d.have.HasPiece(piece)
+		}
+		if done {
+			d.emit(Event{Type: EventFileCompleted, File: i})
+		}
+	}
+}
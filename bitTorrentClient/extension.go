@@ -0,0 +1,125 @@
+// This file implements the extension protocol (BEP 10), which lets peers add messages of
+// their own to the wire protocol. Support is signalled in the handshake's reserved bytes,
+// after which both sides send an extended handshake naming the extensions they speak and the
+// message ids they want them sent with. Every extension message then travels as an
+// MsgExtended whose first payload byte is the receiver's id for the extension
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	MsgExtended MessageID = 20
+
+	// the extension protocol is signalled by the 0x10 bit of the sixth reserved byte
+	reservedExtensionByte = 5
+	reservedExtensionBit  = 0x10
+
+	// extHandshakeID is the extended message id of the extended handshake
+	extHandshakeID = 0
+	// the ids we ask peers to send our extensions with
+	extMetadataID = 2
+
+	extMetadataName = "ut_metadata"
+)
+
+func extensionsSupported(reserved [8]byte) bool {
+	return reserved[reservedExtensionByte]&reservedExtensionBit != 0
+}
+
+// SupportsExtensions reports whether both sides negotiated the extension protocol
+func (p *Peer) SupportsExtensions() bool {
+	return extensionsSupported(p.Reserved)
+}
+
+// ExtensionID returns the id the peer wants the extension name sent with, ok is false when
+// it didn't name the extension in its extended handshake
+func (p *Peer) ExtensionID(name string) (id int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id, ok = p.extensions[name]
+	return id, ok && id > 0
+}
+
+// MetadataSize returns the size of the info dictionary the peer announced in its extended
+// handshake, zero when it didn't
+func (p *Peer) MetadataSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.metadataSize
+}
+
+// SendExtendedHandshake tells the peer which extensions we speak. metadataSize is the size
+// of the torrent's info dictionary, zero while we don't have it ourselves
+func (p *Peer) SendExtendedHandshake(metadataSize int) error {
+	dict := map[string]interface{}{
+		"m": map[string]interface{}{extMetadataName: extMetadataID},
+		"v": "goNet " + peerIDPrefix[3:7],
+		// requests are served as they come in, this many is what our fastest peers send
+		"reqq": maxRequestBacklog,
+	}
+	if metadataSize > 0 {
+		dict["metadata_size"] = metadataSize
+	}
+	payload, err := encodeBencode(dict)
+	if err != nil {
+		return err
+	}
+	return p.Send(formatExtended(extHandshakeID, payload))
+}
+
+// this function sends payload as the peer's extension name
+func (p *Peer) sendExtension(name string, payload []byte) error {
+	id, ok := p.ExtensionID(name)
+	if !ok {
+		return fmt.Errorf("peer doesn't support %s", name)
+	}
+	return p.Send(formatExtended(id, payload))
+}
+
+func formatExtended(id int, payload []byte) *Message {
+	return &Message{ID: MsgExtended, Payload: append([]byte{byte(id)}, payload...)}
+}
+
+// ParseExtended splits an extended message into its extended id and payload
+func ParseExtended(msg *Message) (id int, payload []byte, err error) {
+	if msg.ID != MsgExtended {
+		return 0, nil, fmt.Errorf("expected extended message, got %s", msg.ID)
+	}
+	if len(msg.Payload) < 1 {
+		return 0, nil, errors.New("empty extended message")
+	}
+	return int(msg.Payload[0]), msg.Payload[1:], nil
+}
+
+// this function takes in the peer's extended handshake. the caller must hold p.mu
+func (p *Peer) readExtendedHandshake(payload []byte) error {
+	v, err := decodeBencode(payload)
+	if err != nil {
+		return fmt.Errorf("invalid extended handshake: %w", err)
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return errors.New("extended handshake is not a dictionary")
+	}
+	// a handshake updates the ids of the extensions it names, zero turns one off
+	if m, ok := dict["m"].(map[string]interface{}); ok {
+		if p.extensions == nil {
+			p.extensions = make(map[string]int)
+		}
+		for name, idInterface := range m {
+			if id, ok := idInterface.(int64); ok && id >= 0 && id < 256 {
+				p.extensions[name] = int(id)
+			}
+		}
+	}
+	if size, ok := dict["metadata_size"].(int64); ok && size > 0 && size <= maxMetadataSize {
+		p.metadataSize = int(size)
+	}
+	if reqq, ok := dict["reqq"].(int64); ok && reqq > 0 {
+		p.maxRequests = int(min(reqq, maxRequestBacklog))
+	}
+	return nil
+}
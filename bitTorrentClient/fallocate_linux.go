@@ -0,0 +1,18 @@
+// This file reserves file blocks with fallocate on Linux
+package bittorrentclient
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// this function reserves the blocks for the first size bytes of f, growing it if needed.
+// ok is false when the filesystem can't do it and the caller has to write zeros instead
+func fallocate(f *os.File, size int64) (ok bool, err error) {
+	err = syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+// Outside Linux there is no fallocate in the standard library, blocks are reserved by
+// writing zeros
+package bittorrentclient
+
+import "os"
+
+func fallocate(f *os.File, size int64) (ok bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,148 @@
+// This file implements the parts of the Fast extension (BEP 6) we use. The allowed fast set
+// is a small, deterministic set of pieces derived from the peer's IP and the infohash that
+// may be requested even while choked, which gets new peers going before their first unchoke
+package bittorrentclient
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+)
+
+const (
+	MsgSuggest     MessageID = 0x0D
+	MsgHaveAll     MessageID = 0x0E
+	MsgHaveNone    MessageID = 0x0F
+	MsgReject      MessageID = 0x10
+	MsgAllowedFast MessageID = 0x11
+
+	// the fast extension is signalled by the third least significant bit of the reserved bytes
+	reservedFastByte = 7
+	reservedFastBit  = 0x04
+
+	defaultAllowedFastSetSize = 10
+)
+
+func fastSupported(reserved [8]byte) bool {
+	return reserved[reservedFastByte]&reservedFastBit != 0
+}
+
+// AllowedFastSet computes the canonical allowed fast set of k pieces for a peer at ip. The
+// algorithm is only defined for IPv4, other addresses get no set
+func AllowedFastSet(ip net.IP, infoHash [20]byte, numPieces, k int) []int {
+	ip4 := ip.To4()
+	if ip4 == nil || numPieces <= 0 {
+		return nil
+	}
+	if k > numPieces {
+		k = numPieces
+	}
+
+	// only the /24 the peer is in counts, so a peer can't grow its set by hopping addresses
+	x := make([]byte, 0, 24)
+	x = append(x, ip4[0], ip4[1], ip4[2], 0)
+	x = append(x, infoHash[:]...)
+
+	set := make([]int, 0, k)
+	seen := make(map[int]bool, k)
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			y := binary.BigEndian.Uint32(x[i*4:])
+			index := int(y % uint32(numPieces))
+			if !seen[index] {
+				seen[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+	return set
+}
+
+func FormatAllowedFast(index int) *Message {
+	msg := FormatHave(index)
+	msg.ID = MsgAllowedFast
+	return msg
+}
+
+func FormatReject(index, begin, length int) *Message {
+	return &Message{ID: MsgReject, Payload: formatBlock(index, begin, length)}
+}
+
+// SupportsFast reports whether both sides negotiated the fast extension
+func (p *Peer) SupportsFast() bool {
+	return fastSupported(p.Reserved)
+}
+
+// GrantAllowedFast sends the peer its allowed fast set, we will then serve requests for
+// those pieces even while the peer is choked
+func (p *Peer) GrantAllowedFast(infoHash [20]byte, numPieces, k int) error {
+	if !p.SupportsFast() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(p.Addr)
+	if err != nil {
+		return err
+	}
+	set := AllowedFastSet(net.ParseIP(host), infoHash, numPieces, k)
+
+	p.mu.Lock()
+	for _, index := range set {
+		p.grantedFast[index] = true
+	}
+	p.mu.Unlock()
+
+	for _, index := range set {
+		err := p.Send(FormatAllowedFast(index))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsGrantedFast reports whether we allowed the peer to request index while choked
+func (p *Peer) IsGrantedFast(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.grantedFast[index]
+}
+
+// CanRequestPiece reports whether a request for a block of index would be served, either
+// because the peer unchoked us or because the piece is in our allowed fast set
+func (p *Peer) CanRequestPiece(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.peerChoking || p.allowedFast[index]
+}
+
+// AllowedFast returns the pieces the peer lets us request while choked that it actually has
+func (p *Peer) AllowedFast() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var pieces []int
+	for index := range p.allowedFast {
+		if p.bitfield.HasPiece(index) {
+			pieces = append(pieces, index)
+		}
+	}
+	return pieces
+}
+
+// SendReject tells a fast peer we won't serve one of its requests
+func (p *Peer) SendReject(index, begin, length int) error {
+	if !p.SupportsFast() {
+		return nil
+	}
+	return p.Send(FormatReject(index, begin, length))
+}
+
+// SendHaveAll and SendHaveNone replace the bitfield for fast peers
+func (p *Peer) SendHaveAll() error {
+	return p.Send(&Message{ID: MsgHaveAll})
+}
+
+func (p *Peer) SendHaveNone() error {
+	return p.Send(&Message{ID: MsgHaveNone})
+}
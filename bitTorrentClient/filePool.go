@@ -0,0 +1,174 @@
+// This file keeps the number of open files bounded. Torrents with thousands of files would
+// otherwise hold a descriptor for every file they ever touched and run into the process's
+// limit, so FileStorage gets its handles from a FilePool, which closes the least recently
+// used ones once too many are open. A handle is counted as in use from acquire to release,
+// in-use handles are never closed under a reader or writer, and reads and writes on one
+// handle go through ReadAt and WriteAt, which don't share a file offset, so any number of
+// peers can use the same file at once
+package bittorrentclient
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"sync"
+)
+
+// DefaultMaxOpenFiles is the size of the pool storages share unless they are given their own
+const DefaultMaxOpenFiles = 512
+
+// FilePool bounds how many files are open across every storage using it. The bound is soft:
+// when every open handle is in use a new one is opened anyway rather than blocking, which
+// could deadlock a read spanning several files, and the pool shrinks back as handles are
+// released
+type FilePool struct {
+	mu  sync.Mutex
+	max int
+	// lru holds the *storageFile of every open handle, most recently used first
+	lru *list.List
+}
+
+// NewFilePool returns a pool keeping at most max files open, zero or less means
+// DefaultMaxOpenFiles
+func NewFilePool(max int) *FilePool {
+	if max <= 0 {
+		max = DefaultMaxOpenFiles
+	}
+	return &FilePool{max: max, lru: list.New()}
+}
+
+var (
+	sharedFilePool     *FilePool
+	sharedFilePoolOnce sync.Once
+)
+
+// this function returns the pool used by storages that weren't given one
+func defaultFilePool() *FilePool {
+	sharedFilePoolOnce.Do(func() {
+		sharedFilePool = NewFilePool(0)
+	})
+	return sharedFilePool
+}
+
+// SetMaxOpen changes the bound, idle handles over it are closed right away
+func (p *FilePool) SetMaxOpen(max int) {
+	if max <= 0 {
+		max = DefaultMaxOpenFiles
+	}
+	p.mu.Lock()
+	p.max = max
+	victims := p.evict(0)
+	p.mu.Unlock()
+	closeHandles(victims)
+}
+
+// Open returns the number of files currently open through the pool
+func (p *FilePool) Open() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// this function returns sf's handle, opening it with open when it isn't open, and counts
+// it as in use until release is called
+func (p *FilePool) acquire(sf *storageFile, open func() (*os.File, error)) (*os.File, error) {
+	p.mu.Lock()
+	if sf.f != nil {
+		sf.users++
+		p.lru.MoveToFront(sf.elem)
+		p.mu.Unlock()
+		return sf.f, nil
+	}
+	victims := p.evict(1)
+	f, err := open()
+	if err == nil {
+		sf.f = f
+		sf.users = 1
+		sf.elem = p.lru.PushFront(sf)
+	}
+	p.mu.Unlock()
+	closeHandles(victims)
+	return f, err
+}
+
+// this function marks a handle returned by acquire as no longer in use. dirty records that
+// it was written to, so it gets synced before it is closed
+func (p *FilePool) release(sf *storageFile, dirty bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sf.users--
+	if dirty {
+		sf.dirty = true
+	}
+}
+
+// this function takes idle handles out of the pool, least recently used first, until there
+// is room for extra more. the caller must hold p.mu and close the returned files after
+// unlocking, closing a written file syncs it first which can take a while
+func (p *FilePool) evict(extra int) []*storageFile {
+	var victims []*storageFile
+	for e := p.lru.Back(); e != nil && p.lru.Len()+extra > p.max; {
+		prev := e.Prev()
+		sf := e.Value.(*storageFile)
+		if sf.users == 0 {
+			victims = append(victims, &storageFile{path: sf.path, f: sf.f, dirty: sf.dirty})
+			p.lru.Remove(e)
+			sf.f, sf.elem, sf.dirty = nil, nil, false
+		}
+		e = prev
+	}
+	return victims
+}
+
+// this function closes handles taken out of the pool. data written through them is synced
+// first, Flush can't reach it anymore once the handle is gone
+func closeHandles(victims []*storageFile) error {
+	var errs []error
+	for _, sf := range victims {
+		if sf.dirty {
+			errs = append(errs, sf.f.Sync())
+		}
+		errs = append(errs, sf.f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// this function syncs every handle of files that was written to since it was last synced
+func (p *FilePool) sync(files []*storageFile) error {
+	var errs []error
+	for _, sf := range files {
+		p.mu.Lock()
+		f := sf.f
+		if f == nil || !sf.dirty {
+			p.mu.Unlock()
+			continue
+		}
+		// held like a reader so it isn't closed under the sync
+		sf.users++
+		sf.dirty = false
+		p.mu.Unlock()
+
+		err := f.Sync()
+		p.release(sf, err != nil)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// this function closes the handles of files and takes them out of the pool, for a storage
+// being closed. nothing may be using them anymore. like closing a file they aren't synced,
+// that is what Flush is for
+func (p *FilePool) closeFiles(files []*storageFile) error {
+	var victims []*storageFile
+	p.mu.Lock()
+	for _, sf := range files {
+		if sf.f == nil {
+			continue
+		}
+		victims = append(victims, &storageFile{path: sf.path, f: sf.f})
+		p.lru.Remove(sf.elem)
+		sf.f, sf.elem, sf.dirty = nil, nil, false
+	}
+	p.mu.Unlock()
+	return closeHandles(victims)
+}
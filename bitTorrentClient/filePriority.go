@@ -0,0 +1,106 @@
+// This file lets users choose which files of a multi-file torrent to download. Pieces are
+// prioritized by the files they belong to: pieces that belong only to skipped files are
+// never requested, while a piece shared with a wanted file still has to be fetched whole
+package bittorrentclient
+
+import "fmt"
+
+type FilePriority int
+
+const (
+	FileSkip FilePriority = iota
+	FileNormal
+	FileHigh
+)
+
+func (p FilePriority) String() string {
+	switch p {
+	case FileSkip:
+		return "skip"
+	case FileNormal:
+		return "normal"
+	case FileHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// this function maps a file priority onto the piece priority its pieces get
+func (p FilePriority) piecePriority() PiecePriority {
+	switch p {
+	case FileSkip:
+		return PiecePriorityIgnore
+	case FileHigh:
+		return PiecePriorityHigh
+	default:
+		return PiecePriorityNormal
+	}
+}
+
+// SetFilePriority changes the priority of the file at index in the torrent's file list.
+// Unskipping a file of a finished download starts downloading again
+func (d *Download) SetFilePriority(index int, prio FilePriority) error {
+	d.mu.Lock()
+	if index < 0 || index >= len(d.filePriority) {
+		d.mu.Unlock()
+		return fmt.Errorf("file index %d out of range", index)
+	}
+	d.filePriority[index] = prio
+	d.applyFilePriorities()
+	d.mu.Unlock()
+
+	for _, p := range d.Peers() {
+		d.updateInterest(p)
+	}
+	return nil
+}
+
+// FilePriorities returns the priority of every file, in the order of the torrent's file list
+func (d *Download) FilePriorities() []FilePriority {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]FilePriority(nil), d.filePriority...)
+}
+
+// this function recomputes every piece priority from the file priorities, a piece gets the
+// highest priority of the files it overlaps, unless its priority was set directly. preview
+// pieces go on top of the file priorities. the caller must hold d.mu
+func (d *Download) applyFilePriorities() {
+	prios := make([]PiecePriority, d.Torrent.NumPieces())
+	for i, f := range d.Torrent.files() {
+		first, last, ok := d.Torrent.filePieces(f)
+		if !ok {
+			continue
+		}
+		prio := d.filePriority[i].piecePriority()
+		for index := first; index <= last; index++ {
+			if prio > prios[index] {
+				prios[index] = prio
+			}
+		}
+	}
+	d.applyPreviewPieces(prios)
+	for index, prio := range d.pieceOverrides {
+		prios[index] = prio
+	}
+	for index, prio := range prios {
+		d.picker.SetPriority(index, prio)
+	}
+	d.wantedChanged()
+}
+
+// this function moves the download between downloading and seeding after the set of wanted
+// pieces changed. the caller must hold d.mu
+func (d *Download) wantedChanged() {
+	switch {
+	case d.state == DownloadSeeding && !d.complete():
+		d.done = make(chan struct{})
+		d.setState(DownloadDownloading)
+	case d.state == DownloadDownloading && d.complete():
+		d.closeDone()
+		d.emit(Event{Type: EventTorrentCompleted})
+		d.setState(DownloadSeeding)
+		d.moveWhenComplete()
+	}
+}
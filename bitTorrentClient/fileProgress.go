@@ -0,0 +1,77 @@
+// This file reports progress per file. Verified pieces are mapped onto the byte ranges of
+// the files they overlap, so a multi-file torrent can show which files are done instead of
+// a single number for the whole torrent. Only verified data counts, blocks of pieces still
+// in progress don't
+package bittorrentclient
+
+import "path/filepath"
+
+type FileProgress struct {
+	// Index is the file's index in the torrent's file list
+	Index int
+	// Path is relative to the download directory
+	Path      string
+	Length    int64
+	Completed int64
+	Priority  FilePriority
+}
+
+// Progress returns the completed fraction of the file, an empty file counts as complete
+func (f FileProgress) Progress() float64 {
+	if f.Length == 0 {
+		return 1
+	}
+	return float64(f.Completed) / float64(f.Length)
+}
+
+func (f FileProgress) Done() bool {
+	return f.Completed == f.Length
+}
+
+// FileProgress returns the progress of every file of the torrent, in the torrent's order.
+// Padding files are left out
+func (d *Download) FileProgress() []FileProgress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var progress []FileProgress
+	layout := d.fileLayout()
+	for i, f := range layout.files() {
+		if f.Padding {
+			continue
+		}
+		progress = append(progress, FileProgress{
+			Index:     i,
+			Path:      layout.relativePath(f),
+			Length:    f.Length,
+			Completed: d.Torrent.fileCompleted(f, d.have),
+			Priority:  d.filePriority[i],
+		})
+	}
+	return progress
+}
+
+// this function counts how many of the file's bytes lie in pieces set in have
+func (t *Torrent) fileCompleted(f fileSpan, have Bitfield) int64 {
+	first, last, ok := t.filePieces(f)
+	if !ok {
+		return 0
+	}
+	var completed int64
+	for index := first; index <= last; index++ {
+		if !have.HasPiece(index) {
+			continue
+		}
+		start := max(int64(index)*t.Info.PieceLength, f.Offset)
+		end := min(int64(index)*t.Info.PieceLength+int64(t.PieceSize(index)), f.Offset+f.Length)
+		completed += end - start
+	}
+	return completed
+}
+
+// this function returns the path of a file relative to the download directory
+func (t *Torrent) relativePath(f fileSpan) string {
+	if len(t.Info.Files) == 0 {
+		return f.Path[0]
+	}
+	return filepath.Join(append([]string{t.Info.Name}, f.Path...)...)
+}
@@ -0,0 +1,302 @@
+// This file puts a torrent's data on disk. Pieces are addressed by their offset into the
+// torrent as if all its files were laid end to end, FileStorage maps those offsets onto the
+// files of the torrent and splits reads and writes that cross from one file into the next.
+// A single file torrent is saved as dir/name, a multi-file torrent under dir/name/ with
+// the directories from the torrent's file paths. Files are created when their first byte
+// is written, so skipped files don't show up on disk unless they share a piece with a
+// wanted one. Every path is checked to stay inside dir with symlinks resolved, see
+// pathConfine.go. How a file's space is reserved on its first write is up to its AllocationMode,
+// see allocation.go
+package bittorrentclient
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStorage stores a torrent's pieces in its files under a directory. It is safe for
+// concurrent use
+type FileStorage struct {
+	root        string
+	pieceLength int64
+	totalLength int64
+	spans       []fileSpan
+	allocation  AllocationMode
+	// readOnly storage opens files read only and refuses writes, see VerifyData
+	readOnly bool
+	// pool hands out the file handles, see filePool.go
+	pool *FilePool
+
+	files []*storageFile
+	// mu guards allocated
+	mu sync.Mutex
+}
+
+type storageFile struct {
+	// path is where the file is kept, which is final with the part suffix while the file
+	// is incomplete. it is guarded by the pool's mutex
+	path      string
+	final     string
+	allocated bool
+
+	// the handle is managed by the pool and guarded by its mutex. f is nil while the file
+	// isn't open, users counts who is reading or writing through f and dirty is set when
+	// it was written to since it was last synced
+	f     *os.File
+	users int
+	dirty bool
+	elem  *list.Element
+}
+
+// NewFileStorage prepares storage for t under dir. The directories of the torrent are
+// created here, and so are its empty files since nothing is ever written to those
+func NewFileStorage(t *Torrent, dir string) (*FileStorage, error) {
+	paths, err := t.filePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		root:        dir,
+		pieceLength: t.Info.PieceLength,
+		totalLength: t.TotalLength(),
+		spans:       t.files(),
+		pool:        defaultFilePool(),
+	}
+	for i, f := range t.files() {
+		path := paths[i]
+		s.files = append(s.files, &storageFile{path: path, final: path})
+		if f.Padding {
+			continue
+		}
+		err = mkdirConfined(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		if f.Length == 0 {
+			empty, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			empty.Close()
+		}
+	}
+	return s, nil
+}
+
+// this function prepares storage for reading what is already in dir, without creating
+// anything or needing write access to it
+func openReadOnlyFileStorage(t *Torrent, dir string) (*FileStorage, error) {
+	paths, err := t.filePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		root:        dir,
+		pieceLength: t.Info.PieceLength,
+		totalLength: t.TotalLength(),
+		spans:       t.files(),
+		readOnly:    true,
+		pool:        defaultFilePool(),
+	}
+	for _, path := range paths {
+		s.files = append(s.files, &storageFile{path: path, final: path})
+	}
+	return s, nil
+}
+
+// WriteBlock writes data at begin within piece index
+func (s *FileStorage) WriteBlock(index, begin int, data []byte) error {
+	_, err := s.WriteAt(data, int64(index)*s.pieceLength+int64(begin))
+	return err
+}
+
+// ReadBlock reads length bytes at begin within piece index
+func (s *FileStorage) ReadBlock(index, begin, length int) ([]byte, error) {
+	b := make([]byte, length)
+	n, err := s.ReadAt(b, int64(index)*s.pieceLength+int64(begin))
+	if n == length {
+		err = nil
+	}
+	return b[:n], err
+}
+
+// ReadAt reads from the torrent's files at the torrent offset off. Data that was never
+// written, including whole files that don't exist yet, makes it a short read. Padding
+// files read as zeros
+func (s *FileStorage) ReadAt(b []byte, off int64) (int, error) {
+	read := 0
+	err := spanFiles(s.spans, s.totalLength, b, off, func(i int, chunk []byte, fileOff int64) error {
+		if s.spans[i].Padding {
+			read += copy(chunk, make([]byte, len(chunk)))
+			return nil
+		}
+		f, err := s.open(i, false)
+		if errors.Is(err, fs.ErrNotExist) {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		n, err := f.ReadAt(chunk, fileOff)
+		s.pool.release(s.files[i], false)
+		read += n
+		if err == io.EOF && n < len(chunk) {
+			return io.EOF
+		}
+		return err
+	})
+	return read, err
+}
+
+// WriteAt writes to the torrent's files at the torrent offset off, creating them as needed.
+// What falls into padding files is dropped
+func (s *FileStorage) WriteAt(b []byte, off int64) (int, error) {
+	if s.readOnly {
+		return 0, errors.New("storage is read only")
+	}
+	written := 0
+	err := spanFiles(s.spans, s.totalLength, b, off, func(i int, chunk []byte, fileOff int64) error {
+		if s.spans[i].Padding {
+			written += len(chunk)
+			return nil
+		}
+		f, err := s.open(i, true)
+		if err != nil {
+			return err
+		}
+		n, err := f.WriteAt(chunk, fileOff)
+		s.pool.release(s.files[i], true)
+		written += n
+		return err
+	})
+	return written, err
+}
+
+// this function calls fn for each of the files b overlaps at the torrent offset off, with
+// the file's index, the part of b that falls into it and where that part starts within the
+// file. it stops at the first error
+func spanFiles(files []fileSpan, total int64, b []byte, off int64, fn func(i int, chunk []byte, fileOff int64) error) error {
+	if off < 0 || off+int64(len(b)) > total {
+		return fmt.Errorf("range %d+%d is outside the torrent", off, len(b))
+	}
+	for i, f := range files {
+		if len(b) == 0 {
+			break
+		}
+		if f.Length == 0 || off >= f.Offset+f.Length {
+			continue
+		}
+		n := min(int64(len(b)), f.Offset+f.Length-off)
+		err := fn(i, b[:n], off-f.Offset)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		off += n
+	}
+	return nil
+}
+
+// this function returns file i open, taking its handle from the pool. the caller has to
+// release it when done. a file that doesn't exist is only created for writing, and
+// allocated before the first write to it
+func (s *FileStorage) open(i int, write bool) (*os.File, error) {
+	sf := s.files[i]
+	f, err := s.pool.acquire(sf, func() (*os.File, error) {
+		// checked on every open, a link can be put in the way after the storage was opened
+		err := confinePath(s.root, sf.path)
+		if err != nil {
+			return nil, err
+		}
+		flags := os.O_RDWR
+		if s.readOnly {
+			flags = os.O_RDONLY
+		} else if write {
+			flags |= os.O_CREATE
+		}
+		return os.OpenFile(sf.path, flags, 0o644)
+	})
+	if err != nil || !write {
+		return f, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !sf.allocated {
+		err := allocateFile(f, s.spans[i].Length, s.allocation)
+		if err != nil {
+			s.pool.release(sf, true)
+			return nil, fmt.Errorf("allocating %s: %w", f.Name(), err)
+		}
+		sf.allocated = true
+	}
+	return f, nil
+}
+
+// ReadPieceAt reads from piece index at off within the piece
+func (s *FileStorage) ReadPieceAt(index int, b []byte, off int64) (int, error) {
+	return s.ReadAt(b, int64(index)*s.pieceLength+off)
+}
+
+// WritePieceAt writes to piece index at off within the piece
+func (s *FileStorage) WritePieceAt(index int, b []byte, off int64) (int, error) {
+	return s.WriteAt(b, int64(index)*s.pieceLength+off)
+}
+
+// Flush syncs every file written to since the last Flush to the disk. Files the pool closed
+// in the meantime were synced when they were closed
+func (s *FileStorage) Flush() error {
+	return s.pool.sync(s.files)
+}
+
+// Close closes every open file, the storage can't be used afterwards
+func (s *FileStorage) Close() error {
+	return s.pool.closeFiles(s.files)
+}
+
+// this function returns where each of the torrent's files is saved under dir. path
+// elements from the torrent are checked so a malicious torrent can't write outside dir
+func (t *Torrent) filePaths(dir string) ([]string, error) {
+	name, err := safePathElement(t.Info.Name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid torrent name: %w", err)
+	}
+	if len(t.Info.Files) == 0 {
+		return []string{filepath.Join(dir, name)}, nil
+	}
+	paths := make([]string, len(t.Info.Files))
+	for i, f := range t.Info.Files {
+		if len(f.Path) == 0 {
+			return nil, fmt.Errorf("file %d has an empty path", i)
+		}
+		elems := []string{dir, name}
+		for _, elem := range f.Path {
+			elem, err = safePathElement(elem)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path for file %d: %w", i, err)
+			}
+			elems = append(elems, elem)
+		}
+		paths[i] = filepath.Join(elems...)
+	}
+	return paths, nil
+}
+
+// this function checks that elem names a single file or directory, not a path and not a
+// reference to the current or parent directory
+func safePathElement(elem string) (string, error) {
+	if elem == "" || elem == "." || elem == ".." {
+		return "", fmt.Errorf("%q is not a file name", elem)
+	}
+	for _, c := range elem {
+		if c == '/' || c == '\\' || c == 0 {
+			return "", fmt.Errorf("%q contains a path separator", elem)
+		}
+	}
+	return elem, nil
+}
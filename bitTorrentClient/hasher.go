@@ -0,0 +1,138 @@
+// This file runs piece hash checks on a bounded pool of workers. Completed pieces are handed
+// to the pool instead of being hashed on the peer's read loop, and a full recheck feeds it one
+// piece at a time. The queue in front of the workers is bounded too, once it is full
+// submitters block, so a recheck or a burst of completed pieces can't pile up unbounded
+// memory, and hashing never takes more than GOMAXPROCS cores
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// ErrHasherClosed is returned for jobs submitted after the hasher was closed
+var ErrHasherClosed = errors.New("hasher is closed")
+
+type hashJob struct {
+	data  []byte
+	check func(data []byte) bool
+	done  func(ok bool)
+}
+
+// Hasher is a pool of workers checking data against piece hashes, SHA-1 for v1 and merkle
+// trees for v2 torrents. It can be shared by any number of downloads
+type Hasher struct {
+	jobs chan hashJob
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewHasher starts a hasher with the given number of workers and room for queue waiting
+// jobs. Zero or less for workers means GOMAXPROCS, and for queue twice the number of workers
+func NewHasher(workers, queue int) *Hasher {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if queue <= 0 {
+		queue = 2 * workers
+	}
+	h := &Hasher{jobs: make(chan hashJob, queue)}
+	h.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go h.work()
+	}
+	return h
+}
+
+var (
+	sharedHasher     *Hasher
+	sharedHasherOnce sync.Once
+)
+
+// this function returns the hasher used by downloads that weren't given one
+func defaultHasher() *Hasher {
+	sharedHasherOnce.Do(func() {
+		sharedHasher = NewHasher(0, 0)
+	})
+	return sharedHasher
+}
+
+func (h *Hasher) work() {
+	defer h.wg.Done()
+	for job := range h.jobs {
+		job.done(job.check(job.data))
+	}
+}
+
+// Submit queues data to be checked against the SHA-1 hash want, done is called from a worker
+// with the result. Submit blocks while the queue is full, until ctx is done. done is only
+// called when Submit returns nil, and the data must not be touched until then
+func (h *Hasher) Submit(ctx context.Context, data, want []byte, done func(ok bool)) error {
+	return h.submit(ctx, data, func(data []byte) bool {
+		sum := sha1.Sum(data)
+		return bytes.Equal(sum[:], want)
+	}, done)
+}
+
+// SubmitPiece is Submit for piece index of t, checked against whichever of the torrent's v1
+// and v2 hashes it has
+func (h *Hasher) SubmitPiece(ctx context.Context, t *Torrent, index int, data []byte, done func(ok bool)) error {
+	return h.submit(ctx, data, func(data []byte) bool {
+		return t.checkPiece(index, data)
+	}, done)
+}
+
+func (h *Hasher) submit(ctx context.Context, data []byte, check func([]byte) bool, done func(ok bool)) error {
+	// the read lock keeps Close from closing the channel under a blocked send
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return ErrHasherClosed
+	}
+	select {
+	case h.jobs <- hashJob{data: data, check: check, done: done}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check hashes data on the pool and waits for the result
+func (h *Hasher) Check(ctx context.Context, data, want []byte) (bool, error) {
+	result := make(chan bool, 1)
+	err := h.Submit(ctx, data, want, func(ok bool) { result <- ok })
+	if err != nil {
+		return false, err
+	}
+	return <-result, nil
+}
+
+// CheckPiece hashes piece index of t on the pool and waits for the result
+func (h *Hasher) CheckPiece(ctx context.Context, t *Torrent, index int, data []byte) (bool, error) {
+	result := make(chan bool, 1)
+	err := h.SubmitPiece(ctx, t, index, data, func(ok bool) { result <- ok })
+	if err != nil {
+		return false, err
+	}
+	return <-result, nil
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish. Blocked submitters
+// have to give up through their contexts before Close can go ahead
+func (h *Hasher) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.jobs)
+	h.mu.Unlock()
+	h.wg.Wait()
+}
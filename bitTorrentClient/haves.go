@@ -0,0 +1,51 @@
+// This file cuts down on have messages. Peers that already have a piece don't need to hear
+// that we got it too, and haves for pieces completing in quick succession are batched into
+// a single write instead of one tiny packet each
+package bittorrentclient
+
+import "time"
+
+const (
+	// queued haves are flushed at least this often
+	HaveFlushInterval = time.Second
+	// a batch this large is flushed straight away
+	haveBatchMax = 64
+)
+
+// QueueHave queues a have for index unless the peer already has the piece. The batch is
+// written once it is full, otherwise on the next FlushHaves
+func (p *Peer) QueueHave(index int) error {
+	p.mu.Lock()
+	if p.bitfield.HasPiece(index) {
+		p.mu.Unlock()
+		return nil
+	}
+	p.queuedHaves = append(p.queuedHaves, index)
+	full := len(p.queuedHaves) >= haveBatchMax
+	p.mu.Unlock()
+
+	if full {
+		return p.FlushHaves()
+	}
+	return nil
+}
+
+// FlushHaves writes every queued have in one go, dropping the ones for pieces the peer
+// picked up in the meantime
+func (p *Peer) FlushHaves() error {
+	p.mu.Lock()
+	queued := p.queuedHaves
+	p.queuedHaves = nil
+	var buf []byte
+	for _, index := range queued {
+		if !p.bitfield.HasPiece(index) {
+			buf = append(buf, FormatHave(index).Serialize()...)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(buf) == 0 {
+		return nil
+	}
+	return p.enqueue(outgoing{buf: buf})
+}
@@ -0,0 +1,210 @@
+// This file runs commands when torrents are added, complete or fail, so finished downloads
+// can be unpacked, a media library refreshed or a notification sent without writing Go. A
+// hook's command is run without a shell, with what it is about in its environment:
+//
+//	GONET_EVENT     torrent_added, torrent_completed or torrent_errored
+//	GONET_INFOHASH  the torrent's infohash in hex
+//	GONET_NAME      its name, as renamed if it was
+//	GONET_DIR       the directory it is saved in
+//	GONET_PATH      its file, or its directory for torrents of many files
+//	GONET_LABEL     its first label, GONET_LABELS all of them comma separated
+//	GONET_ERROR     what went wrong, for torrent_errored
+//
+// Only GONET_EVENT, GONET_INFOHASH and GONET_ERROR are set for magnet links failing before
+// their metadata arrived. A completed torrent with a complete directory runs its hooks once
+// the files were moved there. Hooks run side by side, their output is logged when they fail
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the most of a hook's output kept for the log
+const hookOutputLimit = 4 << 10
+
+// Hook is a command run on an event of the session bus
+type Hook struct {
+	// On is the event the command runs on, SessionTorrentAdded, SessionTorrentCompleted or
+	// SessionTorrentErrored
+	On SessionEventType
+	// Command is the program and its arguments
+	Command []string
+	// Timeout kills the command when it runs longer, zero lets it run as long as it takes
+	Timeout time.Duration
+}
+
+// this function checks that the hook is one the client can run
+func (h Hook) validate() error {
+	switch h.On {
+	case SessionTorrentAdded, SessionTorrentCompleted, SessionTorrentErrored:
+	default:
+		return fmt.Errorf("hooks don't run on %s events", h.On)
+	}
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return fmt.Errorf("%s hook has no command", h.On)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("%s hook timeout can't be negative", h.On)
+	}
+	return nil
+}
+
+// this function starts running hooks on the events of the bus. it is called once the saved
+// session is restored, so restored torrents don't count as added
+func (c *Client) startHooks(hooks []Hook) {
+	if len(hooks) == 0 {
+		return
+	}
+	c.hooks = hooks
+	c.hookLogger = c.baseLogger.With("component", LogHooks)
+	c.hookCtx, c.cancelHooks = context.WithCancel(context.Background())
+	c.stopHooks = c.bus.Subscribe(0, c.runHooks)
+}
+
+// this function starts the hooks that run on ev
+func (c *Client) runHooks(ev SessionEvent) {
+	for _, h := range c.hooks {
+		if h.On != ev.Type {
+			continue
+		}
+		c.mu.Lock()
+		if c.closed {
+			// Shutdown is waiting for the hooks already running
+			c.mu.Unlock()
+			return
+		}
+		c.hookWG.Add(1)
+		c.mu.Unlock()
+		go func() {
+			defer c.hookWG.Done()
+			c.runHook(h, ev)
+		}()
+	}
+}
+
+func (c *Client) runHook(h Hook, ev SessionEvent) {
+	ctx := c.hookCtx
+	env := []string{
+		"GONET_EVENT=" + strings.ReplaceAll(ev.Type.String(), " ", "_"),
+		"GONET_INFOHASH=" + hex.EncodeToString(ev.InfoHash[:]),
+	}
+	if ev.Err != nil {
+		env = append(env, "GONET_ERROR="+ev.Err.Error())
+	}
+	if d, ok := c.Torrent(ev.InfoHash); ok {
+		if ev.Type == SessionTorrentCompleted {
+			d.waitForMove(ctx)
+		}
+		env = append(env, d.hookEnv()...)
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	out := &cappedBuffer{limit: hookOutputLimit}
+	cmd.Stdout, cmd.Stderr = out, out
+	start := time.Now()
+	err := cmd.Run()
+	logger := c.hookLogger.With("event", ev.Type.String(), "command", h.Command[0], "infohash", fmt.Sprintf("%x", ev.InfoHash))
+	if err != nil {
+		logger.Warn("hook failed", "err", err, "output", strings.TrimSpace(out.String()))
+		return
+	}
+	logger.Info("hook ran", "took", time.Since(start).Round(time.Millisecond))
+}
+
+// this function returns the variables describing the download to its hooks. The name and
+// path are those of the files on disk, renamed or not, and a torrent whose name would lead
+// outside its directory has no GONET_PATH
+func (d *Download) hookEnv() []string {
+	labels := d.Labels()
+	d.mu.Lock()
+	dir := d.Dir
+	layout := d.fileLayout()
+	paths, err := layout.filePaths(dir)
+	d.mu.Unlock()
+	env := []string{
+		"GONET_NAME=" + layout.Info.Name,
+		"GONET_DIR=" + dir,
+		"GONET_LABELS=" + strings.Join(labels, ","),
+	}
+	if err == nil {
+		// the file of a single file torrent, the root directory of any other
+		path := paths[0]
+		if len(layout.Info.Files) > 0 {
+			path = filepath.Join(dir, layout.Info.Name)
+		}
+		env = append(env, "GONET_PATH="+path)
+	}
+	if len(labels) > 0 {
+		env = append(env, "GONET_LABEL="+labels[0])
+	}
+	return env
+}
+
+// this function waits until the files of a completed download are moved to its complete
+// directory, when it has one they aren't in. a move that fails ends the wait as well, as
+// does the download stopping or failing
+func (d *Download) waitForMove(ctx context.Context) {
+	moved := make(chan struct{})
+	var once sync.Once
+	unsubscribe := d.Subscribe(func(ev Event) {
+		if ev.Type == EventFilesMoved || ev.Type == EventStateChanged && ev.State != DownloadSeeding {
+			once.Do(func() { close(moved) })
+		}
+	})
+	defer unsubscribe()
+	d.mu.Lock()
+	pending := d.completeDir != "" && d.completeDir != d.Dir
+	d.mu.Unlock()
+	if !pending {
+		return
+	}
+	select {
+	case <-moved:
+	case <-ctx.Done():
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written to it and drops the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// this function waits for the hooks still running until ctx is done, then kills them
+func (c *Client) waitHooks(ctx context.Context) error {
+	defer c.cancelHooks()
+	done := make(chan struct{})
+	go func() {
+		c.hookWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		c.cancelHooks()
+		<-done
+		return fmt.Errorf("shutdown: hooks killed: %w", ctx.Err())
+	}
+}
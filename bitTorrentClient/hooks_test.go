@@ -0,0 +1,35 @@
+package bittorrentclient
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHookEnvFollowsRename(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDownload(testTorrent(t, "http://127.0.0.1:1/announce"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.layout = d.Torrent.withNames("renamed", nil)
+	d.mu.Unlock()
+	env := d.hookEnv()
+	for _, want := range []string{"GONET_NAME=renamed", "GONET_PATH=" + filepath.Join(dir, "renamed")} {
+		if !slices.Contains(env, want) {
+			t.Errorf("environment %q lacks %s", env, want)
+		}
+	}
+
+	// a name leading out of the directory gets no path at all
+	d.mu.Lock()
+	d.layout = d.Torrent.withNames("..", nil)
+	d.mu.Unlock()
+	for _, v := range d.hookEnv() {
+		if strings.HasPrefix(v, "GONET_PATH=") {
+			t.Errorf("environment has %s for a torrent named ..", v)
+		}
+	}
+}
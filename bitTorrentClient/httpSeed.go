@@ -0,0 +1,167 @@
+// This file implements BEP 17 HTTP seeding, the older of the two web seed protocols. A seed
+// is a script that takes the infohash and a piece index and answers with that whole piece.
+// Seeds are an extra source next to the peers: each one gets a goroutine that takes pieces
+// from the picker like a peer that has everything would. A busy seed answers 503 with the
+// number of seconds to wait, a seed that keeps failing is given up on
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	httpSeedTimeout = 60 * time.Second
+	// a seed is retried this long after a failure, doubling with each one in a row
+	httpSeedRetryDelay = 10 * time.Second
+	// a seed is given up on after this many failures in a row
+	maxHTTPSeedFailures = 5
+	// how long a seed waits when the picker has nothing for it
+	httpSeedIdleWait = 5 * time.Second
+)
+
+// seedBusyError is a 503 from a seed, it wants us to come back after retry
+type seedBusyError struct {
+	retry time.Duration
+}
+
+func (e *seedBusyError) Error() string {
+	return fmt.Sprintf("http seed is busy, retry in %v", e.retry)
+}
+
+type httpSeed struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSeed(seedURL string) *httpSeed {
+	return &httpSeed{url: seedURL, client: http.DefaultClient}
+}
+
+// this function fetches piece index, which has to be length bytes long
+func (s *httpSeed) fetchPiece(ctx context.Context, infoHash [20]byte, index, length int) ([]byte, error) {
+	sep := "?"
+	if strings.Contains(s.url, "?") {
+		sep = "&"
+	}
+	reqURL := s.url + sep + "info_hash=" + url.QueryEscape(string(infoHash[:])) + "&piece=" + strconv.Itoa(index)
+
+	ctx, cancel := context.WithTimeout(ctx, httpSeedTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64))
+		seconds, err := strconv.Atoi(strings.TrimSpace(string(body)))
+		if err != nil || seconds <= 0 {
+			seconds = int(httpSeedRetryDelay / time.Second)
+		}
+		return nil, &seedBusyError{retry: time.Duration(seconds) * time.Second}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http seed returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(length)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != length {
+		return nil, fmt.Errorf("http seed sent %d bytes for piece %d, expected %d", len(data), index, length)
+	}
+	return data, nil
+}
+
+// this function downloads pieces from a seed until the download stops or the seed keeps
+// failing. pieces are hashed here, so a seed serving bad data is noticed and given up on
+func (d *Download) runHTTPSeed(ctx context.Context, seed *httpSeed) {
+	defer d.wg.Done()
+	numPieces := d.Torrent.NumPieces()
+	all := NewBitfield(numPieces)
+	for i := 0; i < numPieces; i++ {
+		all.SetPiece(i)
+	}
+
+	failures := 0
+	for failures < maxHTTPSeedFailures {
+		wait := time.Duration(0)
+		index, ok := d.picker.Pick(all)
+		if !ok {
+			wait = httpSeedIdleWait
+		} else {
+			err := d.fetchFromSeed(ctx, seed, index)
+			var busy *seedBusyError
+			switch {
+			case err == nil:
+				failures = 0
+			case ctx.Err() != nil:
+				return
+			case errors.As(err, &busy):
+				wait = busy.retry
+			default:
+				failures++
+				wait = httpSeedRetryDelay << (failures - 1)
+			}
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// this function fetches, checks and stores one piece from a seed. the piece goes back to
+// the picker on any error
+func (d *Download) fetchFromSeed(ctx context.Context, seed *httpSeed, index int) error {
+	data, err := seed.fetchPiece(ctx, d.InfoHash, index, d.Torrent.PieceSize(index))
+	if err != nil {
+		d.picker.Abort(index)
+		return err
+	}
+
+	d.mu.Lock()
+	hasher := d.hasher
+	limiters := []*RateLimiter{d.downloadLimit}
+	if d.limits != nil {
+		limiters = append(limiters, d.limits.download)
+	}
+	d.downloaded += int64(len(data))
+	d.countTransfer(int64(len(data)), 0)
+	d.mu.Unlock()
+	// seeds don't go through a peer connection, so they are held to the limits here
+	waitAll(limiters, len(data))
+
+	verified, err := hasher.CheckPiece(ctx, d.Torrent, index, data)
+	if err != nil {
+		d.picker.Abort(index)
+		return err
+	}
+	ap := newActivePiece(index, len(data))
+	ap.data = data
+	for i := range ap.received {
+		ap.received[i] = true
+	}
+	ap.remaining = 0
+	d.finishPiece(ap, verified)
+	if !verified {
+		return fmt.Errorf("http seed sent bad data for piece %d", index)
+	}
+	return nil
+}
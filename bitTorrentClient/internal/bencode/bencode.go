@@ -0,0 +1,191 @@
+// Package bencode encodes and decodes bencode for the messages built and read in memory,
+// like DHT queries and extension messages, and is shared by the client and the DHT. Values
+// decode to string, int64, []interface{} and map[string]interface{}. Dictionary keys are
+// written sorted, as the format requires, so encoding the same value always gives the same
+// bytes
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Raw is a value that is bencoded already and written as is
+type Raw []byte
+
+// maxDepth bounds the nesting of lists and dictionaries a decoded value may have
+const maxDepth = 64
+
+var errTruncated = errors.New("bencode: truncated value")
+
+// Encode returns the bencoding of v
+func Encode(v interface{}) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Append appends the bencoding of v to b
+func Append(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		b = strconv.AppendInt(b, int64(len(v)), 10)
+		b = append(b, ':')
+		b = append(b, v...)
+	case []byte:
+		b = strconv.AppendInt(b, int64(len(v)), 10)
+		b = append(b, ':')
+		b = append(b, v...)
+	case int:
+		b = append(b, 'i')
+		b = strconv.AppendInt(b, int64(v), 10)
+		b = append(b, 'e')
+	case int64:
+		b = append(b, 'i')
+		b = strconv.AppendInt(b, v, 10)
+		b = append(b, 'e')
+	case Raw:
+		b = append(b, v...)
+	case []string:
+		b = append(b, 'l')
+		for _, item := range v {
+			b, _ = Append(b, item)
+		}
+		b = append(b, 'e')
+	case []interface{}:
+		b = append(b, 'l')
+		for _, item := range v {
+			b, err = Append(b, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b = append(b, 'e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, 'd')
+		for _, k := range keys {
+			b, _ = Append(b, k)
+			b, err = Append(b, v[k])
+			if err != nil {
+				return nil, err
+			}
+		}
+		b = append(b, 'e')
+	default:
+		return nil, fmt.Errorf("can't bencode %T", v)
+	}
+	return b, nil
+}
+
+// Decode decodes a single bencoded value from data, which must hold nothing else
+func Decode(data []byte) (interface{}, error) {
+	v, n, err := DecodePrefix(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("trailing data after bencoded value")
+	}
+	return v, nil
+}
+
+// DecodePrefix decodes the bencoded value data starts with and returns how many bytes it
+// took up
+func DecodePrefix(data []byte) (interface{}, int, error) {
+	return decode(data, 0, 0)
+}
+
+// this function decodes the value at data[i:] and returns the index after it
+func decode(data []byte, i, depth int) (interface{}, int, error) {
+	if i >= len(data) {
+		return nil, 0, errTruncated
+	}
+	switch ch := data[i]; {
+	case ch == 'i':
+		end := i + 1
+		for end < len(data) && data[end] != 'e' {
+			end++
+		}
+		if end == len(data) {
+			return nil, 0, errTruncated
+		}
+		n, err := strconv.ParseInt(string(data[i+1:end]), 10, 64)
+		if err != nil {
+			return nil, 0, err
+		}
+		return n, end + 1, nil
+	case ch >= '0' && ch <= '9':
+		s, next, err := decodeString(data, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		return s, next, nil
+	case ch == 'l', ch == 'd':
+		if depth == maxDepth {
+			return nil, 0, errors.New("bencode: value nested too deep")
+		}
+		var list []interface{}
+		var dict map[string]interface{}
+		if ch == 'd' {
+			dict = make(map[string]interface{})
+		}
+		i++
+		for {
+			if i >= len(data) {
+				return nil, 0, errTruncated
+			}
+			if data[i] == 'e' {
+				if dict != nil {
+					return dict, i + 1, nil
+				}
+				return list, i + 1, nil
+			}
+			var key string
+			if dict != nil {
+				var err error
+				key, i, err = decodeString(data, i)
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+			v, next, err := decode(data, i, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			i = next
+			if dict != nil {
+				dict[key] = v
+			} else {
+				list = append(list, v)
+			}
+		}
+	default:
+		return nil, 0, fmt.Errorf("unexpected character '%c'", ch)
+	}
+}
+
+// this function decodes the string at data[i:] and returns the index after it
+func decodeString(data []byte, i int) (string, int, error) {
+	colon := i
+	for colon < len(data) && data[colon] != ':' {
+		colon++
+	}
+	if colon == len(data) {
+		return "", 0, errTruncated
+	}
+	length, err := strconv.ParseInt(string(data[i:colon]), 10, 64)
+	if err != nil {
+		return "", 0, err
+	}
+	if length < 0 || length > int64(len(data)-colon-1) {
+		return "", 0, fmt.Errorf("invalid string length %d", length)
+	}
+	end := colon + 1 + int(length)
+	return string(data[colon+1 : end]), end, nil
+}
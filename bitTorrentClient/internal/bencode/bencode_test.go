@@ -0,0 +1,111 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{"spam", "4:spam"},
+		{[]byte{0, 0xff}, "2:\x00\xff"},
+		{"", "0:"},
+		{42, "i42e"},
+		{int64(-3), "i-3e"},
+		{[]string{"a", "bc"}, "l1:a2:bce"},
+		{[]interface{}{"a", int64(1), []interface{}{}}, "l1:ai1elee"},
+		// keys come out sorted whatever order the map has
+		{map[string]interface{}{"z": 1, "a": "x", "m": []interface{}{}}, "d1:a1:x1:mle1:zi1ee"},
+		{map[string]interface{}{"raw": Raw("i7e")}, "d3:rawi7ee"},
+	} {
+		got, err := Encode(tc.v)
+		if err != nil || string(got) != tc.want {
+			t.Errorf("Encode(%#v) = %q, %v, want %q", tc.v, got, err, tc.want)
+		}
+	}
+	if _, err := Encode(1.5); err == nil {
+		t.Error("a float was encoded")
+	}
+	if _, err := Encode(map[string]interface{}{"k": struct{}{}}); err == nil {
+		t.Error("a struct inside a dictionary was encoded")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		want interface{}
+	}{
+		{"4:spam", "spam"},
+		{"0:", ""},
+		{"i42e", int64(42)},
+		{"i-42e", int64(-42)},
+		{"le", []interface{}(nil)},
+		{"l4:spami1ee", []interface{}{"spam", int64(1)}},
+		{"de", map[string]interface{}{}},
+		{"d3:bar4:spam3:fooi42ee", map[string]interface{}{"bar": "spam", "foo": int64(42)}},
+		{"d1:ld1:xleee", map[string]interface{}{"l": map[string]interface{}{"x": []interface{}(nil)}}},
+	} {
+		got, err := Decode([]byte(tc.data))
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Decode(%q) = %#v, %v, want %#v", tc.data, got, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"i42",
+		"ie",
+		"i4x2e",
+		"4:spa",
+		"5",
+		"-1:x",
+		"999999999999:x",
+		"9223372036854775807:x",
+		"l4:spam",
+		"d3:foo",
+		"di1ei2ee",
+		"x",
+		"4:spamextra",
+		strings.Repeat("l", maxDepth+1) + strings.Repeat("e", maxDepth+1),
+	} {
+		if v, err := Decode([]byte(data)); err == nil {
+			t.Errorf("Decode(%q) = %#v", data, v)
+		}
+	}
+	if _, err := Decode([]byte(strings.Repeat("l", maxDepth) + strings.Repeat("e", maxDepth))); err != nil {
+		t.Errorf("nesting up to the limit: %v", err)
+	}
+}
+
+func TestDecodePrefix(t *testing.T) {
+	v, n, err := DecodePrefix([]byte("d1:ai1ee" + "trailing"))
+	if err != nil || n != len("d1:ai1ee") || !reflect.DeepEqual(v, map[string]interface{}{"a": int64(1)}) {
+		t.Errorf("DecodePrefix = %#v, %d, %v", v, n, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	v := map[string]interface{}{
+		"announce": "http://tracker/announce",
+		"info": map[string]interface{}{
+			"name":   "\x00binary\xff",
+			"length": int64(1 << 40),
+			"files":  []interface{}{map[string]interface{}{"path": []interface{}{"a", "b"}}},
+		},
+	}
+	data, err := Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(data)
+	if err != nil || !reflect.DeepEqual(got, v) {
+		t.Errorf("round trip gave %#v, %v", got, err)
+	}
+}
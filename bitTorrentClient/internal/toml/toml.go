@@ -0,0 +1,472 @@
+// Package toml reads the subset of TOML configuration files need: tables, key = value pairs
+// with bare or quoted keys, basic and literal strings, integers, floats, booleans and arrays
+// of them, which may span lines. Dates, inline tables, arrays of tables and multi-line
+// strings are refused with an error rather than misread. Keys come back flattened, a key
+// in [limits] is "limits.upload", each with the line it was set on for error messages
+package toml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Value is a parsed value: a string, int64, float64, bool or []any of those
+type Value struct {
+	V    any
+	Line int
+}
+
+// Parse parses a document into its values by dotted key
+func Parse(data []byte) (map[string]Value, error) {
+	p := &parser{values: make(map[string]Value), tables: make(map[string]bool)}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		p.line = i + 1
+		text := strings.TrimSpace(lines[i])
+		if !utf8.ValidString(text) {
+			return nil, p.errorf("invalid UTF-8")
+		}
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			err := p.table(text)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// an array may go on over the following lines until its brackets close
+		for open := unclosedBrackets(text); open > 0 && i+1 < len(lines); open = unclosedBrackets(text) {
+			i++
+			text += "\n" + lines[i]
+		}
+		err := p.keyValue(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.values, nil
+}
+
+type parser struct {
+	values map[string]Value
+	tables map[string]bool
+	// prefix is the current table's key with a trailing dot, empty at the top
+	prefix string
+	line   int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// this function starts the table a [header] names
+func (p *parser) table(text string) error {
+	if strings.HasPrefix(text, "[[") {
+		return p.errorf("arrays of tables are not supported")
+	}
+	s := &scanner{s: text[1:]}
+	var parts []string
+	for {
+		s.skipSpace()
+		key, err := s.key()
+		if err != nil {
+			return p.errorf("%v", err)
+		}
+		parts = append(parts, key)
+		s.skipSpace()
+		if s.peek() != '.' {
+			break
+		}
+		s.pos++
+	}
+	if s.peek() != ']' {
+		return p.errorf("expected ] to close the table header")
+	}
+	s.pos++
+	if err := s.end(); err != nil {
+		return p.errorf("%v", err)
+	}
+	name := strings.Join(parts, ".")
+	if p.tables[name] {
+		return p.errorf("table [%s] is defined twice", name)
+	}
+	p.tables[name] = true
+	p.prefix = name + "."
+	return nil
+}
+
+// this function parses a key = value line
+func (p *parser) keyValue(text string) error {
+	s := &scanner{s: text}
+	var parts []string
+	for {
+		s.skipSpace()
+		key, err := s.key()
+		if err != nil {
+			return p.errorf("%v", err)
+		}
+		parts = append(parts, key)
+		s.skipSpace()
+		if s.peek() != '.' {
+			break
+		}
+		s.pos++
+	}
+	if s.peek() != '=' {
+		return p.errorf("expected = after the key %q", strings.Join(parts, "."))
+	}
+	s.pos++
+	s.skipSpace()
+	v, err := s.value()
+	if err != nil {
+		return p.errorf("%s: %v", strings.Join(parts, "."), err)
+	}
+	if err := s.end(); err != nil {
+		return p.errorf("%v", err)
+	}
+	key := p.prefix + strings.Join(parts, ".")
+	if prev, ok := p.values[key]; ok {
+		return p.errorf("%s is already set on line %d", key, prev.Line)
+	}
+	p.values[key] = Value{V: v, Line: p.line}
+	return nil
+}
+
+// unclosedBrackets counts the [ in text that aren't closed, outside strings and comments
+func unclosedBrackets(text string) int {
+	open := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '[':
+			open++
+		case c == ']':
+			open--
+		}
+	}
+	return open
+}
+
+type scanner struct {
+	s   string
+	pos int
+}
+
+func (s *scanner) peek() byte {
+	if s.pos >= len(s.s) {
+		return 0
+	}
+	return s.s[s.pos]
+}
+
+// skipSpace skips spaces and tabs
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.s) && (s.s[s.pos] == ' ' || s.s[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+// skipSpaceAndLines skips the newlines and comments inside an array as well
+func (s *scanner) skipSpaceAndLines() {
+	for {
+		s.skipSpace()
+		switch s.peek() {
+		case '\n':
+			s.pos++
+		case '#':
+			for s.pos < len(s.s) && s.s[s.pos] != '\n' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// end checks that only a comment follows
+func (s *scanner) end() error {
+	s.skipSpace()
+	if s.pos < len(s.s) && s.s[s.pos] != '#' {
+		return fmt.Errorf("unexpected %q after the value", s.s[s.pos:])
+	}
+	return nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (s *scanner) key() (string, error) {
+	switch s.peek() {
+	case '"':
+		return s.basicString()
+	case '\'':
+		return s.literalString()
+	}
+	start := s.pos
+	for s.pos < len(s.s) && isBareKeyChar(s.s[s.pos]) {
+		s.pos++
+	}
+	if s.pos == start {
+		return "", fmt.Errorf("expected a key at %q", s.s[start:])
+	}
+	return s.s[start:s.pos], nil
+}
+
+func (s *scanner) value() (any, error) {
+	switch c := s.peek(); {
+	case c == '"':
+		if strings.HasPrefix(s.s[s.pos:], `"""`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return s.basicString()
+	case c == '\'':
+		if strings.HasPrefix(s.s[s.pos:], "'''") {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return s.literalString()
+	case c == '[':
+		return s.array()
+	case c == '{':
+		return nil, fmt.Errorf("inline tables are not supported")
+	case c == 0:
+		return nil, fmt.Errorf("missing value")
+	}
+	start := s.pos
+	for s.pos < len(s.s) && !strings.ContainsRune(" \t\n,]#", rune(s.s[s.pos])) {
+		s.pos++
+	}
+	word := s.s[start:s.pos]
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, ok, err := integer(word); ok {
+		return n, err
+	}
+	if f, ok := float(word); ok {
+		return f, nil
+	}
+	if len(word) >= 10 && word[4] == '-' && word[7] == '-' {
+		return nil, fmt.Errorf("dates are not supported")
+	}
+	return nil, fmt.Errorf("%q is not a string, number or boolean, strings need quotes", word)
+}
+
+// this function parses word as an integer: decimal with an optional sign and no leading
+// zeros, or hexadecimal, octal or binary behind 0x, 0o or 0b. ok is false when word isn't
+// written like one, err is set when it is but doesn't fit in an int64
+func integer(word string) (n int64, ok bool, err error) {
+	base, digits, sign := 10, word, ""
+	if len(word) > 2 && word[0] == '0' {
+		switch word[1] {
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if base != 10 {
+			digits = word[2:]
+		}
+	}
+	if base == 10 {
+		digits = trimSign(word)
+		sign = word[:len(word)-len(digits)]
+	}
+	if !validDigits(digits, base) {
+		return 0, false, nil
+	}
+	digits = strings.ReplaceAll(digits, "_", "")
+	if base == 10 && len(digits) > 1 && digits[0] == '0' {
+		return 0, false, nil
+	}
+	n, err = strconv.ParseInt(sign+digits, base, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("integer %s is out of range", word)
+	}
+	return n, true, nil
+}
+
+// this function parses word as a float: an integer part without leading zeros followed by a
+// fraction, an exponent or both, or inf or nan, all with an optional sign
+func float(word string) (float64, bool) {
+	body := trimSign(word)
+	switch body {
+	case "inf":
+		if word[0] == '-' {
+			return math.Inf(-1), true
+		}
+		return math.Inf(1), true
+	case "nan":
+		return math.NaN(), true
+	}
+	mantissa, exponent, hasExponent := strings.Cut(strings.ReplaceAll(body, "E", "e"), "e")
+	whole, fraction, hasFraction := strings.Cut(mantissa, ".")
+	if !hasFraction && !hasExponent {
+		return 0, false
+	}
+	if !validDigits(whole, 10) || len(whole) > 1 && whole[0] == '0' {
+		return 0, false
+	}
+	if hasFraction && !validDigits(fraction, 10) {
+		return 0, false
+	}
+	if hasExponent {
+		if !validDigits(trimSign(exponent), 10) {
+			return 0, false
+		}
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64)
+	return f, err == nil
+}
+
+// this function returns s without a leading + or -
+func trimSign(s string) string {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		return s[1:]
+	}
+	return s
+}
+
+// this function reports whether s is digits of base, with single underscores between them
+func validDigits(s string, base int) bool {
+	if s == "" || s[0] == '_' || s[len(s)-1] == '_' || strings.Contains(s, "__") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' {
+			continue
+		}
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c >= 'a' && c <= 'f':
+			d = int(c-'a') + 10
+		case c >= 'A' && c <= 'F':
+			d = int(c-'A') + 10
+		default:
+			return false
+		}
+		if d >= base {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *scanner) array() ([]any, error) {
+	s.pos++ // [
+	list := []any{}
+	for {
+		s.skipSpaceAndLines()
+		if s.peek() == ']' {
+			s.pos++
+			return list, nil
+		}
+		v, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		s.skipSpaceAndLines()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return list, nil
+		default:
+			return nil, fmt.Errorf("expected , or ] in the array")
+		}
+	}
+}
+
+func (s *scanner) literalString() (string, error) {
+	s.pos++ // '
+	end := strings.IndexAny(s.s[s.pos:], "'\n")
+	if end < 0 || s.s[s.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	str := s.s[s.pos : s.pos+end]
+	s.pos += end + 1
+	return str, nil
+}
+
+func (s *scanner) basicString() (string, error) {
+	s.pos++ // "
+	var b strings.Builder
+	for s.pos < len(s.s) {
+		c := s.s[s.pos]
+		switch c {
+		case '"':
+			s.pos++
+			return b.String(), nil
+		case '\n':
+			return "", fmt.Errorf("unterminated string")
+		case '\\':
+			if s.pos+1 >= len(s.s) {
+				return "", fmt.Errorf("unterminated string")
+			}
+			esc := s.s[s.pos+1]
+			s.pos += 2
+			switch esc {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(esc)
+			case 'u', 'U':
+				size := 4
+				if esc == 'U' {
+					size = 8
+				}
+				if s.pos+size > len(s.s) {
+					return "", fmt.Errorf("short unicode escape")
+				}
+				r, err := strconv.ParseUint(s.s[s.pos:s.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", fmt.Errorf("invalid unicode escape \\%c%s", esc, s.s[s.pos:s.pos+size])
+				}
+				b.WriteRune(rune(r))
+				s.pos += size
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+			s.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,128 @@
+package toml
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseValues(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want any
+	}{
+		{"42", int64(42)},
+		{"+42", int64(42)},
+		{"-42", int64(-42)},
+		{"0", int64(0)},
+		{"-0", int64(0)},
+		{"1_000_000", int64(1000000)},
+		{"0x1F", int64(31)},
+		{"0xdead_beef", int64(0xdeadbeef)},
+		{"0o644", int64(0o644)},
+		{"0b1010", int64(10)},
+		{"9223372036854775807", int64(math.MaxInt64)},
+		{"-9223372036854775808", int64(math.MinInt64)},
+		{"1.5", 1.5},
+		{"-0.25", -0.25},
+		{"1e3", 1000.0},
+		{"6.02E+23", 6.02e23},
+		{"1_000.5", 1000.5},
+		{"0.5", 0.5},
+		{"inf", math.Inf(1)},
+		{"-inf", math.Inf(-1)},
+		{"true", true},
+		{"false", false},
+		{`"a \"quoted\" \u00e9"`, `a "quoted" é`},
+		{`'C:\path'`, `C:\path`},
+		{"[1, 2, 3]", []any{int64(1), int64(2), int64(3)}},
+		{"[\n  \"a\",\n  \"b\",\n]", []any{"a", "b"}},
+	} {
+		values, err := Parse([]byte("key = " + tc.text))
+		if err != nil {
+			t.Errorf("%s: %v", tc.text, err)
+			continue
+		}
+		if got := values["key"].V; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s parsed as %#v, want %#v", tc.text, got, tc.want)
+		}
+	}
+
+	values, err := Parse([]byte("key = nan"))
+	if f, ok := values["key"].V.(float64); err != nil || !ok || !math.IsNaN(f) {
+		t.Errorf("nan parsed as %#v (%v)", values["key"].V, err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, text := range []string{
+		// a leading zero reads as octal in some languages, TOML doesn't allow it
+		"0644",
+		"-0644",
+		"00",
+		"01.5",
+		"0X1F",
+		"+0x1F",
+		"0x",
+		"0b102",
+		"0o8",
+		"1__000",
+		"_1000",
+		"1000_",
+		"1_.5",
+		"1.",
+		".5",
+		"1e",
+		"1e_3",
+		"9223372036854775808",
+		"0x8000000000000000",
+		"infinity",
+		"NaN",
+		"1979-05-27",
+		"bare",
+		`"unterminated`,
+		`"""multi"""`,
+		"{a = 1}",
+	} {
+		if values, err := Parse([]byte("key = " + text)); err == nil {
+			t.Errorf("%s parsed as %#v", text, values["key"].V)
+		}
+	}
+}
+
+func TestParseTables(t *testing.T) {
+	values, err := Parse([]byte(`
+# comment
+top = 1
+[limits]
+upload = 100 # trailing comment
+"quoted key" = "x"
+[a.b]
+c.d = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Value{
+		"top":               {int64(1), 3},
+		"limits.upload":     {int64(100), 5},
+		"limits.quoted key": {"x", 6},
+		"a.b.c.d":           {true, 8},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parsed %v, want %v", values, want)
+	}
+
+	for _, doc := range []string{
+		"a = 1\na = 2",
+		"[t]\n[t]",
+		"[[t]]",
+		"a = 1 b",
+		"= 1",
+		"a 1",
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%q parsed", doc)
+		}
+	}
+}
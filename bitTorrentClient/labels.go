@@ -0,0 +1,101 @@
+// This file lets torrents carry labels, free form names like "linux" or "tv" that group
+// them for whoever drives the client. Labels are trimmed, case is kept, and each is held
+// once in sorted order. A label can come with defaults, see WithLabelDefaults, that a
+// torrent added with it gets unless it was given settings of its own
+package bittorrentclient
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// LabelDefaults are the settings of torrents added with a label. Zero values leave the
+// client's settings alone
+type LabelDefaults struct {
+	// Dir is where the torrents are saved unless they are added WithSaveDir
+	Dir           string
+	RatioLimit    float64
+	SeedTimeLimit time.Duration
+}
+
+// Labels returns the download's labels in sorted order
+func (d *Download) Labels() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.labels)
+}
+
+// SetLabels replaces the download's labels, empty ones are dropped
+func (d *Download) SetLabels(labels []string) {
+	clean := normalizeLabels(labels)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.labels = clean
+}
+
+// HasLabel reports whether the download has label
+func (d *Download) HasLabel(label string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, found := slices.BinarySearch(d.labels, strings.TrimSpace(label))
+	return found
+}
+
+// this function trims, sorts and dedupes labels
+func normalizeLabels(labels []string) []string {
+	var clean []string
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label != "" {
+			clean = append(clean, label)
+		}
+	}
+	slices.Sort(clean)
+	return slices.Compact(clean)
+}
+
+// Labels returns every label a torrent of the session has, sorted
+func (c *Client) Labels() []string {
+	var labels []string
+	for _, d := range c.Torrents() {
+		labels = append(labels, d.Labels()...)
+	}
+	slices.Sort(labels)
+	return slices.Compact(labels)
+}
+
+// TorrentsLabelled returns the downloads that have label, in the order they were added
+func (c *Client) TorrentsLabelled(label string) []*Download {
+	var list []*Download
+	for _, d := range c.Torrents() {
+		if d.HasLabel(label) {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// this function returns the defaults of the first of labels that has any
+func (c *Client) labelDefaults(labels []string) (LabelDefaults, bool) {
+	for _, label := range normalizeLabels(labels) {
+		if defaults, ok := c.labelSettings[label]; ok {
+			return defaults, true
+		}
+	}
+	return LabelDefaults{}, false
+}
+
+// this function gives a download just made the limits of its labels' defaults
+func (c *Client) applyLabelDefaults(d *Download, labels []string) {
+	defaults, ok := c.labelDefaults(labels)
+	if !ok {
+		return
+	}
+	if defaults.RatioLimit > 0 {
+		d.SetRatioLimit(defaults.RatioLimit)
+	}
+	if defaults.SeedTimeLimit > 0 {
+		d.SetSeedTimeLimit(defaults.SeedTimeLimit)
+	}
+}
@@ -0,0 +1,191 @@
+// This file sets up the client's structured logging. Everything logs through the one
+// slog.Logger given with WithLogger, each part of the client under a "component" attribute
+// (see the Log constants) and a download's logs with its infohash and name, a peer's with
+// its address. The level is set per component, so the DHT can be debugged without drowning
+// in piece traffic: LogLevels holds the levels and can change them while the client runs,
+// components without a level of their own log at the level of the logger's handler
+package bittorrentclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// the components the client logs under
+const (
+	LogClient   = "client"
+	LogDownload = "download"
+	LogPeer     = "peer"
+	LogTracker  = "tracker"
+	LogDHT      = "dht"
+	LogDisk     = "disk"
+	LogWatch    = "watch"
+	LogHooks    = "hooks"
+)
+
+// LogLevels holds the minimum level of each component's logs, it is safe for concurrent
+// use. The level of the empty component applies to every component without one of its own
+type LogLevels struct {
+	mu     sync.RWMutex
+	levels map[string]slog.Level
+}
+
+func NewLogLevels(levels map[string]slog.Level) *LogLevels {
+	l := &LogLevels{levels: make(map[string]slog.Level)}
+	for component, level := range levels {
+		l.levels[component] = level
+	}
+	return l
+}
+
+// Set makes component log at level and above
+func (l *LogLevels) Set(component string, level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.levels[component] = level
+}
+
+// Reset makes component log at the default level again
+func (l *LogLevels) Reset(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.levels, component)
+}
+
+// Level returns the level component logs at, ok is false when neither it nor the empty
+// component has one and the handler decides
+func (l *LogLevels) Level(component string) (level slog.Level, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok = l.levels[component]; ok {
+		return level, true
+	}
+	level, ok = l.levels[""]
+	return level, ok
+}
+
+// ParseLogLevels parses levels given as a comma separated list of component=level pairs,
+// a level without a component is the default one, e.g. "warn,dht=debug,tracker=info"
+func ParseLogLevels(spec string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, name, found := strings.Cut(part, "=")
+		if !found {
+			component, name = "", part
+		}
+		var level slog.Level
+		err := level.UnmarshalText([]byte(name))
+		if err != nil {
+			return nil, fmt.Errorf("log level %q: %w", part, err)
+		}
+		levels[strings.TrimSpace(component)] = level
+	}
+	return levels, nil
+}
+
+// componentHandler filters records by the level of the component they are logged under.
+// The component is picked up from the attributes added with Logger.With
+type componentHandler struct {
+	next      slog.Handler
+	levels    *LogLevels
+	component string
+}
+
+func newComponentHandler(next slog.Handler, levels *LogLevels) *componentHandler {
+	return &componentHandler{next: next, levels: levels}
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if threshold, ok := h.levels.Level(h.component); ok {
+		return level >= threshold
+	}
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == "component" {
+			c.component = a.Value.String()
+		}
+	}
+	return &c
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	return &c
+}
+
+// componentLoggers holds a logger for each of the components a download logs under, made
+// once so logging doesn't build one for every line
+type componentLoggers map[string]*slog.Logger
+
+// SetLogger makes the download log to logger, under its components. The client passes
+// its logger with the download's infohash and name attached, nil logs nothing
+func (d *Download) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	loggers := make(componentLoggers)
+	for _, component := range []string{LogDownload, LogPeer, LogTracker, LogDisk} {
+		loggers[component] = logger.With("component", component)
+	}
+	d.loggers.Store(&loggers)
+}
+
+// this function returns the download's logger for component. it doesn't take d.mu
+func (d *Download) log(component string) *slog.Logger {
+	return (*d.loggers.Load())[component]
+}
+
+// this function logs an event the download emits, at the level and under the component
+// that fits its type
+func (d *Download) logEvent(ev Event) {
+	switch ev.Type {
+	case EventStateChanged:
+		if ev.State == DownloadError {
+			d.log(LogDownload).Error("download failed", "err", ev.Err)
+		} else {
+			d.log(LogDownload).Info("state changed", "state", ev.State)
+		}
+	case EventTorrentCompleted:
+		d.log(LogDownload).Info("download complete")
+	case EventFileCompleted:
+		d.log(LogDownload).Debug("file complete", "file", ev.File)
+	case EventPieceCompleted:
+		d.log(LogDownload).Debug("piece complete", "piece", ev.Piece)
+	case EventHashFailed:
+		d.log(LogDownload).Warn("piece failed its hash check", "piece", ev.Piece)
+	case EventTrackerError:
+		d.log(LogTracker).Warn("announce failed", "err", ev.Err)
+	case EventPeerConnected:
+		d.log(LogPeer).Debug("peer connected", "peer", ev.Peer)
+	case EventPeerBanned:
+		d.log(LogPeer).Info("peer banned", "peer", ev.Peer)
+	case EventResumeFailed:
+		d.log(LogDownload).Warn("resume data not used", "err", ev.Err)
+	case EventSeedLimitReached:
+		d.log(LogDownload).Info("seed limit reached")
+	case EventFilesMoved:
+		d.log(LogDisk).Info("files moved")
+	case EventDiskFull:
+		d.log(LogDisk).Error("disk full", "err", ev.Err)
+	case EventStorageError:
+		d.log(LogDisk).Error("storage error", "err", ev.Err)
+	}
+}
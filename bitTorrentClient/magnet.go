@@ -0,0 +1,359 @@
+// This file starts downloads from magnet links (BEP 9). A magnet link carries little more
+// than the infohash, so the torrent's info dictionary has to come from the swarm first:
+// peers are gathered from the link's x.pe addresses, its trackers and the DHT, dialed a few
+// at a time, and asked for the metadata over ut_metadata until one of them hands over a
+// dictionary whose hash matches. That dictionary is turned into a regular torrent and the
+// download starts on it, with the peers found so far already known
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"mybittorrent/dht"
+)
+
+const (
+	// maxMetadataFetches is how many peers are asked for the metadata at the same time
+	maxMetadataFetches = 8
+	// magnetLookupInterval is the wait between DHT lookups while no peer sent the metadata
+	magnetLookupInterval = 10 * time.Second
+)
+
+type Magnet struct {
+	InfoHash [20]byte
+	// Name is the display name, only a hint until the metadata arrived
+	Name     string
+	Trackers []string
+	// Peers are the host:port addresses given with x.pe
+	Peers []string
+}
+
+// ParseMagnet parses a magnet link with a BitTorrent infohash, given as 40 hex digits or
+// 32 base32 characters
+func ParseMagnet(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("not a magnet link: %q", uri)
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	m := &Magnet{
+		Name:     query.Get("dn"),
+		Trackers: query["tr"],
+		Peers:    query["x.pe"],
+	}
+	found := false
+	for _, xt := range query["xt"] {
+		hash, ok := strings.CutPrefix(xt, "urn:btih:")
+		if !ok {
+			continue
+		}
+		var raw []byte
+		switch len(hash) {
+		case 40:
+			raw, err = hex.DecodeString(hash)
+		case 32:
+			raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		default:
+			err = fmt.Errorf("infohash %q has the wrong length", hash)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid infohash: %w", err)
+		}
+		copy(m.InfoHash[:], raw)
+		found = true
+		break
+	}
+	if !found {
+		return nil, errors.New("magnet link has no btih infohash")
+	}
+	return m, nil
+}
+
+// String returns the magnet link, the infohash in hex
+func (m *Magnet) String() string {
+	var b strings.Builder
+	b.WriteString("magnet:?xt=urn:btih:")
+	b.WriteString(hex.EncodeToString(m.InfoHash[:]))
+	if m.Name != "" {
+		b.WriteString("&dn=" + url.QueryEscape(m.Name))
+	}
+	for _, tracker := range m.Trackers {
+		b.WriteString("&tr=" + url.QueryEscape(tracker))
+	}
+	for _, peer := range m.Peers {
+		b.WriteString("&x.pe=" + url.QueryEscape(peer))
+	}
+	return b.String()
+}
+
+// Magnet returns a magnet link for the torrent with its name and every tracker
+func (t *Torrent) Magnet() *Magnet {
+	m := &Magnet{InfoHash: t.InfoHash, Name: t.Info.Name}
+	seen := make(map[string]bool)
+	add := func(tracker string) {
+		if tracker != "" && !seen[tracker] {
+			seen[tracker] = true
+			m.Trackers = append(m.Trackers, tracker)
+		}
+	}
+	add(t.Announce)
+	for _, tier := range t.AnnounceList {
+		for _, tracker := range tier {
+			add(tracker)
+		}
+	}
+	return m
+}
+
+// MagnetOptions configures how AddMagnet finds peers and the download it starts
+type MagnetOptions struct {
+	// DHT is searched for peers and set on the download, nil keeps to trackers and x.pe
+	DHT *dht.Node
+	// Dialer makes the peer connections, nil uses a plain TCP dialer
+	Dialer *PeerDialer
+	// Port is the port announced to trackers and the DHT, zero means DefaultPort
+	Port int
+	// PeerIDPrefix starts the peer ids used for the metadata and the download, empty uses
+	// our own client code
+	PeerIDPrefix string
+}
+
+// magnetPeer is an address found while looking for the metadata
+type magnetPeer struct {
+	addr   string
+	source PeerSource
+}
+
+// AddMagnet fetches the metadata of the magnet link uri from the swarm, checks it against
+// the infohash and starts downloading the torrent into dir. Without a DHT it fails once
+// every peer from the link and its trackers was tried, with one it keeps looking until ctx
+// is done
+func AddMagnet(ctx context.Context, uri, dir string, opts MagnetOptions) (*Download, error) {
+	m, err := ParseMagnet(uri)
+	if err != nil {
+		return nil, err
+	}
+	d, err := m.download(ctx, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Start(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// this function fetches the metadata and prepares the download of the torrent into dir,
+// with the peers that were found along the way queued up. it isn't started
+func (m *Magnet) download(ctx context.Context, dir string, opts MagnetOptions) (*Download, error) {
+	if opts.Dialer == nil {
+		opts.Dialer = NewPeerDialer(nil)
+	}
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+	info, peers, err := m.fetchInfo(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := m.torrent(info)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := NewDownload(t, dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PeerIDPrefix != "" {
+		d.PeerID, err = newPeerID(opts.PeerIDPrefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d.Port = opts.Port
+	d.SetDialer(opts.Dialer)
+	d.SetDHT(opts.DHT)
+	for _, p := range peers {
+		d.addPeers(p.source, p.addr)
+	}
+	return d, nil
+}
+
+// this function builds the torrent from the fetched info dictionary. it goes through
+// DecodeTorrent so the torrent is exactly what a .torrent file with the same info would give
+func (m *Magnet) torrent(info []byte) (*Torrent, error) {
+	dict := map[string]interface{}{"info": rawBencode(info), "announce": ""}
+	if len(m.Trackers) > 0 {
+		dict["announce"] = m.Trackers[0]
+	}
+	if len(m.Trackers) > 1 {
+		var tiers []interface{}
+		for _, tr := range m.Trackers {
+			tiers = append(tiers, []string{tr})
+		}
+		dict["announce-list"] = tiers
+	}
+	raw, err := encodeBencode(dict)
+	if err != nil {
+		return nil, err
+	}
+	t, err := DecodeTorrent(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	if t.InfoHash != m.InfoHash {
+		// a v2 info dictionary hashes differently, we only fetch by v1 infohash
+		return nil, errors.New("metadata doesn't match the infohash")
+	}
+	return t, nil
+}
+
+// this function asks the peers it finds for the info dictionary until one sends it. it
+// returns the dictionary with every peer found along the way
+func (m *Magnet) fetchInfo(ctx context.Context, opts MagnetOptions) ([]byte, []magnetPeer, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	prefix := opts.PeerIDPrefix
+	if prefix == "" {
+		prefix = peerIDPrefix
+	}
+	peerID, err := newPeerID(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := m.findPeers(ctx, opts, peerID)
+	type result struct {
+		info []byte
+		err  error
+	}
+	results := make(chan result)
+	var peers, queue []magnetPeer
+	seen := make(map[string]bool)
+	inflight := 0
+	var lastErr error
+	for {
+		for inflight < maxMetadataFetches && len(queue) > 0 {
+			addr := queue[0].addr
+			queue = queue[1:]
+			inflight++
+			go func() {
+				info, err := fetchMetadataFrom(ctx, opts.Dialer, addr, m.InfoHash, peerID)
+				select {
+				case results <- result{info, err}:
+				case <-ctx.Done():
+				}
+			}()
+		}
+		if found == nil && inflight == 0 {
+			if lastErr == nil {
+				return nil, nil, errors.New("no peers found for the magnet link")
+			}
+			return nil, nil, fmt.Errorf("no peer sent the metadata: %w", lastErr)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case p, ok := <-found:
+			if !ok {
+				found = nil
+				continue
+			}
+			if seen[p.addr] {
+				continue
+			}
+			seen[p.addr] = true
+			peers = append(peers, p)
+			queue = append(queue, p)
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				return res.info, peers, nil
+			}
+			lastErr = res.err
+		}
+	}
+}
+
+// this function sends the peers of the magnet link's sources on the returned channel, which
+// is closed once they are exhausted. with a DHT that is only when ctx is done
+func (m *Magnet) findPeers(ctx context.Context, opts MagnetOptions, peerID [20]byte) <-chan magnetPeer {
+	found := make(chan magnetPeer)
+	send := func(source PeerSource, addrs []string) {
+		for _, addr := range addrs {
+			select {
+			case found <- magnetPeer{addr, source}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	var sources []func()
+	if len(m.Peers) > 0 {
+		sources = append(sources, func() { send(PeerSourceManual, m.Peers) })
+	}
+	for _, tr := range m.Trackers {
+		sources = append(sources, func() {
+			a := NewTorrentAnnouncer(&Torrent{Announce: tr, InfoHash: m.InfoHash}, peerID, opts.Dialer.announcePort(opts.Port))
+			// a tracker takes a peer with nothing left for a seed, and seeds get no seeds back
+			a.SetProgress(0, 0, 1)
+			res, err := a.Announce(ctx)
+			if err == nil {
+				send(PeerSourceTracker, res.Peers)
+			}
+		})
+	}
+	if opts.DHT != nil {
+		sources = append(sources, func() {
+			for {
+				peers, _ := opts.DHT.GetPeers(ctx, m.InfoHash)
+				send(PeerSourceDHT, peers)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(magnetLookupInterval):
+				}
+			}
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			source()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+	return found
+}
+
+// this function dials addr and fetches the info dictionary of infoHash from it
+func fetchMetadataFrom(ctx context.Context, dialer *PeerDialer, addr string, infoHash, peerID [20]byte) ([]byte, error) {
+	p, err := DialPeer(ctx, dialer, addr, infoHash, peerID)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	return FetchMetadata(ctx, p, infoHash)
+}
@@ -0,0 +1,225 @@
+// This file implements BitTorrent v2 (BEP 52) piece verification. A v2 torrent hashes every
+// file on its own as a SHA-256 merkle tree over 16 KiB blocks, and pieces never span two
+// files. Such torrents are laid out here like a hybrid torrent, every file but the last one
+// followed by a padding file up to the next piece boundary, so storage, the picker and file
+// progress see the same piece-aligned geometry as for v1. Only the hashing differs: a v2
+// piece is checked against its file's piece layer, or against the file's pieces root for
+// files no larger than one piece
+package bittorrentclient
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// merkleBlockSize is the size of the blocks v2 hashes its merkle tree leaves over
+const merkleBlockSize = 16 * 1024
+
+// IsV2 reports whether the torrent carries v2 hashes, either on its own or as a hybrid that
+// also has v1 piece hashes
+func (t *Torrent) IsV2() bool {
+	return t.Info.MetaVersion == 2
+}
+
+// IsHybrid reports whether the torrent has both v1 and v2 hashes. Pieces of a hybrid torrent
+// have to match both
+func (t *Torrent) IsHybrid() bool {
+	return t.IsV2() && len(t.Info.Pieces) > 0
+}
+
+// this function checks data against the hashes of piece index, the SHA-1 for v1 and the
+// merkle tree for v2. data is the whole piece as laid out, including any padding at its end
+func (t *Torrent) checkPiece(index int, data []byte) bool {
+	if len(t.Info.Pieces) > 0 {
+		sum := sha1.Sum(data)
+		if !bytes.Equal(sum[:], t.PieceHash(index)) {
+			return false
+		}
+	}
+	if t.IsV2() {
+		want, leaves, n, ok := t.v2PieceHash(index)
+		if !ok || n > len(data) {
+			return false
+		}
+		root := merkleRoot(blockHashes(data[:n]), leaves, [32]byte{})
+		return bytes.Equal(root[:], want)
+	}
+	return true
+}
+
+// this function returns what the v2 merkle tree of piece index has to hash to, how many
+// leaves that tree has and how many bytes of file data the piece holds. the rest of the
+// piece, if any, is padding which v2 doesn't hash
+func (t *Torrent) v2PieceHash(index int) (want []byte, leaves, n int, ok bool) {
+	spans := t.files()
+	start := int64(index) * t.Info.PieceLength
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].Offset+spans[i].Length > start })
+	if i == len(spans) || spans[i].Padding || spans[i].Offset > start {
+		return nil, 0, 0, false
+	}
+	f := spans[i]
+	root := t.Info.piecesRoot(f.Path)
+	if root == nil {
+		return nil, 0, 0, false
+	}
+	n = int(min(t.Info.PieceLength, f.Offset+f.Length-start))
+	if f.Length <= t.Info.PieceLength {
+		// a file of a single piece is hashed up to the next power of two blocks, and its
+		// root is the pieces root itself
+		return root, nextPowerOfTwo(blockCount(int64(n))), n, true
+	}
+	layer := t.PieceLayers[string(root)]
+	k := int((start - f.Offset) / t.Info.PieceLength)
+	if len(layer) < (k+1)*sha256.Size {
+		return nil, 0, 0, false
+	}
+	return layer[k*sha256.Size : (k+1)*sha256.Size], int(t.Info.PieceLength / merkleBlockSize), n, true
+}
+
+// this function returns the pieces root of the v2 file at path, nil for empty files and
+// paths the file tree doesn't have
+func (info *TorrentInfo) piecesRoot(path []string) []byte {
+	for _, f := range info.FileTree {
+		if slices.Equal(f.Path, path) {
+			return f.PiecesRoot
+		}
+	}
+	return nil
+}
+
+// this function hashes data in merkle blocks, the last block may be short
+func blockHashes(data []byte) [][32]byte {
+	hashes := make([][32]byte, 0, blockCount(int64(len(data))))
+	for len(data) > 0 {
+		block := data[:min(len(data), merkleBlockSize)]
+		hashes = append(hashes, sha256.Sum256(block))
+		data = data[len(block):]
+	}
+	return hashes
+}
+
+func blockCount(length int64) int {
+	return int((length + merkleBlockSize - 1) / merkleBlockSize)
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// this function computes the root of a merkle tree with width leaves, a power of two. the
+// leaves past the given hashes are pad, which for a whole layer past the end of a file is
+// the root of a subtree of zero leaves
+func merkleRoot(hashes [][32]byte, width int, pad [32]byte) [32]byte {
+	layer := make([][32]byte, width)
+	copy(layer, hashes)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+	var buf [64]byte
+	for len(layer) > 1 {
+		for i := 0; i < len(layer)/2; i++ {
+			copy(buf[:32], layer[2*i][:])
+			copy(buf[32:], layer[2*i+1][:])
+			layer[i] = sha256.Sum256(buf[:])
+		}
+		layer = layer[:len(layer)/2]
+	}
+	return layer[0]
+}
+
+// this function returns the root of a subtree of leaves zero leaves, which stands in for
+// pieces past the end of a file in the piece layer
+func zeroSubtree(leaves int) [32]byte {
+	return merkleRoot(nil, leaves, [32]byte{})
+}
+
+// this function checks the v2 parts of the metainfo: the piece length, and that every piece
+// layer hashes up to its file's pieces root. without the layers pieces of large files can't
+// be verified, so they are required
+func (t *Torrent) validateV2() error {
+	plen := t.Info.PieceLength
+	if plen < merkleBlockSize || plen&(plen-1) != 0 {
+		return fmt.Errorf("v2 piece length %d is not a power of two of at least 16 KiB", plen)
+	}
+	if len(t.Info.FileTree) == 0 {
+		return errors.New("v2 torrent has an empty file tree")
+	}
+	// the v1 half of a hybrid torrent has to put every v2 file on a piece boundary
+	spans := make(map[string]fileSpan)
+	for _, f := range t.files() {
+		if !f.Padding {
+			spans[strings.Join(f.Path, "/")] = f
+		}
+	}
+	for _, f := range t.Info.FileTree {
+		span, ok := spans[strings.Join(f.Path, "/")]
+		if !ok || span.Length != f.Length {
+			return fmt.Errorf("file %v of the file tree doesn't match the file list", f.Path)
+		}
+		if f.Length > 0 && span.Offset%plen != 0 {
+			return fmt.Errorf("file %v doesn't start on a piece boundary", f.Path)
+		}
+	}
+	pad := zeroSubtree(int(plen / merkleBlockSize))
+	for _, f := range t.Info.FileTree {
+		if f.Length == 0 {
+			continue
+		}
+		if len(f.PiecesRoot) != sha256.Size {
+			return fmt.Errorf("file %v has no valid pieces root", f.Path)
+		}
+		if f.Length <= plen {
+			continue
+		}
+		pieces := int((f.Length + plen - 1) / plen)
+		layer := t.PieceLayers[string(f.PiecesRoot)]
+		if len(layer) != pieces*sha256.Size {
+			return fmt.Errorf("piece layer of file %v has %d bytes, want %d", f.Path, len(layer), pieces*sha256.Size)
+		}
+		hashes := make([][32]byte, pieces)
+		for i := range hashes {
+			copy(hashes[i][:], layer[i*sha256.Size:])
+		}
+		root := merkleRoot(hashes, nextPowerOfTwo(pieces), pad)
+		if !bytes.Equal(root[:], f.PiecesRoot) {
+			return fmt.Errorf("piece layer of file %v doesn't match its pieces root", f.Path)
+		}
+	}
+	return nil
+}
+
+// this function lays the files of a v2 only torrent out like a hybrid torrent would, with a
+// padding file after every file but the last that doesn't end on a piece boundary. a torrent
+// of a single file named after the torrent stays a single file torrent
+func (info *TorrentInfo) layoutV2() {
+	if len(info.FileTree) == 1 && slices.Equal(info.FileTree[0].Path, []string{info.Name}) {
+		info.Length = info.FileTree[0].Length
+		return
+	}
+	info.Files = nil
+	for i, f := range info.FileTree {
+		info.Files = append(info.Files, TorrentFile{Length: f.Length, Path: f.Path, Attr: f.Attr, PiecesRoot: f.PiecesRoot})
+		if i == len(info.FileTree)-1 {
+			break
+		}
+		if rem := f.Length % info.PieceLength; rem != 0 {
+			pad := info.PieceLength - rem
+			info.Files = append(info.Files, TorrentFile{
+				Length: pad,
+				Path:   []string{".pad", strconv.FormatInt(pad, 10)},
+				Attr:   "p",
+			})
+		}
+	}
+}
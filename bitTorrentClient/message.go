@@ -0,0 +1,270 @@
+// This file contains the peer wire protocol: the handshake, the length prefixed messages
+// exchanged after it, and the bitfield peers use to advertise which pieces they have
+package bittorrentclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type MessageID uint8
+
+const (
+	MsgChoke         MessageID = 0
+	MsgUnchoke       MessageID = 1
+	MsgInterested    MessageID = 2
+	MsgNotInterested MessageID = 3
+	MsgHave          MessageID = 4
+	MsgBitfield      MessageID = 5
+	MsgRequest       MessageID = 6
+	MsgPiece         MessageID = 7
+	MsgCancel        MessageID = 8
+	MsgPort          MessageID = 9
+)
+
+const (
+	protocolString = "BitTorrent protocol"
+	handshakeLen   = 49 + len(protocolString)
+	// anything bigger than this is a broken or hostile peer, a piece message for a 16 KiB
+	// block is only 16 KiB + 13 and bitfields for even huge torrents stay well below it
+	maxMessageLength = 1 << 21
+)
+
+// a nil *Message is a keep-alive
+type Message struct {
+	ID      MessageID
+	Payload []byte
+	// buf is the pooled buffer backing Payload, see Release
+	buf []byte
+}
+
+func (id MessageID) String() string {
+	switch id {
+	case MsgChoke:
+		return "choke"
+	case MsgUnchoke:
+		return "unchoke"
+	case MsgInterested:
+		return "interested"
+	case MsgNotInterested:
+		return "not interested"
+	case MsgHave:
+		return "have"
+	case MsgBitfield:
+		return "bitfield"
+	case MsgRequest:
+		return "request"
+	case MsgPiece:
+		return "piece"
+	case MsgCancel:
+		return "cancel"
+	case MsgPort:
+		return "port"
+	case MsgSuggest:
+		return "suggest piece"
+	case MsgHaveAll:
+		return "have all"
+	case MsgHaveNone:
+		return "have none"
+	case MsgReject:
+		return "reject request"
+	case MsgAllowedFast:
+		return "allowed fast"
+	case MsgExtended:
+		return "extended"
+	default:
+		return fmt.Sprintf("unknown#%d", uint8(id))
+	}
+}
+
+// this function serializes a message to <length prefix><message ID><payload>
+func (m *Message) Serialize() []byte {
+	if m == nil {
+		return make([]byte, 4)
+	}
+	length := uint32(len(m.Payload) + 1)
+	buf := make([]byte, 4+length)
+	binary.BigEndian.PutUint32(buf[0:4], length)
+	buf[4] = byte(m.ID)
+	copy(buf[5:], m.Payload)
+	return buf
+}
+
+// ReadMessage reads one message from r, returning nil for keep-alives. The payload lives in
+// a pooled buffer, call Release on the message once done with it
+func ReadMessage(r io.Reader) (*Message, error) {
+	var lengthBuf [4]byte
+	_, err := io.ReadFull(r, lengthBuf[:])
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length == 0 {
+		return nil, nil
+	}
+	if length > maxMessageLength {
+		return nil, fmt.Errorf("message length %d exceeds limit", length)
+	}
+
+	buf := getBuffer(int(length))
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	return &Message{ID: MessageID(buf[0]), Payload: buf[1:], buf: buf}, nil
+}
+
+func FormatHave(index int) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return &Message{ID: MsgHave, Payload: payload}
+}
+
+func FormatRequest(index, begin, length int) *Message {
+	return &Message{ID: MsgRequest, Payload: formatBlock(index, begin, length)}
+}
+
+func FormatCancel(index, begin, length int) *Message {
+	return &Message{ID: MsgCancel, Payload: formatBlock(index, begin, length)}
+}
+
+func FormatPiece(index, begin int, block []byte) *Message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+	return &Message{ID: MsgPiece, Payload: payload}
+}
+
+func formatBlock(index, begin, length int) []byte {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return payload
+}
+
+func ParseHave(msg *Message) (int, error) {
+	if msg.ID != MsgHave {
+		return 0, fmt.Errorf("expected have (ID %d), got ID %d", MsgHave, msg.ID)
+	}
+	if len(msg.Payload) != 4 {
+		return 0, fmt.Errorf("expected have payload length 4, got length %d", len(msg.Payload))
+	}
+	return int(binary.BigEndian.Uint32(msg.Payload)), nil
+}
+
+// ParseRequest parses both request and cancel messages, they share a payload layout
+func ParseRequest(msg *Message) (index, begin, length int, err error) {
+	if msg.ID != MsgRequest && msg.ID != MsgCancel {
+		return 0, 0, 0, fmt.Errorf("expected request or cancel, got ID %d", msg.ID)
+	}
+	if len(msg.Payload) != 12 {
+		return 0, 0, 0, fmt.Errorf("expected request payload length 12, got length %d", len(msg.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length = int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	return index, begin, length, nil
+}
+
+func ParsePiece(msg *Message) (index, begin int, block []byte, err error) {
+	if msg.ID != MsgPiece {
+		return 0, 0, nil, fmt.Errorf("expected piece (ID %d), got ID %d", MsgPiece, msg.ID)
+	}
+	if len(msg.Payload) < 8 {
+		return 0, 0, nil, fmt.Errorf("piece payload too short: %d", len(msg.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	return index, begin, msg.Payload[8:], nil
+}
+
+type Handshake struct {
+	Reserved [8]byte
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+func (h *Handshake) Serialize() []byte {
+	buf := make([]byte, handshakeLen)
+	buf[0] = byte(len(protocolString))
+	curr := 1
+	curr += copy(buf[curr:], protocolString)
+	curr += copy(buf[curr:], h.Reserved[:])
+	curr += copy(buf[curr:], h.InfoHash[:])
+	copy(buf[curr:], h.PeerID[:])
+	return buf
+}
+
+func ReadHandshake(r io.Reader) (*Handshake, error) {
+	var pstrlen [1]byte
+	_, err := io.ReadFull(r, pstrlen[:])
+	if err != nil {
+		return nil, err
+	}
+	if int(pstrlen[0]) != len(protocolString) {
+		return nil, errors.New("peer is not speaking the BitTorrent protocol")
+	}
+
+	buf := make([]byte, handshakeLen-1)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	if string(buf[:len(protocolString)]) != protocolString {
+		return nil, errors.New("peer is not speaking the BitTorrent protocol")
+	}
+
+	h := &Handshake{}
+	curr := len(protocolString)
+	curr += copy(h.Reserved[:], buf[curr:])
+	curr += copy(h.InfoHash[:], buf[curr:])
+	copy(h.PeerID[:], buf[curr:])
+	return h, nil
+}
+
+// Bitfield is a packed set of piece indexes, the high bit of the first byte is piece 0
+type Bitfield []byte
+
+func NewBitfield(numPieces int) Bitfield {
+	return make(Bitfield, (numPieces+7)/8)
+}
+
+func (bf Bitfield) HasPiece(index int) bool {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(bf) {
+		return false
+	}
+	return bf[byteIndex]>>(7-uint(index%8))&1 != 0
+}
+
+func (bf Bitfield) SetPiece(index int) {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(bf) {
+		return
+	}
+	bf[byteIndex] |= 1 << (7 - uint(index%8))
+}
+
+func (bf Bitfield) ClearPiece(index int) {
+	byteIndex := index / 8
+	if index < 0 || byteIndex >= len(bf) {
+		return
+	}
+	bf[byteIndex] &^= 1 << (7 - uint(index%8))
+}
+
+// Count returns how many pieces are set
+func (bf Bitfield) Count() int {
+	n := 0
+	for _, b := range bf {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
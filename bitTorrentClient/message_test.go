@@ -0,0 +1,212 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want *Message
+	}{
+		{"keep-alive", "\x00\x00\x00\x00", nil},
+		{"choke", "\x00\x00\x00\x01\x00", &Message{ID: MsgChoke, Payload: []byte{}}},
+		{"have", "\x00\x00\x00\x05\x04\x00\x00\x01\x02", &Message{ID: MsgHave, Payload: []byte{0, 0, 1, 2}}},
+		{"unknown id", "\x00\x00\x00\x02\xfe\x01", &Message{ID: 0xfe, Payload: []byte{1}}},
+	} {
+		msg, err := ReadMessage(bytes.NewReader([]byte(tc.data)))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if tc.want == nil {
+			if msg != nil {
+				t.Errorf("%s: read %+v, want a keep-alive", tc.name, msg)
+			}
+			continue
+		}
+		if msg.ID != tc.want.ID || !bytes.Equal(msg.Payload, tc.want.Payload) {
+			t.Errorf("%s: read %v %x, want %v %x", tc.name, msg.ID, msg.Payload, tc.want.ID, tc.want.Payload)
+		}
+		if got := msg.Serialize(); string(got) != tc.data {
+			t.Errorf("%s: serialized back to %x", tc.name, got)
+		}
+		msg.Release()
+	}
+
+	for _, data := range []string{
+		"",
+		"\x00\x00",
+		// a length past the limit is refused before anything is allocated for it
+		"\xff\xff\xff\xff",
+		"\x00\x20\x00\x01\x07",
+		"\x00\x00\x00\x05\x04\x00",
+	} {
+		if msg, err := ReadMessage(bytes.NewReader([]byte(data))); err == nil {
+			t.Errorf("%x read as %+v", data, msg)
+		}
+	}
+}
+
+func TestParseMessages(t *testing.T) {
+	index, err := ParseHave(FormatHave(1 << 20))
+	if err != nil || index != 1<<20 {
+		t.Errorf("have parsed as %d, %v", index, err)
+	}
+	for _, msg := range []*Message{
+		{ID: MsgHave, Payload: []byte{0, 0, 1}},
+		{ID: MsgHave, Payload: []byte{0, 0, 0, 1, 0}},
+		{ID: MsgRequest, Payload: []byte{0, 0, 0, 1}},
+	} {
+		if _, err := ParseHave(msg); err == nil {
+			t.Errorf("have parsed from %v %x", msg.ID, msg.Payload)
+		}
+	}
+
+	for _, msg := range []*Message{FormatRequest(1, 2, 3), FormatCancel(1, 2, 3), FormatReject(1, 2, 3)} {
+		if msg.ID == MsgReject {
+			// a reject carries the request it refuses
+			msg = &Message{ID: MsgRequest, Payload: msg.Payload}
+		}
+		index, begin, length, err := ParseRequest(msg)
+		if err != nil || index != 1 || begin != 2 || length != 3 {
+			t.Errorf("%v parsed as %d %d %d, %v", msg.ID, index, begin, length, err)
+		}
+	}
+	for _, msg := range []*Message{
+		{ID: MsgRequest, Payload: make([]byte, 11)},
+		{ID: MsgRequest, Payload: make([]byte, 13)},
+		{ID: MsgPiece, Payload: make([]byte, 12)},
+	} {
+		if _, _, _, err := ParseRequest(msg); err == nil {
+			t.Errorf("request parsed from %v of %d bytes", msg.ID, len(msg.Payload))
+		}
+	}
+
+	index, begin, block, err := ParsePiece(FormatPiece(7, 16384, []byte("block")))
+	if err != nil || index != 7 || begin != 16384 || string(block) != "block" {
+		t.Errorf("piece parsed as %d %d %q, %v", index, begin, block, err)
+	}
+	if _, _, _, err := ParsePiece(&Message{ID: MsgPiece, Payload: make([]byte, 7)}); err == nil {
+		t.Error("piece parsed from 7 bytes")
+	}
+	index, begin, block, err = ParsePiece(&Message{ID: MsgPiece, Payload: make([]byte, 8)})
+	if err != nil || len(block) != 0 {
+		t.Errorf("empty piece parsed as %d %d %q, %v", index, begin, block, err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	h := Handshake{InfoHash: [20]byte{1, 2, 3}, PeerID: [20]byte{4, 5, 6}}
+	h.Reserved[reservedExtensionByte] |= reservedExtensionBit
+	data := h.Serialize()
+	if len(data) != 68 || string(data[:20]) != "\x13BitTorrent protocol" {
+		t.Fatalf("handshake serialized as %q", data)
+	}
+	got, err := ReadHandshake(bytes.NewReader(data))
+	if err != nil || *got != h {
+		t.Errorf("handshake read back as %+v, %v", got, err)
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		data[:40],
+		append([]byte{18}, data[1:]...),
+		append([]byte("\x13BitTorrent Protocol"), data[20:]...),
+	} {
+		if _, err := ReadHandshake(bytes.NewReader(data)); err == nil {
+			t.Errorf("handshake read from %q", data)
+		}
+	}
+}
+
+func TestBitfield(t *testing.T) {
+	bf := NewBitfield(10)
+	if len(bf) != 2 {
+		t.Fatalf("%d bytes for 10 pieces", len(bf))
+	}
+	for _, index := range []int{0, 7, 9} {
+		bf.SetPiece(index)
+	}
+	// out of range indexes are ignored rather than growing or panicking
+	bf.SetPiece(16)
+	bf.SetPiece(-1)
+	if !reflect.DeepEqual(bf, Bitfield{0x81, 0x40}) || bf.Count() != 3 {
+		t.Errorf("bitfield %08b with %d pieces", bf, bf.Count())
+	}
+	if !bf.HasPiece(7) || bf.HasPiece(8) || bf.HasPiece(100) || bf.HasPiece(-1) {
+		t.Error("HasPiece is wrong")
+	}
+	bf.ClearPiece(7)
+	if bf.HasPiece(7) || bf.Count() != 2 {
+		t.Error("ClearPiece didn't clear")
+	}
+}
+
+func TestExtensionMessages(t *testing.T) {
+	id, payload, err := ParseExtended(formatExtended(3, []byte("d1:xi1ee")))
+	if err != nil || id != 3 || string(payload) != "d1:xi1ee" {
+		t.Errorf("extended parsed as %d %q, %v", id, payload, err)
+	}
+	if _, _, err := ParseExtended(&Message{ID: MsgExtended}); err == nil {
+		t.Error("empty extended message parsed")
+	}
+
+	var p Peer
+	err = p.readExtendedHandshake([]byte("d1:md11:ut_metadatai2e6:ut_pexi0e3:badi300ee13:metadata_sizei5000e4:reqqi250ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := p.ExtensionID(extMetadataName); !ok || id != 2 {
+		t.Errorf("ut_metadata has id %d, %v", id, ok)
+	}
+	if _, ok := p.ExtensionID("ut_pex"); ok {
+		t.Error("an extension turned off with id 0 is supported")
+	}
+	if _, ok := p.ExtensionID("bad"); ok {
+		t.Error("an extension with an id past 255 is supported")
+	}
+	if p.MetadataSize() != 5000 || p.maxRequests != 250 {
+		t.Errorf("metadata size %d and reqq %d", p.MetadataSize(), p.maxRequests)
+	}
+	for _, payload := range []string{"le", "d1:m", "d13:metadata_sizei-1ee"} {
+		var p Peer
+		err := p.readExtendedHandshake([]byte(payload))
+		if payload == "d13:metadata_sizei-1ee" {
+			if err != nil || p.MetadataSize() != 0 {
+				t.Errorf("negative metadata size taken as %d, %v", p.MetadataSize(), err)
+			}
+		} else if err == nil {
+			t.Errorf("extended handshake %q read", payload)
+		}
+	}
+
+	msgType, piece, data, err := parseMetadataMessage(formatMetadataMessage(metadataData, 2, 40000, []byte("info")))
+	if err != nil || msgType != metadataData || piece != 2 || string(data) != "info" {
+		t.Errorf("metadata message parsed as %d %d %q, %v", msgType, piece, data, err)
+	}
+	for _, payload := range []string{"d8:msg_typei0ee", "d5:piecei0ee", "d8:msg_typei0e5:piecei-1ee", "le", "d8:msg_type"} {
+		if _, _, _, err := parseMetadataMessage([]byte(payload)); err == nil {
+			t.Errorf("metadata message %q parsed", payload)
+		}
+	}
+}
+
+// the example from BEP 6
+func TestAllowedFastSet(t *testing.T) {
+	var infoHash [20]byte
+	for i := range infoHash {
+		infoHash[i] = 0xaa
+	}
+	ip := net.ParseIP("80.4.4.200")
+	if got, want := AllowedFastSet(ip, infoHash, 1313, 7), []int{1059, 431, 808, 1217, 287, 376, 1188}; !reflect.DeepEqual(got, want) {
+		t.Errorf("allowed fast set of 7 is %v, want %v", got, want)
+	}
+	if got, want := AllowedFastSet(ip, infoHash, 1313, 9), []int{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}; !reflect.DeepEqual(got, want) {
+		t.Errorf("allowed fast set of 9 is %v, want %v", got, want)
+	}
+}
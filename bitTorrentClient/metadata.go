@@ -0,0 +1,158 @@
+// This file implements the metadata extension (BEP 9), which lets a peer that only knows a
+// torrent's infohash, e.g. from a magnet link, fetch the info dictionary from the swarm.
+// The dictionary is split into 16 KiB pieces that are requested one at a time over
+// ut_metadata messages. Whatever a peer sends is only trusted once its SHA-1 matches the
+// infohash. Downloads serve the info dictionary of their own torrent to every peer asking
+package bittorrentclient
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	metadataPieceSize = 16 * 1024
+	// maxMetadataSize bounds the info dictionary a peer can make us download, even torrents
+	// with millions of pieces stay far below it
+	maxMetadataSize = 64 << 20
+
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+
+	// metadataTimeout bounds the wait for a peer's extended handshake and for each piece
+	metadataTimeout = 30 * time.Second
+)
+
+var ErrMetadataRejected = errors.New("peer rejected the metadata request")
+
+// this function answers a ut_metadata message from p with pieces of our info dictionary
+func (d *Download) handleExtended(p *Peer, msg *Message) error {
+	id, payload, err := ParseExtended(msg)
+	if err != nil {
+		return err
+	}
+	if id != extMetadataID {
+		return nil
+	}
+	msgType, piece, _, err := parseMetadataMessage(payload)
+	if err != nil {
+		return err
+	}
+	if msgType != metadataRequest {
+		return nil
+	}
+	info := d.Torrent.infoBytes
+	if piece*metadataPieceSize >= len(info) {
+		return p.sendExtension(extMetadataName, formatMetadataMessage(metadataReject, piece, 0, nil))
+	}
+	block := info[piece*metadataPieceSize:]
+	if len(block) > metadataPieceSize {
+		block = block[:metadataPieceSize]
+	}
+	return p.sendExtension(extMetadataName, formatMetadataMessage(metadataData, piece, len(info), block))
+}
+
+// this function builds a ut_metadata message, data is appended after the dictionary of
+// data messages
+func formatMetadataMessage(msgType, piece, totalSize int, data []byte) []byte {
+	dict := map[string]interface{}{"msg_type": msgType, "piece": piece}
+	if msgType == metadataData {
+		dict["total_size"] = totalSize
+	}
+	payload, _ := encodeBencode(dict)
+	return append(payload, data...)
+}
+
+// this function splits a ut_metadata message into its dictionary and the data following it
+func parseMetadataMessage(payload []byte) (msgType, piece int, data []byte, err error) {
+	v, n, err := decodeBencodePrefix(payload)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid metadata message: %w", err)
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return 0, 0, nil, errors.New("metadata message is not a dictionary")
+	}
+	t, ok1 := dict["msg_type"].(int64)
+	i, ok2 := dict["piece"].(int64)
+	if !ok1 || !ok2 || i < 0 || i > maxMetadataSize/metadataPieceSize {
+		return 0, 0, nil, errors.New("metadata message without a valid type and piece")
+	}
+	return int(t), int(i), payload[n:], nil
+}
+
+// FetchMetadata downloads the info dictionary of infoHash from p, which has to be freshly
+// connected. It returns the dictionary once its hash matched
+func FetchMetadata(ctx context.Context, p *Peer, infoHash [20]byte) ([]byte, error) {
+	if !p.SupportsExtensions() {
+		return nil, errors.New("peer doesn't support the extension protocol")
+	}
+	// ReadMessage blocks, closing the connection is the only way to give up on it
+	stop := context.AfterFunc(ctx, func() { p.Close() })
+	defer stop()
+	p.mu.Lock()
+	p.readTimeout = metadataTimeout
+	p.mu.Unlock()
+	if err := p.SendExtendedHandshake(0); err != nil {
+		return nil, err
+	}
+
+	var info []byte
+	requested, received := -1, 0
+	for {
+		if _, ok := p.ExtensionID(extMetadataName); ok && p.MetadataSize() > 0 {
+			if info == nil {
+				info = make([]byte, p.MetadataSize())
+			}
+			// one piece at a time, a whole dictionary is rarely more than a few of them
+			if requested < received {
+				requested = received
+				err := p.sendExtension(extMetadataName, formatMetadataMessage(metadataRequest, received, 0, nil))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		msg, err := p.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if msg == nil || msg.ID != MsgExtended {
+			msg.Release()
+			continue
+		}
+		id, payload, err := ParseExtended(msg)
+		if err != nil || id != extMetadataID || info == nil {
+			msg.Release()
+			continue
+		}
+		msgType, piece, data, err := parseMetadataMessage(payload)
+		if err == nil && msgType == metadataData && piece == received {
+			if piece*metadataPieceSize+len(data) > len(info) {
+				err = errors.New("metadata piece exceeds the announced size")
+			} else {
+				copy(info[piece*metadataPieceSize:], data)
+				received++
+			}
+		}
+		msg.Release()
+		switch {
+		case err != nil:
+			return nil, err
+		case msgType == metadataReject:
+			return nil, ErrMetadataRejected
+		case received*metadataPieceSize >= len(info):
+			if sha1.Sum(info) != infoHash {
+				return nil, errors.New("metadata doesn't match the infohash")
+			}
+			return info, nil
+		}
+	}
+}
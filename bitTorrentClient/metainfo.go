@@ -0,0 +1,205 @@
+// This file adds the torrent geometry the downloader needs on top of the decoded metainfo:
+// piece counts and sizes, piece hashes and the total length. It also finds the raw bytes of
+// a value in a bencoded dictionary, which is what the infohash is computed over
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadTorrent reads and decodes a .torrent file
+func LoadTorrent(path string) (*Torrent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodeTorrent(file)
+}
+
+func (t *Torrent) NumPieces() int {
+	if t.IsV2() && !t.IsHybrid() {
+		// v2 only torrents have no piece list, the padded layout decides
+		return int((t.TotalLength() + t.Info.PieceLength - 1) / t.Info.PieceLength)
+	}
+	return len(t.Info.Pieces) / 20
+}
+
+// PieceHash returns the expected SHA-1 of a piece, nil for v2 only torrents
+func (t *Torrent) PieceHash(index int) []byte {
+	if len(t.Info.Pieces) == 0 {
+		return nil
+	}
+	return t.Info.Pieces[index*20 : index*20+20]
+}
+
+// TotalLength returns the size of all files together
+func (t *Torrent) TotalLength() int64 {
+	if len(t.Info.Files) == 0 {
+		return t.Info.Length
+	}
+	var total int64
+	for _, f := range t.Info.Files {
+		total += f.Length
+	}
+	return total
+}
+
+// PieceSize returns the length of a piece, only the last one may be shorter than PieceLength
+func (t *Torrent) PieceSize(index int) int {
+	begin := int64(index) * t.Info.PieceLength
+	end := begin + t.Info.PieceLength
+	if total := t.TotalLength(); end > total {
+		end = total
+	}
+	return int(end - begin)
+}
+
+// this function checks the metainfo is consistent enough to download from
+func (t *Torrent) validate() error {
+	if len(t.Info.Pieces)%20 != 0 {
+		return errors.New("pieces is not a multiple of 20 bytes")
+	}
+	if t.Info.PieceLength <= 0 {
+		return fmt.Errorf("invalid piece length %d", t.Info.PieceLength)
+	}
+	if t.IsV2() {
+		if err := t.validateV2(); err != nil {
+			return err
+		}
+		if !t.IsHybrid() {
+			return nil
+		}
+	}
+	want := (t.TotalLength() + t.Info.PieceLength - 1) / t.Info.PieceLength
+	if int64(t.NumPieces()) != want {
+		return fmt.Errorf("torrent has %d piece hashes but its files need %d", t.NumPieces(), want)
+	}
+	return nil
+}
+
+// rawDictValue returns the encoded bytes of key's value in the bencoded dictionary data
+func rawDictValue(data []byte, key string) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errors.New("not a dictionary")
+	}
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		k, next, err := rawString(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		end, err := skipValue(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if k == key {
+			return data[next:end], nil
+		}
+		pos = end
+	}
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+func rawString(data []byte, pos int) (string, int, error) {
+	colon := pos
+	for colon < len(data) && data[colon] != ':' {
+		colon++
+	}
+	if colon >= len(data) {
+		return "", 0, errors.New("unterminated string length")
+	}
+	length, err := strconv.Atoi(string(data[pos:colon]))
+	if err != nil || length < 0 || colon+1+length > len(data) {
+		return "", 0, fmt.Errorf("invalid string length at offset %d", pos)
+	}
+	end := colon + 1 + length
+	return string(data[colon+1 : end]), end, nil
+}
+
+// this function returns the offset just past the value starting at pos
+func skipValue(data []byte, pos int) (int, error) {
+	if pos >= len(data) {
+		return 0, errors.New("unexpected end of data")
+	}
+	switch c := data[pos]; {
+	case c == 'i':
+		for pos < len(data) && data[pos] != 'e' {
+			pos++
+		}
+		if pos >= len(data) {
+			return 0, errors.New("unterminated integer")
+		}
+		return pos + 1, nil
+	case c >= '0' && c <= '9':
+		_, end, err := rawString(data, pos)
+		return end, err
+	case c == 'l' || c == 'd':
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			end, err := skipValue(data, pos)
+			if err != nil {
+				return 0, err
+			}
+			pos = end
+		}
+		if pos >= len(data) {
+			return 0, errors.New("unterminated list or dictionary")
+		}
+		return pos + 1, nil
+	default:
+		return 0, fmt.Errorf("unexpected character '%c' at offset %d", c, pos)
+	}
+}
+
+// fileSpan is where a file's bytes sit when all files of the torrent are laid end to end
+type fileSpan struct {
+	Path   []string
+	Offset int64
+	Length int64
+	// Padding is set for BEP 47 padding files, see IsPadding
+	Padding bool
+}
+
+// IsPadding reports whether the file only pads the next file out to a piece boundary. Such
+// files are zeros, they count for piece offsets but are never shown or written to disk.
+// Besides the BEP 47 attr flag the names older creators gave them are recognized
+func (f TorrentFile) IsPadding() bool {
+	if strings.Contains(f.Attr, "p") {
+		return true
+	}
+	if len(f.Path) == 0 {
+		return false
+	}
+	return f.Path[0] == ".pad" || strings.HasPrefix(f.Path[len(f.Path)-1], "_____padding_file_")
+}
+
+// this function returns the torrent's files with their offsets, a single file torrent
+// has one file named after the torrent
+func (t *Torrent) files() []fileSpan {
+	if len(t.Info.Files) == 0 {
+		return []fileSpan{{Path: []string{t.Info.Name}, Length: t.Info.Length}}
+	}
+	spans := make([]fileSpan, len(t.Info.Files))
+	var offset int64
+	for i, f := range t.Info.Files {
+		spans[i] = fileSpan{Path: f.Path, Offset: offset, Length: f.Length, Padding: f.IsPadding()}
+		offset += f.Length
+	}
+	return spans
+}
+
+// this function returns the first and last piece a file overlaps, ok is false for empty and
+// padding files, which never need pieces of their own
+func (t *Torrent) filePieces(f fileSpan) (first, last int, ok bool) {
+	if f.Length == 0 || f.Padding {
+		return 0, 0, false
+	}
+	first = int(f.Offset / t.Info.PieceLength)
+	last = int((f.Offset + f.Length - 1) / t.Info.PieceLength)
+	return first, last, true
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+// Memory mapped storage is only implemented for Linux and macOS, elsewhere opening it fails
+// and FilesystemStorage has to be used
+package bittorrentclient
+
+import "errors"
+
+func (MmapStorage) OpenTorrent(t *Torrent, dir string) (TorrentStorage, error) {
+	return nil, errors.New("memory mapped storage isn't supported on this platform")
+}
@@ -0,0 +1,168 @@
+//go:build linux || darwin
+
+// This file implements storage on memory mapped files. Every file of the torrent is mapped
+// whole, so a piece write is a copy into the page cache: no syscall per write and no
+// second copy of the data in our own buffers. The kernel writes dirty pages back when it
+// likes, Flush forces them out with msync. Files are grown to their full size when they are
+// mapped, sparse on filesystems that support it, because touching a page beyond the end of
+// a mapped file kills the process. A file's last page may be partly past its end, nothing
+// is ever read or written there. Mapping needs address space for the whole torrent, which
+// rules out large torrents on 32 bit systems
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+func (MmapStorage) OpenTorrent(t *Torrent, dir string) (TorrentStorage, error) {
+	paths, err := t.filePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &mmapTorrent{
+		pieceLength: t.Info.PieceLength,
+		totalLength: t.TotalLength(),
+		spans:       t.files(),
+	}
+	for i, f := range m.spans {
+		if f.Padding {
+			m.maps = append(m.maps, nil)
+			continue
+		}
+		data, err := mapFile(dir, paths[i], f.Length)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.maps = append(m.maps, data)
+	}
+	return m, nil
+}
+
+// this function maps the file at path, creating it and growing it to length first. empty
+// files are created but not mapped. path has to stay inside root
+func mapFile(root, path string, length int64) ([]byte, error) {
+	if length > math.MaxInt {
+		return nil, fmt.Errorf("%s is too large to map", path)
+	}
+	err := mkdirConfined(root, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid after the file is closed
+	defer f.Close()
+	if length == 0 {
+		return nil, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// only ever grown, a longer file keeps whatever is past the torrent's part of it
+	if info.Size() < length {
+		err = f.Truncate(length)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %w", path, err)
+	}
+	return data, nil
+}
+
+type mmapTorrent struct {
+	pieceLength int64
+	totalLength int64
+	spans       []fileSpan
+
+	// mu is read locked around every copy so Close can't unmap under one
+	mu     sync.RWMutex
+	maps   [][]byte
+	closed bool
+}
+
+func (m *mmapTorrent) ReadPieceAt(index int, b []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return 0, errStorageClosed
+	}
+	read := 0
+	err := spanFiles(m.spans, m.totalLength, b, int64(index)*m.pieceLength+off, func(i int, chunk []byte, fileOff int64) error {
+		if m.spans[i].Padding {
+			read += copy(chunk, make([]byte, len(chunk)))
+			return nil
+		}
+		read += copy(chunk, m.maps[i][fileOff:])
+		return nil
+	})
+	return read, err
+}
+
+func (m *mmapTorrent) WritePieceAt(index int, b []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return 0, errStorageClosed
+	}
+	written := 0
+	err := spanFiles(m.spans, m.totalLength, b, int64(index)*m.pieceLength+off, func(i int, chunk []byte, fileOff int64) error {
+		if m.spans[i].Padding {
+			written += len(chunk)
+			return nil
+		}
+		written += copy(m.maps[i][fileOff:], chunk)
+		return nil
+	})
+	return written, err
+}
+
+// Flush writes every dirty page back to the files and waits for it
+func (m *mmapTorrent) Flush() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return errStorageClosed
+	}
+	var errs []error
+	for _, data := range m.maps {
+		if len(data) == 0 {
+			continue
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), syscall.MS_SYNC)
+		if errno != 0 {
+			errs = append(errs, errno)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close unmaps the files, dirty pages are still written back by the kernel
+func (m *mmapTorrent) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	var errs []error
+	for _, data := range m.maps {
+		if len(data) > 0 {
+			errs = append(errs, syscall.Munmap(data))
+		}
+	}
+	m.maps = nil
+	return errors.Join(errs...)
+}
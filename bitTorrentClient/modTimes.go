@@ -0,0 +1,94 @@
+// This file gives finished files the modification times they had when the torrent was
+// made, for archives where the timestamps are part of what is preserved. A file's time is
+// the BEP 47 mtime its creator recorded for it, or the torrent's creation date when there
+// is none. It is set once the file is complete, and again whenever the download starts, as
+// writing to a file moves its time on. Only FileStorage supports it, mapped files get their
+// times changed by the kernel whenever it writes them back
+package bittorrentclient
+
+import (
+	"os"
+	"time"
+)
+
+// modTimeStorage is implemented by storages that can set the modification time of files
+type modTimeStorage interface {
+	setModTime(i int, mtime time.Time) error
+}
+
+// CreatedAt returns the creation date recorded in the torrent, ok is false when there is none
+func (t *Torrent) CreatedAt() (created time.Time, ok bool) {
+	if t.CreationDate <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(t.CreationDate, 0), true
+}
+
+// FileModTime returns the modification time file i had according to the torrent, its own
+// mtime or else the creation date. ok is false when the torrent records neither
+func (t *Torrent) FileModTime(i int) (mtime time.Time, ok bool) {
+	var own int64
+	if len(t.Info.Files) == 0 {
+		own = t.Info.Mtime
+	} else if i >= 0 && i < len(t.Info.Files) {
+		own = t.Info.Files[i].Mtime
+	}
+	if own > 0 {
+		return time.Unix(own, 0), true
+	}
+	return t.CreatedAt()
+}
+
+// RawInfo returns a copy of the info dictionary exactly as it was encoded in the torrent
+// file, nil for torrents that weren't read from one
+func (t *Torrent) RawInfo() []byte {
+	if t.infoBytes == nil {
+		return nil
+	}
+	return append([]byte(nil), t.infoBytes...)
+}
+
+// SetPreserveModTimes makes the download set the modification time of every finished file
+// to the one recorded in the torrent, see FileModTime
+func (d *Download) SetPreserveModTimes(preserve bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.preserveModTimes = preserve
+	if preserve && d.storage != nil {
+		d.finishFiles(0, d.Torrent.NumPieces()-1)
+	}
+}
+
+func (d *Download) PreserveModTimes() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.preserveModTimes
+}
+
+// this function sets the modification time of the complete file i, when the download
+// preserves them and the torrent has one. the caller must hold d.mu
+func (d *Download) applyModTime(i int) error {
+	if !d.preserveModTimes {
+		return nil
+	}
+	storage, ok := d.storage.(modTimeStorage)
+	if !ok {
+		return nil
+	}
+	mtime, ok := d.Torrent.FileModTime(i)
+	if !ok {
+		return nil
+	}
+	return storage.setModTime(i, mtime)
+}
+
+func (s *FileStorage) setModTime(i int, mtime time.Time) error {
+	sf := s.files[i]
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	if s.spans[i].Padding {
+		return nil
+	}
+	// the access time is left alone
+	return os.Chtimes(sf.path, time.Time{}, mtime)
+}
@@ -0,0 +1,341 @@
+// This file moves finished downloads out of the directory they were downloaded into. With
+// a complete directory set, the files sit in Dir while pieces arrive and go to the complete
+// directory once every wanted piece is there, so a watch folder or media library never
+// sees half written files. Files are renamed where possible, which is atomic. Across
+// filesystems they are copied under a temporary name, checked against the original and
+// only then renamed into place and removed from Dir. Storage is closed for the move and
+// reopened in the new place, peers asking for blocks meanwhile are turned away and
+// seeding picks up again right after
+package bittorrentclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SetCompleteDir sets the directory finished files are moved to, empty leaves them in Dir
+func (d *Download) SetCompleteDir(dir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completeDir = dir
+	d.moveWhenComplete()
+}
+
+func (d *Download) CompleteDir() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.completeDir
+}
+
+// SaveDir returns the directory the files are in now, Dir or the complete directory once
+// they were moved there
+func (d *Download) SaveDir() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.Dir
+}
+
+// this function starts moving the files once the download is complete and running, unless
+// they are in the complete directory already. the caller must hold d.mu
+func (d *Download) moveWhenComplete() {
+	if d.completeDir == "" || d.completeDir == d.Dir || d.movePending || d.cancel == nil || d.storage == nil || !d.complete() {
+		return
+	}
+	d.movePending = true
+	// added while d.cancel is set, so halt waits for the move
+	d.wg.Add(1)
+	go d.moveToCompleteDir()
+}
+
+func (d *Download) moveToCompleteDir() {
+	defer d.wg.Done()
+	var dst string
+	err := d.relocate(func(layout *Torrent, dir, partSuffix string) (*Torrent, string, error) {
+		dst = d.CompleteDir()
+		if dst == "" || dst == dir {
+			// the complete directory changed since the move was started
+			return layout, dir, nil
+		}
+		return layout, dst, relocateFiles(layout, dir, layout, dst, partSuffix)
+	})
+	if err != nil {
+		err = fmt.Errorf("moving files to %s: %w", dst, err)
+		d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+	}
+	d.mu.Lock()
+	d.movePending = false
+	d.emit(Event{Type: EventFilesMoved, Err: err})
+	d.mu.Unlock()
+	if err == nil {
+		_ = d.autosaveResume()
+	}
+}
+
+// this function closes storage, lets fn move the files and reopens storage where fn says
+// they are now. fn gets the current file layout and directory, and the suffix incomplete
+// files have, and returns the new layout and directory. they are only taken on when it
+// succeeds. peers asking for blocks meanwhile are turned
+// away, pieces finishing meanwhile are downloaded again
+func (d *Download) relocate(fn func(layout *Torrent, dir, partSuffix string) (*Torrent, string, error)) error {
+	d.relocateMu.Lock()
+	defer d.relocateMu.Unlock()
+	d.mu.Lock()
+	layout, dir, suffix := d.fileLayout(), d.Dir, d.partSuffix()
+	writer, storage := d.writer, d.storage
+	d.writer, d.storage = nil, nil
+	d.moving = true
+	d.mu.Unlock()
+
+	var err error
+	if storage != nil {
+		err = writer.close()
+		if closeErr := storage.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	var newLayout *Torrent
+	var newDir string
+	if err == nil {
+		newLayout, newDir, err = fn(layout, dir, suffix)
+	}
+
+	d.mu.Lock()
+	d.moving = false
+	if err == nil {
+		d.layout, d.Dir = newLayout, newDir
+	}
+	var openErr error
+	if storage != nil {
+		openErr = d.openStorage()
+		if openErr == nil && d.preserveModTimes {
+			// files copied across filesystems got new modification times
+			d.finishFiles(0, d.Torrent.NumPieces()-1)
+		}
+	}
+	d.wakeReaders()
+	d.mu.Unlock()
+	if openErr != nil {
+		d.publish(SessionEvent{Type: SessionStorageError, Err: openErr})
+		d.fail(openErr)
+		return openErr
+	}
+	return err
+}
+
+// this function moves a torrent's files from their paths in layout from under src to their
+// paths in layout to under dst. a file that isn't there is left alone and an incomplete file
+// kept under partSuffix keeps it. nothing is moved when two files would end up in the same
+// place, one inside the other, or in a place something already is. directories left empty
+// are removed
+func relocateFiles(from *Torrent, src string, to *Torrent, dst, partSuffix string) error {
+	oldPaths, err := from.filePaths(src)
+	if err != nil {
+		return err
+	}
+	newPaths, err := to.filePaths(dst)
+	if err != nil {
+		return err
+	}
+	files := from.files()
+	err = checkDistinctPaths(files, newPaths, partSuffix)
+	if err != nil {
+		return err
+	}
+	var moves []int
+	for i, f := range files {
+		if f.Padding || oldPaths[i] == newPaths[i] {
+			continue
+		}
+		if partSuffix != "" {
+			// an incomplete file keeps its suffix
+			if _, err := os.Lstat(oldPaths[i] + partSuffix); err == nil {
+				oldPaths[i] += partSuffix
+				newPaths[i] += partSuffix
+			}
+		}
+		if _, err := os.Lstat(oldPaths[i]); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		err = confinePath(src, oldPaths[i])
+		if err != nil {
+			return err
+		}
+		err = checkVacant(oldPaths[i], newPaths[i])
+		if err != nil {
+			return err
+		}
+		moves = append(moves, i)
+	}
+	for _, i := range moves {
+		err = mkdirConfined(dst, newPaths[i])
+		if err != nil {
+			return err
+		}
+		err = moveFile(oldPaths[i], newPaths[i])
+		if err != nil {
+			return err
+		}
+	}
+	if len(from.Info.Files) > 0 {
+		// the name was checked by filePaths already
+		name, _ := safePathElement(from.Info.Name)
+		removeEmptyDirs(filepath.Join(src, name), oldPaths)
+	}
+	return nil
+}
+
+// this function returns an error when two of the files, padding aside, would be stored at
+// the same path, counting the names incomplete files have with partSuffix, or one of them
+// at a path inside another, where a directory would have to be a file at the same time
+func checkDistinctPaths(files []fileSpan, paths []string, partSuffix string) error {
+	owner := make(map[string]int, len(paths))
+	for i, f := range files {
+		if f.Padding {
+			continue
+		}
+		names := []string{paths[i]}
+		if partSuffix != "" {
+			names = append(names, paths[i]+partSuffix)
+		}
+		for _, name := range names {
+			if j, ok := owner[name]; ok {
+				return fmt.Errorf("files %d and %d would both be stored as %s", j, i, name)
+			}
+			owner[name] = i
+		}
+	}
+	for i, f := range files {
+		if f.Padding {
+			continue
+		}
+		for dir := filepath.Dir(paths[i]); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if j, ok := owner[dir]; ok {
+				return fmt.Errorf("file %d would be stored inside file %d at %s", i, j, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// this function returns an error when something other than the file at from is at to
+// already, a move there would replace it
+func checkVacant(from, to string) error {
+	existing, err := os.Lstat(to)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// e.g. a rename that only changes case on a filesystem that ignores it
+	if current, err := os.Lstat(from); err == nil && os.SameFile(current, existing) {
+		return nil
+	}
+	return fmt.Errorf("%s exists already", to)
+}
+
+// this function renames from to to, or copies it over and removes it when a rename isn't
+// possible, e.g. because the two are on different filesystems
+func moveFile(from, to string) error {
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+	// a name of its own, a fixed one could be another file of the torrent
+	f, err := os.CreateTemp(filepath.Dir(to), "."+filepath.Base(to)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	err = copyFile(from, tmp)
+	if err == nil {
+		err = sameContents(from, tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, to)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(from)
+}
+
+// this function copies from to to and syncs the copy
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// this function returns an error unless both files have the same contents
+func sameContents(a, b string) error {
+	sumA, err := fileSHA256(a)
+	if err != nil {
+		return err
+	}
+	sumB, err := fileSHA256(b)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sumA, sumB) {
+		return fmt.Errorf("copy of %s doesn't match the original", a)
+	}
+	return nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// this function removes the directories the moved files were in, deepest first, as long
+// as they are empty. it stops at root, which is removed last
+func removeEmptyDirs(root string, files []string) {
+	dirs := make(map[string]bool)
+	for _, path := range files {
+		for dir := filepath.Dir(path); len(dir) > len(root); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for len(dirs) > 0 {
+		deepest := ""
+		for dir := range dirs {
+			if len(dir) > len(deepest) {
+				deepest = dir
+			}
+		}
+		delete(dirs, deepest)
+		// fails for directories that still hold something, which is fine
+		os.Remove(deepest)
+	}
+	os.Remove(root)
+}
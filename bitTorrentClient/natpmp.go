@@ -0,0 +1,205 @@
+// This file asks the gateway for port mappings with PCP (RFC 6887) and its predecessor
+// NAT-PMP (RFC 6886), the small UDP protocols home routers speak on port 5351. PCP is tried
+// first, a gateway that only knows NAT-PMP answers it with an unsupported version error and
+// is then spoken to in NAT-PMP. A mapping is a lease: the gateway forwards the external port
+// for the lifetime it granted, and asking again with a lifetime of zero removes it
+package bittorrentclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	natpmpPort = 5351
+	// requests are resent after 250ms, doubling up to natpmpAttempts tries
+	natpmpFirstTimeout = 250 * time.Millisecond
+	natpmpAttempts     = 4
+
+	natpmpVersion = 0
+	pcpVersion    = 2
+	pcpOpMap      = 1
+	// the result code of a request in a version the server doesn't speak, the same in both
+	natpmpUnsupportedVersion = 1
+)
+
+var errUnsupportedVersion = errors.New("gateway doesn't support the protocol version")
+
+// natpmpClient maps ports with PCP, or NAT-PMP when legacy is set
+type natpmpClient struct {
+	gateway *net.UDPAddr
+	legacy  bool
+}
+
+func (c *natpmpClient) name() string {
+	if c.legacy {
+		return "NAT-PMP"
+	}
+	return "PCP"
+}
+
+// this function sends req to the gateway until an answer accepted by valid arrives
+func (c *natpmpClient) roundTrip(ctx context.Context, req []byte, valid func([]byte) bool) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, c.gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	buf := make([]byte, 1100)
+	timeout := natpmpFirstTimeout
+	for attempt := 0; attempt < natpmpAttempts; attempt++ {
+		_, err := conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				// e.g. an ICMP port unreachable, nothing listens on the gateway
+				return nil, err
+			}
+			if valid(buf[:n]) {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+		timeout *= 2
+	}
+	return nil, fmt.Errorf("%s: no answer from %s", c.name(), c.gateway)
+}
+
+func (c *natpmpClient) mapPort(ctx context.Context, protocol string, internal, external int, lifetime time.Duration) (PortMapping, error) {
+	if c.legacy {
+		return c.mapNATPMP(ctx, protocol, internal, external, lifetime)
+	}
+	return c.mapPCP(ctx, protocol, internal, external, lifetime)
+}
+
+func (c *natpmpClient) unmapPort(ctx context.Context, m PortMapping) error {
+	_, err := c.mapPort(ctx, m.Protocol, m.InternalPort, 0, 0)
+	return err
+}
+
+func (c *natpmpClient) mapNATPMP(ctx context.Context, protocol string, internal, external int, lifetime time.Duration) (PortMapping, error) {
+	op := byte(1)
+	if protocol == "TCP" {
+		op = 2
+	}
+	req := make([]byte, 12)
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:], uint16(internal))
+	binary.BigEndian.PutUint16(req[6:], uint16(external))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	res, err := c.roundTrip(ctx, req, func(b []byte) bool {
+		return len(b) >= 4 && b[0] == natpmpVersion && b[1] == 128+op
+	})
+	if err != nil {
+		return PortMapping{}, err
+	}
+	if code := binary.BigEndian.Uint16(res[2:]); code != 0 {
+		return PortMapping{}, fmt.Errorf("NAT-PMP: gateway refused the mapping with result %d", code)
+	}
+	if len(res) < 16 {
+		return PortMapping{}, errors.New("NAT-PMP: short answer")
+	}
+	m := PortMapping{
+		Protocol:     protocol,
+		InternalPort: internal,
+		ExternalPort: int(binary.BigEndian.Uint16(res[10:])),
+		Method:       c.name(),
+		Expires:      time.Now().Add(time.Duration(binary.BigEndian.Uint32(res[12:])) * time.Second),
+	}
+	if lifetime == 0 {
+		return m, nil
+	}
+	// the mapping answer doesn't carry the external address, it is asked for separately
+	res, err = c.roundTrip(ctx, []byte{natpmpVersion, 0}, func(b []byte) bool {
+		return len(b) >= 12 && b[1] == 128
+	})
+	if err == nil && binary.BigEndian.Uint16(res[2:]) == 0 {
+		m.ExternalIP = netip.AddrFrom4([4]byte(res[8:12]))
+	}
+	return m, nil
+}
+
+func (c *natpmpClient) mapPCP(ctx context.Context, protocol string, internal, external int, lifetime time.Duration) (PortMapping, error) {
+	client, err := localAddrTo(c.gateway)
+	if err != nil {
+		return PortMapping{}, err
+	}
+	proto := byte(17)
+	if protocol == "TCP" {
+		proto = 6
+	}
+	var nonce [12]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return PortMapping{}, err
+	}
+	req := make([]byte, 60)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:], uint32(lifetime/time.Second))
+	clientIP := client.As16()
+	copy(req[8:24], clientIP[:])
+	copy(req[24:36], nonce[:])
+	req[36] = proto
+	binary.BigEndian.PutUint16(req[40:], uint16(internal))
+	binary.BigEndian.PutUint16(req[42:], uint16(external))
+	// no address suggested, the IPv4-mapped any address
+	anyIP := netip.IPv4Unspecified().As16()
+	copy(req[44:60], anyIP[:])
+
+	res, err := c.roundTrip(ctx, req, func(b []byte) bool {
+		// NAT-PMP servers answer with their own version and the unsupported version code
+		if len(b) >= 4 && b[0] == natpmpVersion {
+			return true
+		}
+		return len(b) >= 60 && b[0] == pcpVersion && b[1] == 0x80|pcpOpMap && [12]byte(b[24:36]) == nonce
+	})
+	if err != nil {
+		return PortMapping{}, err
+	}
+	if res[0] != pcpVersion {
+		return PortMapping{}, errUnsupportedVersion
+	}
+	if code := res[3]; code != 0 {
+		if code == natpmpUnsupportedVersion {
+			return PortMapping{}, errUnsupportedVersion
+		}
+		return PortMapping{}, fmt.Errorf("PCP: gateway refused the mapping with result %d", code)
+	}
+	return PortMapping{
+		Protocol:     protocol,
+		InternalPort: internal,
+		ExternalPort: int(binary.BigEndian.Uint16(res[42:])),
+		ExternalIP:   netip.AddrFrom16([16]byte(res[44:60])).Unmap(),
+		Method:       c.name(),
+		Expires:      time.Now().Add(time.Duration(binary.BigEndian.Uint32(res[4:])) * time.Second),
+	}, nil
+}
+
+// this function returns the local address packets to addr are sent from
+func localAddrTo(addr *net.UDPAddr) (netip.Addr, error) {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
@@ -0,0 +1,169 @@
+// This file pins outgoing peer connections to a local address, network interface and
+// source port range. With a VPN up next to the regular uplink this keeps swarm traffic on
+// the VPN: if the bound interface has no usable address the dial fails instead of quietly
+// going out the default route
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// source ports tried per dial before giving up on a busy range
+const maxBindAttempts = 16
+
+// OutgoingBind restricts where outgoing connections come from, the zero value doesn't
+// restrict anything
+type OutgoingBind struct {
+	// IP is the local address to connect from
+	IP netip.Addr
+	// Interface is the network interface to connect through, e.g. "tun0". Connections use
+	// its address of the right family, and on Linux the socket is bound to the device too
+	Interface string
+	// MinPort and MaxPort restrict the source port, zero MinPort leaves it to the OS
+	MinPort uint16
+	MaxPort uint16
+}
+
+var ErrNoBindAddress = errors.New("bound interface has no usable address")
+
+// SetOutgoingBind restricts outgoing peer connections, see OutgoingBind
+func (d *PeerDialer) SetOutgoingBind(b OutgoingBind) error {
+	if b.MinPort != 0 && b.MaxPort != 0 && b.MaxPort < b.MinPort {
+		return fmt.Errorf("source port range %d-%d is empty", b.MinPort, b.MaxPort)
+	}
+	if b.Interface != "" {
+		_, err := net.InterfaceByName(b.Interface)
+		if err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bind = b
+	return nil
+}
+
+func (d *PeerDialer) OutgoingBind() OutgoingBind {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bind
+}
+
+// this function opens a TCP connection to addr honouring the outgoing bind settings
+func (d *PeerDialer) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	b := d.OutgoingBind()
+	if !b.IP.IsValid() && b.Interface == "" && b.MinPort == 0 {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	remote, err := resolveAddrPort(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	addr = remote.String()
+	local, err := b.localIP(remote.Addr().Unmap().Is4())
+	if err != nil {
+		return nil, err
+	}
+	if b.MinPort == 0 {
+		dialer := b.dialer(local, 0)
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	maxPort := b.MaxPort
+	if maxPort == 0 {
+		maxPort = b.MinPort
+	}
+	span := int(maxPort-b.MinPort) + 1
+	start := rand.IntN(span)
+	attempts := min(span, maxBindAttempts)
+	for i := 0; i < attempts; i++ {
+		port := b.MinPort + uint16((start+i)%span)
+		dialer := b.dialer(local, port)
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no free source port in %d-%d", b.MinPort, maxPort)
+}
+
+// peers are always IP addresses, but a proxy may be given by name
+func resolveAddrPort(ctx context.Context, addr string) (netip.AddrPort, error) {
+	ap, err := netip.ParseAddrPort(addr)
+	if err == nil {
+		return ap, nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, "tcp", portStr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(ips[0], uint16(port)), nil
+}
+
+func (b OutgoingBind) dialer(ip netip.Addr, port uint16) *net.Dialer {
+	dialer := &net.Dialer{Control: bindDeviceControl(b.Interface)}
+	if ip.IsValid() || port != 0 {
+		dialer.LocalAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port))
+	}
+	return dialer
+}
+
+// this function returns the local address to connect from for an IPv4 or IPv6 peer, the
+// zero Addr lets the OS pick
+func (b OutgoingBind) localIP(v4 bool) (netip.Addr, error) {
+	if b.Interface == "" {
+		if b.IP.IsValid() && b.IP.Unmap().Is4() != v4 {
+			return netip.Addr{}, fmt.Errorf("can't reach peer from bound address %v", b.IP)
+		}
+		return b.IP.Unmap(), nil
+	}
+	iface, err := net.InterfaceByName(b.Interface)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return netip.Addr{}, fmt.Errorf("interface %s is down", b.Interface)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.Is4() != v4 || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if b.IP.IsValid() && ip != b.IP.Unmap() {
+			continue
+		}
+		return ip, nil
+	}
+	return netip.Addr{}, fmt.Errorf("%w: %s", ErrNoBindAddress, b.Interface)
+}
@@ -0,0 +1,125 @@
+// This file keeps incomplete files under a temporary name. With a PartSuffix set on
+// FilesystemStorage a file is written as name.part, or whatever the suffix is, and renamed
+// to its final name once all of its pieces are verified, so other programs and people
+// can tell finished files from partial ones at a glance. Files are renamed as they complete,
+// when the download starts, and after a recheck found them complete. A file that is already
+// on disk under its final name keeps it, and so does a file that became incomplete again
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// partFileStorage is implemented by storages that keep incomplete files under another name
+type partFileStorage interface {
+	// finishFile gives file i its final name, it must only be called once it is complete
+	finishFile(i int) error
+}
+
+// this function makes the storage keep incomplete files under their name with suffix.
+// files that are on disk already are used where they are
+func (s *FileStorage) usePartSuffix(suffix string) error {
+	if strings.ContainsAny(suffix, `/\`) {
+		return fmt.Errorf("invalid part suffix %q", suffix)
+	}
+	for i, sf := range s.files {
+		if s.spans[i].Padding || s.spans[i].Length == 0 {
+			continue
+		}
+		if _, err := os.Lstat(sf.final); err == nil {
+			if _, err := os.Lstat(sf.final + suffix); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		sf.path = sf.final + suffix
+	}
+	return nil
+}
+
+func (s *FileStorage) finishFile(i int) error {
+	sf := s.files[i]
+	s.pool.mu.Lock()
+	defer s.pool.mu.Unlock()
+	if sf.path == sf.final {
+		return nil
+	}
+	err := confinePath(s.root, sf.final)
+	if err != nil {
+		return err
+	}
+	// an idle handle is closed first, not every system can rename an open file
+	if sf.f != nil && sf.users == 0 {
+		s.pool.lru.Remove(sf.elem)
+		f := sf.f
+		sf.f, sf.elem, sf.dirty = nil, nil, false
+		defer f.Close()
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	err = os.Rename(sf.path, sf.final)
+	if err != nil {
+		return err
+	}
+	sf.path = sf.final
+	return nil
+}
+
+// this function stats the file at path the way the storage finds it: under its name with
+// suffix while that exists, under its final name otherwise
+func statPartFile(path, suffix string) (os.FileInfo, error) {
+	if suffix != "" {
+		info, err := os.Stat(path + suffix)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return info, err
+		}
+	}
+	return os.Stat(path)
+}
+
+// this function returns the part suffix of the download's storage, the caller must hold d.mu
+func (d *Download) partSuffix() string {
+	if fs, ok := d.backend.(FilesystemStorage); ok {
+		return fs.PartSuffix
+	}
+	return ""
+}
+
+// this function renames the complete files among those overlapping pieces first to last to
+// their final names, and gives them their modification times when those are preserved.
+// the caller must hold d.mu
+func (d *Download) finishFiles(first, last int) {
+	storage, rename := d.storage.(partFileStorage)
+	if !rename && !d.preserveModTimes {
+		return
+	}
+	for i, f := range d.Torrent.files() {
+		from, to, ok := d.Torrent.filePieces(f)
+		if !ok || to < first || from > last {
+			continue
+		}
+		done := true
+		for index := from; index <= to && done; index++ {
+			done = d.have.HasPiece(index)
+		}
+		if !done {
+			continue
+		}
+		if rename {
+			err := storage.finishFile(i)
+			if err != nil {
+				err = fmt.Errorf("renaming completed file %d: %w", i, err)
+				d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+			}
+		}
+		err := d.applyModTime(i)
+		if err != nil {
+			err = fmt.Errorf("setting the modification time of file %d: %w", i, err)
+			d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// This file carries pieces that were only partly downloaded over a restart. When resume data
+// is saved, the blocks received so far are written to their place on disk and listed in
+// the resume data, and the next Start reads them back, so only the missing blocks have to
+// be downloaded again. Until the whole piece is there and hashed, the blocks are no more
+// trusted than any other download in progress
+package bittorrentclient
+
+// ResumePiece lists the blocks of an unfinished piece that were written to disk
+type ResumePiece struct {
+	Index  int   `json:"index"`
+	Blocks []int `json:"blocks"`
+}
+
+// this function writes the received blocks of every piece in progress to storage and
+// returns them for the resume data. nothing is saved while storage is closed
+func (d *Download) savePartialPieces() []ResumePiece {
+	type partial struct {
+		ResumePiece
+		data []byte
+	}
+	d.mu.Lock()
+	storage := d.storage
+	var partials []partial
+	for index, ap := range d.active {
+		var blocks []int
+		for i, ok := range ap.received {
+			if ok {
+				blocks = append(blocks, i)
+			}
+		}
+		if len(blocks) > 0 {
+			// copied, more blocks can arrive while we write
+			data := append([]byte(nil), ap.data...)
+			partials = append(partials, partial{ResumePiece{Index: index, Blocks: blocks}, data})
+		}
+	}
+	d.mu.Unlock()
+	if storage == nil {
+		return nil
+	}
+
+	var saved []ResumePiece
+	for _, p := range partials {
+		ok := true
+		for _, i := range p.Blocks {
+			begin := i * BlockSize
+			end := min(begin+BlockSize, len(p.data))
+			_, err := storage.WritePieceAt(p.Index, p.data[begin:end], int64(begin))
+			if err != nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			saved = append(saved, p.ResumePiece)
+		}
+	}
+	return saved
+}
+
+// this function reads the blocks of pieces listed in the resume data back from storage and
+// puts the pieces back in progress. the caller must hold d.mu, with storage open
+func (d *Download) restorePartialPieces() {
+	partial := d.partial
+	d.partial = nil
+	for _, rp := range partial {
+		if rp.Index < 0 || rp.Index >= d.Torrent.NumPieces() || d.have.HasPiece(rp.Index) {
+			continue
+		}
+		if _, ok := d.active[rp.Index]; ok {
+			continue
+		}
+		ap := newActivePiece(rp.Index, d.Torrent.PieceSize(rp.Index))
+		for _, i := range rp.Blocks {
+			if i < 0 || i >= len(ap.received) || ap.received[i] {
+				continue
+			}
+			b := ap.block(i)
+			n, _ := d.storage.ReadPieceAt(rp.Index, ap.data[b.Begin:b.Begin+b.Length], int64(b.Begin))
+			if n == b.Length {
+				ap.received[i] = true
+				ap.remaining--
+			}
+		}
+		// nothing could be read back, or every block was there, which a piece in progress
+		// never has. either way it's simplest to download the piece from scratch
+		if ap.remaining == len(ap.received) || ap.remaining == 0 {
+			continue
+		}
+		d.active[rp.Index] = ap
+		d.picker.MarkInProgress(rp.Index)
+	}
+}
@@ -0,0 +1,104 @@
+// This file keeps storage inside the download directory. Torrent paths are checked when
+// they are turned into file names already, but a symlink inside the directory, planted
+// there or left from an earlier download, can still lead outside it. Before a file is
+// created or opened its path is resolved through every symlink that exists so far and has
+// to end up under the resolved download directory
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// this function returns an error unless path, with symlinks resolved, is inside root.
+// the parts of path that don't exist yet can't be links, so they are taken as they are.
+// a link whose target doesn't exist is followed all the same, creating a file through it
+// creates the target
+func confinePath(root, path string) error {
+	realRoot, err := resolvePath(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return fmt.Errorf("%s resolves to %s, outside the download directory", path, realPath)
+	}
+	return nil
+}
+
+// the symlinks followed while resolving one path before giving up, as the kernel does
+const maxSymlinks = 40
+
+// this function resolves the symlinks of the longest existing prefix of path and appends
+// the rest
+func resolvePath(path string) (string, error) {
+	links := 0
+	return resolveLinks(path, &links)
+}
+
+// this function is resolvePath, counting the links followed in links
+func resolveLinks(path string, links *int) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var rest []string
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{real}, rest...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		// a dangling link doesn't resolve, but a file created through it lands at its target
+		if info, lerr := os.Lstat(path); lerr == nil && info.Mode()&fs.ModeSymlink != 0 {
+			*links++
+			if *links > maxSymlinks {
+				return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+			}
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				// relative to the link's directory as it really is, .. included
+				dir, err := resolveLinks(filepath.Dir(path), links)
+				if err != nil {
+					return "", err
+				}
+				target = filepath.Join(dir, target)
+			}
+			path = target
+			continue
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
+
+// this function creates the directories for path, making sure it stays inside root first
+// and that no directory on the way turned out to lead elsewhere afterwards
+func mkdirConfined(root, path string) error {
+	err := confinePath(root, path)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	return confinePath(root, path)
+}
@@ -0,0 +1,102 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mybittorrent/internal/bencode"
+)
+
+func TestConfinePathDanglingLinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	links := map[string]string{
+		// a file that doesn't exist yet, outside and inside
+		"out":      filepath.Join(outside, "missing"),
+		"in":       filepath.Join(root, "missing"),
+		"relative": filepath.Join("..", filepath.Base(outside), "missing"),
+		// a directory that doesn't exist yet on the way to the file
+		"outdir": filepath.Join(outside, "dir"),
+		"loop":   "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks unsupported:", err)
+		}
+	}
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"out", false},
+		{"relative", false},
+		{filepath.Join("outdir", "file"), false},
+		{"loop", false},
+		{"in", true},
+		{filepath.Join("new", "file"), true},
+	}
+	for _, tt := range tests {
+		err := confinePath(root, filepath.Join(root, tt.path))
+		if (err == nil) != tt.ok {
+			t.Errorf("confinePath(%s) = %v, want ok %v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestRemoveDataStaysInside(t *testing.T) {
+	metainfo, err := bencode.Encode(map[string]interface{}{
+		"announce": "http://127.0.0.1:1/announce",
+		"info": map[string]interface{}{
+			"name":         "multi",
+			"piece length": int64(16 << 10),
+			"pieces":       make([]byte, 20),
+			"files": []interface{}{
+				map[string]interface{}{"length": int64(16 << 10), "path": []interface{}{"sub", "file"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := DecodeTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(WithListenHost("127.0.0.1"), WithListenPort(0), WithDHT(false), WithDownloadDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	d, err := client.AddTorrent(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Stop()
+
+	// a directory of the torrent is swapped for a link to a file of the same name elsewhere
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "file")
+	err = os.WriteFile(victim, []byte("keep"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(d.Dir, "multi", "sub")
+	err = os.RemoveAll(sub)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(sub), 0o755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, sub); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+	if err := client.Remove(torrent.InfoHash, true); err == nil {
+		t.Error("removing through a link out of the download directory succeeded")
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Errorf("the file the link leads to was removed: %v", err)
+	}
+}
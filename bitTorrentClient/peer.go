@@ -0,0 +1,475 @@
+// This file wraps the connection to a single peer. It performs the handshake and keeps track
+// of the choke/interest state on both sides, which pieces the peer has, and the block
+// requests we still have outstanding with it
+package bittorrentclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+type blockRequest struct {
+	Index  int
+	Begin  int
+	Length int
+}
+
+// until the number of pieces is known, a piece index only has to fit the largest torrent,
+// one whose metadata holds nothing but piece hashes
+const maxPieces = maxMetadataSize / sha1.Size
+
+// reads from a peer go through a buffer so the small header reads don't each cost a syscall
+const peerReadBufferSize = 32 * 1024
+
+type Peer struct {
+	conn      *rateLimitedConn
+	reader    *bufio.Reader
+	Addr      string
+	ID        [20]byte
+	Reserved  [8]byte
+	Transport Transport
+	// Encrypted is set when the connection is RC4 encrypted, see encryption.go
+	Encrypted bool
+	// Source is where the download learned of the peer, it is set before the peer is used
+	Source PeerSource
+
+	uploadLimit   *RateLimiter
+	downloadLimit *RateLimiter
+	readTimeout   time.Duration
+
+	// outgoing messages are queued and written by a dedicated goroutine, see peerWriter.go
+	outMu     sync.Mutex
+	outCond   *sync.Cond
+	outQueue  []outgoing
+	outBytes  int
+	outClosed bool
+
+	mu             sync.Mutex
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+	bitfield       Bitfield
+	pending        map[blockRequest]time.Time
+	lastBlock      time.Time
+	snubbed        bool
+	connectedAt    time.Time
+	downloaded     int64
+	uploaded       int64
+	downRate       rateMeter
+	upRate         rateMeter
+	piecesReceived int
+	hashFailures   int
+	latency        time.Duration
+	timeouts       int
+	recovered      int
+	queuedHaves    []int
+	numPieces      int
+	haveAll        bool
+	allowedFast    map[int]bool
+	grantedFast    map[int]bool
+	// extensions maps the names in the peer's extended handshake to its message ids
+	extensions   map[string]int
+	metadataSize int
+	// maxRequests is the reqq of the peer's extended handshake, zero when it gave none
+	maxRequests int
+}
+
+// NewPeer performs the handshake over an already open connection and checks that the
+// remote end is serving the torrent we asked for. The whole handshake has to complete
+// within the handshake timeout
+func NewPeer(conn net.Conn, infoHash, peerID [20]byte) (*Peer, error) {
+	conn.SetDeadline(time.Now().Add(defaultHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	req := Handshake{InfoHash: infoHash, PeerID: peerID}
+	req.Reserved[reservedFastByte] |= reservedFastBit
+	req.Reserved[reservedExtensionByte] |= reservedExtensionBit
+	_, err := conn.Write(req.Serialize())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+	res, err := ReadHandshake(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+	if !bytes.Equal(res.InfoHash[:], infoHash[:]) {
+		return nil, fmt.Errorf("%w: expected infohash %x but got %x", ErrHandshakeFailed, infoHash, res.InfoHash)
+	}
+	return newPeer(conn, res), nil
+}
+
+// DialPeer connects to addr using d and performs the handshake. The attempt holds one of the
+// dialer's half open slots until the handshake is done and gives up after the dial timeout
+func DialPeer(ctx context.Context, d *PeerDialer, addr string, infoHash, peerID [20]byte) (*Peer, error) {
+	err := d.acquireHalfOpen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer d.releaseHalfOpen()
+
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout+d.UTPDialTimeout)
+	defer cancel()
+	conn, transport, err := d.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.Encryption != EncryptionDisabled {
+		conn, err = encryptConn(ctx, conn, infoHash, d.Encryption)
+		if err != nil && d.Encryption == EncryptionPreferred {
+			// the peer may just not speak it, a plaintext connection will do
+			conn, transport, err = d.Dial(ctx, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	p, err := NewPeer(conn, infoHash, peerID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p.Transport = transport
+	return p, nil
+}
+
+func newPeer(conn net.Conn, h *Handshake) *Peer {
+	upload := NewRateLimiter(0)
+	download := NewRateLimiter(0)
+	limited := &rateLimitedConn{
+		Conn:          conn,
+		readLimiters:  []*RateLimiter{download},
+		writeLimiters: []*RateLimiter{upload},
+		writeTimeout:  defaultWriteTimeout,
+	}
+	p := &Peer{
+		conn:          limited,
+		reader:        bufio.NewReaderSize(limited, peerReadBufferSize),
+		Addr:          conn.RemoteAddr().String(),
+		ID:            h.PeerID,
+		Reserved:      h.Reserved,
+		Encrypted:     connEncrypted(conn),
+		uploadLimit:   upload,
+		downloadLimit: download,
+		amChoking:     true,
+		peerChoking:   true,
+		pending:       make(map[blockRequest]time.Time),
+		connectedAt:   time.Now(),
+		allowedFast:   make(map[int]bool),
+		grantedFast:   make(map[int]bool),
+		readTimeout:   defaultReadTimeout,
+	}
+	p.outCond = sync.NewCond(&p.outMu)
+	go p.writeLoop()
+	return p
+}
+
+func (p *Peer) Close() error {
+	p.closeOutgoing()
+	return p.conn.Close()
+}
+
+// ReadMessage reads the next message from the peer and applies it to the connection
+// state before handing it back, so callers only need to act on the payload. The message
+// should be released once the caller is done with it
+func (p *Peer) ReadMessage() (*Message, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+	if timeout > 0 {
+		p.conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	msg, err := ReadMessage(p.reader)
+	if err != nil || msg == nil {
+		return msg, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch msg.ID {
+	case MsgChoke:
+		// a choking peer discards every request we had queued with it, fast peers tell
+		// us which ones with explicit rejects instead
+		p.peerChoking = true
+		if !fastSupported(p.Reserved) {
+			p.pending = make(map[blockRequest]time.Time)
+		}
+	case MsgUnchoke:
+		p.peerChoking = false
+	case MsgInterested:
+		p.peerInterested = true
+	case MsgNotInterested:
+		p.peerInterested = false
+	case MsgHave:
+		index, err := ParseHave(msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkIndex(index); err != nil {
+			return nil, err
+		}
+		p.setPiece(index)
+	case MsgBitfield:
+		if err := p.checkBitfield(msg.Payload); err != nil {
+			return nil, err
+		}
+		p.bitfield = append(Bitfield(nil), msg.Payload...)
+	case MsgHaveAll:
+		p.haveAll = true
+		p.fillBitfield()
+	case MsgHaveNone:
+		p.bitfield = NewBitfield(p.numPieces)
+	case MsgReject:
+		index, begin, length, err := ParseRequest(&Message{ID: MsgRequest, Payload: msg.Payload})
+		if err != nil {
+			return nil, err
+		}
+		delete(p.pending, blockRequest{Index: index, Begin: begin, Length: length})
+	case MsgAllowedFast:
+		index, err := ParseHave(&Message{ID: MsgHave, Payload: msg.Payload})
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkIndex(index); err != nil {
+			return nil, err
+		}
+		p.allowedFast[index] = true
+	case MsgPiece:
+		index, begin, block, err := ParsePiece(msg)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		p.downloaded += int64(len(block))
+		p.downRate.add(int64(len(block)), now)
+		req := blockRequest{Index: index, Begin: begin, Length: len(block)}
+		if sentAt, ok := p.pending[req]; ok {
+			delete(p.pending, req)
+			p.recordLatency(now.Sub(sentAt))
+			p.lastBlock = now
+			p.snubbed = false
+			p.recoverFromTimeouts()
+		}
+	case MsgExtended:
+		id, payload, err := ParseExtended(msg)
+		if err != nil {
+			return nil, err
+		}
+		if id == extHandshakeID {
+			if err := p.readExtendedHandshake(payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return msg, nil
+}
+
+// SetNumPieces tells the peer how many pieces the torrent has, which is needed to expand a
+// have all message into a bitfield and to check the pieces the peer claims to have
+func (p *Peer) SetNumPieces(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.numPieces = n
+	if p.haveAll {
+		p.fillBitfield()
+		return
+	}
+	// what arrived before only had to fit the largest torrent, keep the pieces of this one
+	if p.bitfield != nil {
+		bf := NewBitfield(n)
+		for i := 0; i < n; i++ {
+			if p.bitfield.HasPiece(i) {
+				bf.SetPiece(i)
+			}
+		}
+		p.bitfield = bf
+	}
+}
+
+// this function returns an error unless index is a piece of the torrent, or of the largest
+// torrent while the number of pieces isn't known. the caller must hold p.mu
+func (p *Peer) checkIndex(index int) error {
+	limit := p.numPieces
+	if limit == 0 {
+		limit = maxPieces
+	}
+	if index < 0 || index >= limit {
+		return fmt.Errorf("piece index %d out of range, the torrent has %d pieces", index, limit)
+	}
+	return nil
+}
+
+// this function returns an error unless bf has exactly a bit per piece and the spare bits
+// of its last byte are clear. the caller must hold p.mu
+func (p *Peer) checkBitfield(bf []byte) error {
+	if p.numPieces == 0 {
+		if len(bf) > (maxPieces+7)/8 {
+			return fmt.Errorf("bitfield of %d bytes is too long", len(bf))
+		}
+		return nil
+	}
+	if want := (p.numPieces + 7) / 8; len(bf) != want {
+		return fmt.Errorf("bitfield of %d bytes, want %d", len(bf), want)
+	}
+	if spare := p.numPieces % 8; spare != 0 && bf[len(bf)-1]&(0xff>>spare) != 0 {
+		return errors.New("bitfield has spare bits set")
+	}
+	return nil
+}
+
+// this function marks every piece as available. the caller must hold p.mu
+func (p *Peer) fillBitfield() {
+	p.bitfield = NewBitfield(p.numPieces)
+	for i := 0; i < p.numPieces; i++ {
+		p.bitfield.SetPiece(i)
+	}
+}
+
+// this function marks a piece as available, growing the bitfield if needed. the caller must hold p.mu
+func (p *Peer) setPiece(index int) {
+	if index < 0 {
+		return
+	}
+	if need := index/8 + 1; len(p.bitfield) < need {
+		p.bitfield = append(p.bitfield, make(Bitfield, need-len(p.bitfield))...)
+	}
+	p.bitfield.SetPiece(index)
+}
+
+// Send queues a message for the peer, it is safe to call from multiple goroutines and
+// never blocks on the network
+func (p *Peer) Send(msg *Message) error {
+	return p.enqueue(outgoing{buf: msg.Serialize()})
+}
+
+func (p *Peer) SendChoke() error {
+	p.mu.Lock()
+	p.amChoking = true
+	p.mu.Unlock()
+	return p.Send(&Message{ID: MsgChoke})
+}
+
+func (p *Peer) SendUnchoke() error {
+	p.mu.Lock()
+	p.amChoking = false
+	p.mu.Unlock()
+	return p.Send(&Message{ID: MsgUnchoke})
+}
+
+func (p *Peer) SendInterested() error {
+	p.mu.Lock()
+	p.amInterested = true
+	p.mu.Unlock()
+	return p.Send(&Message{ID: MsgInterested})
+}
+
+func (p *Peer) SendNotInterested() error {
+	p.mu.Lock()
+	p.amInterested = false
+	p.mu.Unlock()
+	return p.Send(&Message{ID: MsgNotInterested})
+}
+
+func (p *Peer) SendHave(index int) error {
+	return p.Send(FormatHave(index))
+}
+
+func (p *Peer) SendBitfield(bf Bitfield) error {
+	return p.Send(&Message{ID: MsgBitfield, Payload: bf})
+}
+
+// SendRequest asks the peer for a block and remembers it until the block arrives
+func (p *Peer) SendRequest(index, begin, length int) error {
+	p.mu.Lock()
+	p.pending[blockRequest{Index: index, Begin: begin, Length: length}] = time.Now()
+	p.mu.Unlock()
+	return p.Send(FormatRequest(index, begin, length))
+}
+
+func (p *Peer) SendCancel(index, begin, length int) error {
+	p.mu.Lock()
+	delete(p.pending, blockRequest{Index: index, Begin: begin, Length: length})
+	p.mu.Unlock()
+	return p.Send(FormatCancel(index, begin, length))
+}
+
+// SendPiece serializes the piece message into a pooled buffer, this runs for every block we upload
+func (p *Peer) SendPiece(index, begin int, block []byte) error {
+	buf := getBuffer(13 + len(block))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(9+len(block)))
+	buf[4] = byte(MsgPiece)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(index))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(begin))
+	copy(buf[13:], block)
+	return p.enqueue(outgoing{buf: buf, pooled: true, pieceBytes: len(block)})
+}
+
+// AmChoking reports whether we are choking the peer
+func (p *Peer) AmChoking() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.amChoking
+}
+
+// AmInterested reports whether we told the peer we want pieces from it
+func (p *Peer) AmInterested() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.amInterested
+}
+
+// PeerChoking reports whether the peer is choking us
+func (p *Peer) PeerChoking() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peerChoking
+}
+
+// PeerInterested reports whether the peer wants pieces from us
+func (p *Peer) PeerInterested() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peerInterested
+}
+
+func (p *Peer) HasPiece(index int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bitfield.HasPiece(index)
+}
+
+// Bitfield returns a copy of the pieces the peer advertised
+func (p *Peer) Bitfield() Bitfield {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append(Bitfield(nil), p.bitfield...)
+}
+
+// PendingRequests returns how many requested blocks haven't arrived yet
+func (p *Peer) PendingRequests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
+
+// Downloaded returns the number of piece bytes received from the peer
+func (p *Peer) Downloaded() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.downloaded
+}
+
+// Uploaded returns the number of piece bytes sent to the peer
+func (p *Peer) Uploaded() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.uploaded
+}
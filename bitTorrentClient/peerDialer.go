@@ -0,0 +1,197 @@
+// This file handles opening transport connections to peers. Each peer can be reached over
+// uTP or TCP, uTP is tried first when enabled and we fall back to TCP if the peer doesn't answer.
+// The dialer also limits how many connections may be half open (connecting or handshaking) at
+// once, so thousands of discovered peers don't exhaust file descriptors or router NAT tables.
+// With a SOCKS5 proxy configured every connection goes through it over TCP, uTP is skipped
+// so no traffic leaves from our own address, and likewise with an outgoing bind
+package bittorrentclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+type Transport int
+
+const (
+	TransportTCP Transport = iota
+	TransportUTP
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportTCP:
+		return "tcp"
+	case TransportUTP:
+		return "utp"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	defaultDialTimeout    = 10 * time.Second
+	defaultUTPDialTimeout = 4 * time.Second
+	defaultMaxHalfOpen    = 32
+)
+
+type PeerDialer struct {
+	// UTP is the socket outgoing uTP connections are made from, nil disables uTP entirely
+	UTP *UTPSocket
+	// PreferUTP makes uTP the first choice for peers that have no transport pinned
+	PreferUTP      bool
+	Timeout        time.Duration
+	UTPDialTimeout time.Duration
+	// Proxy tunnels every peer connection through a SOCKS5 proxy when set
+	Proxy *SOCKS5Proxy
+	// Encryption decides whether DialPeer encrypts the connections it makes
+	Encryption EncryptionPolicy
+
+	mu          sync.Mutex
+	pinned      map[string]Transport
+	bind        OutgoingBind
+	maxHalfOpen int
+	halfOpen    int
+	slotFreed   chan struct{}
+}
+
+func NewPeerDialer(utp *UTPSocket) *PeerDialer {
+	return &PeerDialer{
+		UTP:            utp,
+		PreferUTP:      utp != nil,
+		Timeout:        defaultDialTimeout,
+		UTPDialTimeout: defaultUTPDialTimeout,
+		pinned:         make(map[string]Transport),
+		maxHalfOpen:    defaultMaxHalfOpen,
+	}
+}
+
+// SetMaxHalfOpen changes how many connection attempts may be in progress at once, zero
+// removes the limit
+func (d *PeerDialer) SetMaxHalfOpen(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxHalfOpen = n
+	d.wakeHalfOpen()
+}
+
+// HalfOpen returns the number of connection attempts currently in progress
+func (d *PeerDialer) HalfOpen() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.halfOpen
+}
+
+// this function blocks until a half open slot is free or ctx is done
+func (d *PeerDialer) acquireHalfOpen(ctx context.Context) error {
+	for {
+		d.mu.Lock()
+		if d.maxHalfOpen <= 0 || d.halfOpen < d.maxHalfOpen {
+			d.halfOpen++
+			d.mu.Unlock()
+			return nil
+		}
+		if d.slotFreed == nil {
+			d.slotFreed = make(chan struct{})
+		}
+		wait := d.slotFreed
+		d.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *PeerDialer) releaseHalfOpen() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.halfOpen--
+	d.wakeHalfOpen()
+}
+
+// this function wakes everyone waiting for a slot, the caller must hold d.mu
+func (d *PeerDialer) wakeHalfOpen() {
+	if d.slotFreed != nil {
+		close(d.slotFreed)
+		d.slotFreed = nil
+	}
+}
+
+// AcceptsIncoming reports whether peers can be expected to connect to us. Behind a proxy
+// they can't, our listening port isn't reachable through it and shouldn't be announced
+func (d *PeerDialer) AcceptsIncoming() bool {
+	return d.Proxy == nil
+}
+
+// this function returns the port to announce for the listening port, zero when peers
+// can't connect to us
+func (d *PeerDialer) announcePort(port int) int {
+	if !d.AcceptsIncoming() {
+		return 0
+	}
+	return port
+}
+
+// SetTransport pins the transport used to reach a single peer, overriding PreferUTP
+func (d *PeerDialer) SetTransport(addr string, t Transport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pinned[addr] = t
+}
+
+// this function returns the transport we should try first for addr. With an outgoing bind
+// it is TCP, uTP packets leave from the socket it listens on wherever that is
+func (d *PeerDialer) transportFor(addr string) Transport {
+	if d.UTP == nil || d.Proxy != nil {
+		return TransportTCP
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.bind != (OutgoingBind{}) {
+		return TransportTCP
+	}
+	if t, ok := d.pinned[addr]; ok {
+		return t
+	}
+	if d.PreferUTP {
+		return TransportUTP
+	}
+	return TransportTCP
+}
+
+// Dial opens the transport connection to addr and reports which transport was used. When
+// uTP fails the peer is pinned to TCP so later dials don't waste time on it again. Dial
+// doesn't take a half open slot, DialPeer does that for the whole connect and handshake
+func (d *PeerDialer) Dial(ctx context.Context, addr string) (net.Conn, Transport, error) {
+	if d.transportFor(addr) == TransportUTP {
+		uctx, cancel := context.WithTimeout(ctx, d.UTPDialTimeout)
+		conn, err := d.UTP.DialContext(uctx, addr)
+		cancel()
+		if err == nil {
+			return conn, TransportUTP, nil
+		}
+		if ctx.Err() != nil {
+			return nil, TransportUTP, ctx.Err()
+		}
+		d.SetTransport(addr, TransportTCP)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if d.Proxy != nil {
+		conn, err = d.Proxy.DialContext(tctx, d.dialTCP, addr)
+	} else {
+		conn, err = d.dialTCP(tctx, addr)
+	}
+	if err != nil {
+		return nil, TransportTCP, err
+	}
+	return conn, TransportTCP, nil
+}
@@ -0,0 +1,120 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+// this function returns a single file torrent of one zeroed piece announcing to announce
+func testTorrent(t *testing.T, announce string) *Torrent {
+	t.Helper()
+	piece := make([]byte, 16<<10)
+	hash := sha1.Sum(piece)
+	metainfo, err := bencode.Encode(map[string]interface{}{
+		"announce": announce,
+		"info": map[string]interface{}{
+			"name":         "test",
+			"piece length": int64(len(piece)),
+			"pieces":       hash[:],
+			"length":       int64(len(piece)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := DecodeTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrent
+}
+
+func TestProxyHidesListeningPort(t *testing.T) {
+	ports := make(chan string, 10)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ports <- r.URL.Query().Get("port")
+		data, _ := bencode.Encode(map[string]interface{}{"interval": int64(60), "peers": ""})
+		w.Write(data)
+	}))
+	defer tracker.Close()
+
+	client, err := NewClient(
+		WithListenHost("127.0.0.1"),
+		WithListenPort(0),
+		WithDHT(false),
+		WithDownloadDir(t.TempDir()),
+		// nothing listens there, the test never dials out
+		WithProxy(&SOCKS5Proxy{Addr: "127.0.0.1:1"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	d, err := client.AddTorrent(testTorrent(t, tracker.URL+"/announce"), WithStorage(MemoryStorage{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case port := <-ports:
+		if port != "0" {
+			t.Errorf("announced port %s behind a proxy, want 0", port)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no announce")
+	}
+
+	conn, err := net.Dial("tcp", client.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	h := Handshake{InfoHash: d.InfoHash}
+	_, err = conn.Write(h.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// the handshake is left unread, so hanging up can reset the connection as well
+	n, err := io.ReadFull(conn, make([]byte, 1))
+	if n != 0 || err == nil || os.IsTimeout(err) {
+		t.Errorf("incoming peer behind a proxy read %d bytes, %v, want to be hung up on", n, err)
+	}
+}
+
+func TestClientKeepsTorrentDialer(t *testing.T) {
+	client, err := NewClient(
+		WithListenHost("127.0.0.1"),
+		WithListenPort(0),
+		WithDHT(false),
+		WithDownloadDir(t.TempDir()),
+		WithMaxHalfOpen(4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	own := NewPeerDialer(nil)
+	d, err := client.AddTorrent(testTorrent(t, "http://127.0.0.1:1/announce"), WithDialer(own))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	dialer := d.dialer
+	d.mu.Unlock()
+	if dialer != own {
+		t.Error("the client replaced the torrent's own dialer")
+	}
+	if client.dialer.maxHalfOpen != 4 {
+		t.Errorf("client dialer allows %d half open connections, want 4", client.dialer.maxHalfOpen)
+	}
+}
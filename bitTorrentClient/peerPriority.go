@@ -0,0 +1,125 @@
+// This file implements canonical peer priority (BEP 40). Both ends of a connection compute
+// the same priority for it from the two IP addresses, so when there are more candidates
+// than connection slots the whole swarm agrees on which connections to keep instead of
+// each side dropping a different half
+package bittorrentclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"net/netip"
+	"sort"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// masks for addresses in different /16s, the same /16 and the same /24
+	v4PriorityMasks = [3][4]byte{
+		{0xff, 0xff, 0x55, 0x55},
+		{0xff, 0xff, 0xff, 0x55},
+		{0xff, 0xff, 0xff, 0xff},
+	}
+	// masks for addresses in different /48s, the same /48 and the same /56
+	v6PriorityMasks = [3][16]byte{
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+)
+
+// PeerPriority returns the canonical priority of a connection between a and b, higher is
+// better. The result doesn't depend on which side computes it
+func PeerPriority(a, b netip.AddrPort) uint32 {
+	ipA, ipB := a.Addr().Unmap(), b.Addr().Unmap()
+	if ipA == ipB {
+		// same machine, fall back to the ports
+		lo, hi := a.Port(), b.Port()
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		var buf [4]byte
+		binary.BigEndian.PutUint16(buf[0:2], lo)
+		binary.BigEndian.PutUint16(buf[2:4], hi)
+		return crc32.Checksum(buf[:], castagnoli)
+	}
+
+	var x, y []byte
+	switch {
+	case ipA.Is4() && ipB.Is4():
+		a4, b4 := ipA.As4(), ipB.As4()
+		mask := v4PriorityMasks[commonPrefixClass(a4[:], b4[:], 2, 3)]
+		x, y = maskBytes(a4[:], mask[:]), maskBytes(b4[:], mask[:])
+	default:
+		a16, b16 := ipA.As16(), ipB.As16()
+		mask := v6PriorityMasks[commonPrefixClass(a16[:], b16[:], 6, 7)]
+		x, y = maskBytes(a16[:], mask[:]), maskBytes(b16[:], mask[:])
+	}
+	if bytes.Compare(x, y) > 0 {
+		x, y = y, x
+	}
+	return crc32.Checksum(append(x, y...), castagnoli)
+}
+
+// this function returns 2 when a and b share their first long bytes, 1 when they share the
+// first short bytes and 0 otherwise
+func commonPrefixClass(a, b []byte, short, long int) int {
+	switch {
+	case bytes.Equal(a[:long], b[:long]):
+		return 2
+	case bytes.Equal(a[:short], b[:short]):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func maskBytes(ip, mask []byte) []byte {
+	out := make([]byte, len(ip))
+	for i := range ip {
+		out[i] = ip[i] & mask[i]
+	}
+	return out
+}
+
+// SortByPriority orders peer addresses by their canonical priority relative to self,
+// highest first, so the best candidates are dialed first. Addresses that don't parse keep
+// their order at the end
+func SortByPriority(self netip.AddrPort, addrs []string) {
+	prio := make(map[string]int64, len(addrs))
+	for _, addr := range addrs {
+		prio[addr] = -1
+		if ap, err := netip.ParseAddrPort(addr); err == nil {
+			prio[addr] = int64(PeerPriority(self, ap))
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return prio[addrs[i]] > prio[addrs[j]]
+	})
+}
+
+// this function returns the canonical priority of the connection to p, using self as our
+// own address when it is known and the local end of the connection otherwise
+func (p *Peer) priority(self netip.AddrPort) uint32 {
+	remote, err := netip.ParseAddrPort(p.Addr)
+	if err != nil {
+		return 0
+	}
+	if !self.IsValid() {
+		self = addrPortOf(p.conn.LocalAddr())
+	}
+	return PeerPriority(self, remote)
+}
+
+func addrPortOf(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort()
+	case *net.UDPAddr:
+		return a.AddrPort()
+	}
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return ap
+}
@@ -0,0 +1,49 @@
+// This file records where the download learned of each peer: the tracker, the DHT, peer
+// exchange, local discovery, the application through AddPeers, an earlier session's resume
+// data, or the peer connecting to us. The source is kept with the address in the peer store and shows up in the peer's stats
+package bittorrentclient
+
+type PeerSource int
+
+const (
+	PeerSourceManual PeerSource = iota
+	PeerSourceTracker
+	PeerSourceDHT
+	PeerSourceIncoming
+	PeerSourcePEX
+	PeerSourceLSD
+	// PeerSourceResume are peers saved with the resume data of an earlier session
+	PeerSourceResume
+)
+
+func (s PeerSource) String() string {
+	switch s {
+	case PeerSourceManual:
+		return "manual"
+	case PeerSourceTracker:
+		return "tracker"
+	case PeerSourceDHT:
+		return "dht"
+	case PeerSourceIncoming:
+		return "incoming"
+	case PeerSourcePEX:
+		return "pex"
+	case PeerSourceLSD:
+		return "lsd"
+	case PeerSourceResume:
+		return "resume"
+	default:
+		return "unknown"
+	}
+}
+
+// this function adds peer addresses learned from source to the peer store
+func (d *Download) addPeers(source PeerSource, addrs ...string) {
+	d.peerStore.Add(source, addrs...)
+}
+
+// KnownPeers returns every peer address the download knows of with what happened when it
+// was dialed, see PeerStore
+func (d *Download) KnownPeers() []KnownPeer {
+	return d.peerStore.Peers()
+}
@@ -0,0 +1,83 @@
+// This file tracks per peer statistics: transfer totals, smoothed transfer rates, piece
+// outcomes and request latency. Stats returns a consistent snapshot for UIs and the choker
+package bittorrentclient
+
+import "time"
+
+type PeerStats struct {
+	Addr      string
+	ID        [20]byte
+	Client    ClientInfo
+	Transport Transport
+	Encrypted bool
+	Source    PeerSource
+
+	Downloaded   int64
+	Uploaded     int64
+	DownloadRate float64
+	UploadRate   float64
+
+	PiecesReceived  int
+	HashFailures    int
+	RequestTimeouts int
+	// RequestLatency is a moving average of the time between requesting a block and receiving it
+	RequestLatency time.Duration
+	ConnectedFor   time.Duration
+
+	AmChoking       bool
+	AmInterested    bool
+	PeerChoking     bool
+	PeerInterested  bool
+	Snubbed         bool
+	PendingRequests int
+}
+
+// Stats returns a snapshot of the peer's statistics
+func (p *Peer) Stats() PeerStats {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PeerStats{
+		Addr:            p.Addr,
+		ID:              p.ID,
+		Client:          IdentifyClient(p.ID),
+		Transport:       p.Transport,
+		Encrypted:       p.Encrypted,
+		Source:          p.Source,
+		Downloaded:      p.downloaded,
+		Uploaded:        p.uploaded,
+		DownloadRate:    p.downRate.rate(now),
+		UploadRate:      p.upRate.rate(now),
+		PiecesReceived:  p.piecesReceived,
+		HashFailures:    p.hashFailures,
+		RequestTimeouts: p.timeouts,
+		RequestLatency:  p.latency,
+		ConnectedFor:    now.Sub(p.connectedAt),
+		AmChoking:       p.amChoking,
+		AmInterested:    p.amInterested,
+		PeerChoking:     p.peerChoking,
+		PeerInterested:  p.peerInterested,
+		Snubbed:         p.snubbed,
+		PendingRequests: len(p.pending),
+	}
+}
+
+// this function folds a request round trip into the latency average. the caller must hold p.mu
+func (p *Peer) recordLatency(sample time.Duration) {
+	if p.latency == 0 {
+		p.latency = sample
+		return
+	}
+	p.latency += (sample - p.latency) / 8
+}
+
+// this function is called once a piece the peer contributed to passed or failed verification
+func (p *Peer) recordPieceResult(verified bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if verified {
+		p.piecesReceived++
+	} else {
+		p.hashFailures++
+	}
+}
@@ -0,0 +1,285 @@
+// This file keeps every peer address a download knows of in one place, whatever found it:
+// trackers, the DHT, peer exchange, local discovery, the application or the peer connecting
+// to us. Addresses are deduplicated in their canonical form, so the same peer reported as
+// an IPv4-mapped IPv6 address by one source and a plain IPv4 address by another is dialed
+// once. The store remembers what happened with each address, whether we ever got through
+// to it, when it was last tried and whether it is banned, and hands the dialer the most
+// promising addresses first: peers we reached before, then peers never tried, then the
+// ones tried longest ago. The peers that sent us the most are saved with the resume data
+// and restored as reachable, so a restarted download dials them right away
+package bittorrentclient
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+// KnownPeer is what the store knows about a peer address
+type KnownPeer struct {
+	Addr string
+	// Source is where the address was first learned from, Sources every source that
+	// reported it
+	Source  PeerSource
+	Sources []PeerSource
+	// Connectable is set once a connection we dialed got through the handshake
+	Connectable bool
+	Banned      bool
+	Connected   bool
+	// Failures counts the dials that failed since the last one that got through
+	Failures    int
+	LastAttempt time.Time
+	LastSeen    time.Time
+	// Downloaded is what the peer sent us over connections that closed
+	Downloaded int64
+}
+
+type peerEntry struct {
+	addr        string
+	source      PeerSource
+	sources     []PeerSource
+	connectable bool
+	connecting  bool
+	connected   bool
+	failures    int
+	lastAttempt time.Time
+	lastSeen    time.Time
+	downloaded  int64
+	// order is when the address was added, ties are dialed in the order they were found
+	order int
+}
+
+// PeerStore is the set of peer addresses of a torrent, every download has one
+type PeerStore struct {
+	mu      sync.Mutex
+	bans    *BanList
+	entries map[string]*peerEntry
+	added   int
+}
+
+func newPeerStore(bans *BanList) *PeerStore {
+	return &PeerStore{bans: bans, entries: make(map[string]*peerEntry)}
+}
+
+// this function returns the form addresses are deduplicated by, names are kept as they are
+func canonicalPeerAddr(addr string) string {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return addr
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+}
+
+// this function returns the entry of addr, adding it from source when it is new. the
+// caller must hold s.mu
+func (s *PeerStore) entry(source PeerSource, addr string) *peerEntry {
+	key := canonicalPeerAddr(addr)
+	e, ok := s.entries[key]
+	if !ok {
+		s.added++
+		e = &peerEntry{addr: key, source: source, order: s.added}
+		s.entries[key] = e
+	}
+	if !slices.Contains(e.sources, source) {
+		e.sources = append(e.sources, source)
+	}
+	return e
+}
+
+// Add adds addresses learned from source and returns how many were new. An address keeps
+// the source it was first learned from
+func (s *PeerStore) Add(source PeerSource, addrs ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.entries)
+	for _, addr := range addrs {
+		s.entry(source, addr)
+	}
+	return len(s.entries) - before
+}
+
+// Remove forgets addr
+func (s *PeerStore) Remove(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, canonicalPeerAddr(addr))
+}
+
+// Len returns the number of known addresses
+func (s *PeerStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Peers returns a snapshot of every known address, in the order they were found
+func (s *PeerStore) Peers() []KnownPeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*peerEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *peerEntry) int { return a.order - b.order })
+	peers := make([]KnownPeer, 0, len(entries))
+	for _, e := range entries {
+		peers = append(peers, KnownPeer{
+			Addr:        e.addr,
+			Source:      e.source,
+			Sources:     slices.Clone(e.sources),
+			Connectable: e.connectable,
+			Banned:      s.bans != nil && s.bans.IsBanned(e.addr),
+			Connected:   e.connected,
+			Failures:    e.failures,
+			LastAttempt: e.lastAttempt,
+			LastSeen:    e.lastSeen,
+			Downloaded:  e.downloaded,
+		})
+	}
+	return peers
+}
+
+// this function returns the number of dials in progress
+func (s *PeerStore) connecting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, e := range s.entries {
+		if e.connecting {
+			n++
+		}
+	}
+	return n
+}
+
+// this function returns up to n addresses to dial, best first, and marks them as being
+// dialed. canDial filters out addresses that are backed off. addresses only known from
+// their own incoming connection aren't dialed, their port is whatever their end picked
+func (s *PeerStore) candidates(n int, canDial func(addr string) bool) []*peerEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var candidates []*peerEntry
+	for _, e := range s.entries {
+		if e.connecting || e.connected || (len(e.sources) == 1 && e.source == PeerSourceIncoming) {
+			continue
+		}
+		if s.bans != nil && s.bans.IsBanned(e.addr) || !canDial(e.addr) {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	slices.SortFunc(candidates, func(a, b *peerEntry) int {
+		switch {
+		case a.connectable != b.connectable:
+			if a.connectable {
+				return -1
+			}
+			return 1
+		case a.lastAttempt.IsZero() != b.lastAttempt.IsZero():
+			if a.lastAttempt.IsZero() {
+				return -1
+			}
+			return 1
+		case !a.lastAttempt.Equal(b.lastAttempt):
+			return a.lastAttempt.Compare(b.lastAttempt)
+		}
+		return a.order - b.order
+	})
+	if len(candidates) > n {
+		candidates = candidates[:max(n, 0)]
+	}
+	now := time.Now()
+	for _, e := range candidates {
+		e.connecting = true
+		e.lastAttempt = now
+	}
+	return candidates
+}
+
+// this function records the outcome of dialing addr
+func (s *PeerStore) dialed(addr string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[canonicalPeerAddr(addr)]
+	if !ok {
+		return
+	}
+	e.connecting = false
+	if err != nil {
+		e.failures++
+		return
+	}
+	e.failures = 0
+	e.connectable = true
+	e.connected = true
+	e.lastSeen = time.Now()
+}
+
+// this function records that a peer connected to us from addr
+func (s *PeerStore) accepted(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entry(PeerSourceIncoming, addr)
+	e.connected = true
+	e.lastSeen = time.Now()
+}
+
+// this function records that the connection to addr closed after it sent us downloaded bytes
+func (s *PeerStore) disconnected(addr string, downloaded int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[canonicalPeerAddr(addr)]; ok {
+		e.connected = false
+		e.lastSeen = time.Now()
+		e.downloaded += downloaded
+	}
+}
+
+// this function returns up to n reachable peers worth trying first next time, the ones that
+// sent us the most first. live holds what connected peers sent so far
+func (s *PeerStore) best(n int, live map[string]int64) []ResumePeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var peers []ResumePeer
+	for _, e := range s.entries {
+		if !e.connectable || (s.bans != nil && s.bans.IsBanned(e.addr)) {
+			continue
+		}
+		rp := ResumePeer{Addr: e.addr, Downloaded: e.downloaded, LastSeen: e.lastSeen.Unix()}
+		if downloaded, ok := live[e.addr]; ok {
+			rp.Downloaded += downloaded
+			rp.LastSeen = now.Unix()
+		}
+		peers = append(peers, rp)
+	}
+	slices.SortFunc(peers, func(a, b ResumePeer) int {
+		if a.Downloaded != b.Downloaded {
+			return cmp.Compare(b.Downloaded, a.Downloaded)
+		}
+		return cmp.Compare(b.LastSeen, a.LastSeen)
+	})
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
+
+// this function adds the peers saved with the resume data as reachable, so they are dialed
+// before anything else. peers not seen for maxAge are left out
+func (s *PeerStore) restore(peers []ResumePeer, maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-maxAge).Unix()
+	for _, rp := range peers {
+		if rp.LastSeen < cutoff {
+			continue
+		}
+		e := s.entry(PeerSourceResume, rp.Addr)
+		e.connectable = true
+		e.downloaded = max(e.downloaded, rp.Downloaded)
+		e.lastSeen = time.Unix(rp.LastSeen, 0)
+	}
+}
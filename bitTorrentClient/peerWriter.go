@@ -0,0 +1,130 @@
+// This file protects us from slow peers. Messages to a peer are queued and written by a
+// goroutine of its own with a deadline on every write, so a peer whose TCP buffers stay
+// full can't block whoever is sending to it. Peers that let the queue grow past its bound,
+// or don't accept a write within the timeout, are dropped
+package bittorrentclient
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	defaultHandshakeTimeout = 20 * time.Second
+	// peers send keep-alives every two minutes, silence well past that means a dead connection
+	defaultReadTimeout  = 150 * time.Second
+	defaultWriteTimeout = 60 * time.Second
+	maxOutgoingBytes    = 4 << 20
+)
+
+var ErrSlowPeer = errors.New("peer is not reading fast enough")
+
+type outgoing struct {
+	buf []byte
+	// pooled buffers go back to the pool once written
+	pooled bool
+	// pieceBytes is the block length of a piece message, counted as uploaded once written
+	pieceBytes int
+}
+
+// SetTimeouts changes the per message read timeout and the per write timeout, zero disables either
+func (p *Peer) SetTimeouts(read, write time.Duration) {
+	p.mu.Lock()
+	p.readTimeout = read
+	p.mu.Unlock()
+	p.conn.mu.Lock()
+	p.conn.writeTimeout = write
+	p.conn.mu.Unlock()
+}
+
+// QueuedBytes returns how many bytes are waiting to be written to the peer
+func (p *Peer) QueuedBytes() int {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+	return p.outBytes
+}
+
+// SendKeepAlive queues a keep-alive, peers drop connections that stay silent for too long
+func (p *Peer) SendKeepAlive() error {
+	return p.enqueue(outgoing{buf: make([]byte, 4)})
+}
+
+func (p *Peer) enqueue(o outgoing) error {
+	p.outMu.Lock()
+	if p.outClosed {
+		p.outMu.Unlock()
+		if o.pooled {
+			putBuffer(o.buf)
+		}
+		return net.ErrClosed
+	}
+	if len(p.outQueue) > 0 && p.outBytes+len(o.buf) > maxOutgoingBytes {
+		p.outMu.Unlock()
+		if o.pooled {
+			putBuffer(o.buf)
+		}
+		// the peer hasn't drained its socket for a long time, drop it before it backs us up
+		p.Close()
+		return ErrSlowPeer
+	}
+	p.outQueue = append(p.outQueue, o)
+	p.outBytes += len(o.buf)
+	p.outCond.Signal()
+	p.outMu.Unlock()
+	return nil
+}
+
+// this function stops the writer and throws away whatever is still queued
+func (p *Peer) closeOutgoing() {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+	p.outClosed = true
+	p.outCond.Broadcast()
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		p.outMu.Lock()
+		for len(p.outQueue) == 0 && !p.outClosed {
+			p.outCond.Wait()
+		}
+		batch := p.outQueue
+		p.outQueue = nil
+		closed := p.outClosed
+		p.outMu.Unlock()
+
+		if closed {
+			p.discard(batch)
+			return
+		}
+		for i, o := range batch {
+			_, err := p.conn.Write(o.buf)
+			if o.pooled {
+				putBuffer(o.buf)
+			}
+			if err != nil {
+				p.discard(batch[i+1:])
+				p.Close()
+				return
+			}
+			p.outMu.Lock()
+			p.outBytes -= len(o.buf)
+			p.outMu.Unlock()
+			if o.pieceBytes > 0 {
+				p.mu.Lock()
+				p.uploaded += int64(o.pieceBytes)
+				p.upRate.add(int64(o.pieceBytes), time.Now())
+				p.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (p *Peer) discard(batch []outgoing) {
+	for _, o := range batch {
+		if o.pooled {
+			putBuffer(o.buf)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package bittorrentclient
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// this function connects a Peer of a torrent with numPieces pieces to a pipe, whatever is
+// written to the returned end is what the peer sends us
+func pipePeer(t *testing.T, numPieces int) (*Peer, net.Conn) {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	var h Handshake
+	h.Reserved[reservedFastByte] |= reservedFastBit
+	p := newPeer(ours, &h)
+	if numPieces > 0 {
+		p.SetNumPieces(numPieces)
+	}
+	t.Cleanup(func() {
+		p.Close()
+		theirs.Close()
+	})
+	return p, theirs
+}
+
+func indexPayload(index uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, index)
+}
+
+func TestPeerAvailability(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		numPieces int
+		msg       Message
+		ok        bool
+	}{
+		{"have", 10, Message{ID: MsgHave, Payload: indexPayload(9)}, true},
+		{"have past the end", 10, Message{ID: MsgHave, Payload: indexPayload(10)}, false},
+		{"have of an unknown torrent", 0, Message{ID: MsgHave, Payload: indexPayload(1000)}, true},
+		{"have past any torrent", 0, Message{ID: MsgHave, Payload: indexPayload(0xFFFFFFFF)}, false},
+		{"allowed fast", 10, Message{ID: MsgAllowedFast, Payload: indexPayload(3)}, true},
+		{"allowed fast past the end", 10, Message{ID: MsgAllowedFast, Payload: indexPayload(10)}, false},
+		{"bitfield", 10, Message{ID: MsgBitfield, Payload: []byte{0xff, 0xc0}}, true},
+		{"bitfield too short", 10, Message{ID: MsgBitfield, Payload: []byte{0xff}}, false},
+		{"bitfield too long", 10, Message{ID: MsgBitfield, Payload: []byte{0xff, 0xc0, 0}}, false},
+		{"bitfield with spare bits", 10, Message{ID: MsgBitfield, Payload: []byte{0xff, 0xe0}}, false},
+		{"bitfield of whole bytes", 16, Message{ID: MsgBitfield, Payload: []byte{0xff, 0xff}}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, theirs := pipePeer(t, tc.numPieces)
+			go theirs.Write(tc.msg.Serialize())
+			_, err := p.ReadMessage()
+			if tc.ok && err != nil {
+				t.Errorf("refused: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestPeerBitfieldBeforeNumPieces(t *testing.T) {
+	p, theirs := pipePeer(t, 0)
+	go theirs.Write((&Message{ID: MsgBitfield, Payload: []byte{0xff, 0xff, 0xff}}).Serialize())
+	_, err := p.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pieces past the end of the torrent are dropped once its size is known
+	p.SetNumPieces(10)
+	bf := p.Bitfield()
+	if len(bf) != 2 || bf.Count() != 10 {
+		t.Errorf("bitfield %08b, want the 10 pieces of the torrent", bf)
+	}
+}
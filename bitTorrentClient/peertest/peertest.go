@@ -0,0 +1,334 @@
+// Package peertest provides a scriptable fake peer speaking the wire protocol over
+// net.Pipe, so peer connections, the choker and the piece pipeline can be exercised
+// deterministically without a live swarm. A FakePeer serves pieces, and can be told to
+// misbehave: stall, corrupt data, hang up, or send whatever malformed bytes a test needs
+package peertest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	bittorrentclient "mybittorrent"
+)
+
+// Behavior controls how a FakePeer reacts to the client
+type Behavior struct {
+	// Unchoke the client as soon as it says it is interested
+	UnchokeOnInterest bool
+	// Stall accepts requests but never answers them
+	Stall bool
+	// RequestDelay is waited before answering each request
+	RequestDelay time.Duration
+	// CorruptPieces lists pieces whose blocks are served with flipped bytes
+	CorruptPieces map[int]bool
+	// CloseAfterBlocks hangs up after serving this many blocks, zero never hangs up
+	CloseAfterBlocks int
+	// WrongInfoHash answers the handshake with a different infohash
+	WrongInfoHash bool
+	// Fast advertises the fast extension in the handshake
+	Fast bool
+	// AllowedFast lists pieces the client may request while choked, they are sent after the
+	// bitfield when Fast is set
+	AllowedFast []int
+}
+
+type FakePeer struct {
+	InfoHash    [20]byte
+	PeerID      [20]byte
+	PieceLength int
+	// Pieces holds the data the peer can serve, nil entries are pieces it doesn't have
+	Pieces   [][]byte
+	Behavior Behavior
+
+	conn     net.Conn
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	choking  bool
+	received []*bittorrentclient.Message
+	notify   chan struct{}
+	served   int
+	done     chan struct{}
+	err      error
+}
+
+// NewFakePeer returns a peer that has every piece in pieces
+func NewFakePeer(infoHash [20]byte, pieceLength int, pieces [][]byte) *FakePeer {
+	fp := &FakePeer{
+		InfoHash:    infoHash,
+		PieceLength: pieceLength,
+		Pieces:      pieces,
+		choking:     true,
+		notify:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	copy(fp.PeerID[:], "-PT0001-fakepeer0000")
+	return fp
+}
+
+// Pipe connects the fake peer to one end of a net.Pipe and returns the other end for the
+// client under test. The fake peer is served in the background
+func (fp *FakePeer) Pipe() net.Conn {
+	client, server := net.Pipe()
+	fp.conn = server
+	go fp.run()
+	return client
+}
+
+// Serve runs the fake peer on conn until it is closed, the handshake is expected from the client side first
+func (fp *FakePeer) Serve(conn net.Conn) error {
+	fp.conn = conn
+	return fp.run()
+}
+
+func (fp *FakePeer) run() error {
+	conn := fp.conn
+	defer close(fp.done)
+	defer conn.Close()
+
+	err := fp.handshake()
+	if err == nil {
+		err = fp.sendBitfield()
+	}
+	if err == nil {
+		err = fp.sendAllowedFast()
+	}
+	for err == nil {
+		var msg *bittorrentclient.Message
+		msg, err = bittorrentclient.ReadMessage(conn)
+		if err != nil {
+			break
+		}
+		err = fp.handle(msg)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+		err = nil
+	}
+	fp.mu.Lock()
+	fp.err = err
+	fp.mu.Unlock()
+	return err
+}
+
+func (fp *FakePeer) handshake() error {
+	h, err := bittorrentclient.ReadHandshake(fp.conn)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.InfoHash[:], fp.InfoHash[:]) {
+		return fmt.Errorf("peertest: client asked for infohash %x", h.InfoHash)
+	}
+	res := bittorrentclient.Handshake{InfoHash: fp.InfoHash, PeerID: fp.PeerID}
+	if fp.Behavior.WrongInfoHash {
+		res.InfoHash[0] ^= 0xff
+	}
+	if fp.Behavior.Fast {
+		res.Reserved[7] |= 0x04
+	}
+	return fp.SendRaw(res.Serialize())
+}
+
+func (fp *FakePeer) sendBitfield() error {
+	bf := bittorrentclient.NewBitfield(len(fp.Pieces))
+	for i, piece := range fp.Pieces {
+		if piece != nil {
+			bf.SetPiece(i)
+		}
+	}
+	return fp.Send(&bittorrentclient.Message{ID: bittorrentclient.MsgBitfield, Payload: bf})
+}
+
+func (fp *FakePeer) sendAllowedFast() error {
+	if !fp.Behavior.Fast {
+		return nil
+	}
+	for _, index := range fp.Behavior.AllowedFast {
+		err := fp.Send(bittorrentclient.FormatAllowedFast(index))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fp *FakePeer) handle(msg *bittorrentclient.Message) error {
+	if msg != nil {
+		// keep a copy, the payload buffer is recycled once released
+		logged := &bittorrentclient.Message{ID: msg.ID, Payload: append([]byte(nil), msg.Payload...)}
+		msg.Release()
+		msg = logged
+	}
+	fp.mu.Lock()
+	fp.received = append(fp.received, msg)
+	close(fp.notify)
+	fp.notify = make(chan struct{})
+	fp.mu.Unlock()
+	if msg == nil {
+		return nil
+	}
+
+	switch msg.ID {
+	case bittorrentclient.MsgInterested:
+		if fp.Behavior.UnchokeOnInterest {
+			return fp.Unchoke()
+		}
+	case bittorrentclient.MsgRequest:
+		return fp.serve(msg)
+	}
+	return nil
+}
+
+func (fp *FakePeer) serve(msg *bittorrentclient.Message) error {
+	index, begin, length, err := bittorrentclient.ParseRequest(msg)
+	if err != nil {
+		return err
+	}
+	fp.mu.Lock()
+	choking := fp.choking && !(fp.Behavior.Fast && slices.Contains(fp.Behavior.AllowedFast, index))
+	stall := fp.Behavior.Stall
+	fp.mu.Unlock()
+	if stall {
+		return nil
+	}
+	if choking || index < 0 || index >= len(fp.Pieces) || fp.Pieces[index] == nil ||
+		begin < 0 || length < 0 || begin+length > len(fp.Pieces[index]) {
+		if fp.Behavior.Fast {
+			return fp.Send(bittorrentclient.FormatReject(index, begin, length))
+		}
+		return nil
+	}
+
+	if fp.Behavior.RequestDelay > 0 {
+		time.Sleep(fp.Behavior.RequestDelay)
+	}
+	block := append([]byte(nil), fp.Pieces[index][begin:begin+length]...)
+	if fp.Behavior.CorruptPieces[index] {
+		for i := range block {
+			block[i] ^= 0xff
+		}
+	}
+	err = fp.Send(bittorrentclient.FormatPiece(index, begin, block))
+	if err != nil {
+		return err
+	}
+
+	fp.mu.Lock()
+	fp.served++
+	hangUp := fp.Behavior.CloseAfterBlocks > 0 && fp.served >= fp.Behavior.CloseAfterBlocks
+	fp.mu.Unlock()
+	if hangUp {
+		return fp.conn.Close()
+	}
+	return nil
+}
+
+// Send writes a message to the client
+func (fp *FakePeer) Send(msg *bittorrentclient.Message) error {
+	return fp.SendRaw(msg.Serialize())
+}
+
+// SendRaw writes arbitrary bytes to the client, e.g. a truncated or oversized message
+func (fp *FakePeer) SendRaw(b []byte) error {
+	fp.writeMu.Lock()
+	defer fp.writeMu.Unlock()
+	_, err := fp.conn.Write(b)
+	return err
+}
+
+func (fp *FakePeer) Unchoke() error {
+	fp.mu.Lock()
+	fp.choking = false
+	fp.mu.Unlock()
+	return fp.Send(&bittorrentclient.Message{ID: bittorrentclient.MsgUnchoke})
+}
+
+func (fp *FakePeer) Choke() error {
+	fp.mu.Lock()
+	fp.choking = true
+	fp.mu.Unlock()
+	return fp.Send(&bittorrentclient.Message{ID: bittorrentclient.MsgChoke})
+}
+
+// SetStall starts or stops ignoring requests
+func (fp *FakePeer) SetStall(stall bool) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.Behavior.Stall = stall
+}
+
+// Received returns every message the client sent so far, keep-alives are nil entries
+func (fp *FakePeer) Received() []*bittorrentclient.Message {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return append([]*bittorrentclient.Message(nil), fp.received...)
+}
+
+// WaitFor blocks until the client sent a message with the given ID, and returns the first one
+func (fp *FakePeer) WaitFor(id bittorrentclient.MessageID, timeout time.Duration) (*bittorrentclient.Message, error) {
+	deadline := time.After(timeout)
+	for {
+		fp.mu.Lock()
+		for _, msg := range fp.received {
+			if msg != nil && msg.ID == id {
+				fp.mu.Unlock()
+				return msg, nil
+			}
+		}
+		wait := fp.notify
+		fp.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-fp.done:
+			return nil, fmt.Errorf("peertest: connection closed before %v arrived", id)
+		case <-deadline:
+			return nil, fmt.Errorf("peertest: timed out waiting for %v", id)
+		}
+	}
+}
+
+// Close hangs up on the client and waits for Serve to return
+func (fp *FakePeer) Close() error {
+	if fp.conn == nil {
+		return nil
+	}
+	fp.conn.Close()
+	<-fp.done
+	return fp.Err()
+}
+
+// Err returns the error Serve stopped with, if any
+func (fp *FakePeer) Err() error {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.err
+}
+
+// RandomPieces generates numPieces pieces of random data along with the concatenated
+// SHA-1 hashes a torrent's info dictionary would carry for them. The last piece is
+// lastLength bytes long, zero makes it a full piece
+func RandomPieces(numPieces, pieceLength, lastLength int) ([][]byte, []byte) {
+	pieces := make([][]byte, numPieces)
+	hashes := make([]byte, 0, numPieces*sha1.Size)
+	for i := range pieces {
+		size := pieceLength
+		if i == numPieces-1 && lastLength > 0 {
+			size = lastLength
+		}
+		pieces[i] = make([]byte, size)
+		_, err := rand.Read(pieces[i])
+		if err != nil {
+			panic(err)
+		}
+		sum := sha1.Sum(pieces[i])
+		hashes = append(hashes, sum[:]...)
+	}
+	return pieces, hashes
+}
@@ -0,0 +1,400 @@
+// This file decides which piece to download next. The picker tracks how many connected
+// peers have each piece, from their bitfields and haves, and hands out the rarest piece a
+// peer can give us. Getting rare pieces first keeps them alive in the swarm and gives us
+// something other peers want to trade for, ties are broken randomly so peers starting at
+// the same time don't all chase the same piece. In sequential mode pieces are handed out in
+// order instead, so media files can be previewed while they download. Pieces with a
+// deadline, set by a streaming reader for the data just ahead of playback, go before
+// everything else in either mode. Every piece also has a priority: ignored pieces are never
+// picked and higher priorities go before lower ones, rarity only decides within a priority
+package bittorrentclient
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PiecePicker chooses pieces to request and keeps what that takes: which pieces the peers
+// have, which we have or are downloading and at what priority each is wanted. Picker is the
+// default, Download.SetPiecePicker puts another in its place. Implementations have to be
+// safe for concurrent use
+type PiecePicker interface {
+	// PeerAdded counts the pieces in a new peer's bitfield
+	PeerAdded(bf Bitfield)
+	// PeerHave counts a piece a peer announced with a have message
+	PeerHave(index int)
+	// PeerRemoved stops counting the pieces of a disconnected peer
+	PeerRemoved(bf Bitfield)
+	// Pick returns a piece to download from a peer that has the pieces in peerHas and
+	// marks it in progress, ok is false when the peer has nothing we still need
+	Pick(peerHas Bitfield) (index int, ok bool)
+	// MarkInProgress marks a piece in progress that didn't come from Pick, e.g. one that
+	// was half downloaded before a restart
+	MarkInProgress(index int)
+	// Complete marks a piece as downloaded and verified
+	Complete(index int)
+	// Abort puts an in progress piece back, e.g. after it failed the hash check
+	Abort(index int)
+	// SetHave replaces the pieces marked complete, after the data was checked
+	SetHave(have Bitfield)
+	// SetPriority changes a piece's priority, ignored pieces are never picked
+	SetPriority(index int, prio PiecePriority)
+	Priority(index int) PiecePriority
+	// Wants reports whether a piece still has to be downloaded
+	Wants(index int) bool
+	// Done reports whether every piece that isn't ignored is complete
+	Done() bool
+	// Remaining returns how many wanted pieces are neither complete nor in progress
+	Remaining() int
+	// SetPieceDeadline asks for a piece to arrive within d, it goes before the others
+	SetPieceDeadline(index int, d time.Duration)
+	ClearPieceDeadline(index int)
+	// Availability returns how many connected peers have a piece
+	Availability(index int) int
+	// DistributedCopies returns how many full copies of the torrent the connected peers
+	// hold between them
+	DistributedCopies() float64
+}
+
+type PiecePriority int
+
+const (
+	// PiecePriorityIgnore pieces are never downloaded
+	PiecePriorityIgnore PiecePriority = iota
+	PiecePriorityLow
+	PiecePriorityNormal
+	PiecePriorityHigh
+	// PiecePriorityNow pieces go before everything but deadline pieces
+	PiecePriorityNow
+)
+
+func (p PiecePriority) String() string {
+	switch p {
+	case PiecePriorityIgnore:
+		return "ignore"
+	case PiecePriorityLow:
+		return "low"
+	case PiecePriorityNormal:
+		return "normal"
+	case PiecePriorityHigh:
+		return "high"
+	case PiecePriorityNow:
+		return "now"
+	default:
+		return "unknown"
+	}
+}
+
+// Picker is the default PiecePicker, it picks rarest first
+type Picker struct {
+	mu           sync.Mutex
+	numPieces    int
+	availability []int
+	have         Bitfield
+	inProgress   []bool
+	priority     []PiecePriority
+	sequential   bool
+	deadlines    map[int]time.Time
+}
+
+func NewPicker(numPieces int) *Picker {
+	pp := &Picker{
+		numPieces:    numPieces,
+		availability: make([]int, numPieces),
+		have:         NewBitfield(numPieces),
+		inProgress:   make([]bool, numPieces),
+		priority:     make([]PiecePriority, numPieces),
+		deadlines:    make(map[int]time.Time),
+	}
+	for i := range pp.priority {
+		pp.priority[i] = PiecePriorityNormal
+	}
+	return pp
+}
+
+// SetPriority changes the priority of a piece
+func (pp *Picker) SetPriority(index int, prio PiecePriority) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index >= 0 && index < pp.numPieces {
+		pp.priority[index] = prio
+	}
+}
+
+func (pp *Picker) Priority(index int) PiecePriority {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index < 0 || index >= pp.numPieces {
+		return PiecePriorityIgnore
+	}
+	return pp.priority[index]
+}
+
+// Wants reports whether a piece still has to be downloaded
+func (pp *Picker) Wants(index int) bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return index >= 0 && index < pp.numPieces && !pp.have.HasPiece(index) && pp.priority[index] != PiecePriorityIgnore
+}
+
+// Done reports whether every piece that isn't ignored is complete
+func (pp *Picker) Done() bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := 0; i < pp.numPieces; i++ {
+		if !pp.have.HasPiece(i) && pp.priority[i] != PiecePriorityIgnore {
+			return false
+		}
+	}
+	return true
+}
+
+func (pp *Picker) PeerAdded(bf Bitfield) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := 0; i < pp.numPieces; i++ {
+		if bf.HasPiece(i) {
+			pp.availability[i]++
+		}
+	}
+}
+
+func (pp *Picker) PeerHave(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index >= 0 && index < pp.numPieces {
+		pp.availability[index]++
+	}
+}
+
+func (pp *Picker) PeerRemoved(bf Bitfield) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := 0; i < pp.numPieces; i++ {
+		if bf.HasPiece(i) && pp.availability[i] > 0 {
+			pp.availability[i]--
+		}
+	}
+}
+
+// SetSequential switches between in order and rarest first picking. Sequential picking
+// still only picks pieces the peer actually has, so a peer missing the next piece in line
+// gets asked for the first one it can serve
+func (pp *Picker) SetSequential(sequential bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	pp.sequential = sequential
+}
+
+func (pp *Picker) Sequential() bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return pp.sequential
+}
+
+// SetPieceDeadline asks for a piece to arrive within d. Deadline pieces are picked before
+// any other, earliest deadline first, and once a deadline has passed the piece is handed
+// out again to other peers even while it is in progress, whichever copy arrives first wins
+func (pp *Picker) SetPieceDeadline(index int, d time.Duration) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index < 0 || index >= pp.numPieces || pp.have.HasPiece(index) {
+		return
+	}
+	pp.deadlines[index] = time.Now().Add(d)
+}
+
+func (pp *Picker) ClearPieceDeadline(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	delete(pp.deadlines, index)
+}
+
+// ClearDeadlines drops every deadline, e.g. when a streaming reader seeks elsewhere
+func (pp *Picker) ClearDeadlines() {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	clear(pp.deadlines)
+}
+
+// this function picks the deadline piece with the earliest deadline the peer can give us,
+// -1 if there is none
+func (pp *Picker) pickDeadline(peerHas Bitfield, now time.Time) int {
+	best := -1
+	var bestAt time.Time
+	for index, at := range pp.deadlines {
+		if pp.have.HasPiece(index) || !peerHas.HasPiece(index) {
+			continue
+		}
+		if pp.inProgress[index] && now.Before(at) {
+			continue
+		}
+		if best == -1 || at.Before(bestAt) || at.Equal(bestAt) && index < best {
+			best, bestAt = index, at
+		}
+	}
+	return best
+}
+
+func (pp *Picker) Pick(peerHas Bitfield) (int, bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index := pp.pickDeadline(peerHas, time.Now()); index >= 0 {
+		pp.inProgress[index] = true
+		return index, true
+	}
+	if pp.sequential {
+		best := -1
+		for i := 0; i < pp.numPieces; i++ {
+			if pp.wanted(i, peerHas) && (best == -1 || pp.priority[i] > pp.priority[best]) {
+				best = i
+			}
+		}
+		if best == -1 {
+			return 0, false
+		}
+		pp.inProgress[best] = true
+		return best, true
+	}
+
+	best, bestAvail, ties := -1, 0, 0
+	for i := 0; i < pp.numPieces; i++ {
+		if !pp.wanted(i, peerHas) {
+			continue
+		}
+		avail := pp.availability[i]
+		switch {
+		case best == -1 || pp.priority[i] > pp.priority[best] ||
+			pp.priority[i] == pp.priority[best] && avail < bestAvail:
+			best, bestAvail, ties = i, avail, 1
+		case pp.priority[i] == pp.priority[best] && avail == bestAvail:
+			// reservoir sampling keeps every tied piece equally likely
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = i
+			}
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	pp.inProgress[best] = true
+	return best, true
+}
+
+// this function reports whether index is worth requesting from a peer that has peerHas
+func (pp *Picker) wanted(index int, peerHas Bitfield) bool {
+	return !pp.inProgress[index] && !pp.have.HasPiece(index) && pp.priority[index] != PiecePriorityIgnore &&
+		peerHas.HasPiece(index)
+}
+
+func (pp *Picker) Complete(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index >= 0 && index < pp.numPieces {
+		pp.have.SetPiece(index)
+		pp.inProgress[index] = false
+		delete(pp.deadlines, index)
+	}
+}
+
+// SetHave replaces the set of complete pieces, e.g. after the data was rechecked
+func (pp *Picker) SetHave(have Bitfield) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for i := 0; i < pp.numPieces; i++ {
+		if have.HasPiece(i) {
+			pp.have.SetPiece(i)
+			delete(pp.deadlines, i)
+		} else {
+			pp.have.ClearPiece(i)
+		}
+	}
+}
+
+// MarkInProgress marks index as being downloaded, for pieces that were in progress before
+// a restart and didn't come from Pick
+func (pp *Picker) MarkInProgress(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index >= 0 && index < pp.numPieces {
+		pp.inProgress[index] = true
+	}
+}
+
+func (pp *Picker) Abort(index int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index >= 0 && index < pp.numPieces {
+		pp.inProgress[index] = false
+	}
+}
+
+func (pp *Picker) Availability(index int) int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if index < 0 || index >= pp.numPieces {
+		return 0
+	}
+	return pp.availability[index]
+}
+
+// DistributedCopies returns how many full copies of the torrent the connected peers hold
+// between them: the availability of the rarest piece, plus the share of pieces that are
+// more common than that
+func (pp *Picker) DistributedCopies() float64 {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if pp.numPieces == 0 {
+		return 0
+	}
+	rarest := slices.Min(pp.availability)
+	above := 0
+	for _, avail := range pp.availability {
+		if avail > rarest {
+			above++
+		}
+	}
+	return float64(rarest) + float64(above)/float64(pp.numPieces)
+}
+
+// Have returns a copy of the pieces marked complete
+func (pp *Picker) Have() Bitfield {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	return append(Bitfield(nil), pp.have...)
+}
+
+// Remaining returns how many pieces we want are neither complete nor in progress
+func (pp *Picker) Remaining() int {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	n := 0
+	for i := 0; i < pp.numPieces; i++ {
+		if !pp.inProgress[i] && !pp.have.HasPiece(i) && pp.priority[i] != PiecePriorityIgnore {
+			n++
+		}
+	}
+	return n
+}
+
+// SetPiecePicker replaces the picker that chooses the download's pieces, the download has
+// to be stopped or paused. The new picker is handed the pieces we have, the ones in
+// progress and the priorities
+func (d *Download) SetPiecePicker(picker PiecePicker) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil || d.state == DownloadChecking {
+		return errors.New("the picker can't be changed while the download runs")
+	}
+	picker.SetHave(append(Bitfield(nil), d.have...))
+	for index := range d.active {
+		picker.MarkInProgress(index)
+	}
+	d.picker = picker
+	d.applyFilePriorities()
+	return nil
+}
@@ -0,0 +1,53 @@
+// This file exposes piece priorities on a running download, for integrators that need finer
+// control than file priorities give, e.g. a streaming frontend bumping the pieces around the
+// playback position. A piece priority set here sticks until it is cleared, file priority
+// changes don't overwrite it
+package bittorrentclient
+
+import "fmt"
+
+// SetPiecePriority overrides the priority of a single piece
+func (d *Download) SetPiecePriority(index int, prio PiecePriority) error {
+	if index < 0 || index >= d.Torrent.NumPieces() {
+		return fmt.Errorf("piece index %d out of range", index)
+	}
+	if prio < PiecePriorityIgnore || prio > PiecePriorityNow {
+		return fmt.Errorf("invalid piece priority %d", prio)
+	}
+	d.mu.Lock()
+	d.pieceOverrides[index] = prio
+	d.picker.SetPriority(index, prio)
+	d.wantedChanged()
+	d.mu.Unlock()
+
+	for _, p := range d.Peers() {
+		d.updateInterest(p)
+	}
+	return nil
+}
+
+// ClearPiecePriority drops a piece's override, it goes back to the priority of its files
+func (d *Download) ClearPiecePriority(index int) {
+	d.mu.Lock()
+	delete(d.pieceOverrides, index)
+	d.applyFilePriorities()
+	d.mu.Unlock()
+
+	for _, p := range d.Peers() {
+		d.updateInterest(p)
+	}
+}
+
+// PiecePriority returns the effective priority of a piece
+func (d *Download) PiecePriority(index int) PiecePriority {
+	return d.picker.Priority(index)
+}
+
+// PiecePriorities returns the effective priority of every piece
+func (d *Download) PiecePriorities() []PiecePriority {
+	prios := make([]PiecePriority, d.Torrent.NumPieces())
+	for i := range prios {
+		prios[i] = d.picker.Priority(i)
+	}
+	return prios
+}
@@ -0,0 +1,336 @@
+// This file keeps the listening port forwarded on the gateway, so peers behind no NAT of
+// their own can connect to us. A PortMapper asks the gateway for TCP and UDP mappings of the
+// port with PCP, NAT-PMP or UPnP, whichever it answers first, renews them halfway through
+// their lease and removes them when closed. Whether the mappings got through is published
+// on the event bus, a client that couldn't map its port is only reachable by peers it dials
+package bittorrentclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPortMappingLifetime is the lease asked for, RFC 6886 recommends two hours
+	DefaultPortMappingLifetime = 2 * time.Hour
+	// portMappingRetryInterval is the wait before trying again after no gateway mapped
+	portMappingRetryInterval = 5 * time.Minute
+	// portUnmapTimeout bounds the removal of the mappings on Close
+	portUnmapTimeout = 3 * time.Second
+)
+
+// PortMapping is a port forwarded on the gateway
+type PortMapping struct {
+	// Protocol is "TCP" or "UDP"
+	Protocol     string
+	InternalPort int
+	ExternalPort int
+	// ExternalIP is the gateway's public address, invalid when it didn't tell
+	ExternalIP netip.Addr
+	// Method is the protocol the mapping was made with: "PCP", "NAT-PMP" or "UPnP"
+	Method  string
+	Expires time.Time
+}
+
+// portMapMethod is a protocol for asking the gateway to forward ports
+type portMapMethod interface {
+	name() string
+	// mapPort forwards external, or a port of the gateway's choosing when zero, to internal
+	mapPort(ctx context.Context, protocol string, internal, external int, lifetime time.Duration) (PortMapping, error)
+	unmapPort(ctx context.Context, m PortMapping) error
+}
+
+// PortMappingConfig configures a PortMapper
+type PortMappingConfig struct {
+	// Port is the local port to forward, for TCP and UDP alike
+	Port int
+	// Lifetime is the lease asked for, DefaultPortMappingLifetime when zero
+	Lifetime time.Duration
+	// Gateway is the host:port PCP and NAT-PMP requests go to, the default route's gateway
+	// on port 5351 when empty
+	Gateway string
+	// UPnPLocation is the URL of the gateway's UPnP description, found with an SSDP
+	// search when empty
+	UPnPLocation string
+	// DisableNATPMP and DisableUPnP leave a method out
+	DisableNATPMP bool
+	DisableUPnP   bool
+	// Bus receives a SessionPortMapping event whenever the mappings were made or failed
+	Bus *EventBus
+}
+
+// PortMapper keeps a port forwarded on the gateway until it is closed
+type PortMapper struct {
+	cfg PortMappingConfig
+
+	mu       sync.Mutex
+	method   portMapMethod
+	mappings []PortMapping
+	err      error
+	// attempted is set after the first attempt, whose outcome is always published
+	attempted bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPortMapper starts mapping cfg.Port in the background
+func NewPortMapper(cfg PortMappingConfig) *PortMapper {
+	if cfg.Lifetime <= 0 {
+		cfg.Lifetime = DefaultPortMappingLifetime
+	}
+	m := &PortMapper{cfg: cfg}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.wg.Add(1)
+	go m.run()
+	return m
+}
+
+// Mappings returns the mappings in place
+func (m *PortMapper) Mappings() []PortMapping {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]PortMapping(nil), m.mappings...)
+}
+
+// Connectable reports whether the TCP port is forwarded, so peers can connect to us
+func (m *PortMapper) Connectable() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pm := range m.mappings {
+		if pm.Protocol == "TCP" {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns why the last attempt to map the port failed, nil once it succeeded
+func (m *PortMapper) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close stops renewing the mappings and removes them from the gateway
+func (m *PortMapper) Close() error {
+	m.cancel()
+	m.wg.Wait()
+	m.mu.Lock()
+	method, mappings := m.method, m.mappings
+	m.mappings = nil
+	m.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), portUnmapTimeout)
+	defer cancel()
+	var errs []error
+	for _, pm := range mappings {
+		errs = append(errs, method.unmapPort(ctx, pm))
+	}
+	return errors.Join(errs...)
+}
+
+// this function maps the port and renews the mappings until the mapper is closed
+func (m *PortMapper) run() {
+	defer m.wg.Done()
+	for {
+		wait := portMappingRetryInterval
+		mappings, err := m.refresh()
+		if err == nil {
+			wait = m.cfg.Lifetime / 2
+			for _, pm := range mappings {
+				// the gateway may grant less than asked for
+				if half := time.Until(pm.Expires) / 2; half > 0 && half < wait {
+					wait = half
+				}
+			}
+		}
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// this function makes or renews the mappings with the method that worked before, or finds
+// one that works. the result is published when it changed
+func (m *PortMapper) refresh() ([]PortMapping, error) {
+	m.mu.Lock()
+	method, previous := m.method, m.mappings
+	m.mu.Unlock()
+
+	var mappings []PortMapping
+	var err error
+	if method != nil {
+		mappings, err = m.mapWith(method, previous)
+	}
+	if method == nil || err != nil {
+		method, mappings, err = m.discover()
+	}
+	if m.ctx.Err() != nil {
+		// closing, whatever got mapped is removed by Close
+		m.mu.Lock()
+		if err == nil {
+			m.method, m.mappings = method, mappings
+		}
+		m.mu.Unlock()
+		return nil, m.ctx.Err()
+	}
+
+	m.mu.Lock()
+	changed := !m.attempted || !samePortMappings(previous, mappings)
+	m.method, m.mappings, m.err, m.attempted = method, mappings, err, true
+	m.mu.Unlock()
+	if m.cfg.Bus != nil && changed {
+		ev := SessionEvent{Type: SessionPortMapping, Port: m.cfg.Port, Err: err}
+		for _, pm := range mappings {
+			if pm.Protocol == "TCP" {
+				ev.Port = pm.ExternalPort
+				if pm.ExternalIP.IsValid() {
+					ev.ExternalAddr = netip.AddrPortFrom(pm.ExternalIP, uint16(pm.ExternalPort)).String()
+				}
+			}
+		}
+		m.cfg.Bus.Publish(ev)
+	}
+	return mappings, err
+}
+
+func samePortMappings(a, b []PortMapping) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Protocol != b[i].Protocol || a[i].ExternalPort != b[i].ExternalPort || a[i].ExternalIP != b[i].ExternalIP {
+			return false
+		}
+	}
+	return true
+}
+
+// this function maps TCP and UDP with method, asking for the external ports of previous
+func (m *PortMapper) mapWith(method portMapMethod, previous []PortMapping) ([]PortMapping, error) {
+	var mappings []PortMapping
+	for _, protocol := range []string{"TCP", "UDP"} {
+		// the same external port as our own, or the one we had, keeps what we told others valid
+		external := m.cfg.Port
+		for _, pm := range previous {
+			if pm.Protocol == protocol {
+				external = pm.ExternalPort
+			}
+		}
+		pm, err := method.mapPort(m.ctx, protocol, m.cfg.Port, external, m.cfg.Lifetime)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", method.name(), protocol, err)
+		}
+		mappings = append(mappings, pm)
+	}
+	return mappings, nil
+}
+
+// this function tries PCP, NAT-PMP and UPnP in turn and returns the first that mapped
+func (m *PortMapper) discover() (portMapMethod, []PortMapping, error) {
+	var errs []error
+	if !m.cfg.DisableNATPMP {
+		gateway, err := m.natpmpGateway()
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			client := &natpmpClient{gateway: gateway}
+			mappings, err := m.mapWith(client, nil)
+			if errors.Is(err, errUnsupportedVersion) {
+				client.legacy = true
+				mappings, err = m.mapWith(client, nil)
+			}
+			if err == nil {
+				return client, mappings, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	if !m.cfg.DisableUPnP && m.ctx.Err() == nil {
+		var client *upnpClient
+		var err error
+		if m.cfg.UPnPLocation != "" {
+			client, err = newUPnPClient(m.ctx, m.cfg.UPnPLocation)
+		} else {
+			client, err = discoverUPnP(m.ctx)
+		}
+		if err == nil {
+			var mappings []PortMapping
+			mappings, err = m.mapWith(client, nil)
+			if err == nil {
+				return client, mappings, nil
+			}
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, nil, errors.New("every port mapping method is disabled")
+	}
+	return nil, nil, fmt.Errorf("no gateway mapped the port: %w", errors.Join(errs...))
+}
+
+func (m *PortMapper) natpmpGateway() (*net.UDPAddr, error) {
+	if m.cfg.Gateway != "" {
+		return net.ResolveUDPAddr("udp4", m.cfg.Gateway)
+	}
+	ip, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip.AsSlice(), Port: natpmpPort}, nil
+}
+
+// defaultGateway returns the IPv4 gateway of the default route. The routing table is read
+// where the system exposes it, elsewhere the gateway is taken to be the first address of the
+// local network, which is what nearly every home router uses
+func defaultGateway() (netip.Addr, error) {
+	if ip, err := linuxDefaultGateway(); err == nil {
+		return ip, nil
+	}
+	local, err := localAddrTo(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9})
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("no default route: %w", err)
+	}
+	if !local.Is4() || !local.IsPrivate() {
+		return netip.Addr{}, errors.New("not behind a NAT gateway")
+	}
+	prefix, _ := local.Prefix(24)
+	return prefix.Addr().Next(), nil
+}
+
+// this function reads the default route's gateway from /proc/net/route
+func linuxDefaultGateway() (netip.Addr, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ..., addresses in host byte order hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		var gw uint32
+		if _, err := fmt.Sscanf(fields[2], "%x", &gw); err != nil || gw == 0 {
+			continue
+		}
+		var ip [4]byte
+		binary.NativeEndian.PutUint32(ip[:], gw)
+		return netip.AddrFrom4(ip), nil
+	}
+	return netip.Addr{}, errors.New("no default route")
+}
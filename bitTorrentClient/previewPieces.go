@@ -0,0 +1,40 @@
+// This file implements preview pieces: with the option on, the first and last piece of every
+// file that isn't skipped are fetched before the rest. Media players read the header at the
+// start of a file and often an index at the end (an mp4 moov atom, a matroska cue table)
+// before they can play anything, so this gets a preview going early without the cost of
+// sequential mode, which gives up rarest first for the whole download
+package bittorrentclient
+
+// SetPreviewPieces turns preview pieces on or off, see previewPieces.go
+func (d *Download) SetPreviewPieces(enabled bool) {
+	d.mu.Lock()
+	d.previewPieces = enabled
+	d.applyFilePriorities()
+	d.mu.Unlock()
+
+	for _, p := range d.Peers() {
+		d.updateInterest(p)
+	}
+}
+
+func (d *Download) PreviewPieces() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.previewPieces
+}
+
+// this function raises the first and last piece of every file that isn't skipped to
+// PiecePriorityNow. the caller must hold d.mu
+func (d *Download) applyPreviewPieces(prios []PiecePriority) {
+	if !d.previewPieces {
+		return
+	}
+	for i, f := range d.Torrent.files() {
+		first, last, ok := d.Torrent.filePieces(f)
+		if !ok || d.filePriority[i] == FileSkip {
+			continue
+		}
+		prios[first] = PiecePriorityNow
+		prios[last] = PiecePriorityNow
+	}
+}
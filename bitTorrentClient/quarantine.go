@@ -0,0 +1,151 @@
+// This file quarantines pieces that failed their hash check. The corrupt data is kept along
+// with which peer sent each block, so it can be dumped and compared against the good copy
+// once that arrives. The peers that contributed to a bad copy are suspects for that piece:
+// it is downloaded again from other peers, and only falls back to the suspects when nobody
+// else came up with it for a while
+package bittorrentclient
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// the corrupt data of at most this many pieces is kept, older buffers are dropped first
+	maxQuarantinedBuffers = 16
+	// suspects may serve the piece again once it was quarantined this long
+	quarantineSuspectWait = time.Minute
+)
+
+// BlockSource is the peer a block of a quarantined piece came from
+type BlockSource struct {
+	Begin int
+	Peer  string
+}
+
+// QuarantinedPiece describes a piece that failed its hash check and wasn't replaced yet
+type QuarantinedPiece struct {
+	Index    int
+	Failures int
+	FailedAt time.Time
+	// Blocks lists where each block of the last bad copy came from
+	Blocks []BlockSource
+	// Suspects are the hosts that sent blocks of any bad copy
+	Suspects []string
+	// HasData is false once the bad copy was dropped to make room for newer ones
+	HasData bool
+}
+
+type quarantinedPiece struct {
+	failures int
+	failedAt time.Time
+	blocks   []BlockSource
+	suspects map[string]bool
+	data     []byte
+}
+
+// this function quarantines a piece that failed its hash check. sources maps each block's
+// offset to the peer that sent it. the caller must hold d.mu
+func (d *Download) quarantinePiece(ap *activePiece, sources map[int]*Peer) {
+	q, ok := d.quarantine[ap.index]
+	if !ok {
+		q = &quarantinedPiece{suspects: make(map[string]bool)}
+		d.quarantine[ap.index] = q
+	}
+	q.failures++
+	q.failedAt = time.Now()
+	q.data = ap.data
+	q.blocks = q.blocks[:0]
+	for begin, p := range sources {
+		q.blocks = append(q.blocks, BlockSource{Begin: begin, Peer: p.Addr})
+		q.suspects[banKey(p.Addr)] = true
+	}
+	sort.Slice(q.blocks, func(i, j int) bool { return q.blocks[i].Begin < q.blocks[j].Begin })
+
+	// drop the oldest buffers beyond the limit, their sources are still remembered
+	var held []int
+	for index, q := range d.quarantine {
+		if q.data != nil {
+			held = append(held, index)
+		}
+	}
+	if len(held) > maxQuarantinedBuffers {
+		sort.Slice(held, func(i, j int) bool {
+			return d.quarantine[held[i]].failedAt.Before(d.quarantine[held[j]].failedAt)
+		})
+		for _, index := range held[:len(held)-maxQuarantinedBuffers] {
+			d.quarantine[index].data = nil
+		}
+	}
+}
+
+// this function releases a piece from quarantine once a good copy was written. the caller
+// must hold d.mu
+func (d *Download) releaseQuarantine(index int) {
+	delete(d.quarantine, index)
+}
+
+// this function reports whether p sent blocks of a bad copy of index, and should leave the
+// piece to other peers for now. the caller must hold d.mu
+func (d *Download) isSuspect(index int, p *Peer) bool {
+	q, ok := d.quarantine[index]
+	if !ok || time.Since(q.failedAt) >= quarantineSuspectWait {
+		return false
+	}
+	return q.suspects[banKey(p.Addr)]
+}
+
+// this function returns bf without the pieces p is a suspect for, bf itself is left alone
+func (d *Download) withoutSuspectPieces(p *Peer, bf Bitfield) Bitfield {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.quarantine) == 0 {
+		return bf
+	}
+	var out Bitfield
+	for index := range d.quarantine {
+		if bf.HasPiece(index) && d.isSuspect(index, p) {
+			if out == nil {
+				out = append(Bitfield(nil), bf...)
+			}
+			out.ClearPiece(index)
+		}
+	}
+	if out == nil {
+		return bf
+	}
+	return out
+}
+
+// Quarantined returns the pieces that failed their hash check and weren't replaced yet
+func (d *Download) Quarantined() []QuarantinedPiece {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	pieces := make([]QuarantinedPiece, 0, len(d.quarantine))
+	for _, index := range slices.Sorted(maps.Keys(d.quarantine)) {
+		q := d.quarantine[index]
+		suspects := slices.Sorted(maps.Keys(q.suspects))
+		pieces = append(pieces, QuarantinedPiece{
+			Index:    index,
+			Failures: q.failures,
+			FailedAt: q.failedAt,
+			Blocks:   append([]BlockSource(nil), q.blocks...),
+			Suspects: suspects,
+			HasData:  q.data != nil,
+		})
+	}
+	return pieces
+}
+
+// QuarantinedData returns a copy of the last bad copy of piece index, for diagnostics
+func (d *Download) QuarantinedData(index int) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q, ok := d.quarantine[index]
+	if !ok || q.data == nil {
+		return nil, false
+	}
+	return append([]byte(nil), q.data...), true
+}
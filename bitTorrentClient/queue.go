@@ -0,0 +1,260 @@
+// This file keeps the client's torrents in a queue and, with WithMaxActive, runs only so
+// many of them at once. The queue is the order of Client.Torrents: torrents join it at the
+// bottom and can be moved up, down, to the top or to the bottom, and a queue priority puts
+// high priority torrents ahead of normal ones and low priority ones behind them. The first
+// torrents still downloading get the download slots and the first finished ones the seeding
+// slots, the others wait in DownloadQueued. A torrent moved ahead of a running one takes its
+// slot and the running one goes back to waiting. Paused, stopped and failed torrents keep
+// their state and take no slot
+package bittorrentclient
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the queue is looked at this often, besides right after a change to it
+const queueCheckInterval = 2 * time.Second
+
+// QueuePriority puts a torrent ahead of, or behind, the torrents of lower, or higher,
+// priority in the queue
+type QueuePriority int
+
+const (
+	QueueLow    QueuePriority = -1
+	QueueNormal QueuePriority = 0
+	QueueHigh   QueuePriority = 1
+)
+
+func (p QueuePriority) String() string {
+	switch p {
+	case QueueLow:
+		return "low"
+	case QueueNormal:
+		return "normal"
+	case QueueHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseQueuePriority parses "low", "normal" or "high"
+func ParseQueuePriority(s string) (QueuePriority, error) {
+	for _, p := range []QueuePriority{QueueLow, QueueNormal, QueueHigh} {
+		if strings.EqualFold(s, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("queue priority %q must be low, normal or high", s)
+}
+
+// QueueMove is a way to move a torrent in the queue
+type QueueMove int
+
+const (
+	QueueUp QueueMove = iota
+	QueueDown
+	QueueTop
+	QueueBottom
+)
+
+func (m QueueMove) String() string {
+	switch m {
+	case QueueUp:
+		return "up"
+	case QueueDown:
+		return "down"
+	case QueueTop:
+		return "top"
+	case QueueBottom:
+		return "bottom"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseQueueMove parses "up", "down", "top" or "bottom"
+func ParseQueueMove(s string) (QueueMove, error) {
+	for _, m := range []QueueMove{QueueUp, QueueDown, QueueTop, QueueBottom} {
+		if strings.EqualFold(s, m.String()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("queue move %q must be up, down, top or bottom", s)
+}
+
+// SetQueuePriority sets where the download goes in its client's queue. Client.SetQueuePriority
+// acts on it right away, the client gets to it within queueCheckInterval otherwise
+func (d *Download) SetQueuePriority(prio QueuePriority) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queuePriority = max(QueueLow, min(prio, QueueHigh))
+}
+
+func (d *Download) QueuePriority() QueuePriority {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queuePriority
+}
+
+// SetQueuePriority sets the queue priority of the download of infoHash and acts on it
+func (c *Client) SetQueuePriority(infoHash [20]byte, prio QueuePriority) error {
+	d, ok := c.Torrent(infoHash)
+	if !ok {
+		return ErrTorrentUnknown
+	}
+	d.SetQueuePriority(prio)
+	c.mu.Lock()
+	c.sortQueue()
+	c.mu.Unlock()
+	c.queueChanged()
+	return nil
+}
+
+// MoveInQueue moves the download of infoHash in the queue. It only passes torrents of its
+// own queue priority, up and down swap it with the next one of them
+func (c *Client) MoveInQueue(infoHash [20]byte, move QueueMove) error {
+	c.mu.Lock()
+	c.sortQueue()
+	i := slices.Index(c.order, infoHash)
+	if i < 0 {
+		c.mu.Unlock()
+		return ErrTorrentUnknown
+	}
+	// the torrents of the same priority are next to each other after sorting
+	prio := c.torrents[infoHash].QueuePriority()
+	first, last := i, i
+	for first > 0 && c.torrents[c.order[first-1]].QueuePriority() == prio {
+		first--
+	}
+	for last < len(c.order)-1 && c.torrents[c.order[last+1]].QueuePriority() == prio {
+		last++
+	}
+	to := i
+	switch move {
+	case QueueUp:
+		to = max(i-1, first)
+	case QueueDown:
+		to = min(i+1, last)
+	case QueueTop:
+		to = first
+	case QueueBottom:
+		to = last
+	default:
+		c.mu.Unlock()
+		return errors.New("unknown queue move")
+	}
+	c.order = slices.Insert(slices.Delete(c.order, i, i+1), to, infoHash)
+	c.mu.Unlock()
+	c.queueChanged()
+	return nil
+}
+
+// this function orders the queue by priority, keeping the order within each priority. the
+// caller must hold c.mu
+func (c *Client) sortQueue() {
+	prios := make(map[[20]byte]QueuePriority, len(c.order))
+	for _, infoHash := range c.order {
+		prios[infoHash] = c.torrents[infoHash].QueuePriority()
+	}
+	slices.SortStableFunc(c.order, func(a, b [20]byte) int {
+		return cmp.Compare(prios[b], prios[a])
+	})
+}
+
+// this function makes the queue be looked at right away and the new order saved
+func (c *Client) queueChanged() {
+	select {
+	case c.requeue <- struct{}{}:
+	default:
+	}
+	c.saveStateNow()
+}
+
+// this function reports whether torrents wait in the queue for a slot
+func (c *Client) queueing() bool {
+	return c.maxDownloads > 0 || c.maxSeeds > 0
+}
+
+// this function runs the queue until the client is closed
+func (c *Client) queueLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+	// torrents finishing or failing free their slot
+	unsubscribe := c.bus.Subscribe(0, func(ev SessionEvent) {
+		if ev.Type == SessionTorrentCompleted || ev.Type == SessionTorrentErrored {
+			select {
+			case c.requeue <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer unsubscribe()
+	for {
+		c.runQueue()
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		case <-c.requeue:
+		}
+	}
+}
+
+// this function starts the first torrents of the queue that fit in the slots and puts the
+// running ones that don't back to waiting
+func (c *Client) runQueue() {
+	c.mu.Lock()
+	c.sortQueue()
+	queue := make([]*Download, 0, len(c.order))
+	for _, infoHash := range c.order {
+		queue = append(queue, c.torrents[infoHash])
+	}
+	c.mu.Unlock()
+
+	// the torrents losing their slot stop before the ones getting it start, so the limits
+	// hold throughout
+	var downloading, seeding int
+	var start, wait []*Download
+	for _, d := range queue {
+		d.mu.Lock()
+		state, complete := d.state, d.complete()
+		d.mu.Unlock()
+		if state != DownloadDownloading && state != DownloadSeeding && state != DownloadQueued {
+			continue
+		}
+		slots, taken := c.maxDownloads, &downloading
+		if complete {
+			slots, taken = c.maxSeeds, &seeding
+		}
+		switch {
+		case slots == 0 || *taken < slots:
+			*taken++
+			if state == DownloadQueued {
+				start = append(start, d)
+			}
+		case state != DownloadQueued:
+			wait = append(wait, d)
+		}
+	}
+	for _, d := range wait {
+		infoHash := fmt.Sprintf("%x", d.InfoHash)
+		c.logger.Info("queueing torrent", "infohash", infoHash)
+		if err := d.pause(DownloadQueued); err != nil {
+			c.logger.Warn("queueing torrent failed", "infohash", infoHash, "err", err)
+		}
+	}
+	for _, d := range start {
+		infoHash := fmt.Sprintf("%x", d.InfoHash)
+		c.logger.Info("starting queued torrent", "infohash", infoHash)
+		if err := d.Start(); err != nil {
+			c.logger.Warn("queued torrent failed to start", "infohash", infoHash, "err", err)
+		}
+	}
+}
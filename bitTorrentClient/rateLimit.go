@@ -0,0 +1,211 @@
+// This file implements token bucket rate limiting and a net.Conn wrapper that applies
+// limiters to every Read and Write going through it
+package bittorrentclient
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// data is passed through the limiters in chunks of at most this size so a single large
+// write doesn't take the whole bucket in one go
+const rateLimitChunk = 16 * 1024
+
+// RateLimiter is a token bucket measured in bytes. A rate of zero means unlimited
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// overhead makes the limiter charge for the TCP/IP headers around the payload too
+	overhead bool
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+// SetRate changes the limit, it can be called at any time while the limiter is in use
+func (l *RateLimiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	l.rate = float64(bytesPerSec)
+	// allow bursts of a quarter second, but never less than one chunk
+	l.burst = l.rate / 4
+	if l.burst < rateLimitChunk {
+		l.burst = rateLimitChunk
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = time.Now()
+}
+
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// reserve takes n tokens out of the bucket and returns how long the caller has to wait
+// before using them. the bucket may go into debt, which later callers pay off
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.overhead {
+		n = wireBytes(n)
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WaitN blocks until n bytes may pass the limiter or ctx is done
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	wait := l.reserve(n)
+	if wait == 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// this function charges n bytes without waiting for them, for traffic that already went
+// over the wire. later callers pay off the debt
+func (l *RateLimiter) consume(n int) {
+	l.reserve(n)
+}
+
+// this function takes n tokens from every limiter and waits until all of them allow it.
+// the waits overlap, so nested limits (peer, download, session) cost the strictest one's
+// delay rather than the sum of all of them
+func waitAll(limiters []*RateLimiter, n int) {
+	var wait time.Duration
+	for _, l := range limiters {
+		wait = max(wait, l.reserve(n))
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// rateLimitedConn passes reads and writes through every limiter in its lists. When
+// writeTimeout is set every chunk has to be written within it, time spent waiting on the
+// limiters doesn't count
+type rateLimitedConn struct {
+	net.Conn
+	mu            sync.Mutex
+	readLimiters  []*RateLimiter
+	writeLimiters []*RateLimiter
+	writeTimeout  time.Duration
+}
+
+// this function adds limiters on top of the ones the connection already has. the lists are
+// copied so a Read or Write that's in progress keeps the old ones
+func (c *rateLimitedConn) addLimiters(read, write *RateLimiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if read != nil {
+		c.readLimiters = append(append([]*RateLimiter(nil), c.readLimiters...), read)
+	}
+	if write != nil {
+		c.writeLimiters = append(append([]*RateLimiter(nil), c.writeLimiters...), write)
+	}
+}
+
+func (c *rateLimitedConn) limiters(write bool) []*RateLimiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if write {
+		return c.writeLimiters
+	}
+	return c.readLimiters
+}
+
+// reads are throttled after the fact, delaying the next read lets the TCP receive window
+// fill up so the sender slows down
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	limiters := c.limiters(false)
+	if len(limiters) > 0 && len(b) > rateLimitChunk {
+		b = b[:rateLimitChunk]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		waitAll(limiters, n)
+	}
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	limiters := c.limiters(true)
+	c.mu.Lock()
+	timeout := c.writeTimeout
+	c.mu.Unlock()
+	if len(limiters) == 0 {
+		if timeout > 0 {
+			c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		return c.Conn.Write(b)
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > rateLimitChunk {
+			chunk = chunk[:rateLimitChunk]
+		}
+		waitAll(limiters, len(chunk))
+		if timeout > 0 {
+			c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// SetUploadLimit caps how fast we send to this peer in bytes per second, zero removes the cap
+func (p *Peer) SetUploadLimit(bytesPerSec int64) {
+	p.uploadLimit.SetRate(bytesPerSec)
+}
+
+// SetDownloadLimit caps how fast we read from this peer in bytes per second, zero removes the cap
+func (p *Peer) SetDownloadLimit(bytesPerSec int64) {
+	p.downloadLimit.SetRate(bytesPerSec)
+}
+
+func (p *Peer) UploadLimit() int64 {
+	return p.uploadLimit.Rate()
+}
+
+func (p *Peer) DownloadLimit() int64 {
+	return p.downloadLimit.Rate()
+}
@@ -0,0 +1,104 @@
+// This file measures transfer rates. A rateMeter keeps an exponentially weighted moving
+// average of the bytes moved each second, which follows changes within a few seconds but
+// doesn't jump around with every burst. The same meter is used for peers, downloads and the
+// whole session, so the choker, UIs and stats all see the same numbers
+package bittorrentclient
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rates are averaged with this time constant
+const rateTimeConstant = 5 * time.Second
+
+// each elapsed second keeps this much of the previous average
+var rateDecay = math.Exp(-float64(time.Second) / float64(rateTimeConstant))
+
+// rateMeter is an EWMA of bytes per second, updated in one second steps. It isn't safe for
+// concurrent use, its owner locks around it
+type rateMeter struct {
+	// second is the unix second current counts bytes for
+	second  int64
+	current int64
+	average float64
+	// weight corrects the average while the meter is younger than a few time constants,
+	// which would otherwise read low because it started from zero
+	weight float64
+}
+
+func (m *rateMeter) add(n int64, now time.Time) {
+	m.advance(now)
+	m.current += n
+}
+
+// this function folds every second that ended since the last update into the average
+func (m *rateMeter) advance(now time.Time) {
+	sec := now.Unix()
+	if m.second == 0 {
+		m.second = sec
+		return
+	}
+	elapsed := sec - m.second
+	if elapsed <= 0 {
+		return
+	}
+	m.average = m.average*rateDecay + float64(m.current)*(1-rateDecay)
+	m.weight = m.weight*rateDecay + (1 - rateDecay)
+	if idle := elapsed - 1; idle > 0 {
+		// seconds without any traffic only decay the average
+		decay := math.Pow(rateDecay, float64(idle))
+		m.average *= decay
+		m.weight = m.weight*decay + (1 - decay)
+	}
+	m.current = 0
+	m.second = sec
+}
+
+// this function returns the average in bytes per second over the completed seconds
+func (m *rateMeter) rate(now time.Time) float64 {
+	m.advance(now)
+	if m.weight == 0 {
+		return 0
+	}
+	return m.average / m.weight
+}
+
+// TransferRates tracks upload and download rates, e.g. for a download or a whole session.
+// It is safe for concurrent use
+type TransferRates struct {
+	mu   sync.Mutex
+	down rateMeter
+	up   rateMeter
+}
+
+func NewTransferRates() *TransferRates {
+	return &TransferRates{}
+}
+
+func (r *TransferRates) addDownloaded(n int64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down.add(n, now)
+}
+
+func (r *TransferRates) addUploaded(n int64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.up.add(n, now)
+}
+
+// DownloadRate returns the smoothed download rate in bytes per second
+func (r *TransferRates) DownloadRate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.down.rate(time.Now())
+}
+
+// UploadRate returns the smoothed upload rate in bytes per second
+func (r *TransferRates) UploadRate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.up.rate(time.Now())
+}
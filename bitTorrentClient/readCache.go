@@ -0,0 +1,149 @@
+// This file caches piece data for serving uploads. Peers request pieces 16 KiB at a time,
+// and the pieces everyone wants tend to be the same few, so instead of reading the disk for
+// every request a whole piece is read once and kept in an LRU cache. The cache has a memory
+// budget and can be shared by any number of downloads
+package bittorrentclient
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultReadCacheSize is the budget of the cache downloads share unless given their own
+const DefaultReadCacheSize = 32 << 20
+
+type cacheKey struct {
+	infoHash [20]byte
+	index    int
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+type ReadCacheStats struct {
+	Budget int64
+	Used   int64
+	Pieces int
+	Hits   int64
+	Misses int64
+}
+
+// ReadCache is an LRU cache of whole pieces, it is safe for concurrent use
+type ReadCache struct {
+	mu      sync.Mutex
+	budget  int64
+	used    int64
+	entries map[cacheKey]*list.Element
+	// lru has the most recently used piece at the front
+	lru    *list.List
+	hits   int64
+	misses int64
+}
+
+// NewReadCache returns a cache holding at most budget bytes of piece data, zero disables it
+func NewReadCache(budget int64) *ReadCache {
+	return &ReadCache{
+		budget:  budget,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+var (
+	sharedReadCache     *ReadCache
+	sharedReadCacheOnce sync.Once
+)
+
+// this function returns the cache used by downloads that weren't given one
+func defaultReadCache() *ReadCache {
+	sharedReadCacheOnce.Do(func() {
+		sharedReadCache = NewReadCache(DefaultReadCacheSize)
+	})
+	return sharedReadCache
+}
+
+// SetBudget changes the memory budget, shrinking it evicts pieces right away
+func (c *ReadCache) SetBudget(budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.budget = max(budget, 0)
+	c.evict()
+}
+
+func (c *ReadCache) Stats() ReadCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ReadCacheStats{
+		Budget: c.budget,
+		Used:   c.used,
+		Pieces: c.lru.Len(),
+		Hits:   c.hits,
+		Misses: c.misses,
+	}
+}
+
+// this function returns the cached piece, the data must not be modified
+func (c *ReadCache) get(infoHash [20]byte, index int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[cacheKey{infoHash, index}]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).data, true
+}
+
+// this function reports whether a piece of size bytes can be cached at all
+func (c *ReadCache) fits(size int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(size) <= c.budget
+}
+
+// this function caches a piece, data must not be modified afterwards
+func (c *ReadCache) put(infoHash [20]byte, index int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(data)) > c.budget {
+		return
+	}
+	key := cacheKey{infoHash, index}
+	if el, ok := c.entries[key]; ok {
+		c.used -= int64(len(el.Value.(*cacheEntry).data))
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.used += int64(len(data))
+	c.evict()
+}
+
+// this function drops every cached piece of a torrent, e.g. when its data was rechecked
+func (c *ReadCache) invalidate(infoHash [20]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.entries {
+		if key.infoHash == infoHash {
+			c.remove(el)
+		}
+	}
+}
+
+// this function evicts least recently used pieces until the cache fits its budget. the
+// caller must hold c.mu
+func (c *ReadCache) evict() {
+	for c.used > c.budget {
+		c.remove(c.lru.Back())
+	}
+}
+
+// the caller must hold c.mu
+func (c *ReadCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.used -= int64(len(e.data))
+}
@@ -0,0 +1,207 @@
+// This file lets a download be read like a file while it is still downloading, e.g. to play
+// a video or feed an HTTP range server. A Reader puts deadlines on the pieces just ahead of
+// its position so the picker fetches them first, and a Read blocks until the piece under
+// the position is verified and on disk. Seeking moves the deadlines along
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReadahead is how far ahead of the position a Reader asks for pieces
+	DefaultReadahead = 8 << 20
+	// the piece under the position is due in this long, each one after it this much later
+	readerDeadlineStep = time.Second
+)
+
+var ErrDownloadStopped = errors.New("download is stopped")
+
+// Reader reads one file of a download, see NewReader. It is safe for concurrent use but
+// reads and seeks are serialized
+type Reader struct {
+	d    *Download
+	file fileSpan
+	ctx  context.Context
+
+	mu        sync.Mutex
+	pos       int64
+	readahead int64
+	// deadlines holds the pieces this reader put deadlines on
+	deadlines map[int]bool
+	closed    bool
+}
+
+// NewReader returns a reader for file, an index into the torrent's files. Pieces of a
+// skipped file become wanted once the reader gets to them. Close the reader to drop its
+// deadlines
+func (d *Download) NewReader(file int) (*Reader, error) {
+	files := d.Torrent.files()
+	if file < 0 || file >= len(files) {
+		return nil, fmt.Errorf("file index %d out of range", file)
+	}
+	if files[file].Padding {
+		return nil, fmt.Errorf("file %d is a padding file", file)
+	}
+	return &Reader{
+		d:         d,
+		file:      files[file],
+		ctx:       context.Background(),
+		readahead: DefaultReadahead,
+		deadlines: make(map[int]bool),
+	}, nil
+}
+
+// SetContext makes blocked reads give up when ctx is done
+func (r *Reader) SetContext(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+}
+
+// SetReadahead sets how many bytes past the position get deadlines
+func (r *Reader) SetReadahead(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readahead = max(n, 0)
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errors.New("reader is closed")
+	}
+	if r.pos >= r.file.Length {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	pieceLength := r.d.Torrent.Info.PieceLength
+	off := r.file.Offset + r.pos
+	index := int(off / pieceLength)
+	r.setDeadlines(index)
+
+	storage, err := r.waitFor(index)
+	if err != nil {
+		return 0, err
+	}
+	// one piece at a time, the next one may not be there yet
+	end := min(int64(index+1)*pieceLength, r.file.Offset+r.file.Length)
+	b = b[:min(int64(len(b)), end-off)]
+	n, err := storage.ReadPieceAt(index, b, off-int64(index)*pieceLength)
+	r.pos += int64(n)
+	if err == io.EOF && n == len(b) {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.file.Length
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	// the new window gets its deadlines on the next read
+	return offset, nil
+}
+
+// Close drops the reader's deadlines, the pieces it asked for are downloaded as usual
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for index := range r.deadlines {
+		r.d.picker.ClearPieceDeadline(index)
+	}
+	clear(r.deadlines)
+	return nil
+}
+
+// this function puts deadlines on the pieces from first to the end of the readahead window
+// and drops the ones this reader set outside it. the caller must hold r.mu
+func (r *Reader) setDeadlines(first int) {
+	pieceLength := r.d.Torrent.Info.PieceLength
+	fileEnd := r.file.Offset + r.file.Length
+	last := int((min(r.file.Offset+r.pos+r.readahead, fileEnd) - 1) / pieceLength)
+	last = max(last, first)
+
+	have := r.d.Have()
+	window := make(map[int]bool, last-first+1)
+	for index := first; index <= last; index++ {
+		window[index] = true
+		if have.HasPiece(index) || r.deadlines[index] {
+			// a deadline that is already set keeps running, moving it on every read
+			// would never let it pass
+			continue
+		}
+		if r.d.PiecePriority(index) == PiecePriorityIgnore {
+			_ = r.d.SetPiecePriority(index, PiecePriorityNormal)
+		}
+		r.d.picker.SetPieceDeadline(index, time.Duration(index-first+1)*readerDeadlineStep)
+	}
+	for index := range r.deadlines {
+		if !window[index] {
+			r.d.picker.ClearPieceDeadline(index)
+		}
+	}
+	r.deadlines = window
+
+	// get the peers onto the urgent pieces now rather than when their next block arrives
+	for _, p := range r.d.Peers() {
+		r.d.updateInterest(p)
+		r.d.fillRequests(p)
+	}
+}
+
+// this function blocks until piece index is on disk and returns the storage to read it
+// from. the caller must hold r.mu
+func (r *Reader) waitFor(index int) (TorrentStorage, error) {
+	d := r.d
+	for {
+		d.mu.Lock()
+		storage := d.storage
+		have := d.have.HasPiece(index)
+		state, err := d.state, d.err
+		ready := d.pieceReady
+		d.mu.Unlock()
+
+		switch {
+		case have && storage != nil:
+			return storage, nil
+		case state == DownloadError:
+			return nil, err
+		case state == DownloadStopped:
+			return nil, ErrDownloadStopped
+		}
+		select {
+		case <-ready:
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		}
+	}
+}
+
+// this function wakes every reader waiting for a piece, after one was written or the state
+// changed. the caller must hold d.mu
+func (d *Download) wakeReaders() {
+	close(d.pieceReady)
+	d.pieceReady = make(chan struct{})
+}
@@ -0,0 +1,148 @@
+// This file decides when a peer address may be dialed again. Addresses that failed or
+// disconnected are backed off exponentially, and addresses that keep failing the handshake
+// are dropped for good instead of being redialed after every announce
+package bittorrentclient
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReconnectBaseDelay    = 30 * time.Second
+	defaultReconnectMaxDelay     = 30 * time.Minute
+	defaultMaxHandshakeFailures  = 3
+	reconnectAfterDisconnectWait = 10 * time.Second
+)
+
+var ErrHandshakeFailed = errors.New("handshake failed")
+
+type reconnectEntry struct {
+	failures          int
+	handshakeFailures int
+	nextAttempt       time.Time
+	dropped           bool
+}
+
+type ReconnectPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxHandshakeFailures is the number of failed handshakes after which an address is dropped
+	MaxHandshakeFailures int
+
+	mu      sync.Mutex
+	entries map[string]*reconnectEntry
+}
+
+func NewReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		BaseDelay:            defaultReconnectBaseDelay,
+		MaxDelay:             defaultReconnectMaxDelay,
+		MaxHandshakeFailures: defaultMaxHandshakeFailures,
+		entries:              make(map[string]*reconnectEntry),
+	}
+}
+
+func (r *ReconnectPolicy) entry(addr string) *reconnectEntry {
+	e, ok := r.entries[addr]
+	if !ok {
+		e = &reconnectEntry{}
+		r.entries[addr] = e
+	}
+	return e
+}
+
+// CanDial reports whether addr may be dialed now
+func (r *ReconnectPolicy) CanDial(addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[addr]
+	if !ok {
+		return true
+	}
+	return !e.dropped && !time.Now().Before(e.nextAttempt)
+}
+
+// Filter returns the addresses from addrs that may be dialed now
+func (r *ReconnectPolicy) Filter(addrs []string) []string {
+	var ok []string
+	for _, addr := range addrs {
+		if r.CanDial(addr) {
+			ok = append(ok, addr)
+		}
+	}
+	return ok
+}
+
+// RecordDialResult updates the address after a DialPeer attempt
+func (r *ReconnectPolicy) RecordDialResult(addr string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(addr)
+	if err == nil {
+		e.failures = 0
+		e.handshakeFailures = 0
+		e.nextAttempt = time.Time{}
+		return
+	}
+
+	e.failures++
+	if errors.Is(err, ErrHandshakeFailed) {
+		e.handshakeFailures++
+		if r.MaxHandshakeFailures > 0 && e.handshakeFailures >= r.MaxHandshakeFailures {
+			e.dropped = true
+		}
+	}
+	e.nextAttempt = time.Now().Add(r.backoff(e.failures))
+}
+
+// Disconnected records that an established connection to addr went away, we wait a little
+// before trying again so a peer that kicks us isn't redialed in a tight loop
+func (r *ReconnectPolicy) Disconnected(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(addr)
+	e.nextAttempt = time.Now().Add(reconnectAfterDisconnectWait)
+}
+
+// Drop stops addr from ever being dialed again, e.g. because it turned out to be ourselves
+func (r *ReconnectPolicy) Drop(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(addr).dropped = true
+}
+
+func (r *ReconnectPolicy) Dropped(addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[addr]
+	return ok && e.dropped
+}
+
+// this function returns BaseDelay doubled for every consecutive failure, capped at MaxDelay
+// and jittered so peers that failed together don't get redialed together
+func (r *ReconnectPolicy) backoff(failures int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < failures && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxDelay {
+		delay = r.MaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - delay/10 + jitter
+}
+
+// Prune forgets addresses whose backoff ran out long ago, dropped addresses are kept
+func (r *ReconnectPolicy) Prune() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff := time.Now().Add(-r.MaxDelay)
+	for addr, e := range r.entries {
+		if !e.dropped && e.nextAttempt.Before(cutoff) {
+			delete(r.entries, addr)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// This file renames a torrent's files and its root directory, e.g. to turn a release name
+// full of dots and tags into something readable. The torrent itself is left as it is, the
+// download keeps a copy of it with the new names as its file layout, and storage, resume
+// data and file progress all go by that layout. Files already on disk are renamed along,
+// so nothing has to be downloaded again
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// RenameRoot renames the torrent's root, the directory of a multi-file torrent or the file
+// of a single file one
+func (d *Download) RenameRoot(name string) error {
+	_, err := safePathElement(name)
+	if err != nil {
+		return err
+	}
+	return d.relocate(func(layout *Torrent, dir, partSuffix string) (*Torrent, string, error) {
+		renamed := layout.withNames(name, nil)
+		return renamed, dir, relocateFiles(layout, dir, renamed, dir, partSuffix)
+	})
+}
+
+// RenameFile gives the file at index a new path below the torrent's root. For a single file
+// torrent the path is just the new file name, the same as RenameRoot
+func (d *Download) RenameFile(index int, path ...string) error {
+	if len(d.Torrent.Info.Files) == 0 {
+		if index != 0 || len(path) != 1 {
+			return errors.New("the file of a single file torrent can only be given a new name")
+		}
+		return d.RenameRoot(path[0])
+	}
+	if index < 0 || index >= len(d.Torrent.Info.Files) {
+		return fmt.Errorf("file index %d out of range", index)
+	}
+	if d.Torrent.Info.Files[index].IsPadding() {
+		return fmt.Errorf("file %d is a padding file", index)
+	}
+	if len(path) == 0 {
+		return errors.New("empty path")
+	}
+	for _, elem := range path {
+		if _, err := safePathElement(elem); err != nil {
+			return err
+		}
+	}
+	return d.relocate(func(layout *Torrent, dir, partSuffix string) (*Torrent, string, error) {
+		renamed := layout.withNames(layout.Info.Name, map[int][]string{index: path})
+		return renamed, dir, relocateFiles(layout, dir, renamed, dir, partSuffix)
+	})
+}
+
+// this function returns the torrent with its files laid out under their current names.
+// the caller must hold d.mu
+func (d *Download) fileLayout() *Torrent {
+	if d.layout != nil {
+		return d.layout
+	}
+	return d.Torrent
+}
+
+// this function returns a copy of t with the root renamed to name and the files in paths
+// given new paths
+func (t *Torrent) withNames(name string, paths map[int][]string) *Torrent {
+	c := *t
+	c.Info.Name = name
+	c.Info.Files = slices.Clone(t.Info.Files)
+	for index, path := range paths {
+		c.Info.Files[index].Path = slices.Clone(path)
+	}
+	return &c
+}
+
+// this function returns the paths of the files whose layout differs from t, for the resume
+// data. nil when none were renamed
+func (t *Torrent) renamedPaths(layout *Torrent) map[int][]string {
+	var paths map[int][]string
+	for i, f := range layout.Info.Files {
+		if !slices.Equal(f.Path, t.Info.Files[i].Path) {
+			if paths == nil {
+				paths = make(map[int][]string)
+			}
+			paths[i] = f.Path
+		}
+	}
+	return paths
+}
+
+// this function returns t laid out with the names from resume data, t itself when nothing
+// was renamed. the names are checked like new ones, resume data can be edited by hand
+func (t *Torrent) restoreNames(name string, paths map[int][]string) (*Torrent, error) {
+	if name == "" && len(paths) == 0 {
+		return t, nil
+	}
+	if name == "" {
+		name = t.Info.Name
+	}
+	if _, err := safePathElement(name); err != nil {
+		return nil, err
+	}
+	for index, path := range paths {
+		if index < 0 || index >= len(t.Info.Files) || len(path) == 0 {
+			return nil, fmt.Errorf("resume data renames file %d, which it can't", index)
+		}
+		for _, elem := range path {
+			if _, err := safePathElement(elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t.withNames(name, paths), nil
+}
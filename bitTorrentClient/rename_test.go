@@ -0,0 +1,79 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mybittorrent/internal/bencode"
+)
+
+func TestRenameKeepsFilesApart(t *testing.T) {
+	files := []interface{}{}
+	for _, path := range [][]interface{}{{"a"}, {"b"}, {"dir", "c"}} {
+		files = append(files, map[string]interface{}{"length": int64(4), "path": path})
+	}
+	metainfo, err := bencode.Encode(map[string]interface{}{
+		"announce": "http://127.0.0.1:1/announce",
+		"info": map[string]interface{}{
+			"name":         "multi",
+			"piece length": int64(16 << 10),
+			"pieces":       make([]byte, 20),
+			"files":        files,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := DecodeTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	root := filepath.Join(dir, "multi")
+	contents := map[string]string{"a": "aaaa", "b": "bbbb", filepath.Join("dir", "c"): "cccc", "unrelated": "keep"}
+	for name, data := range contents {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := NewDownload(torrent, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		index int
+		path  []string
+	}{
+		{"onto another file", 0, []string{"b"}},
+		{"onto a directory of another file", 0, []string{"dir"}},
+		{"inside another file", 2, []string{"a", "c"}},
+		{"onto a file outside the torrent", 1, []string{"unrelated"}},
+	} {
+		if err := d.RenameFile(tc.index, tc.path...); err == nil {
+			t.Errorf("renaming %s succeeded", tc.name)
+		}
+	}
+	for name, data := range contents {
+		got, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || string(got) != data {
+			t.Errorf("%s holds %q (%v), want %q", name, got, err, data)
+		}
+	}
+
+	err = d.RenameFile(0, "dir", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "dir", "a"))
+	if err != nil || string(got) != "aaaa" {
+		t.Errorf("renamed file holds %q (%v), want %q", got, err, "aaaa")
+	}
+}
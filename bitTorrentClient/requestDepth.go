@@ -0,0 +1,51 @@
+// This file sizes the request pipeline to the link. A fixed backlog of 16 blocks caps a peer
+// at 256 KiB per round trip, which on a fast link with a long round trip is far below what
+// it could do. Instead each peer gets enough requests to cover its bandwidth-delay product:
+// what it delivers per second times how long we want the queue to last, and never less than
+// two round trips. The blocks themselves stay at 16 KiB, most clients drop peers that ask
+// for more, so a fast link gets a deeper queue of normal sized requests instead. A peer that
+// says in its extended handshake how many requests it queues, its reqq, never gets more, it
+// would drop the rest
+package bittorrentclient
+
+import "time"
+
+const (
+	// DefaultRequestQueueTime is how many seconds of a peer's transfer rate we keep requested
+	DefaultRequestQueueTime = 3 * time.Second
+	// no peer gets more requests than this, however fast
+	maxRequestBacklog = 500
+)
+
+// this function returns how many requests the peer should have outstanding to keep
+// queueTime worth of data coming, with requestBacklog as the floor for new and slow peers
+// and the peer's reqq as the ceiling
+func (p *Peer) requestDepth(queueTime time.Duration) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate := p.downRate.rate(time.Now())
+	window := max(queueTime, 2*p.latency)
+	depth := int(rate * window.Seconds() / BlockSize)
+	limit := maxRequestBacklog
+	if p.maxRequests > 0 {
+		limit = p.maxRequests
+	}
+	return min(max(depth, requestBacklog), limit)
+}
+
+// SetRequestQueueTime sets how much of each peer's transfer rate is kept requested ahead,
+// longer suits links with a high bandwidth-delay product. Zero restores the default
+func (d *Download) SetRequestQueueTime(queueTime time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if queueTime <= 0 {
+		queueTime = DefaultRequestQueueTime
+	}
+	d.requestQueueTime = queueTime
+}
+
+func (d *Download) RequestQueueTime() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requestQueueTime
+}
@@ -0,0 +1,88 @@
+package bittorrentclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestDepthReqq(t *testing.T) {
+	for _, tc := range []struct {
+		reqq  int64
+		depth int
+	}{
+		{0, requestBacklog},
+		{10, 10},
+		{100, requestBacklog},
+		{1 << 40, requestBacklog},
+	} {
+		p, theirs := pipePeer(t, 1)
+		dict := map[string]interface{}{"m": map[string]interface{}{}}
+		if tc.reqq > 0 {
+			dict["reqq"] = tc.reqq
+		}
+		payload, err := encodeBencode(dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go theirs.Write(formatExtended(extHandshakeID, payload).Serialize())
+		_, err = p.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if depth := p.requestDepth(DefaultRequestQueueTime); depth != tc.depth {
+			t.Errorf("reqq %d: %d requests, want %d", tc.reqq, depth, tc.depth)
+		}
+	}
+
+	// a fast peer is capped at its reqq too
+	p, _ := pipePeer(t, 1)
+	p.maxRequests = 100
+	now := time.Now()
+	p.downRate.add(100<<20, now.Add(-2*time.Second))
+	p.downRate.add(100<<20, now.Add(-time.Second))
+	if depth := p.requestDepth(DefaultRequestQueueTime); depth != 100 {
+		t.Errorf("fast peer with reqq 100: %d requests", depth)
+	}
+}
+
+func TestExtendedHandshakeAdvertisesReqq(t *testing.T) {
+	p, theirs := pipePeer(t, 1)
+	err := p.SendExtendedHandshake(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ReadMessage(theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err := ParseExtended(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := decodeBencode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqq, _ := v.(map[string]interface{})["reqq"].(int64); reqq != maxRequestBacklog {
+		t.Errorf("reqq %d, want %d", reqq, maxRequestBacklog)
+	}
+}
+
+func TestClientRequestQueueTime(t *testing.T) {
+	client, err := NewClient(WithListenHost("127.0.0.1"), WithListenPort(0), WithDHT(false),
+		WithDownloadDir(t.TempDir()), WithRequestQueueTime(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	d, err := client.AddTorrent(testTorrent(t, "http://127.0.0.1:1/announce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.RequestQueueTime(); got != 10*time.Second {
+		t.Errorf("request queue time %v, want 10s", got)
+	}
+	if _, err := NewClient(WithRequestQueueTime(-time.Second)); err == nil {
+		t.Error("negative request queue time accepted")
+	}
+}
@@ -0,0 +1,81 @@
+// This file times out block requests a peer doesn't answer. The timeout scales with how
+// long the peer normally takes: a slow but steady peer gets more time than a fast one, but
+// a stalled peer can't keep blocks to itself. Each timeout halves the number of requests
+// the peer may have outstanding, and blocks it delivers win that back bit by bit
+package bittorrentclient
+
+import "time"
+
+const (
+	minRequestTimeout = 10 * time.Second
+	maxRequestTimeout = 60 * time.Second
+	// a block gets this many times the time the peer should need for it
+	requestTimeoutFactor = 3
+	// the backlog never shrinks below this, a peer that recovers has to be able to show it
+	minRequestBacklog = 1
+	// delivered blocks it takes to make up for one timeout
+	blocksPerRecovery = requestBacklog
+)
+
+// this function returns how long a request to the peer may stay unanswered. the caller
+// must hold p.mu
+func (p *Peer) requestTimeout(now time.Time) time.Duration {
+	timeout := requestTimeoutFactor * p.latency
+	// everything queued ahead of a request has to arrive first, at the rate the peer manages
+	if rate := p.downRate.rate(now); rate > 0 {
+		queued := time.Duration(float64(len(p.pending)*BlockSize) / rate * float64(time.Second))
+		timeout = max(timeout, requestTimeoutFactor*queued)
+	}
+	return min(max(timeout, minRequestTimeout), maxRequestTimeout)
+}
+
+// this function removes and returns the requests that timed out, counting them against the peer
+func (p *Peer) timedOutRequests(now time.Time) []blockRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peerChoking && !fastSupported(p.Reserved) {
+		// a choked peer won't answer, its requests were dropped already
+		return nil
+	}
+	timeout := p.requestTimeout(now)
+	var expired []blockRequest
+	for req, sentAt := range p.pending {
+		if now.Sub(sentAt) > timeout {
+			expired = append(expired, req)
+			delete(p.pending, req)
+		}
+	}
+	if len(expired) > 0 {
+		p.timeouts++
+	}
+	return expired
+}
+
+// this function is called for every requested block the peer delivers. the caller must hold p.mu
+func (p *Peer) recoverFromTimeouts() {
+	if p.timeouts == 0 {
+		return
+	}
+	p.recovered++
+	if p.recovered >= blocksPerRecovery {
+		p.recovered = 0
+		p.timeouts--
+	}
+}
+
+// this function returns how many requests the peer may have outstanding, base shrunk by
+// the peer's recent timeouts
+func (p *Peer) requestLimit(base int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	limit := base >> min(p.timeouts, 8)
+	return max(limit, minRequestBacklog)
+}
+
+// RequestTimeouts returns how many times requests to the peer timed out, minus what it
+// made up for since
+func (p *Peer) RequestTimeouts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.timeouts
+}
@@ -0,0 +1,229 @@
+// This file saves and restores fast-resume data. It records which pieces were verified,
+// the size and modification time of every file when that was true, the transfer totals and
+// the tracker id, so a restarted download neither rechecks its data nor loses its stats.
+// Files that changed on disk since the data was saved have their pieces checked again. The
+// best peers of the session are saved too, and dialed first once the download restarts
+package bittorrentclient
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// resume data is saved this often while the download runs, and when it is paused or stopped
+	resumeSaveInterval = 5 * time.Minute
+	// resumeMaxPeers is how many peers are saved with the resume data, peers not seen for
+	// resumePeerMaxAge aren't restored
+	resumeMaxPeers   = 50
+	resumePeerMaxAge = 7 * 24 * time.Hour
+)
+
+type ResumeData struct {
+	InfoHash   string       `json:"info_hash"`
+	Have       Bitfield     `json:"have"`
+	Files      []ResumeFile `json:"files"`
+	Uploaded   int64        `json:"uploaded"`
+	Downloaded int64        `json:"downloaded"`
+	// SeedingTime is the seconds the download seeded, see seedLimits.go
+	SeedingTime int64     `json:"seeding_time,omitempty"`
+	TrackerID   string    `json:"tracker_id,omitempty"`
+	SavedAt     time.Time `json:"saved_at"`
+	// Partial lists the pieces that were in progress, see partialPieces.go
+	Partial []ResumePiece `json:"partial,omitempty"`
+	// Moved is set once the files were moved to the complete directory
+	Moved bool `json:"moved,omitempty"`
+	// Name and Paths hold the names of a renamed root and renamed files, see rename.go
+	Name  string           `json:"name,omitempty"`
+	Paths map[int][]string `json:"paths,omitempty"`
+	// Peers are the reachable peers that sent us the most, best first
+	Peers []ResumePeer `json:"peers,omitempty"`
+}
+
+// ResumePeer is a peer saved with the resume data
+type ResumePeer struct {
+	Addr       string `json:"addr"`
+	Downloaded int64  `json:"downloaded"`
+	// LastSeen is the unix time we were last connected to the peer
+	LastSeen int64 `json:"last_seen"`
+}
+
+// ResumeFile is the state of a file at the time its pieces were verified
+type ResumeFile struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+}
+
+// ResumeData returns a snapshot of the download's resume data. The blocks of pieces still
+// in progress are written to disk on the way, so the snapshot can refer to them
+func (d *Download) ResumeData() (*ResumeData, error) {
+	d.mu.Lock()
+	dir, moved := d.Dir, d.completeDir != "" && d.Dir == d.completeDir
+	layout := d.fileLayout()
+	suffix := d.partSuffix()
+	d.mu.Unlock()
+	paths, err := layout.filePaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	// written before the files are looked at below, so their times include the blocks
+	partial := d.savePartialPieces()
+	d.mu.Lock()
+	rd := &ResumeData{
+		InfoHash:   hex.EncodeToString(d.InfoHash[:]),
+		Have:       append(Bitfield(nil), d.have...),
+		Uploaded:   d.uploaded,
+		Downloaded: d.downloaded,
+		TrackerID:  d.trackerID,
+		SavedAt:    time.Now(),
+		Partial:    partial,
+		Moved:      moved,
+	}
+	rd.SeedingTime = int64(d.seedingTime(rd.SavedAt) / time.Second)
+	if layout != d.Torrent {
+		rd.Name = layout.Info.Name
+		rd.Paths = d.Torrent.renamedPaths(layout)
+	}
+	if d.announcer != nil {
+		rd.TrackerID = d.announcer.TrackerID()
+	}
+	live := make(map[string]int64, len(d.peers))
+	for p := range d.peers {
+		live[canonicalPeerAddr(p.Addr)] = p.Stats().Downloaded
+	}
+	d.mu.Unlock()
+	rd.Peers = d.peerStore.best(resumeMaxPeers, live)
+
+	rd.Files = make([]ResumeFile, len(paths))
+	for i, path := range paths {
+		info, err := statPartFile(path, suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rd.Files[i] = ResumeFile{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	}
+	return rd, nil
+}
+
+// SaveResume writes the resume data to path. The file is replaced atomically so a crash
+// halfway through leaves the previous data intact
+func (d *Download) SaveResume(path string) error {
+	rd, err := d.ResumeData()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rd)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// LoadResume restores resume data saved by SaveResume, it has to be called before Start.
+// Pieces of files whose size or modification time changed since are not trusted and will
+// be downloaded again
+func (d *Download) LoadResume(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rd ResumeData
+	err = json.Unmarshal(data, &rd)
+	if err != nil {
+		return fmt.Errorf("error decoding resume data: %v", err)
+	}
+	return d.applyResume(&rd)
+}
+
+func (d *Download) applyResume(rd *ResumeData) error {
+	if rd.InfoHash != hex.EncodeToString(d.InfoHash[:]) {
+		return fmt.Errorf("resume data is for torrent %s", rd.InfoHash)
+	}
+	numPieces := d.Torrent.NumPieces()
+	if len(rd.Have) != len(NewBitfield(numPieces)) {
+		return errors.New("resume data has the wrong number of pieces")
+	}
+	files := d.Torrent.files()
+	if len(rd.Files) != len(files) {
+		return errors.New("resume data has the wrong number of files")
+	}
+	d.mu.Lock()
+	dir := d.Dir
+	if rd.Moved && d.completeDir != "" {
+		dir = d.completeDir
+	}
+	suffix := d.partSuffix()
+	d.mu.Unlock()
+	layout, err := d.Torrent.restoreNames(rd.Name, rd.Paths)
+	if err != nil {
+		return err
+	}
+	paths, err := layout.filePaths(dir)
+	if err != nil {
+		return err
+	}
+
+	trusted := make([]bool, numPieces)
+	changed := make([]bool, numPieces)
+	for i := range trusted {
+		trusted[i] = rd.Have.HasPiece(i)
+	}
+	for i, f := range files {
+		first, last, ok := d.Torrent.filePieces(f)
+		if !ok {
+			continue
+		}
+		info, err := statPartFile(paths[i], suffix)
+		unchanged := err == nil && info.Size() == rd.Files[i].Size && info.ModTime().UnixNano() == rd.Files[i].ModTime
+		if unchanged {
+			continue
+		}
+		for index := first; index <= last; index++ {
+			trusted[index] = false
+			changed[index] = true
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		return errors.New("resume data has to be loaded before the download starts")
+	}
+	for index, ok := range trusted {
+		if ok {
+			d.have.SetPiece(index)
+			d.picker.Complete(index)
+		}
+	}
+	d.Dir = dir
+	if layout != d.Torrent {
+		d.layout = layout
+	}
+	d.uploaded = rd.Uploaded
+	d.downloaded = rd.Downloaded
+	d.seededFor = time.Duration(rd.SeedingTime) * time.Second
+	d.trackerID = rd.TrackerID
+	d.peerStore.restore(rd.Peers, resumePeerMaxAge)
+	d.partial = nil
+	for _, rp := range rd.Partial {
+		if rp.Index >= 0 && rp.Index < numPieces && !changed[rp.Index] && !trusted[rp.Index] {
+			d.partial = append(d.partial, rp)
+		}
+	}
+	return nil
+}
+
+// this function saves resume data to ResumePath if one is set
+func (d *Download) autosaveResume() error {
+	if d.ResumePath == "" {
+		return nil
+	}
+	return d.SaveResume(d.ResumePath)
+}
@@ -0,0 +1,98 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+func TestResumePartFiles(t *testing.T) {
+	const pieceLength = 16 << 10
+	pieces := make([]byte, 2*pieceLength)
+	rand.Read(pieces)
+	var hashes []byte
+	for i := 0; i < len(pieces); i += pieceLength {
+		hash := sha1.Sum(pieces[i : i+pieceLength])
+		hashes = append(hashes, hash[:]...)
+	}
+	metainfo, err := bencode.Encode(map[string]interface{}{
+		"announce": "http://127.0.0.1:1/announce",
+		"info": map[string]interface{}{
+			"name":         "partial",
+			"piece length": int64(pieceLength),
+			"pieces":       hashes,
+			"length":       int64(len(pieces)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := DecodeTorrent(bytes.NewReader(metainfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the first piece is on disk, so the file keeps its part name
+	dir := t.TempDir()
+	part := filepath.Join(dir, "partial.part")
+	err = os.WriteFile(part, append(pieces[:pieceLength:pieceLength], make([]byte, pieceLength)...), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDownload := func() *Download {
+		d, err := NewDownload(torrent, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.SetStorage(FilesystemStorage{PartSuffix: ".part"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Stop() })
+		return d
+	}
+	d := newDownload()
+	err = d.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := d.Have(); !have.HasPiece(0) || have.HasPiece(1) {
+		t.Fatalf("verified %08b, want the first piece", have)
+	}
+	resume := filepath.Join(t.TempDir(), "resume")
+	err = d.SaveResume(resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = newDownload()
+	err = d.LoadResume(resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := d.Have(); !have.HasPiece(0) || have.HasPiece(1) {
+		t.Errorf("resumed %08b, want the first piece", have)
+	}
+
+	// a part file changed since isn't trusted
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(part, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = newDownload()
+	err = d.LoadResume(resume)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := d.Have(); have.Count() != 0 {
+		t.Errorf("resumed %08b from a changed file, want nothing", have)
+	}
+}
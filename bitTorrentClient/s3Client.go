@@ -0,0 +1,311 @@
+// This file talks to S3 compatible object stores for S3Storage. Requests are signed with
+// AWS signature version 4, which AWS, MinIO, Ceph, R2 and the like all accept. Only what the
+// storage needs is implemented: listing keys, ranged GETs, PUT for small objects and
+// multipart uploads for large ones
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// every request to the object store has to finish within this time
+const s3RequestTimeout = 2 * time.Minute
+
+// s3Client signs and sends requests for one bucket
+type s3Client struct {
+	endpoint    *url.URL
+	bucket      string
+	region      string
+	accessKey   string
+	secretKey   string
+	virtualHost bool
+	http        *http.Client
+}
+
+// s3Error is an error response from the object store
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: status %d", e.Status)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// this function returns the URL of key, in the bucket's own host name or as the first
+// element of the path
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.virtualHost {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+	} else {
+		u.Path = "/" + c.bucket + "/" + key
+	}
+	u.RawPath = s3EncodePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+// this function sends a signed request and returns the response when its status is one of
+// the 2xx codes or 206, the body is closed otherwise
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.sign(req, body, time.Now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	e := &s3Error{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = xml.Unmarshal(data, e)
+	return nil, e
+}
+
+// this function adds the signature version 4 headers to req
+func (c *s3Client) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+
+	// host, range and the x-amz headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// this function encodes s the way signature version 4 wants it: everything but letters,
+// digits and -._~ is percent encoded, and so is / unless keepSlash is set
+func s3Encode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EncodePath(path string) string {
+	return s3Encode(path, true)
+}
+
+// this function returns the query sorted by key with keys and values encoded, which is both
+// what goes on the wire and what gets signed
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Encode(k, false)+"="+s3Encode(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// this function returns the size of every object whose key starts with prefix
+func (c *s3Client) list(ctx context.Context, prefix string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err := c.doXML(ctx, http.MethodGet, "", query, nil, nil, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Contents {
+			sizes[obj.Key] = obj.Size
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return sizes, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// this function sends a request and decodes the XML response into v
+func (c *s3Client) doXML(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, v any) error {
+	resp, err := c.do(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// this function reads len(b) bytes of key at off with a ranged GET, a short read means the
+// object ends early
+func (c *s3Client) getRange(ctx context.Context, key string, b []byte, off int64) (int, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+int64(len(b))-1)}}
+	resp, err := c.do(ctx, http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent && off > 0 {
+		return 0, errors.New("s3: range request not supported")
+	}
+	n, err := io.ReadFull(resp.Body, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *s3Client) put(ctx context.Context, key string, body []byte) error {
+	return c.doXML(ctx, http.MethodPut, key, nil, nil, body, nil)
+}
+
+func (c *s3Client) createMultipart(ctx context.Context, key string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := c.doXML(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("s3: no upload id in response")
+	}
+	return result.UploadID, nil
+}
+
+// this function uploads part number num of a multipart upload and returns its ETag
+func (c *s3Client) uploadPart(ctx context.Context, key, uploadID string, num int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadID}}
+	resp, err := c.do(ctx, http.MethodPut, key, query, nil, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (c *s3Client) completeMultipart(ctx context.Context, key, uploadID string, parts []s3Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	// a 200 can still carry an error when the store fails while assembling the object
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	err = c.doXML(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, &result)
+	if err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return &s3Error{Status: http.StatusOK, Code: result.Code, Message: result.Message}
+	}
+	return nil
+}
+
+func (c *s3Client) abortMultipart(ctx context.Context, key, uploadID string) error {
+	return c.doXML(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, nil)
+}
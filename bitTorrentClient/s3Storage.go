@@ -0,0 +1,334 @@
+// This file implements storage in an S3 compatible object store, so a seeder can serve
+// archives far larger than its own disk. Every file of the torrent is one object, keyed by
+// its path under the torrent's name like on disk. Objects can't be written in pieces, so
+// incomplete files are kept in a local write-back cache, a FileStorage under the download's
+// directory, and uploaded once the download reports them complete: in one PUT when small,
+// as a multipart upload otherwise. After the upload the cached copy is deleted and reads of
+// the file turn into ranged GETs. Objects already in the bucket with the right size count
+// as complete when the storage is opened, so a torrent uploaded once is seeded straight from
+// the bucket
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+)
+
+// multipart uploads use parts of this size, more for files that would need more than
+// s3MaxParts of them
+const (
+	s3PartSize = 8 * 1024 * 1024
+	s3MaxParts = 10000
+)
+
+// S3Storage keeps torrents in an S3 compatible bucket, see s3Storage.go
+type S3Storage struct {
+	// Endpoint is the store's base URL, e.g. https://s3.eu-west-1.amazonaws.com
+	Endpoint string
+	Bucket   string
+	// Region is signed into every request, stores that don't have regions usually want
+	// us-east-1
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is put in front of every key, e.g. "torrents/"
+	Prefix string
+	// VirtualHost addresses the bucket as bucket.endpoint instead of endpoint/bucket
+	VirtualHost bool
+	// CacheDir holds incomplete files until they are uploaded, the download's directory
+	// when empty
+	CacheDir string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+func (s S3Storage) OpenTorrent(t *Torrent, dir string) (TorrentStorage, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if s.Bucket == "" {
+		return nil, errors.New("s3 storage needs a bucket")
+	}
+	client := &s3Client{
+		endpoint:    endpoint,
+		bucket:      s.Bucket,
+		region:      s.Region,
+		accessKey:   s.AccessKey,
+		secretKey:   s.SecretKey,
+		virtualHost: s.VirtualHost,
+		http:        s.Client,
+	}
+	if client.region == "" {
+		client.region = "us-east-1"
+	}
+	if client.http == nil {
+		client.http = http.DefaultClient
+	}
+	cacheDir := s.CacheDir
+	if cacheDir == "" {
+		cacheDir = dir
+	}
+	cache, err := NewFileStorage(t, cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &s3Torrent{
+		client: client,
+		cache:  cache,
+		spans:  t.files(),
+		total:  t.TotalLength(),
+		plen:   t.Info.PieceLength,
+		keys:   make([]string, len(cache.files)),
+		remote: make([]bool, len(cache.files)),
+	}
+	// keys are the paths under the cache directory, which filePaths checked already
+	root := s.Prefix + t.Info.Name
+	for i, f := range st.spans {
+		if len(t.Info.Files) == 0 {
+			st.keys[i] = root
+		} else {
+			st.keys[i] = path.Join(append([]string{root}, f.Path...)...)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+	defer cancel()
+	sizes, err := client.list(ctx, root)
+	if err != nil {
+		cache.Close()
+		return nil, fmt.Errorf("listing bucket: %w", err)
+	}
+	for i, f := range st.spans {
+		if size, ok := sizes[st.keys[i]]; ok && size == f.Length && !f.Padding {
+			st.remote[i] = true
+			continue
+		}
+		// empty files have no pieces to complete them, they are put right away
+		if f.Length == 0 && !f.Padding {
+			err = client.put(ctx, st.keys[i], nil)
+			if err != nil {
+				cache.Close()
+				return nil, fmt.Errorf("uploading %s: %w", st.keys[i], err)
+			}
+			st.remote[i] = true
+		}
+	}
+	return st, nil
+}
+
+// s3Torrent is the storage of one torrent in a bucket
+type s3Torrent struct {
+	client *s3Client
+	cache  *FileStorage
+	spans  []fileSpan
+	total  int64
+	plen   int64
+	keys   []string
+
+	mu        sync.Mutex
+	remote    []bool
+	uploading map[int]bool
+	uploadErr error
+	closed    bool
+	uploads   sync.WaitGroup
+	// cacheMu is held for reading while the cache is used, so a finished upload doesn't
+	// delete a cached file under a read or write
+	cacheMu sync.RWMutex
+}
+
+// this function reports whether file i is in the bucket, and fails once the storage is closed
+func (s *s3Torrent) isRemote(i int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, errStorageClosed
+	}
+	return s.remote[i], nil
+}
+
+func (s *s3Torrent) ReadPieceAt(index int, b []byte, off int64) (int, error) {
+	read := 0
+	err := spanFiles(s.spans, s.total, b, int64(index)*s.plen+off, func(i int, chunk []byte, fileOff int64) error {
+		s.cacheMu.RLock()
+		remote, err := s.isRemote(i)
+		if err != nil || remote {
+			s.cacheMu.RUnlock()
+		}
+		if err != nil {
+			return err
+		}
+		var n int
+		if remote {
+			ctx, cancel := context.WithTimeout(context.Background(), s3RequestTimeout)
+			n, err = s.client.getRange(ctx, s.keys[i], chunk, fileOff)
+			cancel()
+		} else {
+			n, err = s.cache.ReadAt(chunk, s.spans[i].Offset+fileOff)
+			s.cacheMu.RUnlock()
+		}
+		read += n
+		if err == nil && n < len(chunk) {
+			err = io.EOF
+		}
+		return err
+	})
+	return read, err
+}
+
+// WritePieceAt writes to the cache. Data for files that are in the bucket already is
+// dropped, objects can't be changed and a verified piece holds the same bytes anyway
+func (s *s3Torrent) WritePieceAt(index int, b []byte, off int64) (int, error) {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	written := 0
+	err := spanFiles(s.spans, s.total, b, int64(index)*s.plen+off, func(i int, chunk []byte, fileOff int64) error {
+		remote, err := s.isRemote(i)
+		if err != nil {
+			return err
+		}
+		if remote {
+			written += len(chunk)
+			return nil
+		}
+		n, err := s.cache.WriteAt(chunk, s.spans[i].Offset+fileOff)
+		written += n
+		return err
+	})
+	return written, err
+}
+
+// finishFile starts uploading file i, which the download verified completely. The cached
+// copy keeps serving reads until the upload is done
+func (s *s3Torrent) finishFile(i int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.remote[i] || s.uploading[i] || s.spans[i].Padding {
+		return nil
+	}
+	if s.uploading == nil {
+		s.uploading = make(map[int]bool)
+	}
+	s.uploading[i] = true
+	s.uploads.Add(1)
+	go s.upload(i)
+	return nil
+}
+
+func (s *s3Torrent) upload(i int) {
+	defer s.uploads.Done()
+	// the cache has to hold everything before it is read back
+	err := s.cache.Flush()
+	if err == nil {
+		err = s.uploadFile(i)
+	}
+
+	if err != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.uploading, i)
+		// the next finishFile tries again, which the download does when it starts
+		s.uploadErr = errors.Join(s.uploadErr, fmt.Errorf("uploading %s: %w", s.keys[i], err))
+		return
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.mu.Lock()
+	delete(s.uploading, i)
+	s.remote[i] = true
+	s.mu.Unlock()
+	sf := s.cache.files[i]
+	s.cache.pool.closeFiles([]*storageFile{sf})
+	os.Remove(sf.final)
+}
+
+// this function uploads file i from the cache, in one request or as a multipart upload
+func (s *s3Torrent) uploadFile(i int) error {
+	key, length, offset := s.keys[i], s.spans[i].Length, s.spans[i].Offset
+	partSize := max(int64(s3PartSize), (length+s3MaxParts-1)/s3MaxParts)
+	read := func(off, n int64) ([]byte, error) {
+		buf := make([]byte, n)
+		got, err := s.cache.ReadAt(buf, offset+off)
+		if int64(got) < n {
+			return nil, fmt.Errorf("cached file is short: %w", err)
+		}
+		return buf, nil
+	}
+	ctx := context.Background()
+
+	if length <= partSize {
+		data, err := read(0, length)
+		if err != nil {
+			return err
+		}
+		pctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+		defer cancel()
+		return s.client.put(pctx, key, data)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+	uploadID, err := s.client.createMultipart(cctx, key)
+	cancel()
+	if err != nil {
+		return err
+	}
+	var parts []s3Part
+	for off := int64(0); off < length && err == nil; off += partSize {
+		var data []byte
+		data, err = read(off, min(partSize, length-off))
+		if err != nil {
+			break
+		}
+		pctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+		var etag string
+		etag, err = s.client.uploadPart(pctx, key, uploadID, len(parts)+1, data)
+		cancel()
+		parts = append(parts, s3Part{Number: len(parts) + 1, ETag: etag})
+	}
+	if err == nil {
+		cctx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+		err = s.client.completeMultipart(cctx, key, uploadID, parts)
+		cancel()
+	}
+	if err != nil {
+		// the parts would be kept, and paid for, until the upload is aborted
+		actx, cancel := context.WithTimeout(ctx, s3RequestTimeout)
+		_ = s.client.abortMultipart(actx, key, uploadID)
+		cancel()
+	}
+	return err
+}
+
+// Flush syncs the cache and reports uploads that failed since the last Flush. Uploads
+// still running aren't waited for, Close does that
+func (s *s3Torrent) Flush() error {
+	s.mu.Lock()
+	err := s.uploadErr
+	s.uploadErr = nil
+	s.mu.Unlock()
+	return errors.Join(s.cache.Flush(), err)
+}
+
+// Close waits for running uploads and closes the cache
+func (s *s3Torrent) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	s.uploads.Wait()
+	s.mu.Lock()
+	err := s.uploadErr
+	s.mu.Unlock()
+	return errors.Join(s.cache.Close(), err)
+}
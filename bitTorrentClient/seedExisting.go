@@ -0,0 +1,126 @@
+// This file starts seeding data that is already on disk, e.g. the files a torrent was
+// created from or a copy made by some other program, without downloading anything. The
+// download's Dir is taken to hold the files as they are, they are checked against the
+// metadata and seeding starts right away, they are never moved to the complete directory.
+// By default a file of the right size that wasn't modified after the torrent was created is
+// trusted as is and only the other files are hashed, which makes importing a large archive
+// take seconds. A torrent without a creation date gives nothing to go by and is hashed in
+// full, as with FullCheck
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrDataMismatch is returned by StartSeeding when the data on disk isn't complete. The
+// pieces that did check out are kept, Start downloads the rest
+var ErrDataMismatch = errors.New("data on disk doesn't match the torrent")
+
+// SeedOptions tunes how StartSeeding checks the data
+type SeedOptions struct {
+	// FullCheck hashes every piece instead of trusting files by their size and
+	// modification time
+	FullCheck bool
+}
+
+// StartSeeding checks the files in Dir against the torrent and starts seeding them. It has
+// to be called on a download that isn't running, in place of Start. Progress of the hashing
+// is reported with EventVerifyProgress events. When wanted pieces turn out to be missing
+// or damaged an ErrDataMismatch error is returned and the download stays stopped
+func (d *Download) StartSeeding(ctx context.Context, opts SeedOptions) error {
+	d.mu.Lock()
+	if d.cancel != nil || d.state == DownloadChecking {
+		d.mu.Unlock()
+		return errors.New("download is already running")
+	}
+	// the files stay where they are, and whatever resume data says is checked below anyway
+	d.completeDir = ""
+	d.resumed = true
+	err := d.openStorage()
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	storage, state := d.storage, d.state
+	layout, dir := d.fileLayout(), d.Dir
+	d.setState(DownloadChecking)
+	d.mu.Unlock()
+
+	var trusted Bitfield
+	if !opts.FullCheck {
+		trusted = d.Torrent.unmodifiedPieces(layout, dir)
+	}
+	var want func(int) bool
+	if trusted != nil {
+		want = func(index int) bool { return !trusted.HasPiece(index) }
+	}
+	have, err := d.checkPieces(ctx, storage, want)
+	d.readCache().invalidate(d.InfoHash)
+
+	d.mu.Lock()
+	if err != nil {
+		d.setState(state)
+		d.mu.Unlock()
+		return err
+	}
+	for index := 0; index < d.Torrent.NumPieces(); index++ {
+		if trusted.HasPiece(index) {
+			have.SetPiece(index)
+		}
+	}
+	d.have = have
+	d.picker.SetHave(have)
+	d.finishFiles(0, d.Torrent.NumPieces()-1)
+	if !d.complete() {
+		missing := d.Torrent.NumPieces() - have.Count()
+		d.setState(DownloadStopped)
+		d.mu.Unlock()
+		_ = d.autosaveResume()
+		return fmt.Errorf("%w: %d of %d pieces are missing or damaged", ErrDataMismatch, missing, d.Torrent.NumPieces())
+	}
+	d.setState(DownloadStopped)
+	d.mu.Unlock()
+	_ = d.autosaveResume()
+	return d.Start()
+}
+
+// this function returns the pieces lying entirely in files of layout under dir that have
+// the right size and weren't modified after the torrent was created. without a creation
+// date any file could have been written since, it returns nil and everything is hashed
+func (t *Torrent) unmodifiedPieces(layout *Torrent, dir string) Bitfield {
+	if t.CreationDate <= 0 {
+		return nil
+	}
+	paths, err := layout.filePaths(dir)
+	if err != nil {
+		return nil
+	}
+	numPieces := t.NumPieces()
+	// a piece is trusted unless a file overlapping it isn't
+	untrusted := make([]bool, numPieces)
+	for i, f := range t.files() {
+		first, last, ok := t.filePieces(f)
+		if !ok || f.Padding {
+			continue
+		}
+		info, err := os.Stat(paths[i])
+		unchanged := err == nil && info.Mode().IsRegular() && info.Size() == f.Length &&
+			info.ModTime().Unix() <= t.CreationDate
+		if unchanged {
+			continue
+		}
+		for index := first; index <= last; index++ {
+			untrusted[index] = true
+		}
+	}
+	trusted := NewBitfield(numPieces)
+	for index, bad := range untrusted {
+		if !bad {
+			trusted.SetPiece(index)
+		}
+	}
+	return trusted
+}
@@ -0,0 +1,33 @@
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartSeedingWithoutCreationDate(t *testing.T) {
+	// the test torrent has no creation date, a file of the right size proves nothing
+	torrent := testTorrent(t, "http://127.0.0.1:1/announce")
+	dir := t.TempDir()
+	data := make([]byte, 16<<10)
+	data[0] = 1
+	err := os.WriteFile(filepath.Join(dir, "test"), data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDownload(torrent, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	err = d.StartSeeding(context.Background(), SeedOptions{})
+	if !errors.Is(err, ErrDataMismatch) {
+		t.Errorf("seeding a damaged file: %v, want %v", err, ErrDataMismatch)
+	}
+	if n := d.Have().Count(); n != 0 {
+		t.Errorf("%d pieces of a damaged file trusted", n)
+	}
+}
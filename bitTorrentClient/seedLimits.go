@@ -0,0 +1,112 @@
+// This file stops seeding automatically. A finished download is stopped once it uploaded a
+// set multiple of its size, once it seeded for a set time, or once nobody downloaded from it
+// for a while, whichever comes first. The tracker is told we left and an
+// EventSeedLimitReached event is emitted
+package bittorrentclient
+
+import "time"
+
+// SetRatioLimit makes the download stop seeding once it uploaded ratio times its size, zero
+// seeds forever. Raise the limit before starting a download that reached it, or it stops again
+func (d *Download) SetRatioLimit(ratio float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ratioLimit = max(ratio, 0)
+}
+
+func (d *Download) RatioLimit() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ratioLimit
+}
+
+// SetSeedTimeLimit makes the download stop after seeding for limit in total, zero seeds forever
+func (d *Download) SetSeedTimeLimit(limit time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seedTimeLimit = max(limit, 0)
+}
+
+func (d *Download) SeedTimeLimit() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seedTimeLimit
+}
+
+// SetIdleLimit makes the download stop seeding once it uploaded nothing for limit, zero
+// seeds forever
+func (d *Download) SetIdleLimit(limit time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.idleLimit = max(limit, 0)
+}
+
+func (d *Download) IdleLimit() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.idleLimit
+}
+
+// SeedingTime returns how long the download has been seeding, over all the times it was started
+func (d *Download) SeedingTime() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seedingTime(time.Now())
+}
+
+// this function returns the total seeding time, the caller must hold d.mu
+func (d *Download) seedingTime(now time.Time) time.Duration {
+	if d.state != DownloadSeeding {
+		return d.seededFor
+	}
+	return d.seededFor + now.Sub(d.seedingSince)
+}
+
+// this function keeps the seeding clock running while the download seeds, and starts the
+// idle clock when it begins to. the caller must hold d.mu and be about to switch to state
+func (d *Download) trackSeeding(state DownloadState) {
+	now := time.Now()
+	switch {
+	case state == DownloadSeeding:
+		d.seedingSince = now
+		d.lastUpload = now
+	case d.state == DownloadSeeding:
+		d.seededFor += now.Sub(d.seedingSince)
+	}
+}
+
+// Ratio returns how many times the download's size it has uploaded
+func (d *Download) Ratio() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ratio()
+}
+
+// this function returns the share ratio, the caller must hold d.mu
+func (d *Download) ratio() float64 {
+	size := d.Torrent.TotalLength()
+	if size == 0 {
+		return 0
+	}
+	return float64(d.uploaded) / float64(size)
+}
+
+// this function stops the download when it is seeding and reached its limit. Stop waits
+// for the goroutine calling us, so it runs on its own
+func (d *Download) checkSeedLimits() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state != DownloadSeeding || d.seedLimitHit {
+		return
+	}
+	now := time.Now()
+	ratioHit := d.ratioLimit > 0 && d.ratio() >= d.ratioLimit
+	timeHit := d.seedTimeLimit > 0 && d.seedingTime(now) >= d.seedTimeLimit
+	idleHit := d.idleLimit > 0 && now.Sub(d.lastUpload) >= d.idleLimit
+	if !ratioHit && !timeHit && !idleHit {
+		return
+	}
+	d.seedLimitHit = true
+	d.emit(Event{Type: EventSeedLimitReached})
+	go d.Stop()
+}
@@ -0,0 +1,107 @@
+// This file implements the session wide upload and download throttles. Every peer of every
+// download passes its traffic through the same pair of token buckets, so the client as a
+// whole stays under the limits no matter how many torrents and peers are active. The limiters
+// sit on the connection, so message headers, keep-alives, haves and handshakes count as well
+// as piece data, and an estimate of the TCP/IP headers is charged on top
+package bittorrentclient
+
+import "sync"
+
+const (
+	// a full sized TCP segment over ethernet, and the IPv4 and TCP headers around it
+	tcpSegmentPayload = 1460
+	tcpIPHeaderBytes  = 40
+)
+
+// this function estimates how many bytes n bytes of payload take up on the wire
+func wireBytes(n int) int {
+	segments := (n + tcpSegmentPayload - 1) / tcpSegmentPayload
+	return n + segments*tcpIPHeaderBytes
+}
+
+// SessionLimiter holds the upload and download limits shared by all downloads. A limit of
+// zero means unlimited, the limits can be changed at any time. Besides the usual pair it
+// holds an alternative one that can be swapped in, see altSpeed.go
+type SessionLimiter struct {
+	upload   *RateLimiter
+	download *RateLimiter
+
+	mu sync.Mutex
+	// uploadLimit and downloadLimit are the usual limits, altUpload and altDownload the
+	// alternative ones. alt tells which pair the limiters run at
+	uploadLimit, downloadLimit int64
+	altUpload, altDownload     int64
+	alt                        bool
+}
+
+func NewSessionLimiter(uploadBytesPerSec, downloadBytesPerSec int64) *SessionLimiter {
+	s := &SessionLimiter{
+		upload:        NewRateLimiter(uploadBytesPerSec),
+		download:      NewRateLimiter(downloadBytesPerSec),
+		uploadLimit:   uploadBytesPerSec,
+		downloadLimit: downloadBytesPerSec,
+	}
+	s.upload.overhead = true
+	s.download.overhead = true
+	return s
+}
+
+// SetUploadLimit caps how fast the whole session sends in bytes per second, zero removes the
+// cap. While the alternative limits are on it takes effect when they are turned off
+func (s *SessionLimiter) SetUploadLimit(bytesPerSec int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadLimit = bytesPerSec
+	s.apply()
+}
+
+// SetDownloadLimit caps how fast the whole session receives in bytes per second, zero
+// removes the cap. While the alternative limits are on it takes effect when they are turned off
+func (s *SessionLimiter) SetDownloadLimit(bytesPerSec int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downloadLimit = bytesPerSec
+	s.apply()
+}
+
+// UploadLimit returns the upload limit in effect, the alternative one while it is on
+func (s *SessionLimiter) UploadLimit() int64 {
+	return s.upload.Rate()
+}
+
+// DownloadLimit returns the download limit in effect, the alternative one while it is on
+func (s *SessionLimiter) DownloadLimit() int64 {
+	return s.download.Rate()
+}
+
+// Limits returns the usual upload and download limits in bytes per second, whether or not
+// the alternative ones are on
+func (s *SessionLimiter) Limits() (upload, download int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploadLimit, s.downloadLimit
+}
+
+// this function sets the limiters to the pair of limits in effect, under s.mu
+func (s *SessionLimiter) apply() {
+	if s.alt {
+		s.upload.SetRate(s.altUpload)
+		s.download.SetRate(s.altDownload)
+		return
+	}
+	s.upload.SetRate(s.uploadLimit)
+	s.download.SetRate(s.downloadLimit)
+}
+
+// this function puts p's connection under the session limits
+func (s *SessionLimiter) attach(p *Peer) {
+	attachLimiters(p, s.download, s.upload)
+}
+
+// this function adds a download and an upload limiter to p's connection. the handshake went
+// over the wire before the peer existed, so it is charged here
+func attachLimiters(p *Peer, download, upload *RateLimiter) {
+	p.conn.addLimiters(download, upload)
+	download.consume(handshakeLen)
+	upload.consume(handshakeLen)
+}
@@ -0,0 +1,317 @@
+// This file keeps a session across restarts. With a state directory the client writes every
+// torrent it holds there: the .torrent file under torrents/, its resume data under resume/
+// and, in session.json, the queue order of the torrents with what was set on each of them,
+// the directories, labels, limits, file and queue priorities and whether it was paused.
+// Magnet links still fetching their metadata are kept as links. session.json is rewritten
+// when torrents are added, removed or moved in the queue, every stateSaveInterval when
+// something changed and on Close, and a new client with the same directory adds everything
+// back the way it was
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	sessionStateFile = "session.json"
+	// the session is saved this often when something about its torrents changed
+	stateSaveInterval = 30 * time.Second
+)
+
+type sessionState struct {
+	Torrents []torrentState `json:"torrents"`
+}
+
+// torrentState is what session.json holds for a torrent, the rest is in its resume data
+type torrentState struct {
+	InfoHash string `json:"info_hash"`
+	// Magnet is set for links whose metadata hadn't arrived, which are fetched again
+	Magnet      string   `json:"magnet,omitempty"`
+	Dir         string   `json:"dir"`
+	CompleteDir string   `json:"complete_dir,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Paused      bool     `json:"paused,omitempty"`
+	// the limits are in bytes per second and seconds
+	UploadLimit    int64          `json:"upload_limit,omitempty"`
+	DownloadLimit  int64          `json:"download_limit,omitempty"`
+	RatioLimit     float64        `json:"ratio_limit,omitempty"`
+	SeedTimeLimit  int64          `json:"seed_time_limit,omitempty"`
+	IdleLimit      int64          `json:"idle_limit,omitempty"`
+	FilePriorities []FilePriority `json:"file_priorities,omitempty"`
+	QueuePriority  QueuePriority  `json:"queue_priority,omitempty"`
+}
+
+func (c *Client) torrentFilePath(infoHash [20]byte) string {
+	return filepath.Join(c.stateDir, "torrents", hex.EncodeToString(infoHash[:])+".torrent")
+}
+
+func (c *Client) resumeFilePath(infoHash [20]byte) string {
+	return filepath.Join(c.stateDir, "resume", hex.EncodeToString(infoHash[:])+".json")
+}
+
+// this function writes the .torrent file of a download added to the session and has its
+// resume data kept in the state directory, unless it has a place of its own
+func (c *Client) keepTorrent(d *Download) {
+	if c.stateDir == "" {
+		return
+	}
+	if d.ResumePath == "" {
+		d.ResumePath = c.resumeFilePath(d.InfoHash)
+	}
+	path := c.torrentFilePath(d.InfoHash)
+	if _, err := os.Stat(path); err != nil {
+		data, err := d.Torrent.Encode()
+		if err == nil {
+			err = writeFileAtomic(path, data)
+		}
+		if err != nil {
+			c.logger.Warn("saving torrent to the state directory failed", "infohash", fmt.Sprintf("%x", d.InfoHash), "err", err)
+			return
+		}
+	}
+	c.saveStateNow()
+}
+
+// this function deletes what the state directory holds of a removed download
+func (c *Client) forgetTorrent(d *Download) {
+	if c.stateDir == "" {
+		return
+	}
+	for _, path := range []string{c.torrentFilePath(d.InfoHash), c.resumeFilePath(d.InfoHash)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			c.logger.Warn("removing torrent from the state directory failed", "file", path, "err", err)
+		}
+	}
+	c.saveStateNow()
+}
+
+// this function returns the session as it is now
+func (c *Client) snapshotState() sessionState {
+	c.mu.Lock()
+	downloads := make([]*Download, 0, len(c.order))
+	for _, infoHash := range c.order {
+		downloads = append(downloads, c.torrents[infoHash])
+	}
+	var magnets []torrentState
+	for infoHash, pending := range c.fetching {
+		magnets = append(magnets, torrentState{
+			InfoHash: hex.EncodeToString(infoHash[:]),
+			Magnet:   pending.uri,
+			Dir:      c.saveDir(pending.cfg),
+			Labels:   normalizeLabels(pending.cfg.labels),
+		})
+	}
+	c.mu.Unlock()
+	// in a steady order, so an unchanged session isn't written again
+	sort.Slice(magnets, func(i, j int) bool { return magnets[i].InfoHash < magnets[j].InfoHash })
+
+	state := sessionState{Torrents: make([]torrentState, 0, len(downloads)+len(magnets))}
+	for _, d := range downloads {
+		state.Torrents = append(state.Torrents, d.torrentState())
+	}
+	state.Torrents = append(state.Torrents, magnets...)
+	return state
+}
+
+// this function returns the download's entry in session.json
+func (d *Download) torrentState() torrentState {
+	ts := torrentState{
+		InfoHash:      hex.EncodeToString(d.InfoHash[:]),
+		Labels:        d.Labels(),
+		Paused:        d.State() == DownloadPaused,
+		UploadLimit:   d.UploadLimit(),
+		DownloadLimit: d.DownloadLimit(),
+		RatioLimit:    d.RatioLimit(),
+		SeedTimeLimit: int64(d.SeedTimeLimit() / time.Second),
+		IdleLimit:     int64(d.IdleLimit() / time.Second),
+		QueuePriority: d.QueuePriority(),
+	}
+	d.mu.Lock()
+	ts.Dir, ts.CompleteDir = d.Dir, d.completeDir
+	d.mu.Unlock()
+	prios := d.FilePriorities()
+	for _, prio := range prios {
+		if prio != FileNormal {
+			ts.FilePriorities = prios
+			break
+		}
+	}
+	return ts
+}
+
+// this function writes session.json if the session changed since it was last written.
+// the client keeps running when it fails, the error is logged and returned
+func (c *Client) saveStateNow() error {
+	if c.stateDir == "" {
+		return nil
+	}
+	c.mu.Lock()
+	skip := c.closed || c.restoring
+	c.mu.Unlock()
+	if skip {
+		// once closed the downloads are stopped, saving would lose which of them were
+		// running. while restoring, the session on disk is more complete than the client
+		return nil
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	data, err := json.MarshalIndent(c.snapshotState(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, c.lastState) {
+		return nil
+	}
+	err = writeFileAtomic(filepath.Join(c.stateDir, sessionStateFile), data)
+	if err != nil {
+		c.logger.Warn("saving the session failed", "err", err)
+		return fmt.Errorf("saving the session: %w", err)
+	}
+	c.lastState = data
+	return nil
+}
+
+// this function saves the session every stateSaveInterval until the client is closed,
+// catching what changed on the downloads themselves, like pausing or new limits
+func (c *Client) stateLoop() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+			c.saveStateNow()
+		}
+	}
+}
+
+// this function adds back the torrents of the session saved in the state directory, in
+// their order. a torrent that can't be restored is logged and left out, a session.json
+// that can't be read stops the client from starting over it
+func (c *Client) restoreState() error {
+	if c.stateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(c.stateDir, sessionStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("restoring the session: %w", err)
+	}
+	var state sessionState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("restoring the session: %s: %w", sessionStateFile, err)
+	}
+	c.lastState = data
+	c.mu.Lock()
+	c.restoring = true
+	c.mu.Unlock()
+	for _, ts := range state.Torrents {
+		err := c.restoreTorrent(ts)
+		if err != nil {
+			c.logger.Warn("restoring torrent failed", "infohash", ts.InfoHash, "err", err)
+		}
+	}
+	c.mu.Lock()
+	c.restoring = false
+	c.mu.Unlock()
+	c.logger.Info("session restored", "torrents", len(state.Torrents))
+	return nil
+}
+
+// this function adds back one torrent of the saved session
+func (c *Client) restoreTorrent(ts torrentState) error {
+	opts := []AddOption{WithSaveDir(ts.Dir), WithLabels(ts.Labels...)}
+	if ts.Magnet != "" {
+		// Close gives up on it like on any link being fetched
+		go c.AddMagnet(context.Background(), ts.Magnet, opts...)
+		return nil
+	}
+	raw, err := hex.DecodeString(ts.InfoHash)
+	if err != nil || len(raw) != 20 {
+		return fmt.Errorf("bad infohash %q", ts.InfoHash)
+	}
+	infoHash := [20]byte(raw)
+	t, err := LoadTorrent(c.torrentFilePath(infoHash))
+	if err != nil {
+		return err
+	}
+	if t.InfoHash != infoHash {
+		return errors.New("torrent file is of another torrent")
+	}
+	d, err := c.newDownload(t, newAddConfig(opts))
+	if err != nil {
+		return err
+	}
+	d.ResumePath = c.resumeFilePath(infoHash)
+	d.applyTorrentState(ts)
+	if ts.Paused {
+		d.park(DownloadPaused)
+	}
+	return c.add(d, !ts.Paused)
+}
+
+// this function sets what session.json holds on the download, but for its directory and
+// labels, which it was made with, and whether it was paused
+func (d *Download) applyTorrentState(ts torrentState) {
+	d.SetUploadLimit(ts.UploadLimit)
+	d.SetDownloadLimit(ts.DownloadLimit)
+	d.SetRatioLimit(ts.RatioLimit)
+	d.SetSeedTimeLimit(time.Duration(ts.SeedTimeLimit) * time.Second)
+	d.SetIdleLimit(time.Duration(ts.IdleLimit) * time.Second)
+	if ts.CompleteDir != "" {
+		d.SetCompleteDir(ts.CompleteDir)
+	}
+	for i, prio := range ts.FilePriorities {
+		d.SetFilePriority(i, prio)
+	}
+	d.SetQueuePriority(ts.QueuePriority)
+}
+
+// this function loads the resume data of a download that isn't started, restored paused or
+// waiting in the queue, so it shows its progress before it starts, and puts it in state
+func (d *Download) park(state DownloadState) {
+	d.mu.Lock()
+	load := !d.resumed && d.ResumePath != ""
+	d.resumed = true
+	path := d.ResumePath
+	d.mu.Unlock()
+	var err error
+	if load {
+		err = d.LoadResume(path)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		d.emit(Event{Type: EventResumeFailed, Err: err})
+	}
+	d.setState(state)
+}
+
+// writeFileAtomic replaces the file at path with data, through a temporary file so a crash
+// halfway through leaves the previous one intact
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,69 @@
+// This file detects snubbing. A peer snubs us when it has us unchoked but stops delivering
+// the blocks we requested from it. Snubbed peers are only asked for blocks during endgame,
+// and other peers are preferred when handing out the optimistic unchoke
+package bittorrentclient
+
+import "time"
+
+const defaultSnubTimeout = 60 * time.Second
+
+// CheckSnubbed marks the peer as snubbed when it has us unchoked and requests outstanding,
+// but hasn't delivered a single block for longer than timeout. It reports the current state
+func (p *Peer) CheckSnubbed(timeout time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peerChoking || len(p.pending) == 0 {
+		return p.snubbed
+	}
+
+	// the clock starts at the last delivered block, or at the oldest outstanding request if
+	// we sent that one after the last block arrived
+	since := p.lastBlock
+	oldest := time.Time{}
+	for _, sentAt := range p.pending {
+		if oldest.IsZero() || sentAt.Before(oldest) {
+			oldest = sentAt
+		}
+	}
+	if oldest.After(since) {
+		since = oldest
+	}
+	if time.Since(since) > timeout {
+		p.snubbed = true
+	}
+	return p.snubbed
+}
+
+func (p *Peer) Snubbed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.snubbed
+}
+
+// CanRequest reports whether we should send block requests to the peer. Snubbed peers are
+// skipped unless we are in endgame, where every peer that has the block is worth asking
+func (p *Peer) CanRequest(endgame bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.peerChoking && (!p.snubbed || endgame)
+}
+
+// this function returns the peers eligible for the optimistic unchoke: choked peers that
+// want data from us. peers snubbing us are only considered when there is nobody else
+func optimisticCandidates(peers []*Peer) []*Peer {
+	var candidates, snubbed []*Peer
+	for _, p := range peers {
+		if !p.AmChoking() || !p.PeerInterested() {
+			continue
+		}
+		if p.Snubbed() {
+			snubbed = append(snubbed, p)
+		} else {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return snubbed
+	}
+	return candidates
+}
@@ -0,0 +1,210 @@
+// This file implements a SOCKS5 client (RFC 1928, with username/password auth from RFC 1929)
+// so peer connections can be routed through a proxy. Only CONNECT is supported, which means
+// peers reached through the proxy are TCP only and nobody can connect to us through it
+package bittorrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+const (
+	socksVersion        = 5
+	socksAuthNone       = 0x00
+	socksAuthPassword   = 0x02
+	socksAuthNoAccept   = 0xff
+	socksCmdConnect     = 0x01
+	socksAtypIPv4       = 0x01
+	socksAtypDomain     = 0x03
+	socksAtypIPv6       = 0x04
+	socksPasswordStatOK = 0x00
+)
+
+var ErrProxyAuth = errors.New("socks5: proxy rejected our credentials")
+
+// SOCKS5Proxy describes a proxy peer connections are tunneled through. Username and
+// Password are only sent when the proxy asks for them
+type SOCKS5Proxy struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+var socksReplies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// DialContext connects to addr through the proxy, the TCP connection to the proxy itself
+// is opened with dial
+func (s *SOCKS5Proxy) DialContext(ctx context.Context, dial func(ctx context.Context, addr string) (net.Conn, error), addr string) (net.Conn, error) {
+	conn, err := dial(ctx, s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: connecting to proxy: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(defaultDialTimeout))
+	}
+	// unblock the proxy handshake if ctx is cancelled halfway through
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	err = s.connect(conn, addr)
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (s *SOCKS5Proxy) connect(conn net.Conn, addr string) error {
+	method := byte(socksAuthNone)
+	if s.Username != "" || s.Password != "" {
+		method = socksAuthPassword
+	}
+	methods := []byte{socksVersion, 1, method}
+	if method == socksAuthPassword {
+		methods = []byte{socksVersion, 2, socksAuthNone, socksAuthPassword}
+	}
+	_, err := conn.Write(methods)
+	if err != nil {
+		return err
+	}
+	var choice [2]byte
+	_, err = io.ReadFull(conn, choice[:])
+	if err != nil {
+		return err
+	}
+	if choice[0] != socksVersion {
+		return fmt.Errorf("socks5: proxy answered with version %d", choice[0])
+	}
+	switch choice[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		err = s.authenticate(conn)
+		if err != nil {
+			return err
+		}
+	case socksAuthNoAccept:
+		return errors.New("socks5: proxy accepts none of our auth methods")
+	default:
+		return fmt.Errorf("socks5: proxy picked unknown auth method %d", choice[1])
+	}
+
+	req, err := socksConnectRequest(addr)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+	return readSocksReply(conn)
+}
+
+// this function performs username/password auth, RFC 1929
+func (s *SOCKS5Proxy) authenticate(conn net.Conn) error {
+	if len(s.Username) > 255 || len(s.Password) > 255 {
+		return errors.New("socks5: username and password are limited to 255 bytes")
+	}
+	buf := []byte{1, byte(len(s.Username))}
+	buf = append(buf, s.Username...)
+	buf = append(buf, byte(len(s.Password)))
+	buf = append(buf, s.Password...)
+	_, err := conn.Write(buf)
+	if err != nil {
+		return err
+	}
+	var status [2]byte
+	_, err = io.ReadFull(conn, status[:])
+	if err != nil {
+		return err
+	}
+	if status[1] != socksPasswordStatOK {
+		return ErrProxyAuth
+	}
+	return nil
+}
+
+func socksConnectRequest(addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: bad port in %q", addr)
+	}
+	req := []byte{socksVersion, socksCmdConnect, 0}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			req = append(req, socksAtypIPv4)
+		} else {
+			req = append(req, socksAtypIPv6)
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		// let the proxy resolve names so lookups don't leak around it either
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks5: host name too long: %q", host)
+		}
+		req = append(req, socksAtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	return binary.BigEndian.AppendUint16(req, uint16(port)), nil
+}
+
+// this function reads the reply to a CONNECT, the bound address in it is of no use to us
+func readSocksReply(conn net.Conn) error {
+	var head [4]byte
+	_, err := io.ReadFull(conn, head[:])
+	if err != nil {
+		return err
+	}
+	if head[0] != socksVersion {
+		return fmt.Errorf("socks5: proxy answered with version %d", head[0])
+	}
+	if head[1] != 0 {
+		reason, ok := socksReplies[head[1]]
+		if !ok {
+			reason = fmt.Sprintf("error %d", head[1])
+		}
+		return fmt.Errorf("socks5: proxy could not connect: %s", reason)
+	}
+	var skip int
+	switch head[3] {
+	case socksAtypIPv4:
+		skip = 4
+	case socksAtypIPv6:
+		skip = 16
+	case socksAtypDomain:
+		var n [1]byte
+		_, err = io.ReadFull(conn, n[:])
+		if err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("socks5: unknown address type %d in reply", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
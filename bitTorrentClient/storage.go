@@ -0,0 +1,141 @@
+// This file defines the storage interfaces. A download only ever talks to a TorrentStorage,
+// which it gets from a Storage backend when it starts, so the data can live somewhere else
+// than plain files on disk: in memory, in a memory mapped file or on a remote store. The
+// filesystem backend is the default, a memory mapped and a memory backend are included too
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var errStorageClosed = errors.New("storage is closed")
+
+// Storage is a backend that torrents' data can be kept in
+type Storage interface {
+	// OpenTorrent opens the storage for t. dir is the download's directory, backends that
+	// don't keep files may ignore it
+	OpenTorrent(t *Torrent, dir string) (TorrentStorage, error)
+}
+
+// TorrentStorage holds the pieces of one torrent. Reads and writes are addressed within a
+// piece and may come from several goroutines at once. A read of data that was never
+// written is a short read
+type TorrentStorage interface {
+	ReadPieceAt(index int, b []byte, off int64) (int, error)
+	WritePieceAt(index int, b []byte, off int64) (int, error)
+	// Flush makes everything written so far durable, as far as the backend can
+	Flush() error
+	Close() error
+}
+
+// FilesystemStorage keeps torrents in their files on disk, see FileStorage
+type FilesystemStorage struct {
+	// Allocation decides how the space for each file is reserved, sparse by default
+	Allocation AllocationMode
+	// Pool bounds the files kept open, nil shares one pool of DefaultMaxOpenFiles between
+	// all storages
+	Pool *FilePool
+	// PartSuffix, e.g. ".part", is added to the names of files until they are complete, see
+	// partFiles.go. Empty saves every file under its final name from the start
+	PartSuffix string
+}
+
+func (fs FilesystemStorage) OpenTorrent(t *Torrent, dir string) (TorrentStorage, error) {
+	s, err := NewFileStorage(t, dir)
+	if err != nil {
+		return nil, err
+	}
+	s.allocation = fs.Allocation
+	if fs.Pool != nil {
+		s.pool = fs.Pool
+	}
+	if fs.PartSuffix != "" {
+		err = s.usePartSuffix(fs.PartSuffix)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// MmapStorage keeps torrents in their files on disk like FilesystemStorage, but reads and
+// writes them through memory mappings. It suits large torrents with lots of random writes,
+// see mmapStorage_unix.go
+type MmapStorage struct{}
+
+// MemoryStorage keeps torrents in memory, their data is gone once the storage is closed
+type MemoryStorage struct{}
+
+func (MemoryStorage) OpenTorrent(t *Torrent, dir string) (TorrentStorage, error) {
+	pieces := make([][]byte, t.NumPieces())
+	return &memoryTorrent{t: t, pieces: pieces}, nil
+}
+
+type memoryTorrent struct {
+	t  *Torrent
+	mu sync.RWMutex
+	// pieces are allocated on their first write, like a sparse file the parts of a piece
+	// that weren't written read as zeros
+	pieces [][]byte
+}
+
+func (m *memoryTorrent) ReadPieceAt(index int, b []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.check(index, len(b), off); err != nil {
+		return 0, err
+	}
+	if m.pieces[index] == nil {
+		return 0, io.EOF
+	}
+	return copy(b, m.pieces[index][off:]), nil
+}
+
+func (m *memoryTorrent) WritePieceAt(index int, b []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(index, len(b), off); err != nil {
+		return 0, err
+	}
+	if m.pieces[index] == nil {
+		m.pieces[index] = make([]byte, m.t.PieceSize(index))
+	}
+	return copy(m.pieces[index][off:], b), nil
+}
+
+// this function checks that n bytes at off fall within piece index
+func (m *memoryTorrent) check(index, n int, off int64) error {
+	if index < 0 || index >= len(m.pieces) {
+		return fmt.Errorf("piece index %d out of range", index)
+	}
+	if off < 0 || off+int64(n) > int64(m.t.PieceSize(index)) {
+		return fmt.Errorf("range %d+%d is outside piece %d", off, n, index)
+	}
+	return nil
+}
+
+func (m *memoryTorrent) Flush() error {
+	return nil
+}
+
+func (m *memoryTorrent) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.pieces)
+	return nil
+}
+
+// SetStorage changes the backend the download keeps its data in, the download has to be
+// stopped. The default is FilesystemStorage
+func (d *Download) SetStorage(s Storage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.storage != nil {
+		return errors.New("storage can't be changed while it is open")
+	}
+	d.backend = s
+	return nil
+}
@@ -0,0 +1,169 @@
+// This file decides what a failed write does to a download. Errors are sorted into a few
+// kinds: the disk being full, I/O errors of a failing or disconnected disk, permission
+// errors and everything else. The download's StorageErrorPolicy maps each kind to an
+// action: pause the download so it can be resumed once the problem is fixed, write the
+// piece again after a growing delay, since a flaky network share often recovers, or put the
+// download into the error state. Either way the piece stays out of the pieces we have until
+// it was actually written, and every failed write is reported with an EventStorageError
+package bittorrentclient
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+type StorageErrorKind int
+
+const (
+	StorageErrorOther StorageErrorKind = iota
+	StorageErrorNoSpace
+	StorageErrorIO
+	StorageErrorPermission
+)
+
+func (k StorageErrorKind) String() string {
+	switch k {
+	case StorageErrorNoSpace:
+		return "no space"
+	case StorageErrorIO:
+		return "i/o error"
+	case StorageErrorPermission:
+		return "permission denied"
+	default:
+		return "other"
+	}
+}
+
+// ClassifyStorageError returns the kind of a storage error
+func ClassifyStorageError(err error) StorageErrorKind {
+	switch {
+	case isDiskFull(err) || errors.Is(err, syscall.EDQUOT):
+		return StorageErrorNoSpace
+	case errors.Is(err, syscall.EIO):
+		return StorageErrorIO
+	case errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS):
+		return StorageErrorPermission
+	default:
+		return StorageErrorOther
+	}
+}
+
+type StorageErrorAction int
+
+const (
+	// StorageErrorFail puts the download into the error state
+	StorageErrorFail StorageErrorAction = iota
+	// StorageErrorPause pauses the download, Resume carries on
+	StorageErrorPause
+	// StorageErrorRetry writes the piece again after a delay, and fails the download once
+	// MaxRetries writes failed
+	StorageErrorRetry
+)
+
+// StorageErrorPolicy maps each kind of storage error to what the download does about it
+type StorageErrorPolicy struct {
+	NoSpace    StorageErrorAction
+	IO         StorageErrorAction
+	Permission StorageErrorAction
+	Other      StorageErrorAction
+	// MaxRetries is how often a piece is written again before the download fails
+	MaxRetries int
+	// RetryDelay is the wait before the first retry, it doubles with every further one
+	RetryDelay time.Duration
+}
+
+// DefaultStorageErrorPolicy pauses when the disk is full, retries I/O errors a few times
+// and fails on anything else
+func DefaultStorageErrorPolicy() StorageErrorPolicy {
+	return StorageErrorPolicy{
+		NoSpace:    StorageErrorPause,
+		IO:         StorageErrorRetry,
+		Permission: StorageErrorFail,
+		Other:      StorageErrorFail,
+		MaxRetries: 3,
+		RetryDelay: time.Second,
+	}
+}
+
+func (p StorageErrorPolicy) action(kind StorageErrorKind) StorageErrorAction {
+	switch kind {
+	case StorageErrorNoSpace:
+		return p.NoSpace
+	case StorageErrorIO:
+		return p.IO
+	case StorageErrorPermission:
+		return p.Permission
+	default:
+		return p.Other
+	}
+}
+
+func (d *Download) SetStorageErrorPolicy(policy StorageErrorPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.storagePolicy = policy
+}
+
+func (d *Download) StorageErrorPolicy() StorageErrorPolicy {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.storagePolicy
+}
+
+// this function applies the policy to a failed write of piece, -1 for a failed sync.
+// attempt counts the writes that failed so far, retry writes again and is nil when that
+// isn't possible. it reports whether the retry was scheduled, otherwise the caller gives
+// the piece back to the picker
+func (d *Download) storageFailed(err error, piece, attempt int, retry func()) bool {
+	kind := ClassifyStorageError(err)
+	d.mu.Lock()
+	policy, ctx, running := d.storagePolicy, d.runCtx, d.cancel != nil
+	d.mu.Unlock()
+
+	action := policy.action(kind)
+	if action == StorageErrorRetry && (retry == nil || attempt > policy.MaxRetries || !running) {
+		action = StorageErrorFail
+		if retry != nil {
+			err = fmt.Errorf("%w, gave up after %d attempts", err, attempt)
+		}
+	}
+	// pausing for anything but space reports the error itself
+	if action != StorageErrorPause || kind == StorageErrorNoSpace {
+		d.mu.Lock()
+		d.emit(Event{Type: EventStorageError, Piece: piece, Err: err})
+		d.mu.Unlock()
+	}
+	if action == StorageErrorRetry {
+		delay := policy.RetryDelay << (attempt - 1)
+		// counted like the write that failed, so halt waits for the retry
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				if piece >= 0 {
+					d.picker.Abort(piece)
+				}
+			case <-timer.C:
+				retry()
+			}
+		}()
+		return true
+	}
+
+	switch {
+	case action == StorageErrorPause && kind == StorageErrorNoSpace:
+		d.pauseForSpace(err)
+	case action == StorageErrorPause:
+		d.pauseForStorage(err, EventStorageError)
+	default:
+		d.publish(SessionEvent{Type: SessionStorageError, Err: err})
+		d.fail(err)
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+// This file learns our address as the internet sees it with STUN (RFC 8489). A binding
+// request sent to a STUN server comes back with the address and port the server saw it
+// from, which behind a NAT is the NAT's external address and the port it mapped ours to.
+// The request is sent from the listening port where that is free, and from a port of the
+// system's choosing otherwise, either way showing whether the NAT keeps the local port.
+// A NATMonitor checks on startup and periodically and publishes what it found, downloads
+// take it in with SetNATStatus for what they announce to trackers and the DHT
+package bittorrentclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+	stunHeaderLen        = 20
+
+	// requests are resent after 500ms, doubling up to stunAttempts tries
+	stunFirstTimeout = 500 * time.Millisecond
+	stunAttempts     = 4
+
+	// DefaultNATCheckInterval is how often a NATMonitor checks the external address
+	DefaultNATCheckInterval = 30 * time.Minute
+)
+
+// DefaultSTUNServers are public STUN servers, tried in order
+var DefaultSTUNServers = []string{
+	"stun.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+	"stun.nextcloud.com:3478",
+}
+
+// STUNBinding sends a binding request over conn to server and returns the address the
+// server saw it from. It reads from conn until the answer arrives, nothing else may
+func STUNBinding(ctx context.Context, conn net.PacketConn, server string) (netip.AddrPort, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	var tx [12]byte
+	if _, err := rand.Read(tx[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], tx[:])
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 1500)
+	timeout := stunFirstTimeout
+	for attempt := 0; attempt < stunAttempts; attempt++ {
+		if _, err := conn.WriteTo(req, addr); err != nil {
+			return netip.AddrPort{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if ctx.Err() != nil {
+				return netip.AddrPort{}, ctx.Err()
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				return netip.AddrPort{}, err
+			}
+			fromUDP, ok := from.(*net.UDPAddr)
+			if !ok || !fromUDP.IP.Equal(addr.IP) {
+				continue
+			}
+			mapped, err := parseSTUNResponse(buf[:n], tx)
+			if err == errNotOurSTUNResponse {
+				continue
+			}
+			return mapped, err
+		}
+		timeout *= 2
+	}
+	return netip.AddrPort{}, fmt.Errorf("stun: no answer from %s", server)
+}
+
+var errNotOurSTUNResponse = errors.New("stun: not an answer to our request")
+
+// this function returns the mapped address of the binding response to transaction tx
+func parseSTUNResponse(msg []byte, tx [12]byte) (netip.AddrPort, error) {
+	if len(msg) < stunHeaderLen || binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie || [12]byte(msg[8:20]) != tx {
+		return netip.AddrPort{}, errNotOurSTUNResponse
+	}
+	if typ := binary.BigEndian.Uint16(msg[0:]); typ != stunBindingSuccess {
+		return netip.AddrPort{}, fmt.Errorf("stun: binding failed with message type %#04x", typ)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderLen+length > len(msg) {
+		return netip.AddrPort{}, errors.New("stun: truncated response")
+	}
+	attrs := msg[stunHeaderLen : stunHeaderLen+length]
+	var mapped netip.AddrPort
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+size > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+size]
+		switch typ {
+		case stunXORMappedAddress:
+			// the port is xored with the cookie's top half, the IPv4 address with the cookie
+			if ap, ok := parseSTUNAddress(value); ok {
+				var cookie [4]byte
+				binary.BigEndian.PutUint32(cookie[:], stunMagicCookie)
+				ip := ap.Addr().As4()
+				for i := range ip {
+					ip[i] ^= cookie[i]
+				}
+				return netip.AddrPortFrom(netip.AddrFrom4(ip), ap.Port()^uint16(stunMagicCookie>>16)), nil
+			}
+		case stunMappedAddress:
+			if ap, ok := parseSTUNAddress(value); ok {
+				mapped = ap
+			}
+		}
+		// attributes are padded to four bytes
+		attrs = attrs[4+(size+3)&^3:]
+	}
+	if !mapped.IsValid() {
+		return netip.AddrPort{}, errors.New("stun: response without a mapped address")
+	}
+	return mapped, nil
+}
+
+// this function parses an IPv4 address attribute, the family IPv6 isn't asked for
+func parseSTUNAddress(value []byte) (netip.AddrPort, bool) {
+	if len(value) < 8 || value[1] != 0x01 {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte(value[4:8])), binary.BigEndian.Uint16(value[2:])), true
+}
+
+// NATStatus is what a STUN check found out about our address
+type NATStatus struct {
+	// External is the address and port the STUN server saw
+	External netip.AddrPort
+	// PortPreserved is set when the NAT mapped the local port to the same external port,
+	// so what we listen on is what peers have to dial
+	PortPreserved bool
+	Server        string
+	CheckedAt     time.Time
+}
+
+// NATConfig configures a NATMonitor
+type NATConfig struct {
+	// Port is the local listening port, requests are sent from it while it is free
+	Port int
+	// Servers are the STUN servers to ask, DefaultSTUNServers when empty
+	Servers []string
+	// Interval is the time between checks, DefaultNATCheckInterval when zero
+	Interval time.Duration
+	// Bus receives a SessionExternalAddr event whenever the external address changed
+	Bus *EventBus
+}
+
+// NATMonitor keeps track of our external address until it is closed
+type NATMonitor struct {
+	cfg NATConfig
+
+	mu     sync.Mutex
+	status NATStatus
+	err    error
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewNATMonitor checks the external address right away and then every cfg.Interval
+func NewNATMonitor(cfg NATConfig) *NATMonitor {
+	if len(cfg.Servers) == 0 {
+		cfg.Servers = DefaultSTUNServers
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultNATCheckInterval
+	}
+	m := &NATMonitor{cfg: cfg}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.wg.Add(1)
+	go m.run(ctx)
+	return m
+}
+
+// Status returns the result of the last check that succeeded, ok is false before the first
+func (m *NATMonitor) Status() (status NATStatus, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status, m.status.External.IsValid()
+}
+
+// Err returns why the last check failed, nil when it succeeded
+func (m *NATMonitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *NATMonitor) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *NATMonitor) run(ctx context.Context) {
+	defer m.wg.Done()
+	for {
+		status, err := m.check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		m.mu.Lock()
+		changed := err == nil && (status.External != m.status.External || status.PortPreserved != m.status.PortPreserved)
+		firstFailure := err != nil && m.err == nil && !m.status.External.IsValid()
+		m.err = err
+		if err == nil {
+			m.status = status
+		}
+		m.mu.Unlock()
+		if m.cfg.Bus != nil && (changed || firstFailure) {
+			ev := SessionEvent{Type: SessionExternalAddr, Err: err}
+			if err == nil {
+				ev.Port = int(status.External.Port())
+				ev.ExternalAddr = status.External.String()
+			}
+			m.cfg.Bus.Publish(ev)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.cfg.Interval):
+		}
+	}
+}
+
+// SetNATStatus tells the download what a STUN check found: the next announces hand trackers
+// the external address, and behind a NAT that doesn't keep the listening port DHT nodes are
+// told to use the port our packets arrive from
+func (d *Download) SetNATStatus(status NATStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nat = status
+}
+
+// this function asks the servers in turn until one answers
+func (m *NATMonitor) check(ctx context.Context) (NATStatus, error) {
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", m.cfg.Port))
+	if err != nil {
+		// the port is taken, e.g. by uTP or the DHT, a port of our own shows the NAT as well
+		conn, err = net.ListenPacket("udp4", ":0")
+		if err != nil {
+			return NATStatus{}, err
+		}
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).Port
+	var errs []error
+	for _, server := range m.cfg.Servers {
+		external, err := STUNBinding(ctx, conn, server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return NATStatus{
+			External:      external,
+			PortPreserved: int(external.Port()) == local,
+			Server:        server,
+			CheckedAt:     time.Now(),
+		}, nil
+	}
+	return NATStatus{}, fmt.Errorf("no stun server answered: %w", errors.Join(errs...))
+}
@@ -0,0 +1,298 @@
+// Package swarmtest runs a whole swarm in one process: a Tracker and a number of real
+// Clients speaking the wire protocol to each other over loopback, one of them seeding a
+// torrent of random data from memory and the others downloading it into memory. Where
+// peertest exercises one connection against a scripted peer, a Swarm checks that the
+// pieces of the client fit together end to end, cmd/swarmcheck runs one in CI
+package swarmtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	bt "mybittorrent"
+	"mybittorrent/internal/bencode"
+	"mybittorrent/peertest"
+)
+
+const (
+	defaultLeechers    = 3
+	defaultSize        = 4 << 20
+	defaultPieceLength = 64 << 10
+)
+
+// Config shapes a swarm, the zero value is a seeder and three leechers sharing 4 MiB
+type Config struct {
+	// Leechers is the number of clients downloading from the seeder, 3 when zero
+	Leechers int
+	// Size is the size of the torrent's data in bytes, 4 MiB when zero
+	Size int
+	// PieceLength is the torrent's piece length, 64 KiB when zero
+	PieceLength int
+	// Files splits the data into this many files of about the same size, zero or one makes
+	// a single file torrent
+	Files int
+	// Options are given to every client after the ones the swarm needs, e.g. rate limits
+	// or a logger
+	Options []bt.Option
+}
+
+// Node is one client of a swarm with its download of the swarm's torrent
+type Node struct {
+	Client   *bt.Client
+	Download *bt.Download
+}
+
+// Swarm is a tracker, a seeder and the leechers downloading from it, see New
+type Swarm struct {
+	Tracker  *Tracker
+	Seeder   *Node
+	Leechers []*Node
+
+	// metainfo is the .torrent, every node decodes its own copy
+	metainfo []byte
+	data     []byte
+	// dir is the clients' download directory, nothing is written to it
+	dir string
+}
+
+// Run starts a swarm shaped by cfg, waits for every leecher to finish and checks the data
+// they got, then shuts the swarm down
+func Run(ctx context.Context, cfg Config) error {
+	s, err := New(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	err = s.Wait(ctx)
+	if err == nil {
+		err = s.Check(ctx)
+	}
+	return errors.Join(err, s.Close())
+}
+
+// New starts a swarm shaped by cfg. The seeder is seeding and known to the tracker by the
+// time the leechers are added, so their first announce finds it
+func New(ctx context.Context, cfg Config) (*Swarm, error) {
+	if cfg.Leechers == 0 {
+		cfg.Leechers = defaultLeechers
+	}
+	if cfg.Size == 0 {
+		cfg.Size = defaultSize
+	}
+	if cfg.PieceLength == 0 {
+		cfg.PieceLength = defaultPieceLength
+	}
+	if cfg.Leechers < 0 || cfg.Size < 0 || cfg.PieceLength < 0 || cfg.Files < 0 {
+		return nil, errors.New("swarmtest: negative config")
+	}
+	tracker, err := NewTracker()
+	if err != nil {
+		return nil, err
+	}
+	s := &Swarm{Tracker: tracker}
+	err = s.start(ctx, cfg)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// this function makes the torrent and starts the seeder and the leechers
+func (s *Swarm) start(ctx context.Context, cfg Config) error {
+	var err error
+	s.dir, err = os.MkdirTemp("", "swarmtest")
+	if err != nil {
+		return err
+	}
+	numPieces := (cfg.Size + cfg.PieceLength - 1) / cfg.PieceLength
+	pieces, hashes := peertest.RandomPieces(numPieces, cfg.PieceLength, cfg.Size%cfg.PieceLength)
+	s.data = bytes.Join(pieces, nil)
+	s.metainfo, err = makeTorrent(s.Tracker.URL(), hashes, cfg.PieceLength, cfg.Size, cfg.Files)
+	if err != nil {
+		return err
+	}
+
+	s.Seeder, err = s.addNode(cfg, memoryPieces(pieces))
+	if err != nil {
+		return fmt.Errorf("seeder: %w", err)
+	}
+	// the seeder starts out downloading, the check finds every piece and it moves on to
+	// seeding
+	d := s.Seeder.Download
+	err = d.Verify(ctx)
+	if err != nil {
+		return fmt.Errorf("seeder: %w", err)
+	}
+	if state := d.State(); state != bt.DownloadSeeding {
+		return fmt.Errorf("seeder is %v after checking its data", state)
+	}
+	err = s.Tracker.WaitSeeders(ctx, d.InfoHash, 1)
+	if err != nil {
+		return err
+	}
+
+	for i := range cfg.Leechers {
+		node, err := s.addNode(cfg, bt.MemoryStorage{})
+		if err != nil {
+			return fmt.Errorf("leecher %d: %w", i, err)
+		}
+		s.Leechers = append(s.Leechers, node)
+	}
+	return nil
+}
+
+// this function starts a client on loopback and adds the torrent to it, kept in storage
+func (s *Swarm) addNode(cfg Config, storage bt.Storage) (*Node, error) {
+	opts := []bt.Option{
+		bt.WithListenHost("127.0.0.1"),
+		bt.WithListenPort(0),
+		bt.WithDHT(false),
+		bt.WithPortMapping(false),
+		bt.WithDownloadDir(s.dir),
+	}
+	client, err := bt.NewClient(append(opts, cfg.Options...)...)
+	if err != nil {
+		return nil, err
+	}
+	t, err := bt.DecodeTorrent(bytes.NewReader(s.metainfo))
+	if err == nil {
+		var d *bt.Download
+		d, err = client.AddTorrent(t, bt.WithStorage(storage))
+		if err == nil {
+			return &Node{Client: client, Download: d}, nil
+		}
+	}
+	client.Close()
+	return nil, err
+}
+
+// Wait blocks until every leecher has downloaded the whole torrent
+func (s *Swarm) Wait(ctx context.Context) error {
+	for i, node := range s.Leechers {
+		d := node.Download
+		select {
+		case <-d.Done():
+		case <-ctx.Done():
+			stats := d.Stats()
+			return fmt.Errorf("leecher %d has %d of %d pieces, %d peers, %v: %w",
+				i, stats.PiecesHave, stats.PiecesTotal, stats.Peers, stats.State, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// Check reads every file back from every leecher and compares it with the seeder's data
+func (s *Swarm) Check(ctx context.Context) error {
+	for i, node := range s.Leechers {
+		var offset int64
+		for _, f := range node.Download.FileProgress() {
+			got, err := readFile(ctx, node.Download, f.Index)
+			if err != nil {
+				return fmt.Errorf("leecher %d: reading %s: %w", i, f.Path, err)
+			}
+			if !bytes.Equal(got, s.data[offset:offset+f.Length]) {
+				return fmt.Errorf("leecher %d: %s doesn't match the seeder's", i, f.Path)
+			}
+			offset += f.Length
+		}
+		if offset != int64(len(s.data)) {
+			return fmt.Errorf("leecher %d has %d bytes of files, the torrent %d", i, offset, len(s.data))
+		}
+	}
+	return nil
+}
+
+// this function reads file index of d in full
+func readFile(ctx context.Context, d *bt.Download, index int) ([]byte, error) {
+	r, err := d.NewReader(index)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	r.SetContext(ctx)
+	return io.ReadAll(r)
+}
+
+// Close shuts down the clients and the tracker
+func (s *Swarm) Close() error {
+	var errs []error
+	for _, node := range append(s.Leechers, s.Seeder) {
+		if node != nil {
+			errs = append(errs, node.Client.Close())
+		}
+	}
+	errs = append(errs, s.Tracker.Close())
+	if s.dir != "" {
+		errs = append(errs, os.RemoveAll(s.dir))
+	}
+	return errors.Join(errs...)
+}
+
+// this function returns the .torrent of size bytes in pieces with the given hashes, split
+// into files when there are more than one
+func makeTorrent(announce string, hashes []byte, pieceLength, size, files int) ([]byte, error) {
+	info := map[string]interface{}{
+		"name":         "swarm",
+		"piece length": int64(pieceLength),
+		"pieces":       hashes,
+	}
+	if files <= 1 {
+		info["length"] = int64(size)
+	} else {
+		var list []interface{}
+		for i := range files {
+			// the first files take the remainder
+			length := size / files
+			if i < size%files {
+				length++
+			}
+			list = append(list, map[string]interface{}{
+				"length": int64(length),
+				"path":   []string{"file" + strconv.Itoa(i)},
+			})
+		}
+		info["files"] = list
+	}
+	return bencode.Encode(map[string]interface{}{
+		"announce": announce,
+		"info":     info,
+	})
+}
+
+// memoryPieces is a Storage holding a torrent's pieces from the start, the seeder's data
+type memoryPieces [][]byte
+
+func (m memoryPieces) OpenTorrent(t *bt.Torrent, dir string) (bt.TorrentStorage, error) {
+	if len(m) != t.NumPieces() {
+		return nil, fmt.Errorf("have %d pieces, the torrent %d", len(m), t.NumPieces())
+	}
+	return m, nil
+}
+
+func (m memoryPieces) ReadPieceAt(index int, b []byte, off int64) (int, error) {
+	if index < 0 || index >= len(m) || off < 0 || off > int64(len(m[index])) {
+		return 0, fmt.Errorf("read of piece %d at %d is out of range", index, off)
+	}
+	n := copy(b, m[index][off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m memoryPieces) WritePieceAt(index int, b []byte, off int64) (int, error) {
+	return 0, errors.New("the seeder's data is read only")
+}
+
+func (memoryPieces) Flush() error {
+	return nil
+}
+
+func (memoryPieces) Close() error {
+	return nil
+}
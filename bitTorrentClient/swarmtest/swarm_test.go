@@ -0,0 +1,49 @@
+package swarmtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bt "mybittorrent"
+)
+
+func TestSwarm(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		transport bt.Transport
+	}{
+		{"single file", Config{Leechers: 2, Size: 1 << 20, PieceLength: 32 << 10}, bt.TransportTCP},
+		// the last piece is short and spans files
+		{"files", Config{Leechers: 3, Size: 1<<20 + 1000, PieceLength: 32 << 10, Files: 3}, bt.TransportTCP},
+		{"utp", Config{Leechers: 2, Size: 1 << 20, PieceLength: 32 << 10, Options: []bt.Option{bt.WithUTP(true)}}, bt.TransportUTP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if testing.Short() {
+				t.Skip("swarm runs take a while")
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			s, err := New(ctx, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			err = s.Wait(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range s.Seeder.Download.Peers() {
+				if p.Transport != tt.transport {
+					t.Errorf("seeder talks to %s over %v, want %v", p.Addr, p.Transport, tt.transport)
+				}
+			}
+			err = s.Check(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// This file is the tracker a swarm announces to, a plain HTTP tracker that remembers the
+// peers of every torrent announced to it and hands each of them the others in the compact
+// format. It keeps nothing on disk and checks nothing beyond what it needs to answer
+
+package swarmtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"mybittorrent/internal/bencode"
+)
+
+// the interval asked of clients, they announce no more often than once a minute anyway
+const announceInterval = 60
+
+// Tracker is an in-process HTTP tracker listening on loopback
+type Tracker struct {
+	ln     net.Listener
+	server *http.Server
+
+	mu sync.Mutex
+	// torrents holds the peers of each torrent by peer id
+	torrents map[[20]byte]map[[20]byte]trackedPeer
+	// notify is closed and replaced whenever a peer announces
+	notify chan struct{}
+}
+
+type trackedPeer struct {
+	addr     netip.AddrPort
+	complete bool
+}
+
+// NewTracker starts a tracker on a free port of 127.0.0.1
+func NewTracker() (*Tracker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracker{
+		ln:       ln,
+		torrents: make(map[[20]byte]map[[20]byte]trackedPeer),
+		notify:   make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /announce", t.announce)
+	t.server = &http.Server{Handler: mux}
+	go t.server.Serve(ln)
+	return t, nil
+}
+
+// URL returns the announce URL to put in torrents
+func (t *Tracker) URL() string {
+	return "http://" + t.ln.Addr().String() + "/announce"
+}
+
+// Close stops the tracker
+func (t *Tracker) Close() error {
+	return t.server.Close()
+}
+
+// Peers returns how many peers of the torrent of infoHash announced as complete and as
+// still downloading
+func (t *Tracker) Peers(infoHash [20]byte) (seeders, leechers int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.torrents[infoHash] {
+		if p.complete {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+	return seeders, leechers
+}
+
+// WaitSeeders blocks until n peers of the torrent of infoHash announced as complete
+func (t *Tracker) WaitSeeders(ctx context.Context, infoHash [20]byte, n int) error {
+	for {
+		t.mu.Lock()
+		wait := t.notify
+		t.mu.Unlock()
+		if seeders, _ := t.Peers(infoHash); seeders >= n {
+			return nil
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d seeders: %w", n, ctx.Err())
+		}
+	}
+}
+
+func (t *Tracker) announce(w http.ResponseWriter, r *http.Request) {
+	resp, err := t.register(r)
+	if err != nil {
+		resp = map[string]interface{}{"failure reason": err.Error()}
+	}
+	// failures are answered with 200 too, clients only read the reason from a 200
+	data, _ := bencode.Encode(resp)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(data)
+}
+
+// this function records the announcing peer and returns the answer listing the others
+func (t *Tracker) register(r *http.Request) (map[string]interface{}, error) {
+	query := r.URL.Query()
+	var infoHash, peerID [20]byte
+	if len(query.Get("info_hash")) != len(infoHash) || len(query.Get("peer_id")) != len(peerID) {
+		return nil, errors.New("info_hash and peer_id must be 20 bytes")
+	}
+	copy(infoHash[:], query.Get("info_hash"))
+	copy(peerID[:], query.Get("peer_id"))
+	// port zero is a peer behind a proxy, it is counted but handed to nobody
+	port, err := strconv.ParseUint(query.Get("port"), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", query.Get("port"))
+	}
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	addr := netip.AddrPortFrom(remote.Addr().Unmap(), uint16(port))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	peers := t.torrents[infoHash]
+	if peers == nil {
+		peers = make(map[[20]byte]trackedPeer)
+		t.torrents[infoHash] = peers
+	}
+	if query.Get("event") == "stopped" {
+		delete(peers, peerID)
+	} else {
+		peers[peerID] = trackedPeer{addr: addr, complete: query.Get("left") == "0"}
+	}
+	close(t.notify)
+	t.notify = make(chan struct{})
+
+	var compact []byte
+	var seeders, leechers int64
+	for id, p := range peers {
+		if p.complete {
+			seeders++
+		} else {
+			leechers++
+		}
+		if id == peerID || !p.addr.Addr().Is4() || p.addr.Port() == 0 {
+			continue
+		}
+		ip := p.addr.Addr().As4()
+		compact = append(compact, ip[:]...)
+		compact = binary.BigEndian.AppendUint16(compact, p.addr.Port())
+	}
+	return map[string]interface{}{
+		"interval":   int64(announceInterval),
+		"complete":   seeders,
+		"incomplete": leechers,
+		"peers":      compact,
+	}, nil
+}
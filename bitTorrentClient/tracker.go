@@ -0,0 +1,220 @@
+// This file talks to HTTP trackers. An Announcer built from a decoded torrent sends the
+// announce request and parses the bencoded response, compact and dictionary peer lists
+// alike, into the peer addresses to connect to and the interval for the next announce
+package bittorrentclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAnnounceInterval = 30 * time.Minute
+	minAnnounceInterval     = time.Minute
+	announceTimeout         = 30 * time.Second
+	// trackers answer with a few KiB at most, anything huge isn't a tracker response
+	maxAnnounceResponse = 1 << 20
+)
+
+type AnnounceResponse struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	Peers       []string
+	Seeders     int
+	Leechers    int
+	TrackerID   string
+	Warning     string
+}
+
+// NewTorrentAnnouncer returns an announcer for a decoded torrent, announcing peerID and the
+// port we accept connections on
+func NewTorrentAnnouncer(t *Torrent, peerID [20]byte, port int) *Announcer {
+	total := t.TotalLength()
+	return &Announcer{
+		announce_url: t.Announce,
+		piece_size:   t.Info.PieceLength,
+		TotalSize:    total,
+		urlParams: urlParams{
+			info_dict: string(t.InfoHash[:]),
+			peer_id:   string(peerID[:]),
+			port:      strconv.Itoa(port),
+			left:      total,
+			compact:   "1",
+			event:     "started",
+		},
+	}
+}
+
+// SetProgress updates the transfer totals sent with the next announce
+func (a *Announcer) SetProgress(uploaded, downloaded, left int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.urlParams.uploaded = uploaded
+	a.urlParams.downloaded = downloaded
+	a.urlParams.left = left
+}
+
+// TrackerID returns the id the tracker asked us to send back with later announces
+func (a *Announcer) TrackerID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.urlParams.trackerid
+}
+
+// URL returns the announce url of the tracker
+func (a *Announcer) URL() string {
+	return a.announce_url
+}
+
+// SetTrackerID restores a tracker id from an earlier session
+func (a *Announcer) SetTrackerID(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.urlParams.trackerid = id
+}
+
+// SetExternalIP sets the address the tracker hands out for us, without one the tracker uses
+// the address the announce came from
+func (a *Announcer) SetExternalIP(ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.urlParams.ip = ip
+}
+
+// SetEvent sets the event sent with the next announce: "started", "completed", "stopped"
+// or "" for a regular update
+func (a *Announcer) SetEvent(event string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setEvent(event)
+}
+
+// this function returns the event the next announce sends
+func (a *Announcer) pendingEvent() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.urlParams.event
+}
+
+// Announce sends an announce to the tracker. The event is cleared once the tracker has
+// received it, so it is only sent once, unless another one was set in the meantime. It is
+// safe to update the announcer while an announce is out
+func (a *Announcer) Announce(ctx context.Context) (*AnnounceResponse, error) {
+	if !strings.HasPrefix(a.announce_url, "http://") && !strings.HasPrefix(a.announce_url, "https://") {
+		return nil, fmt.Errorf("unsupported tracker url %q", a.announce_url)
+	}
+	ctx, cancel := context.WithTimeout(ctx, announceTimeout)
+	defer cancel()
+	a.mu.Lock()
+	u, event := a.generateEncodedURL(), a.urlParams.event
+	a.mu.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker responded with %s", resp.Status)
+	}
+	res, err := parseAnnounceResponse(io.LimitReader(resp.Body, maxAnnounceResponse))
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.urlParams.event == event {
+		a.urlParams.event = ""
+	}
+	if res.TrackerID != "" {
+		a.urlParams.trackerid = res.TrackerID
+	}
+	return res, nil
+}
+
+func parseAnnounceResponse(r io.Reader) (*AnnounceResponse, error) {
+	data, err := NewDecoder(r).decode()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding tracker response: %v", err)
+	}
+	dict, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("tracker response is not a dictionary")
+	}
+	if reason, ok := dict["failure reason"].(string); ok {
+		return nil, fmt.Errorf("tracker failure: %s", reason)
+	}
+
+	res := &AnnounceResponse{Interval: defaultAnnounceInterval}
+	if interval, ok := dict["interval"].(int64); ok && interval > 0 {
+		res.Interval = time.Duration(interval) * time.Second
+	}
+	if interval, ok := dict["min interval"].(int64); ok && interval > 0 {
+		res.MinInterval = time.Duration(interval) * time.Second
+	}
+	if res.Interval < minAnnounceInterval {
+		res.Interval = minAnnounceInterval
+	}
+	if complete, ok := dict["complete"].(int64); ok {
+		res.Seeders = int(complete)
+	}
+	if incomplete, ok := dict["incomplete"].(int64); ok {
+		res.Leechers = int(incomplete)
+	}
+	if id, ok := dict["tracker id"].(string); ok {
+		res.TrackerID = id
+	}
+	if warning, ok := dict["warning message"].(string); ok {
+		res.Warning = warning
+	}
+
+	switch peers := dict["peers"].(type) {
+	case string:
+		res.Peers = append(res.Peers, parseCompactPeers([]byte(peers), 4)...)
+	case []interface{}:
+		for _, entry := range peers {
+			peer, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ip, _ := peer["ip"].(string)
+			port, _ := peer["port"].(int64)
+			if ip == "" || port <= 0 || port > 65535 {
+				continue
+			}
+			res.Peers = append(res.Peers, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+		}
+	}
+	if peers6, ok := dict["peers6"].(string); ok {
+		res.Peers = append(res.Peers, parseCompactPeers([]byte(peers6), 16)...)
+	}
+	return res, nil
+}
+
+// this function parses a compact peer list, each entry is an IP address of ipLen bytes
+// followed by a big endian port
+func parseCompactPeers(data []byte, ipLen int) []string {
+	entry := ipLen + 2
+	var peers []string
+	for i := 0; i+entry <= len(data); i += entry {
+		ip, _ := netip.AddrFromSlice(data[i : i+ipLen])
+		port := binary.BigEndian.Uint16(data[i+ipLen : i+entry])
+		if port == 0 {
+			continue
+		}
+		peers = append(peers, netip.AddrPortFrom(ip, port).String())
+	}
+	return peers
+}
@@ -0,0 +1,108 @@
+// This file scrapes HTTP trackers (BEP 48), asking for the swarm size of torrents without
+// announcing to them. The scrape url is the announce url with its last path element
+// "announce" replaced by "scrape", trackers whose announce url doesn't end that way can't
+// be scraped. Several torrents can be scraped with one request
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrScrapeUnsupported is returned for trackers whose announce url has no scrape url
+var ErrScrapeUnsupported = errors.New("tracker doesn't support scraping")
+
+// TrackerScrape is the swarm size of a torrent as a tracker knows it
+type TrackerScrape struct {
+	Seeders  int
+	Leechers int
+	// Completed is the number of times the torrent was downloaded in full
+	Completed int
+}
+
+// ScrapeURL returns the scrape url of the tracker at announceURL
+func ScrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported tracker url %q", announceURL)
+	}
+	slash := strings.LastIndexByte(u.Path, '/')
+	rest, ok := strings.CutPrefix(u.Path[slash+1:], "announce")
+	if !ok {
+		return "", ErrScrapeUnsupported
+	}
+	u.Path = u.Path[:slash+1] + "scrape" + rest
+	return u.String(), nil
+}
+
+// ScrapeTracker asks the tracker at announceURL for the swarm size of each infohash. The
+// tracker leaves out torrents it doesn't know
+func ScrapeTracker(ctx context.Context, announceURL string, infoHashes ...[20]byte) (map[[20]byte]TrackerScrape, error) {
+	scrapeURL, err := ScrapeURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	for _, infoHash := range infoHashes {
+		params.Add("info_hash", string(infoHash[:]))
+	}
+	sep := "?"
+	if strings.Contains(scrapeURL, "?") {
+		sep = "&"
+	}
+	ctx, cancel := context.WithTimeout(ctx, announceTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scrapeURL+sep+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker responded with %s", resp.Status)
+	}
+	return parseScrapeResponse(io.LimitReader(resp.Body, maxAnnounceResponse))
+}
+
+func parseScrapeResponse(r io.Reader) (map[[20]byte]TrackerScrape, error) {
+	data, err := NewDecoder(r).decode()
+	if err != nil {
+		return nil, fmt.Errorf("error decoding scrape response: %v", err)
+	}
+	dict, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("scrape response is not a dictionary")
+	}
+	if reason, ok := dict["failure reason"].(string); ok {
+		return nil, fmt.Errorf("tracker failure: %s", reason)
+	}
+	files, ok := dict["files"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("scrape response has no files")
+	}
+	res := make(map[[20]byte]TrackerScrape, len(files))
+	for key, value := range files {
+		stats, ok := value.(map[string]interface{})
+		if len(key) != 20 || !ok {
+			continue
+		}
+		var infoHash [20]byte
+		copy(infoHash[:], key)
+		complete, _ := stats["complete"].(int64)
+		incomplete, _ := stats["incomplete"].(int64)
+		downloaded, _ := stats["downloaded"].(int64)
+		res[infoHash] = TrackerScrape{Seeders: int(complete), Leechers: int(incomplete), Completed: int(downloaded)}
+	}
+	return res, nil
+}
@@ -0,0 +1,171 @@
+package bittorrentclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mybittorrent/internal/bencode"
+)
+
+func TestParseAnnounceResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		resp map[string]interface{}
+		want AnnounceResponse
+	}{
+		{
+			name: "compact",
+			resp: map[string]interface{}{
+				"interval": int64(1800), "min interval": int64(120), "complete": int64(5), "incomplete": int64(7),
+				"tracker id": "abc", "warning message": "careful",
+				"peers":  "\x7f\x00\x00\x01\x1a\xe1" + "\x0a\x00\x00\x02\x00\x00" + "\x0a\x00\x00\x03\x00",
+				"peers6": "\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01\x1a\xe1",
+			},
+			want: AnnounceResponse{
+				Interval: 30 * time.Minute, MinInterval: 2 * time.Minute, Seeders: 5, Leechers: 7,
+				TrackerID: "abc", Warning: "careful",
+				// the zero port and the truncated entry are skipped
+				Peers: []string{"127.0.0.1:6881", "[2001:db8::1]:6881"},
+			},
+		},
+		{
+			name: "dictionaries",
+			resp: map[string]interface{}{
+				"interval": int64(900),
+				"peers": []interface{}{
+					map[string]interface{}{"ip": "10.0.0.1", "port": int64(51413), "peer id": "x"},
+					map[string]interface{}{"ip": "::1", "port": int64(6881)},
+					map[string]interface{}{"ip": "10.0.0.2", "port": int64(70000)},
+					map[string]interface{}{"port": int64(6881)},
+					"not a dictionary",
+				},
+			},
+			want: AnnounceResponse{Interval: 15 * time.Minute, Peers: []string{"10.0.0.1:51413", "[::1]:6881"}},
+		},
+		{
+			name: "defaults",
+			resp: map[string]interface{}{"interval": int64(5)},
+			// an interval that would hammer the tracker is raised to the minimum
+			want: AnnounceResponse{Interval: minAnnounceInterval},
+		},
+		{
+			name: "no interval",
+			resp: map[string]interface{}{},
+			want: AnnounceResponse{Interval: defaultAnnounceInterval},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := bencode.Encode(tc.resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseAnnounceResponse(strings.NewReader(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("parsed %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+
+	for _, resp := range []string{
+		"d14:failure reason9:not founde",
+		"li1ee",
+		"d8:interval",
+		"",
+	} {
+		if _, err := parseAnnounceResponse(strings.NewReader(resp)); err == nil {
+			t.Errorf("%q parsed", resp)
+		}
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	queries := make(chan url.Values, 2)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		data, _ := bencode.Encode(map[string]interface{}{"interval": int64(60), "tracker id": "id1", "peers": ""})
+		w.Write(data)
+	}))
+	defer tracker.Close()
+	torrent := testTorrent(t, tracker.URL+"/announce")
+	var peerID [20]byte
+	copy(peerID[:], "-GN0001-announcetest")
+	a := NewTorrentAnnouncer(torrent, peerID, 6881)
+	a.SetProgress(10, 20, 30)
+
+	for i, want := range []map[string]string{
+		{"info_hash": string(torrent.InfoHash[:]), "peer_id": string(peerID[:]), "port": "6881",
+			"uploaded": "10", "downloaded": "20", "left": "30", "compact": "1", "event": "started"},
+		// the event went through and the tracker id comes back
+		{"event": "", "trackerid": "id1"},
+	} {
+		res, err := a.Announce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Interval != time.Minute {
+			t.Errorf("interval %v, want a minute", res.Interval)
+		}
+		q := <-queries
+		for key, value := range want {
+			if got := q.Get(key); got != value {
+				t.Errorf("announce %d: %s=%q, want %q", i, key, got, value)
+			}
+		}
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	a = NewTorrentAnnouncer(testTorrent(t, failing.URL+"/announce"), peerID, 6881)
+	if _, err := a.Announce(context.Background()); err == nil {
+		t.Error("a failing tracker gave a response")
+	}
+	if event := a.pendingEvent(); event != "started" {
+		t.Errorf("event %q after a failed announce, want it kept", event)
+	}
+}
+
+func TestScrape(t *testing.T) {
+	for announce, want := range map[string]string{
+		"http://t.example/announce":         "http://t.example/scrape",
+		"http://t.example/x/announce.php":   "http://t.example/x/scrape.php",
+		"https://t.example/announce?pk=key": "https://t.example/scrape?pk=key",
+		"http://t.example/a":                "",
+		"udp://t.example:80/announce":       "",
+	} {
+		got, err := ScrapeURL(announce)
+		if want == "" && err == nil || want != "" && got != want {
+			t.Errorf("ScrapeURL(%s) = %q, %v, want %q", announce, got, err, want)
+		}
+	}
+
+	var infoHash [20]byte
+	copy(infoHash[:], "aaaaaaaaaaaaaaaaaaaa")
+	data, err := bencode.Encode(map[string]interface{}{"files": map[string]interface{}{
+		string(infoHash[:]): map[string]interface{}{"complete": int64(3), "incomplete": int64(4), "downloaded": int64(50)},
+		"short":             map[string]interface{}{"complete": int64(1)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseScrapeResponse(strings.NewReader(string(data)))
+	want := map[[20]byte]TrackerScrape{infoHash: {Seeders: 3, Leechers: 4, Completed: 50}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("scrape parsed as %v, %v, want %v", got, err, want)
+	}
+	for _, resp := range []string{"d14:failure reason3:nope", "de", "le"} {
+		if _, err := parseScrapeResponse(strings.NewReader(resp)); err == nil {
+			t.Errorf("scrape %q parsed", resp)
+		}
+	}
+}
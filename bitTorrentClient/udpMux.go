@@ -0,0 +1,191 @@
+// This file shares one UDP socket between the DHT and uTP, so both run on the listening
+// port like other clients expect. The two are told apart by the first byte of a packet: a
+// DHT message is a bencoded dictionary and starts with 'd', a uTP packet starts with its
+// type and version, which never make a 'd'. Each side reads its packets from a PacketConn
+// of its own, the socket is closed once both are
+package bittorrentclient
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// packets queued for a side that doesn't read fast enough, more are dropped like a full
+// socket buffer drops them
+const udpMuxQueue = 256
+
+type udpMux struct {
+	pc       net.PacketConn
+	dht, utp *muxConn
+
+	mu   sync.Mutex
+	open int
+}
+
+type muxPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// newUDPMux starts splitting the packets of pc between the two PacketConns it returns
+func newUDPMux(pc net.PacketConn) (dhtConn, utpConn net.PacketConn) {
+	m := &udpMux{pc: pc, open: 2}
+	m.dht = newMuxConn(m)
+	m.utp = newMuxConn(m)
+	go m.readLoop()
+	return m.dht, m.utp
+}
+
+func (m *udpMux) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := m.pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				m.dht.fail()
+				m.utp.fail()
+				return
+			}
+			// e.g. an ICMP error for an earlier packet, the socket is still fine
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		side := m.utp
+		if buf[0] == 'd' {
+			side = m.dht
+		}
+		side.deliver(muxPacket{data: append([]byte(nil), buf[:n]...), addr: addr})
+	}
+}
+
+// this function closes the socket once both sides are closed
+func (m *udpMux) release() error {
+	m.mu.Lock()
+	m.open--
+	last := m.open == 0
+	m.mu.Unlock()
+	if last {
+		return m.pc.Close()
+	}
+	return nil
+}
+
+// muxConn is one side of a udpMux. Writes go straight to the socket
+type muxConn struct {
+	mux     *udpMux
+	packets chan muxPacket
+	closed  chan struct{}
+	once    sync.Once
+
+	mu           sync.Mutex
+	readDeadline time.Time
+	// deadlineSet wakes a blocked read when the deadline changes
+	deadlineSet chan struct{}
+}
+
+func newMuxConn(m *udpMux) *muxConn {
+	return &muxConn{
+		mux:         m,
+		packets:     make(chan muxPacket, udpMuxQueue),
+		closed:      make(chan struct{}),
+		deadlineSet: make(chan struct{}, 1),
+	}
+}
+
+func (c *muxConn) deliver(p muxPacket) {
+	select {
+	case c.packets <- p:
+	default:
+	}
+}
+
+// this function closes the side without releasing the socket, which is already gone
+func (c *muxConn) fail() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+func (c *muxConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		c.mu.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case p := <-c.packets:
+			stopTimer(timer)
+			return copy(b, p.data), p.addr, nil
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-c.deadlineSet:
+			stopTimer(timer)
+		}
+	}
+}
+
+func (c *muxConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.mux.pc.WriteTo(b, addr)
+}
+
+func (c *muxConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.mux.release()
+	})
+	return err
+}
+
+func (c *muxConn) LocalAddr() net.Addr {
+	return c.mux.pc.LocalAddr()
+}
+
+func (c *muxConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *muxConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	select {
+	case c.deadlineSet <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes to a UDP socket don't block
+func (c *muxConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
@@ -0,0 +1,117 @@
+package bittorrentclient
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mybittorrent/dht"
+)
+
+func TestUDPMux(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhtConn, utpConn := newUDPMux(pc)
+	sender, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	packets := []struct {
+		data string
+		conn net.PacketConn
+	}{
+		{"d1:ad2:id20:aaaaaaaaaaaaaaaaaaaae1:q4:ping1:t2:aa1:y1:qe", dhtConn},
+		{"\x41\x00\x12\x34", utpConn}, // a uTP SYN
+	}
+	buf := make([]byte, 1500)
+	for _, p := range packets {
+		_, err := sender.WriteTo([]byte(p.data), pc.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, addr, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("reading %q: %v", p.data, err)
+		}
+		if string(buf[:n]) != p.data || addr.String() != sender.LocalAddr().String() {
+			t.Errorf("read %q from %v, want %q from %v", buf[:n], addr, p.data, sender.LocalAddr())
+		}
+	}
+
+	dhtConn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, _, err := dhtConn.ReadFrom(buf); !os.IsTimeout(err) {
+		t.Errorf("read past the deadline: %v, want a timeout", err)
+	}
+
+	// the socket stays open until both sides are closed
+	dhtConn.Close()
+	if _, err := utpConn.WriteTo([]byte("x"), sender.LocalAddr()); err != nil {
+		t.Errorf("writing after closing the other side: %v", err)
+	}
+	utpConn.Close()
+	if _, err := pc.WriteTo([]byte("x"), sender.LocalAddr()); err == nil {
+		t.Error("socket is still open after closing both sides")
+	}
+}
+
+// this function starts a client serving uTP next to the DHT on its listening port
+func newUTPClient(t *testing.T) *Client {
+	t.Helper()
+	client, err := NewClient(
+		WithListenHost("127.0.0.1"),
+		WithListenPort(0),
+		WithUTP(true),
+		WithDHTConfig(dht.Config{NoDefaultBootstrap: true, DisableIPv6: true}),
+		WithDownloadDir(t.TempDir()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientUTP(t *testing.T) {
+	seeder, leecher := newUTPClient(t), newUTPClient(t)
+	if port := seeder.dht.Addr().(*net.UDPAddr).Port; port != seeder.Port() {
+		t.Fatalf("DHT is on port %d, want the listening port %d", port, seeder.Port())
+	}
+
+	torrent := testTorrent(t, "http://127.0.0.1:1/announce")
+	err := os.WriteFile(filepath.Join(seeder.Dir(), "test"), make([]byte, 16<<10), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := seeder.AddTorrent(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = seed.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leech, err := leecher.AddTorrent(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leech.AddPeers(seeder.Addr().String())
+	select {
+	case <-leech.Done():
+	case <-time.After(20 * time.Second):
+		t.Fatal("the download didn't complete")
+	}
+	for _, d := range []*Download{seed, leech} {
+		peers := d.Peers()
+		if len(peers) != 1 || peers[0].Transport != TransportUTP {
+			t.Errorf("%d peers, want one over uTP", len(peers))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// This file configures who a download uploads to: how many peers it unchokes while
+// downloading and while seeding, and which seed strategy picks them once it has nothing
+// left to reciprocate. The choker is only touched by the maintenance loop, so the settings
+// are kept under d.mu and handed to it before every rechoke
+package bittorrentclient
+
+// chokerSettings are the parts of a Choker that can be changed while the download runs
+type chokerSettings struct {
+	uploadSlots     int
+	seedUploadSlots int
+	seedStrategy    SeedStrategy
+}
+
+// this function copies the settings to c, the caller holds d.mu
+func (s chokerSettings) apply(c *Choker) {
+	c.UploadSlots = s.uploadSlots
+	c.SeedUploadSlots = s.seedUploadSlots
+	c.SeedStrategy = s.seedStrategy
+}
+
+// SetUploadSlots sets how many peers the download unchokes at once including the
+// optimistic one, below one restores DefaultUploadSlots. It takes effect at the next rechoke
+func (d *Download) SetUploadSlots(slots int) {
+	if slots < 1 {
+		slots = DefaultUploadSlots
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unchoke.uploadSlots = slots
+}
+
+func (d *Download) UploadSlots() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.unchoke.uploadSlots
+}
+
+// SetSeedUploadSlots sets how many peers the download unchokes at once while seeding, zero
+// keeps the number of SetUploadSlots
+func (d *Download) SetSeedUploadSlots(slots int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unchoke.seedUploadSlots = max(slots, 0)
+}
+
+func (d *Download) SeedUploadSlots() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.unchoke.seedUploadSlots
+}
+
+// SetSeedStrategy decides who the download unchokes while seeding, see SeedStrategy
+func (d *Download) SetSeedStrategy(strategy SeedStrategy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unchoke.seedStrategy = strategy
+}
+
+func (d *Download) SeedStrategy() SeedStrategy {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.unchoke.seedStrategy
+}
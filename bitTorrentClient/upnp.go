@@ -0,0 +1,279 @@
+// This file asks UPnP Internet Gateway Devices for port mappings. The gateway is found with
+// an SSDP search multicast on the local network, its answer points at a device description
+// listing the services it offers, and the WANIPConnection or WANPPPConnection service of
+// that description takes SOAP calls to add and delete mappings and to tell the external
+// address
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr          = "239.255.255.250:1900"
+	ssdpSearchTimeout = 3 * time.Second
+	upnpTimeout       = 5 * time.Second
+	// descriptions and SOAP answers are a few KiB
+	maxUPnPResponse = 1 << 20
+	// upnpDescription is what our mappings are labelled with in the gateway's UI
+	upnpDescription = "goNet BitTorrent"
+)
+
+// upnpServiceTypes are the services that map ports, in order of preference
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpClient maps ports through the service at controlURL
+type upnpClient struct {
+	controlURL  string
+	serviceType string
+	// localIP is the address the gateway forwards to
+	localIP netip.Addr
+}
+
+func (c *upnpClient) name() string {
+	return "UPnP"
+}
+
+// discoverUPnP searches the network for a gateway and returns a client for it
+func discoverUPnP(ctx context.Context) (*upnpClient, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	conn.SetReadDeadline(time.Now().Add(ssdpSearchTimeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, errors.New("UPnP: no gateway answered")
+		}
+		location := ssdpHeader(buf[:n], "location")
+		if location == "" {
+			continue
+		}
+		c, err := newUPnPClient(ctx, location)
+		if err == nil {
+			return c, nil
+		}
+	}
+}
+
+// this function returns the value of header name in an SSDP answer
+func ssdpHeader(msg []byte, name string) string {
+	for _, line := range strings.Split(string(msg), "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// upnpDevice is the part of a device description that leads to the services
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// this function returns the first service of type serviceType on d or its subdevices
+func (d *upnpDevice) find(serviceType string) string {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return s.ControlURL
+		}
+	}
+	for i := range d.Devices {
+		if u := d.Devices[i].find(serviceType); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// newUPnPClient reads the device description at location and returns a client for its
+// port mapping service
+func newUPnPClient(ctx context.Context, location string) (*upnpClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, upnpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP: description returned %s", resp.Status)
+	}
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, maxUPnPResponse)).Decode(&root)
+	if err != nil {
+		return nil, fmt.Errorf("UPnP: invalid description: %w", err)
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+	for _, serviceType := range upnpServiceTypes {
+		control := root.Device.find(serviceType)
+		if control == "" {
+			continue
+		}
+		controlURL, err := base.Parse(control)
+		if err != nil {
+			return nil, err
+		}
+		gateway, err := net.ResolveUDPAddr("udp4", controlURL.Host)
+		if err != nil {
+			return nil, err
+		}
+		localIP, err := localAddrTo(gateway)
+		if err != nil {
+			return nil, err
+		}
+		return &upnpClient{controlURL: controlURL.String(), serviceType: serviceType, localIP: localIP}, nil
+	}
+	return nil, errors.New("UPnP: gateway has no port mapping service")
+}
+
+// this function calls action on the service and returns the answer's arguments
+func (c *upnpClient) call(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, upnpTimeout)
+	defer cancel()
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, c.serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+c.serviceType+"#"+action+`"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// the answer's arguments are the leaf elements of the body, faults included
+	results := make(map[string]string)
+	decoder := xml.NewDecoder(io.LimitReader(resp.Body, maxUPnPResponse))
+	var name string
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("UPnP: invalid answer to %s: %w", action, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+		case xml.CharData:
+			if name != "" {
+				results[name] += string(t)
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP: %s failed: %s %s", action, results["errorCode"], results["errorDescription"])
+	}
+	return results, nil
+}
+
+func (c *upnpClient) mapPort(ctx context.Context, protocol string, internal, external int, lifetime time.Duration) (PortMapping, error) {
+	if external == 0 {
+		external = internal
+	}
+	_, err := c.call(ctx, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(external)},
+		{"NewProtocol", protocol},
+		{"NewInternalPort", strconv.Itoa(internal)},
+		{"NewInternalClient", c.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", upnpDescription},
+		{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	})
+	if err != nil {
+		return PortMapping{}, err
+	}
+	m := PortMapping{
+		Protocol:     protocol,
+		InternalPort: internal,
+		ExternalPort: external,
+		Method:       c.name(),
+		Expires:      time.Now().Add(lifetime),
+	}
+	res, err := c.call(ctx, "GetExternalIPAddress", nil)
+	if err == nil {
+		m.ExternalIP, _ = netip.ParseAddr(strings.TrimSpace(res["NewExternalIPAddress"]))
+	}
+	return m, nil
+}
+
+func (c *upnpClient) unmapPort(ctx context.Context, m PortMapping) error {
+	_, err := c.call(ctx, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(m.ExternalPort)},
+		{"NewProtocol", m.Protocol},
+	})
+	return err
+}
@@ -0,0 +1,824 @@
+// This file implements the uTP transport (BEP 29), a congestion controlled protocol on
+// top of UDP. uTP measures one way queuing delay and backs off when it grows, so large
+// downloads don't bufferbloat the user's uplink the way a pile of TCP connections does
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	utpTypeData  = 0
+	utpTypeFin   = 1
+	utpTypeState = 2
+	utpTypeReset = 3
+	utpTypeSyn   = 4
+
+	utpVersion    = 1
+	utpHeaderSize = 20
+	utpMaxPacket  = 1400
+	utpMaxPayload = utpMaxPacket - utpHeaderSize
+
+	// LEDBAT parameters, the congestion window grows by at most utpMaxCwndIncrease
+	// bytes per round trip while the measured queuing delay is below the target
+	utpTargetDelay     = 100 * time.Millisecond
+	utpMaxCwndIncrease = 3000
+	utpMaxCwnd         = 1 << 20
+	utpRecvWindow      = 1 << 20
+
+	utpInitialRTO   = time.Second
+	utpMinRTO       = 500 * time.Millisecond
+	utpMaxRetries   = 8
+	utpTickInterval = 50 * time.Millisecond
+	utpLinger       = 10 * time.Second
+	utpAcceptQueue  = 64
+	utpMaxReorder   = 2048
+)
+
+var errUTPReset = errors.New("utp: connection reset by peer")
+var errUTPTimedOut = errors.New("utp: connection timed out")
+
+type utpHeader struct {
+	typ       uint8
+	connID    uint16
+	timestamp uint32
+	timeDiff  uint32
+	wndSize   uint32
+	seqNr     uint16
+	ackNr     uint16
+}
+
+// this function serializes the header followed by the payload into a single datagram
+func (h utpHeader) marshal(payload []byte) []byte {
+	buf := make([]byte, utpHeaderSize+len(payload))
+	buf[0] = h.typ<<4 | utpVersion
+	buf[1] = 0 // we don't send any extensions
+	binary.BigEndian.PutUint16(buf[2:], h.connID)
+	binary.BigEndian.PutUint32(buf[4:], h.timestamp)
+	binary.BigEndian.PutUint32(buf[8:], h.timeDiff)
+	binary.BigEndian.PutUint32(buf[12:], h.wndSize)
+	binary.BigEndian.PutUint16(buf[16:], h.seqNr)
+	binary.BigEndian.PutUint16(buf[18:], h.ackNr)
+	copy(buf[utpHeaderSize:], payload)
+	return buf
+}
+
+// this function parses a datagram, skipping over any extension headers we don't understand
+func parseUTPPacket(b []byte) (utpHeader, []byte, error) {
+	var h utpHeader
+	if len(b) < utpHeaderSize {
+		return h, nil, errors.New("utp: packet too short")
+	}
+	if b[0]&0x0f != utpVersion {
+		return h, nil, fmt.Errorf("utp: unsupported version %d", b[0]&0x0f)
+	}
+	h.typ = b[0] >> 4
+	if h.typ > utpTypeSyn {
+		return h, nil, fmt.Errorf("utp: unknown packet type %d", h.typ)
+	}
+	h.connID = binary.BigEndian.Uint16(b[2:])
+	h.timestamp = binary.BigEndian.Uint32(b[4:])
+	h.timeDiff = binary.BigEndian.Uint32(b[8:])
+	h.wndSize = binary.BigEndian.Uint32(b[12:])
+	h.seqNr = binary.BigEndian.Uint16(b[16:])
+	h.ackNr = binary.BigEndian.Uint16(b[18:])
+
+	offset := utpHeaderSize
+	for ext := b[1]; ext != 0; {
+		if len(b) < offset+2 {
+			return h, nil, errors.New("utp: truncated extension header")
+		}
+		ext = b[offset]
+		offset += 2 + int(b[offset+1])
+		if len(b) < offset {
+			return h, nil, errors.New("utp: truncated extension header")
+		}
+	}
+	return h, b[offset:], nil
+}
+
+// this function returns the current time in the microsecond resolution used on the wire
+func utpNow() uint32 {
+	return uint32(time.Now().UnixMicro())
+}
+
+// seqLess reports whether a comes before b, taking 16 bit wrap around into account
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+func randUint16() uint16 {
+	var buf [2]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint16(buf[:])
+}
+
+type utpConnKey struct {
+	addr   string
+	connID uint16
+}
+
+// UTPSocket multiplexes any number of uTP connections over a single UDP socket. It
+// implements net.Listener for incoming connections and DialContext for outgoing ones
+type UTPSocket struct {
+	pc        net.PacketConn
+	mu        sync.Mutex
+	conns     map[utpConnKey]*utpConn
+	accept    chan *utpConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenUTP opens a UDP socket on addr and starts serving uTP connections on it
+func ListenUTP(addr string) (*UTPSocket, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUTPSocket(pc), nil
+}
+
+// NewUTPSocket serves uTP on an already open packet conn, the socket takes ownership of pc
+func NewUTPSocket(pc net.PacketConn) *UTPSocket {
+	s := &UTPSocket{
+		pc:     pc,
+		conns:  make(map[utpConnKey]*utpConn),
+		accept: make(chan *utpConn, utpAcceptQueue),
+		closed: make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+func (s *UTPSocket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+func (s *UTPSocket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.accept:
+		return c, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close shuts the UDP socket down and fails every connection still using it
+func (s *UTPSocket) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.pc.Close()
+		s.mu.Lock()
+		conns := make([]*utpConn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+		for _, c := range conns {
+			c.fail(net.ErrClosed)
+		}
+	})
+	return err
+}
+
+// DialContext opens an outgoing uTP connection, returning once the peer acknowledged our SYN
+func (s *UTPSocket) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	var key utpConnKey
+	for {
+		key = utpConnKey{addr: raddr.String(), connID: randUint16()}
+		if _, taken := s.conns[key]; !taken {
+			break
+		}
+	}
+	c := newUTPConn(s, raddr, key.connID, key.connID+1)
+	s.conns[key] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	c.state = utpStateSynSent
+	c.seqNr = 1
+	syn := &utpPacket{typ: utpTypeSyn, seqNr: c.seqNr}
+	c.seqNr++
+	c.outbound[syn.seqNr] = syn
+	c.transmit(syn)
+
+	stop := context.AfterFunc(ctx, c.wake)
+	for c.state == utpStateSynSent && c.err == nil && ctx.Err() == nil {
+		c.cond.Wait()
+	}
+	stop()
+	err = c.err
+	if err == nil && c.state != utpStateConnected {
+		err = ctx.Err()
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	go c.loop()
+	return c, nil
+}
+
+func (s *UTPSocket) readLoop() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			s.Close()
+			return
+		}
+		h, payload, err := parseUTPPacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.dispatch(addr, h, append([]byte(nil), payload...))
+	}
+}
+
+// this function routes an incoming packet to its connection, creating one for new SYNs
+func (s *UTPSocket) dispatch(addr net.Addr, h utpHeader, payload []byte) {
+	key := utpConnKey{addr: addr.String(), connID: h.connID}
+	if h.typ == utpTypeSyn {
+		// the SYN carries the initiator's receive id, we receive on the next one up
+		key.connID++
+	}
+
+	s.mu.Lock()
+	c, ok := s.conns[key]
+	if !ok && h.typ == utpTypeSyn {
+		c = newUTPConn(s, addr, key.connID, h.connID)
+		c.state = utpStateConnected
+		c.seqNr = randUint16()
+		c.ackNr = h.seqNr
+		// nothing of ours is acked yet, acks count as duplicates from the one before our first
+		c.lastAck = c.seqNr - 1
+		select {
+		case s.accept <- c:
+			s.conns[key] = c
+			go c.loop()
+		default:
+			// nobody is accepting fast enough, refuse the connection
+			c = nil
+		}
+	}
+	s.mu.Unlock()
+
+	if c == nil {
+		if h.typ != utpTypeReset {
+			s.sendReset(addr, h)
+		}
+		return
+	}
+	c.handlePacket(h, payload)
+}
+
+func (s *UTPSocket) sendReset(addr net.Addr, h utpHeader) {
+	reset := utpHeader{
+		typ:       utpTypeReset,
+		connID:    h.connID,
+		timestamp: utpNow(),
+		seqNr:     randUint16(),
+		ackNr:     h.seqNr,
+	}
+	_, _ = s.pc.WriteTo(reset.marshal(nil), addr)
+}
+
+func (s *UTPSocket) remove(c *utpConn) {
+	s.mu.Lock()
+	key := utpConnKey{addr: c.raddr.String(), connID: c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+	s.mu.Unlock()
+}
+
+type utpState int
+
+const (
+	utpStateIdle utpState = iota
+	utpStateSynSent
+	utpStateConnected
+	utpStateClosed
+)
+
+type utpPacket struct {
+	typ           uint8
+	seqNr         uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+}
+
+type utpConn struct {
+	socket *UTPSocket
+	raddr  net.Addr
+	recvID uint16
+	sendID uint16
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	state utpState
+	err   error
+
+	seqNr uint16
+	ackNr uint16
+
+	// send side
+	outbound   map[uint16]*utpPacket
+	inFlight   int
+	cwnd       float64
+	peerWnd    uint32
+	lastAck    uint16
+	dupAcks    int
+	rtt        time.Duration
+	rttVar     time.Duration
+	rto        time.Duration
+	replyMicro uint32
+
+	// LEDBAT base delay, the minimum delay seen over the current and previous minute
+	delayMin       uint32
+	delayPrevMin   uint32
+	delayBucketEnd time.Time
+
+	// receive side
+	readBuf bytes.Buffer
+	reorder map[uint16][]byte
+	gotFin  bool
+	finSeq  uint16
+	eof     bool
+
+	closing       bool
+	closedAt      time.Time
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readTimer     *time.Timer
+	writeTimer    *time.Timer
+	done          chan struct{}
+	doneOnce      sync.Once
+}
+
+func newUTPConn(s *UTPSocket, raddr net.Addr, recvID, sendID uint16) *utpConn {
+	c := &utpConn{
+		socket:       s,
+		raddr:        raddr,
+		recvID:       recvID,
+		sendID:       sendID,
+		outbound:     make(map[uint16]*utpPacket),
+		reorder:      make(map[uint16][]byte),
+		cwnd:         utpMaxPacket * 2,
+		peerWnd:      utpRecvWindow,
+		rto:          utpInitialRTO,
+		delayMin:     math.MaxUint32,
+		delayPrevMin: math.MaxUint32,
+		done:         make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// this function sends a packet to the peer, the caller must hold c.mu
+func (c *utpConn) transmit(p *utpPacket) {
+	connID := c.sendID
+	if p.typ == utpTypeSyn {
+		connID = c.recvID
+	}
+	h := utpHeader{
+		typ:       p.typ,
+		connID:    connID,
+		timestamp: utpNow(),
+		timeDiff:  c.replyMicro,
+		wndSize:   c.recvWindow(),
+		seqNr:     p.seqNr,
+		ackNr:     c.ackNr,
+	}
+	p.sentAt = time.Now()
+	p.transmissions++
+	_, _ = c.socket.pc.WriteTo(h.marshal(p.payload), c.raddr)
+}
+
+// this function acknowledges everything received so far without consuming a sequence number
+func (c *utpConn) sendState() {
+	c.transmit(&utpPacket{typ: utpTypeState, seqNr: c.seqNr})
+}
+
+func (c *utpConn) recvWindow() uint32 {
+	free := utpRecvWindow - c.readBuf.Len()
+	if free < 0 {
+		return 0
+	}
+	return uint32(free)
+}
+
+// this function is called by the socket for every packet belonging to this connection
+func (c *utpConn) handlePacket(h utpHeader, payload []byte) {
+	if h.typ == utpTypeReset {
+		c.fail(errUTPReset)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == utpStateClosed {
+		return
+	}
+
+	c.peerWnd = h.wndSize
+	c.replyMicro = utpNow() - h.timestamp
+
+	if c.state == utpStateSynSent {
+		if h.typ != utpTypeState {
+			return
+		}
+		// the STATE answering our SYN doesn't consume a sequence number, so the
+		// first data packet from the peer will carry this same seq_nr
+		c.state = utpStateConnected
+		c.ackNr = h.seqNr - 1
+		c.lastAck = h.ackNr
+	}
+
+	// a SYN acknowledges nothing, its ack_nr is zero
+	if h.typ != utpTypeSyn {
+		c.processAck(h.ackNr, h.timeDiff)
+	}
+
+	switch h.typ {
+	case utpTypeData:
+		c.receive(h.seqNr, payload)
+		c.sendState()
+	case utpTypeFin:
+		c.gotFin = true
+		c.finSeq = h.seqNr
+		c.receive(h.seqNr, nil)
+		c.sendState()
+	case utpTypeSyn:
+		// our STATE got lost, the initiator is retrying the SYN
+		c.sendState()
+	}
+	c.cond.Broadcast()
+}
+
+// this function buffers a packet and moves every packet now in order into the read buffer
+func (c *utpConn) receive(seq uint16, payload []byte) {
+	next := c.ackNr + 1
+	if seqLess(seq, next) || seq-next > utpMaxReorder {
+		return
+	}
+	if !(c.gotFin && seq == c.finSeq) {
+		c.reorder[seq] = payload
+	}
+	for {
+		next = c.ackNr + 1
+		if c.gotFin && next == c.finSeq {
+			c.ackNr = next
+			c.eof = true
+			return
+		}
+		p, ok := c.reorder[next]
+		if !ok {
+			return
+		}
+		delete(c.reorder, next)
+		c.ackNr = next
+		c.readBuf.Write(p)
+	}
+}
+
+// this function drops every packet covered by ackNr and feeds the delay sample to LEDBAT
+func (c *utpConn) processAck(ackNr uint16, delay uint32) {
+	now := time.Now()
+	acked := 0
+	for seq, p := range c.outbound {
+		if seqLess(ackNr, seq) {
+			continue
+		}
+		if p.transmissions == 1 {
+			c.updateRTT(now.Sub(p.sentAt))
+		}
+		acked += len(p.payload)
+		c.inFlight -= len(p.payload)
+		delete(c.outbound, seq)
+	}
+
+	if acked > 0 {
+		c.dupAcks = 0
+		if delay != 0 {
+			c.ledbat(acked, delay)
+		}
+	} else if ackNr == c.lastAck && len(c.outbound) > 0 {
+		c.dupAcks++
+		if c.dupAcks == 3 {
+			// fast retransmit the packet the peer keeps asking for
+			if p, ok := c.outbound[ackNr+1]; ok {
+				c.transmit(p)
+				c.cwnd = math.Max(c.cwnd/2, utpMaxPacket)
+			}
+		}
+	}
+	if !seqLess(ackNr, c.lastAck) {
+		c.lastAck = ackNr
+	}
+}
+
+func (c *utpConn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = c.rtt + 4*c.rttVar
+	if c.rto < utpMinRTO {
+		c.rto = utpMinRTO
+	}
+}
+
+// this function adjusts the congestion window from the one way delay the peer measured for
+// our packets. delays are relative to the lowest delay seen recently, which is taken to be
+// the propagation delay with empty queues
+func (c *utpConn) ledbat(bytesAcked int, delay uint32) {
+	now := time.Now()
+	if now.After(c.delayBucketEnd) {
+		c.delayPrevMin = c.delayMin
+		c.delayMin = math.MaxUint32
+		c.delayBucketEnd = now.Add(time.Minute)
+	}
+	if delay < c.delayMin {
+		c.delayMin = delay
+	}
+	base := c.delayMin
+	if c.delayPrevMin < base {
+		base = c.delayPrevMin
+	}
+
+	ourDelay := time.Duration(delay-base) * time.Microsecond
+	offTarget := float64(utpTargetDelay-ourDelay) / float64(utpTargetDelay)
+	windowFactor := float64(bytesAcked) / math.Max(c.cwnd, float64(bytesAcked))
+	c.cwnd += utpMaxCwndIncrease * offTarget * windowFactor
+	c.cwnd = math.Min(math.Max(c.cwnd, utpMaxPacket), utpMaxCwnd)
+}
+
+// this function returns how many bytes may be in flight right now
+func (c *utpConn) window() int {
+	w := int(c.cwnd)
+	if int(c.peerWnd) < w {
+		w = int(c.peerWnd)
+	}
+	if w < utpMaxPacket && c.inFlight == 0 {
+		// always allow a single packet so a zero window gets probed
+		w = utpMaxPacket
+	}
+	return w
+}
+
+// this function runs for the lifetime of the connection, resending timed out packets and
+// tearing the connection down once a close has finished lingering
+func (c *utpConn) loop() {
+	ticker := time.NewTicker(utpTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		c.mu.Lock()
+		c.checkTimeouts()
+		finished := c.closing && (len(c.outbound) == 0 || c.err != nil || time.Since(c.closedAt) > utpLinger)
+		if c.err != nil && !c.closing {
+			c.cond.Broadcast()
+		}
+		c.mu.Unlock()
+
+		if finished {
+			c.finish()
+			return
+		}
+	}
+}
+
+func (c *utpConn) checkTimeouts() {
+	if c.err != nil {
+		return
+	}
+	// the first packet not acked is the one holding the peer up, the ones after it may
+	// well have arrived
+	var first *utpPacket
+	for _, p := range c.outbound {
+		if first == nil || seqLess(p.seqNr, first.seqNr) {
+			first = p
+		}
+	}
+	if first == nil || time.Since(first.sentAt) < c.rto {
+		return
+	}
+	if first.transmissions > utpMaxRetries {
+		c.err = errUTPTimedOut
+		c.cond.Broadcast()
+		return
+	}
+	// a timeout means the path is badly congested, collapse the window to one packet
+	c.cwnd = utpMaxPacket
+	c.rto *= 2
+	c.transmit(first)
+}
+
+// this function tears the connection down immediately with err
+func (c *utpConn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.finish()
+}
+
+func (c *utpConn) finish() {
+	c.doneOnce.Do(func() {
+		c.mu.Lock()
+		c.state = utpStateClosed
+		if c.readTimer != nil {
+			c.readTimer.Stop()
+		}
+		if c.writeTimer != nil {
+			c.writeTimer.Stop()
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		close(c.done)
+		c.socket.remove(c)
+	})
+}
+
+func (c *utpConn) wake() {
+	c.mu.Lock()
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+func (c *utpConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.readBuf.Len() == 0 {
+		switch {
+		case c.eof:
+			return 0, io.EOF
+		case c.closing || c.state == utpStateClosed && c.err == nil:
+			return 0, net.ErrClosed
+		case c.err != nil:
+			return 0, c.err
+		case !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.cond.Wait()
+	}
+	wasFull := c.recvWindow() < utpMaxPacket
+	n, _ := c.readBuf.Read(b)
+	if wasFull && c.recvWindow() >= utpMaxPacket {
+		// let the peer know our window opened up again
+		c.sendState()
+	}
+	return n, nil
+}
+
+func (c *utpConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > utpMaxPayload {
+			n = utpMaxPayload
+		}
+		for c.inFlight+n > c.window() {
+			if err := c.writeErr(); err != nil {
+				return written, err
+			}
+			c.cond.Wait()
+		}
+		if err := c.writeErr(); err != nil {
+			return written, err
+		}
+
+		p := &utpPacket{typ: utpTypeData, seqNr: c.seqNr, payload: append([]byte(nil), b[:n]...)}
+		c.seqNr++
+		c.outbound[p.seqNr] = p
+		c.inFlight += n
+		c.transmit(p)
+		b = b[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (c *utpConn) writeErr() error {
+	switch {
+	case c.closing || c.state == utpStateClosed && c.err == nil:
+		return net.ErrClosed
+	case c.err != nil:
+		return c.err
+	case !c.writeDeadline.IsZero() && !time.Now().Before(c.writeDeadline):
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// Close sends a FIN and returns straight away, the connection keeps lingering in the
+// background until the peer acknowledged everything we sent
+func (c *utpConn) Close() error {
+	c.mu.Lock()
+	if c.closing || c.state == utpStateClosed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	c.closedAt = time.Now()
+	if c.err == nil {
+		fin := &utpPacket{typ: utpTypeFin, seqNr: c.seqNr}
+		c.seqNr++
+		c.outbound[fin.seqNr] = fin
+		c.transmit(fin)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *utpConn) LocalAddr() net.Addr {
+	return c.socket.Addr()
+}
+
+func (c *utpConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *utpConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *utpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.readTimer = c.resetTimer(c.readTimer, t)
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *utpConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.writeTimer = c.resetTimer(c.writeTimer, t)
+	c.cond.Broadcast()
+	return nil
+}
+
+// this function arms a timer that wakes blocked readers and writers once t passes
+func (c *utpConn) resetTimer(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), c.wake)
+}
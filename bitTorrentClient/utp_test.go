@@ -0,0 +1,302 @@
+package bittorrentclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUTPPacket(t *testing.T) {
+	h := utpHeader{typ: utpTypeData, connID: 0x1234, timestamp: 1, timeDiff: 2, wndSize: 3, seqNr: 0xfffe, ackNr: 5}
+	data := h.marshal([]byte("payload"))
+	if len(data) != utpHeaderSize+7 || data[0] != 0x01 {
+		t.Fatalf("marshalled as %x", data)
+	}
+	got, payload, err := parseUTPPacket(data)
+	if err != nil || got != h || string(payload) != "payload" {
+		t.Errorf("parsed back as %+v %q, %v", got, payload, err)
+	}
+
+	// extension headers we don't know are skipped
+	withExt := append([]byte(nil), data[:utpHeaderSize]...)
+	withExt[1] = 2
+	withExt = append(withExt, 1, 4, 0, 0, 0, 0, 0, 2, 0xaa, 0xbb)
+	withExt = append(withExt, "payload"...)
+	if _, payload, err := parseUTPPacket(withExt); err != nil || string(payload) != "payload" {
+		t.Errorf("packet with extensions parsed as %q, %v", payload, err)
+	}
+
+	for name, b := range map[string][]byte{
+		"short":              data[:utpHeaderSize-1],
+		"version 2":          append([]byte{0x02}, data[1:]...),
+		"unknown type":       append([]byte{0x51}, data[1:]...),
+		"truncated ext":      withExt[:utpHeaderSize+1],
+		"ext past the end":   withExt[:utpHeaderSize+5],
+		"second ext missing": withExt[:utpHeaderSize+6],
+	} {
+		if _, _, err := parseUTPPacket(b); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+
+	if !seqLess(0xfffe, 1) || seqLess(1, 0xfffe) || seqLess(5, 5) || !seqLess(4, 5) {
+		t.Error("seqLess doesn't wrap around")
+	}
+}
+
+// lossyConn drops every nth packet written to it
+type lossyConn struct {
+	net.PacketConn
+	n     int64
+	count atomic.Int64
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.count.Add(1)%c.n == 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func newUTPSocket(t *testing.T, dropEvery int64) *UTPSocket {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropEvery > 0 {
+		pc = &lossyConn{PacketConn: pc, n: dropEvery}
+	}
+	s := NewUTPSocket(pc)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// this function sends size random bytes each way between a connection dialed from a and
+// the one b accepts, and checks both ends got them and the close
+func testUTPTransfer(t *testing.T, a, b *UTPSocket, size int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := b.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+	dialed, err := a.DialContext(ctx, b.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-ctx.Done():
+		t.Fatal("connection wasn't accepted")
+	}
+	if conn.RemoteAddr().String() != a.Addr().String() {
+		t.Errorf("accepted from %s, dialed from %s", conn.RemoteAddr(), a.Addr())
+	}
+
+	up, down := make([]byte, size), make([]byte, size)
+	rand.Read(up)
+	rand.Read(down)
+	errs := make(chan error, 2)
+	go func() {
+		_, err := dialed.Write(up)
+		errs <- err
+	}()
+	go func() {
+		_, err := conn.Write(down)
+		errs <- err
+	}()
+	for _, tc := range []struct {
+		c    net.Conn
+		want []byte
+	}{{conn, up}, {dialed, down}} {
+		tc.c.SetReadDeadline(time.Now().Add(30 * time.Second))
+		got := make([]byte, len(tc.want))
+		if _, err := io.ReadFull(tc.c, got); err != nil {
+			t.Fatalf("reading from %s: %v", tc.c.RemoteAddr(), err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("bytes read from %s aren't the ones sent", tc.c.RemoteAddr())
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the FIN reaches the other end as EOF
+	dialed.Close()
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("read %d bytes, %v after the other end closed", n, err)
+	}
+	conn.Close()
+}
+
+func TestUTPTransfer(t *testing.T) {
+	testUTPTransfer(t, newUTPSocket(t, 0), newUTPSocket(t, 0), 1<<20)
+}
+
+func TestUTPLoss(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for retransmissions")
+	}
+	// the lost packets are sent again whichever way they went
+	testUTPTransfer(t, newUTPSocket(t, 23), newUTPSocket(t, 19), 256<<10)
+}
+
+// recordingConn keeps the packets written to it and reads nothing until it is closed
+type recordingConn struct {
+	net.PacketConn
+	mu      sync.Mutex
+	written []utpHeader
+	closed  chan struct{}
+}
+
+func (c *recordingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	h, _, err := parseUTPPacket(b)
+	if err == nil {
+		c.mu.Lock()
+		c.written = append(c.written, h)
+		c.mu.Unlock()
+	}
+	return len(b), nil
+}
+
+func (c *recordingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	<-c.closed
+	return 0, nil, net.ErrClosed
+}
+
+func (c *recordingConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *recordingConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+}
+
+// this function counts the data packets with seq written
+func (c *recordingConn) sent(seq uint16) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, h := range c.written {
+		if h.typ == utpTypeData && h.seqNr == seq {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUTPRetransmit(t *testing.T) {
+	pc := &recordingConn{closed: make(chan struct{})}
+	s := NewUTPSocket(pc)
+	defer s.Close()
+
+	// our sequence numbers are random, the acks must be counted whichever half of the
+	// range they start in
+	var c *utpConn
+	for port := 1000; c == nil || c.seqNr < 0x8000; port++ {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: port}
+		s.dispatch(addr, utpHeader{typ: utpTypeSyn, connID: 10, wndSize: utpRecvWindow, seqNr: 1}, nil)
+		s.mu.Lock()
+		c = s.conns[utpConnKey{addr: addr.String(), connID: 11}]
+		s.mu.Unlock()
+	}
+	first := c.seqNr
+	for i := 0; i < 2; i++ {
+		if _, err := c.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the peer got the first packet and keeps asking for the second
+	for i := 0; i < 4; i++ {
+		c.handlePacket(utpHeader{typ: utpTypeState, connID: 11, wndSize: utpRecvWindow, seqNr: 2, ackNr: first}, nil)
+	}
+	if n := pc.sent(first + 1); n != 2 {
+		t.Errorf("packet sent %d times after three duplicate acks, want a fast retransmit", n)
+	}
+
+	// a timeout sends the first packet not acked again, even when a later one went out
+	// longer ago
+	if _, err := c.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	c.outbound[first+1].sentAt = time.Now().Add(-2 * c.rto)
+	c.outbound[first+2].sentAt = time.Now().Add(-3 * c.rto)
+	c.checkTimeouts()
+	c.mu.Unlock()
+	if n := pc.sent(first + 1); n != 3 {
+		t.Errorf("first packet not acked sent %d times after a timeout, want 3", n)
+	}
+	if n := pc.sent(first + 2); n != 1 {
+		t.Errorf("later packet sent %d times after a timeout, want once", n)
+	}
+}
+
+func TestUTPFailures(t *testing.T) {
+	s := newUTPSocket(t, 0)
+
+	// a packet for a connection nobody has is answered with a reset
+	raw, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	data := utpHeader{typ: utpTypeData, connID: 77, seqNr: 9}.marshal([]byte("x"))
+	if _, err := raw.WriteTo(data, s.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := raw.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _, err := parseUTPPacket(buf[:n]); err != nil || h.typ != utpTypeReset || h.connID != 77 || h.ackNr != 9 {
+		t.Errorf("answered with %+v, %v", h, err)
+	}
+
+	// nothing answers on raw, so the dial gives up with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := s.DialContext(ctx, raw.LocalAddr().String()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dial to a silent address: %v", err)
+	}
+
+	// a read past its deadline fails with os.ErrDeadlineExceeded, and the socket closing
+	// fails the connections on it
+	other := newUTPSocket(t, 0)
+	go other.Accept()
+	c, err := s.DialContext(context.Background(), other.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read past the deadline: %v", err)
+	}
+	c.SetReadDeadline(time.Time{})
+	s.Close()
+	if _, err := c.Read(buf); err == nil {
+		t.Error("read from a connection of a closed socket")
+	}
+	if _, err := s.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("accept on a closed socket: %v", err)
+	}
+}
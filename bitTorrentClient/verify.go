@@ -0,0 +1,148 @@
+// This file implements a full recheck. Every piece is read back from disk and hashed, and
+// the set of pieces we have is rebuilt from the result, which is what to do after a crash
+// or when pointing a download at data that came from somewhere else
+package bittorrentclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Verify rechecks every piece on disk and rebuilds the set of pieces we have from it.
+// Progress is reported with EventVerifyProgress events. A running download is paused for
+// the check and resumed afterwards, downloading only what turned out to be missing
+func (d *Download) Verify(ctx context.Context) error {
+	state := d.State()
+	if state == DownloadChecking {
+		return errors.New("download is already being checked")
+	}
+	running := state == DownloadDownloading || state == DownloadSeeding
+	if running {
+		d.halt(DownloadPaused)
+	}
+
+	d.mu.Lock()
+	err := d.openStorage()
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	storage := d.storage
+	d.setState(DownloadChecking)
+	d.mu.Unlock()
+
+	have, err := d.checkPieces(ctx, storage, nil)
+	// whatever was cached may not be what's on disk anymore
+	d.readCache().invalidate(d.InfoHash)
+
+	d.mu.Lock()
+	if err == nil {
+		d.have = have
+		d.picker.SetHave(have)
+		d.finishFiles(0, d.Torrent.NumPieces()-1)
+		if !d.complete() {
+			d.closeDone()
+			d.done = make(chan struct{})
+		}
+	}
+	if running {
+		// Start below moves us on to downloading or seeding
+		d.setState(DownloadPaused)
+	} else {
+		d.setState(state)
+	}
+	d.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	_ = d.autosaveResume()
+	if running {
+		return d.Start()
+	}
+	return nil
+}
+
+// this function hashes the pieces in storage that want returns true for, every piece when
+// want is nil, on the hasher and returns the ones that match, reporting progress as it goes
+func (d *Download) checkPieces(ctx context.Context, storage TorrentStorage, want func(index int) bool) (Bitfield, error) {
+	d.mu.Lock()
+	hasher := d.hasher
+	d.mu.Unlock()
+	numPieces := d.Torrent.NumPieces()
+	have := NewBitfield(numPieces)
+	toCheck := numPieces
+	if want != nil {
+		toCheck = 0
+		for index := 0; index < numPieces; index++ {
+			if want(index) {
+				toCheck++
+			}
+		}
+	}
+
+	checked := 0
+	lastPercent := -1
+	err := checkStoredPieces(ctx, d.Torrent, storage, hasher, want, func(index int, ok, missing bool) {
+		if ok {
+			have.SetPiece(index)
+		}
+		checked++
+		percent := checked * 100 / toCheck
+		if percent != lastPercent {
+			lastPercent = percent
+			d.emit(Event{Type: EventVerifyProgress, Piece: index, Progress: float64(checked) / float64(toCheck)})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return have, nil
+}
+
+// this function hashes every piece of t in storage that want returns true for, or all of
+// them when want is nil, on the hasher and calls done with each result, missing is set for pieces that couldn't be read in full. pieces are read here and
+// hashed by the workers, the hasher's bounded queue keeps the reads from running ahead of
+// the hashing. done runs on the workers, in whatever order the pieces finish, but never
+// concurrently with itself
+func checkStoredPieces(ctx context.Context, t *Torrent, storage TorrentStorage, hasher *Hasher, want func(index int) bool, done func(index int, ok, missing bool)) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	report := func(index int, ok, missing bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		done(index, ok, missing)
+	}
+
+	var err error
+	for index := 0; index < t.NumPieces(); index++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if want != nil && !want(index) {
+			continue
+		}
+		data := getBuffer(t.PieceSize(index))
+		// short reads are missing data, the piece just doesn't match
+		n, _ := storage.ReadPieceAt(index, data, 0)
+		if n != len(data) {
+			putBuffer(data)
+			report(index, false, true)
+			continue
+		}
+		wg.Add(1)
+		err = hasher.SubmitPiece(ctx, t, index, data, func(ok bool) {
+			defer wg.Done()
+			putBuffer(data)
+			report(index, ok, false)
+		})
+		if err != nil {
+			wg.Done()
+			putBuffer(data)
+			break
+		}
+	}
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,175 @@
+// This file checks data that is already on disk against a torrent without starting a
+// download, e.g. to audit an archive. Every piece is hashed and the results are reported per
+// piece and per file, as text or as JSON. Nothing is created or written, the files are only
+// opened for reading
+package bittorrentclient
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PieceCheck is the result for one piece
+type PieceCheck struct {
+	Index int  `json:"index"`
+	OK    bool `json:"ok"`
+	// Missing is set when the piece couldn't be read in full, its files are missing or short
+	Missing bool `json:"missing,omitempty"`
+}
+
+// FileCheck is the result for one file, padding files are left out
+type FileCheck struct {
+	// Index is the file's index in the torrent's file list
+	Index int `json:"index"`
+	// Path is relative to the directory that was checked
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+	// Verified counts the file's bytes that lie in pieces that matched
+	Verified int64 `json:"verified"`
+	// Exists is false when there is no file at all, Size is what is on disk otherwise
+	Exists bool  `json:"exists"`
+	Size   int64 `json:"size"`
+	// BadPieces are the file's pieces that didn't match or couldn't be read
+	BadPieces []int `json:"bad_pieces,omitempty"`
+}
+
+// OK reports whether the file is there and every byte of it was verified
+func (f FileCheck) OK() bool {
+	return f.Exists && f.Verified == f.Length
+}
+
+// VerifyReport is the outcome of VerifyData
+type VerifyReport struct {
+	Name       string       `json:"name"`
+	InfoHash   string       `json:"info_hash"`
+	Dir        string       `json:"dir"`
+	GoodPieces int          `json:"good_pieces"`
+	Pieces     []PieceCheck `json:"pieces"`
+	Files      []FileCheck  `json:"files"`
+}
+
+// OK reports whether every piece matched and every file is there
+func (r *VerifyReport) OK() bool {
+	for _, f := range r.Files {
+		if !f.OK() {
+			return false
+		}
+	}
+	return r.GoodPieces == len(r.Pieces)
+}
+
+// VerifyData hashes the data of t found under dir, laid out the way a download of t into
+// dir would save it, and reports which pieces and files are intact. Pieces are hashed on the
+// shared hasher. Only ctx being done or a torrent that can't be laid out under dir make it
+// fail, missing and damaged data end up in the report
+func VerifyData(ctx context.Context, t *Torrent, dir string) (*VerifyReport, error) {
+	err := t.validate()
+	if err != nil {
+		return nil, err
+	}
+	storage, err := openReadOnlyFileStorage(t, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	report := &VerifyReport{
+		Name:     t.Info.Name,
+		InfoHash: hex.EncodeToString(t.InfoHash[:]),
+		Dir:      dir,
+		Pieces:   make([]PieceCheck, t.NumPieces()),
+	}
+	have := NewBitfield(t.NumPieces())
+	err = checkStoredPieces(ctx, t, storage, defaultHasher(), nil, func(index int, ok, missing bool) {
+		report.Pieces[index] = PieceCheck{Index: index, OK: ok, Missing: missing}
+		if ok {
+			have.SetPiece(index)
+			report.GoodPieces++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range t.files() {
+		if f.Padding {
+			continue
+		}
+		fc := FileCheck{
+			Index:    i,
+			Path:     t.relativePath(f),
+			Length:   f.Length,
+			Verified: t.fileCompleted(f, have),
+		}
+		if info, err := os.Stat(storage.files[i].path); err == nil {
+			fc.Exists = true
+			fc.Size = info.Size()
+		}
+		if first, last, ok := t.filePieces(f); ok {
+			for index := first; index <= last; index++ {
+				if !have.HasPiece(index) {
+					fc.BadPieces = append(fc.BadPieces, index)
+				}
+			}
+		}
+		report.Files = append(report.Files, fc)
+	}
+	return report, nil
+}
+
+// WriteText writes the report for people: a summary, then a line per file with the pieces
+// of damaged files
+func (r *VerifyReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n", r.Name, r.InfoHash)
+	fmt.Fprintf(&b, "%d of %d pieces ok\n", r.GoodPieces, len(r.Pieces))
+	for _, f := range r.Files {
+		switch {
+		case f.OK():
+			fmt.Fprintf(&b, "ok       %s\n", f.Path)
+		case !f.Exists:
+			fmt.Fprintf(&b, "missing  %s\n", f.Path)
+		default:
+			fmt.Fprintf(&b, "damaged  %s (%.1f%% verified", f.Path, 100*float64(f.Verified)/float64(f.Length))
+			if f.Size != f.Length {
+				fmt.Fprintf(&b, ", %d of %d bytes on disk", f.Size, f.Length)
+			}
+			fmt.Fprintf(&b, ")\n         bad pieces: %s\n", pieceRanges(f.BadPieces))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *VerifyReport) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// this function formats sorted piece indexes compactly, runs as first-last
+func pieceRanges(pieces []int) string {
+	var parts []string
+	for i := 0; i < len(pieces); {
+		j := i
+		for j+1 < len(pieces) && pieces[j+1] == pieces[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprint(pieces[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", pieces[i], pieces[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, " ")
+}
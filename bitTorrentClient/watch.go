@@ -0,0 +1,243 @@
+// This file adds torrents dropped into watch folders, the way seedboxes are fed: a .torrent
+// file, or a .magnet file holding a magnet link, saved into a watched directory is added to
+// the client with the folder's save directory and labels. A file is only taken once it
+// stopped changing between two scans, so one still being written isn't read half way.
+// Consumed files are moved to the folder's done directory, or renamed with ".added"
+// appended, and files that aren't torrents get ".invalid" so they aren't tried again
+package bittorrentclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often a Watcher scans its folders
+const DefaultWatchInterval = 2 * time.Second
+
+// the suffixes consumed files are renamed with
+const (
+	watchAddedSuffix   = ".added"
+	watchInvalidSuffix = ".invalid"
+)
+
+// WatchFolder is a directory torrents are added from. Its subdirectories aren't watched
+type WatchFolder struct {
+	Dir string
+	// SaveDir is where the folder's torrents are saved, empty for the client's directory
+	SaveDir string
+	// Labels are given to the folder's torrents
+	Labels []string
+	// DoneDir is where consumed files are moved to, empty renames them in Dir instead
+	DoneDir string
+}
+
+// Watcher adds the torrents saved into its folders to a client, see Run
+type Watcher struct {
+	// Interval is the time between scans, DefaultWatchInterval unless changed before Run
+	Interval time.Duration
+
+	client  *Client
+	folders []WatchFolder
+	logger  *slog.Logger
+	// seen holds the size and modification time of the files found on the last scan
+	seen map[string]fileStamp
+	// magnets are the links still fetching their metadata
+	magnets sync.WaitGroup
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewWatcher returns a watcher adding the torrents in folders to c, which have to be
+// directories
+func NewWatcher(c *Client, folders []WatchFolder) (*Watcher, error) {
+	for _, f := range folders {
+		info, err := os.Stat(f.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("watch folder: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("watch folder %s is not a directory", f.Dir)
+		}
+	}
+	return &Watcher{
+		Interval: DefaultWatchInterval,
+		client:   c,
+		folders:  folders,
+		logger:   c.baseLogger.With("component", LogWatch),
+		seen:     make(map[string]fileStamp),
+	}, nil
+}
+
+// Run scans the folders until ctx is done, errors along the way are logged. Magnet links
+// are fetched in the background and given up on with ctx, Run returns once they are
+func (w *Watcher) Run(ctx context.Context) {
+	defer w.magnets.Wait()
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		w.Scan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan looks through the folders once and adds the files that stopped changing since the
+// previous scan
+func (w *Watcher) Scan(ctx context.Context) {
+	found := make(map[string]fileStamp)
+	for _, f := range w.folders {
+		entries, err := os.ReadDir(f.Dir)
+		if err != nil {
+			w.logger.Warn("reading watch folder failed", "dir", f.Dir, "err", err)
+			continue
+		}
+		for _, entry := range entries {
+			kind := watchFileKind(entry.Name())
+			if kind == "" || !entry.Type().IsRegular() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(f.Dir, entry.Name())
+			stamp := fileStamp{size: info.Size(), modTime: info.ModTime()}
+			if prev, ok := w.seen[path]; !ok || prev != stamp || stamp.size == 0 {
+				found[path] = stamp
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			w.take(ctx, f, path, kind)
+		}
+	}
+	w.seen = found
+}
+
+// this function returns "torrent" or "magnet" for the files the watcher takes, hidden
+// files are left alone as editors and downloads write to those first
+func watchFileKind(name string) string {
+	if strings.HasPrefix(name, ".") {
+		return ""
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".torrent":
+		return "torrent"
+	case ".magnet":
+		return "magnet"
+	}
+	return ""
+}
+
+// this function adds the file at path and moves it out of the way
+func (w *Watcher) take(ctx context.Context, f WatchFolder, path, kind string) {
+	opts := []AddOption{WithSaveDir(f.SaveDir), WithLabels(f.Labels...)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		w.logger.Warn("reading watched file failed", "file", path, "err", err)
+		return
+	}
+	switch kind {
+	case "torrent":
+		t, err := DecodeTorrent(bytes.NewReader(data))
+		if err != nil {
+			w.reject(f, path, err)
+			return
+		}
+		d, err := w.client.AddTorrent(t, opts...)
+		switch {
+		case errors.Is(err, ErrClientClosed):
+			return
+		case d == nil && err != nil && !errors.Is(err, ErrTorrentExists):
+			w.reject(f, path, err)
+			return
+		case errors.Is(err, ErrTorrentExists):
+			w.logger.Info("watched torrent is already added", "file", path)
+		default:
+			w.logger.Info("added watched torrent", "file", path, "name", t.Info.Name)
+		}
+	case "magnet":
+		uri := magnetFileLink(data)
+		m, err := ParseMagnet(uri)
+		if err != nil {
+			w.reject(f, path, err)
+			return
+		}
+		if _, ok := w.client.Torrent(m.InfoHash); ok {
+			w.logger.Info("watched magnet link is already added", "file", path)
+			break
+		}
+		w.magnets.Add(1)
+		go func() {
+			defer w.magnets.Done()
+			// a failure is logged and published by the client
+			w.client.AddMagnet(ctx, uri, opts...)
+		}()
+		w.logger.Info("fetching watched magnet link", "file", path, "infohash", fmt.Sprintf("%x", m.InfoHash))
+	}
+	w.consume(f, path, watchAddedSuffix)
+}
+
+// this function returns the magnet link in a .magnet file, its first line that isn't
+// blank or a # comment
+func magnetFileLink(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
+	}
+	return ""
+}
+
+// this function logs why the file at path isn't a torrent and renames it aside
+func (w *Watcher) reject(f WatchFolder, path string, err error) {
+	w.logger.Warn("watched file is not a torrent", "file", path, "err", err)
+	// a rejected file stays in the folder, where whoever dropped it will look
+	w.consume(WatchFolder{Dir: f.Dir}, path, watchInvalidSuffix)
+}
+
+// this function moves the file at path to the folder's done directory, or renames it in
+// place with suffix, without overwriting a file of the same name
+func (w *Watcher) consume(f WatchFolder, path, suffix string) {
+	dir, name := f.DoneDir, filepath.Base(path)
+	if dir == "" {
+		dir, name = filepath.Dir(path), name+suffix
+	}
+	target := filepath.Join(dir, name)
+	for n := 1; ; n++ {
+		_, err := os.Lstat(target)
+		if err != nil {
+			break
+		}
+		target = filepath.Join(dir, name+"."+strconv.Itoa(n))
+	}
+	err := os.Rename(path, target)
+	if err != nil && f.DoneDir != "" {
+		// most likely the done directory is on another filesystem
+		w.logger.Warn("moving watched file failed, renaming it in place", "file", path, "err", err)
+		w.consume(WatchFolder{Dir: f.Dir}, path, suffix)
+		return
+	}
+	if err != nil {
+		w.logger.Warn("renaming watched file failed", "file", path, "err", err)
+	}
+}